@@ -11,20 +11,36 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
+	// quiet hours of users are in their timezones, which the alpine image has no database of
+	_ "time/tzdata"
 
 	"github.com/gerladeno/homie-core/pkg/chat"
 
 	"github.com/gerladeno/homie-core/internal"
+	"github.com/gerladeno/homie-core/internal/models"
 	"github.com/gerladeno/homie-core/internal/rest"
 	"github.com/gerladeno/homie-core/internal/storage"
+	"github.com/gerladeno/homie-core/pkg/blob"
+	"github.com/gerladeno/homie-core/pkg/common"
 	"github.com/gerladeno/homie-core/pkg/logging"
+	"github.com/gerladeno/homie-core/pkg/metrics"
+	"github.com/gerladeno/homie-core/pkg/notify"
+	"github.com/gerladeno/homie-core/pkg/textfilter"
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/sirupsen/logrus"
 )
 
-const httpPort = 3001
+const (
+	httpPort = 3001
+	// defaultDBBreakerCooldown is how long queries fail fast after the datastore breaker opens.
+	defaultDBBreakerCooldown = 30 * time.Second
+	// prewarmTimeout bounds pre-warming, the server starts cold past it.
+	prewarmTimeout = 30 * time.Second
+)
 
 //go:embed public.pub
 var publicSigningKey []byte
@@ -32,36 +48,905 @@ var publicSigningKey []byte
 var (
 	version = `0.0.0`
 	pgDSN   = os.Getenv("PG_DSN")
-	domain  = os.Getenv("APP_DOMAIN")
+	// DB_BREAKER_THRESHOLD consecutive datastore failures make queries fail fast for DB_BREAKER_COOLDOWN
+	// (30s by default), the breaker is disabled when empty or zero.
+	dbBreakerThreshold = os.Getenv("DB_BREAKER_THRESHOLD")
+	dbBreakerCooldown  = os.Getenv("DB_BREAKER_COOLDOWN")
+	domain             = os.Getenv("APP_DOMAIN")
+	minAge             = os.Getenv("MIN_AGE")
+	// MAX_ACTIVE_MATCHES is unlimited when empty or zero.
+	maxActiveMatches = os.Getenv("MAX_ACTIVE_MATCHES")
+	// MAX_REGIONS is the number of regions a user can search in, 20 when empty, unlimited when zero.
+	maxRegions = os.Getenv("MAX_REGIONS")
+	// MAX_BUDGET is the ceiling of budgets of search criteria, 1e8 when empty, unlimited when zero.
+	maxBudget = os.Getenv("MAX_BUDGET")
+	// MAX_PHOTOS is the number of photos a user can upload, 6 when empty, unlimited when zero.
+	maxPhotos = os.Getenv("MAX_PHOTOS")
+	// MAX_BIO_LENGTH is the number of characters of bios, 1000 when empty, unlimited when zero.
+	maxBioLength      = os.Getenv("MAX_BIO_LENGTH")
+	chatAutoUnarchive = os.Getenv("CHAT_AUTO_UNARCHIVE")
+	chatRequireMatch  = os.Getenv("CHAT_REQUIRE_MATCH")
+	// REQUIRE_PHOTO=true keeps users without photos from browsing the feed.
+	requirePhoto = os.Getenv("REQUIRE_PHOTO")
+	// USERNAME_MAX_LENGTH is the number of characters of usernames, 50 when empty, unlimited when zero.
+	// USERNAME_ASCII_ONLY=true allows printable ASCII only, USERNAME_NFC=false saves usernames unnormalized.
+	usernameMaxLength = os.Getenv("USERNAME_MAX_LENGTH")
+	usernameASCIIOnly = os.Getenv("USERNAME_ASCII_ONLY")
+	usernameNFC       = os.Getenv("USERNAME_NFC")
+	// REGIONS_CACHE_TTL is a duration like 10m the region list is cached for, zero disables caching.
+	regionsCacheTTL = os.Getenv("REGIONS_CACHE_TTL")
+	// BANS_CACHE_TTL is a duration like 30s banned identities are cached for, zero disables caching.
+	bansCacheTTL = os.Getenv("BANS_CACHE_TTL")
+	// REVOCATIONS_CACHE_TTL is a duration like 10s revoked tokens are cached for, zero disables caching.
+	revocationsCacheTTL = os.Getenv("REVOCATIONS_CACHE_TTL")
+	// CONFIG_EDIT_COOLDOWN is a duration like 1m a user waits between config edits, zero disables the limit.
+	configEditCooldown = os.Getenv("CONFIG_EDIT_COOLDOWN")
+	// CHAT_HISTORY_REPLAY=true sends recent messages to new chat connections, up to CHAT_HISTORY_REPLAY_LIMIT.
+	chatHistoryReplay      = os.Getenv("CHAT_HISTORY_REPLAY")
+	chatHistoryReplayLimit = os.Getenv("CHAT_HISTORY_REPLAY_LIMIT")
+	// CHAT_PING_INTERVAL and CHAT_PONG_WAIT are durations like 30s, a connection not answering pings
+	// within CHAT_PONG_WAIT is closed.
+	chatPingInterval = os.Getenv("CHAT_PING_INTERVAL")
+	chatPongWait     = os.Getenv("CHAT_PONG_WAIT")
+	// CHAT_MAX_CONNECTION_LIFETIME is a duration like 1h chat connections are closed after for clients to reconnect,
+	// unlimited when empty.
+	chatMaxConnectionLifetime = os.Getenv("CHAT_MAX_CONNECTION_LIFETIME")
+	// CHAT_MAX_FRAME_SIZE is the size in bytes of the largest frame read from chat connections, 512 by default.
+	chatMaxFrameSize = os.Getenv("CHAT_MAX_FRAME_SIZE")
+	// CHAT_EDIT_WINDOW is how long after sending a message it may be edited, 15m by default.
+	chatEditWindow = os.Getenv("CHAT_EDIT_WINDOW")
+	// CHAT_TYPING_TIMEOUT is how long after the last typing of a user the peer is told they stopped, 5s by default.
+	chatTypingTimeout = os.Getenv("CHAT_TYPING_TIMEOUT")
+	// CHAT_REACTIONS is a comma separated allowlist of emoji messages may be reacted to with.
+	chatReactions = os.Getenv("CHAT_REACTIONS")
+	// CHAT_ATTACHMENT_SCHEMES and CHAT_ATTACHMENT_TYPES are comma separated allowlists of link schemes and MIME
+	// types of files messages attach, https and common image types by default.
+	chatAttachmentSchemes = os.Getenv("CHAT_ATTACHMENT_SCHEMES")
+	chatAttachmentTypes   = os.Getenv("CHAT_ATTACHMENT_TYPES")
+	// CHAT_MAX_EMPTY_CHATS is the number of chats without messages a user keeps, older ones are evicted on opening
+	// a chat, all of them are kept when empty. It needs CHAT_MAX_CONNECTION_LIFETIME.
+	chatMaxEmptyChats = os.Getenv("CHAT_MAX_EMPTY_CHATS")
+	// CHAT_SESSIONS is either multiple, letting users chat from several connections at once, which is the default,
+	// or single, closing older connections of a user once they connect again.
+	chatSessions      = os.Getenv("CHAT_SESSIONS")
+	distancePrecision = os.Getenv("DISTANCE_PRECISION_KM")
+	// PUBLIC_PROFILE_FIELDS is a comma separated allowlist of profile fields shown to other users.
+	publicProfileFields = os.Getenv("PUBLIC_PROFILE_FIELDS")
+	// TITLE_CASE_FIELDS is a comma separated list of username and bio, title-cased on saves.
+	titleCaseFields = os.Getenv("TITLE_CASE_FIELDS")
+	compressMinSize = os.Getenv("COMPRESS_MIN_SIZE")
+	// COMPRESS_EXCLUDED_TYPES is a comma separated list of media types never compressed, replacing
+	// the default image/jpeg, image/png, image/webp and application/zip.
+	compressExcludedTypes = os.Getenv("COMPRESS_EXCLUDED_TYPES")
+	maxMatchesCount       = os.Getenv("MAX_MATCHES_COUNT")
+	// MAX_STREAM_MATCHES_COUNT caps the count of streamed matches, 1000 by default.
+	maxStreamMatchesCount = os.Getenv("MAX_STREAM_MATCHES_COUNT")
+	// ACCESS_LOG_SAMPLE logs 1 in that many requests, every one by default, but those answered with
+	// ACCESS_LOG_ALWAYS_STATUS (400 by default) or above or taking longer than ACCESS_LOG_ALWAYS_SLOWER
+	// (1s by default, 0 to sample them too) are always logged.
+	accessLogSample       = os.Getenv("ACCESS_LOG_SAMPLE")
+	accessLogAlwaysStatus = os.Getenv("ACCESS_LOG_ALWAYS_STATUS")
+	accessLogAlwaysSlower = os.Getenv("ACCESS_LOG_ALWAYS_SLOWER")
+	// REQUEST_TIMEOUT is a duration like 10s requests are canceled after, 30s by default.
+	requestTimeout = os.Getenv("REQUEST_TIMEOUT")
+	// IP_RATE_LIMIT is the count of requests a second a client IP may make to unauthenticated routes on average,
+	// 10 by default, 0 disables limiting. IP_RATE_BURST is how many it may make at once, 20 by default.
+	ipRateLimit = os.Getenv("IP_RATE_LIMIT")
+	ipRateBurst = os.Getenv("IP_RATE_BURST")
+	// MAX_IN_FLIGHT_WRITES is the count of writes, likes and dislikes a user may have in flight at once,
+	// 10 by default, 0 disables limiting.
+	maxInFlightWrites = os.Getenv("MAX_IN_FLIGHT_WRITES")
+	// TRUSTED_PROXIES are comma separated CIDRs or IPs of proxies the client IP is taken from
+	// the X-Forwarded-For and X-Real-IP headers of requests of. None by default.
+	trustedProxies = os.Getenv("TRUSTED_PROXIES")
+	// ANONYMIZE_IPS=true zeroes the last octet of IPv4 and all but the first 48 bits of IPv6 client IPs
+	// in the access log and metrics, IP_RATE_LIMIT applies to anonymized IPs too unless
+	// ANONYMIZE_IPS_KEEP_FULL=true.
+	anonymizeIPs         = os.Getenv("ANONYMIZE_IPS")
+	anonymizeIPsKeepFull = os.Getenv("ANONYMIZE_IPS_KEEP_FULL")
+	// REQUIRE_REQUEST_ID=true rejects requests without X-Request-Id instead of generating one,
+	// but to /ping, /version and /openapi.json.
+	requireRequestID = os.Getenv("REQUIRE_REQUEST_ID")
+	// PRETTY_RESPONSES=true indents all JSON responses and PRETTY_RESPONSES_QUERY=true those requested
+	// with ?pretty=true, for development only.
+	prettyResponses      = os.Getenv("PRETTY_RESPONSES")
+	prettyResponsesQuery = os.Getenv("PRETTY_RESPONSES_QUERY")
+	// SECURITY_CONTENT_TYPE_OPTIONS, SECURITY_FRAME_OPTIONS, SECURITY_REFERRER_POLICY and SECURITY_CSP replace
+	// the defaults of X-Content-Type-Options, X-Frame-Options, Referrer-Policy and Content-Security-Policy,
+	// off leaves the header out. HSTS_MAX_AGE is a duration like 8760h Strict-Transport-Security is sent with,
+	// not sent when empty, HSTS_INCLUDE_SUBDOMAINS=true applies it to subdomains.
+	securityContentTypeOptions = os.Getenv("SECURITY_CONTENT_TYPE_OPTIONS")
+	securityFrameOptions       = os.Getenv("SECURITY_FRAME_OPTIONS")
+	securityReferrerPolicy     = os.Getenv("SECURITY_REFERRER_POLICY")
+	securityCSP                = os.Getenv("SECURITY_CSP")
+	hstsMaxAge                 = os.Getenv("HSTS_MAX_AGE")
+	hstsIncludeSubdomains      = os.Getenv("HSTS_INCLUDE_SUBDOMAINS")
+	// HTTP_READ_HEADER_TIMEOUT (5s by default) and HTTP_READ_TIMEOUT (30s by default) are durations clients
+	// may take to send request headers and whole requests, HTTP_MAX_HEADER_BYTES caps headers, 65536 by default.
+	httpReadHeaderTimeout = os.Getenv("HTTP_READ_HEADER_TIMEOUT")
+	httpReadTimeout       = os.Getenv("HTTP_READ_TIMEOUT")
+	httpMaxHeaderBytes    = os.Getenv("HTTP_MAX_HEADER_BYTES")
+	// TEXT_FILTER_WORDS is a comma separated list of banned words overriding the default one.
+	textFilterWords    = os.Getenv("TEXT_FILTER_WORDS")
+	textFilterDisabled = os.Getenv("TEXT_FILTER_DISABLED")
+	jwtAudience        = os.Getenv("JWT_AUDIENCE")
+	// METRICS_ADDR is an address like :9090 Prometheus metrics are served on at /metrics, apart from the API
+	// port so that they stay internal. Metrics aren't served when empty.
+	metricsAddr = os.Getenv("METRICS_ADDR")
+	adminToken  = os.Getenv("ADMIN_TOKEN")
+	// IMPERSONATION_KEY of at least 32 bytes enables admin impersonation, IMPERSONATION_TTL is a duration
+	// like 15m the tokens expire after.
+	impersonationKey = os.Getenv("IMPERSONATION_KEY")
+	impersonationTTL = os.Getenv("IMPERSONATION_TTL")
+	// FEATURES_DISABLED is a comma separated list of features like boost whose routes respond 404.
+	featuresDisabled = os.Getenv("FEATURES_DISABLED")
+	// JWT_LEEWAY is a duration like 30s, JWT_REQUIRED_CLAIMS is a comma separated list of claim names.
+	jwtLeeway         = os.Getenv("JWT_LEEWAY")
+	jwtRequiredClaims = os.Getenv("JWT_REQUIRED_CLAIMS")
+	feedDefaultSort   = os.Getenv("FEED_DEFAULT_SORT")
+	// MATCH_STRATEGY is one of strict (the default), balanced or exploratory, see internal.MatchStrategy.
+	matchStrategy = os.Getenv("MATCH_STRATEGY")
+	// SUPER_LIKES_FIRST=true puts users who super liked someone at the front of their feed.
+	superLikesFirst = os.Getenv("SUPER_LIKES_FIRST")
+	// FEED_SHUFFLE=true shuffles best sorted candidates of the same tier per user and day.
+	feedShuffle = os.Getenv("FEED_SHUFFLE")
+	// MESSAGE_RETENTION is a positive duration like 720h chat messages are kept for, forever when empty.
+	messageRetention = os.Getenv("MESSAGE_RETENTION")
+	// PREWARM=true loads the regions cache before the server starts accepting requests.
+	prewarm = os.Getenv("PREWARM")
+	// BOOST_MAX_DURATION (1h by default) and BOOST_COOLDOWN (24h by default) are durations like 30m.
+	boostMaxDuration = os.Getenv("BOOST_MAX_DURATION")
+	boostCooldown    = os.Getenv("BOOST_COOLDOWN")
+	// MAX_PENDING_LIKES is unlimited when empty or zero, PENDING_LIKES_WINDOW is a duration likes expire after.
+	maxPendingLikes    = os.Getenv("MAX_PENDING_LIKES")
+	pendingLikesWindow = os.Getenv("PENDING_LIKES_WINDOW")
+	// MAX_SWIPES likes and dislikes within SWIPE_WINDOW (10s by default) make a user cool down
+	// for SWIPE_COOLDOWN (1m by default), unchecked when empty or zero.
+	maxSwipes     = os.Getenv("MAX_SWIPES")
+	swipeWindow   = os.Getenv("SWIPE_WINDOW")
+	swipeCooldown = os.Getenv("SWIPE_COOLDOWN")
+	// FEED_SNAPSHOT_TTL is how long a feed paging session pins its first FEED_SNAPSHOT_SIZE (200 by default)
+	// candidates, snapshots are disabled when empty.
+	feedSnapshotTTL  = os.Getenv("FEED_SNAPSHOT_TTL")
+	feedSnapshotSize = os.Getenv("FEED_SNAPSHOT_SIZE")
+	// SECOND_CHANCE_AGE is a duration like 2160h after which disliked profiles may come back to the feed,
+	// flagged as second chances, with the probability SECOND_CHANCE_RATE within [0, 1] (0.05 by default) per day.
+	// Off when empty or with a zero rate.
+	secondChanceAge  = os.Getenv("SECOND_CHANCE_AGE")
+	secondChanceRate = os.Getenv("SECOND_CHANCE_RATE")
+	// FEED_DIVERSITY is region or age, no more than FEED_DIVERSITY_MAX_RUN (3 by default) candidates in a row
+	// share it if a different one is within FEED_DIVERSITY_WINDOW (10 by default) positions. Off when empty.
+	feedDiversity       = os.Getenv("FEED_DIVERSITY")
+	feedDiversityMaxRun = os.Getenv("FEED_DIVERSITY_MAX_RUN")
+	feedDiversityWindow = os.Getenv("FEED_DIVERSITY_WINDOW")
+	// FEED_FAIRNESS=true down-ranks candidates shown widely across feeds by FEED_FAIRNESS_WEIGHT (1 by default)
+	// positions per doubling of their impressions within FEED_FAIRNESS_WINDOW (1h by default).
+	feedFairness       = os.Getenv("FEED_FAIRNESS")
+	feedFairnessWeight = os.Getenv("FEED_FAIRNESS_WEIGHT")
+	feedFairnessWindow = os.Getenv("FEED_FAIRNESS_WINDOW")
+	// SCORING_WORKERS score candidates of large feeds in parallel, GOMAXPROCS by default.
+	scoringWorkers = os.Getenv("SCORING_WORKERS")
+	// REMATCH_COOLDOWN is a duration like 720h unmatched users are kept out of each other's matches for.
+	rematchCooldown = os.Getenv("REMATCH_COOLDOWN")
+	// NEW_USERS_WINDOW is a duration like 168h users who joined within are in the new_only feed, 168h when empty.
+	newUsersWindow = os.Getenv("NEW_USERS_WINDOW")
+	// PROFILE_VIEWS_WINDOW is a duration like 1h repeated views of a profile by the same viewer count as one within,
+	// 1h when empty.
+	profileViewsWindow = os.Getenv("PROFILE_VIEWS_WINDOW")
+	// PROFILE_VIEWS_RETENTION is a duration like 2160h views of profiles are kept for, 2160h when empty and forever
+	// when zero.
+	profileViewsRetention = os.Getenv("PROFILE_VIEWS_RETENTION")
+	// BIO_LANGUAGES is a comma separated list of language codes bios may be translated to, en,ru when empty.
+	bioLanguages = os.Getenv("BIO_LANGUAGES")
+	// COUNT_CAP makes list totals above it estimated, exact when empty or zero.
+	countCap = os.Getenv("COUNT_CAP")
+	// NOTIFICATIONS=true serves match notifications over a websocket, resending an unacked one on reconnect
+	// within NOTIFICATION_TTL (24h by default) up to NOTIFICATION_MAX_ATTEMPTS (5 by default) times.
+	// Matches within MATCH_NOTIFICATION_WINDOW are notified about at once.
+	notificationsEnabled    = os.Getenv("NOTIFICATIONS")
+	notificationTTL         = os.Getenv("NOTIFICATION_TTL")
+	notificationMaxAttempts = os.Getenv("NOTIFICATION_MAX_ATTEMPTS")
+	matchNotificationWindow = os.Getenv("MATCH_NOTIFICATION_WINDOW")
+	// S3_ENDPOINT is the URL of an S3-compatible bucket photos are uploaded to directly with presigned URLs
+	// valid for PHOTO_UPLOAD_TTL (15m by default), photos are links only when empty. S3_REGION is us-east-1
+	// by default.
+	s3Endpoint     = os.Getenv("S3_ENDPOINT")
+	s3Region       = os.Getenv("S3_REGION")
+	s3AccessKey    = os.Getenv("S3_ACCESS_KEY")
+	s3SecretKey    = os.Getenv("S3_SECRET_KEY")
+	photoUploadTTL = os.Getenv("PHOTO_UPLOAD_TTL")
+	// PHOTO_URL_TTL is a duration like 5m photos of a private bucket are shown with signed links valid for,
+	// permanent links are shown when empty.
+	photoURLTTL = os.Getenv("PHOTO_URL_TTL")
 )
 
 func main() {
 	log := logging.GetLogger(true)
 	ctx := context.Background()
-	store, err := storage.New(ctx, log, pgDSN)
+	store, err := storage.New(ctx, log, pgDSN, storageOptions(log)...)
 	if err != nil {
 		log.Panicf("err initing pg: %v", err)
 	}
 	if err = store.Migrate(); err != nil {
 		log.Panicf("err migrating pg: %v", err)
 	}
-	chatServer := chat.NewServer()
-	app := internal.NewApp(log, store, chatServer)
-	router := rest.NewRouter(log, app, mustGetPublicKey(publicSigningKey), domain, version)
-	if err = startServer(ctx, router, log); err != nil {
+	// business events are counted by both the chat server and the app
+	events := metrics.NewEvents().AutoRegister()
+	chatServer := chat.NewServer(store, chatOptions(log, events)...)
+	notifications := notificationServer(log)
+	app := internal.NewApp(log, store, chatServer, appOptions(log, events, notifications)...)
+	go app.RunMessageRetention(ctx, time.Hour)
+	go app.RunViewsRetention(ctx, time.Hour)
+	go app.RunUploadsSweep(ctx, time.Hour)
+	if err = app.ResumeDeferredNotifications(ctx); err != nil {
+		log.Warnf("err resuming deferred notifications, they are sent by other instances or the next start: %v", err)
+	}
+	if prewarm == "true" {
+		warmCtx, cancel := context.WithTimeout(ctx, prewarmTimeout)
+		if err = app.Warm(warmCtx); err != nil {
+			log.Warnf("err pre-warming, starting cold: %v", err)
+		}
+		cancel()
+	}
+	router, err := rest.NewRouter(log, app, mustGetPublicKey(publicSigningKey), domain, version,
+		routerOptions(log, notifications)...)
+	if err != nil {
+		log.Panic(err)
+	}
+	metricsServer := startMetricsServer(log)
+	if err = startServer(ctx, router, log, serverOptions(log)...); err != nil {
 		log.Panic(err)
 	}
+	if metricsServer != nil {
+		_ = metricsServer.Close()
+	}
+	chatServer.Shutdown()
 }
 
-func startServer(ctx context.Context, router http.Handler, log *logrus.Logger) error {
-	log.Infof("starting server on port %d", httpPort)
-	s := &http.Server{
-		Addr:              fmt.Sprintf(":%d", httpPort),
-		ReadHeaderTimeout: 30 * time.Second,
-		ReadTimeout:       30 * time.Second,
-		WriteTimeout:      30 * time.Second,
-		Handler:           router,
+// notificationServer returns nil unless notifications are enabled.
+func notificationServer(log *logrus.Logger) *notify.Server {
+	if notificationsEnabled != "true" {
+		return nil
+	}
+	var opts []notify.Option
+	if notificationTTL != "" {
+		ttl, err := time.ParseDuration(notificationTTL)
+		if err != nil {
+			log.Panicf("err parsing NOTIFICATION_TTL: %v", err)
+		}
+		opts = append(opts, notify.WithTTL(ttl))
+	}
+	if notificationMaxAttempts != "" {
+		attempts, err := strconv.Atoi(notificationMaxAttempts)
+		if err != nil {
+			log.Panicf("err parsing NOTIFICATION_MAX_ATTEMPTS: %v", err)
+		}
+		opts = append(opts, notify.WithMaxAttempts(attempts))
+	}
+	return notify.NewServer(opts...)
+}
+
+func storageOptions(log *logrus.Logger) []storage.Option {
+	var opts []storage.Option
+	if dbBreakerThreshold != "" {
+		threshold, err := strconv.Atoi(dbBreakerThreshold)
+		if err != nil {
+			log.Panicf("err parsing DB_BREAKER_THRESHOLD: %v", err)
+		}
+		cooldown := defaultDBBreakerCooldown
+		if dbBreakerCooldown != "" {
+			if cooldown, err = time.ParseDuration(dbBreakerCooldown); err != nil {
+				log.Panicf("err parsing DB_BREAKER_COOLDOWN: %v", err)
+			}
+		}
+		opts = append(opts, storage.WithBreaker(threshold, cooldown))
+	}
+	return opts
+}
+
+func appOptions(log *logrus.Logger, events *metrics.Events, notifications *notify.Server) []internal.Option {
+	opts := []internal.Option{
+		internal.WithEventMetrics(events),
+		internal.WithMatchOnlyChats(chatRequireMatch == "true"),
+		internal.WithPhotoRequired(requirePhoto == "true"),
+		internal.WithSuperLikesFirst(superLikesFirst == "true"),
+		internal.WithFeedShuffle(feedShuffle == "true"),
+	}
+	if minAge != "" {
+		age, err := strconv.Atoi(minAge)
+		if err != nil {
+			log.Panicf("err parsing MIN_AGE: %v", err)
+		}
+		opts = append(opts, internal.WithMinAge(age))
+	}
+	if maxActiveMatches != "" {
+		count, err := strconv.ParseInt(maxActiveMatches, 10, 64)
+		if err != nil {
+			log.Panicf("err parsing MAX_ACTIVE_MATCHES: %v", err)
+		}
+		opts = append(opts, internal.WithMaxActiveMatches(count))
+	}
+	if maxRegions != "" {
+		count, err := strconv.Atoi(maxRegions)
+		if err != nil {
+			log.Panicf("err parsing MAX_REGIONS: %v", err)
+		}
+		opts = append(opts, internal.WithMaxRegions(count))
+	}
+	if maxBudget != "" {
+		budget, err := strconv.ParseFloat(maxBudget, 64)
+		if err != nil {
+			log.Panicf("err parsing MAX_BUDGET: %v", err)
+		}
+		opts = append(opts, internal.WithMaxBudget(budget))
+	}
+	if maxBioLength != "" {
+		length, err := strconv.Atoi(maxBioLength)
+		if err != nil {
+			log.Panicf("err parsing MAX_BIO_LENGTH: %v", err)
+		}
+		opts = append(opts, internal.WithMaxBioLength(length))
+	}
+	if usernameMaxLength != "" {
+		length, err := strconv.Atoi(usernameMaxLength)
+		if err != nil {
+			log.Panicf("err parsing USERNAME_MAX_LENGTH: %v", err)
+		}
+		opts = append(opts, internal.WithMaxUsernameLength(length))
+	}
+	if usernameASCIIOnly != "" || usernameNFC != "" {
+		opts = append(opts, internal.WithUsernameRules(usernameASCIIOnly == "true", usernameNFC != "false"))
+	}
+	if maxPhotos != "" {
+		count, err := strconv.Atoi(maxPhotos)
+		if err != nil {
+			log.Panicf("err parsing MAX_PHOTOS: %v", err)
+		}
+		opts = append(opts, internal.WithMaxPhotos(count))
+	}
+	if countCap != "" {
+		count, err := strconv.ParseInt(countCap, 10, 64)
+		if err != nil {
+			log.Panicf("err parsing COUNT_CAP: %v", err)
+		}
+		opts = append(opts, internal.WithCountCap(count))
+	}
+	if maxPendingLikes != "" {
+		count, err := strconv.ParseInt(maxPendingLikes, 10, 64)
+		if err != nil {
+			log.Panicf("err parsing MAX_PENDING_LIKES: %v", err)
+		}
+		var window time.Duration
+		if pendingLikesWindow != "" {
+			if window, err = time.ParseDuration(pendingLikesWindow); err != nil {
+				log.Panicf("err parsing PENDING_LIKES_WINDOW: %v", err)
+			}
+		}
+		opts = append(opts, internal.WithPendingLikesLimit(count, window))
+	}
+	if maxSwipes != "" {
+		count, err := strconv.Atoi(maxSwipes)
+		if err != nil {
+			log.Panicf("err parsing MAX_SWIPES: %v", err)
+		}
+		window, cooldown := 10*time.Second, time.Minute
+		if swipeWindow != "" {
+			if window, err = time.ParseDuration(swipeWindow); err != nil {
+				log.Panicf("err parsing SWIPE_WINDOW: %v", err)
+			}
+		}
+		if swipeCooldown != "" {
+			if cooldown, err = time.ParseDuration(swipeCooldown); err != nil {
+				log.Panicf("err parsing SWIPE_COOLDOWN: %v", err)
+			}
+		}
+		opts = append(opts, internal.WithSwipeCadence(count, window, cooldown))
+	}
+	if distancePrecision != "" {
+		km, err := strconv.ParseFloat(distancePrecision, 64)
+		if err != nil {
+			log.Panicf("err parsing DISTANCE_PRECISION_KM: %v", err)
+		}
+		opts = append(opts, internal.WithDistancePrecision(km))
+	}
+	if publicProfileFields != "" {
+		opts = append(opts, internal.WithPublicFields(strings.Split(publicProfileFields, ",")))
+	}
+	if titleCaseFields != "" {
+		opts = append(opts, internal.WithTitleCaseFields(strings.Split(titleCaseFields, ",")))
+	}
+	if messageRetention != "" {
+		age, err := time.ParseDuration(messageRetention)
+		if err != nil {
+			log.Panicf("err parsing MESSAGE_RETENTION: %v", err)
+		}
+		if age <= 0 {
+			log.Panicf("err MESSAGE_RETENTION %v isn't positive", age)
+		}
+		opts = append(opts, internal.WithMessageRetention(age))
+	}
+	if regionsCacheTTL != "" {
+		ttl, err := time.ParseDuration(regionsCacheTTL)
+		if err != nil {
+			log.Panicf("err parsing REGIONS_CACHE_TTL: %v", err)
+		}
+		opts = append(opts, internal.WithRegionsTTL(ttl))
+	}
+	if bansCacheTTL != "" {
+		ttl, err := time.ParseDuration(bansCacheTTL)
+		if err != nil {
+			log.Panicf("err parsing BANS_CACHE_TTL: %v", err)
+		}
+		opts = append(opts, internal.WithBansTTL(ttl))
+	}
+	if revocationsCacheTTL != "" {
+		ttl, err := time.ParseDuration(revocationsCacheTTL)
+		if err != nil {
+			log.Panicf("err parsing REVOCATIONS_CACHE_TTL: %v", err)
+		}
+		opts = append(opts, internal.WithRevocationsTTL(ttl))
+	}
+	if configEditCooldown != "" {
+		cooldown, err := time.ParseDuration(configEditCooldown)
+		if err != nil {
+			log.Panicf("err parsing CONFIG_EDIT_COOLDOWN: %v", err)
+		}
+		opts = append(opts, internal.WithEditCooldown(cooldown))
+	}
+	if feedSnapshotTTL != "" {
+		ttl, err := time.ParseDuration(feedSnapshotTTL)
+		if err != nil {
+			log.Panicf("err parsing FEED_SNAPSHOT_TTL: %v", err)
+		}
+		var size int64
+		if feedSnapshotSize != "" {
+			if size, err = strconv.ParseInt(feedSnapshotSize, 10, 64); err != nil {
+				log.Panicf("err parsing FEED_SNAPSHOT_SIZE: %v", err)
+			}
+		}
+		opts = append(opts, internal.WithFeedSnapshots(ttl, size))
+	}
+	if secondChanceAge != "" {
+		age, err := time.ParseDuration(secondChanceAge)
+		if err != nil {
+			log.Panicf("err parsing SECOND_CHANCE_AGE: %v", err)
+		}
+		rate := internal.DefaultSecondChanceRate
+		if secondChanceRate != "" {
+			if rate, err = strconv.ParseFloat(secondChanceRate, 64); err != nil {
+				log.Panicf("err parsing SECOND_CHANCE_RATE: %v", err)
+			}
+			if rate < 0 || rate > 1 {
+				log.Panicf("err SECOND_CHANCE_RATE %v is out of [0, 1]", rate)
+			}
+		}
+		opts = append(opts, internal.WithSecondChance(age, rate))
+	}
+	if feedDiversity != "" {
+		key, err := internal.DiversityKeyByName(feedDiversity)
+		if err != nil {
+			log.Panicf("err parsing FEED_DIVERSITY: %v", err)
+		}
+		var maxRun, window int
+		if feedDiversityMaxRun != "" {
+			if maxRun, err = strconv.Atoi(feedDiversityMaxRun); err != nil {
+				log.Panicf("err parsing FEED_DIVERSITY_MAX_RUN: %v", err)
+			}
+		}
+		if feedDiversityWindow != "" {
+			if window, err = strconv.Atoi(feedDiversityWindow); err != nil {
+				log.Panicf("err parsing FEED_DIVERSITY_WINDOW: %v", err)
+			}
+		}
+		opts = append(opts, internal.WithFeedDiversity(key, maxRun, window))
+	}
+	if feedFairness == "true" {
+		var weight float64
+		var window time.Duration
+		var err error
+		if feedFairnessWeight != "" {
+			if weight, err = strconv.ParseFloat(feedFairnessWeight, 64); err != nil {
+				log.Panicf("err parsing FEED_FAIRNESS_WEIGHT: %v", err)
+			}
+		}
+		if feedFairnessWindow != "" {
+			if window, err = time.ParseDuration(feedFairnessWindow); err != nil {
+				log.Panicf("err parsing FEED_FAIRNESS_WINDOW: %v", err)
+			}
+		}
+		opts = append(opts, internal.WithFeedFairness(weight, window))
+	}
+	if scoringWorkers != "" {
+		workers, err := strconv.Atoi(scoringWorkers)
+		if err != nil {
+			log.Panicf("err parsing SCORING_WORKERS: %v", err)
+		}
+		opts = append(opts, internal.WithScoringWorkers(workers))
+	}
+	if rematchCooldown != "" {
+		cooldown, err := time.ParseDuration(rematchCooldown)
+		if err != nil {
+			log.Panicf("err parsing REMATCH_COOLDOWN: %v", err)
+		}
+		opts = append(opts, internal.WithRematchCooldown(cooldown))
+	}
+	if newUsersWindow != "" {
+		window, err := time.ParseDuration(newUsersWindow)
+		if err != nil {
+			log.Panicf("err parsing NEW_USERS_WINDOW: %v", err)
+		}
+		opts = append(opts, internal.WithNewUsersWindow(window))
+	}
+	if profileViewsWindow != "" {
+		window, err := time.ParseDuration(profileViewsWindow)
+		if err != nil {
+			log.Panicf("err parsing PROFILE_VIEWS_WINDOW: %v", err)
+		}
+		opts = append(opts, internal.WithProfileViewsWindow(window))
+	}
+	if profileViewsRetention != "" {
+		age, err := time.ParseDuration(profileViewsRetention)
+		if err != nil {
+			log.Panicf("err parsing PROFILE_VIEWS_RETENTION: %v", err)
+		}
+		if age < 0 {
+			log.Panicf("err PROFILE_VIEWS_RETENTION %v is negative", age)
+		}
+		opts = append(opts, internal.WithProfileViewsRetention(age))
+	}
+	if bioLanguages != "" {
+		opts = append(opts, internal.WithBioLanguages(strings.Split(bioLanguages, ",")))
+	}
+	if boostMaxDuration != "" {
+		maxDuration, err := time.ParseDuration(boostMaxDuration)
+		if err != nil {
+			log.Panicf("err parsing BOOST_MAX_DURATION: %v", err)
+		}
+		opts = append(opts, internal.WithMaxBoostDuration(maxDuration))
+	}
+	if boostCooldown != "" {
+		cooldown, err := time.ParseDuration(boostCooldown)
+		if err != nil {
+			log.Panicf("err parsing BOOST_COOLDOWN: %v", err)
+		}
+		opts = append(opts, internal.WithBoostCooldown(cooldown))
+	}
+	if feedDefaultSort != "" {
+		sort := models.FeedSort(feedDefaultSort)
+		if !sort.Valid() {
+			log.Panicf("err parsing FEED_DEFAULT_SORT: %v", common.ErrInvalidFeedSort)
+		}
+		opts = append(opts, internal.WithDefaultFeedSort(sort))
+	}
+	if matchStrategy != "" {
+		strategy, err := internal.MatchStrategyByName(matchStrategy)
+		if err != nil {
+			log.Panicf("err parsing MATCH_STRATEGY: %v", err)
+		}
+		opts = append(opts, internal.WithMatchStrategy(strategy))
+	}
+	if textFilterDisabled != "true" {
+		words := textfilter.DefaultBannedWords
+		if textFilterWords != "" {
+			words = strings.Split(textFilterWords, ",")
+		}
+		opts = append(opts, internal.WithTextFilter(textfilter.NewWordlist(words)))
+	}
+	if notifications != nil {
+		var window time.Duration
+		if matchNotificationWindow != "" {
+			var err error
+			if window, err = time.ParseDuration(matchNotificationWindow); err != nil {
+				log.Panicf("err parsing MATCH_NOTIFICATION_WINDOW: %v", err)
+			}
+		}
+		opts = append(opts, internal.WithNotifier(internal.NewEventNotifier(notifications), window))
 	}
+	if s3Endpoint != "" {
+		region := s3Region
+		if region == "" {
+			region = "us-east-1"
+		}
+		blobs, err := blob.NewS3(s3Endpoint, region, s3AccessKey, s3SecretKey)
+		if err != nil {
+			log.Panicf("err parsing S3_ENDPOINT: %v", err)
+		}
+		var ttl time.Duration
+		if photoUploadTTL != "" {
+			if ttl, err = time.ParseDuration(photoUploadTTL); err != nil {
+				log.Panicf("err parsing PHOTO_UPLOAD_TTL: %v", err)
+			}
+		}
+		opts = append(opts, internal.WithBlobStore(blobs, ttl))
+		if photoURLTTL != "" {
+			urlTTL, err := time.ParseDuration(photoURLTTL)
+			if err != nil {
+				log.Panicf("err parsing PHOTO_URL_TTL: %v", err)
+			}
+			opts = append(opts, internal.WithSignedPhotoURLs(urlTTL))
+		}
+	}
+	return opts
+}
+
+func chatOptions(log *logrus.Logger, events *metrics.Events) []chat.Option {
+	opts := []chat.Option{
+		chat.WithAutoUnarchive(chatAutoUnarchive == "true"),
+		chat.WithMetrics(metrics.NewChat().AutoRegister()),
+		chat.WithEventMetrics(events),
+	}
+	if chatHistoryReplay == "true" {
+		var limit int
+		if chatHistoryReplayLimit != "" {
+			var err error
+			if limit, err = strconv.Atoi(chatHistoryReplayLimit); err != nil {
+				log.Panicf("err parsing CHAT_HISTORY_REPLAY_LIMIT: %v", err)
+			}
+		}
+		opts = append(opts, chat.WithHistoryReplay(limit))
+	}
+	if chatPingInterval != "" || chatPongWait != "" {
+		var pingInterval, pongWait time.Duration
+		var err error
+		if chatPingInterval != "" {
+			if pingInterval, err = time.ParseDuration(chatPingInterval); err != nil {
+				log.Panicf("err parsing CHAT_PING_INTERVAL: %v", err)
+			}
+		}
+		if chatPongWait != "" {
+			if pongWait, err = time.ParseDuration(chatPongWait); err != nil {
+				log.Panicf("err parsing CHAT_PONG_WAIT: %v", err)
+			}
+		}
+		opts = append(opts, chat.WithKeepalive(pingInterval, pongWait))
+	}
+	if chatMaxFrameSize != "" {
+		size, err := strconv.ParseInt(chatMaxFrameSize, 10, 64)
+		if err != nil {
+			log.Panicf("err parsing CHAT_MAX_FRAME_SIZE: %v", err)
+		}
+		opts = append(opts, chat.WithMaxFrameSize(size))
+	}
+	if chatMaxConnectionLifetime != "" {
+		lifetime, err := time.ParseDuration(chatMaxConnectionLifetime)
+		if err != nil {
+			log.Panicf("err parsing CHAT_MAX_CONNECTION_LIFETIME: %v", err)
+		}
+		opts = append(opts, chat.WithMaxLifetime(lifetime))
+	}
+	if chatEditWindow != "" {
+		window, err := time.ParseDuration(chatEditWindow)
+		if err != nil {
+			log.Panicf("err parsing CHAT_EDIT_WINDOW: %v", err)
+		}
+		opts = append(opts, chat.WithEditWindow(window))
+	}
+	if chatTypingTimeout != "" {
+		timeout, err := time.ParseDuration(chatTypingTimeout)
+		if err != nil {
+			log.Panicf("err parsing CHAT_TYPING_TIMEOUT: %v", err)
+		}
+		opts = append(opts, chat.WithTypingTimeout(timeout))
+	}
+	if chatReactions != "" {
+		opts = append(opts, chat.WithReactions(strings.Split(chatReactions, ",")))
+	}
+	if chatAttachmentSchemes != "" {
+		opts = append(opts, chat.WithAttachmentSchemes(strings.Split(chatAttachmentSchemes, ",")))
+	}
+	if chatAttachmentTypes != "" {
+		opts = append(opts, chat.WithAttachmentTypes(strings.Split(chatAttachmentTypes, ",")))
+	}
+	if chatMaxEmptyChats != "" {
+		max, err := strconv.Atoi(chatMaxEmptyChats)
+		if err != nil {
+			log.Panicf("err parsing CHAT_MAX_EMPTY_CHATS: %v", err)
+		}
+		if max > 0 && chatMaxConnectionLifetime == "" {
+			log.Panic("err CHAT_MAX_EMPTY_CHATS needs CHAT_MAX_CONNECTION_LIFETIME, chats may be connected to elsewhere")
+		}
+		opts = append(opts, chat.WithMaxEmptyChats(max))
+	}
+	mode, err := chat.ParseSessionMode(chatSessions)
+	if err != nil {
+		log.Panicf("err parsing CHAT_SESSIONS: %v", err)
+	}
+	return append(opts, chat.WithSessionMode(mode))
+}
+
+func routerOptions(log *logrus.Logger, notifications *notify.Server) []rest.Option {
+	var opts []rest.Option
+	if compressMinSize != "" {
+		size, err := strconv.Atoi(compressMinSize)
+		if err != nil {
+			log.Panicf("err parsing COMPRESS_MIN_SIZE: %v", err)
+		}
+		opts = append(opts, rest.WithCompressMinSize(size))
+	}
+	if compressExcludedTypes != "" {
+		opts = append(opts, rest.WithCompressExcludedTypes(strings.Split(compressExcludedTypes, ",")))
+	}
+	if accessLogSample != "" {
+		every, err := strconv.Atoi(accessLogSample)
+		if err != nil {
+			log.Panicf("err parsing ACCESS_LOG_SAMPLE: %v", err)
+		}
+		opts = append(opts, rest.WithAccessLogSample(every))
+	}
+	if accessLogAlwaysStatus != "" {
+		status, err := strconv.Atoi(accessLogAlwaysStatus)
+		if err != nil {
+			log.Panicf("err parsing ACCESS_LOG_ALWAYS_STATUS: %v", err)
+		}
+		opts = append(opts, rest.WithAccessLogAlwaysStatus(status))
+	}
+	if accessLogAlwaysSlower != "" {
+		d, err := time.ParseDuration(accessLogAlwaysSlower)
+		if err != nil {
+			log.Panicf("err parsing ACCESS_LOG_ALWAYS_SLOWER: %v", err)
+		}
+		opts = append(opts, rest.WithAccessLogAlwaysSlower(d))
+	}
+	if maxMatchesCount != "" {
+		count, err := strconv.ParseInt(maxMatchesCount, 10, 64)
+		if err != nil {
+			log.Panicf("err parsing MAX_MATCHES_COUNT: %v", err)
+		}
+		opts = append(opts, rest.WithMaxMatchesCount(count))
+	}
+	if maxStreamMatchesCount != "" {
+		count, err := strconv.ParseInt(maxStreamMatchesCount, 10, 64)
+		if err != nil {
+			log.Panicf("err parsing MAX_STREAM_MATCHES_COUNT: %v", err)
+		}
+		opts = append(opts, rest.WithMaxStreamMatchesCount(count))
+	}
+	if requestTimeout != "" {
+		timeout, err := time.ParseDuration(requestTimeout)
+		if err != nil {
+			log.Panicf("err parsing REQUEST_TIMEOUT: %v", err)
+		}
+		opts = append(opts, rest.WithRequestTimeout(timeout))
+	}
+	if ipRateLimit != "" {
+		rate, err := strconv.ParseFloat(ipRateLimit, 64)
+		if err != nil {
+			log.Panicf("err parsing IP_RATE_LIMIT: %v", err)
+		}
+		opts = append(opts, rest.WithIPRateLimit(rate))
+	}
+	if ipRateBurst != "" {
+		burst, err := strconv.Atoi(ipRateBurst)
+		if err != nil {
+			log.Panicf("err parsing IP_RATE_BURST: %v", err)
+		}
+		opts = append(opts, rest.WithIPRateBurst(burst))
+	}
+	if maxInFlightWrites != "" {
+		max, err := strconv.Atoi(maxInFlightWrites)
+		if err != nil {
+			log.Panicf("err parsing MAX_IN_FLIGHT_WRITES: %v", err)
+		}
+		opts = append(opts, rest.WithMaxInFlightWrites(max))
+	}
+	if trustedProxies != "" {
+		var proxies []string
+		for _, proxy := range strings.Split(trustedProxies, ",") {
+			if proxy = strings.TrimSpace(proxy); proxy != "" {
+				proxies = append(proxies, proxy)
+			}
+		}
+		opts = append(opts, rest.WithTrustedProxies(proxies))
+	}
+	if anonymizeIPs == "true" {
+		opts = append(opts, rest.WithIPAnonymization(anonymizeIPsKeepFull == "true"))
+	}
+	if prettyResponses == "true" || prettyResponsesQuery == "true" {
+		log.Warn("pretty responses are enabled, don't enable them in production")
+		opts = append(opts, rest.WithPrettyResponses(prettyResponses == "true", prettyResponsesQuery == "true"))
+	}
+	if jwtAudience != "" {
+		opts = append(opts, rest.WithAudience(jwtAudience))
+	}
+	if jwtLeeway != "" {
+		leeway, err := time.ParseDuration(jwtLeeway)
+		if err != nil {
+			log.Panicf("err parsing JWT_LEEWAY: %v", err)
+		}
+		opts = append(opts, rest.WithLeeway(leeway))
+	}
+	if jwtRequiredClaims != "" {
+		opts = append(opts, rest.WithRequiredClaims(strings.Split(jwtRequiredClaims, ",")))
+	}
+	if adminToken != "" {
+		opts = append(opts, rest.WithAdminToken(adminToken))
+	}
+	if impersonationKey != "" {
+		opts = append(opts, rest.WithImpersonation([]byte(impersonationKey)))
+	}
+	if impersonationTTL != "" {
+		ttl, err := time.ParseDuration(impersonationTTL)
+		if err != nil {
+			log.Panicf("err parsing IMPERSONATION_TTL: %v", err)
+		}
+		opts = append(opts, rest.WithImpersonationTTL(ttl))
+	}
+	if notifications != nil {
+		opts = append(opts, rest.WithNotifications(notifications))
+	}
+	if featuresDisabled != "" {
+		var disabled []rest.Feature
+		for _, feature := range strings.Split(featuresDisabled, ",") {
+			disabled = append(disabled, rest.Feature(feature))
+		}
+		opts = append(opts, rest.WithFeatureFlags(rest.NewFeatureFlags(disabled...)))
+	}
+	return append(opts, rest.WithSecurityHeaders(securityHeaders(log)), rest.WithRequireRequestID(requireRequestID == "true"))
+}
+
+// securityHeaders returns the default security headers overridden by the env.
+func securityHeaders(log *logrus.Logger) rest.SecurityHeaders {
+	headers := rest.DefaultSecurityHeaders()
+	for _, header := range []struct {
+		env   string
+		value *string
+	}{
+		{securityContentTypeOptions, &headers.ContentTypeOptions},
+		{securityFrameOptions, &headers.FrameOptions},
+		{securityReferrerPolicy, &headers.ReferrerPolicy},
+		{securityCSP, &headers.ContentSecurityPolicy},
+	} {
+		switch header.env {
+		case "":
+		case "off":
+			*header.value = ""
+		default:
+			*header.value = header.env
+		}
+	}
+	if hstsMaxAge != "" {
+		maxAge, err := time.ParseDuration(hstsMaxAge)
+		if err != nil {
+			log.Panicf("err parsing HSTS_MAX_AGE: %v", err)
+		}
+		headers.HSTSMaxAge = maxAge
+	}
+	headers.HSTSIncludeSubdomains = hstsIncludeSubdomains == "true"
+	return headers
+}
+
+func serverOptions(log *logrus.Logger) []rest.ServerOption {
+	var opts []rest.ServerOption
+	if httpReadHeaderTimeout != "" {
+		timeout, err := time.ParseDuration(httpReadHeaderTimeout)
+		if err != nil {
+			log.Panicf("err parsing HTTP_READ_HEADER_TIMEOUT: %v", err)
+		}
+		opts = append(opts, rest.WithReadHeaderTimeout(timeout))
+	}
+	if httpReadTimeout != "" {
+		timeout, err := time.ParseDuration(httpReadTimeout)
+		if err != nil {
+			log.Panicf("err parsing HTTP_READ_TIMEOUT: %v", err)
+		}
+		opts = append(opts, rest.WithReadTimeout(timeout))
+	}
+	if httpMaxHeaderBytes != "" {
+		size, err := strconv.Atoi(httpMaxHeaderBytes)
+		if err != nil {
+			log.Panicf("err parsing HTTP_MAX_HEADER_BYTES: %v", err)
+		}
+		opts = append(opts, rest.WithMaxHeaderBytes(size))
+	}
+	return opts
+}
+
+func startServer(ctx context.Context, router http.Handler, log *logrus.Logger, opts ...rest.ServerOption) error {
+	s, err := rest.NewServer(fmt.Sprintf(":%d", httpPort), router, opts...)
+	if err != nil {
+		return err
+	}
+	log.Infof("starting server on port %d", httpPort)
 	errCh := make(chan error)
 	go func() {
 		if err := s.ListenAndServe(); err != nil && errors.Is(err, http.ErrServerClosed) {
@@ -81,6 +966,24 @@ func startServer(ctx context.Context, router http.Handler, log *logrus.Logger) e
 	return s.Shutdown(gfCtx)
 }
 
+// startMetricsServer serves metrics on METRICS_ADDR in the background, it returns nil if they aren't served.
+func startMetricsServer(log *logrus.Logger) *http.Server {
+	if metricsAddr == "" {
+		return nil
+	}
+	s, err := rest.NewServer(metricsAddr, rest.MetricsHandler())
+	if err != nil {
+		log.Panic(err)
+	}
+	log.Infof("serving metrics on %s", metricsAddr)
+	go func() {
+		if err := s.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Warnf("err serving metrics: %v", err)
+		}
+	}()
+	return s
+}
+
 func mustGetPublicKey(keyBytes []byte) *rsa.PublicKey {
 	if len(keyBytes) == 0 {
 		panic("file public.pub is missing or invalid")
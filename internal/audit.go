@@ -0,0 +1,21 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+// RecordAudit appends the entry to the audit log at the moment.
+func (a *App) RecordAudit(ctx context.Context, entry *models.AuditEntry) error {
+	if err := entry.Validate(); err != nil {
+		return err
+	}
+	entry.At = common.NewTimestamp(a.now())
+	if err := a.store.SaveAuditEntry(ctx, entry); err != nil {
+		return fmt.Errorf("err recording audit entry: %w", err)
+	}
+	return nil
+}
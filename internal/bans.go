@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+// BanIdentity forbids authentication to everyone with the identity, banning it again updates the reason.
+func (a *App) BanIdentity(ctx context.Context, ban *models.Ban) error {
+	if err := ban.Validate(); err != nil {
+		return err
+	}
+	ban.CreatedAt = common.NewTimestamp(a.now())
+	if err := a.store.SaveBan(ctx, ban); err != nil {
+		return fmt.Errorf("err banning identity: %w", err)
+	}
+	a.bans.reset()
+	return nil
+}
+
+func (a *App) UnbanIdentity(ctx context.Context, kind models.BanKind, value string) error {
+	err := a.store.DeleteBan(ctx, kind, value)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrBanNotFound):
+		return err
+	default:
+		return fmt.Errorf("err unbanning identity: %w", err)
+	}
+	a.bans.reset()
+	return nil
+}
+
+func (a *App) ListBans(ctx context.Context) ([]*models.Ban, error) {
+	bans, err := a.store.ListBans(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("err listing bans: %w", err)
+	}
+	return bans, nil
+}
+
+// IsBanned reports whether the token subject or the device is banned. Empty identities are never banned.
+// Bans are cached for bansTTL, so bans made by other instances apply within it.
+func (a *App) IsBanned(ctx context.Context, subject, device string) (bool, error) {
+	now := a.now()
+	banned, ok := a.bans.get(now, a.bansTTL)
+	if !ok {
+		bans, err := a.store.ListBans(ctx)
+		if err != nil {
+			return false, fmt.Errorf("err checking bans: %w", err)
+		}
+		banned = make(map[banKey]bool, len(bans))
+		for _, ban := range bans {
+			banned[banKey{ban.Kind, ban.Value}] = true
+		}
+		a.bans.set(banned, now)
+	}
+	return subject != "" && banned[banKey{models.BanSubject, subject}] ||
+		device != "" && banned[banKey{models.BanDevice, device}], nil
+}
+
+type banKey struct {
+	kind  models.BanKind
+	value string
+}
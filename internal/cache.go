@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+// ttlCache keeps a value loaded at loadedAt for a ttl, the zero cache has nothing cached.
+type ttlCache[T any] struct {
+	mx       sync.RWMutex
+	value    T
+	loaded   bool
+	loadedAt time.Time
+}
+
+func (c *ttlCache[T]) get(now time.Time, ttl time.Duration) (T, bool) {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	if !c.loaded || now.Sub(c.loadedAt) >= ttl {
+		var zero T
+		return zero, false
+	}
+	return c.value, true
+}
+
+func (c *ttlCache[T]) set(value T, loadedAt time.Time) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.value, c.loaded, c.loadedAt = value, true, loadedAt
+}
+
+// reset drops the cached value, so that the next get misses.
+func (c *ttlCache[T]) reset() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	var zero T
+	c.value, c.loaded = zero, false
+}
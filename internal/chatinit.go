@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/chat"
+)
+
+// GetConversationInit returns the first frame of the user's connection to the chat with the peer. The peer's
+// profile is left out if either of them has blocked the other, the read state is sent anyway.
+func (a *App) GetConversationInit(ctx context.Context, uuid, peer string) (*models.ConversationInit, error) {
+	unread, lastRead, err := a.store.GetReadState(ctx, uuid, peer)
+	if err != nil {
+		return nil, fmt.Errorf("err getting conversation init: %w", err)
+	}
+	init := &models.ConversationInit{
+		Type:           chat.EventTypeConversationInit,
+		ConversationID: chat.ConversationID(uuid, peer),
+		UnreadCount:    unread,
+		LastReadID:     lastRead,
+	}
+	for _, pair := range [][2]string{{uuid, peer}, {peer, uuid}} {
+		hidden, err := a.store.IsHidden(ctx, pair[0], pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("err getting conversation init: %w", err)
+		}
+		if hidden {
+			return init, nil
+		}
+	}
+	profiles, err := a.store.GetProfiles(ctx, []string{peer})
+	if err != nil {
+		return nil, fmt.Errorf("err getting conversation init: %w", err)
+	}
+	if len(profiles) == 1 {
+		init.Peer = profiles[0]
+		a.projectProfile(init.Peer)
+	}
+	return init, nil
+}
@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"context"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+// dailyPickPool is the count of best candidates the daily pick is drawn from.
+const dailyPickPool = 20
+
+// GetDailyPick returns one of the best candidates for the user, picked by the user and the UTC day, so it
+// is the same all day. Every candidate of the pool has a rank of its own for the day, the one ranked first
+// is picked, so that candidates joining or leaving the pool don't change the pick unless they outrank it.
+// A swiped pick leaves the feed along with the pool. Returns ErrNoDailyPick if there are no candidates.
+func (a *App) GetDailyPick(ctx context.Context, uuid string) (*models.Profile, error) {
+	candidates, err := a.listCandidates(ctx, uuid, dailyPickPool, models.FeedSortBest, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, common.ErrNoDailyPick
+	}
+	seed := feedSeed(uuid, a.now())
+	pick, best := candidates[0], hashRoll(seed+":"+candidates[0].UUID)
+	for _, p := range candidates[1:] {
+		if rank := hashRoll(seed + ":" + p.UUID); rank < best {
+			pick, best = p, rank
+		}
+	}
+	if err = a.scoreCandidates(ctx, uuid, []*models.Profile{pick}); err != nil {
+		return pick, &common.DegradedError{Skipped: []string{SkippedDistances}, Err: err}
+	}
+	return pick, nil
+}
@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+// RegisterDevice registers the push token to the user, registering it again returns the same device.
+func (a *App) RegisterDevice(ctx context.Context, uuid string, platform models.DevicePlatform, token string) (*models.Device, error) { //nolint:lll
+	device := &models.Device{Platform: platform, Token: token, CreatedAt: common.NewTimestamp(a.now())}
+	if err := device.Validate(); err != nil {
+		return nil, err
+	}
+	if err := a.store.SaveDevice(ctx, uuid, device); err != nil {
+		return nil, fmt.Errorf("err registering device: %w", err)
+	}
+	return device, nil
+}
+
+func (a *App) UnregisterDevice(ctx context.Context, uuid string, id int64) error {
+	err := a.store.DeleteDevice(ctx, uuid, id)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrDeviceNotFound):
+		return err
+	default:
+		return fmt.Errorf("err unregistering device: %w", err)
+	}
+	return nil
+}
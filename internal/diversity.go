@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gerladeno/homie-core/internal/models"
+)
+
+// DiversityKey returns the attribute of a candidate the feed shouldn't repeat too many times in a row,
+// an empty key if the candidate lacks it.
+type DiversityKey func(p *models.Profile) string
+
+// Diversity keys by name, see DiversityKeyByName.
+const (
+	DiversityRegion = "region"
+	DiversityAge    = "age"
+)
+
+const (
+	defaultDiversityMaxRun = 3
+	defaultDiversityWindow = 10
+	// diversityAgeBand is the span of years candidates of the same age key fall into.
+	diversityAgeBand = 5
+)
+
+// DiversityKeyByName returns the shipped key of the name: region keys candidates by their set of regions,
+// age by their age in 5 year bands.
+func DiversityKeyByName(name string) (DiversityKey, error) {
+	switch name {
+	case DiversityRegion:
+		return regionKey, nil
+	case DiversityAge:
+		return ageKey, nil
+	}
+	return nil, fmt.Errorf("err unknown diversity key %q", name)
+}
+
+func regionKey(p *models.Profile) string {
+	if p.Criteria == nil || len(p.Criteria.Regions) == 0 {
+		return ""
+	}
+	regions := make([]string, 0, len(p.Criteria.Regions))
+	for _, region := range p.Criteria.Regions {
+		regions = append(regions, strconv.FormatInt(region, 10))
+	}
+	sort.Strings(regions)
+	return strings.Join(regions, ",")
+}
+
+func ageKey(p *models.Profile) string {
+	if p.Personal == nil || p.Personal.Age == 0 {
+		return ""
+	}
+	return strconv.Itoa(int(p.Personal.Age) / diversityAgeBand)
+}
+
+// WithFeedDiversity reorders ranked candidates of GetMatches so that no more than maxRun of them in a row
+// share the key, pulling a different candidate up by at most window positions. Non-positive maxRun and window
+// mean 3 and 10. Streamed matches keep the ranked order.
+func WithFeedDiversity(key DiversityKey, maxRun, window int) Option {
+	return func(a *App) {
+		if maxRun <= 0 {
+			maxRun = defaultDiversityMaxRun
+		}
+		if window <= 0 {
+			window = defaultDiversityWindow
+		}
+		a.diversity = feedDiversity{key: key, maxRun: maxRun, window: window}
+	}
+}
+
+// feedDiversity breaks runs of candidates sharing the key, it does nothing without a key.
+type feedDiversity struct {
+	key    DiversityKey
+	maxRun int
+	window int
+}
+
+// apply reorders the profiles in place. A candidate extending a run past maxRun swaps in the nearest one
+// with another key within the window, the rest keep their relative order. Runs which can't be broken
+// within the window are left as is.
+func (d feedDiversity) apply(profiles []*models.Profile) {
+	if d.key == nil {
+		return
+	}
+	keys := make([]string, len(profiles))
+	for i, p := range profiles {
+		keys[i] = d.key(p)
+	}
+	var (
+		runKey string
+		runLen int
+	)
+	for i := range profiles {
+		if keys[i] != "" && keys[i] == runKey && runLen >= d.maxRun {
+			for j := i + 1; j < len(profiles) && j <= i+d.window; j++ {
+				if keys[j] == runKey {
+					continue
+				}
+				p, key := profiles[j], keys[j]
+				copy(profiles[i+1:j+1], profiles[i:j])
+				copy(keys[i+1:j+1], keys[i:j])
+				profiles[i], keys[i] = p, key
+				break
+			}
+		}
+		if keys[i] == runKey {
+			runLen++
+		} else {
+			runKey, runLen = keys[i], 1
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+// WithEditCooldown rejects saves of a user's config sooner than the cooldown after their previous save,
+// so that profiles can't be changed rapidly to game the feed. Zero, the default, disables the check.
+func WithEditCooldown(cooldown time.Duration) Option {
+	return func(a *App) {
+		a.edits.cooldown = cooldown
+	}
+}
+
+// reserveEdit counts a save of the user's config. The error is a *common.RetryError of ErrEditingTooFast
+// if the previous save is within the cooldown. The returned undo must be called if the save fails,
+// so that only successful saves count.
+func (a *App) reserveEdit(uuid string) (func(), error) {
+	if a.edits.cooldown == 0 {
+		return func() {}, nil
+	}
+	return a.edits.reserve(uuid, a.now())
+}
+
+// editTracker keeps times of the last config saves within the cooldown, they are counted per instance.
+type editTracker struct {
+	cooldown time.Duration
+
+	mx      sync.Mutex
+	savedAt map[string]time.Time
+	// sweptAt is when users who saved before the cooldown were last dropped.
+	sweptAt time.Time
+}
+
+func (t *editTracker) reserve(uuid string, now time.Time) (func(), error) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.savedAt == nil {
+		t.savedAt = make(map[string]time.Time)
+	}
+	t.forgetIdle(now)
+	previous, ok := t.savedAt[uuid]
+	if ok {
+		if wait := previous.Add(t.cooldown).Sub(now); wait > 0 {
+			return nil, &common.RetryError{After: wait, Err: common.ErrEditingTooFast}
+		}
+	}
+	t.savedAt[uuid] = now
+	undo := func() {
+		t.mx.Lock()
+		defer t.mx.Unlock()
+		if !t.savedAt[uuid].Equal(now) {
+			return
+		}
+		if ok {
+			t.savedAt[uuid] = previous
+		} else {
+			delete(t.savedAt, uuid)
+		}
+	}
+	return undo, nil
+}
+
+// forgetIdle drops users who saved before the cooldown once a cooldown, so that the tracker holds
+// recent editors only.
+func (t *editTracker) forgetIdle(now time.Time) {
+	if now.Sub(t.sweptAt) < t.cooldown {
+		return
+	}
+	t.sweptAt = now
+	for uuid, savedAt := range t.savedAt {
+		if now.Sub(savedAt) >= t.cooldown {
+			delete(t.savedAt, uuid)
+		}
+	}
+}
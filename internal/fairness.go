@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/models"
+)
+
+const (
+	defaultFairnessWeight = 1
+	defaultFairnessWindow = time.Hour
+	// maxFairnessProfiles bounds the number of profiles impressions are counted for within a window,
+	// profiles beyond it aren't counted until the window rotates.
+	maxFairnessProfiles = 100000
+)
+
+// WithFeedFairness down-ranks candidates of GetMatches shown widely across users recently, so that
+// less seen ones get exposure. A candidate moves weight positions down per doubling of its impressions
+// within the last one or two windows. Non-positive weight and window mean 1 and 1h. Only candidates of
+// a page are reordered, streamed matches keep the ranked order.
+func WithFeedFairness(weight float64, window time.Duration) Option {
+	return func(a *App) {
+		if weight <= 0 {
+			weight = defaultFairnessWeight
+		}
+		if window <= 0 {
+			window = defaultFairnessWindow
+		}
+		a.fairness = &feedFairness{weight: weight, window: window, maxProfiles: maxFairnessProfiles}
+	}
+}
+
+// feedFairness counts impressions of profiles in all feeds, it does nothing if it's nil.
+type feedFairness struct {
+	weight      float64
+	window      time.Duration
+	maxProfiles int
+
+	mx sync.Mutex
+	// current counts impressions since start, previous those of the window before.
+	start    time.Time
+	current  map[string]int
+	previous map[string]int
+}
+
+// apply reorders the profiles in place by their ranked positions pushed down by their impressions,
+// profiles ending up at the same position keep their ranked order.
+func (f *feedFairness) apply(profiles []*models.Profile, now time.Time) {
+	if f == nil || len(profiles) < 2 {
+		return
+	}
+	f.mx.Lock()
+	f.rotate(now)
+	positions := make(map[*models.Profile]float64, len(profiles))
+	for i, p := range profiles {
+		impressions := f.current[p.UUID] + f.previous[p.UUID]
+		positions[p] = float64(i) + f.weight*math.Log2(1+float64(impressions))
+	}
+	f.mx.Unlock()
+	sort.SliceStable(profiles, func(i, j int) bool {
+		return positions[profiles[i]] < positions[profiles[j]]
+	})
+}
+
+// record counts an impression of every profile.
+func (f *feedFairness) record(profiles []*models.Profile, now time.Time) {
+	if f == nil {
+		return
+	}
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.rotate(now)
+	for _, p := range profiles {
+		if _, ok := f.current[p.UUID]; ok || len(f.current) < f.maxProfiles {
+			f.current[p.UUID]++
+		}
+	}
+}
+
+// rotate starts a new window once the current one is over, forgetting impressions of the previous one.
+func (f *feedFairness) rotate(now time.Time) {
+	elapsed := now.Sub(f.start)
+	switch {
+	case f.current == nil || elapsed >= 2*f.window:
+		f.previous = nil
+	case elapsed >= f.window:
+		f.previous = f.current
+	default:
+		return
+	}
+	f.start = now
+	f.current = make(map[string]int)
+}
@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gerladeno/homie-core/internal/models"
+)
+
+const (
+	// defaultMatchStatesPageSize is the page size of the matches screen, maxMatchStatesPageSize keeps pages
+	// as short as those of incoming likes.
+	defaultMatchStatesPageSize = 10
+	maxMatchStatesPageSize     = 100
+	// maxPreviewLength is the number of characters of the last message shown along with a match.
+	maxPreviewLength = 100
+)
+
+// GetMatchesWithState returns up to limit active matches of the user from the offset along with the state
+// of their chats, those with the latest message or match first. Zero limit means the default page size,
+// larger ones than the maximum are capped.
+func (a *App) GetMatchesWithState(ctx context.Context, uuid string, limit, offset int64) ([]*models.MatchWithState, error) { //nolint:lll
+	switch {
+	case limit <= 0:
+		limit = defaultMatchStatesPageSize
+	case limit > maxMatchStatesPageSize:
+		limit = maxMatchStatesPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	matches, err := a.store.ListActiveMatches(ctx, uuid, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("err getting matches with state: %w", err)
+	}
+	for _, match := range matches {
+		if match.Profile != nil {
+			a.projectProfile(match.Profile)
+		}
+		if match.LastMessage != nil {
+			match.LastMessage.Body = preview(match.LastMessage.Body)
+		}
+	}
+	return matches, nil
+}
+
+// preview cuts the body short of maxPreviewLength characters.
+func preview(body string) string {
+	runes := []rune(body)
+	if len(runes) <= maxPreviewLength {
+		return body
+	}
+	return string(runes[:maxPreviewLength-1]) + "…"
+}
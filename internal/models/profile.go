@@ -1,8 +1,20 @@
 package models
 
 import (
+	"bytes"
 	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gerladeno/homie-core/pkg/common"
 )
 
 type Gender int8
@@ -27,17 +39,185 @@ func (g *Gender) Scan(src interface{}) error {
 	return nil
 }
 
+// Personal.Gender is who the user is and SearchCriteria.Gender who they look for, Any of criteria
+// looks for everyone. Both sides of a match look for one another.
 const (
 	Any Gender = iota
 	Male
 	Female
 )
 
+// Valid tells whether the gender is a known one.
+func (g Gender) Valid() bool {
+	return g >= Any && g <= Female
+}
+
+const dateLayout = "2006-01-02"
+
+// Date is a calendar date without time of day, serialized as YYYY-MM-DD.
+type Date struct {
+	time.Time
+}
+
+func NewDate(year int, month time.Month, day int) Date {
+	return Date{Time: time.Date(year, month, day, 0, 0, 0, 0, time.UTC)}
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Format(dateLayout))
+}
+
+func (d *Date) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("err parsing date: %w", err)
+	}
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return fmt.Errorf("err parsing date: %w", err)
+	}
+	d.Time = t
+	return nil
+}
+
+func (d Date) Value() (driver.Value, error) {
+	return d.Time, nil
+}
+
+func (d *Date) Scan(src interface{}) error {
+	if src == nil {
+		return nil
+	}
+	t, ok := src.(time.Time)
+	if !ok {
+		return fmt.Errorf("err scanning date")
+	}
+	*d = NewDate(t.Year(), t.Month(), t.Day())
+	return nil
+}
+
+// AgeAt returns full years passed since the date till now. Both are compared as calendar dates in UTC,
+// so a person born on Feb 29 gets one year older on Mar 1 in non-leap years.
+func (d Date) AgeAt(now time.Time) int {
+	now = now.UTC()
+	age := now.Year() - d.Year()
+	if now.Month() < d.Month() || (now.Month() == d.Month() && now.Day() < d.Day()) {
+		age--
+	}
+	return age
+}
+
 type Config struct {
-	UUID     string          `json:"uuid,omitempty"`
+	UUID string `json:"uuid,omitempty"`
+	// Version grows with every change of the config, including its photos. It's ignored on saves.
+	Version  int64           `json:"version,omitempty"`
 	Personal *Personal       `json:"personal,omitempty"`
 	Criteria *SearchCriteria `json:"criteria,omitempty"`
-	Settings *Settings       `json:"settings,omitempty"`
+	// PauseUntil hides the user from matches until the time passes.
+	PauseUntil *common.Timestamp `json:"pause_until,omitempty"`
+	// Travel searches for matches in another place for a while.
+	Travel *Travel `json:"travel,omitempty"`
+}
+
+// Travel overrides the user's regions and location in their matches until ExpiresAt.
+// Coordinates are optional, without them distances are shown from the real location.
+type Travel struct {
+	RegionID  int64            `json:"region_id"`
+	Latitude  *float64         `json:"latitude,omitempty"`
+	Longitude *float64         `json:"longitude,omitempty"`
+	ExpiresAt common.Timestamp `json:"expires_at"`
+}
+
+// Active reports whether the travel is in effect at the moment.
+func (t *Travel) Active(now time.Time) bool {
+	return t != nil && t.ExpiresAt.After(now)
+}
+
+func (t *Travel) Validate() error {
+	switch {
+	case t.RegionID <= 0:
+		return fmt.Errorf("%w: region_id is required", common.ErrInvalidTravel)
+	case (t.Latitude == nil) != (t.Longitude == nil):
+		return fmt.Errorf("%w: latitude and longitude must be set together", common.ErrInvalidTravel)
+	case t.Latitude != nil && (math.Abs(*t.Latitude) > 90 || math.Abs(*t.Longitude) > 180):
+		return fmt.Errorf("%w: coordinates are out of range", common.ErrInvalidTravel)
+	case t.ExpiresAt.IsZero():
+		return fmt.Errorf("%w: expires_at is required", common.ErrInvalidTravel)
+	}
+	return nil
+}
+
+// Paused reports whether the user is hidden from matches at the moment.
+func (c *Config) Paused(now time.Time) bool {
+	return c.PauseUntil != nil && c.PauseUntil.After(now)
+}
+
+// Patch applies a JSON merge patch (RFC 7386) to the config. The result has only the sections present
+// in the patch, so that saving it leaves other sections untouched, and keeps PauseUntil and Travel unless
+// they are patched.
+func (c *Config) Patch(patch []byte) (*Config, error) {
+	var patchDoc, doc interface{}
+	if err := unmarshalNumbers(patch, &patchDoc); err != nil {
+		return nil, fmt.Errorf("%w: %v", common.ErrInvalidPatch, err)
+	}
+	fields, ok := patchDoc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: patch must be an object", common.ErrInvalidPatch)
+	}
+	current, err := json.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("err marshaling config: %w", err)
+	}
+	if err = unmarshalNumbers(current, &doc); err != nil {
+		return nil, fmt.Errorf("err unmarshaling config: %w", err)
+	}
+	merged, err := json.Marshal(mergePatch(doc, patchDoc))
+	if err != nil {
+		return nil, fmt.Errorf("err marshaling patched config: %w", err)
+	}
+	var result Config
+	if err = json.Unmarshal(merged, &result); err != nil {
+		return nil, fmt.Errorf("%w: %v", common.ErrInvalidPatch, err)
+	}
+	if _, ok = fields["personal"]; !ok {
+		result.Personal = nil
+	}
+	if _, ok = fields["criteria"]; !ok {
+		result.Criteria = nil
+	}
+	if _, ok = fields["pause_until"]; !ok {
+		result.PauseUntil = c.PauseUntil
+	}
+	if _, ok = fields["travel"]; !ok {
+		result.Travel = c.Travel
+	}
+	return &result, nil
+}
+
+// unmarshalNumbers keeps numbers as json.Number so that large ones don't lose precision.
+func unmarshalNumbers(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func mergePatch(doc, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	docObj, ok := doc.(map[string]interface{})
+	if !ok {
+		docObj = make(map[string]interface{}, len(patchObj))
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(docObj, key)
+			continue
+		}
+		docObj[key] = mergePatch(docObj[key], value)
+	}
+	return docObj
 }
 
 func (c *Config) SetUUID(uuid string) {
@@ -48,15 +228,50 @@ func (c *Config) SetUUID(uuid string) {
 	if c.Criteria != nil {
 		c.Criteria.UUID = uuid
 	}
-	if c.Settings != nil {
-		c.Settings.UUID = uuid
+}
+
+const (
+	WarningEmptyBio      = "empty_bio"
+	WarningWideAgeRange  = "wide_age_range"
+	WarningFlaggedText   = "flagged_text"
+	wideAgeRangeMaxYears = 30
+)
+
+// Warning is a non-blocking remark on a saved config a client may show as a suggestion.
+type Warning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (c *Config) Warnings() []Warning {
+	var warnings []Warning
+	if c.Personal != nil && c.Personal.Bio == "" {
+		warnings = append(warnings, Warning{Code: WarningEmptyBio, Message: "profiles with a bio get more likes"})
+	}
+	if c.Criteria != nil {
+		r := c.Criteria.AgeRange
+		if r.From != nil && r.To != nil && *r.To-*r.From > wideAgeRangeMaxYears {
+			warnings = append(warnings, Warning{Code: WarningWideAgeRange, Message: "age range is very wide"})
+		}
 	}
+	return warnings
 }
 
 type Profile struct {
-	UUID     string          `json:"uuid,omitempty"`
-	Personal *Personal       `json:"personal,omitempty"`
-	Criteria *SearchCriteria `json:"criteria,omitempty"`
+	UUID       string          `json:"uuid,omitempty"`
+	Personal   *Personal       `json:"personal,omitempty"`
+	Criteria   *SearchCriteria `json:"criteria,omitempty"`
+	DistanceKm *float64        `json:"distance_km,omitempty"`
+	// ConversationID is set for profiles listed as chats.
+	ConversationID string `json:"conversation_id,omitempty"`
+	// UnreadCount is the count of messages the user hasn't read, set for profiles listed as chats.
+	UnreadCount *int64 `json:"unread_count,omitempty"`
+	// SuperLikedYou is set for profiles in the feed which super liked the user.
+	SuperLikedYou bool `json:"super_liked_you,omitempty"`
+	// SecondChance is set for profiles in the feed the user disliked long ago, which are shown again.
+	SecondChance bool `json:"second_chance,omitempty"`
+	// JoinedAt is when the user first saved their config, so that clients can badge new users.
+	JoinedAt *common.Timestamp `json:"joined_at,omitempty"`
 }
 
 type Personal struct {
@@ -65,6 +280,697 @@ type Personal struct {
 	AvatarLink string `json:"avatar_link"`
 	Gender     Gender `json:"gender"`
 	Age        int8   `json:"age"`
+	Bio        string `json:"bio"`
+	// Bios are translations of the bio by language code, the bio itself is the default one.
+	Bios      map[string]string `json:"bios,omitempty"`
+	Birthdate *Date             `json:"birthdate,omitempty"`
+	Latitude  *float64          `json:"latitude,omitempty"`
+	Longitude *float64          `json:"longitude,omitempty"`
+	// Photos are ordered, the first one is the primary.
+	Photos []*Photo `json:"photos,omitempty" db:"-"`
+}
+
+// Normalize trims free-text fields and collapses runs of whitespace in them, keeping line breaks of the bio.
+// Fields in titleCase are title-cased. Normalizing a normalized profile changes nothing.
+func (p *Personal) Normalize(titleCase map[string]bool) {
+	p.Username = collapseSpaces(p.Username)
+	p.AvatarLink = strings.TrimSpace(p.AvatarLink)
+	p.Bio = normalizedBio(p.Bio, titleCase[FieldBio])
+	if titleCase[FieldUsername] {
+		p.Username = titleCased(p.Username)
+	}
+	// language codes are lower-cased and empty translations dropped
+	var bios map[string]string
+	for lang, bio := range p.Bios {
+		if bio = normalizedBio(bio, titleCase[FieldBio]); bio != "" {
+			if bios == nil {
+				bios = make(map[string]string, len(p.Bios))
+			}
+			bios[strings.ToLower(strings.TrimSpace(lang))] = bio
+		}
+	}
+	p.Bios = bios
+}
+
+func normalizedBio(s string, titleCase bool) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	bio := lines[:0]
+	for _, line := range lines {
+		line = collapseSpaces(line)
+		// a single blank line separates paragraphs
+		if line == "" && len(bio) > 0 && bio[len(bio)-1] == "" {
+			continue
+		}
+		bio = append(bio, line)
+	}
+	if titleCase {
+		return titleCased(strings.Join(bio, "\n"))
+	}
+	return strings.Join(bio, "\n")
+}
+
+// Localize replaces the bio with its translation to the first of the languages the user has one for,
+// keeping the default bio otherwise. Translations are dropped, so that other users see a single bio.
+func (p *Personal) Localize(languages []string) {
+	if p == nil {
+		return
+	}
+	for _, lang := range languages {
+		if bio, ok := p.Bios[lang]; ok {
+			p.Bio = bio
+			break
+		}
+	}
+	p.Bios = nil
+}
+
+func collapseSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// titleCased upper-cases the first letter of every word and lower-cases the others.
+func titleCased(s string) string {
+	b := []rune(strings.ToLower(s))
+	start := true
+	for i, r := range b {
+		if start {
+			b[i] = unicode.ToUpper(r)
+		}
+		start = unicode.IsSpace(r) || r == '-'
+	}
+	return string(b)
+}
+
+type Photo struct {
+	ID       int64  `json:"id"`
+	Link     string `json:"link"`
+	Position int16  `json:"position"`
+}
+
+// PhotoUpload is a presigned URL a photo is uploaded to as a multipart form of Fields followed by the file
+// before ExpiresAt, then confirmed by the key.
+type PhotoUpload struct {
+	Key       string            `json:"key"`
+	URL       string            `json:"url"`
+	Fields    map[string]string `json:"fields"`
+	ExpiresAt common.Timestamp  `json:"expires_at"`
+}
+
+// Profile fields which may be exposed to other users.
+const (
+	FieldUsername   = "username"
+	FieldAvatarLink = "avatar_link"
+	FieldGender     = "gender"
+	FieldAge        = "age"
+	FieldBio        = "bio"
+	FieldBirthdate  = "birthdate"
+	FieldLocation   = "location"
+	FieldPhotos     = "photos"
+	FieldCriteria   = "criteria"
+)
+
+// DefaultPublicFields withholds exact birthdate and location from other users.
+var DefaultPublicFields = []string{
+	FieldUsername, FieldAvatarLink, FieldGender, FieldAge, FieldBio, FieldPhotos, FieldCriteria,
+}
+
+// Project clears every field of the profile not present in the allowlist.
+func (p *Profile) Project(fields map[string]bool) {
+	if p == nil {
+		return
+	}
+	if !fields[FieldCriteria] {
+		p.Criteria = nil
+	}
+	personal := p.Personal
+	if personal == nil {
+		return
+	}
+	if !fields[FieldUsername] {
+		personal.Username = ""
+	}
+	if !fields[FieldAvatarLink] {
+		personal.AvatarLink = ""
+	}
+	if !fields[FieldGender] {
+		personal.Gender = Any
+	}
+	if !fields[FieldAge] {
+		personal.Age = 0
+	}
+	if !fields[FieldBio] {
+		personal.Bio, personal.Bios = "", nil
+	}
+	if !fields[FieldBirthdate] {
+		personal.Birthdate = nil
+	}
+	if !fields[FieldLocation] {
+		personal.Latitude, personal.Longitude = nil, nil
+	}
+	if !fields[FieldPhotos] {
+		personal.Photos = nil
+	}
+}
+
+func (p *Personal) HasLocation() bool {
+	return p != nil && p.Latitude != nil && p.Longitude != nil
+}
+
+const earthRadiusKm = 6371.0
+
+// DistanceKm returns the great-circle distance between two personal locations.
+// Both must have a location.
+func (p *Personal) DistanceKm(other *Personal) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	lat1, lat2 := toRad(*p.Latitude), toRad(*other.Latitude)
+	dLat := lat2 - lat1
+	dLon := toRad(*other.Longitude - *p.Longitude)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+// FeedSort is an order of candidates in matches.
+type FeedSort string
+
+const (
+	// FeedSortBest orders candidates by relevance score.
+	FeedSortBest FeedSort = "best"
+	// FeedSortNewest orders candidates by the time they joined, the most recent first.
+	FeedSortNewest FeedSort = "newest"
+)
+
+func (s FeedSort) Valid() bool {
+	return s == FeedSortBest || s == FeedSortNewest
+}
+
+// SoftFilters tells which search criteria rank candidates meeting them first instead of excluding
+// the rest, the zero value excludes by every criterion. Gender and regions always exclude.
+type SoftFilters struct {
+	// Age covers both the candidate's age within the user's range and the other way round.
+	Age   bool
+	Price bool
+}
+
+// MatchQuery tells which candidates of the user's feed are listed and in which order.
+type MatchQuery struct {
+	// Count is the maximum number of candidates.
+	Count int64
+	// Now is the moment paused candidates are left out at.
+	Now time.Time
+	// UnmatchedSince leaves out pairs unmatched after it in either direction.
+	UnmatchedSince time.Time
+	// JoinedSince leaves out candidates who joined before it unless it's zero.
+	JoinedSince time.Time
+	Sort        FeedSort
+	Soft        SoftFilters
+	// SuperLikesFirst puts candidates who super liked the user first in the best order.
+	SuperLikesFirst bool
+	// ShuffleSeed shuffles best sorted candidates within the same tier unless it's empty.
+	ShuffleSeed string
+}
+
+type Match struct {
+	Profile     *Profile         `json:"profile"`
+	MatchedAt   common.Timestamp `json:"matched_at"`
+	SuperLike   bool             `json:"super_like"`
+	HasMessages bool             `json:"has_messages"`
+}
+
+// MatchWithState is an active match along with the state of the chat with them.
+type MatchWithState struct {
+	Match
+	// LastMessage is the latest message of the chat, nil if messaging hasn't started.
+	LastMessage *MessagePreview `json:"last_message,omitempty"`
+	// UnreadCount is the number of messages of the match the user hasn't read.
+	UnreadCount int64 `json:"unread_count"`
+}
+
+// ConversationInit is the first frame of a chat connection, enough to render the header of the chat.
+type ConversationInit struct {
+	Type           string `json:"type"`
+	ConversationID string `json:"conversation_id"`
+	// Peer is the public profile of the peer, nil if either of them has blocked the other.
+	Peer        *Profile `json:"peer"`
+	UnreadCount int64    `json:"unread_count"`
+	// LastReadID is the id of the latest message the user has read, zero if there is none.
+	LastReadID int64 `json:"last_read_id"`
+}
+
+// MessagePreview is the start of a message, the body is cut short of long ones.
+type MessagePreview struct {
+	Sender    string           `json:"sender"`
+	Timestamp common.Timestamp `json:"timestamp"`
+	Body      string           `json:"body"`
+}
+
+// SharedAttributes are what a user has in common with a match. PriceRange is the overlap of their budgets,
+// nil if they don't overlap or neither is bounded.
+type SharedAttributes struct {
+	Regions    []int64 `json:"regions"`
+	PriceRange *Range  `json:"price_range,omitempty"`
+}
+
+// Shared returns attributes the profiles have in common by their search criteria.
+func Shared(p1, p2 *Profile) *SharedAttributes {
+	shared := &SharedAttributes{Regions: []int64{}}
+	if p1.Criteria == nil || p2.Criteria == nil {
+		return shared
+	}
+	shared.Regions = commonRegions(p1.Criteria.Regions, p2.Criteria.Regions)
+	shared.PriceRange = p1.Criteria.PriceRange.Overlap(p2.Criteria.PriceRange)
+	return shared
+}
+
+// commonRegions returns sorted regions present in both lists, empty if there are none.
+func commonRegions(r1, r2 []int64) []int64 {
+	regions := make(map[int64]bool, len(r2))
+	for _, region := range r2 {
+		regions[region] = true
+	}
+	shared := []int64{}
+	for _, region := range r1 {
+		if regions[region] {
+			shared = append(shared, region)
+			delete(regions, region)
+		}
+	}
+	sort.Slice(shared, func(i, j int) bool { return shared[i] < shared[j] })
+	return shared
+}
+
+// CommonContext is what a user has in common with a match without identifying anyone else.
+type CommonContext struct {
+	// Regions are searched by both users.
+	Regions []int64 `json:"regions"`
+	// ConnectionRegions are searched by users both of them have an active match with. A region is listed
+	// only when several such users search in it, so that none of them can be singled out.
+	ConnectionRegions []int64 `json:"connection_regions"`
+	// Availability are windows from now on when both users are matched in the same regions.
+	Availability []*AvailabilityWindow `json:"availability"`
+}
+
+// AvailabilityWindow is a time both users are matched in the regions. Until is empty if the window doesn't end.
+type AvailabilityWindow struct {
+	From    common.Timestamp  `json:"from"`
+	Until   *common.Timestamp `json:"until,omitempty"`
+	Regions []int64           `json:"regions"`
+}
+
+// availability is a time the user is matched in the regions, zero until means it doesn't end.
+type availability struct {
+	from, until time.Time
+	regions     []int64
+}
+
+// availability returns when the user is matched from now on: nowhere while paused, in the travel region
+// until the travel expires and in their own regions after that.
+func (c *Config) availability(now time.Time) []availability {
+	from := now
+	if c.Paused(from) {
+		from = c.PauseUntil.Time
+	}
+	var windows []availability
+	if c.Travel.Active(from) {
+		until := c.Travel.ExpiresAt.Time
+		windows = append(windows, availability{from: from, until: until, regions: []int64{c.Travel.RegionID}})
+		from = c.Travel.ExpiresAt.Time
+	}
+	var regions []int64
+	if c.Criteria != nil {
+		regions = c.Criteria.Regions
+	}
+	return append(windows, availability{from: from, regions: regions})
+}
+
+// Common returns the regions and availability the configs have in common, ConnectionRegions are left empty.
+func Common(c1, c2 *Config, now time.Time) *CommonContext {
+	result := &CommonContext{Regions: []int64{}, ConnectionRegions: []int64{}}
+	if c1.Criteria != nil && c2.Criteria != nil {
+		result.Regions = commonRegions(c1.Criteria.Regions, c2.Criteria.Regions)
+	}
+	result.Availability = commonAvailability(c1, c2, now)
+	return result
+}
+
+// commonAvailability returns windows from now on when both users are matched in the same regions,
+// earliest first. Only the overlaps are returned, not when either of the users is paused or travels.
+func commonAvailability(c1, c2 *Config, now time.Time) []*AvailabilityWindow {
+	windows := []*AvailabilityWindow{}
+	for _, a1 := range c1.availability(now) {
+		for _, a2 := range c2.availability(now) {
+			from, until := a1.from, a1.until
+			if a2.from.After(from) {
+				from = a2.from
+			}
+			if until.IsZero() || !a2.until.IsZero() && a2.until.Before(until) {
+				until = a2.until
+			}
+			if !until.IsZero() && !from.Before(until) {
+				continue
+			}
+			regions := commonRegions(a1.regions, a2.regions)
+			if len(regions) == 0 {
+				continue
+			}
+			window := &AvailabilityWindow{From: common.NewTimestamp(from), Regions: regions}
+			if !until.IsZero() {
+				window.Until = common.NewTimestampPtr(&until)
+			}
+			windows = append(windows, window)
+		}
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].From.Before(windows[j].From.Time) })
+	return windows
+}
+
+// Boost raises the user in matches of others for a while.
+type Boost struct {
+	StartedAt common.Timestamp `json:"started_at"`
+	ExpiresAt common.Timestamp `json:"expires_at"`
+}
+
+// BoostStatus tells whether the last boost of the user is active. RemainingSeconds and PositionBucket are set
+// only while it is. PositionBucket roughly tells among how many first boosted candidates others see the user,
+// it's a power of ten from 10 up.
+type BoostStatus struct {
+	Active           bool   `json:"active"`
+	Boost            *Boost `json:"boost,omitempty"`
+	RemainingSeconds int64  `json:"remaining_seconds,omitempty"`
+	PositionBucket   int64  `json:"position_bucket,omitempty"`
+	// AvailableAt is when a new boost may be started, empty if right away.
+	AvailableAt *common.Timestamp `json:"available_at,omitempty"`
+}
+
+const NotificationMatches = "matches"
+
+// Notification tells the recipient about something that happened while they may be offline. A matches one
+// lists the users matched with since the previous one, Text is what is shown to the recipient.
+type Notification struct {
+	Type      string   `json:"type"`
+	Recipient string   `json:"recipient"`
+	Count     int      `json:"count"`
+	Targets   []string `json:"targets"`
+	Text      string   `json:"text"`
+}
+
+// Limits are server limits clients adapt to, zero maximums mean unlimited.
+type Limits struct {
+	MinAge                int     `json:"min_age"`
+	MaxActiveMatches      int64   `json:"max_active_matches"`
+	MaxPendingLikes       int64   `json:"max_pending_likes"`
+	MaxMatchesCount       int64   `json:"max_matches_count"`
+	MaxStreamMatchesCount int64   `json:"max_stream_matches_count"`
+	MaxRegions            int     `json:"max_regions"`
+	MaxBudget             float64 `json:"max_budget"`
+	MaxPhotos             int     `json:"max_photos"`
+}
+
+// Quota is what the user may still do before hitting the limits at the moment.
+type Quota struct {
+	// PendingLikesRemaining is how many more likes may go unreciprocated, null if they are unlimited.
+	PendingLikesRemaining *int64 `json:"pending_likes_remaining"`
+	// SwipesAvailableAt is when likes and dislikes are accepted again after swiping too fast, empty if right away.
+	SwipesAvailableAt *common.Timestamp `json:"swipes_available_at,omitempty"`
+}
+
+// ConfigSchema describes constraints SaveConfig puts on config fields, so that clients can build forms
+// agreeing with the server. Zero maximums are unlimited.
+type ConfigSchema struct {
+	Personal PersonalSchema `json:"personal"`
+	Criteria CriteriaSchema `json:"criteria"`
+}
+
+type PersonalSchema struct {
+	// Genders are those a user may be of, the gender is required.
+	Genders []Gender `json:"genders"`
+	// MinAge and MaxAge bound the age at the birthdate.
+	MinAge int `json:"min_age"`
+	MaxAge int `json:"max_age"`
+	// MaxUsernameLength is the number of characters of the username, ASCIIUsername tells if only printable
+	// ASCII ones are allowed.
+	MaxUsernameLength int  `json:"max_username_length"`
+	ASCIIUsername     bool `json:"ascii_username"`
+	// MaxBioLength is the number of characters of the bio and of each of its translations.
+	MaxBioLength int `json:"max_bio_length"`
+	// BioLanguages are the language codes bios may be translated to, sorted.
+	BioLanguages []string `json:"bio_languages"`
+}
+
+type CriteriaSchema struct {
+	// Genders are those a user may look for, Any looks for everyone.
+	Genders    []Gender `json:"genders"`
+	MaxRegions int      `json:"max_regions"`
+	// MinBudget and MaxBudget bound both ends of the price range.
+	MinBudget float64 `json:"min_budget"`
+	MaxBudget float64 `json:"max_budget"`
+}
+
+// MatchRepair summarizes what RepairMatches fixed: counts of active matches which drifted, counts of users
+// who no longer exist and chats of users who don't like each other anymore.
+type MatchRepair struct {
+	FixedCounts    int64 `json:"fixed_counts"`
+	OrphanedCounts int64 `json:"orphaned_counts"`
+	UnmatchedChats int64 `json:"unmatched_chats"`
+}
+
+// Identity kinds a ban is keyed on.
+const (
+	// BanSubject is the sub claim of access tokens which stays the same when a user registers again.
+	BanSubject BanKind = "subject"
+	// BanDevice is the device fingerprint sent in the device_id claim of access tokens.
+	BanDevice BanKind = "device"
+)
+
+type BanKind string
+
+// Ban forbids authentication to everyone with the identity.
+type Ban struct {
+	Kind      BanKind          `json:"kind"`
+	Value     string           `json:"value"`
+	Reason    string           `json:"reason,omitempty"`
+	CreatedAt common.Timestamp `json:"created_at"`
+}
+
+func (b *Ban) Validate() error {
+	switch {
+	case b.Kind != BanSubject && b.Kind != BanDevice:
+		return fmt.Errorf("%w: unknown kind %q", common.ErrInvalidBan, b.Kind)
+	case b.Value == "":
+		return fmt.Errorf("%w: empty value", common.ErrInvalidBan)
+	}
+	return nil
+}
+
+// RevokedToken is an access token rejected by its jti claim. ExpiresAt is the exp claim of the token,
+// the revocation is kept until then, forever if it's nil.
+type RevokedToken struct {
+	JTI       string            `json:"jti"`
+	RevokedAt common.Timestamp  `json:"revoked_at"`
+	ExpiresAt *common.Timestamp `json:"expires_at,omitempty"`
+}
+
+// Actions of audit entries.
+const (
+	AuditImpersonationStarted = "impersonation_started"
+	AuditImpersonatedRequest  = "impersonated_request"
+	AuditImpersonatedRejected = "impersonated_request_rejected"
+)
+
+// AuditEntry is an action an admin took on behalf of a user.
+type AuditEntry struct {
+	ID     int64            `json:"id"`
+	At     common.Timestamp `json:"at"`
+	Actor  string           `json:"actor"`
+	Action string           `json:"action"`
+	// Subject is the uuid of the user acted as.
+	Subject string `json:"subject"`
+	// TokenID is the jti claim of the impersonation token, which ties requests to the start of impersonation.
+	TokenID string `json:"token_id"`
+	Method  string `json:"method,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Validate checks the entry names who did what as whom.
+func (e *AuditEntry) Validate() error {
+	switch {
+	case e.Actor == "":
+		return fmt.Errorf("%w: empty actor", common.ErrInvalidAuditEntry)
+	case e.Action == "":
+		return fmt.Errorf("%w: empty action", common.ErrInvalidAuditEntry)
+	case e.TokenID == "":
+		return fmt.Errorf("%w: empty token id", common.ErrInvalidAuditEntry)
+	case !common.IsValidUUID(e.Subject):
+		return fmt.Errorf("%w: invalid subject %q", common.ErrInvalidAuditEntry, e.Subject)
+	}
+	return nil
+}
+
+// Impersonation is a short-lived token acting as the user on behalf of the admin.
+type Impersonation struct {
+	Token          string           `json:"token"`
+	UUID           string           `json:"uuid"`
+	ImpersonatedBy string           `json:"impersonated_by"`
+	ExpiresAt      common.Timestamp `json:"expires_at"`
+}
+
+// maxReportReasonLength caps reasons of reports in bytes.
+const maxReportReasonLength = 1000
+
+// MessageReport is a report of an abusive chat message for moderators. Sender, Body and SentAt are
+// copied from the message when it is reported.
+type MessageReport struct {
+	ID        int64            `json:"id"`
+	Reporter  string           `json:"reporter"`
+	MessageID int64            `json:"message_id"`
+	Sender    string           `json:"sender"`
+	Body      string           `json:"body"`
+	SentAt    common.Timestamp `json:"sent_at"`
+	Reason    string           `json:"reason"`
+	CreatedAt common.Timestamp `json:"created_at"`
+}
+
+func (r *MessageReport) Validate() error {
+	switch {
+	case strings.TrimSpace(r.Reason) == "":
+		return fmt.Errorf("%w: empty reason", common.ErrInvalidReport)
+	case len(r.Reason) > maxReportReasonLength:
+		return fmt.Errorf("%w: reason is longer than %d bytes", common.ErrInvalidReport, maxReportReasonLength)
+	}
+	return nil
+}
+
+// Push platforms of devices.
+const (
+	PlatformIOS     DevicePlatform = "ios"
+	PlatformAndroid DevicePlatform = "android"
+	PlatformWeb     DevicePlatform = "web"
+)
+
+type DevicePlatform string
+
+// maxDeviceTokenLength caps push tokens in bytes, those of every platform are much shorter.
+const maxDeviceTokenLength = 4096
+
+// Device is a push token of the user's device. A token is registered to a single user.
+type Device struct {
+	ID        int64            `json:"id"`
+	Platform  DevicePlatform   `json:"platform"`
+	Token     string           `json:"token"`
+	CreatedAt common.Timestamp `json:"created_at"`
+}
+
+func (d *Device) Validate() error {
+	switch {
+	case d.Platform != PlatformIOS && d.Platform != PlatformAndroid && d.Platform != PlatformWeb:
+		return fmt.Errorf("%w: unknown platform %q", common.ErrInvalidDevice, d.Platform)
+	case strings.TrimSpace(d.Token) == "":
+		return fmt.Errorf("%w: empty token", common.ErrInvalidDevice)
+	case len(d.Token) > maxDeviceTokenLength:
+		return fmt.Errorf("%w: token is longer than %d bytes", common.ErrInvalidDevice, maxDeviceTokenLength)
+	}
+	return nil
+}
+
+// Decision actions, see Decision.
+const (
+	ActionLike      = "like"
+	ActionSuperLike = "superlike"
+	ActionDislike   = "dislike"
+)
+
+// Relationship statuses of a user to another, see Relationship.
+const (
+	RelationshipNone       = "none"
+	RelationshipLiked      = "liked"
+	RelationshipSuperLiked = "superliked"
+	RelationshipDisliked   = "disliked"
+	RelationshipMatched    = "matched"
+	RelationshipBlocked    = "blocked"
+)
+
+// Relationship is the current status of the user to a target, LikedYou tells whether the target
+// likes the user whatever the status.
+type Relationship struct {
+	Status   string `json:"status"`
+	LikedYou bool   `json:"liked_you"`
+}
+
+// RegionFeed is a sample of candidates searching in a region and the count of them.
+type RegionFeed struct {
+	Count      int64      `json:"count"`
+	Candidates []*Profile `json:"candidates"`
+}
+
+// Total is the number of items of a list, approximate if Estimate is set.
+type Total struct {
+	Count    int64
+	Estimate bool
+}
+
+// Decision is an entry of the user's like and dislike history.
+type Decision struct {
+	Profile   *Profile         `json:"profile"`
+	Action    string           `json:"action"`
+	DecidedAt common.Timestamp `json:"decided_at"`
+}
+
+// IncomingLike is a like of the user they haven't answered with a like yet.
+type IncomingLike struct {
+	// UUID is of the liker, whose profile may be missing.
+	UUID      string           `json:"uuid"`
+	Profile   *Profile         `json:"profile"`
+	SuperLike bool             `json:"super_like"`
+	LikedAt   common.Timestamp `json:"liked_at"`
+}
+
+// ProfileView is the last view of the user's profile by a viewer.
+type ProfileView struct {
+	// UUID is of the viewer, whose profile may be missing.
+	UUID     string           `json:"uuid"`
+	Profile  *Profile         `json:"profile"`
+	ViewedAt common.Timestamp `json:"viewed_at"`
+	// Views counts views apart by more than the dedup window.
+	Views int64 `json:"views"`
+}
+
+// LikesCursor points past the last like of a page of incoming likes, which are ordered by LikedAt then UUID
+// of the liker, both descending. The zero cursor points at the start.
+type LikesCursor struct {
+	LikedAt time.Time
+	UUID    string
+}
+
+// String returns the cursor as an opaque token, empty for the zero cursor.
+func (c LikesCursor) String() string {
+	if c.IsZero() {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", c.LikedAt.UnixNano(), c.UUID)))
+}
+
+func (c LikesCursor) IsZero() bool {
+	return c.LikedAt.IsZero() && c.UUID == ""
+}
+
+// ParseLikesCursor parses a token returned by String, empty token is the zero cursor.
+func ParseLikesCursor(token string) (LikesCursor, error) {
+	if token == "" {
+		return LikesCursor{}, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return LikesCursor{}, common.ErrInvalidCursor
+	}
+	nanos, uuid, ok := strings.Cut(string(b), ":")
+	if !ok || uuid == "" {
+		return LikesCursor{}, common.ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return LikesCursor{}, common.ErrInvalidCursor
+	}
+	return LikesCursor{LikedAt: time.Unix(0, n).UTC(), UUID: uuid}, nil
 }
 
 type Relation struct {
@@ -73,17 +979,163 @@ type Relation struct {
 	Relation int8
 }
 
+// Settings are the user's app preferences, kept apart from the config so that saving either of them
+// doesn't overwrite the other.
 type Settings struct {
 	UUID  string `json:"uuid,omitempty"`
 	Theme int64  `json:"theme"`
+	// Language is a BCP 47 tag like "en" or "pt-BR", empty for the device language.
+	Language string `json:"language"`
+	// Incognito users are shown only to those they liked.
+	Incognito     bool                 `json:"incognito"`
+	Notifications NotificationSettings `json:"notifications"`
+	Privacy       PrivacySettings      `json:"privacy"`
+}
+
+type NotificationSettings struct {
+	Matches  bool `json:"matches"`
+	Messages bool `json:"messages"`
+	Likes    bool `json:"likes"`
+	// QuietHours defer notifications till they are over, nil means none.
+	QuietHours *QuietHours `json:"quiet_hours,omitempty"`
+}
+
+// QuietHours are a daily period from Start to End, times like "22:00" in Timezone, an IANA name like
+// "Europe/Moscow" or empty for UTC. A period with Start later than End spans midnight.
+type QuietHours struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone"`
+}
+
+const clockLayout = "15:04"
+
+func (q *QuietHours) Validate() error {
+	start, err := time.Parse(clockLayout, q.Start)
+	if err != nil {
+		return fmt.Errorf("%w: quiet hours start %q is not like 22:00", common.ErrInvalidSettings, q.Start)
+	}
+	end, err := time.Parse(clockLayout, q.End)
+	if err != nil {
+		return fmt.Errorf("%w: quiet hours end %q is not like 07:00", common.ErrInvalidSettings, q.End)
+	}
+	if start.Equal(end) {
+		return fmt.Errorf("%w: quiet hours start and end at %s", common.ErrInvalidSettings, q.Start)
+	}
+	if _, err = time.LoadLocation(q.Timezone); err != nil {
+		return fmt.Errorf("%w: quiet hours timezone %q is unknown", common.ErrInvalidSettings, q.Timezone)
+	}
+	return nil
+}
+
+// Until returns the end of the quiet hours the moment falls within, false if it falls within none.
+// Invalid quiet hours never apply.
+func (q *QuietHours) Until(now time.Time) (time.Time, bool) {
+	if q.Validate() != nil {
+		return time.Time{}, false
+	}
+	// validated already
+	loc, _ := time.LoadLocation(q.Timezone)
+	start, _ := time.Parse(clockLayout, q.Start)
+	end, _ := time.Parse(clockLayout, q.End)
+	local := now.In(loc)
+	year, month, day := local.Date()
+	at := func(day int, clock time.Time) time.Time {
+		return time.Date(year, month, day, clock.Hour(), clock.Minute(), 0, 0, loc)
+	}
+	startToday, endToday := at(day, start), at(day, end)
+	switch {
+	case start.Before(end):
+		if !local.Before(startToday) && local.Before(endToday) {
+			return endToday, true
+		}
+	case !local.Before(startToday):
+		// the evening part of a period spanning midnight ends tomorrow
+		return at(day+1, end), true
+	case local.Before(endToday):
+		return endToday, true
+	}
+	return time.Time{}, false
+}
+
+type PrivacySettings struct {
+	ShowAge      bool `json:"show_age"`
+	ShowDistance bool `json:"show_distance"`
+}
+
+// DefaultSettings are settings of a user who hasn't saved any.
+func DefaultSettings(uuid string) *Settings {
+	return &Settings{
+		UUID:          uuid,
+		Notifications: NotificationSettings{Matches: true, Messages: true, Likes: true},
+		Privacy:       PrivacySettings{ShowAge: true, ShowDistance: true},
+	}
+}
+
+var languageRe = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+func (s *Settings) Validate() error {
+	if s.Language != "" && !languageRe.MatchString(s.Language) {
+		return fmt.Errorf("%w: language %q is not a BCP 47 tag", common.ErrInvalidSettings, s.Language)
+	}
+	if s.Notifications.QuietHours != nil {
+		return s.Notifications.QuietHours.Validate()
+	}
+	return nil
 }
 
 type SearchCriteria struct {
-	UUID       string  `json:"uuid,omitempty"`
-	Regions    []int64 `json:"regions"`
-	PriceRange Range   `json:"price_range"`
-	Gender     Gender  `json:"gender"`
-	AgeRange   Range   `json:"age_range"`
+	UUID    string  `json:"uuid,omitempty"`
+	Regions []int64 `json:"regions"`
+	// RegionWeights prioritizes candidates from some of the regions, missing ones weigh 1.
+	RegionWeights map[int64]float64 `json:"region_weights,omitempty"`
+	PriceRange    Range             `json:"price_range"`
+	Gender        Gender            `json:"gender"`
+	AgeRange      Range             `json:"age_range"`
+}
+
+// DedupeRegions drops repeated regions keeping the first occurrence of each.
+func (c *SearchCriteria) DedupeRegions() {
+	seen := make(map[int64]bool, len(c.Regions))
+	regions := c.Regions[:0]
+	for _, region := range c.Regions {
+		if !seen[region] {
+			seen[region] = true
+			regions = append(regions, region)
+		}
+	}
+	c.Regions = regions
+}
+
+// NormalizeRegionWeights validates the weights and scales them to sum up to 1 across all the regions.
+func (c *SearchCriteria) NormalizeRegionWeights() error {
+	if c.RegionWeights == nil {
+		return nil
+	}
+	regions := make(map[int64]bool, len(c.Regions))
+	for _, region := range c.Regions {
+		regions[region] = true
+	}
+	for region, weight := range c.RegionWeights {
+		if !regions[region] || weight <= 0 || math.IsInf(weight, 0) || math.IsNaN(weight) {
+			return common.ErrInvalidRegionWeights
+		}
+	}
+	weights := make(map[int64]float64, len(regions))
+	var total float64
+	for region := range regions {
+		weight, ok := c.RegionWeights[region]
+		if !ok {
+			weight = 1
+		}
+		weights[region] = weight
+		total += weight
+	}
+	for region := range weights {
+		weights[region] /= total
+	}
+	c.RegionWeights = weights
+	return nil
 }
 
 type Region struct {
@@ -92,11 +1144,80 @@ type Region struct {
 	Description string `json:"description"`
 }
 
+func (r *Region) Validate() error {
+	switch {
+	case r.ID < 0:
+		return fmt.Errorf("%w: negative id", common.ErrInvalidRegion)
+	case strings.TrimSpace(r.Name) == "":
+		return fmt.Errorf("%w: empty name", common.ErrInvalidRegion)
+	}
+	return nil
+}
+
+// RegionStats shows supply and demand in a region: users searching there and their likes and matches.
+type RegionStats struct {
+	RegionID    int64  `json:"region_id"`
+	Name        string `json:"name"`
+	ActiveUsers int64  `json:"active_users"`
+	Likes       int64  `json:"likes"`
+	Matches     int64  `json:"matches"`
+}
+
+// DayBucket counts the user's likes and matches of a day in UTC.
+type DayBucket struct {
+	Day           Date  `json:"day"`
+	LikesSent     int64 `json:"likes_sent"`
+	LikesReceived int64 `json:"likes_received"`
+	Matches       int64 `json:"matches"`
+}
+
+// TimeRange restricts lists to what happened within it, both bounds included. A zero bound is unbounded.
+type TimeRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// Valid tells whether From isn't after To.
+func (r TimeRange) Valid() bool {
+	return r.From.IsZero() || r.To.IsZero() || !r.From.After(r.To)
+}
+
 type Range struct {
 	From *float64 `json:"from,omitempty"`
 	To   *float64 `json:"to,omitempty"`
 }
 
+// Overlap returns the range within both, nil if they don't overlap or neither is bounded.
+func (r Range) Overlap(other Range) *Range {
+	var overlap Range
+	for _, from := range []*float64{r.From, other.From} {
+		if from != nil && (overlap.From == nil || *from > *overlap.From) {
+			overlap.From = from
+		}
+	}
+	for _, to := range []*float64{r.To, other.To} {
+		if to != nil && (overlap.To == nil || *to < *overlap.To) {
+			overlap.To = to
+		}
+	}
+	switch {
+	case overlap.From == nil && overlap.To == nil:
+		return nil
+	case overlap.From != nil && overlap.To != nil && *overlap.From > *overlap.To:
+		return nil
+	}
+	// bounds are copied so that the overlap doesn't alias the ranges
+	if overlap.From != nil {
+		from := *overlap.From
+		overlap.From = &from
+	}
+	if overlap.To != nil {
+		to := *overlap.To
+		overlap.To = &to
+	}
+	return &overlap
+}
+
 func NewRange(from, to float64) Range {
 	r := Range{}
 	if from != 0 {
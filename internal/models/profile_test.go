@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/gerladeno/homie-core/pkg/common"
 	"github.com/stretchr/testify/require"
 )
 
@@ -26,10 +28,6 @@ func TestConfig(t *testing.T) {
 				Gender:     0,
 				AgeRange:   NewRange(20, 35),
 			},
-			Settings: &Settings{
-				UUID:  "797bcfb5-ca07-11ec-a6c3-049226c2eb3c",
-				Theme: 0,
-			},
 		}
 		b, err := json.Marshal(conf)
 		require.NoError(t, err)
@@ -52,12 +50,333 @@ func TestConfigMinimal(t *testing.T) {
 				Gender:     0,
 				AgeRange:   NewRange(20, 35),
 			},
-			Settings: &Settings{
-				Theme: 0,
-			},
 		}
 		b, err := json.Marshal(conf)
 		require.NoError(t, err)
 		fmt.Println(string(b))
 	})
 }
+
+func TestShared(t *testing.T) {
+	own := &Profile{UUID: "first", Criteria: &SearchCriteria{Regions: []int64{3, 1, 2}, PriceRange: NewRange(20000, 50000)}}
+	tests := []struct {
+		name     string
+		criteria *SearchCriteria
+		expected *SharedAttributes
+	}{
+		{"partial overlap", &SearchCriteria{Regions: []int64{4, 2, 3}, PriceRange: NewRange(40000, 70000)},
+			&SharedAttributes{Regions: []int64{2, 3}, PriceRange: &Range{From: floatPtr(40000), To: floatPtr(50000)}}},
+		{"open budget", &SearchCriteria{Regions: []int64{1}, PriceRange: NewRange(30000, 0)},
+			&SharedAttributes{Regions: []int64{1}, PriceRange: &Range{From: floatPtr(30000), To: floatPtr(50000)}}},
+		{"nothing shared", &SearchCriteria{Regions: []int64{5}, PriceRange: NewRange(60000, 70000)},
+			&SharedAttributes{Regions: []int64{}}},
+		{"no criteria", nil, &SharedAttributes{Regions: []int64{}}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, Shared(own, &Profile{UUID: "second", Criteria: tt.criteria}))
+		})
+	}
+	require.Nil(t, Range{}.Overlap(Range{}), "unbounded budgets have nothing to show")
+}
+
+func TestCommon(t *testing.T) {
+	now := time.Date(2022, time.June, 27, 12, 0, 0, 0, time.UTC)
+	ts := func(d time.Duration) common.Timestamp { return common.NewTimestamp(now.Add(d)) }
+	day := 24 * time.Hour
+	pause, travelUntil := ts(2*day), ts(10*day)
+	travelling := &Config{
+		UUID:     "first",
+		Criteria: &SearchCriteria{Regions: []int64{1, 2}},
+		Travel:   &Travel{RegionID: 5, ExpiresAt: travelUntil},
+	}
+	paused := &Config{UUID: "second", Criteria: &SearchCriteria{Regions: []int64{5, 2}}, PauseUntil: &pause}
+
+	require.Equal(t, &CommonContext{
+		Regions:           []int64{2},
+		ConnectionRegions: []int64{},
+		Availability: []*AvailabilityWindow{
+			{From: pause, Until: &travelUntil, Regions: []int64{5}},
+			{From: travelUntil, Regions: []int64{2}},
+		},
+	}, Common(travelling, paused, now))
+
+	elsewhere := &Config{UUID: "third", Criteria: &SearchCriteria{Regions: []int64{9}}}
+	require.Equal(t, &CommonContext{Regions: []int64{}, ConnectionRegions: []int64{}, Availability: []*AvailabilityWindow{}},
+		Common(paused, elsewhere, now))
+	require.Empty(t, Common(&Config{UUID: "fourth"}, paused, now).Availability)
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func TestSettingsValidate(t *testing.T) {
+	for _, language := range []string{"", "en", "pt-BR", "zh-Hans-CN"} {
+		require.NoError(t, (&Settings{Language: language}).Validate(), language)
+	}
+	for _, language := range []string{"english", "EN", "en_US", "en-"} {
+		require.ErrorIs(t, (&Settings{Language: language}).Validate(), common.ErrInvalidSettings, language)
+	}
+}
+
+func TestQuietHoursValidate(t *testing.T) {
+	valid := &QuietHours{Start: "22:00", End: "07:00", Timezone: "Europe/Moscow"}
+	require.NoError(t, (&Settings{Notifications: NotificationSettings{QuietHours: valid}}).Validate())
+	require.NoError(t, (&QuietHours{Start: "01:30", End: "06:00"}).Validate(), "empty timezone is UTC")
+	for _, quiet := range []*QuietHours{
+		{Start: "22", End: "07:00"},
+		{Start: "22:00", End: "7am"},
+		{Start: "25:00", End: "07:00"},
+		{Start: "22:00", End: "22:00"},
+		{Start: "22:00", End: "07:00", Timezone: "Mars/Olympus"},
+	} {
+		require.ErrorIs(t, quiet.Validate(), common.ErrInvalidSettings, *quiet)
+	}
+}
+
+func TestQuietHoursUntil(t *testing.T) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	night := &QuietHours{Start: "22:00", End: "07:00", Timezone: "Europe/Moscow"}
+	lunch := &QuietHours{Start: "13:00", End: "14:00", Timezone: "Europe/Moscow"}
+	tests := []struct {
+		name  string
+		quiet *QuietHours
+		now   time.Time
+		until time.Time
+	}{
+		{"before the night", night, time.Date(2022, 7, 3, 21, 59, 0, 0, moscow), time.Time{}},
+		{"evening", night, time.Date(2022, 7, 3, 22, 0, 0, 0, moscow), time.Date(2022, 7, 4, 7, 0, 0, 0, moscow)},
+		{"after midnight", night, time.Date(2022, 7, 4, 3, 0, 0, 0, moscow), time.Date(2022, 7, 4, 7, 0, 0, 0, moscow)},
+		{"morning", night, time.Date(2022, 7, 4, 7, 0, 0, 0, moscow), time.Time{}},
+		{"end of month", night, time.Date(2022, 7, 31, 23, 0, 0, 0, moscow), time.Date(2022, 8, 1, 7, 0, 0, 0, moscow)},
+		{"in utc", night, time.Date(2022, 7, 3, 20, 0, 0, 0, time.UTC), time.Date(2022, 7, 4, 4, 0, 0, 0, time.UTC)},
+		{"within a day", lunch, time.Date(2022, 7, 3, 13, 30, 0, 0, moscow), time.Date(2022, 7, 3, 14, 0, 0, 0, moscow)},
+		{"outside a day", lunch, time.Date(2022, 7, 3, 12, 0, 0, 0, moscow), time.Time{}},
+		// clocks go back an hour at 2:00 on Nov 6, 2022
+		{"dst change", &QuietHours{Start: "23:00", End: "07:00", Timezone: "America/New_York"},
+			time.Date(2022, 11, 5, 23, 30, 0, 0, newYork), time.Date(2022, 11, 6, 12, 0, 0, 0, time.UTC)},
+		{"invalid", &QuietHours{Start: "22:00"}, time.Date(2022, 7, 3, 23, 0, 0, 0, time.UTC), time.Time{}},
+	}
+	for _, tt := range tests {
+		until, ok := tt.quiet.Until(tt.now)
+		require.Equal(t, !tt.until.IsZero(), ok, tt.name)
+		require.True(t, tt.until.Equal(until), "%s: %s", tt.name, until)
+	}
+}
+
+func TestDateAgeAt(t *testing.T) {
+	birthdate := NewDate(2004, time.May, 17)
+	t.Run("day before 18th birthday", func(t *testing.T) {
+		require.Equal(t, 17, birthdate.AgeAt(time.Date(2022, time.May, 16, 23, 59, 59, 0, time.UTC)))
+	})
+	t.Run("18th birthday", func(t *testing.T) {
+		require.Equal(t, 18, birthdate.AgeAt(time.Date(2022, time.May, 17, 0, 0, 0, 0, time.UTC)))
+	})
+	t.Run("18th birthday in another timezone", func(t *testing.T) {
+		loc := time.FixedZone("UTC+3", 3*60*60)
+		require.Equal(t, 17, birthdate.AgeAt(time.Date(2022, time.May, 17, 2, 0, 0, 0, loc)))
+		require.Equal(t, 18, birthdate.AgeAt(time.Date(2022, time.May, 17, 3, 0, 0, 0, loc)))
+	})
+	t.Run("leap day birthday", func(t *testing.T) {
+		leap := NewDate(2004, time.February, 29)
+		require.Equal(t, 17, leap.AgeAt(time.Date(2022, time.February, 28, 0, 0, 0, 0, time.UTC)))
+		require.Equal(t, 18, leap.AgeAt(time.Date(2022, time.March, 1, 0, 0, 0, 0, time.UTC)))
+		require.Equal(t, 20, leap.AgeAt(time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)))
+	})
+	t.Run("json", func(t *testing.T) {
+		var p Personal
+		require.NoError(t, json.Unmarshal([]byte(`{"birthdate":"2004-05-17"}`), &p))
+		require.Equal(t, birthdate, *p.Birthdate)
+		b, err := json.Marshal(p.Birthdate)
+		require.NoError(t, err)
+		require.Equal(t, `"2004-05-17"`, string(b))
+	})
+}
+
+func TestPersonalDistanceKm(t *testing.T) {
+	coords := func(lat, lon float64) *Personal {
+		return &Personal{Latitude: &lat, Longitude: &lon}
+	}
+	moscow := coords(55.7558, 37.6173)
+	petersburg := coords(59.9343, 30.3351)
+	require.True(t, moscow.HasLocation())
+	require.False(t, (&Personal{}).HasLocation())
+	require.InDelta(t, 634, moscow.DistanceKm(petersburg), 2)
+	require.InDelta(t, 0, moscow.DistanceKm(moscow), 0.001)
+}
+
+func TestConfigWarnings(t *testing.T) {
+	conf := Config{
+		Personal: &Personal{Username: "chuvak"},
+		Criteria: &SearchCriteria{AgeRange: NewRange(18, 60)},
+	}
+	warnings := conf.Warnings()
+	require.Len(t, warnings, 2)
+	require.Equal(t, WarningEmptyBio, warnings[0].Code)
+	require.Equal(t, WarningWideAgeRange, warnings[1].Code)
+	conf.Personal.Bio = "hi"
+	conf.Criteria.AgeRange = NewRange(20, 30)
+	require.Empty(t, conf.Warnings())
+}
+
+func TestProfileProject(t *testing.T) {
+	birthdate := NewDate(2000, time.January, 1)
+	profile := Profile{
+		UUID:     "797bcfb5-ca07-11ec-a6c3-049226c2eb3c",
+		Personal: &Personal{Username: "chuvak", Bio: "hi", Age: 22, Birthdate: &birthdate},
+		Criteria: &SearchCriteria{Regions: []int64{1}},
+	}
+	profile.Project(map[string]bool{FieldUsername: true, FieldAge: true})
+	require.Equal(t, "chuvak", profile.Personal.Username)
+	require.Equal(t, int8(22), profile.Personal.Age)
+	require.Empty(t, profile.Personal.Bio)
+	require.Nil(t, profile.Personal.Birthdate)
+	require.Nil(t, profile.Criteria)
+	b, err := json.Marshal(profile)
+	require.NoError(t, err)
+	require.NotContains(t, string(b), "birthdate")
+}
+
+func TestNormalizeRegionWeights(t *testing.T) {
+	criteria := SearchCriteria{Regions: []int64{1, 2, 3}, RegionWeights: map[int64]float64{1: 6, 2: 3}}
+	require.NoError(t, criteria.NormalizeRegionWeights())
+	require.InDelta(t, 0.6, criteria.RegionWeights[1], 1e-9)
+	require.InDelta(t, 0.3, criteria.RegionWeights[2], 1e-9)
+	require.InDelta(t, 0.1, criteria.RegionWeights[3], 1e-9)
+
+	criteria = SearchCriteria{Regions: []int64{1}, RegionWeights: map[int64]float64{2: 1}}
+	require.ErrorIs(t, criteria.NormalizeRegionWeights(), common.ErrInvalidRegionWeights)
+	criteria = SearchCriteria{Regions: []int64{1}, RegionWeights: map[int64]float64{1: -1}}
+	require.ErrorIs(t, criteria.NormalizeRegionWeights(), common.ErrInvalidRegionWeights)
+	criteria = SearchCriteria{Regions: []int64{1}}
+	require.NoError(t, criteria.NormalizeRegionWeights())
+	require.Nil(t, criteria.RegionWeights)
+}
+
+func TestConfigPatch(t *testing.T) {
+	pause := common.NewTimestamp(time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC))
+	conf := Config{
+		UUID:       "first",
+		PauseUntil: &pause,
+		Personal:   &Personal{UUID: "first", Username: "chuvak", Gender: Male, Age: 26, Bio: "likes cats"},
+		Criteria:   &SearchCriteria{UUID: "first", Regions: []int64{1, 2}, PriceRange: NewRange(35000, 70000)},
+	}
+	patch := `{"personal": {"bio": null, "username": "bober"}, "criteria": {"price_range": {"to": 50000}}}`
+	patched, err := conf.Patch([]byte(patch))
+	require.NoError(t, err)
+	require.Equal(t, &Personal{UUID: "first", Username: "bober", Gender: Male, Age: 26}, patched.Personal)
+	require.Equal(t, []int64{1, 2}, patched.Criteria.Regions)
+	require.Equal(t, NewRange(35000, 50000), patched.Criteria.PriceRange)
+	require.Equal(t, &pause, patched.PauseUntil)
+	require.Equal(t, "chuvak", conf.Personal.Username)
+
+	patched, err = conf.Patch([]byte(`{"pause_until": null}`))
+	require.NoError(t, err)
+	require.Nil(t, patched.PauseUntil)
+	require.Nil(t, patched.Personal)
+
+	for _, patch := range []string{`{"personal": `, `[]`, `{"personal": {"gender": "male"}}`} {
+		_, err = conf.Patch([]byte(patch))
+		require.ErrorIs(t, err, common.ErrInvalidPatch, patch)
+	}
+}
+
+func TestTravelValidate(t *testing.T) {
+	lat, lon, far := 59.93, 30.33, 200.0
+	expires := common.NewTimestamp(time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name   string
+		travel Travel
+		valid  bool
+	}{
+		{"region only", Travel{RegionID: 2, ExpiresAt: expires}, true},
+		{"with coordinates", Travel{RegionID: 2, Latitude: &lat, Longitude: &lon, ExpiresAt: expires}, true},
+		{"no region", Travel{ExpiresAt: expires}, false},
+		{"latitude only", Travel{RegionID: 2, Latitude: &lat, ExpiresAt: expires}, false},
+		{"out of range", Travel{RegionID: 2, Latitude: &lat, Longitude: &far, ExpiresAt: expires}, false},
+		{"no expiry", Travel{RegionID: 2}, false},
+	}
+	for _, tt := range tests {
+		err := tt.travel.Validate()
+		if tt.valid {
+			require.NoError(t, err, tt.name)
+			continue
+		}
+		require.ErrorIs(t, err, common.ErrInvalidTravel, tt.name)
+	}
+	travel := Travel{RegionID: 2, ExpiresAt: expires}
+	require.True(t, travel.Active(expires.Add(-time.Second)))
+	require.False(t, travel.Active(expires.Time))
+	require.False(t, (*Travel)(nil).Active(expires.Time))
+}
+
+func TestPersonalNormalize(t *testing.T) {
+	tests := []struct {
+		name      string
+		personal  Personal
+		titleCase []string
+		expected  Personal
+	}{
+		{"trimmed", Personal{Username: "  ivan \t petrov ", AvatarLink: " jopa.ru\n", Bio: "\n  likes   cats \n"},
+			nil, Personal{Username: "ivan petrov", AvatarLink: "jopa.ru", Bio: "likes cats"}},
+		{"bio paragraphs", Personal{Bio: "first  line\r\nsecond\n\n \n\nnext paragraph"},
+			nil, Personal{Bio: "first line\nsecond\n\nnext paragraph"}},
+		{"title case", Personal{Username: " iVAN  petrov-vodkin ", Bio: "likes CATS"},
+			[]string{FieldUsername}, Personal{Username: "Ivan Petrov-Vodkin", Bio: "likes CATS"}},
+		{"unicode", Personal{Username: "иван петров"},
+			[]string{FieldUsername}, Personal{Username: "Иван Петров"}},
+	}
+	for _, tt := range tests {
+		titleCase := make(map[string]bool)
+		for _, field := range tt.titleCase {
+			titleCase[field] = true
+		}
+		tt.personal.Normalize(titleCase)
+		require.Equal(t, tt.expected, tt.personal, tt.name)
+		tt.personal.Normalize(titleCase)
+		require.Equal(t, tt.expected, tt.personal, "%s: normalizing is idempotent", tt.name)
+	}
+}
+
+func TestPersonalLocalize(t *testing.T) {
+	tests := []struct {
+		name      string
+		languages []string
+		expected  string
+	}{
+		{"translated", []string{"ru", "en"}, "любит котов"},
+		{"first translated", []string{"de", "ru"}, "любит котов"},
+		{"fallback", []string{"de"}, "likes cats"},
+		{"no languages", nil, "likes cats"},
+	}
+	for _, tt := range tests {
+		personal := &Personal{Bio: "likes cats", Bios: map[string]string{"ru": "любит котов"}}
+		personal.Localize(tt.languages)
+		require.Equal(t, tt.expected, personal.Bio, tt.name)
+		require.Nil(t, personal.Bios, tt.name)
+	}
+	var personal *Personal
+	personal.Localize([]string{"ru"})
+}
+
+func TestLikesCursor(t *testing.T) {
+	cursor := LikesCursor{LikedAt: time.Date(2022, time.July, 1, 12, 0, 0, 123456000, time.UTC), UUID: "a:b"}
+	parsed, err := ParseLikesCursor(cursor.String())
+	require.NoError(t, err)
+	require.Equal(t, cursor, parsed)
+
+	parsed, err = ParseLikesCursor("")
+	require.NoError(t, err)
+	require.True(t, parsed.IsZero())
+	require.Empty(t, LikesCursor{}.String())
+
+	for _, token := range []string{"!", "MTIz", "YWJjOmRlZg"} {
+		_, err = ParseLikesCursor(token)
+		require.ErrorIs(t, err, common.ErrInvalidCursor, token)
+	}
+}
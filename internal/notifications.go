@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/logging"
+	"github.com/gerladeno/homie-core/pkg/notify"
+)
+
+// Notifier delivers notifications to users.
+type Notifier interface {
+	Notify(ctx context.Context, n *models.Notification) error
+}
+
+type eventNotifier struct {
+	events *notify.Server
+}
+
+// NewEventNotifier sends notifications as events of the server, so that they are delivered again
+// until the recipient acks them.
+func NewEventNotifier(events *notify.Server) Notifier {
+	return &eventNotifier{events: events}
+}
+
+func (n *eventNotifier) Notify(_ context.Context, notification *models.Notification) error {
+	_, err := n.events.Send(notification.Recipient, notification)
+	return err
+}
+
+// WithNotifier sends notifications about new matches to both users. Matches of a user within matchWindow
+// of the first one are coalesced into a single notification, zero window sends every match on its own.
+func WithNotifier(notifier Notifier, matchWindow time.Duration) Option {
+	return func(a *App) {
+		a.notifier = notifier
+		a.matchNotifications.window = matchWindow
+	}
+}
+
+// notifyMatch queues a notification to the user about the match with the target. It's sent on behalf
+// of the request, so that its logs have the request id, but may outlive it.
+func (a *App) notifyMatch(ctx context.Context, uuid, target string) {
+	if a.notifier == nil {
+		return
+	}
+	a.matchNotifications.add(logging.Detach(ctx), uuid, target, a.sendMatchNotification)
+}
+
+// deferredRetry is how long delivery of deferred notifications is retried after if they can't be read.
+const deferredRetry = time.Minute
+
+// sendMatchNotification sends the notification about matches with the targets unless the user is in quiet hours,
+// then it's stored till those are over together with others coming meanwhile, so that a restart doesn't lose it.
+// Users whose settings can't be read or whose notifications can't be stored are notified right away,
+// as notifications are deferred but never dropped.
+func (a *App) sendMatchNotification(ctx context.Context, uuid string, targets []string) {
+	settings, err := a.store.GetSettings(ctx, uuid)
+	if err != nil {
+		logging.FromContext(ctx, a.log).Warnf("err checking quiet hours of %s: %v", uuid, err)
+	} else if quiet := settings.Notifications.QuietHours; quiet != nil {
+		now := a.now()
+		if until, ok := quiet.Until(now); ok {
+			if err = a.store.DeferMatchNotifications(ctx, uuid, targets, until); err == nil {
+				a.quietMatches.queue(ctx, uuid, targets, until.Sub(now), a.deliverDeferred)
+				return
+			}
+			logging.FromContext(ctx, a.log).Warnf("err deferring notification of %s: %v", uuid, err)
+		}
+	}
+	a.deliverMatchNotification(ctx, uuid, targets)
+}
+
+// ResumeDeferredNotifications schedules match notifications deferred before a restart, those due already are
+// sent right away. Every instance resumes all of them, a notification is sent by the one taking it first.
+func (a *App) ResumeDeferredNotifications(ctx context.Context) error {
+	if a.notifier == nil {
+		return nil
+	}
+	deferred, err := a.store.ListDeferredNotifications(ctx)
+	if err != nil {
+		return fmt.Errorf("err listing deferred notifications: %w", err)
+	}
+	now := a.now()
+	for uuid, deliverAt := range deferred {
+		a.quietMatches.queue(logging.Detach(ctx), uuid, nil, deliverAt.Sub(now), a.deliverDeferred)
+	}
+	return nil
+}
+
+// deliverDeferred sends the notification about the stored matches of the user, if another instance hasn't
+// sent it yet. The targets are those held in memory and are only sent as stored.
+func (a *App) deliverDeferred(ctx context.Context, uuid string, _ []string) {
+	targets, err := a.store.TakeDeferredNotifications(ctx, uuid)
+	switch {
+	case err != nil:
+		logging.FromContext(ctx, a.log).Warnf("err taking deferred notifications of %s: %v", uuid, err)
+		a.quietMatches.queue(ctx, uuid, nil, deferredRetry, a.deliverDeferred)
+	case len(targets) > 0:
+		a.deliverMatchNotification(ctx, uuid, targets)
+	}
+}
+
+func (a *App) deliverMatchNotification(ctx context.Context, uuid string, targets []string) {
+	n := &models.Notification{
+		Type:      models.NotificationMatches,
+		Recipient: uuid,
+		Count:     len(targets),
+		Targets:   targets,
+		Text:      "new match",
+	}
+	if n.Count > 1 {
+		n.Text = fmt.Sprintf("%d new matches", n.Count)
+	}
+	if err := a.notifier.Notify(ctx, n); err != nil {
+		logging.FromContext(ctx, a.log).Warnf("err notifying %s about matches: %v", uuid, err)
+	}
+}
+
+// matchBatcher collects matches of every user within the window of the first one.
+type matchBatcher struct {
+	window time.Duration
+	// afterFunc schedules sends, time.AfterFunc if nil.
+	afterFunc func(wait time.Duration, fn func())
+
+	mx      sync.Mutex
+	pending map[string][]string
+}
+
+// add queues the match and calls send with all matches of the user once the window is over.
+func (b *matchBatcher) add(ctx context.Context, uuid, target string, send matchSender) {
+	b.queue(ctx, uuid, []string{target}, b.window, send)
+}
+
+// matchSender sends matches of the user with the context of the first of them queued.
+type matchSender func(ctx context.Context, uuid string, targets []string)
+
+// queue adds the matches to those of the user and calls send with all of them after wait, which is counted
+// from the first queued ones. Non-positive wait sends the matches right away.
+func (b *matchBatcher) queue(ctx context.Context, uuid string, matches []string, wait time.Duration, send matchSender) {
+	if wait <= 0 {
+		send(ctx, uuid, matches)
+		return
+	}
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if b.pending == nil {
+		b.pending = make(map[string][]string)
+	}
+	targets, ok := b.pending[uuid]
+	b.pending[uuid] = append(targets, matches...)
+	if ok {
+		return
+	}
+	b.after(wait, func() {
+		b.mx.Lock()
+		targets := b.pending[uuid]
+		delete(b.pending, uuid)
+		b.mx.Unlock()
+		send(ctx, uuid, targets)
+	})
+}
+
+func (b *matchBatcher) after(wait time.Duration, fn func()) {
+	if b.afterFunc != nil {
+		b.afterFunc(wait, fn)
+		return
+	}
+	time.AfterFunc(wait, fn)
+}
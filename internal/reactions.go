@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gerladeno/homie-core/pkg/chat"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+// ReactToMessage sets the user's reaction to a message of their dialog with the target, whoever sent it,
+// replacing a previous one. The chat server tells which emoji are allowed.
+func (a *App) ReactToMessage(ctx context.Context, uuid, targetUUID string, id int64, emoji string) (*chat.Reaction, error) {
+	if err := a.chatServer.CheckReaction(emoji); err != nil {
+		return nil, err
+	}
+	err := a.store.GetChat(ctx, uuid, targetUUID)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrChatNotFound):
+		return nil, common.ErrNotParticipant
+	default:
+		return nil, fmt.Errorf("err reacting to message: %w", err)
+	}
+	reaction, err := a.chatServer.ReactToMessage(ctx, uuid, targetUUID, id, emoji)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrMessageNotFound), errors.Is(err, common.ErrInvalidReaction):
+		return nil, err
+	default:
+		return nil, fmt.Errorf("err reacting to message: %w", err)
+	}
+	return reaction, nil
+}
+
+// RemoveReaction removes the user's reaction to a message of their dialog with the target.
+func (a *App) RemoveReaction(ctx context.Context, uuid, targetUUID string, id int64) error {
+	err := a.store.GetChat(ctx, uuid, targetUUID)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrChatNotFound):
+		return common.ErrNotParticipant
+	default:
+		return fmt.Errorf("err removing reaction: %w", err)
+	}
+	err = a.chatServer.RemoveReaction(ctx, uuid, targetUUID, id)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrReactionNotFound):
+		return err
+	default:
+		return fmt.Errorf("err removing reaction: %w", err)
+	}
+	return nil
+}
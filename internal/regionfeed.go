@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+const (
+	// regionFeedPool is the count of best candidates grouped by region, counts are of them only.
+	regionFeedPool = 500
+	// maxRegionFeedRegions caps the regions of the grouped feed, those with the most candidates are kept.
+	maxRegionFeedRegions = 20
+)
+
+// GetFeedByRegion groups the best candidates for the user by the regions they search in, which the user
+// searches in too, or travels to. Every region has up to sample candidates in feed order and the count of
+// candidates among the best ones. A candidate searching in several of the regions is in each of them.
+func (a *App) GetFeedByRegion(ctx context.Context, uuid string, sample int64, sort models.FeedSort) (map[int64]*models.RegionFeed, error) { //nolint:lll
+	config, err := a.store.GetConfig(ctx, uuid)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrConfigNotFound):
+		return nil, err
+	default:
+		return nil, fmt.Errorf("err getting feed by region: %w", err)
+	}
+	candidates, err := a.listCandidates(ctx, uuid, regionFeedPool, sort, false)
+	if err != nil {
+		return nil, err
+	}
+	feeds := groupByRegion(searchedRegions(config, a.now()), candidates, sample)
+	var sampled []*models.Profile
+	seen := make(map[*models.Profile]bool)
+	for _, feed := range feeds {
+		for _, p := range feed.Candidates {
+			if !seen[p] {
+				seen[p] = true
+				sampled = append(sampled, p)
+			}
+		}
+	}
+	if err = a.scoreCandidates(ctx, uuid, sampled); err != nil {
+		return feeds, &common.DegradedError{Skipped: []string{SkippedDistances}, Err: err}
+	}
+	return feeds, nil
+}
+
+// searchedRegions returns the regions candidates are searched in for the config, the travel one replaces
+// the user's own while it's active like in matching.
+func searchedRegions(config *models.Config, now time.Time) []int64 {
+	if config.Travel != nil && config.Travel.Active(now) {
+		return []int64{config.Travel.RegionID}
+	}
+	if config.Criteria == nil {
+		return nil
+	}
+	return config.Criteria.Regions
+}
+
+// groupByRegion buckets the candidates by those of the regions they search in, keeping up to sample
+// of them per region and at most maxRegionFeedRegions regions with the most candidates.
+func groupByRegion(regions []int64, candidates []*models.Profile, sample int64) map[int64]*models.RegionFeed {
+	feeds := make(map[int64]*models.RegionFeed, len(regions))
+	for _, region := range regions {
+		feeds[region] = &models.RegionFeed{Candidates: []*models.Profile{}}
+	}
+	for _, p := range candidates {
+		if p.Criteria == nil {
+			continue
+		}
+		for _, region := range p.Criteria.Regions {
+			feed, ok := feeds[region]
+			if !ok {
+				continue
+			}
+			feed.Count++
+			if int64(len(feed.Candidates)) < sample {
+				feed.Candidates = append(feed.Candidates, p)
+			}
+		}
+	}
+	if len(feeds) <= maxRegionFeedRegions {
+		return feeds
+	}
+	ranked := make([]int64, 0, len(feeds))
+	for region := range feeds {
+		ranked = append(ranked, region)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if feeds[ranked[i]].Count != feeds[ranked[j]].Count {
+			return feeds[ranked[i]].Count > feeds[ranked[j]].Count
+		}
+		return ranked[i] < ranked[j]
+	})
+	for _, region := range ranked[maxRegionFeedRegions:] {
+		delete(feeds, region)
+	}
+	return feeds
+}
@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+// ReportMessage reports a message of the user's dialog with the target for moderators, whoever sent it.
+// A copy of the message is kept with the report.
+func (a *App) ReportMessage(ctx context.Context, uuid, targetUUID string, id int64, reason string) (*models.MessageReport, error) { //nolint:lll
+	report := &models.MessageReport{Reporter: uuid, MessageID: id, Reason: reason, CreatedAt: common.NewTimestamp(a.now())}
+	if err := report.Validate(); err != nil {
+		return nil, err
+	}
+	err := a.store.GetChat(ctx, uuid, targetUUID)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrChatNotFound):
+		return nil, common.ErrNotParticipant
+	default:
+		return nil, fmt.Errorf("err reporting message: %w", err)
+	}
+	err = a.store.SaveMessageReport(ctx, report, targetUUID)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrMessageNotFound):
+		return nil, err
+	default:
+		return nil, fmt.Errorf("err reporting message: %w", err)
+	}
+	return report, nil
+}
+
+// ListMessageReports returns reports of messages, most recent first.
+func (a *App) ListMessageReports(ctx context.Context) ([]*models.MessageReport, error) {
+	reports, err := a.store.ListMessageReports(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("err listing message reports: %w", err)
+	}
+	return reports, nil
+}
@@ -0,0 +1,51 @@
+package rest
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	// defaultAccessLogSample logs every request.
+	defaultAccessLogSample = 1
+	// defaultAccessLogAlwaysStatus and defaultAccessLogAlwaysSlower make errors and slow requests logged
+	// whatever the sample.
+	defaultAccessLogAlwaysStatus = http.StatusBadRequest
+	defaultAccessLogAlwaysSlower = time.Second
+)
+
+// sampledLogFormatter logs 1 in every requests, but always those answered with a status of at least
+// alwaysStatus or taking longer than alwaysSlower, zero alwaysSlower disables the latter.
+type sampledLogFormatter struct {
+	next         middleware.LogFormatter
+	every        int64
+	alwaysStatus int
+	alwaysSlower time.Duration
+	count        int64
+}
+
+func (f *sampledLogFormatter) NewLogEntry(r *http.Request) middleware.LogEntry {
+	return &sampledLogEntry{next: f.next.NewLogEntry(r), formatter: f}
+}
+
+type sampledLogEntry struct {
+	next      middleware.LogEntry
+	formatter *sampledLogFormatter
+}
+
+func (e *sampledLogEntry) Write(status, bytes int, header http.Header, elapsed time.Duration, extra interface{}) {
+	f := e.formatter
+	switch {
+	case status >= f.alwaysStatus, f.alwaysSlower > 0 && elapsed > f.alwaysSlower:
+	case atomic.AddInt64(&f.count, 1)%f.every != 0:
+		return
+	}
+	e.next.Write(status, bytes, header, elapsed, extra)
+}
+
+func (e *sampledLogEntry) Panic(v interface{}, stack []byte) {
+	e.next.Panic(v, stack)
+}
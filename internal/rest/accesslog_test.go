@@ -0,0 +1,53 @@
+package rest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampledAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+	formatter := &sampledLogFormatter{
+		next:         &middleware.DefaultLogFormatter{Logger: log, NoColor: true},
+		every:        3,
+		alwaysStatus: http.StatusBadRequest,
+		alwaysSlower: 50 * time.Millisecond,
+	}
+	handler := middleware.RequestLogger(formatter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/slow":
+			time.Sleep(60 * time.Millisecond)
+		}
+		writeResponse(w, "Ok")
+	}))
+	serve := func(path string) {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	for i := 0; i < 9; i++ {
+		serve("/ok")
+	}
+	require.Equal(t, 3, strings.Count(buf.String(), "/ok"), "1 in 3 successes is logged")
+	for i := 0; i < 4; i++ {
+		serve("/fail")
+		serve("/missing")
+	}
+	require.Equal(t, 4, strings.Count(buf.String(), "/fail"), "errors are always logged")
+	require.Equal(t, 4, strings.Count(buf.String(), "/missing"))
+	serve("/slow")
+	require.Equal(t, 1, strings.Count(buf.String(), "/slow"), "slow requests are always logged")
+}
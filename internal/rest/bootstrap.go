@@ -0,0 +1,144 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+const (
+	// defaultBootstrapTimeout bounds every section of the bootstrap, so that a slow subsystem doesn't
+	// delay the others.
+	defaultBootstrapTimeout = 2 * time.Second
+	bootstrapFeedCount      = 10
+)
+
+// Sections of the bootstrap listed as skipped when their data is missing.
+const (
+	bootstrapConfig   = "config"
+	bootstrapSettings = "settings"
+	bootstrapUnread   = "unread_count"
+	bootstrapFeed     = "feed"
+	bootstrapQuota    = "quota"
+	bootstrapBoost    = "boost"
+)
+
+// bootstrapData is everything a client needs on start. A new user has a null config and the boost is null
+// with boosts disabled, other null sections are listed in Meta.Skipped.
+type bootstrapData struct {
+	Config      *models.Config      `json:"config"`
+	Settings    *models.Settings    `json:"settings"`
+	Limits      models.Limits       `json:"limits"`
+	Quota       *models.Quota       `json:"quota"`
+	Boost       *models.BoostStatus `json:"boost"`
+	UnreadCount *int64              `json:"unread_count"`
+	Feed        interface{}         `json:"feed"`
+}
+
+// bootstrap loads the sections concurrently and responds with those loaded in time, flagging the response
+// degraded if any is missing.
+func (h *handler) bootstrap(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	data := bootstrapData{Limits: h.service.GetLimits()}
+	data.Limits.MaxMatchesCount = h.maxMatchesCount
+	data.Limits.MaxStreamMatchesCount = h.maxStreamMatchesCount
+
+	var (
+		wg      sync.WaitGroup
+		mx      sync.Mutex
+		skipped []string
+	)
+	section := func(name string, load func(ctx context.Context) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), h.bootstrapTimeout)
+			defer cancel()
+			err := load(ctx)
+			if err == nil {
+				return
+			}
+			h.log.Warnf("err bootstrapping %s: %v", name, err)
+			mx.Lock()
+			defer mx.Unlock()
+			var degradedErr *common.DegradedError
+			if errors.As(err, &degradedErr) {
+				skipped = append(skipped, degradedErr.Skipped...)
+				return
+			}
+			skipped = append(skipped, name)
+		}()
+	}
+	section(bootstrapConfig, func(ctx context.Context) error {
+		config, err := h.service.GetConfig(ctx, uuid)
+		switch {
+		case err == nil:
+			data.Config = config
+		case errors.Is(err, common.ErrConfigNotFound):
+			// a new user has no config yet which isn't an error
+		default:
+			return err
+		}
+		return nil
+	})
+	section(bootstrapSettings, func(ctx context.Context) error {
+		settings, err := h.service.GetSettings(ctx, uuid)
+		if err != nil {
+			return err
+		}
+		data.Settings = settings
+		return nil
+	})
+	section(bootstrapQuota, func(ctx context.Context) error {
+		quota, err := h.service.GetQuota(ctx, uuid)
+		if err != nil {
+			return err
+		}
+		data.Quota = quota
+		return nil
+	})
+	if h.features.Enabled(FeatureBoost) {
+		section(bootstrapBoost, func(ctx context.Context) error {
+			status, err := h.service.GetBoostStatus(ctx, uuid)
+			if err != nil {
+				return err
+			}
+			data.Boost = status
+			return nil
+		})
+	}
+	section(bootstrapUnread, func(ctx context.Context) error {
+		count, err := h.service.GetTotalUnread(ctx, uuid)
+		if err != nil {
+			return err
+		}
+		data.UnreadCount = &count
+		return nil
+	})
+	section(bootstrapFeed, func(ctx context.Context) error {
+		profiles, err := h.service.GetMatches(ctx, uuid, bootstrapFeedCount, "", false)
+		var degradedErr *common.DegradedError
+		if err != nil && !errors.As(err, &degradedErr) {
+			return err
+		}
+		data.Feed = sparseProfiles(r, profiles)
+		return err
+	})
+	wg.Wait()
+
+	if len(skipped) == 0 {
+		writeResponse(w, data)
+		return
+	}
+	sort.Strings(skipped)
+	writeJSONResponse(w, JSONResponse{Data: data, Meta: &Meta{Degraded: true, Skipped: skipped}})
+}
@@ -0,0 +1,163 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/internal/rest/resttest"
+	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBootstrap(t *testing.T) {
+	config := &models.Config{}
+	config.SetUUID(testUUID)
+	remaining := int64(4)
+	expiresAt := time.Date(2022, time.June, 1, 12, 0, 0, 0, time.UTC)
+	healthy := func() *resttest.Service {
+		return &resttest.Service{
+			GetConfigFunc: func(context.Context, string) (*models.Config, error) {
+				return config, nil
+			},
+			GetSettingsFunc: func(_ context.Context, uuid string) (*models.Settings, error) {
+				return models.DefaultSettings(uuid), nil
+			},
+			GetLimitsFunc: func() models.Limits {
+				return models.Limits{MinAge: 18, MaxActiveMatches: 50}
+			},
+			GetQuotaFunc: func(context.Context, string) (*models.Quota, error) {
+				return &models.Quota{PendingLikesRemaining: &remaining}, nil
+			},
+			GetBoostStatusFunc: func(context.Context, string) (*models.BoostStatus, error) {
+				return &models.BoostStatus{Active: true, Boost: &models.Boost{ExpiresAt: common.NewTimestamp(expiresAt)},
+					RemainingSeconds: 600}, nil
+			},
+			GetTotalUnreadFunc: func(context.Context, string) (int64, error) {
+				return 3, nil
+			},
+			GetMatchesFunc: func(context.Context, string, int64, models.FeedSort, bool) ([]*models.Profile, error) {
+				return []*models.Profile{{UUID: testPeer}}, nil
+			},
+		}
+	}
+	tests := []struct {
+		name     string
+		modify   func(s *resttest.Service)
+		disabled []Feature
+		skipped  []string
+		missing  []string
+	}{
+		{"healthy", func(*resttest.Service) {}, nil, nil, nil},
+		{"new user", func(s *resttest.Service) {
+			s.GetConfigFunc = func(context.Context, string) (*models.Config, error) {
+				return nil, common.ErrConfigNotFound
+			}
+		}, nil, nil, []string{"config"}},
+		{"settings down", func(s *resttest.Service) {
+			s.GetSettingsFunc = func(context.Context, string) (*models.Settings, error) {
+				return nil, errors.New("err connection refused")
+			}
+		}, nil, []string{"settings"}, []string{"settings"}},
+		{"quota down", func(s *resttest.Service) {
+			s.GetQuotaFunc = func(context.Context, string) (*models.Quota, error) {
+				return nil, errors.New("err connection refused")
+			}
+		}, nil, []string{"quota"}, []string{"quota"}},
+		{"boost slow", func(s *resttest.Service) {
+			s.GetBoostStatusFunc = func(ctx context.Context, _ string) (*models.BoostStatus, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+		}, nil, []string{"boost"}, []string{"boost"}},
+		{"boosts disabled", func(*resttest.Service) {}, []Feature{FeatureBoost}, nil, []string{"boost"}},
+		{"chats down", func(s *resttest.Service) {
+			s.GetTotalUnreadFunc = func(context.Context, string) (int64, error) {
+				return 0, errors.New("err connection refused")
+			}
+		}, nil, []string{"unread_count"}, []string{"unread_count"}},
+		{"feed slow", func(s *resttest.Service) {
+			s.GetMatchesFunc = func(ctx context.Context, _ string, _ int64, _ models.FeedSort, _ bool) ([]*models.Profile, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+		}, nil, []string{"feed"}, []string{"feed"}},
+		{"distances down", func(s *resttest.Service) {
+			s.GetMatchesFunc = func(context.Context, string, int64, models.FeedSort, bool) ([]*models.Profile, error) {
+				return []*models.Profile{{UUID: testPeer}},
+					&common.DegradedError{Skipped: []string{"distances"}, Err: errors.New("err connection refused")}
+			}
+		}, nil, []string{"distances"}, nil},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			service := healthy()
+			tt.modify(service)
+			h := newHandler(logrus.New(), service, nil, tokenRules{})
+			h.bootstrapTimeout = 10 * time.Millisecond
+			h.features = NewFeatureFlags(tt.disabled...)
+			r := httptest.NewRequest(http.MethodGet, "/public/v1/bootstrap", nil)
+			r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+			w := httptest.NewRecorder()
+			h.bootstrap(w, r)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var response struct {
+				Data map[string]json.RawMessage `json:"data"`
+				Meta *Meta                      `json:"meta"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			for _, section := range []string{"config", "settings", "limits", "quota", "boost", "unread_count", "feed"} {
+				require.Contains(t, response.Data, section)
+				missing := string(response.Data[section]) == "null"
+				require.Equal(t, contains(tt.missing, section), missing, section)
+			}
+			if tt.skipped == nil {
+				require.Nil(t, response.Meta)
+			} else {
+				require.Equal(t, &Meta{Degraded: true, Skipped: tt.skipped}, response.Meta)
+			}
+
+			var limits models.Limits
+			require.NoError(t, json.Unmarshal(response.Data["limits"], &limits))
+			require.Equal(t, models.Limits{MinAge: 18, MaxActiveMatches: 50, MaxMatchesCount: defaultMaxMatchesCount,
+				MaxStreamMatchesCount: defaultMaxStreamMatchesCount}, limits)
+			if !contains(tt.missing, "quota") {
+				require.JSONEq(t, `{"pending_likes_remaining": 4}`, string(response.Data["quota"]))
+			}
+			if !contains(tt.missing, "boost") {
+				var boost models.BoostStatus
+				require.NoError(t, json.Unmarshal(response.Data["boost"], &boost))
+				require.True(t, boost.Active)
+				require.Equal(t, int64(600), boost.RemainingSeconds)
+			} else if len(tt.disabled) > 0 {
+				require.Empty(t, service.Calls("GetBoostStatus"), "disabled boosts aren't loaded")
+			}
+			if !contains(tt.missing, "unread_count") {
+				require.JSONEq(t, "3", string(response.Data["unread_count"]))
+			}
+			if !contains(tt.missing, "feed") {
+				require.Contains(t, string(response.Data["feed"]), testPeer)
+				calls := service.Calls("GetMatches")
+				require.Len(t, calls, 1)
+				require.Equal(t, []interface{}{testUUID, int64(bootstrapFeedCount), models.FeedSort(""), false}, calls[0].Args)
+			}
+		})
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package rest
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const defaultCompressMinSize = 1024
+
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/javascript": true,
+	"text/html":              true,
+	"text/css":               true,
+	"text/plain":             true,
+	"text/javascript":        true,
+	"image/svg+xml":          true,
+}
+
+// defaultExcludedTypes are compressed already, compressing them again only wastes CPU.
+var defaultExcludedTypes = []string{"image/jpeg", "image/png", "image/webp", "application/zip"}
+
+// compressor compresses responses of at least minSize bytes. Smaller ones are passed as is,
+// since compressing them wastes CPU and may even enlarge the payload. Responses of the excluded
+// media types are never compressed.
+func compressor(level, minSize int, excludedTypes []string) func(next http.Handler) http.Handler {
+	excluded := make(map[string]bool, len(excludedTypes))
+	for _, t := range excludedTypes {
+		excluded[strings.ToLower(strings.TrimSpace(t))] = true
+	}
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			encoding := selectEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, level: level, minSize: minSize, excluded: excluded}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func selectEncoding(acceptEncoding string) string {
+	var deflate bool
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		switch strings.TrimSpace(strings.Split(part, ";")[0]) {
+		case "gzip":
+			return "gzip"
+		case "deflate":
+			deflate = true
+		}
+	}
+	if deflate {
+		return "deflate"
+	}
+	return ""
+}
+
+// compressWriter buffers the response until it reaches minSize or the handler returns
+// and only then decides whether to compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	level    int
+	minSize  int
+	excluded map[string]bool
+	buf      []byte
+	status   int
+	decided  bool
+	hijacked bool
+	w        io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if cw.decided {
+		if cw.w != nil {
+			return cw.w.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (cw *compressWriter) decide() error {
+	cw.decided = true
+	header := cw.Header()
+	header.Add("Vary", "Accept-Encoding")
+	if len(cw.buf) >= cw.minSize && cw.compressible() {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		var err error
+		if cw.encoding == "gzip" {
+			cw.w, err = gzip.NewWriterLevel(cw.ResponseWriter, cw.level)
+		} else {
+			cw.w, err = flate.NewWriter(cw.ResponseWriter, cw.level)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if cw.w != nil {
+		_, err := cw.w.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+func (cw *compressWriter) compressible() bool {
+	header := cw.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && !cw.excluded[mediaType] && compressibleTypes[mediaType]
+}
+
+func (cw *compressWriter) close() {
+	if cw.hijacked {
+		return
+	}
+	if !cw.decided && cw.status != 0 {
+		_ = cw.decide()
+	}
+	if cw.w != nil {
+		_ = cw.w.Close()
+	}
+}
+
+func (cw *compressWriter) Flush() {
+	if !cw.decided && cw.status != 0 {
+		_ = cw.decide()
+	}
+	if f, ok := cw.w.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("err response writer doesn't support hijacking")
+	}
+	cw.hijacked = true
+	return hj.Hijack()
+}
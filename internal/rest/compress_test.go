@@ -0,0 +1,75 @@
+package rest
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressorSkipsTinyResponses(t *testing.T) {
+	router := newTestRouter(t, nil, nil)
+	r := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	r.Header.Set("Accept-Encoding", "gzip, deflate")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, w.Header().Get("Content-Encoding"))
+	require.Contains(t, w.Body.String(), "pong")
+}
+
+func TestCompressorCompressesLargeResponses(t *testing.T) {
+	large := strings.Repeat("a", 2*defaultCompressMinSize)
+	handler := compressor(5, defaultCompressMinSize, defaultExcludedTypes)(http.HandlerFunc(
+		func(w http.ResponseWriter, _ *http.Request) {
+			writeResponse(w, large)
+		}))
+	r := httptest.NewRequest(http.MethodGet, "/static/regions", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	gr, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(gr)
+	require.NoError(t, err)
+	require.Contains(t, string(body), large)
+}
+
+func TestCompressorSkipsExcludedTypes(t *testing.T) {
+	large := strings.Repeat("a", 2*defaultCompressMinSize)
+	tests := []struct {
+		name        string
+		contentType string
+		excluded    []string
+		encoding    string
+	}{
+		{"jpeg", "image/jpeg", defaultExcludedTypes, ""},
+		{"zip", "application/zip", defaultExcludedTypes, ""},
+		{"json", "application/json; charset=utf-8", defaultExcludedTypes, "gzip"},
+		{"json excluded", "application/json", []string{"application/json"}, ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			handler := compressor(5, defaultCompressMinSize, tt.excluded)(http.HandlerFunc(
+				func(w http.ResponseWriter, _ *http.Request) {
+					w.Header().Set("Content-Type", tt.contentType)
+					_, _ = w.Write([]byte(large))
+				}))
+			r := httptest.NewRequest(http.MethodGet, "/public/v1/photos", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, tt.encoding, w.Header().Get("Content-Encoding"))
+			if tt.encoding == "" {
+				require.Equal(t, large, w.Body.String())
+			}
+		})
+	}
+}
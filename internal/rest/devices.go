@@ -0,0 +1,50 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type deviceRequest struct {
+	Platform models.DevicePlatform `json:"platform"`
+	Token    string                `json:"token"`
+}
+
+func (h *handler) registerDevice(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	var req deviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	device, err := h.service.RegisterDevice(r.Context(), uuid, req.Platform, req.Token)
+	if err != nil {
+		h.writeServiceError(w, err, "registering device")
+		return
+	}
+	writeResponse(w, device)
+}
+
+func (h *handler) unregisterDevice(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if err = h.service.UnregisterDevice(r.Context(), uuid, id); err != nil {
+		h.writeServiceError(w, err, "unregistering device")
+		return
+	}
+	writeResponse(w, "Ok")
+}
@@ -0,0 +1,168 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+// Error codes of JSONResponse telling clients the kind of the error.
+const (
+	errCodeNotFound      = "not_found"
+	errCodeForbidden     = "forbidden"
+	errCodeConflict      = "conflict"
+	errCodeValidation    = "validation"
+	errCodeLimitExceeded = "limit_exceeded"
+	errCodeRejected      = "rejected"
+	errCodeExpired       = "expired"
+	errCodeInternal      = "internal"
+	errCodeTimeout       = "timeout"
+	errCodeUnavailable   = "unavailable"
+
+	// errCodeInvalidUsername is a rejected username, told apart as clients show it by the name input.
+	errCodeInvalidUsername = "invalid_username"
+	// errCodePhotoRequired is a feed refused until the user uploads a photo, told apart as clients prompt for one.
+	errCodePhotoRequired = "photo_required"
+)
+
+// timeoutRetryAfter is the Retry-After hint in seconds of responses to timed out requests.
+const timeoutRetryAfter = "5"
+
+var errStatuses = []struct {
+	kind   error
+	status int
+	code   string
+}{
+	// specific errors come before their kinds
+	{common.ErrInvalidUsername, http.StatusUnprocessableEntity, errCodeInvalidUsername},
+	{common.ErrPhotoRequired, http.StatusForbidden, errCodePhotoRequired},
+	{common.ErrNotFound, http.StatusNotFound, errCodeNotFound},
+	{common.ErrForbidden, http.StatusForbidden, errCodeForbidden},
+	{common.ErrConflict, http.StatusConflict, errCodeConflict},
+	{common.ErrValidation, http.StatusBadRequest, errCodeValidation},
+	{common.ErrLimitExceeded, http.StatusTooManyRequests, errCodeLimitExceeded},
+	{common.ErrRejected, http.StatusUnprocessableEntity, errCodeRejected},
+	{common.ErrExpired, http.StatusGone, errCodeExpired},
+	{context.DeadlineExceeded, http.StatusServiceUnavailable, errCodeTimeout},
+	{common.ErrUnavailable, http.StatusServiceUnavailable, errCodeUnavailable},
+}
+
+// httpStatusFor returns the response status and error code for a service error by its kind,
+// 500 for errors of no known kind.
+func httpStatusFor(err error) (int, string) {
+	for _, s := range errStatuses {
+		if errors.Is(err, s.kind) {
+			return s.status, s.code
+		}
+	}
+	return http.StatusInternalServerError, errCodeInternal
+}
+
+// writeServiceError responds with the status of the error. Errors of no known kind are logged
+// and their text isn't shown to the client.
+func (h *handler) writeServiceError(w http.ResponseWriter, err error, action string) {
+	status, code := httpStatusFor(err)
+	if code == errCodeTimeout {
+		// the timeout middleware logs slow requests, the error text tells the client nothing more
+		writeTimeoutResponse(w)
+		return
+	}
+	message := http.StatusText(status)
+	if status == http.StatusInternalServerError {
+		h.log.Warnf("err %s: %v", action, err)
+	} else {
+		message = fmt.Sprintf("%s: %v", message, err)
+	}
+	response := JSONResponse{Data: []int{}, Error: &message, Code: &status, ErrorCode: &code}
+	var fieldErr *common.FieldError
+	if errors.As(err, &fieldErr) {
+		response.Field = &fieldErr.Field
+	}
+	var retryErr *common.RetryError
+	if errors.As(err, &retryErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryErr.After.Seconds()))))
+	}
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(status)
+	_ = newEncoder(w).Encode(response) //nolint:errchkjson
+}
+
+// writeDecodeError responds with 400 to a request body that failed to be decoded. Syntax errors tell the offset
+// they were detected at, type mismatches also the field and the type it should have been of.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	status, code := http.StatusBadRequest, errCodeValidation
+	message := fmt.Sprintf("%s: %v", http.StatusText(status), err)
+	response := JSONResponse{Data: []int{}, Error: &message, Code: &status, ErrorCode: &code}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		message = fmt.Sprintf("%s: malformed JSON at offset %d: %v", http.StatusText(status), syntaxErr.Offset, err)
+		response.Offset = &syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		expected, field := jsonType(typeErr.Type), "body"
+		if typeErr.Field != "" {
+			field = typeErr.Field
+			response.Field = &typeErr.Field
+		}
+		message = fmt.Sprintf("%s: %s at offset %d must be %s, not %s",
+			http.StatusText(status), field, typeErr.Offset, expected, typeErr.Value)
+		response.Offset, response.Expected = &typeErr.Offset, &expected
+	}
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(status)
+	_ = newEncoder(w).Encode(response) //nolint:errchkjson
+}
+
+// jsonType names the JSON type values of the Go type are decoded from.
+func jsonType(t reflect.Type) string {
+	if t == nil {
+		return "value"
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	}
+	return t.String()
+}
+
+// writeTimeoutResponse tells the client the request timed out and when to retry it.
+func writeTimeoutResponse(w http.ResponseWriter) {
+	status, code := http.StatusServiceUnavailable, errCodeTimeout
+	message := http.StatusText(status) + ": request timed out"
+	w.Header().Set("Content-type", "application/json")
+	w.Header().Set("Retry-After", timeoutRetryAfter)
+	w.WriteHeader(status)
+	_ = newEncoder(w).Encode(JSONResponse{Data: []int{}, Error: &message, Code: &status, ErrorCode: &code}) //nolint:errchkjson
+}
+
+// writePartialResponse writes the partial result of a read flagged degraded if err is a *common.DegradedError
+// and reports whether it did. Other errors are left to writeServiceError, writes mustn't use this.
+func (h *handler) writePartialResponse(w http.ResponseWriter, data interface{}, count int, err error, action string) bool {
+	var degradedErr *common.DegradedError
+	if !errors.As(err, &degradedErr) {
+		return false
+	}
+	h.log.Warnf("err %s, responding with partial data: %v", action, err)
+	writeJSONResponse(w, JSONResponse{Data: data, Meta: &Meta{Count: count, Degraded: true, Skipped: degradedErr.Skipped}})
+	return true
+}
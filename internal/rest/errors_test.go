@@ -0,0 +1,120 @@
+package rest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/rest/resttest"
+	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/golang-jwt/jwt"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPStatusFor(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{common.ErrNotFound, http.StatusNotFound, errCodeNotFound},
+		{common.ErrForbidden, http.StatusForbidden, errCodeForbidden},
+		{common.ErrConflict, http.StatusConflict, errCodeConflict},
+		{common.ErrValidation, http.StatusBadRequest, errCodeValidation},
+		{common.ErrLimitExceeded, http.StatusTooManyRequests, errCodeLimitExceeded},
+		{common.ErrRejected, http.StatusUnprocessableEntity, errCodeRejected},
+		{common.ErrMatchNotFound, http.StatusNotFound, errCodeNotFound},
+		{common.ErrChatNotFound, http.StatusNotFound, errCodeNotFound},
+		{common.ErrNotParticipant, http.StatusForbidden, errCodeForbidden},
+		{common.ErrBoostActive, http.StatusConflict, errCodeConflict},
+		{common.ErrSnapshotExpired, http.StatusGone, errCodeExpired},
+		{common.ErrInvalidFeedSort, http.StatusBadRequest, errCodeValidation},
+		{common.ErrPendingLikesLimit, http.StatusTooManyRequests, errCodeLimitExceeded},
+		{fmt.Errorf("err liking: %w", common.ErrMatchLimitReached), http.StatusConflict, errCodeConflict},
+		{&common.FieldError{Field: "bio", Err: common.ErrRejectedContent}, http.StatusUnprocessableEntity, errCodeRejected},
+		{fmt.Errorf("%w: 21, at most 20 allowed", common.ErrTooManyRegions), http.StatusUnprocessableEntity, errCodeRejected},
+		{&common.FieldError{Field: "username", Err: common.ErrInvalidUsername}, http.StatusUnprocessableEntity, errCodeInvalidUsername},
+		{common.ErrPhotoRequired, http.StatusForbidden, errCodePhotoRequired},
+		{fmt.Errorf("err getting regions: %w", context.DeadlineExceeded), http.StatusServiceUnavailable, errCodeTimeout},
+		{&common.RetryError{After: time.Second, Err: common.ErrStoreUnavailable}, http.StatusServiceUnavailable, errCodeUnavailable},
+		{errors.New("err connection lost"), http.StatusInternalServerError, errCodeInternal},
+	}
+	for _, tt := range tests {
+		status, code := httpStatusFor(tt.err)
+		require.Equal(t, tt.status, status, tt.err.Error())
+		require.Equal(t, tt.code, code, tt.err.Error())
+	}
+}
+
+func TestWriteServiceError(t *testing.T) {
+	h := newHandler(logrus.New(), nil, nil, tokenRules{})
+	w := httptest.NewRecorder()
+	h.writeServiceError(w, &common.FieldError{Field: "bio", Err: common.ErrRejectedContent}, "saving config")
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var response JSONResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Equal(t, errCodeRejected, *response.ErrorCode)
+	require.Equal(t, "bio", *response.Field)
+
+	w = httptest.NewRecorder()
+	h.writeServiceError(w, errors.New("err connection lost"), "saving config")
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.NotContains(t, w.Body.String(), "connection lost")
+	require.Empty(t, w.Header().Get("Retry-After"))
+
+	w = httptest.NewRecorder()
+	err := fmt.Errorf("err saving config: %w", &common.RetryError{After: 1500 * time.Millisecond, Err: common.ErrEditingTooFast})
+	h.writeServiceError(w, err, "saving config")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "2", w.Header().Get("Retry-After"), "seconds are rounded up")
+}
+
+func TestMalformedJSONBody(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	service := &resttest.Service{}
+	router := newTestRouter(t, service, &key.PublicKey)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"uuid": testUUID}).SignedString(key)
+	require.NoError(t, err)
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		name     string
+		body     string
+		offset   int64
+		field    *string
+		expected *string
+	}{
+		{"syntax error", `{"personal": {"username": "jopa",}}`, 34, nil, nil},
+		{"type mismatch", `{"criteria": {"price_range": {"from": "cheap"}}}`, 45, str("criteria.price_range.from"), str("number")},
+		{"type mismatch of the body", `[1, 2]`, 1, nil, str("object")},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/public/v1/config", strings.NewReader(tt.body))
+			r.Header.Set("Authorization", "Bearer "+token)
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			require.Equal(t, http.StatusBadRequest, w.Code)
+			require.Equal(t, "application/json", w.Header().Get("Content-type"))
+			var response JSONResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			require.Equal(t, errCodeValidation, *response.ErrorCode)
+			require.Equal(t, tt.offset, *response.Offset)
+			require.Equal(t, tt.field, response.Field)
+			require.Equal(t, tt.expected, response.Expected)
+			require.Contains(t, *response.Error, fmt.Sprintf("offset %d", tt.offset))
+		})
+	}
+	require.Empty(t, service.Calls("SaveConfig"))
+}
@@ -0,0 +1,102 @@
+package rest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/chat"
+)
+
+// flushWriter flushes every write so that streamed responses aren't buffered.
+type flushWriter struct {
+	w io.Writer
+	f http.Flusher
+}
+
+func newFlushWriter(w http.ResponseWriter) *flushWriter {
+	f, _ := w.(http.Flusher)
+	return &flushWriter{w: w, f: f}
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if fw.f != nil {
+		fw.f.Flush()
+	}
+	return n, err
+}
+
+// exportChat streams messages of the dialog as newline delimited JSON, oldest first.
+func (h *handler) exportChat(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	targetUUID, ok := h.chatPeer(w, r, uuid)
+	if !ok {
+		return
+	}
+	var started bool
+	start := func() {
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+	}
+	encoder := json.NewEncoder(newFlushWriter(w))
+	err := h.service.ExportChat(r.Context(), uuid, targetUUID, func(m *chat.Message) error {
+		start()
+		return encoder.Encode(m)
+	})
+	switch {
+	case err == nil:
+		start()
+	case started:
+		// the status is sent already, the client sees a truncated stream
+		h.log.Warnf("err exporting chat: %v", err)
+	default:
+		h.writeServiceError(w, err, "exporting chat")
+	}
+}
+
+// streamMatches streams candidates for the user as newline delimited JSON as they are loaded,
+// taking the same params as getMatches.
+func (h *handler) streamMatches(w http.ResponseWriter, r *http.Request) {
+	count, ok := h.matchesCount(w, r, h.maxStreamMatchesCount)
+	if !ok {
+		return
+	}
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	fields := fieldSet(r)
+	var started bool
+	start := func() {
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+	}
+	encoder := newStreamEncoder(w)
+	err := h.service.StreamMatches(r.Context(), uuid, count, models.FeedSort(r.URL.Query().Get("sort")),
+		func(p *models.Profile) error {
+			start()
+			if fields != nil {
+				return encoder.Encode(sparseProfile{profile: p, fields: fields})
+			}
+			return encoder.Encode(p)
+		})
+	switch {
+	case err == nil:
+		start()
+	case started:
+		h.log.Warnf("err streaming matches: %v", err)
+	default:
+		h.writeServiceError(w, err, "streaming matches")
+	}
+}
@@ -0,0 +1,132 @@
+package rest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/internal/rest/resttest"
+	"github.com/gerladeno/homie-core/pkg/chat"
+	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportChat(t *testing.T) {
+	started := time.Date(2022, time.June, 6, 12, 0, 0, 0, time.UTC)
+	var messages []*chat.Message
+	for i := 0; i < 100; i++ {
+		sender, receiver := testUUID, testPeer
+		if i%2 == 1 {
+			sender, receiver = receiver, sender
+		}
+		messages = append(messages, &chat.Message{
+			ID:        int64(i + 1),
+			Sender:    sender,
+			Receiver:  receiver,
+			Timestamp: common.NewTimestamp(started.Add(time.Duration(i) * time.Minute)),
+			Body:      "message",
+		})
+	}
+	service := &resttest.Service{
+		ExportChatFunc: func(_ context.Context, uuid, target string, fn func(*chat.Message) error) error {
+			if uuid != testUUID || target != testPeer {
+				return common.ErrNotParticipant
+			}
+			for _, m := range messages {
+				if err := fn(m); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	h := newHandler(logrus.New(), service, nil, tokenRules{})
+	export := func(uuid, peer string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/public/v1/chat/"+peer+"/export", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("uuid", peer)
+		ctx := context.WithValue(r.Context(), chi.RouteCtxKey, rctx)
+		r = r.WithContext(context.WithValue(ctx, uuidKey, uuid))
+		w := httptest.NewRecorder()
+		h.exportChat(w, r)
+		return w
+	}
+
+	w := export(testUUID, testPeer)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	require.True(t, w.Flushed)
+	scanner := bufio.NewScanner(w.Body)
+	var got []*chat.Message
+	for scanner.Scan() {
+		var m chat.Message
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &m))
+		got = append(got, &m)
+	}
+	require.NoError(t, scanner.Err())
+	require.Equal(t, messages, got)
+
+	w = export(testPeer, testUUID)
+	require.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestStreamMatches(t *testing.T) {
+	service := &resttest.Service{
+		StreamMatchesFunc: func(_ context.Context, _ string, count int64, sort models.FeedSort, fn func(*models.Profile) error) error {
+			if sort != "" && !sort.Valid() {
+				return common.ErrInvalidFeedSort
+			}
+			for i := int64(0); i < count; i++ {
+				p := &models.Profile{
+					UUID:     fmt.Sprintf("peer-%d", i),
+					Personal: &models.Personal{Username: "bober", Bio: "likes cats"},
+				}
+				if err := fn(p); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	h := newHandler(logrus.New(), service, nil, tokenRules{})
+	stream := func(query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/public/v1/matches/stream"+query, nil)
+		r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+		w := httptest.NewRecorder()
+		h.streamMatches(w, r)
+		return w
+	}
+
+	w := stream("?count=250&fields=username")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	scanner := bufio.NewScanner(w.Body)
+	var lines int
+	for scanner.Scan() {
+		var p models.Profile
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &p))
+		require.Equal(t, fmt.Sprintf("peer-%d", lines), p.UUID)
+		require.Equal(t, "bober", p.Personal.Username)
+		require.Empty(t, p.Personal.Bio, "fields are projected")
+		lines++
+	}
+	require.NoError(t, scanner.Err())
+	require.Equal(t, 250, lines, "count over maxMatchesCount is within maxStreamMatchesCount")
+
+	w = stream("?count=5000")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, int64(defaultMaxStreamMatchesCount), service.Calls("StreamMatches")[1].Args[1])
+
+	w = stream("?sort=random")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = stream("?count=-1")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
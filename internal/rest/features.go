@@ -0,0 +1,101 @@
+package rest
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Feature names routes which may be turned off, e.g. to dark-launch them.
+type Feature string
+
+const (
+	FeatureBoost          Feature = "boost"
+	FeatureMatchesStream  Feature = "matches_stream"
+	FeatureFeedByRegion   Feature = "feed_by_region"
+	FeatureDecisions      Feature = "decisions"
+	FeatureDecisionsReset Feature = "decisions_reset"
+	FeatureReactions      Feature = "reactions"
+	FeatureChatExport     Feature = "chat_export"
+	// FeatureViewers is meant to be a premium one.
+	FeatureViewers Feature = "viewers"
+)
+
+// Features are the known features, all of them enabled by default.
+var Features = []Feature{
+	FeatureBoost,
+	FeatureMatchesStream,
+	FeatureFeedByRegion,
+	FeatureDecisions,
+	FeatureDecisionsReset,
+	FeatureReactions,
+	FeatureChatExport,
+	FeatureViewers,
+}
+
+// FeatureFlags tell which features are on. They may be changed while the router serves requests,
+// routes of a disabled feature respond 404 as if they weren't registered.
+type FeatureFlags struct {
+	mx       sync.RWMutex
+	disabled map[Feature]bool
+}
+
+// NewFeatureFlags returns flags with every feature but the disabled ones enabled.
+func NewFeatureFlags(disabled ...Feature) *FeatureFlags {
+	f := &FeatureFlags{disabled: make(map[Feature]bool, len(disabled))}
+	for _, feature := range disabled {
+		f.disabled[feature] = true
+	}
+	return f
+}
+
+// Enabled tells whether the feature is on, nil flags enable everything.
+func (f *FeatureFlags) Enabled(feature Feature) bool {
+	if f == nil {
+		return true
+	}
+	f.mx.RLock()
+	defer f.mx.RUnlock()
+	return !f.disabled[feature]
+}
+
+// Set turns the feature on or off.
+func (f *FeatureFlags) Set(feature Feature, enabled bool) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.disabled[feature] = !enabled
+}
+
+// unknown returns the features of the flags missing from Features.
+func (f *FeatureFlags) unknown() []Feature {
+	if f == nil {
+		return nil
+	}
+	known := make(map[Feature]bool, len(Features))
+	for _, feature := range Features {
+		known[feature] = true
+	}
+	f.mx.RLock()
+	defer f.mx.RUnlock()
+	var result []Feature
+	for feature, disabled := range f.disabled {
+		if disabled && !known[feature] {
+			result = append(result, feature)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// feature responds 404 to requests of routes of the feature while it's disabled.
+func (h *handler) feature(feature Feature) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !h.features.Enabled(feature) {
+				notFoundHandler(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package rest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gerladeno/homie-core/internal/rest/resttest"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeatureFlags(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"uuid": testUUID}).SignedString(key)
+	require.NoError(t, err)
+	service := &resttest.Service{}
+	features := NewFeatureFlags(FeatureBoost)
+	router := newTestRouter(t, service, &key.PublicKey, WithFeatureFlags(features))
+	getBoostStatus := func() int {
+		r := httptest.NewRequest(http.MethodGet, "/public/v1/boost/status", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusNotFound, getBoostStatus())
+	require.Empty(t, service.Calls("GetBoostStatus"), "a disabled route doesn't reach the service")
+
+	features.Set(FeatureBoost, true)
+	require.Equal(t, http.StatusOK, getBoostStatus())
+	require.Len(t, service.Calls("GetBoostStatus"), 1)
+
+	features.Set(FeatureBoost, false)
+	require.Equal(t, http.StatusNotFound, getBoostStatus())
+	require.Len(t, service.Calls("GetBoostStatus"), 1)
+
+	require.True(t, (*FeatureFlags)(nil).Enabled(FeatureBoost), "no flags enable every feature")
+}
@@ -0,0 +1,94 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gerladeno/homie-core/internal/models"
+)
+
+// personalKeys are the keys of personal details in profile JSON by the field, other fields are keys themselves.
+var personalKeys = map[string][]string{
+	models.FieldLocation: {"latitude", "longitude"},
+}
+
+var projectableFields = []string{
+	models.FieldUsername, models.FieldAvatarLink, models.FieldGender, models.FieldAge, models.FieldBio,
+	models.FieldBirthdate, models.FieldLocation, models.FieldPhotos,
+}
+
+// fieldSet returns profile fields listed in the fields param, nil if there are none.
+func fieldSet(r *http.Request) map[string]bool {
+	fields := r.URL.Query().Get("fields")
+	if fields == "" {
+		return nil
+	}
+	set := make(map[string]bool)
+	for _, field := range strings.Split(fields, ",") {
+		set[strings.TrimSpace(field)] = true
+	}
+	return set
+}
+
+// sparseProfile is a profile projected to the fields a client asked for, encoded without the keys
+// of the other fields rather than with their zero values. Keys of fields which are never projected,
+// such as uuid or distance_km, are kept.
+type sparseProfile struct {
+	profile *models.Profile
+	fields  map[string]bool
+}
+
+func (p sparseProfile) MarshalJSON() ([]byte, error) {
+	if p.profile == nil {
+		return []byte("null"), nil
+	}
+	p.profile.Project(p.fields)
+	b, err := json.Marshal(p.profile)
+	if err != nil {
+		return nil, err
+	}
+	var keys map[string]json.RawMessage
+	if err = json.Unmarshal(b, &keys); err != nil {
+		return nil, err
+	}
+	if !p.fields[models.FieldCriteria] {
+		delete(keys, "criteria")
+	}
+	if raw, ok := keys["personal"]; ok {
+		var personal map[string]json.RawMessage
+		if err = json.Unmarshal(raw, &personal); err != nil {
+			return nil, err
+		}
+		for _, field := range projectableFields {
+			if p.fields[field] {
+				continue
+			}
+			fieldKeys, ok := personalKeys[field]
+			if !ok {
+				fieldKeys = []string{field}
+			}
+			for _, key := range fieldKeys {
+				delete(personal, key)
+			}
+		}
+		if keys["personal"], err = json.Marshal(personal); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(keys)
+}
+
+// sparseProfiles returns the profiles as asked for by the fields param, unchanged if there is none.
+// Profiles are already projected to public fields, this may only narrow them.
+func sparseProfiles(r *http.Request, profiles []*models.Profile) interface{} {
+	fields := fieldSet(r)
+	if fields == nil {
+		return profiles
+	}
+	result := make([]sparseProfile, 0, len(profiles))
+	for _, p := range profiles {
+		result = append(result, sparseProfile{profile: p, fields: fields})
+	}
+	return result
+}
@@ -5,8 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gerladeno/homie-core/pkg/chat"
 
@@ -14,185 +17,1222 @@ import (
 
 	"github.com/gerladeno/homie-core/internal/models"
 	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/gerladeno/homie-core/pkg/metrics"
+	"github.com/gerladeno/homie-core/pkg/notify"
 
 	"github.com/sirupsen/logrus"
 )
 
 type handler struct {
-	log     *logrus.Entry
-	service Service
-	key     *rsa.PublicKey
+	log         *logrus.Entry
+	service     Service
+	key         *rsa.PublicKey
+	tokenRules  tokenRules
+	authMetrics *metrics.Auth
+	// maxMatchesCount caps the count of matches asked for, maxStreamMatchesCount of streamed ones.
+	maxMatchesCount       int64
+	maxStreamMatchesCount int64
+	bootstrapTimeout      time.Duration
+	notifications         *notify.Server
+	features              *FeatureFlags
+	impersonationTTL      time.Duration
+	// ids mint ids of impersonation tokens.
+	ids common.IDGenerator
 }
 
-const defaultLimit = 10
+const (
+	defaultLimit           = 10
+	defaultMatchesCount    = 20
+	defaultMaxMatchesCount = 100
+	// defaultMaxStreamMatchesCount is larger as streamed matches aren't buffered.
+	defaultMaxStreamMatchesCount = 1000
+	defaultStatsWindow           = 7 * 24 * time.Hour
+	// defaultRegionSample and maxRegionSample bound candidates per region of the feed by region.
+	defaultRegionSample = 3
+	maxRegionSample     = 10
+)
+
+func newHandler(log *logrus.Logger, service Service, key *rsa.PublicKey, rules tokenRules) *handler {
+	return &handler{
+		log:                   log.WithField("module", "rest"),
+		service:               service,
+		key:                   key,
+		tokenRules:            rules,
+		authMetrics:           metrics.NewAuth().AutoRegister(),
+		maxMatchesCount:       defaultMaxMatchesCount,
+		maxStreamMatchesCount: defaultMaxStreamMatchesCount,
+		bootstrapTimeout:      defaultBootstrapTimeout,
+		ids:                   common.RandomIDs{},
+	}
+}
+
+func (h *handler) saveConfig(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	var config models.Config
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	config.SetUUID(uuid)
+	warnings, err := h.service.SaveConfig(r.Context(), &config)
+	if err != nil {
+		h.writeServiceError(w, err, "saving config")
+		return
+	}
+	writeResponseWithWarnings(w, "Ok", warnings)
+}
+
+func (h *handler) patchConfig(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeErrResponse(w, fmt.Sprintf("%s: %v", http.StatusText(http.StatusBadRequest), err), http.StatusBadRequest)
+		return
+	}
+	warnings, err := h.service.PatchConfig(r.Context(), uuid, patch)
+	if err != nil {
+		h.writeServiceError(w, err, "patching config")
+		return
+	}
+	writeResponseWithWarnings(w, "Ok", warnings)
+}
+
+func (h *handler) getConfig(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	config, err := h.service.GetConfig(r.Context(), uuid)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrConfigNotFound):
+		// a new user has no config yet which isn't an error
+		writeErrResponse(w, http.StatusText(http.StatusNoContent), http.StatusNoContent)
+		return
+	default:
+		h.writeServiceError(w, err, "getting config")
+		return
+	}
+	etag := configETag(config)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeResponse(w, config)
+}
+
+// getConfigSchema responds with constraints of config fields saves are validated against.
+func (h *handler) getConfigSchema(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, h.service.GetConfigSchema())
+}
+
+// configETag is derived from the config version. An expired pause or travel is dropped from the config
+// without a save, so whether they are in effect is a part of the tag too.
+func configETag(config *models.Config) string {
+	var sections string
+	if config.PauseUntil != nil {
+		sections += "-paused"
+	}
+	if config.Travel != nil {
+		sections += "-travel"
+	}
+	return fmt.Sprintf(`"%d%s"`, config.Version, sections)
+}
+
+// etagMatches reports whether the If-None-Match header lists the tag, weak tags match too.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *handler) getSettings(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	settings, err := h.service.GetSettings(r.Context(), uuid)
+	if err != nil {
+		h.writeServiceError(w, err, "getting settings")
+		return
+	}
+	writeResponse(w, settings)
+}
+
+func (h *handler) saveSettings(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	// the body is decoded over the current settings, so the fields it omits are kept
+	settings, err := h.service.GetSettings(r.Context(), uuid)
+	if err != nil {
+		h.writeServiceError(w, err, "getting settings")
+		return
+	}
+	if err = json.NewDecoder(r.Body).Decode(settings); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	settings.UUID = uuid
+	if err = h.service.SaveSettings(r.Context(), settings); err != nil {
+		h.writeServiceError(w, err, "saving settings")
+		return
+	}
+	writeResponse(w, "Ok")
+}
+
+func (h *handler) getMatches(w http.ResponseWriter, r *http.Request) {
+	if val := r.URL.Query().Get("state"); val != "" {
+		state, err := strconv.ParseBool(val)
+		if err != nil {
+			writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if state {
+			h.getMatchesWithState(w, r)
+			return
+		}
+	}
+	count, ok := h.matchesCount(w, r, h.maxMatchesCount)
+	if !ok {
+		return
+	}
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	sort := models.FeedSort(r.URL.Query().Get("sort"))
+	var newOnly bool
+	if val := r.URL.Query().Get("new_only"); val != "" {
+		var err error
+		if newOnly, err = strconv.ParseBool(val); err != nil {
+			writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+	}
+	if r.URL.Query().Has("snapshot") {
+		h.getFeedPage(w, r, uuid, count, sort, newOnly)
+		return
+	}
+	result, err := h.service.GetMatches(r.Context(), uuid, count, sort, newOnly)
+	var degradedErr *common.DegradedError
+	if err != nil && !errors.As(err, &degradedErr) {
+		h.writeServiceError(w, err, "getting matches")
+		return
+	}
+	if h.writePartialResponse(w, sparseProfiles(r, result), len(result), err, "getting matches") {
+		return
+	}
+	if count == h.maxMatchesCount && int64(len(result)) == count {
+		total, err := h.service.CountCandidates(r.Context(), uuid, newOnly)
+		if err != nil {
+			h.log.Warnf("err counting candidates, responding without total: %v", err)
+		} else if total.Count > count {
+			writeJSONResponse(w, JSONResponse{Data: sparseProfiles(r, result),
+				Meta: &Meta{Count: int(total.Count), CountIsEstimate: total.Estimate, HasMore: true}})
+			return
+		}
+	}
+	writeResponse(w, sparseProfiles(r, result))
+}
+
+// getMatchesWithState responds with active matches of the user along with the state of their chats
+// instead of candidates.
+func (h *handler) getMatchesWithState(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	// the service defaults and caps the limit
+	limit, _ := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64)
+	offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	matches, err := h.service.GetMatchesWithState(r.Context(), uuid, limit, offset)
+	if err != nil {
+		h.writeServiceError(w, err, "getting matches with state")
+		return
+	}
+	writeResponse(w, matches)
+}
+
+// getDailyPick responds with the candidate highlighted for the user today.
+func (h *handler) getDailyPick(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	result, err := h.service.GetDailyPick(r.Context(), uuid)
+	var degradedErr *common.DegradedError
+	if err != nil && !errors.As(err, &degradedErr) {
+		h.writeServiceError(w, err, "getting daily pick")
+		return
+	}
+	if h.writePartialResponse(w, result, 1, err, "getting daily pick") {
+		return
+	}
+	writeResponse(w, result)
+}
+
+// getFeedByRegion responds with a sample of candidates and their count per region, sample is their
+// number per region.
+func (h *handler) getFeedByRegion(w http.ResponseWriter, r *http.Request) {
+	sample := int64(defaultRegionSample)
+	if val := r.URL.Query().Get("sample"); val != "" {
+		parsed, err := strconv.ParseInt(val, 10, 64)
+		if err != nil || parsed <= 0 {
+			writeErrResponse(w, http.StatusText(http.StatusBadRequest)+": invalid sample", http.StatusBadRequest)
+			return
+		}
+		sample = parsed
+	}
+	if sample > maxRegionSample {
+		sample = maxRegionSample
+	}
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	result, err := h.service.GetFeedByRegion(r.Context(), uuid, sample, models.FeedSort(r.URL.Query().Get("sort")))
+	var degradedErr *common.DegradedError
+	if err != nil && !errors.As(err, &degradedErr) {
+		h.writeServiceError(w, err, "getting feed by region")
+		return
+	}
+	if h.writePartialResponse(w, result, len(result), err, "getting feed by region") {
+		return
+	}
+	writeResponse(w, result)
+}
+
+// newSnapshot is the snapshot param starting a paging session, other values are tokens of started ones.
+const newSnapshot = "new"
+
+// getFeedPage responds with a page of a feed snapshot, the token of which is in meta.
+func (h *handler) getFeedPage(w http.ResponseWriter, r *http.Request, uuid string, count int64, sort models.FeedSort, newOnly bool) { //nolint:lll
+	token := r.URL.Query().Get("snapshot")
+	if token == newSnapshot {
+		token = ""
+	}
+	var offset int64
+	if val := r.URL.Query().Get("offset"); val != "" {
+		var err error
+		if offset, err = strconv.ParseInt(val, 10, 64); err != nil || offset < 0 {
+			writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+	}
+	result, token, err := h.service.GetFeedPage(r.Context(), uuid, count, sort, newOnly, token, offset)
+	meta := &Meta{Count: len(result), Snapshot: token}
+	var degradedErr *common.DegradedError
+	switch {
+	case err == nil:
+	case errors.As(err, &degradedErr):
+		h.log.Warnf("err getting feed page, responding with partial data: %v", err)
+		meta.Degraded, meta.Skipped = true, degradedErr.Skipped
+	default:
+		h.writeServiceError(w, err, "getting feed page")
+		return
+	}
+	writeJSONResponse(w, JSONResponse{Data: sparseProfiles(r, result), Meta: meta})
+}
+
+func (h *handler) like(w http.ResponseWriter, r *http.Request) {
+	val := r.URL.Query().Get("super")
+	super, err := strconv.ParseBool(val)
+	if err != nil {
+		writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	targetUUID, ok := h.targetUUID(w, r)
+	if !ok {
+		return
+	}
+	var openChat bool
+	if val = r.URL.Query().Get("open_chat"); val != "" {
+		if openChat, err = strconv.ParseBool(val); err != nil {
+			writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+	}
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	if !openChat {
+		err = h.service.Like(r.Context(), uuid, targetUUID, super)
+		if err != nil {
+			h.writeServiceError(w, err, "liking")
+			return
+		}
+		writeResponse(w, "Ok")
+		return
+	}
+	conversationID, err := h.service.LikeAndOpenChat(r.Context(), uuid, targetUUID, super)
+	if err != nil {
+		h.writeServiceError(w, err, "liking")
+		return
+	}
+	if conversationID == "" {
+		writeResponse(w, "Ok")
+		return
+	}
+	writeResponse(w, &openedChat{ConversationID: conversationID})
+}
+
+// openedChat is the response to a like forming a match with open_chat set.
+type openedChat struct {
+	ConversationID string `json:"conversation_id"`
+}
+
+func (h *handler) getMatch(w http.ResponseWriter, r *http.Request) {
+	targetUUID, ok := h.targetUUID(w, r)
+	if !ok {
+		return
+	}
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	match, err := h.service.GetMatch(r.Context(), uuid, targetUUID)
+	if err != nil {
+		h.writeServiceError(w, err, "getting match")
+		return
+	}
+	if fields := fieldSet(r); fields != nil {
+		writeResponse(w, struct {
+			*models.Match
+			Profile sparseProfile `json:"profile"`
+		}{match, sparseProfile{profile: match.Profile, fields: fields}})
+		return
+	}
+	writeResponse(w, match)
+}
+
+func (h *handler) getSharedAttributes(w http.ResponseWriter, r *http.Request) {
+	targetUUID, ok := h.targetUUID(w, r)
+	if !ok {
+		return
+	}
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	shared, err := h.service.GetSharedAttributes(r.Context(), uuid, targetUUID)
+	if err != nil {
+		h.writeServiceError(w, err, "getting shared attributes")
+		return
+	}
+	writeResponse(w, shared)
+}
+
+func (h *handler) getCommonContext(w http.ResponseWriter, r *http.Request) {
+	targetUUID, ok := h.targetUUID(w, r)
+	if !ok {
+		return
+	}
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	commonContext, err := h.service.GetCommonContext(r.Context(), uuid, targetUUID)
+	if err != nil {
+		h.writeServiceError(w, err, "getting common context")
+		return
+	}
+	writeResponse(w, commonContext)
+}
+
+func (h *handler) getRelationship(w http.ResponseWriter, r *http.Request) {
+	targetUUID, ok := h.targetUUID(w, r)
+	if !ok {
+		return
+	}
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	relationship, err := h.service.GetRelationship(r.Context(), uuid, targetUUID)
+	if err != nil {
+		h.writeServiceError(w, err, "getting relationship")
+		return
+	}
+	writeResponse(w, relationship)
+}
+
+type relationshipsRequest struct {
+	UUIDs []string `json:"uuids"`
+}
+
+// getRelationships responds with the statuses of the user to the targets of the body by their uuids.
+func (h *handler) getRelationships(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	var req relationshipsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	for _, target := range req.UUIDs {
+		if !common.IsValidUUID(target) {
+			h.writeServiceError(w, common.ErrInvalidUUID, "parsing uuid")
+			return
+		}
+	}
+	relationships, err := h.service.GetRelationships(r.Context(), uuid, req.UUIDs)
+	if err != nil {
+		h.writeServiceError(w, err, "getting relationships")
+		return
+	}
+	writeResponse(w, relationships)
+}
+
+func (h *handler) archiveMatch(w http.ResponseWriter, r *http.Request) {
+	targetUUID, ok := h.targetUUID(w, r)
+	if !ok {
+		return
+	}
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	err := h.service.ArchiveMatch(r.Context(), uuid, targetUUID)
+	if err != nil {
+		h.writeServiceError(w, err, "archiving match")
+		return
+	}
+	writeResponse(w, "Ok")
+}
+
+func (h *handler) dislike(w http.ResponseWriter, r *http.Request) {
+	targetUUID, ok := h.targetUUID(w, r)
+	if !ok {
+		return
+	}
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.service.Dislike(r.Context(), uuid, targetUUID); err != nil {
+		h.writeServiceError(w, err, "disliking")
+		return
+	}
+	writeResponse(w, "Ok")
+}
+
+func (h *handler) hide(w http.ResponseWriter, r *http.Request) {
+	targetUUID, ok := h.targetUUID(w, r)
+	if !ok {
+		return
+	}
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.service.Hide(r.Context(), uuid, targetUUID); err != nil {
+		h.writeServiceError(w, err, "hiding")
+		return
+	}
+	writeResponse(w, "Ok")
+}
+
+func (h *handler) unhide(w http.ResponseWriter, r *http.Request) {
+	targetUUID, ok := h.targetUUID(w, r)
+	if !ok {
+		return
+	}
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.service.Unhide(r.Context(), uuid, targetUUID); err != nil {
+		h.writeServiceError(w, err, "unhiding")
+		return
+	}
+	writeResponse(w, "Ok")
+}
+
+func (h *handler) reconsider(w http.ResponseWriter, r *http.Request) {
+	targetUUID, ok := h.targetUUID(w, r)
+	if !ok {
+		return
+	}
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.service.Reconsider(r.Context(), uuid, targetUUID); err != nil {
+		h.writeServiceError(w, err, "reconsidering")
+		return
+	}
+	writeResponse(w, "Ok")
+}
+
+type resetDecisionsRequest struct {
+	// Confirm guards against resets by mistake, the request is rejected unless it's true.
+	Confirm bool `json:"confirm"`
+}
+
+// resetDecisions removes every like and dislike of the user but those of matches, counting them in meta.
+func (h *handler) resetDecisions(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	var req resetDecisionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if !req.Confirm {
+		h.writeServiceError(w, common.ErrResetNotConfirmed, "resetting decisions")
+		return
+	}
+	count, err := h.service.ResetDecisions(r.Context(), uuid)
+	if err != nil {
+		h.writeServiceError(w, err, "resetting decisions")
+		return
+	}
+	writeJSONResponse(w, JSONResponse{Data: "Ok", Meta: &Meta{Count: count}})
+}
+
+func (h *handler) listLiked(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	limit, offset := h.limitOffset(w, r)
+	period, ok := h.timeRange(w, r)
+	if !ok {
+		return
+	}
+	result, err := h.service.ListLikedProfiles(r.Context(), uuid, period, limit, offset)
+	if err != nil {
+		h.writeServiceError(w, err, "listing liked")
+		return
+	}
+	writeResponse(w, sparseProfiles(r, result))
+}
+
+func (h *handler) listDisliked(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	limit, offset := h.limitOffset(w, r)
+	period, ok := h.timeRange(w, r)
+	if !ok {
+		return
+	}
+	result, err := h.service.ListDislikedProfiles(r.Context(), uuid, period, limit, offset)
+	if err != nil {
+		h.writeServiceError(w, err, "listing disliked")
+		return
+	}
+	writeResponse(w, sparseProfiles(r, result))
+}
+
+// listIncomingLikes pages incoming likes by the cursor param, the first page is of an empty one.
+// Missing limit means the service default.
+func (h *handler) listIncomingLikes(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	var limit int64
+	if val := r.URL.Query().Get("limit"); val != "" {
+		var err error
+		if limit, err = strconv.ParseInt(val, 10, 64); err != nil || limit < 0 {
+			writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+	}
+	result, next, err := h.service.ListIncomingLikes(r.Context(), uuid, r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		h.writeServiceError(w, err, "listing incoming likes")
+		return
+	}
+	writeJSONResponse(w, JSONResponse{Data: result, Meta: &Meta{Count: len(result), NextCursor: next}})
+}
+
+func (h *handler) listViewers(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	var limit int64
+	if val := r.URL.Query().Get("limit"); val != "" {
+		var err error
+		if limit, err = strconv.ParseInt(val, 10, 64); err != nil || limit < 0 {
+			writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+	}
+	result, err := h.service.ListProfileViewers(r.Context(), uuid, limit)
+	if err != nil {
+		h.writeServiceError(w, err, "listing viewers")
+		return
+	}
+	writeJSONResponse(w, JSONResponse{Data: result, Meta: &Meta{Count: len(result)}})
+}
+
+func (h *handler) getTimeseries(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	period, ok := h.timeRange(w, r)
+	if !ok {
+		return
+	}
+	series, err := h.service.GetUserTimeseries(r.Context(), uuid, period.From, period.To)
+	if err != nil {
+		h.writeServiceError(w, err, "getting timeseries")
+		return
+	}
+	writeResponse(w, series)
+}
+
+func (h *handler) listDecisions(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	limit, offset := h.limitOffset(w, r)
+	var exact bool
+	if val := r.URL.Query().Get("exact_count"); val != "" {
+		var err error
+		if exact, err = strconv.ParseBool(val); err != nil {
+			writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+	}
+	period, ok := h.timeRange(w, r)
+	if !ok {
+		return
+	}
+	result, total, err := h.service.ListDecisions(r.Context(), uuid, r.URL.Query().Get("action"), period, limit, offset, exact)
+	if err != nil {
+		h.writeServiceError(w, err, "listing decisions")
+		return
+	}
+	writeJSONResponse(w, JSONResponse{Data: result, Meta: &Meta{Count: int(total.Count), CountIsEstimate: total.Estimate}})
+}
 
-func newHandler(log *logrus.Logger, service Service, key *rsa.PublicKey) *handler {
-	return &handler{
-		log:     log.WithField("module", "rest"),
-		service: service,
-		key:     key,
+func (h *handler) startBoost(w http.ResponseWriter, r *http.Request) {
+	duration, err := time.ParseDuration(r.URL.Query().Get("duration"))
+	if err != nil {
+		writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	err = h.service.StartBoost(r.Context(), uuid, duration)
+	if err != nil {
+		h.writeServiceError(w, err, "starting boost")
+		return
 	}
+	writeResponse(w, "Ok")
 }
 
-func (h *handler) saveConfig(w http.ResponseWriter, r *http.Request) {
+func (h *handler) getBoostStatus(w http.ResponseWriter, r *http.Request) {
 	uuid, ok := h.getUUID(w, r)
 	if !ok {
 		return
 	}
-	var config models.Config
-	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
-		writeErrResponse(w, fmt.Sprintf("%s: %v", http.StatusText(http.StatusBadRequest), err), http.StatusBadRequest)
+	status, err := h.service.GetBoostStatus(r.Context(), uuid)
+	if err != nil {
+		h.writeServiceError(w, err, "getting boost status")
 		return
 	}
-	config.SetUUID(uuid)
-	err := h.service.SaveConfig(r.Context(), &config)
-	switch {
-	case err == nil:
-	case errors.Is(err, common.ErrGenderNotSpecified):
-		writeErrResponse(w, fmt.Sprintf("%s: %v", http.StatusText(http.StatusBadRequest), err), http.StatusBadRequest)
+	writeResponse(w, status)
+}
+
+func (h *handler) getAllChats(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
 		return
-	default:
-		h.log.Warnf("err saving config: %v", err)
-		writeErrResponse(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+	includeArchived, _ := strconv.ParseBool(r.URL.Query().Get("include_archived"))
+	limit, _ := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64)
+	offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	profiles, err := h.service.GetAllChats(r.Context(), uuid, includeArchived, limit, offset)
+	if h.writePartialResponse(w, profiles, len(profiles), err, "getting all chats") {
 		return
 	}
-	writeResponse(w, "Ok")
+	if err != nil {
+		h.writeServiceError(w, err, "getting all chats")
+		return
+	}
+	writeResponse(w, profiles)
 }
 
-func (h *handler) getConfig(w http.ResponseWriter, r *http.Request) {
+func (h *handler) chatHandler(w http.ResponseWriter, r *http.Request) {
 	uuid, ok := h.getUUID(w, r)
 	if !ok {
 		return
 	}
-	config, err := h.service.GetConfig(r.Context(), uuid)
-	switch {
-	case err == nil:
-	case errors.Is(err, common.ErrConfigNotFound):
-		writeErrResponse(w, http.StatusText(http.StatusNoContent), http.StatusNoContent)
+	targetUUID, ok := h.chatPeer(w, r, uuid)
+	if !ok {
 		return
 	}
+	hub, err := h.service.GetDialog(r.Context(), uuid, targetUUID)
 	if err != nil {
-		h.log.Warnf("err getting config: %v", err)
-		writeErrResponse(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		h.writeServiceError(w, err, "getting dialog")
 		return
 	}
-	writeResponse(w, config)
+	chat.WebsocketChatHandlerWithInit(hub, uuid, h.conversationInit(r, uuid, targetUUID), w, r)
 }
 
-func (h *handler) getMatches(w http.ResponseWriter, r *http.Request) {
-	val := r.URL.Query().Get("count")
-	count, _ := strconv.ParseInt(val, 10, 64)
+// conversationInit returns the first frame of the chat connection, nil if it can't be made, as clients
+// can still get the peer and the read state by other calls.
+func (h *handler) conversationInit(r *http.Request, uuid, peer string) []byte {
+	init, err := h.service.GetConversationInit(r.Context(), uuid, peer)
+	if err != nil {
+		h.log.Warnf("err getting conversation init: %v", err)
+		return nil
+	}
+	if init == nil {
+		return nil
+	}
+	frame, err := json.Marshal(init)
+	if err != nil {
+		h.log.Warnf("err marshaling conversation init: %v", err)
+		return nil
+	}
+	return frame
+}
+
+// streamHandler serves all chats of the user over a single websocket, along with their notifications
+// if these are served.
+func (h *handler) streamHandler(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	var hooks chat.StreamHooks
+	if h.notifications != nil {
+		frames, unsubscribe := h.notifications.Subscribe(uuid)
+		defer unsubscribe()
+		hooks.Notifications = frames
+		hooks.Ack = func(seq int64) { h.notifications.Ack(uuid, seq) }
+	}
+	if err := h.service.ServeChatStream(w, r, uuid, hooks); err != nil {
+		h.writeServiceError(w, err, "serving chat stream")
+	}
+}
+
+// notificationsHandler serves the user's events, which are sent again on reconnect until acked.
+func (h *handler) notificationsHandler(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	h.notifications.ServeWebsocket(uuid, w, r)
+}
+
+func (h *handler) markAllChatsRead(w http.ResponseWriter, r *http.Request) {
 	uuid, ok := h.getUUID(w, r)
 	if !ok {
 		return
 	}
-	result, err := h.service.GetMatches(r.Context(), uuid, count)
+	count, err := h.service.MarkAllChatsRead(r.Context(), uuid)
 	if err != nil {
-		h.log.Warnf("err getting config: %v", err)
-		writeErrResponse(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		h.writeServiceError(w, err, "marking all chats read")
 		return
 	}
-	writeResponse(w, result)
+	writeJSONResponse(w, JSONResponse{Data: "Ok", Meta: &Meta{Count: count}})
 }
 
-func (h *handler) like(w http.ResponseWriter, r *http.Request) {
-	val := r.URL.Query().Get("super")
-	super, err := strconv.ParseBool(val)
+func (h *handler) countMatches(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	count, err := h.service.CountMatches(r.Context(), uuid)
 	if err != nil {
-		writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		h.writeServiceError(w, err, "counting matches")
 		return
 	}
-	targetUUID := chi.URLParam(r, "uuid")
-	if !common.IsValidUUID(targetUUID) {
-		writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	writeJSONResponse(w, JSONResponse{Data: count})
+}
+
+func (h *handler) getTotalUnread(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	count, err := h.service.GetTotalUnread(r.Context(), uuid)
+	if err != nil {
+		h.writeServiceError(w, err, "getting total unread")
 		return
 	}
+	writeJSONResponse(w, JSONResponse{Data: count})
+}
+
+func (h *handler) archiveChat(w http.ResponseWriter, r *http.Request) {
+	archived := true
+	if val := r.URL.Query().Get("archived"); val != "" {
+		var err error
+		if archived, err = strconv.ParseBool(val); err != nil {
+			writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+	}
 	uuid, ok := h.getUUID(w, r)
 	if !ok {
 		return
 	}
-	if err = h.service.Like(r.Context(), uuid, targetUUID, super); err != nil {
-		h.log.Warnf("err liking: %v", err)
-		writeErrResponse(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	targetUUID, ok := h.chatPeer(w, r, uuid)
+	if !ok {
+		return
+	}
+	err := h.service.ArchiveChat(r.Context(), uuid, targetUUID, archived)
+	if err != nil {
+		h.writeServiceError(w, err, "archiving chat")
 		return
 	}
 	writeResponse(w, "Ok")
 }
 
-func (h *handler) dislike(w http.ResponseWriter, r *http.Request) {
-	targetUUID := chi.URLParam(r, "uuid")
-	if !common.IsValidUUID(targetUUID) {
-		writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+type snoozeRequest struct {
+	Until time.Time `json:"until"`
+}
+
+func (h *handler) snoozeChat(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	targetUUID, ok := h.chatPeer(w, r, uuid)
+	if !ok {
+		return
+	}
+	var req snoozeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.Until.IsZero() {
+		writeErrResponse(w, http.StatusText(http.StatusBadRequest)+": until is required", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.SnoozeChat(r.Context(), uuid, targetUUID, req.Until); err != nil {
+		h.writeServiceError(w, err, "snoozing chat")
 		return
 	}
+	writeResponse(w, "Ok")
+}
+
+func (h *handler) unsnoozeChat(w http.ResponseWriter, r *http.Request) {
 	uuid, ok := h.getUUID(w, r)
 	if !ok {
 		return
 	}
-	if err := h.service.Dislike(r.Context(), uuid, targetUUID); err != nil {
-		h.log.Warnf("err disliking: %v", err)
-		writeErrResponse(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	targetUUID, ok := h.chatPeer(w, r, uuid)
+	if !ok {
+		return
+	}
+	if err := h.service.SnoozeChat(r.Context(), uuid, targetUUID, time.Time{}); err != nil {
+		h.writeServiceError(w, err, "ending chat snooze")
 		return
 	}
 	writeResponse(w, "Ok")
 }
 
-func (h *handler) listLiked(w http.ResponseWriter, r *http.Request) {
+func (h *handler) retractMessage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
 	uuid, ok := h.getUUID(w, r)
 	if !ok {
 		return
 	}
-	limit, offset := h.limitOffset(w, r)
-	result, err := h.service.ListLikedProfiles(r.Context(), uuid, limit, offset)
+	targetUUID, ok := h.chatPeer(w, r, uuid)
+	if !ok {
+		return
+	}
+	err = h.service.RetractMessage(r.Context(), uuid, targetUUID, id)
 	if err != nil {
-		h.log.Warnf("err listing liked: %v", err)
-		writeErrResponse(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		h.writeServiceError(w, err, "retracting message")
 		return
 	}
-	writeResponse(w, result)
+	writeResponse(w, "Ok")
 }
 
-func (h *handler) listDisliked(w http.ResponseWriter, r *http.Request) {
+// editMessageRequest is the new body of an edited message.
+type editMessageRequest struct {
+	Body string `json:"body"`
+}
+
+func (h *handler) editMessage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
 	uuid, ok := h.getUUID(w, r)
 	if !ok {
 		return
 	}
-	limit, offset := h.limitOffset(w, r)
-	result, err := h.service.ListDislikedProfiles(r.Context(), uuid, limit, offset)
+	targetUUID, ok := h.chatPeer(w, r, uuid)
+	if !ok {
+		return
+	}
+	var req editMessageRequest
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	m, err := h.service.EditMessage(r.Context(), uuid, targetUUID, id, req.Body)
 	if err != nil {
-		h.log.Warnf("err listing disliked: %v", err)
-		writeErrResponse(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		h.writeServiceError(w, err, "editing message")
 		return
 	}
-	writeResponse(w, result)
+	writeResponse(w, m)
 }
 
-func (h *handler) getAllChats(w http.ResponseWriter, r *http.Request) {
+// reportMessageRequest is the reason of a message report.
+type reportMessageRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (h *handler) reportMessage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
 	uuid, ok := h.getUUID(w, r)
 	if !ok {
 		return
 	}
-	profiles, err := h.service.GetAllChats(r.Context(), uuid)
+	targetUUID, ok := h.chatPeer(w, r, uuid)
+	if !ok {
+		return
+	}
+	var req reportMessageRequest
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	report, err := h.service.ReportMessage(r.Context(), uuid, targetUUID, id, req.Reason)
 	if err != nil {
-		h.log.Warnf("err getting all chats: %v", err)
-		writeErrResponse(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		h.writeServiceError(w, err, "reporting message")
 		return
 	}
-	writeResponse(w, profiles)
+	writeResponse(w, report)
 }
 
-func (h *handler) chatHandler(w http.ResponseWriter, r *http.Request) {
+// reactRequest is the emoji of a reaction to a message.
+type reactRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+func (h *handler) reactToMessage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
 	uuid, ok := h.getUUID(w, r)
 	if !ok {
 		return
 	}
-	targetUUID := chi.URLParam(r, "uuid")
-	if !common.IsValidUUID(targetUUID) {
+	targetUUID, ok := h.chatPeer(w, r, uuid)
+	if !ok {
+		return
+	}
+	var req reactRequest
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	reaction, err := h.service.ReactToMessage(r.Context(), uuid, targetUUID, id, req.Emoji)
+	if err != nil {
+		h.writeServiceError(w, err, "reacting to message")
+		return
+	}
+	writeResponse(w, reaction)
+}
+
+func (h *handler) removeReaction(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
 		writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	hub := h.service.GetDialog(r.Context(), uuid, targetUUID)
-	chat.WebsocketChatHandler(hub, w, r)
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	targetUUID, ok := h.chatPeer(w, r, uuid)
+	if !ok {
+		return
+	}
+	if err = h.service.RemoveReaction(r.Context(), uuid, targetUUID, id); err != nil {
+		h.writeServiceError(w, err, "removing reaction")
+		return
+	}
+	writeResponse(w, "Ok")
+}
+
+func (h *handler) getChatStats(w http.ResponseWriter, _ *http.Request) {
+	writeResponse(w, h.service.ChatStats())
+}
+
+func (h *handler) getRegionStats(w http.ResponseWriter, r *http.Request) {
+	since := time.Now().Add(-defaultStatsWindow)
+	if param := r.URL.Query().Get("since"); param != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, param); err != nil {
+			writeErrResponse(w, fmt.Sprintf("%s: %v", http.StatusText(http.StatusBadRequest), err), http.StatusBadRequest)
+			return
+		}
+	}
+	stats, err := h.service.GetRegionStats(r.Context(), since)
+	if err != nil {
+		h.writeServiceError(w, err, "getting region stats")
+		return
+	}
+	writeResponse(w, stats)
+}
+
+func (h *handler) listBans(w http.ResponseWriter, r *http.Request) {
+	bans, err := h.service.ListBans(r.Context())
+	if err != nil {
+		h.writeServiceError(w, err, "listing bans")
+		return
+	}
+	writeResponse(w, bans)
+}
+
+func (h *handler) listReports(w http.ResponseWriter, r *http.Request) {
+	reports, err := h.service.ListMessageReports(r.Context())
+	if err != nil {
+		h.writeServiceError(w, err, "listing reports")
+		return
+	}
+	writeResponse(w, reports)
+}
+
+// upsertRegion adds a region or updates the one of the {id} param, which takes precedence over the body.
+func (h *handler) upsertRegion(w http.ResponseWriter, r *http.Request) {
+	var region models.Region
+	if err := json.NewDecoder(r.Body).Decode(&region); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	region.ID = 0
+	if param := chi.URLParam(r, "id"); param != "" {
+		id, err := strconv.ParseInt(param, 10, 64)
+		if err != nil || id <= 0 {
+			writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		region.ID = id
+	}
+	if err := h.service.UpsertRegion(r.Context(), &region); err != nil {
+		h.writeServiceError(w, err, "saving region")
+		return
+	}
+	writeResponse(w, region)
+}
+
+func (h *handler) banIdentity(w http.ResponseWriter, r *http.Request) {
+	var ban models.Ban
+	if err := json.NewDecoder(r.Body).Decode(&ban); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if err := h.service.BanIdentity(r.Context(), &ban); err != nil {
+		h.writeServiceError(w, err, "banning identity")
+		return
+	}
+	writeResponse(w, ban)
+}
+
+func (h *handler) unbanIdentity(w http.ResponseWriter, r *http.Request) {
+	kind := models.BanKind(chi.URLParam(r, "kind"))
+	if err := h.service.UnbanIdentity(r.Context(), kind, chi.URLParam(r, "value")); err != nil {
+		h.writeServiceError(w, err, "unbanning identity")
+		return
+	}
+	writeResponse(w, "Ok")
+}
+
+// revokeToken revokes the token of the jti in the body until its expires_at, revoked_at is ignored.
+func (h *handler) revokeToken(w http.ResponseWriter, r *http.Request) {
+	var token models.RevokedToken
+	if err := json.NewDecoder(r.Body).Decode(&token); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if err := h.service.RevokeToken(r.Context(), &token); err != nil {
+		h.writeServiceError(w, err, "revoking token")
+		return
+	}
+	writeResponse(w, "Ok")
+}
+
+func (h *handler) repairMatches(w http.ResponseWriter, r *http.Request) {
+	repair, err := h.service.RepairMatches(r.Context())
+	if err != nil {
+		h.writeServiceError(w, err, "repairing matches")
+		return
+	}
+	writeResponse(w, repair)
+}
+
+// targetUUID returns the {uuid} param, responding 400 if it isn't a well-formed uuid
+// so that garbage never reaches the service.
+func (h *handler) targetUUID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	param := chi.URLParam(r, "uuid")
+	if !common.IsValidUUID(param) {
+		h.writeServiceError(w, common.ErrInvalidUUID, "parsing uuid")
+		return "", false
+	}
+	return param, true
+}
+
+// chatPeer returns the peer uuid from the {uuid} param which is either the uuid itself or a conversation id.
+// The peer can't be the user.
+func (h *handler) chatPeer(w http.ResponseWriter, r *http.Request, uuid string) (string, bool) {
+	param := chi.URLParam(r, "uuid")
+	if param == uuid {
+		h.writeServiceError(w, common.ErrSelfChat, "parsing uuid")
+		return "", false
+	}
+	if common.IsValidUUID(param) {
+		return param, true
+	}
+	if !chat.IsConversationID(param) {
+		h.writeServiceError(w, common.ErrInvalidUUID, "parsing uuid")
+		return "", false
+	}
+	peer, err := h.service.ResolveConversation(r.Context(), uuid, param)
+	if err != nil {
+		h.writeServiceError(w, err, "resolving conversation")
+		return "", false
+	}
+	return peer, true
 }
 
 func (h *handler) getUUID(w http.ResponseWriter, r *http.Request) (string, bool) {
@@ -219,11 +1259,52 @@ func (h *handler) limitOffset(w http.ResponseWriter, r *http.Request) (int64, in
 	return limit, offset
 }
 
+// timeRange returns the time range of the from and to RFC3339 params in UTC, either of them may be missing.
+// Whether from is after to is left to the service.
+func (h *handler) timeRange(w http.ResponseWriter, r *http.Request) (models.TimeRange, bool) {
+	var period models.TimeRange
+	for _, param := range []struct {
+		name  string
+		bound *time.Time
+	}{{"from", &period.From}, {"to", &period.To}} {
+		val := r.URL.Query().Get(param.name)
+		if val == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			writeErrResponse(w, fmt.Sprintf("%s: invalid %s: %v", http.StatusText(http.StatusBadRequest), param.name, err),
+				http.StatusBadRequest)
+			return models.TimeRange{}, false
+		}
+		*param.bound = t.UTC()
+	}
+	return period, true
+}
+
+// matchesCount returns the count param, defaultMatchesCount if it is missing or zero, capped by max.
+func (h *handler) matchesCount(w http.ResponseWriter, r *http.Request, max int64) (int64, bool) {
+	count := int64(defaultMatchesCount)
+	if val := r.URL.Query().Get("count"); val != "" {
+		parsed, err := strconv.ParseInt(val, 10, 64)
+		if err != nil || parsed < 0 {
+			writeErrResponse(w, http.StatusText(http.StatusBadRequest)+": invalid count", http.StatusBadRequest)
+			return 0, false
+		}
+		if parsed > 0 {
+			count = parsed
+		}
+	}
+	if count > max {
+		count = max
+	}
+	return count, true
+}
+
 func (h *handler) getRegions(w http.ResponseWriter, r *http.Request) {
 	result, err := h.service.GetRegions(r.Context())
 	if err != nil {
-		h.log.Warnf("err getting regions: %v", err)
-		writeErrResponse(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		h.writeServiceError(w, err, "getting regions")
 		return
 	}
 	writeResponse(w, result)
@@ -0,0 +1,1061 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/internal/rest/resttest"
+	"github.com/gerladeno/homie-core/pkg/chat"
+	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/gerladeno/homie-core/pkg/notify"
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+var _ Service = (*resttest.Service)(nil)
+
+const (
+	testUUID = "00000000-0000-0000-0000-000000000001"
+	testPeer = "00000000-0000-0000-0000-000000000002"
+)
+
+func TestGetMatches(t *testing.T) {
+	profiles := []*models.Profile{{UUID: testPeer}}
+	tests := []struct {
+		name   string
+		query  string
+		err    error
+		status int
+		// calls are the expected args of GetMatches, none if nil
+		calls [][]interface{}
+	}{
+		{"missing count", "", nil, http.StatusOK,
+			[][]interface{}{{testUUID, int64(defaultMatchesCount), models.FeedSort(""), false}}},
+		{"zero count", "?count=0", nil, http.StatusOK,
+			[][]interface{}{{testUUID, int64(defaultMatchesCount), models.FeedSort(""), false}}},
+		{"negative count", "?count=-1", nil, http.StatusBadRequest, nil},
+		{"invalid count", "?count=many", nil, http.StatusBadRequest, nil},
+		{"within max", "?count=50&sort=newest", nil, http.StatusOK,
+			[][]interface{}{{testUUID, int64(50), models.FeedSortNewest, false}}},
+		{"over max", "?count=1000", nil, http.StatusOK,
+			[][]interface{}{{testUUID, int64(defaultMaxMatchesCount), models.FeedSort(""), false}}},
+		{"invalid sort", "?sort=random", common.ErrInvalidFeedSort, http.StatusBadRequest,
+			[][]interface{}{{testUUID, int64(defaultMatchesCount), models.FeedSort("random"), false}}},
+		{"new only", "?new_only=true&sort=newest", nil, http.StatusOK,
+			[][]interface{}{{testUUID, int64(defaultMatchesCount), models.FeedSortNewest, true}}},
+		{"invalid new only", "?new_only=maybe", nil, http.StatusBadRequest, nil},
+		{"service failure", "", errors.New("err connection lost"), http.StatusInternalServerError,
+			[][]interface{}{{testUUID, int64(defaultMatchesCount), models.FeedSort(""), false}}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			service := &resttest.Service{
+				GetMatchesFunc: func(context.Context, string, int64, models.FeedSort, bool) ([]*models.Profile, error) {
+					if tt.err != nil {
+						return nil, tt.err
+					}
+					return profiles, nil
+				},
+			}
+			h := newHandler(logrus.New(), service, nil, tokenRules{})
+			r := httptest.NewRequest(http.MethodGet, "/public/v1/matches"+tt.query, nil)
+			r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+			w := httptest.NewRecorder()
+			h.getMatches(w, r)
+			require.Equal(t, tt.status, w.Code)
+			var calls [][]interface{}
+			for _, call := range service.Calls("GetMatches") {
+				calls = append(calls, call.Args)
+			}
+			require.Equal(t, tt.calls, calls)
+			require.Len(t, service.Calls(""), len(tt.calls))
+			if tt.status == http.StatusOK {
+				require.Contains(t, w.Body.String(), testPeer)
+			}
+		})
+	}
+}
+
+func TestGetMatchesHasMore(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		total models.Total
+		meta  *Meta
+		// counted tells whether the candidates are expected to be counted
+		counted bool
+	}{
+		{"over max", "?count=1000", models.Total{Count: 250}, &Meta{Count: 250, HasMore: true}, true},
+		{"estimated", "?count=1000", models.Total{Count: 200, Estimate: true},
+			&Meta{Count: 200, CountIsEstimate: true, HasMore: true}, true},
+		{"all shown", "?count=1000", models.Total{Count: defaultMaxMatchesCount}, nil, true},
+		{"under max", "?count=5", models.Total{Count: 250}, nil, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			service := &resttest.Service{
+				GetMatchesFunc: func(_ context.Context, _ string, count int64, _ models.FeedSort, _ bool) ([]*models.Profile, error) {
+					result := make([]*models.Profile, count)
+					for i := range result {
+						result[i] = &models.Profile{UUID: fmt.Sprintf("peer-%d", i)}
+					}
+					return result, nil
+				},
+				CountCandidatesFunc: func(context.Context, string, bool) (models.Total, error) {
+					return tt.total, nil
+				},
+			}
+			h := newHandler(logrus.New(), service, nil, tokenRules{})
+			r := httptest.NewRequest(http.MethodGet, "/public/v1/matches"+tt.query, nil)
+			r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+			w := httptest.NewRecorder()
+			h.getMatches(w, r)
+			require.Equal(t, http.StatusOK, w.Code)
+			var response JSONResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			require.Equal(t, tt.meta, response.Meta)
+			require.Equal(t, tt.counted, len(service.Calls("CountCandidates")) == 1)
+		})
+	}
+}
+
+func TestGetDailyPick(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"picked", nil, http.StatusOK},
+		{"no candidates", common.ErrNoDailyPick, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			service := &resttest.Service{
+				GetDailyPickFunc: func(context.Context, string) (*models.Profile, error) {
+					if tt.err != nil {
+						return nil, tt.err
+					}
+					return &models.Profile{UUID: testPeer, Personal: &models.Personal{}}, nil
+				},
+			}
+			h := newHandler(logrus.New(), service, nil, tokenRules{})
+			r := httptest.NewRequest(http.MethodGet, "/public/v1/daily-pick", nil)
+			r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+			w := httptest.NewRecorder()
+			h.getDailyPick(w, r)
+			require.Equal(t, tt.status, w.Code)
+			require.Len(t, service.Calls("GetDailyPick"), 1)
+			if tt.status == http.StatusOK {
+				require.Contains(t, w.Body.String(), testPeer)
+			}
+		})
+	}
+}
+
+func TestGetMatchesWithState(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		// calls are the expected args of GetMatchesWithState, none if nil
+		calls [][]interface{}
+	}{
+		{"with state", "?state=true", http.StatusOK, [][]interface{}{{testUUID, int64(0), int64(0)}}},
+		{"paged", "?state=1&limit=5&offset=10", http.StatusOK, [][]interface{}{{testUUID, int64(5), int64(10)}}},
+		{"invalid state", "?state=maybe", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			service := &resttest.Service{
+				GetMatchesWithStateFunc: func(context.Context, string, int64, int64) ([]*models.MatchWithState, error) {
+					return []*models.MatchWithState{
+						{
+							Match:       models.Match{Profile: &models.Profile{UUID: testPeer}, HasMessages: true},
+							LastMessage: &models.MessagePreview{Sender: testPeer, Body: "hi"},
+							UnreadCount: 3,
+						},
+						{Match: models.Match{Profile: &models.Profile{UUID: testUUID}}},
+					}, nil
+				},
+			}
+			h := newHandler(logrus.New(), service, nil, tokenRules{})
+			r := httptest.NewRequest(http.MethodGet, "/public/v1/matches"+tt.query, nil)
+			r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+			w := httptest.NewRecorder()
+			h.getMatches(w, r)
+			require.Equal(t, tt.status, w.Code)
+			var calls [][]interface{}
+			for _, call := range service.Calls("GetMatchesWithState") {
+				calls = append(calls, call.Args)
+			}
+			require.Equal(t, tt.calls, calls)
+			require.Len(t, service.Calls(""), len(tt.calls))
+			if tt.status != http.StatusOK {
+				return
+			}
+			var response struct {
+				Data []map[string]interface{} `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			require.Len(t, response.Data, 2)
+			require.Equal(t, "hi", response.Data[0]["last_message"].(map[string]interface{})["body"])
+			require.EqualValues(t, 3, response.Data[0]["unread_count"])
+			require.Equal(t, true, response.Data[0]["has_messages"])
+			require.NotContains(t, response.Data[1], "last_message")
+			require.EqualValues(t, 0, response.Data[1]["unread_count"])
+		})
+	}
+}
+
+func TestLikeOpenChat(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		matched bool
+		status  int
+		// method is the one of the service called, none if empty
+		method   string
+		response string
+	}{
+		{"plain like", "?super=false", true, http.StatusOK, "Like", `"Ok"`},
+		{"match", "?super=true&open_chat=true", true, http.StatusOK, "LikeAndOpenChat",
+			`{"conversation_id":"c0ffee"}`},
+		{"no match", "?super=false&open_chat=1", false, http.StatusOK, "LikeAndOpenChat", `"Ok"`},
+		{"invalid open chat", "?super=false&open_chat=maybe", true, http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			service := &resttest.Service{
+				LikeAndOpenChatFunc: func(context.Context, string, string, bool) (string, error) {
+					if tt.matched {
+						return "c0ffee", nil
+					}
+					return "", nil
+				},
+			}
+			h := newHandler(logrus.New(), service, nil, tokenRules{})
+			r := httptest.NewRequest(http.MethodGet, "/public/v1/like/"+testPeer+tt.query, nil)
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("uuid", testPeer)
+			r = r.WithContext(context.WithValue(context.WithValue(r.Context(), chi.RouteCtxKey, rctx), uuidKey, testUUID))
+			w := httptest.NewRecorder()
+			h.like(w, r)
+			require.Equal(t, tt.status, w.Code)
+			if tt.method == "" {
+				require.Empty(t, service.Calls(""))
+				return
+			}
+			calls := service.Calls("")
+			require.Len(t, calls, 1)
+			require.Equal(t, tt.method, calls[0].Method)
+			var response struct {
+				Data json.RawMessage `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			require.JSONEq(t, tt.response, string(response.Data))
+		})
+	}
+}
+
+func TestGetMatchesFields(t *testing.T) {
+	service := &resttest.Service{
+		GetMatchesFunc: func(context.Context, string, int64, models.FeedSort, bool) ([]*models.Profile, error) {
+			return []*models.Profile{{
+				UUID:          testPeer,
+				Personal:      &models.Personal{Username: "bober", Bio: "likes cats", Gender: models.Male},
+				Criteria:      &models.SearchCriteria{Regions: []int64{1}},
+				SuperLikedYou: true,
+			}}, nil
+		},
+	}
+	h := newHandler(logrus.New(), service, nil, tokenRules{})
+	r := httptest.NewRequest(http.MethodGet, "/public/v1/feed?fields=username,%20gender", nil)
+	r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+	w := httptest.NewRecorder()
+	h.getMatches(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Data []*models.Profile `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Data, 1)
+	profile := response.Data[0]
+	require.Equal(t, "bober", profile.Personal.Username)
+	require.Equal(t, models.Male, profile.Personal.Gender)
+	require.Empty(t, profile.Personal.Bio)
+	require.Nil(t, profile.Criteria)
+	require.True(t, profile.SuperLikedYou)
+}
+
+func TestGetMatchesLocalizedBio(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		expected       string
+	}{
+		{"translated", "ru-RU,ru;q=0.9,en;q=0.8", "любит котов"},
+		{"by quality", "de, en;q=0.5, ru;q=0.7", "любит котов"},
+		{"fallback", "de", "likes cats"},
+		{"zero quality", "ru;q=0", "likes cats"},
+		{"none", "", "likes cats"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			service := &resttest.Service{
+				GetMatchesFunc: func(context.Context, string, int64, models.FeedSort, bool) ([]*models.Profile, error) {
+					return []*models.Profile{{UUID: testPeer, Personal: &models.Personal{
+						Bio: "likes cats", Bios: map[string]string{"ru": "любит котов"},
+					}}}, nil
+				},
+			}
+			h := newHandler(logrus.New(), service, nil, tokenRules{})
+			r := httptest.NewRequest(http.MethodGet, "/public/v1/matches", nil)
+			r.Header.Set("Accept-Language", tt.acceptLanguage)
+			r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+			w := httptest.NewRecorder()
+			localizedResponses(http.HandlerFunc(h.getMatches)).ServeHTTP(w, r)
+			require.Equal(t, http.StatusOK, w.Code)
+			var response struct {
+				Data []*models.Profile `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			require.Len(t, response.Data, 1)
+			require.Equal(t, tt.expected, response.Data[0].Personal.Bio)
+			require.Nil(t, response.Data[0].Personal.Bios)
+		})
+	}
+}
+
+func TestLocalizeProfiles(t *testing.T) {
+	profile := func() *models.Profile {
+		return &models.Profile{UUID: testPeer, Personal: &models.Personal{
+			Bio: "likes cats", Bios: map[string]string{"ru": "любит котов"},
+		}}
+	}
+	regional, sparse := profile(), profile()
+	own := &models.Personal{Bio: "likes dogs", Bios: map[string]string{"ru": "любит собак"}}
+	localizeProfiles(map[string]interface{}{
+		"regions": map[string]*models.RegionFeed{"msk": {Count: 1, Candidates: []*models.Profile{regional}}},
+		"sparse":  []sparseProfile{{profile: sparse, fields: map[string]bool{"personal": true}}},
+		"config":  &models.Config{Personal: own},
+	}, []string{"ru"})
+	require.Equal(t, "любит котов", regional.Personal.Bio)
+	require.Nil(t, regional.Personal.Bios)
+	require.Equal(t, "любит котов", sparse.Personal.Bio)
+	require.Nil(t, sparse.Personal.Bios)
+	require.Equal(t, "likes dogs", own.Bio)
+	require.Len(t, own.Bios, 1)
+}
+
+func TestSparseFieldsets(t *testing.T) {
+	profile := func() *models.Profile {
+		return &models.Profile{
+			UUID: testPeer,
+			Personal: &models.Personal{
+				Username: "bober", AvatarLink: "https://example.com/a.png", Gender: models.Male, Age: 30, Bio: "likes cats",
+			},
+			Criteria: &models.SearchCriteria{Regions: []int64{1}},
+		}
+	}
+	service := &resttest.Service{
+		GetMatchesFunc: func(context.Context, string, int64, models.FeedSort, bool) ([]*models.Profile, error) {
+			return []*models.Profile{profile()}, nil
+		},
+		GetMatchFunc: func(context.Context, string, string) (*models.Match, error) {
+			return &models.Match{Profile: profile(), SuperLike: true}, nil
+		},
+	}
+	h := newHandler(logrus.New(), service, nil, tokenRules{})
+	serve := func(handler http.HandlerFunc, path string) string {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("uuid", testPeer)
+		ctx := context.WithValue(r.Context(), chi.RouteCtxKey, rctx)
+		r = r.WithContext(context.WithValue(ctx, uuidKey, testUUID))
+		w := httptest.NewRecorder()
+		handler(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	body := serve(h.getMatches, "/public/v1/feed?fields=uuid,username,name")
+	require.JSONEq(t, `{"data":[{"uuid":"`+testPeer+`","personal":{"username":"bober"}}]}`, body,
+		"only the asked for keys are returned and unknown fields are ignored")
+	body = serve(h.getMatch, "/public/v1/match/"+testPeer+"?fields=age,criteria")
+	require.JSONEq(t, `{"data":{"profile":{"uuid":"`+testPeer+`","personal":{"age":30},"criteria":{"regions":[1],`+
+		`"price_range":{},"gender":0,"age_range":{}}},"matched_at":"0001-01-01T00:00:00.000Z","super_like":true,`+
+		`"has_messages":false}}`, body)
+	body = serve(h.getMatches, "/public/v1/feed")
+	require.Contains(t, body, `"bio":"likes cats"`, "without fields profiles are whole")
+}
+
+func TestGetFeedPage(t *testing.T) {
+	const token = "0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		name   string
+		query  string
+		err    error
+		status int
+		// args are the expected args of GetFeedPage, none if nil
+		args []interface{}
+	}{
+		{"new snapshot", "?snapshot=new&count=5", nil, http.StatusOK,
+			[]interface{}{testUUID, int64(5), models.FeedSort(""), false, "", int64(0)}},
+		{"next page", "?snapshot=" + token + "&count=5&offset=5", nil, http.StatusOK,
+			[]interface{}{testUUID, int64(5), models.FeedSort(""), false, token, int64(5)}},
+		{"new only", "?snapshot=new&new_only=1", nil, http.StatusOK,
+			[]interface{}{testUUID, int64(defaultMatchesCount), models.FeedSort(""), true, "", int64(0)}},
+		{"negative offset", "?snapshot=" + token + "&offset=-5", nil, http.StatusBadRequest, nil},
+		{"expired", "?snapshot=" + token + "&offset=5", common.ErrSnapshotExpired, http.StatusGone,
+			[]interface{}{testUUID, int64(defaultMatchesCount), models.FeedSort(""), false, token, int64(5)}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			service := &resttest.Service{
+				GetFeedPageFunc: func(context.Context, string, int64, models.FeedSort, bool, string, int64) ([]*models.Profile, string, error) { //nolint:lll
+					if tt.err != nil {
+						return nil, "", tt.err
+					}
+					return []*models.Profile{{UUID: testPeer}}, token, nil
+				},
+			}
+			h := newHandler(logrus.New(), service, nil, tokenRules{})
+			r := httptest.NewRequest(http.MethodGet, "/public/v1/feed"+tt.query, nil)
+			r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+			w := httptest.NewRecorder()
+			h.getMatches(w, r)
+			require.Equal(t, tt.status, w.Code)
+			require.Empty(t, service.Calls("GetMatches"))
+			calls := service.Calls("GetFeedPage")
+			if tt.args == nil {
+				require.Empty(t, calls)
+				return
+			}
+			require.Len(t, calls, 1)
+			require.Equal(t, tt.args, calls[0].Args)
+			var response JSONResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			if tt.err != nil {
+				require.Equal(t, errCodeExpired, *response.ErrorCode)
+				return
+			}
+			require.Equal(t, &Meta{Count: 1, Snapshot: token}, response.Meta)
+		})
+	}
+}
+
+func TestChatHandlerNotMatched(t *testing.T) {
+	service := &resttest.Service{
+		GetDialogFunc: func(context.Context, string, string) (*chat.Hub, error) {
+			return nil, common.ErrNotMatched
+		},
+	}
+	h := newHandler(logrus.New(), service, nil, tokenRules{})
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("uuid", testPeer)
+	r := httptest.NewRequest(http.MethodGet, "/public/v1/chat/"+testPeer, nil)
+	r = r.WithContext(context.WithValue(context.WithValue(r.Context(), chi.RouteCtxKey, rctx), uuidKey, testUUID))
+	w := httptest.NewRecorder()
+	h.chatHandler(w, r)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Len(t, service.Calls("GetDialog"), 1)
+}
+
+func TestChatHandlerSelf(t *testing.T) {
+	service := &resttest.Service{}
+	h := newHandler(logrus.New(), service, nil, tokenRules{})
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("uuid", testUUID)
+	r := httptest.NewRequest(http.MethodGet, "/public/v1/chat/"+testUUID, nil)
+	r.Header.Set("Connection", "upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r = r.WithContext(context.WithValue(context.WithValue(r.Context(), chi.RouteCtxKey, rctx), uuidKey, testUUID))
+	w := httptest.NewRecorder()
+	h.chatHandler(w, r)
+	require.Equal(t, http.StatusBadRequest, w.Code, "self chats are rejected before upgrading")
+	require.Empty(t, service.Calls("GetDialog"))
+}
+
+// dialogStore opens dialogs in memory, other methods of the store aren't expected to be called.
+type dialogStore struct {
+	chat.Store
+}
+
+func (dialogStore) SaveChat(context.Context, string, string) error { return nil }
+
+func (dialogStore) GetSnoozes(context.Context, string, string) (map[string]chat.Snooze, error) {
+	return nil, nil
+}
+
+func (dialogStore) IsHidden(context.Context, string, string) (bool, error) { return false, nil }
+
+func TestChatHandlerInit(t *testing.T) {
+	hub, err := chat.NewServer(dialogStore{}).GetDialog(context.Background(), testUUID, testPeer)
+	require.NoError(t, err)
+	service := &resttest.Service{
+		GetDialogFunc: func(context.Context, string, string) (*chat.Hub, error) { return hub, nil },
+		GetConversationInitFunc: func(_ context.Context, uuid, peer string) (*models.ConversationInit, error) {
+			return &models.ConversationInit{
+				Type:           chat.EventTypeConversationInit,
+				ConversationID: chat.ConversationID(uuid, peer),
+				Peer:           &models.Profile{UUID: peer},
+				UnreadCount:    3,
+				LastReadID:     7,
+			}, nil
+		},
+	}
+	h := newHandler(logrus.New(), service, nil, tokenRules{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("uuid", testPeer)
+		h.chatHandler(w, r.WithContext(context.WithValue(context.WithValue(r.Context(), chi.RouteCtxKey, rctx), uuidKey, testUUID)))
+	}))
+	defer ts.Close()
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, frame, err := conn.ReadMessage()
+	require.NoError(t, err)
+	var init models.ConversationInit
+	require.NoError(t, json.Unmarshal(frame, &init), "the init frame arrives first")
+	require.Equal(t, chat.EventTypeConversationInit, init.Type)
+	require.Equal(t, hub.ConversationID(), init.ConversationID)
+	require.Equal(t, testPeer, init.Peer.UUID)
+	require.Equal(t, int64(3), init.UnreadCount)
+	require.Equal(t, int64(7), init.LastReadID)
+	require.Equal(t, []resttest.Call{{Method: "GetConversationInit", Args: []interface{}{testUUID, testPeer}}},
+		service.Calls("GetConversationInit"))
+}
+
+func TestStreamHandler(t *testing.T) {
+	notifications := notify.NewServer()
+	seq, err := notifications.Send(testUUID, map[string]string{"type": "match"})
+	require.NoError(t, err)
+	service := &resttest.Service{
+		ServeChatStreamFunc: func(_ http.ResponseWriter, _ *http.Request, _ string, hooks chat.StreamHooks) error {
+			// pending notifications are forwarded to the stream, acks of the stream reach the server
+			var event notify.Event
+			require.NoError(t, json.Unmarshal(<-hooks.Notifications, &event))
+			require.Equal(t, seq, event.Seq)
+			hooks.Ack(event.Seq)
+			return common.ErrStoreUnavailable
+		},
+	}
+	h := newHandler(logrus.New(), service, nil, tokenRules{})
+	h.notifications = notifications
+	r := httptest.NewRequest(http.MethodGet, "/public/v1/stream", nil)
+	r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+	w := httptest.NewRecorder()
+	h.streamHandler(w, r)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, []resttest.Call{{Method: "ServeChatStream", Args: []interface{}{testUUID}}}, service.Calls("ServeChatStream"))
+	require.Zero(t, notifications.Pending(testUUID))
+}
+
+func TestEditMessage(t *testing.T) {
+	editedAt := common.NewTimestamp(time.Date(2022, time.June, 20, 12, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"edited", nil, http.StatusOK},
+		{"peer's message", common.ErrNotMessageSender, http.StatusForbidden},
+		{"too old", common.ErrEditWindowExpired, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			service := &resttest.Service{
+				EditMessageFunc: func(_ context.Context, uuid, targetUUID string, id int64, body string) (*chat.Message, error) {
+					if tt.err != nil {
+						return nil, tt.err
+					}
+					return &chat.Message{ID: id, Sender: uuid, Receiver: targetUUID, Body: body, EditedAt: &editedAt}, nil
+				},
+			}
+			h := newHandler(logrus.New(), service, nil, tokenRules{})
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("uuid", testPeer)
+			rctx.URLParams.Add("id", "42")
+			r := httptest.NewRequest(http.MethodPost, "/public/v1/chat/"+testPeer+"/message/42/edit",
+				strings.NewReader(`{"body": "hello"}`))
+			r = r.WithContext(context.WithValue(context.WithValue(r.Context(), chi.RouteCtxKey, rctx), uuidKey, testUUID))
+			w := httptest.NewRecorder()
+			h.editMessage(w, r)
+			require.Equal(t, tt.status, w.Code)
+			calls := service.Calls("EditMessage")
+			require.Len(t, calls, 1)
+			require.Equal(t, []interface{}{testUUID, testPeer, int64(42), "hello"}, calls[0].Args)
+			if tt.err != nil {
+				return
+			}
+			var response struct {
+				Data chat.Message `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			require.Equal(t, int64(42), response.Data.ID)
+			require.Equal(t, &editedAt, response.Data.EditedAt)
+		})
+	}
+}
+
+func TestReactToMessage(t *testing.T) {
+	service := &resttest.Service{
+		ReactToMessageFunc: func(_ context.Context, uuid, _ string, _ int64, emoji string) (*chat.Reaction, error) {
+			if emoji != "👍" {
+				return nil, common.ErrInvalidReaction
+			}
+			return &chat.Reaction{User: uuid, Emoji: emoji}, nil
+		},
+		RemoveReactionFunc: func(_ context.Context, _, _ string, id int64) error {
+			if id != 42 {
+				return common.ErrReactionNotFound
+			}
+			return nil
+		},
+	}
+	h := newHandler(logrus.New(), service, nil, tokenRules{})
+	request := func(method, id, body string) *httptest.ResponseRecorder {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("uuid", testPeer)
+		rctx.URLParams.Add("id", id)
+		r := httptest.NewRequest(method, "/public/v1/chat/"+testPeer+"/message/"+id+"/react", strings.NewReader(body))
+		r = r.WithContext(context.WithValue(context.WithValue(r.Context(), chi.RouteCtxKey, rctx), uuidKey, testUUID))
+		w := httptest.NewRecorder()
+		if method == http.MethodDelete {
+			h.removeReaction(w, r)
+		} else {
+			h.reactToMessage(w, r)
+		}
+		return w
+	}
+
+	w := request(http.MethodPost, "42", `{"emoji": "👍"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Data chat.Reaction `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Equal(t, chat.Reaction{User: testUUID, Emoji: "👍"}, response.Data)
+	require.Equal(t, []interface{}{testUUID, testPeer, int64(42), "👍"}, service.Calls("ReactToMessage")[0].Args)
+
+	require.Equal(t, http.StatusBadRequest, request(http.MethodPost, "42", `{"emoji": "🦄"}`).Code)
+	require.Equal(t, http.StatusBadRequest, request(http.MethodPost, "x", `{"emoji": "👍"}`).Code)
+	require.Equal(t, http.StatusOK, request(http.MethodDelete, "42", "").Code)
+	require.Equal(t, http.StatusNotFound, request(http.MethodDelete, "43", "").Code)
+	require.Equal(t, []interface{}{testUUID, testPeer, int64(42)}, service.Calls("RemoveReaction")[0].Args)
+}
+
+func TestDevices(t *testing.T) {
+	service := &resttest.Service{
+		RegisterDeviceFunc: func(_ context.Context, _ string, platform models.DevicePlatform, token string) (*models.Device, error) {
+			device := &models.Device{ID: 7, Platform: platform, Token: token}
+			if err := device.Validate(); err != nil {
+				return nil, err
+			}
+			return device, nil
+		},
+		UnregisterDeviceFunc: func(_ context.Context, _ string, id int64) error {
+			if id != 7 {
+				return common.ErrDeviceNotFound
+			}
+			return nil
+		},
+	}
+	h := newHandler(logrus.New(), service, nil, tokenRules{})
+	register := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/public/v1/devices", strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+		w := httptest.NewRecorder()
+		h.registerDevice(w, r)
+		return w
+	}
+	unregister := func(id string) *httptest.ResponseRecorder {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		r := httptest.NewRequest(http.MethodDelete, "/public/v1/devices/"+id, nil)
+		r = r.WithContext(context.WithValue(context.WithValue(r.Context(), chi.RouteCtxKey, rctx), uuidKey, testUUID))
+		w := httptest.NewRecorder()
+		h.unregisterDevice(w, r)
+		return w
+	}
+
+	w := register(`{"platform": "ios", "token": "abc"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Data models.Device `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Equal(t, models.Device{ID: 7, Platform: models.PlatformIOS, Token: "abc"}, response.Data)
+	require.Equal(t, []interface{}{testUUID, models.PlatformIOS, "abc"}, service.Calls("RegisterDevice")[0].Args)
+
+	require.Equal(t, http.StatusBadRequest, register(`{"platform": "symbian", "token": "abc"}`).Code)
+	require.Equal(t, http.StatusBadRequest, register(`{"platform": "ios", "token": " "}`).Code)
+	require.Equal(t, http.StatusBadRequest, register(`{"platform": "ios"`).Code)
+	require.Equal(t, http.StatusOK, unregister("7").Code)
+	require.Equal(t, http.StatusNotFound, unregister("8").Code)
+	require.Equal(t, http.StatusBadRequest, unregister("x").Code)
+	require.Equal(t, []interface{}{testUUID, int64(7)}, service.Calls("UnregisterDevice")[0].Args)
+}
+
+func TestPhotos(t *testing.T) {
+	service := &resttest.Service{
+		AddPhotoFunc: func(_ context.Context, _, link string, replaceOldest bool) (*models.Photo, error) {
+			if !replaceOldest {
+				return nil, common.ErrPhotoLimitReached
+			}
+			return &models.Photo{ID: 4, Link: link, Position: 2}, nil
+		},
+		DeletePhotoFunc: func(_ context.Context, _ string, id int64) error {
+			if id != 4 {
+				return common.ErrPhotoNotFound
+			}
+			return nil
+		},
+	}
+	h := newHandler(logrus.New(), service, nil, tokenRules{})
+	add := func(query, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/public/v1/photos"+query, strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+		w := httptest.NewRecorder()
+		h.addPhoto(w, r)
+		return w
+	}
+	remove := func(id string) *httptest.ResponseRecorder {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		r := httptest.NewRequest(http.MethodDelete, "/public/v1/photos/"+id, nil)
+		r = r.WithContext(context.WithValue(context.WithValue(r.Context(), chi.RouteCtxKey, rctx), uuidKey, testUUID))
+		w := httptest.NewRecorder()
+		h.deletePhoto(w, r)
+		return w
+	}
+
+	w := add("", `{"link": "https://example.com/4.jpg"}`)
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Contains(t, w.Body.String(), `"error_code":"conflict"`)
+	w = add("?replace_oldest=true", `{"link": "https://example.com/4.jpg"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"data":{"id":4,"link":"https://example.com/4.jpg","position":2}}`, w.Body.String())
+	require.Equal(t, []interface{}{testUUID, "https://example.com/4.jpg", true}, service.Calls("AddPhoto")[1].Args)
+	require.Equal(t, http.StatusBadRequest, add("?replace_oldest=maybe", `{"link": "a"}`).Code)
+	require.Len(t, service.Calls("AddPhoto"), 2)
+
+	require.Equal(t, http.StatusOK, remove("4").Code)
+	require.Equal(t, http.StatusNotFound, remove("5").Code)
+	require.Equal(t, http.StatusBadRequest, remove("x").Code)
+	require.Equal(t, []interface{}{testUUID, int64(4)}, service.Calls("DeletePhoto")[0].Args)
+}
+
+func TestGetConfigETag(t *testing.T) {
+	config := &models.Config{UUID: testUUID, Version: 3}
+	service := &resttest.Service{
+		GetConfigFunc: func(context.Context, string) (*models.Config, error) {
+			return config, nil
+		},
+	}
+	h := newHandler(logrus.New(), service, nil, tokenRules{})
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/public/v1/config", nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+		w := httptest.NewRecorder()
+		h.getConfig(w, r)
+		return w
+	}
+	w := get("")
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	w = get(etag)
+	require.Equal(t, http.StatusNotModified, w.Code)
+	require.Empty(t, w.Body.String())
+	require.Equal(t, etag, w.Header().Get("ETag"))
+	require.Equal(t, http.StatusNotModified, get(`"1", W/`+etag).Code)
+
+	config.Version++
+	w = get(etag)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotEqual(t, etag, w.Header().Get("ETag"))
+	require.Contains(t, w.Body.String(), testUUID)
+
+	// an expired pause is dropped from the config without a save
+	pause := common.NewTimestamp(time.Now().Add(time.Hour))
+	config.PauseUntil = &pause
+	etag = get("").Header().Get("ETag")
+	config.PauseUntil = nil
+	require.Equal(t, http.StatusOK, get(etag).Code)
+}
+
+func TestSaveSettings(t *testing.T) {
+	service := &resttest.Service{
+		GetSettingsFunc: func(_ context.Context, uuid string) (*models.Settings, error) {
+			settings := models.DefaultSettings(uuid)
+			settings.Theme, settings.Incognito = 2, true
+			settings.Notifications.QuietHours = &models.QuietHours{Start: "22:00", End: "07:00"}
+			return settings, nil
+		},
+	}
+	h := newHandler(logrus.New(), service, nil, tokenRules{})
+	body := `{"uuid": "` + testPeer + `", "language": "en", "notifications": {"messages": false}}`
+	r := httptest.NewRequest(http.MethodPut, "/public/v1/settings", strings.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+	w := httptest.NewRecorder()
+	h.saveSettings(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	calls := service.Calls("SaveSettings")
+	require.Len(t, calls, 1)
+	require.Equal(t, []interface{}{&models.Settings{UUID: testUUID, Theme: 2, Language: "en", Incognito: true,
+		Notifications: models.NotificationSettings{Matches: true, Likes: true,
+			QuietHours: &models.QuietHours{Start: "22:00", End: "07:00"}},
+		Privacy: models.PrivacySettings{ShowAge: true, ShowDistance: true}}}, calls[0].Args,
+		"the uuid comes from the token, omitted fields are kept")
+	require.Empty(t, service.Calls("SaveConfig"))
+}
+
+func TestPatchConfig(t *testing.T) {
+	patch := `{"personal": {"gender": 0}}`
+	service := &resttest.Service{
+		PatchConfigFunc: func(context.Context, string, []byte) ([]models.Warning, error) {
+			return nil, common.ErrGenderNotSpecified
+		},
+	}
+	h := newHandler(logrus.New(), service, nil, tokenRules{})
+	r := httptest.NewRequest(http.MethodPatch, "/public/v1/config", strings.NewReader(patch))
+	r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+	w := httptest.NewRecorder()
+	h.patchConfig(w, r)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	calls := service.Calls("PatchConfig")
+	require.Len(t, calls, 1)
+	require.Equal(t, []interface{}{testUUID, []byte(patch)}, calls[0].Args)
+}
+
+func TestGetAllChatsDegraded(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		status   int
+		degraded bool
+	}{
+		{"profiles down", &common.DegradedError{Skipped: []string{"profiles"}, Err: errors.New("err connection refused")},
+			http.StatusOK, true},
+		{"chats down", errors.New("err connection refused"), http.StatusInternalServerError, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			service := &resttest.Service{
+				GetAllChatsFunc: func(context.Context, string, bool, int64, int64) ([]*models.Profile, error) {
+					if tt.degraded {
+						return []*models.Profile{{UUID: testPeer}}, tt.err
+					}
+					return nil, tt.err
+				},
+			}
+			h := newHandler(logrus.New(), service, nil, tokenRules{})
+			r := httptest.NewRequest(http.MethodGet, "/public/v1/chats", nil)
+			r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+			w := httptest.NewRecorder()
+			h.getAllChats(w, r)
+			require.Equal(t, tt.status, w.Code)
+			var response JSONResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			if !tt.degraded {
+				require.Nil(t, response.Meta)
+				return
+			}
+			require.Nil(t, response.Error)
+			require.Equal(t, &Meta{Count: 1, Degraded: true, Skipped: []string{"profiles"}}, response.Meta)
+			require.Contains(t, w.Body.String(), testPeer)
+		})
+	}
+}
+
+func TestListDecisionsTimeRange(t *testing.T) {
+	service := &resttest.Service{}
+	h := newHandler(logrus.New(), service, nil, tokenRules{})
+	list := func(query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/public/v1/decisions"+query, nil)
+		r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+		w := httptest.NewRecorder()
+		h.listDecisions(w, r)
+		return w
+	}
+	w := list("?from=2022-06-01T00:00:00Z&to=2022-06-30T12:00:00%2B03:00")
+	require.Equal(t, http.StatusOK, w.Code)
+	calls := service.Calls("ListDecisions")
+	require.Len(t, calls, 1)
+	period := calls[0].Args[2].(models.TimeRange)
+	require.True(t, time.Date(2022, time.June, 1, 0, 0, 0, 0, time.UTC).Equal(period.From))
+	require.True(t, time.Date(2022, time.June, 30, 9, 0, 0, 0, time.UTC).Equal(period.To))
+	require.Equal(t, time.UTC, period.To.Location(), "bounds are normalized to UTC")
+
+	w = list("?to=2022-06-30")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "invalid to")
+	require.Len(t, service.Calls("ListDecisions"), 1)
+}
+
+func TestListDecisionsEstimate(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		exact bool
+		meta  *Meta
+	}{
+		{"estimated", "", false, &Meta{Count: 1000, CountIsEstimate: true}},
+		{"exact requested", "?exact_count=true", true, &Meta{Count: 1234}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			service := &resttest.Service{
+				ListDecisionsFunc: func(_ context.Context, _, _ string, _ models.TimeRange, _, _ int64, exact bool) ([]*models.Decision, models.Total, error) { //nolint:lll
+					if exact {
+						return []*models.Decision{}, models.Total{Count: 1234}, nil
+					}
+					return []*models.Decision{}, models.Total{Count: 1000, Estimate: true}, nil
+				},
+			}
+			h := newHandler(logrus.New(), service, nil, tokenRules{})
+			r := httptest.NewRequest(http.MethodGet, "/public/v1/decisions"+tt.query, nil)
+			r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+			w := httptest.NewRecorder()
+			h.listDecisions(w, r)
+			require.Equal(t, http.StatusOK, w.Code)
+			var response JSONResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			require.Equal(t, tt.meta, response.Meta)
+			calls := service.Calls("ListDecisions")
+			require.Len(t, calls, 1)
+			require.Equal(t, tt.exact, calls[0].Args[5])
+		})
+	}
+}
+
+func TestResetDecisions(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		reset  bool
+	}{
+		{"confirmed", `{"confirm": true}`, http.StatusOK, true},
+		{"not confirmed", `{"confirm": false}`, http.StatusBadRequest, false},
+		{"missing confirm", `{}`, http.StatusBadRequest, false},
+		{"invalid body", `confirm`, http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			service := &resttest.Service{
+				ResetDecisionsFunc: func(context.Context, string) (int, error) { return 3, nil },
+			}
+			h := newHandler(logrus.New(), service, nil, tokenRules{})
+			r := httptest.NewRequest(http.MethodPost, "/public/v1/decisions/reset", strings.NewReader(tt.body))
+			r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+			w := httptest.NewRecorder()
+			h.resetDecisions(w, r)
+			require.Equal(t, tt.status, w.Code)
+			if !tt.reset {
+				require.Empty(t, service.Calls(""))
+				return
+			}
+			var response JSONResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			require.Equal(t, &Meta{Count: 3}, response.Meta)
+			calls := service.Calls("ResetDecisions")
+			require.Len(t, calls, 1)
+			require.Equal(t, []interface{}{testUUID}, calls[0].Args)
+		})
+	}
+}
+
+func TestListIncomingLikes(t *testing.T) {
+	service := &resttest.Service{
+		ListIncomingLikesFunc: func(_ context.Context, _, _ string, _ int64) ([]*models.IncomingLike, string, error) {
+			return []*models.IncomingLike{{UUID: "liker", Profile: &models.Profile{UUID: "liker"}, SuperLike: true}}, "next", nil
+		},
+	}
+	h := newHandler(logrus.New(), service, nil, tokenRules{})
+	list := func(query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/public/v1/likes/incoming"+query, nil)
+		r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+		w := httptest.NewRecorder()
+		h.listIncomingLikes(w, r)
+		return w
+	}
+	w := list("?cursor=abc&limit=5")
+	require.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Data []*models.IncomingLike `json:"data"`
+		Meta *Meta                  `json:"meta"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Data, 1)
+	require.True(t, response.Data[0].SuperLike)
+	require.Equal(t, &Meta{Count: 1, NextCursor: "next"}, response.Meta)
+	require.Equal(t, []interface{}{testUUID, "abc", int64(5)}, service.Calls("ListIncomingLikes")[0].Args)
+
+	w = list("")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, int64(0), service.Calls("ListIncomingLikes")[1].Args[2], "the service picks the default")
+
+	require.Equal(t, http.StatusBadRequest, list("?limit=-1").Code)
+	require.Len(t, service.Calls("ListIncomingLikes"), 2)
+}
+
+func TestListViewers(t *testing.T) {
+	service := &resttest.Service{
+		ListProfileViewersFunc: func(context.Context, string, int64) ([]*models.ProfileView, error) {
+			return []*models.ProfileView{{
+				UUID:     "viewer",
+				Profile:  &models.Profile{UUID: "viewer"},
+				ViewedAt: common.NewTimestamp(time.Date(2022, 7, 2, 12, 0, 0, 0, time.UTC)),
+				Views:    2,
+			}}, nil
+		},
+	}
+	h := newHandler(logrus.New(), service, nil, tokenRules{})
+	list := func(query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/public/v1/viewers"+query, nil)
+		r = r.WithContext(context.WithValue(r.Context(), uuidKey, testUUID))
+		w := httptest.NewRecorder()
+		h.listViewers(w, r)
+		return w
+	}
+	w := list("?limit=5")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{
+		"data": [{"uuid": "viewer", "profile": {"uuid": "viewer"}, "viewed_at": "2022-07-02T12:00:00.000Z", "views": 2}],
+		"meta": {"count": 1}
+	}`, w.Body.String())
+	require.Equal(t, []interface{}{testUUID, int64(5)}, service.Calls("ListProfileViewers")[0].Args)
+	require.Equal(t, http.StatusBadRequest, list("?limit=x").Code)
+	require.Len(t, service.Calls("ListProfileViewers"), 1)
+}
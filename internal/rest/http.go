@@ -4,75 +4,583 @@ import (
 	"compress/flate"
 	"context"
 	"crypto/rsa"
-	"encoding/json"
+	"fmt"
+	"mime"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gerladeno/homie-core/pkg/chat"
+	"github.com/gerladeno/homie-core/pkg/notify"
 
 	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
 	"github.com/gerladeno/homie-core/pkg/metrics"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 )
 
 type Service interface {
-	SaveConfig(ctx context.Context, config *models.Config) error
+	SaveConfig(ctx context.Context, config *models.Config) ([]models.Warning, error)
+	PatchConfig(ctx context.Context, uuid string, patch []byte) ([]models.Warning, error)
 	GetConfig(ctx context.Context, uuid string) (*models.Config, error)
+	GetSharedAttributes(ctx context.Context, uuid, targetUUID string) (*models.SharedAttributes, error)
+	GetCommonContext(ctx context.Context, uuid, targetUUID string) (*models.CommonContext, error)
+	GetSettings(ctx context.Context, uuid string) (*models.Settings, error)
+	SaveSettings(ctx context.Context, settings *models.Settings) error
 	GetRegions(ctx context.Context) ([]*models.Region, error)
+	UpsertRegion(ctx context.Context, region *models.Region) error
+	AddPhoto(ctx context.Context, uuid, link string, replaceOldest bool) (*models.Photo, error)
+	PresignPhoto(ctx context.Context, uuid string) (*models.PhotoUpload, error)
+	ConfirmPhoto(ctx context.Context, uuid, key string, replaceOldest bool) (*models.Photo, error)
+	DeletePhoto(ctx context.Context, uuid string, id int64) error
+	ListPhotos(ctx context.Context, uuid string) ([]*models.Photo, error)
+	ReorderPhotos(ctx context.Context, uuid string, ids []int64) error
 	Like(ctx context.Context, uuid, targetUUID string, super bool) error
+	LikeAndOpenChat(ctx context.Context, uuid, targetUUID string, super bool) (string, error)
 	Dislike(ctx context.Context, uuid, targetUUID string) error
-	ListLikedProfiles(ctx context.Context, uuid string, limit, offset int64) ([]*models.Profile, error)
-	ListDislikedProfiles(ctx context.Context, uuid string, limit, offset int64) ([]*models.Profile, error)
-	GetMatches(ctx context.Context, uuid string, count int64) ([]*models.Profile, error)
-	GetDialog(ctx context.Context, client, target string) *chat.Hub
-	GetAllChats(ctx context.Context, uuid string) ([]*models.Profile, error)
+	GetMatch(ctx context.Context, uuid, targetUUID string) (*models.Match, error)
+	GetMatchesWithState(ctx context.Context, uuid string, limit, offset int64) ([]*models.MatchWithState, error)
+	GetRelationship(ctx context.Context, uuid, targetUUID string) (*models.Relationship, error)
+	GetRelationships(ctx context.Context, uuid string, targets []string) (map[string]*models.Relationship, error)
+	ArchiveMatch(ctx context.Context, uuid, targetUUID string) error
+	Hide(ctx context.Context, uuid, targetUUID string) error
+	Unhide(ctx context.Context, uuid, targetUUID string) error
+	Reconsider(ctx context.Context, uuid, targetUUID string) error
+	ResetDecisions(ctx context.Context, uuid string) (int, error)
+	ListLikedProfiles(ctx context.Context, uuid string, period models.TimeRange, limit, offset int64) ([]*models.Profile, error)
+	ListDislikedProfiles(ctx context.Context, uuid string, period models.TimeRange, limit, offset int64) ([]*models.Profile, error)
+	ListIncomingLikes(ctx context.Context, uuid, cursor string, limit int64) ([]*models.IncomingLike, string, error)
+	ListProfileViewers(ctx context.Context, uuid string, limit int64) ([]*models.ProfileView, error)
+	GetUserTimeseries(ctx context.Context, uuid string, from, to time.Time) ([]models.DayBucket, error)
+	ListDecisions(ctx context.Context, uuid, action string, period models.TimeRange, limit, offset int64, exact bool) ([]*models.Decision, models.Total, error) //nolint:lll
+	GetMatches(ctx context.Context, uuid string, count int64, sort models.FeedSort, newOnly bool) ([]*models.Profile, error)
+	CountCandidates(ctx context.Context, uuid string, newOnly bool) (models.Total, error)
+	GetDailyPick(ctx context.Context, uuid string) (*models.Profile, error)
+	GetFeedByRegion(ctx context.Context, uuid string, sample int64, sort models.FeedSort) (map[int64]*models.RegionFeed, error)
+	GetFeedPage(ctx context.Context, uuid string, count int64, sort models.FeedSort, newOnly bool, token string, offset int64) ([]*models.Profile, string, error) //nolint:lll
+	StreamMatches(ctx context.Context, uuid string, count int64, sort models.FeedSort, fn func(*models.Profile) error) error
+	GetDialog(ctx context.Context, client, target string) (*chat.Hub, error)
+	GetConversationInit(ctx context.Context, uuid, peer string) (*models.ConversationInit, error)
+	ServeChatStream(w http.ResponseWriter, r *http.Request, uuid string, hooks chat.StreamHooks) error
+	GetAllChats(ctx context.Context, uuid string, includeArchived bool, limit, offset int64) ([]*models.Profile, error)
+	ArchiveChat(ctx context.Context, uuid, targetUUID string, archived bool) error
+	SnoozeChat(ctx context.Context, uuid, targetUUID string, until time.Time) error
+	RetractMessage(ctx context.Context, uuid, targetUUID string, id int64) error
+	EditMessage(ctx context.Context, uuid, targetUUID string, id int64, body string) (*chat.Message, error)
+	ReportMessage(ctx context.Context, uuid, targetUUID string, id int64, reason string) (*models.MessageReport, error)
+	ReactToMessage(ctx context.Context, uuid, targetUUID string, id int64, emoji string) (*chat.Reaction, error)
+	RemoveReaction(ctx context.Context, uuid, targetUUID string, id int64) error
+	ExportChat(ctx context.Context, uuid, targetUUID string, fn func(*chat.Message) error) error
+	MarkAllChatsRead(ctx context.Context, uuid string) (int, error)
+	CountMatches(ctx context.Context, uuid string) (int64, error)
+	GetTotalUnread(ctx context.Context, uuid string) (int64, error)
+	GetLimits() models.Limits
+	GetQuota(ctx context.Context, uuid string) (*models.Quota, error)
+	GetConfigSchema() *models.ConfigSchema
+	ResolveConversation(ctx context.Context, uuid, id string) (string, error)
+	ChatStats() chat.Stats
+	GetRegionStats(ctx context.Context, since time.Time) ([]*models.RegionStats, error)
+	IsBanned(ctx context.Context, subject, device string) (bool, error)
+	BanIdentity(ctx context.Context, ban *models.Ban) error
+	UnbanIdentity(ctx context.Context, kind models.BanKind, value string) error
+	ListBans(ctx context.Context) ([]*models.Ban, error)
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	RevokeToken(ctx context.Context, token *models.RevokedToken) error
+	RecordAudit(ctx context.Context, entry *models.AuditEntry) error
+	ListMessageReports(ctx context.Context) ([]*models.MessageReport, error)
+	RepairMatches(ctx context.Context) (*models.MatchRepair, error)
+	StartBoost(ctx context.Context, uuid string, duration time.Duration) error
+	GetBoostStatus(ctx context.Context, uuid string) (*models.BoostStatus, error)
+	RegisterDevice(ctx context.Context, uuid string, platform models.DevicePlatform, token string) (*models.Device, error)
+	UnregisterDevice(ctx context.Context, uuid string, id int64) error
 }
 
 const gitURL = "https://github.com/gerladeno/homie-core"
 
-func NewRouter(log *logrus.Logger, service Service, key *rsa.PublicKey, host, version string) chi.Router {
-	handler := newHandler(log, service, key)
+type options struct {
+	compressMinSize int
+	// compressExcludedTypes are media types never compressed.
+	compressExcludedTypes []string
+	tokenRules            tokenRules
+	adminToken            string
+	maxMatchesCount       int64
+	// maxStreamMatchesCount caps the count of streamed matches, which may be larger than of listed ones.
+	maxStreamMatchesCount int64
+	requestTimeout        time.Duration
+	// notifications serves the notifications websocket unless it's nil.
+	notifications *notify.Server
+	// features turn routes off, all of them are on if it's nil.
+	features *FeatureFlags
+	// accessLogSample logs 1 in that many requests, except for those answered with accessLogAlwaysStatus
+	// or above and taking longer than accessLogAlwaysSlower, which are always logged.
+	accessLogSample       int
+	accessLogAlwaysStatus int
+	accessLogAlwaysSlower time.Duration
+	// ipRateLimit is the count of requests a second to unauthenticated routes a client IP may make on average
+	// and ipRateBurst at once, zero ipRateLimit disables limiting.
+	ipRateLimit float64
+	ipRateBurst int
+	// maxInFlightWrites is the count of writes a user may have in flight at once, zero disables limiting.
+	maxInFlightWrites int
+	// trustedProxies are CIDRs or IPs of proxies whose X-Forwarded-For and X-Real-IP headers are believed.
+	trustedProxies []string
+	// anonymizeIPs anonymizes client IPs past realIP, keepFullIPs keeps full ones for limiting rate by.
+	anonymizeIPs bool
+	keepFullIPs  bool
+	// prettyResponses indents all JSON responses, prettyOnQuery those of requests with ?pretty=true.
+	prettyResponses bool
+	prettyOnQuery   bool
+	// securityHeaders are set on every response but WebSocket handshakes.
+	securityHeaders SecurityHeaders
+	// requireRequestID rejects requests without X-Request-Id but to the raw endpoints.
+	requireRequestID bool
+	// impersonationTTL is the lifetime of impersonation tokens, which are signed by tokenRules.impersonationKey.
+	impersonationTTL time.Duration
+	ids              common.IDGenerator
+}
+
+const defaultRequestTimeout = 30 * time.Second
+
+// validate returns an error listing every problem of the options and dependencies of the router.
+func (o *options) validate(service Service, key *rsa.PublicKey) error {
+	var problems []string
+	if service == nil {
+		problems = append(problems, "service is missing")
+	}
+	if key == nil {
+		problems = append(problems, "public signing key is missing")
+	}
+	if o.compressMinSize < 0 {
+		problems = append(problems, fmt.Sprintf("compress min size %d is negative", o.compressMinSize))
+	}
+	for _, t := range o.compressExcludedTypes {
+		if _, _, err := mime.ParseMediaType(t); err != nil {
+			problems = append(problems, fmt.Sprintf("compress excluded type %q is invalid", t))
+		}
+	}
+	if o.tokenRules.leeway < 0 {
+		problems = append(problems, fmt.Sprintf("token leeway %s is negative", o.tokenRules.leeway))
+	}
+	for _, claim := range o.tokenRules.requiredClaims {
+		if claim == "" {
+			problems = append(problems, "required claims contain an empty name")
+			break
+		}
+	}
+	if o.maxMatchesCount <= 0 {
+		problems = append(problems, fmt.Sprintf("max matches count %d is not positive", o.maxMatchesCount))
+	}
+	if o.maxStreamMatchesCount <= 0 {
+		problems = append(problems, fmt.Sprintf("max stream matches count %d is not positive", o.maxStreamMatchesCount))
+	}
+	if o.requestTimeout <= 0 {
+		problems = append(problems, fmt.Sprintf("request timeout %s is not positive", o.requestTimeout))
+	}
+	if o.accessLogSample <= 0 {
+		problems = append(problems, fmt.Sprintf("access log sample %d is not positive", o.accessLogSample))
+	}
+	if o.accessLogAlwaysSlower < 0 {
+		problems = append(problems, fmt.Sprintf("access log slow threshold %s is negative", o.accessLogAlwaysSlower))
+	}
+	if o.ipRateLimit < 0 {
+		problems = append(problems, fmt.Sprintf("ip rate limit %g is negative", o.ipRateLimit))
+	}
+	if o.ipRateLimit > 0 && o.ipRateBurst < 1 {
+		problems = append(problems, fmt.Sprintf("ip rate burst %d is not positive", o.ipRateBurst))
+	}
+	if o.maxInFlightWrites < 0 {
+		problems = append(problems, fmt.Sprintf("max in-flight writes %d is negative", o.maxInFlightWrites))
+	}
+	if key := o.tokenRules.impersonationKey; key != nil && len(key) < minImpersonationKeySize {
+		problems = append(problems, fmt.Sprintf("impersonation key is shorter than %d bytes", minImpersonationKeySize))
+	}
+	if o.impersonationTTL <= 0 {
+		problems = append(problems, fmt.Sprintf("impersonation ttl %s is not positive", o.impersonationTTL))
+	}
+	if o.securityHeaders.HSTSMaxAge < 0 {
+		problems = append(problems, fmt.Sprintf("hsts max age %s is negative", o.securityHeaders.HSTSMaxAge))
+	}
+	for _, proxy := range o.trustedProxies {
+		if _, err := parseProxies([]string{proxy}); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	for _, feature := range o.features.unknown() {
+		problems = append(problems, fmt.Sprintf("feature %q is unknown", feature))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("err invalid router config: %s", strings.Join(problems, "; "))
+}
+
+type Option func(*options)
+
+// WithCompressMinSize sets the minimum size of a response in bytes to compress it.
+func WithCompressMinSize(size int) Option {
+	return func(o *options) {
+		o.compressMinSize = size
+	}
+}
+
+// WithCompressExcludedTypes sets media types never compressed, replacing the default ones
+// compressed already: image/jpeg, image/png, image/webp and application/zip.
+func WithCompressExcludedTypes(types []string) Option {
+	return func(o *options) {
+		o.compressExcludedTypes = types
+	}
+}
+
+// WithAudience requires access tokens to have the audience in their aud claim.
+func WithAudience(audience string) Option {
+	return func(o *options) {
+		o.tokenRules.audience = audience
+	}
+}
+
+// WithLeeway allows clock skew between the token issuer and the service.
+func WithLeeway(leeway time.Duration) Option {
+	return func(o *options) {
+		o.tokenRules.leeway = leeway
+	}
+}
+
+// WithRequiredClaims requires access tokens to have the claims non-empty.
+func WithRequiredClaims(claims []string) Option {
+	return func(o *options) {
+		o.tokenRules.requiredClaims = claims
+	}
+}
+
+// WithMaxMatchesCount caps the count of matches a client may ask for at once.
+func WithMaxMatchesCount(count int64) Option {
+	return func(o *options) {
+		o.maxMatchesCount = count
+	}
+}
+
+// WithMaxStreamMatchesCount caps the count of matches a client may stream at once.
+func WithMaxStreamMatchesCount(count int64) Option {
+	return func(o *options) {
+		o.maxStreamMatchesCount = count
+	}
+}
+
+// WithRequestTimeout sets how long a request may be served before it is canceled.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.requestTimeout = timeout
+	}
+}
+
+// WithAdminToken enables admin routes for requests with the token in the X-Admin-Token header.
+func WithAdminToken(token string) Option {
+	return func(o *options) {
+		o.adminToken = token
+	}
+}
+
+// WithNotifications serves events of the server to users over the notifications websocket.
+func WithNotifications(notifications *notify.Server) Option {
+	return func(o *options) {
+		o.notifications = notifications
+	}
+}
+
+// WithFeatureFlags turns off routes of the features disabled by the flags, which may change later.
+func WithFeatureFlags(features *FeatureFlags) Option {
+	return func(o *options) {
+		o.features = features
+	}
+}
+
+// WithAccessLogSample logs 1 in every requests but those always logged, every one by default.
+func WithAccessLogSample(every int) Option {
+	return func(o *options) {
+		o.accessLogSample = every
+	}
+}
+
+// WithAccessLogAlwaysStatus always logs requests answered with the status or above, 400 by default.
+func WithAccessLogAlwaysStatus(status int) Option {
+	return func(o *options) {
+		o.accessLogAlwaysStatus = status
+	}
+}
+
+// WithAccessLogAlwaysSlower always logs requests taking longer than d, 1s by default. Zero samples
+// slow requests as any other.
+func WithAccessLogAlwaysSlower(d time.Duration) Option {
+	return func(o *options) {
+		o.accessLogAlwaysSlower = d
+	}
+}
+
+// WithIPRateLimit lets a client IP request unauthenticated routes rate times a second on average, 10 by default.
+// Zero disables limiting.
+func WithIPRateLimit(rate float64) Option {
+	return func(o *options) {
+		o.ipRateLimit = rate
+	}
+}
+
+// WithIPRateBurst lets a client IP request unauthenticated routes burst times at once, 20 by default.
+func WithIPRateBurst(burst int) Option {
+	return func(o *options) {
+		o.ipRateBurst = burst
+	}
+}
+
+// WithMaxInFlightWrites lets a user have up to max writes, likes and dislikes in flight at once, 10 by default,
+// answering 429 to more of them. Unlike the rate limits it bounds concurrency. Zero disables limiting.
+func WithMaxInFlightWrites(max int) Option {
+	return func(o *options) {
+		o.maxInFlightWrites = max
+	}
+}
+
+// WithTrustedProxies sets CIDRs or IPs of proxies the client IP is taken from the X-Forwarded-For
+// and X-Real-IP headers of requests of, the remote address is the client IP of others. None by default.
+func WithTrustedProxies(proxies []string) Option {
+	return func(o *options) {
+		o.trustedProxies = proxies
+	}
+}
+
+// WithIPAnonymization anonymizes client IPs in the access log and metrics, by zeroing the last octet of
+// IPv4 addresses and all but the first 48 bits of IPv6 ones. Rate is limited by anonymized IPs too
+// unless keepFull is set. Client IPs are logged in full by default.
+func WithIPAnonymization(keepFull bool) Option {
+	return func(o *options) {
+		o.anonymizeIPs = true
+		o.keepFullIPs = keepFull
+	}
+}
+
+// WithPrettyResponses indents JSON responses for reading them during development, all of them if always
+// and those of requests with ?pretty=true if onQuery. Responses are compact by default, never enable it
+// in production.
+func WithPrettyResponses(always, onQuery bool) Option {
+	return func(o *options) {
+		o.prettyResponses = always
+		o.prettyOnQuery = onQuery
+	}
+}
+
+// WithSecurityHeaders replaces the security headers set on responses, DefaultSecurityHeaders by default.
+func WithSecurityHeaders(headers SecurityHeaders) Option {
+	return func(o *options) {
+		o.securityHeaders = headers
+	}
+}
+
+// WithRequireRequestID rejects requests without the X-Request-Id header with 400 rather than generating an id,
+// for upstream gateways which must inject it. The raw endpoints, which probes call, are exempt.
+func WithRequireRequestID(require bool) Option {
+	return func(o *options) {
+		o.requireRequestID = require
+	}
+}
+
+// WithImpersonation enables POST /private/impersonate/{uuid}, which mints read-only tokens acting as a user
+// for admins, signing them with HS256 by the key. The key must be kept from the auth service and users,
+// none disables impersonation.
+func WithImpersonation(key []byte) Option {
+	return func(o *options) {
+		o.tokenRules.impersonationKey = key
+	}
+}
+
+// WithImpersonationTTL sets the lifetime of impersonation tokens, 15 minutes by default.
+func WithImpersonationTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.impersonationTTL = ttl
+	}
+}
+
+// WithIDGenerator replaces random ids of impersonation tokens.
+func WithIDGenerator(ids common.IDGenerator) Option {
+	return func(o *options) {
+		o.ids = ids
+	}
+}
+
+// NewRouter validates the options and returns an error describing every problem of them
+// instead of a router which would fail on requests.
+func NewRouter(log *logrus.Logger, service Service, key *rsa.PublicKey, host, version string, opts ...Option) (chi.Router, error) { //nolint:lll
+	o := options{
+		compressMinSize:       defaultCompressMinSize,
+		compressExcludedTypes: defaultExcludedTypes,
+		maxMatchesCount:       defaultMaxMatchesCount,
+		maxStreamMatchesCount: defaultMaxStreamMatchesCount,
+		requestTimeout:        defaultRequestTimeout,
+		accessLogSample:       defaultAccessLogSample,
+		accessLogAlwaysStatus: defaultAccessLogAlwaysStatus,
+		accessLogAlwaysSlower: defaultAccessLogAlwaysSlower,
+		ipRateLimit:           defaultIPRateLimit,
+		ipRateBurst:           defaultIPRateBurst,
+		maxInFlightWrites:     defaultMaxInFlightWrites,
+		securityHeaders:       DefaultSecurityHeaders(),
+		impersonationTTL:      defaultImpersonationTTL,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := o.validate(service, key); err != nil {
+		return nil, err
+	}
+	handler := newHandler(log, service, key, o.tokenRules)
+	handler.maxMatchesCount = o.maxMatchesCount
+	handler.maxStreamMatchesCount = o.maxStreamMatchesCount
+	handler.notifications = o.notifications
+	handler.features = o.features
+	handler.impersonationTTL = o.impersonationTTL
+	if o.ids != nil {
+		handler.ids = o.ids
+	}
+	// validated already
+	proxies, _ := parseProxies(o.trustedProxies)
+	unauthenticated := func(next http.Handler) http.Handler { return next }
+	if o.ipRateLimit > 0 {
+		unauthenticated = handler.ipRateLimit(newIPLimiter(o.ipRateLimit, o.ipRateBurst))
+	}
+	var writes *inFlightLimiter
+	if o.maxInFlightWrites > 0 {
+		writes = newInFlightLimiter(o.maxInFlightWrites)
+	}
+	inFlight := handler.limitInFlight(writes)
 	r := chi.NewRouter()
 	r.Use(middleware.Recoverer)
 	r.Use(cors.AllowAll().Handler)
+	r.Use(securityHeaders(o.securityHeaders))
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(realIP(proxies))
+	if o.anonymizeIPs {
+		r.Use(anonymizeIPs(o.keepFullIPs))
+	}
 	r.Use(middleware.StripSlashes)
-	r.Use(middleware.NewCompressor(flate.DefaultCompression).Handler)
+	r.Use(headResponses)
+	r.Use(compressor(flate.DefaultCompression, o.compressMinSize, o.compressExcludedTypes))
+	if o.prettyResponses || o.prettyOnQuery {
+		r.Use(prettyResponses(o.prettyResponses, o.prettyOnQuery))
+	}
+	r.Use(localizedResponses)
 	r.NotFound(notFoundHandler)
-	r.Get("/ping", pingHandler)
-	r.Get("/version", versionHandler(version))
+	r.With(unauthenticated, rawResponses).Get("/ping", pingHandler)
+	r.With(unauthenticated, rawResponses).Get("/version", versionHandler(version))
+	r.With(unauthenticated, rawResponses).Get("/openapi.json", openAPIHandler(r, version))
 	r.Group(func(r chi.Router) {
-		r.Use(metrics.NewPromMiddleware(host))
-		r.Use(middleware.RequestLogger(&middleware.DefaultLogFormatter{Logger: log, NoColor: true}))
-		r.Use(middleware.Timeout(30 * time.Second))
+		r.Use(metrics.NewPromMiddleware(host, log))
+		r.Use(middleware.RequestLogger(&sampledLogFormatter{
+			next:         &middleware.DefaultLogFormatter{Logger: log, NoColor: true},
+			every:        int64(o.accessLogSample),
+			alwaysStatus: o.accessLogAlwaysStatus,
+			alwaysSlower: o.accessLogAlwaysSlower,
+		}))
+		if o.requireRequestID {
+			r.Use(requireRequestID)
+		}
+		r.Use(handler.timeout(o.requestTimeout))
 		r.Use(middleware.Throttle(100))
 		r.Route("/static", func(r chi.Router) {
+			r.Use(unauthenticated)
 			r.Get("/regions", handler.getRegions)
 		})
 		r.Route("/public", func(r chi.Router) {
 			r.Use(handler.jwtAuth)
 			r.Route("/v1", func(r chi.Router) {
+				r.Use(requireJSON)
+				r.Use(writesOnly(inFlight))
 				r.Group(func(r chi.Router) {
+					r.Get("/bootstrap", handler.bootstrap)
 					r.Get("/config", handler.getConfig)
+					r.Get("/config/schema", handler.getConfigSchema)
 					r.Put("/config", handler.saveConfig)
+					r.Patch("/config", handler.patchConfig)
+					r.Get("/settings", handler.getSettings)
+					r.Put("/settings", handler.saveSettings)
+					r.Get("/photos", handler.listPhotos)
+					r.Post("/photos", handler.addPhoto)
+					r.Post("/photos/presign", handler.presignPhoto)
+					r.Post("/photos/confirm", handler.confirmPhoto)
+					r.Put("/photos/order", handler.reorderPhotos)
+					r.Delete("/photos/{id}", handler.deletePhoto)
+					r.Post("/devices", handler.registerDevice)
+					r.Delete("/devices/{id}", handler.unregisterDevice)
 					r.Get("/matches", handler.getMatches)
-					r.Get("/like/{uuid}", handler.like)
-					r.Get("/dislike/{uuid}", handler.dislike)
+					r.With(handler.feature(FeatureMatchesStream)).Get("/matches/stream", handler.streamMatches)
+					r.Get("/matches/count", handler.countMatches)
+					r.Get("/feed", handler.getMatches)
+					r.Get("/daily-pick", handler.getDailyPick)
+					r.With(handler.feature(FeatureFeedByRegion)).Get("/feed/by-region", handler.getFeedByRegion)
+					r.Get("/match/{uuid}", handler.getMatch)
+					r.Get("/match/{uuid}/common", handler.getSharedAttributes)
+					r.Get("/match/{uuid}/context", handler.getCommonContext)
+					r.Post("/match/{uuid}/archive", handler.archiveMatch)
+					r.Get("/relationship/{uuid}", handler.getRelationship)
+					r.Post("/relationships", handler.getRelationships)
+					// likes and dislikes write despite the method, see sideEffectRoutes
+					r.With(inFlight).Get("/like/{uuid}", handler.like)
+					r.With(inFlight).Get("/dislike/{uuid}", handler.dislike)
+					r.Post("/hide/{uuid}", handler.hide)
+					r.Delete("/hide/{uuid}", handler.unhide)
+					r.Post("/reconsider/{uuid}", handler.reconsider)
 					r.Get("/liked", handler.listLiked)
 					r.Get("/disliked", handler.listDisliked)
+					r.Get("/likes/incoming", handler.listIncomingLikes)
+					r.Get("/stats/timeseries", handler.getTimeseries)
+					r.With(handler.feature(FeatureViewers)).Get("/viewers", handler.listViewers)
+					r.With(handler.feature(FeatureDecisions)).Get("/decisions", handler.listDecisions)
+					r.With(handler.feature(FeatureDecisionsReset)).Post("/decisions/reset", handler.resetDecisions)
+					r.With(handler.feature(FeatureBoost)).Post("/boost", handler.startBoost)
+					r.With(handler.feature(FeatureBoost)).Get("/boost/status", handler.getBoostStatus)
 					r.Get("/chats", handler.getAllChats)
+					r.Post("/chats/read-all", handler.markAllChatsRead)
+					r.Get("/chats/unread-count", handler.getTotalUnread)
+					// {uuid} of chat routes is either the peer uuid or the conversation id
 					r.HandleFunc("/chat/{uuid}", handler.chatHandler)
+					r.HandleFunc("/stream", handler.streamHandler)
+					r.Post("/chat/{uuid}/archive", handler.archiveChat)
+					r.Post("/chat/{uuid}/snooze", handler.snoozeChat)
+					r.Delete("/chat/{uuid}/snooze", handler.unsnoozeChat)
+					r.With(handler.feature(FeatureChatExport)).Get("/chat/{uuid}/export", handler.exportChat)
+					r.Delete("/chat/{uuid}/messages/{id}", handler.retractMessage)
+					r.Post("/chat/{uuid}/message/{id}/edit", handler.editMessage)
+					r.Post("/chat/{uuid}/message/{id}/report", handler.reportMessage)
+					r.With(handler.feature(FeatureReactions)).Post("/chat/{uuid}/message/{id}/react", handler.reactToMessage)
+					r.With(handler.feature(FeatureReactions)).Delete("/chat/{uuid}/message/{id}/react", handler.removeReaction)
+					if o.notifications != nil {
+						r.HandleFunc("/notifications", handler.notificationsHandler)
+					}
 				})
 			})
 		})
 		r.Route("/private", func(r chi.Router) {
+			handler.registerSeed(r)
+			r.Group(func(r chi.Router) {
+				r.Use(requireAdmin(o.adminToken))
+				r.Get("/chat/stats", handler.getChatStats)
+				r.Get("/regions/stats", handler.getRegionStats)
+				r.Post("/regions", handler.upsertRegion)
+				r.Put("/regions/{id}", handler.upsertRegion)
+				r.Get("/bans", handler.listBans)
+				r.Post("/bans", handler.banIdentity)
+				r.Delete("/bans/{kind}/{value}", handler.unbanIdentity)
+				r.Post("/revoked-tokens", handler.revokeToken)
+				r.Get("/reports", handler.listReports)
+				r.Post("/maintenance/repair-matches", handler.repairMatches)
+				if o.tokenRules.impersonationKey != nil {
+					r.Post("/impersonate/{uuid}", handler.impersonate)
+				}
+			})
 		})
 	})
-	return r
+	return r, nil
 }
 
 func notFoundHandler(w http.ResponseWriter, _ *http.Request) {
@@ -83,6 +591,15 @@ func pingHandler(w http.ResponseWriter, _ *http.Request) {
 	writeResponse(w, "pong")
 }
 
+// MetricsHandler serves the default registry at /metrics in the OpenMetrics format to scrapers accepting it,
+// which is the one carrying exemplars, and in the classic text format otherwise. It's meant for a listener
+// of its own, metrics tell the traffic and routes of the service and aren't a part of the public API.
+func MetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	return mux
+}
+
 func versionHandler(version string) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, _ *http.Request) {
 		writeResponse(w, version)
@@ -90,25 +607,69 @@ func versionHandler(version string) func(http.ResponseWriter, *http.Request) {
 }
 
 func writeResponse(w http.ResponseWriter, data interface{}) {
-	response := JSONResponse{Data: data}
+	writeJSONResponse(w, JSONResponse{Data: data})
+}
+
+func writeResponseWithWarnings(w http.ResponseWriter, data interface{}, warnings []models.Warning) {
+	writeJSONResponse(w, JSONResponse{Data: data, Warnings: warnings})
+}
+
+func writeJSONResponse(w http.ResponseWriter, response JSONResponse) {
+	localizeProfiles(response.Data, responseLanguages(w))
 	w.Header().Set("Content-type", "application/json")
-	_ = json.NewEncoder(w).Encode(response) //nolint:errchkjson
+	if _, ok := w.(rawResponseWriter); ok && response.Error == nil {
+		_ = newEncoder(w).Encode(response.Data) //nolint:errchkjson
+		return
+	}
+	_ = newEncoder(w).Encode(response) //nolint:errchkjson
+}
+
+// rawResponses makes successful responses of the route carry data without the JSONResponse envelope,
+// errors are still wrapped so that clients can tell them apart.
+func rawResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(rawResponseWriter{ResponseWriter: w}, r)
+	})
+}
+
+type rawResponseWriter struct {
+	http.ResponseWriter
 }
 
 func writeErrResponse(w http.ResponseWriter, message string, status int) {
 	response := JSONResponse{Data: []int{}, Error: &message, Code: &status}
 	w.WriteHeader(status)
 	w.Header().Set("Content-type", "application/json")
-	_ = json.NewEncoder(w).Encode(response) //nolint:errchkjson
+	_ = newEncoder(w).Encode(response) //nolint:errchkjson
 }
 
 type JSONResponse struct {
-	Data  interface{} `json:"data,omitempty"`
-	Meta  *Meta       `json:"meta,omitempty"`
-	Error *string     `json:"error,omitempty"`
-	Code  *int        `json:"code,omitempty"`
+	Data     interface{}      `json:"data,omitempty"`
+	Meta     *Meta            `json:"meta,omitempty"`
+	Warnings []models.Warning `json:"warnings,omitempty"`
+	Error    *string          `json:"error,omitempty"`
+	Code     *int             `json:"code,omitempty"`
+	// ErrorCode is the kind of the error, e.g. not_found.
+	ErrorCode *string `json:"error_code,omitempty"`
+	// Field is the request field that caused the error, if any.
+	Field *string `json:"field,omitempty"`
+	// Offset is the byte of the request body malformed JSON was detected at, Expected is the JSON type
+	// the value of Field should have been of.
+	Offset   *int64  `json:"offset,omitempty"`
+	Expected *string `json:"expected,omitempty"`
 }
 
 type Meta struct {
 	Count int `json:"count"`
+	// CountIsEstimate marks an approximate Count of a large list.
+	CountIsEstimate bool `json:"count_is_estimate,omitempty"`
+	// Snapshot is the token of the feed snapshot the page is taken from.
+	Snapshot string `json:"snapshot,omitempty"`
+	// NextCursor is the cursor of the next page of a cursor paged list, empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+	// HasMore marks a list cut at the max count, Count is then the total of the full list.
+	HasMore bool `json:"has_more,omitempty"`
+	// Degraded marks partial data, Skipped lists subsystems whose data is missing.
+	Degraded bool     `json:"degraded,omitempty"`
+	Skipped  []string `json:"skipped,omitempty"`
 }
@@ -0,0 +1,394 @@
+package rest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/internal/rest/resttest"
+	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRouter returns a router with a zero resttest.Service and a fresh key unless they are given.
+func newTestRouter(t *testing.T, service Service, key *rsa.PublicKey, opts ...Option) chi.Router {
+	t.Helper()
+	if service == nil {
+		service = &resttest.Service{}
+	}
+	if key == nil {
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		key = &privateKey.PublicKey
+	}
+	router, err := NewRouter(logrus.New(), service, key, "localhost", "test", opts...)
+	require.NoError(t, err)
+	return router
+}
+
+func TestNewRouterTwice(t *testing.T) {
+	var routers []http.Handler
+	for i := 0; i < 2; i++ {
+		require.NotPanics(t, func() {
+			routers = append(routers, newTestRouter(t, nil, nil))
+		})
+	}
+	for _, router := range routers {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ping", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+}
+
+func TestHeadRequests(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	service := &resttest.Service{
+		GetConfigFunc: func(_ context.Context, uuid string) (*models.Config, error) {
+			return &models.Config{UUID: uuid}, nil
+		},
+	}
+	router := newTestRouter(t, service, &key.PublicKey)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"uuid": testUUID}).SignedString(key)
+	require.NoError(t, err)
+	for _, path := range []string{"/ping", "/version", "/public/v1/config"} {
+		r := httptest.NewRequest(http.MethodHead, path, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code, path)
+		require.Equal(t, "application/json", w.Header().Get("Content-type"), path)
+		require.Empty(t, w.Body.String(), path)
+	}
+	require.Len(t, service.Calls("GetConfig"), 1)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/public/v1/config", nil))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Empty(t, w.Body.String())
+}
+
+func TestRawResponses(t *testing.T) {
+	router := newTestRouter(t, nil, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `"test"`, w.Body.String())
+
+	// errors keep the envelope
+	w = httptest.NewRecorder()
+	rawResponses(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeErrResponse(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.JSONEq(t, `{"data": [], "error": "Not Found", "code": 404}`, w.Body.String())
+
+	// other endpoints are wrapped
+	w = httptest.NewRecorder()
+	writeResponse(w, "test")
+	require.JSONEq(t, `{"data": "test"}`, w.Body.String())
+}
+
+func TestNewRouterInvalidConfig(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	tests := []struct {
+		name     string
+		service  Service
+		key      *rsa.PublicKey
+		opts     []Option
+		problems []string
+	}{
+		{"missing dependencies", nil, nil, nil, []string{"service is missing", "public signing key is missing"}},
+		{"zero timeout", &resttest.Service{}, &key.PublicKey, []Option{WithRequestTimeout(0)},
+			[]string{"request timeout 0s is not positive"}},
+		{"invalid excluded type", &resttest.Service{}, &key.PublicKey, []Option{WithCompressExcludedTypes([]string{"image/png", ""})},
+			[]string{`compress excluded type "" is invalid`}},
+		{"unknown feature", &resttest.Service{}, &key.PublicKey, []Option{WithFeatureFlags(NewFeatureFlags(FeatureBoost, "teleport"))},
+			[]string{`feature "teleport" is unknown`}},
+		{"invalid access log sampling", &resttest.Service{}, &key.PublicKey,
+			[]Option{WithAccessLogSample(0), WithAccessLogAlwaysSlower(-time.Second)},
+			[]string{"access log sample 0 is not positive", "access log slow threshold -1s is negative"}},
+		{"invalid ip rate limit", &resttest.Service{}, &key.PublicKey,
+			[]Option{WithIPRateBurst(0), WithTrustedProxies([]string{"10.0.0.0/8", "10.0.0.1", "proxy"})},
+			[]string{"ip rate burst 0 is not positive", `trusted proxy "proxy" is invalid`}},
+		{"negative max in-flight writes", &resttest.Service{}, &key.PublicKey, []Option{WithMaxInFlightWrites(-1)},
+			[]string{"max in-flight writes -1 is negative"}},
+		{"negative hsts max age", &resttest.Service{}, &key.PublicKey,
+			[]Option{WithSecurityHeaders(SecurityHeaders{HSTSMaxAge: -time.Second})},
+			[]string{"hsts max age -1s is negative"}},
+		{"several problems", &resttest.Service{}, nil, []Option{
+			WithCompressMinSize(-1), WithLeeway(-time.Second), WithRequiredClaims([]string{"tenant", ""}), WithMaxMatchesCount(0),
+		}, []string{
+			"public signing key is missing",
+			"compress min size -1 is negative",
+			"token leeway -1s is negative",
+			"required claims contain an empty name",
+			"max matches count 0 is not positive",
+		}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			router, err := NewRouter(logrus.New(), tt.service, tt.key, "localhost", "test", tt.opts...)
+			require.Nil(t, router)
+			require.EqualError(t, err, "err invalid router config: "+strings.Join(tt.problems, "; "))
+		})
+	}
+}
+
+func TestMalformedUUIDParams(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	service := &resttest.Service{}
+	router := newTestRouter(t, service, &key.PublicKey)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"uuid": testUUID}).SignedString(key)
+	require.NoError(t, err)
+	const malformed = "not-a-uuid"
+	var checked int
+	err = chi.Walk(router, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		// HEAD responses have no body to check
+		if !strings.Contains(route, "{uuid}") || method == http.MethodHead {
+			return nil
+		}
+		path := strings.NewReplacer("{uuid}", malformed, "{id}", "1").Replace(route)
+		r := httptest.NewRequest(method, path+"?super=false", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		require.Equal(t, http.StatusBadRequest, w.Code, "%s %s", method, route)
+		var response JSONResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response), "%s %s", method, route)
+		require.Equal(t, errCodeValidation, *response.ErrorCode, "%s %s", method, route)
+		checked++
+		return nil
+	})
+	require.NoError(t, err)
+	require.NotZero(t, checked)
+	for _, call := range service.Calls("") {
+		require.NotContains(t, call.Args, malformed, call.Method)
+	}
+}
+
+func TestUpsertRegion(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	service := &resttest.Service{
+		UpsertRegionFunc: func(_ context.Context, region *models.Region) error {
+			if region.ID == 0 {
+				region.ID = 100
+			}
+			return nil
+		},
+	}
+	router := newTestRouter(t, service, &key.PublicKey, WithAdminToken("secret"))
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"uuid": testUUID}).SignedString(key)
+	require.NoError(t, err)
+	upsert := func(method, path string, admin bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(`{"id":5,"name":"Tbilisi"}`))
+		if admin {
+			r.Header.Set("X-Admin-Token", "secret")
+		} else {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := upsert(http.MethodPost, "/private/regions", true)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"data":{"id":100,"name":"Tbilisi","description":""}}`, w.Body.String())
+	w = upsert(http.MethodPut, "/private/regions/7", true)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, int64(7), service.Calls("UpsertRegion")[1].Args[0].(*models.Region).ID)
+	w = upsert(http.MethodPut, "/private/regions/zero", true)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = upsert(http.MethodPost, "/private/regions", false)
+	require.Equal(t, http.StatusForbidden, w.Code, "user tokens aren't admin ones")
+	require.Len(t, service.Calls("UpsertRegion"), 2)
+}
+
+func TestRepairMatches(t *testing.T) {
+	service := &resttest.Service{
+		RepairMatchesFunc: func(context.Context) (*models.MatchRepair, error) {
+			return &models.MatchRepair{FixedCounts: 2, UnmatchedChats: 4}, nil
+		},
+	}
+	router := newTestRouter(t, service, nil, WithAdminToken("secret"))
+	r := httptest.NewRequest(http.MethodPost, "/private/maintenance/repair-matches", nil)
+	r.Header.Set("X-Admin-Token", "secret")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"data":{"fixed_counts":2,"orphaned_counts":0,"unmatched_chats":4}}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/private/maintenance/repair-matches", nil))
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Len(t, service.Calls("RepairMatches"), 1)
+}
+
+func TestMetricsFormats(t *testing.T) {
+	handler := MetricsHandler()
+	scrape := func(accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	w := httptest.NewRecorder()
+	newTestRouter(t, nil, nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusNotFound, w.Code, "metrics aren't served by the API")
+
+	w = scrape("application/openmetrics-text; version=0.0.1,text/plain;version=0.0.4;q=0.5,*/*;q=0.1")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "application/openmetrics-text; version=0.0.1"),
+		w.Header().Get("Content-Type"))
+	require.True(t, strings.HasSuffix(w.Body.String(), "# EOF\n"))
+
+	for _, accept := range []string{"", "text/plain", "application/json"} {
+		w = scrape(accept)
+		require.Equal(t, http.StatusOK, w.Code, accept)
+		require.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4"), accept)
+		require.NotContains(t, w.Body.String(), "# EOF", accept)
+	}
+}
+
+func TestImpersonation(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	impersonationKey := []byte(strings.Repeat("k", 32))
+	service := &resttest.Service{
+		GetConfigFunc: func(_ context.Context, uuid string) (*models.Config, error) {
+			return &models.Config{UUID: uuid}, nil
+		},
+	}
+	router := newTestRouter(t, service, &key.PublicKey, WithAdminToken("secret"), WithImpersonation(impersonationKey),
+		WithIDGenerator(&common.SequentialIDs{}))
+	userToken, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"uuid": testUUID}).SignedString(key)
+	require.NoError(t, err)
+	do := func(method, path, token, admin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(`{"admin":"alice","reason":"ticket 1"}`))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		if admin != "" {
+			r.Header.Set("X-Admin-Token", admin)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do(http.MethodPost, "/private/impersonate/"+testUUID, userToken, "")
+	require.Equal(t, http.StatusForbidden, w.Code, "user tokens can't impersonate")
+	w = do(http.MethodPost, "/private/impersonate/"+testUUID, "", "wrong")
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Empty(t, service.Calls("RecordAudit"))
+
+	w = do(http.MethodPost, "/private/impersonate/"+testUUID, "", "secret")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response struct {
+		Data models.Impersonation `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Equal(t, "alice", response.Data.ImpersonatedBy)
+	require.WithinDuration(t, time.Now().Add(defaultImpersonationTTL), response.Data.ExpiresAt.Time, time.Minute)
+	calls := service.Calls("RecordAudit")
+	require.Len(t, calls, 1)
+	started := calls[0].Args[0].(*models.AuditEntry)
+	require.Equal(t, models.AuditImpersonationStarted, started.Action)
+	require.Equal(t, "alice", started.Actor)
+	require.Equal(t, testUUID, started.Subject)
+	require.Equal(t, "ticket 1", started.Reason)
+	require.Equal(t, "00000000000000000000000000000001", started.TokenID)
+
+	token := response.Data.Token
+	w = do(http.MethodGet, "/public/v1/config", token, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, testUUID, service.Calls("GetConfig")[0].Args[0])
+	w = do(http.MethodPut, "/public/v1/config", token, "")
+	require.Equal(t, http.StatusForbidden, w.Code, "impersonation is read-only")
+	require.Empty(t, service.Calls("SaveConfig"))
+	calls = service.Calls("RecordAudit")
+	require.Len(t, calls, 3)
+	for i, action := range []string{models.AuditImpersonatedRequest, models.AuditImpersonatedRejected} {
+		entry := calls[i+1].Args[0].(*models.AuditEntry)
+		require.Equal(t, action, entry.Action)
+		require.Equal(t, "alice", entry.Actor)
+		require.Equal(t, testUUID, entry.Subject)
+		require.Equal(t, started.TokenID, entry.TokenID)
+		require.Equal(t, "/public/v1/config", entry.Path)
+	}
+	w = do(http.MethodGet, "/public/v1/like/"+testPeer+"?super=false", token, "")
+	require.Equal(t, http.StatusForbidden, w.Code, "likes write despite the method")
+	require.Empty(t, service.Calls("Like"))
+	calls = service.Calls("RecordAudit")
+	require.Len(t, calls, 4, "a single entry per request")
+	rejected := calls[3].Args[0].(*models.AuditEntry)
+	require.Equal(t, models.AuditImpersonatedRejected, rejected.Action)
+	require.Equal(t, started.TokenID, rejected.TokenID)
+	require.Equal(t, "/public/v1/like/"+testPeer, rejected.Path)
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		w = do(method, "/public/v1/dislike/"+testPeer+"/", token, "")
+		require.Equal(t, http.StatusForbidden, w.Code, method)
+	}
+	require.Empty(t, service.Calls("Dislike"))
+	calls = service.Calls("RecordAudit")
+	require.Len(t, calls, 6)
+	for _, entry := range calls[4:] {
+		require.Equal(t, models.AuditImpersonatedRejected, entry.Args[0].(*models.AuditEntry).Action)
+	}
+
+	// the claim is honored in impersonation tokens only, which must name the admin
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"uuid": testUUID, "impersonated_by": "alice"}).
+		SignedString(key)
+	require.NoError(t, err)
+	anonymous, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"uuid": testUUID}).SignedString(impersonationKey)
+	require.NoError(t, err)
+	for _, token := range []string{forged, anonymous} {
+		require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/public/v1/config", token, "").Code)
+	}
+	require.Len(t, service.Calls("RecordAudit"), 6)
+
+	// requests which can't be audited aren't served
+	service.RecordAuditFunc = func(context.Context, *models.AuditEntry) error { return common.ErrStoreUnavailable }
+	require.Equal(t, http.StatusInternalServerError, do(http.MethodGet, "/public/v1/config", token, "").Code)
+	require.Len(t, service.Calls("GetConfig"), 1)
+}
+
+func TestImpersonationDisabled(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	router := newTestRouter(t, nil, &key.PublicKey, WithAdminToken("secret"))
+	r := httptest.NewRequest(http.MethodPost, "/private/impersonate/"+testUUID, strings.NewReader(`{"admin":"alice"}`))
+	r.Header.Set("X-Admin-Token", "secret")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	// without the key HS256 tokens are rejected whatever they are signed with
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"uuid": testUUID, "impersonated_by": "alice"}).
+		SignedString([]byte(strings.Repeat("k", 32)))
+	require.NoError(t, err)
+	r = httptest.NewRequest(http.MethodGet, "/public/v1/config", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+}
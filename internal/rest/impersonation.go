@@ -0,0 +1,143 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultImpersonationTTL is short as impersonation tokens grant access to someone else's account.
+	defaultImpersonationTTL = 15 * time.Minute
+	// minImpersonationKeySize is the size of a SHA-256 block, shorter HMAC keys are easier to brute force.
+	minImpersonationKeySize = 32
+)
+
+type impersonateRequest struct {
+	// Admin names the admin impersonating the user in the audit log. It's taken on trust from whoever holds
+	// the shared admin token, nothing verifies it.
+	Admin  string `json:"admin"`
+	Reason string `json:"reason"`
+}
+
+// impersonate mints a token acting as the {uuid} user on behalf of the admin. Starting an impersonation is
+// audited before the token is handed out, so there is no token without an entry.
+func (h *handler) impersonate(w http.ResponseWriter, r *http.Request) {
+	target, ok := h.targetUUID(w, r)
+	if !ok {
+		return
+	}
+	var req impersonateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	jti, err := h.ids.NewID()
+	if err != nil {
+		h.writeServiceError(w, err, "impersonating user")
+		return
+	}
+	entry := models.AuditEntry{
+		Actor:   req.Admin,
+		Action:  models.AuditImpersonationStarted,
+		Subject: target,
+		TokenID: jti,
+		Reason:  req.Reason,
+	}
+	if err = h.service.RecordAudit(r.Context(), &entry); err != nil {
+		h.writeServiceError(w, err, "impersonating user")
+		return
+	}
+	now := time.Now()
+	claims := Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(h.impersonationTTL).Unix(),
+		},
+		UUID:           target,
+		ImpersonatedBy: req.Admin,
+	}
+	if h.tokenRules.audience != "" {
+		claims.Audience = audience{h.tokenRules.audience}
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(h.tokenRules.impersonationKey)
+	if err != nil {
+		h.writeServiceError(w, err, "impersonating user")
+		return
+	}
+	h.log.WithFields(logrus.Fields{"impersonated_by": req.Admin, "uuid": target, "jti": jti}).
+		Infof("impersonation started: %s", req.Reason)
+	writeResponse(w, &models.Impersonation{
+		Token:          token,
+		UUID:           target,
+		ImpersonatedBy: req.Admin,
+		ExpiresAt:      common.NewTimestamp(time.Unix(claims.ExpiresAt, 0)),
+	})
+}
+
+// auditImpersonated records the request of an impersonation token in the audit log and tags its log line
+// with the admin. Impersonation is read-only: requests but GET and HEAD, and WebSocket handshakes, which
+// would let the admin chat as the user, are rejected with 403, so are GET routes in sideEffectRoutes.
+// Every request gets a single entry, requests which can't be audited aren't served.
+func (h *handler) auditImpersonated(w http.ResponseWriter, r *http.Request, claims *Claims) bool {
+	readOnly := (r.Method == http.MethodGet || r.Method == http.MethodHead) && !websocket.IsWebSocketUpgrade(r) &&
+		!hasSideEffects(r)
+	if !h.recordImpersonated(w, r, claims, readOnly) {
+		return false
+	}
+	h.log.WithFields(logrus.Fields{"impersonated_by": claims.ImpersonatedBy, "uuid": claims.UUID, "jti": claims.Id}).
+		Infof("impersonated request %s %s", r.Method, r.URL.Path)
+	if !readOnly {
+		writeErrResponse(w, "Impersonation is read-only", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// sideEffectRoutes matches GET routes which write despite the method. They are matched before routing gets
+// to them, so that impersonated requests are audited once, as rejected.
+var sideEffectRoutes = func() *chi.Mux {
+	mux := chi.NewRouter()
+	for _, pattern := range []string{"/public/v1/like/{uuid}", "/public/v1/dislike/{uuid}"} {
+		mux.Get(pattern, http.NotFound)
+	}
+	return mux
+}()
+
+// hasSideEffects tells whether the request is to one of sideEffectRoutes, HEAD requests are served by GET
+// routes and trailing slashes are stripped by the router.
+func hasSideEffects(r *http.Request) bool {
+	path := strings.TrimRight(r.URL.Path, "/")
+	return sideEffectRoutes.Match(chi.NewRouteContext(), http.MethodGet, path)
+}
+
+// recordImpersonated appends the impersonated request to the audit log, as served if allowed and as rejected
+// otherwise. It responds with 500 and returns false if the entry can't be saved.
+func (h *handler) recordImpersonated(w http.ResponseWriter, r *http.Request, claims *Claims, allowed bool) bool {
+	entry := models.AuditEntry{
+		Actor:   claims.ImpersonatedBy,
+		Action:  models.AuditImpersonatedRequest,
+		Subject: claims.UUID,
+		TokenID: claims.Id,
+		Method:  r.Method,
+		Path:    r.URL.Path,
+	}
+	if !allowed {
+		entry.Action = models.AuditImpersonatedRejected
+	}
+	if err := h.service.RecordAudit(r.Context(), &entry); err != nil {
+		h.log.Warnf("err auditing impersonated request: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,178 @@
+package rest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gerladeno/homie-core/internal/models"
+)
+
+// localizedResponses makes bios of profiles in responses rendered in the languages the request accepts.
+func localizedResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(languageWriter{ResponseWriter: w, languages: acceptedLanguages(r)}, r)
+	})
+}
+
+// languageWriter carries the languages the request accepts, writers of inner middlewares wrap it.
+type languageWriter struct {
+	http.ResponseWriter
+	languages []string
+}
+
+func (lw languageWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+func (lw languageWriter) Flush() {
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (lw languageWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := lw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("err response writer doesn't support hijacking")
+	}
+	return hj.Hijack()
+}
+
+// responseLanguages returns the languages responses written to w are rendered in, looking through writers
+// wrapping it. None means default bios.
+func responseLanguages(w http.ResponseWriter) []string {
+	for {
+		switch ww := w.(type) {
+		case languageWriter:
+			return ww.languages
+		case rawResponseWriter:
+			w = ww.ResponseWriter
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = ww.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// streamEncoder encodes values of a streamed response, localizing profiles in them as writeJSONResponse does.
+type streamEncoder struct {
+	*json.Encoder
+	languages []string
+}
+
+func newStreamEncoder(w http.ResponseWriter) *streamEncoder {
+	return &streamEncoder{Encoder: json.NewEncoder(newFlushWriter(w)), languages: responseLanguages(w)}
+}
+
+func (e *streamEncoder) Encode(v interface{}) error {
+	localizeProfiles(v, e.languages)
+	return e.Encoder.Encode(v)
+}
+
+// acceptedLanguages returns primary language subtags of the Accept-Language header, most preferred first.
+// Languages of zero quality and the wildcard are left out.
+func acceptedLanguages(r *http.Request) []string {
+	header := r.Header.Get("Accept-Language")
+	if header == "" {
+		return nil
+	}
+	type language struct {
+		code    string
+		quality float64
+	}
+	var languages []language
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			parsed, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		code, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if code == "" || code == "*" || quality <= 0 {
+			continue
+		}
+		languages = append(languages, language{code: code, quality: quality})
+	}
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].quality > languages[j].quality
+	})
+	result := make([]string, 0, len(languages))
+	for _, lang := range languages {
+		result = append(result, lang.code)
+	}
+	return result
+}
+
+// localizeProfiles renders bios of the profiles found in data in the languages, falling back to the default bio.
+// Only profiles of other users are localized, the user's own personal in their config keeps every bio.
+func localizeProfiles(data interface{}, languages []string) {
+	localizeValue(reflect.ValueOf(data), languages)
+}
+
+var profileType = reflect.TypeOf(&models.Profile{})
+
+func localizeValue(v reflect.Value, languages []string) {
+	switch v.Kind() {
+	case reflect.Interface:
+		localizeValue(v.Elem(), languages)
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		if v.Type() == profileType {
+			v.Interface().(*models.Profile).Personal.Localize(languages)
+			return
+		}
+		localizeValue(v.Elem(), languages)
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(sparseProfile{}) {
+			// the profile is unexported, so it's taken from a copy
+			localizeValue(reflect.ValueOf(v.Interface().(sparseProfile).profile), languages)
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				localizeValue(v.Field(i), languages)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if !mayHoldProfiles(v.Type().Elem()) {
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			localizeValue(v.Index(i), languages)
+		}
+	case reflect.Map:
+		if !mayHoldProfiles(v.Type().Elem()) {
+			return
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			localizeValue(iter.Value(), languages)
+		}
+	default:
+	}
+}
+
+// mayHoldProfiles tells whether values of the type may hold profiles, so that slices of strings and the like
+// aren't walked.
+func mayHoldProfiles(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Interface, reflect.Ptr, reflect.Struct, reflect.Slice, reflect.Array, reflect.Map:
+		return true
+	default:
+		return false
+	}
+}
@@ -3,68 +3,335 @@ package rest
 import (
 	"context"
 	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"mime"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/websocket"
 )
 
 type Claims struct {
 	jwt.StandardClaims
-	UUID string `json:"uuid"`
+	// Audience shadows the standard claim which supports the string form only.
+	Audience audience `json:"aud,omitempty"`
+	UUID     string   `json:"uuid"`
+	// Device is the fingerprint of the device the token was issued to.
+	Device string `json:"device_id,omitempty"`
+	// ImpersonatedBy names the admin acting as the user, it's honored in impersonation tokens only.
+	ImpersonatedBy string `json:"impersonated_by,omitempty"`
+}
+
+// audience is the aud claim which may be either a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return fmt.Errorf("err parsing aud claim: %w", err)
+	}
+	*a = multiple
+	return nil
+}
+
+func (a audience) contains(aud string) bool {
+	for _, elem := range a {
+		if elem == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// Reasons for failed authentication used as a metric label.
+const (
+	authReasonNoHeader      = "no_header"
+	authReasonInvalidHeader = "invalid_header"
+	authReasonInvalidToken  = "invalid_token"
+	authReasonAudience      = "audience_mismatch"
+	authReasonMissingClaim  = "missing_claim"
+	authReasonBanned        = "banned"
+	authReasonRevoked       = "revoked"
+)
+
+// tokenRules are checks of access tokens on top of the signature.
+type tokenRules struct {
+	// audience is expected in the aud claim, empty means any.
+	audience string
+	// leeway is allowed for clock skew when checking exp, nbf and iat claims.
+	leeway time.Duration
+	// requiredClaims must be present and non-empty, impersonation tokens are exempt as the auth service
+	// doesn't issue them.
+	requiredClaims []string
+	// impersonationKey verifies HS256 impersonation tokens minted by the router, nil rejects them.
+	impersonationKey []byte
 }
 
 type idType string
 
-const uuidKey idType = `UUID`
+const (
+	uuidKey idType = `UUID`
+	// impersonationClaimsKey holds the claims of impersonation tokens.
+	impersonationClaimsKey idType = `ImpersonationClaims`
+)
 
 func (h *handler) jwtAuth(next http.Handler) http.Handler {
 	var fn http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			writeErrResponse(w, "Unauthorized", http.StatusUnauthorized)
+			h.unauthorized(w, authReasonNoHeader)
 			return
 		}
 		headerParts := strings.Split(authHeader, " ")
 		if len(headerParts) != 2 {
-			writeErrResponse(w, "Unauthorized", http.StatusUnauthorized)
+			h.unauthorized(w, authReasonInvalidHeader)
 			return
 		}
 		if headerParts[0] != "Bearer" {
-			writeErrResponse(w, "Unauthorized", http.StatusUnauthorized)
+			h.unauthorized(w, authReasonInvalidHeader)
 			return
 		}
-		id, err := parseToken(headerParts[1], h.key)
+		claims, err := parseToken(headerParts[1], h.key, h.tokenRules)
 		switch {
 		case err == nil:
+		case errors.Is(err, common.ErrInvalidAudience):
+			h.unauthorized(w, authReasonAudience)
+			return
+		case errors.Is(err, common.ErrMissingClaim):
+			h.unauthorized(w, authReasonMissingClaim)
+			return
 		case errors.Is(err, common.ErrInvalidAccessToken):
-			writeErrResponse(w, "Unauthorized", http.StatusUnauthorized)
+			h.unauthorized(w, authReasonInvalidToken)
 			return
 		default:
 			h.log.Warnf("err parsing token: %v", err)
 			writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
-		r = r.WithContext(context.WithValue(r.Context(), uuidKey, id))
-		next.ServeHTTP(w, r)
+		revoked, err := h.service.IsTokenRevoked(r.Context(), claims.Id)
+		if err != nil {
+			h.log.Warnf("err checking revoked tokens: %v", err)
+			writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if revoked {
+			h.unauthorized(w, authReasonRevoked)
+			return
+		}
+		banned, err := h.service.IsBanned(r.Context(), claims.Subject, claims.Device)
+		if err != nil {
+			h.log.Warnf("err checking bans: %v", err)
+			writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if banned {
+			h.authMetrics.FailuresTotal.WithLabelValues(authReasonBanned).Inc()
+			writeErrResponse(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		if claims.ImpersonatedBy != "" && !h.auditImpersonated(w, r, claims) {
+			return
+		}
+		ctx := context.WithValue(r.Context(), uuidKey, claims.UUID)
+		if claims.ImpersonatedBy != "" {
+			ctx = context.WithValue(common.WithImpersonator(ctx, claims.ImpersonatedBy), impersonationClaimsKey, claims)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 	return fn
 }
 
-func parseToken(accessToken string, key *rsa.PublicKey) (string, error) {
-	token, err := jwt.ParseWithClaims(accessToken, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, common.ErrInvalidSigningMethod
+func (h *handler) unauthorized(w http.ResponseWriter, reason string) {
+	h.authMetrics.FailuresTotal.WithLabelValues(reason).Inc()
+	writeErrResponse(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// parseToken returns the claims of a valid token.
+func parseToken(accessToken string, key *rsa.PublicKey, rules tokenRules) (*Claims, error) {
+	// time based claims are checked below with the leeway
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(accessToken, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA:
+			return key, nil
+		case *jwt.SigningMethodHMAC:
+			if rules.impersonationKey != nil {
+				return rules.impersonationKey, nil
+			}
 		}
-		return key, nil
+		return nil, common.ErrInvalidSigningMethod
 	})
-	if err != nil {
-		return "", err
+	var validationErr *jwt.ValidationError
+	switch {
+	case err == nil:
+	case errors.As(err, &validationErr):
+		return nil, fmt.Errorf("%w: %v", common.ErrInvalidAccessToken, err)
+	default:
+		return nil, err
+	}
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, common.ErrInvalidAccessToken
+	}
+	// regular tokens can't impersonate and impersonation tokens name the admin
+	_, impersonation := token.Method.(*jwt.SigningMethodHMAC)
+	if impersonation != (claims.ImpersonatedBy != "") {
+		return nil, fmt.Errorf("%w: impersonated_by claim mismatches the signing method", common.ErrInvalidAccessToken)
+	}
+	if err = claims.validAt(time.Now(), rules.leeway); err != nil {
+		return nil, err
 	}
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims.UUID, nil
+	if rules.audience != "" && !claims.Audience.contains(rules.audience) {
+		return nil, common.ErrInvalidAudience
+	}
+	if len(rules.requiredClaims) != 0 && !impersonation {
+		if err = checkRequiredClaims(&parser, accessToken, rules.requiredClaims); err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+func (c *Claims) validAt(now time.Time, leeway time.Duration) error {
+	switch {
+	case !c.VerifyExpiresAt(now.Add(-leeway).Unix(), false):
+		return fmt.Errorf("%w: token is expired", common.ErrInvalidAccessToken)
+	case !c.VerifyNotBefore(now.Add(leeway).Unix(), false):
+		return fmt.Errorf("%w: token is not valid yet", common.ErrInvalidAccessToken)
+	case !c.VerifyIssuedAt(now.Add(leeway).Unix(), false):
+		return fmt.Errorf("%w: token used before issued", common.ErrInvalidAccessToken)
 	}
-	return "", common.ErrInvalidAccessToken
+	return nil
+}
+
+// checkRequiredClaims parses the already verified token again to check claims not known to Claims.
+func checkRequiredClaims(parser *jwt.Parser, accessToken string, required []string) error {
+	claims := jwt.MapClaims{}
+	if _, _, err := parser.ParseUnverified(accessToken, claims); err != nil {
+		return fmt.Errorf("%w: %v", common.ErrInvalidAccessToken, err)
+	}
+	for _, name := range required {
+		switch value := claims[name].(type) {
+		case nil:
+			return fmt.Errorf("%w: %s", common.ErrMissingClaim, name)
+		case string:
+			if value == "" {
+				return fmt.Errorf("%w: %s", common.ErrMissingClaim, name)
+			}
+		case []interface{}:
+			if len(value) == 0 {
+				return fmt.Errorf("%w: %s", common.ErrMissingClaim, name)
+			}
+		}
+	}
+	return nil
+}
+
+// requireAdmin allows requests carrying the token in the X-Admin-Token header. Empty token forbids all requests.
+func requireAdmin(token string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := r.Header.Get("X-Admin-Token")
+			if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				writeErrResponse(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// timeout cancels the request context after d. A handler which returns past the deadline without having
+// responded gets its request answered with a timeout error, slow requests are logged with their route.
+func (h *handler) timeout(d time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			started := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+			// upgraded connections outlive the deadline by design and can't be answered anymore
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) || websocket.IsWebSocketUpgrade(r) {
+				return
+			}
+			route := r.URL.Path
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+			h.log.Warnf("err handling %s %s timed out after %s", r.Method, route, time.Since(started).Round(time.Millisecond))
+			if ww.Status() == 0 && ww.BytesWritten() == 0 {
+				writeTimeoutResponse(ww)
+			}
+		})
+	}
+}
+
+// requireRequestID rejects requests without the X-Request-Id header instead of generating an id for them.
+func requireRequestID(next http.Handler) http.Handler {
+	var fn http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(middleware.RequestIDHeader) == "" {
+			writeErrResponse(w, http.StatusText(http.StatusBadRequest)+": missing "+middleware.RequestIDHeader+" header",
+				http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return fn
+}
+
+// requireJSON rejects requests carrying a body of any content type except application/json
+// and application/merge-patch+json.
+func requireJSON(next http.Handler) http.Handler {
+	var fn http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodDelete:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || (mediaType != "application/json" && mediaType != "application/merge-patch+json") {
+			writeErrResponse(w, http.StatusText(http.StatusUnsupportedMediaType)+": expected application/json",
+				http.StatusUnsupportedMediaType)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return fn
+}
+
+// headResponses serves HEAD requests by GET routes unless there is a HEAD one, dropping the body.
+func headResponses(next http.Handler) http.Handler {
+	return middleware.GetHead(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w = headWriter{ResponseWriter: w}
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// headWriter discards the body keeping the status and headers.
+type headWriter struct {
+	http.ResponseWriter
+}
+
+func (hw headWriter) Write(p []byte) (int, error) {
+	return len(p), nil
 }
@@ -0,0 +1,309 @@
+package rest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/rest/resttest"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireJSON(t *testing.T) {
+	handler := requireJSON(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeResponse(w, "Ok")
+	}))
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"json", http.MethodPut, "application/json", `{}`, http.StatusOK},
+		{"json with charset", http.MethodPut, "application/json; charset=utf-8", `{}`, http.StatusOK},
+		{"form", http.MethodPut, "application/x-www-form-urlencoded", `a=b`, http.StatusUnsupportedMediaType},
+		{"text", http.MethodPost, "text/plain", `{}`, http.StatusUnsupportedMediaType},
+		{"missing", http.MethodPost, "", `{}`, http.StatusUnsupportedMediaType},
+		{"no body", http.MethodPost, "", ``, http.StatusOK},
+		{"get", http.MethodGet, "text/plain", `{}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/public/v1/config", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			require.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func TestRequireRequestID(t *testing.T) {
+	get := func(router http.Handler, path, requestID string) int {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if requestID != "" {
+			r.Header.Set("X-Request-Id", requestID)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+	strict := newTestRouter(t, nil, nil, WithRequireRequestID(true))
+	require.Equal(t, http.StatusBadRequest, get(strict, "/static/regions", ""))
+	require.Equal(t, http.StatusOK, get(strict, "/static/regions", "gateway-1"))
+	require.Equal(t, http.StatusOK, get(strict, "/ping", ""), "probes don't send ids")
+	require.Equal(t, http.StatusOK, get(newTestRouter(t, nil, nil), "/static/regions", ""), "ids are generated by default")
+}
+
+func TestJWTAudience(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	h := newHandler(logrus.New(), &resttest.Service{}, &key.PublicKey, tokenRules{audience: "homie-core"})
+	auth := h.jwtAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, r.Context().Value(uuidKey))
+	}))
+	sign := func(aud interface{}) string {
+		claims := jwt.MapClaims{"uuid": "first", "exp": time.Now().Add(time.Hour).Unix()}
+		if aud != nil {
+			claims["aud"] = aud
+		}
+		token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+		require.NoError(t, err)
+		return token
+	}
+	tests := []struct {
+		name   string
+		aud    interface{}
+		status int
+	}{
+		{"string", "homie-core", http.StatusOK},
+		{"array", []string{"billing", "homie-core"}, http.StatusOK},
+		{"other string", "billing", http.StatusUnauthorized},
+		{"other array", []string{"billing", "chat"}, http.StatusUnauthorized},
+		{"missing", nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/public/v1/config", nil)
+			r.Header.Set("Authorization", "Bearer "+sign(tt.aud))
+			w := httptest.NewRecorder()
+			auth.ServeHTTP(w, r)
+			require.Equal(t, tt.status, w.Code)
+		})
+	}
+	require.Equal(t, float64(3), testutil.ToFloat64(h.authMetrics.FailuresTotal.WithLabelValues(authReasonAudience)))
+
+	r := httptest.NewRequest(http.MethodGet, "/public/v1/config", nil)
+	r.Header.Set("Authorization", "Bearer "+sign("homie-core")+"x")
+	w := httptest.NewRecorder()
+	auth.ServeHTTP(w, r)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Equal(t, float64(1), testutil.ToFloat64(h.authMetrics.FailuresTotal.WithLabelValues(authReasonInvalidToken)))
+}
+
+func TestRequireAdmin(t *testing.T) {
+	handler := func(token string) http.Handler {
+		return requireAdmin(token)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			writeResponse(w, "Ok")
+		}))
+	}
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		status int
+	}{
+		{"valid", "secret", "secret", http.StatusOK},
+		{"invalid", "secret", "guess", http.StatusForbidden},
+		{"missing", "secret", "", http.StatusForbidden},
+		{"disabled", "", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/private/chat/stats", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Admin-Token", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler(tt.token).ServeHTTP(w, r)
+			require.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func TestJWTRequiredClaimsAndLeeway(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	h := newHandler(logrus.New(), &resttest.Service{}, &key.PublicKey,
+		tokenRules{leeway: time.Minute, requiredClaims: []string{"tenant"}})
+	auth := h.jwtAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, r.Context().Value(uuidKey))
+	}))
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		status int
+	}{
+		{"valid", jwt.MapClaims{"uuid": "first", "tenant": "homie"}, http.StatusOK},
+		{"missing claim", jwt.MapClaims{"uuid": "first"}, http.StatusUnauthorized},
+		{"empty claim", jwt.MapClaims{"uuid": "first", "tenant": ""}, http.StatusUnauthorized},
+		{"expired within leeway", jwt.MapClaims{
+			"uuid": "first", "tenant": "homie", "exp": time.Now().Add(-30 * time.Second).Unix(),
+		}, http.StatusOK},
+		{"expired", jwt.MapClaims{
+			"uuid": "first", "tenant": "homie", "exp": time.Now().Add(-2 * time.Minute).Unix(),
+		}, http.StatusUnauthorized},
+		{"not valid yet within leeway", jwt.MapClaims{
+			"uuid": "first", "tenant": "homie", "nbf": time.Now().Add(30 * time.Second).Unix(),
+		}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, tt.claims).SignedString(key)
+			require.NoError(t, err)
+			r := httptest.NewRequest(http.MethodGet, "/public/v1/config", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			auth.ServeHTTP(w, r)
+			require.Equal(t, tt.status, w.Code)
+		})
+	}
+	require.Equal(t, float64(2), testutil.ToFloat64(h.authMetrics.FailuresTotal.WithLabelValues(authReasonMissingClaim)))
+}
+
+func TestJWTBannedIdentity(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	service := &resttest.Service{
+		IsBannedFunc: func(_ context.Context, subject, device string) (bool, error) {
+			return subject == "banned-subject" || device == "banned-device", nil
+		},
+	}
+	h := newHandler(logrus.New(), service, &key.PublicKey, tokenRules{})
+	auth := h.jwtAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, r.Context().Value(uuidKey))
+	}))
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		status int
+	}{
+		{"not banned", jwt.MapClaims{"uuid": "first", "sub": "subject", "device_id": "device"}, http.StatusOK},
+		{"banned subject", jwt.MapClaims{"uuid": "second", "sub": "banned-subject"}, http.StatusForbidden},
+		{"banned device", jwt.MapClaims{"uuid": "third", "sub": "subject", "device_id": "banned-device"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, tt.claims).SignedString(key)
+			require.NoError(t, err)
+			r := httptest.NewRequest(http.MethodGet, "/public/v1/config", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			auth.ServeHTTP(w, r)
+			require.Equal(t, tt.status, w.Code)
+			if tt.status != http.StatusOK {
+				require.NotContains(t, w.Body.String(), tt.claims["uuid"])
+			}
+		})
+	}
+	require.Equal(t, float64(2), testutil.ToFloat64(h.authMetrics.FailuresTotal.WithLabelValues(authReasonBanned)))
+	require.Len(t, service.Calls("IsBanned"), 3)
+}
+
+func TestJWTRevokedToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	service := &resttest.Service{
+		IsTokenRevokedFunc: func(_ context.Context, jti string) (bool, error) {
+			return jti == "revoked", nil
+		},
+	}
+	h := newHandler(logrus.New(), service, &key.PublicKey, tokenRules{})
+	auth := h.jwtAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, r.Context().Value(uuidKey))
+	}))
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		status int
+	}{
+		{"not revoked", jwt.MapClaims{"uuid": "first", "jti": "valid"}, http.StatusOK},
+		{"no jti", jwt.MapClaims{"uuid": "second"}, http.StatusOK},
+		{"revoked", jwt.MapClaims{"uuid": "third", "jti": "revoked"}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, tt.claims).SignedString(key)
+			require.NoError(t, err)
+			r := httptest.NewRequest(http.MethodGet, "/public/v1/config", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			auth.ServeHTTP(w, r)
+			require.Equal(t, tt.status, w.Code)
+		})
+	}
+	require.Equal(t, float64(1), testutil.ToFloat64(h.authMetrics.FailuresTotal.WithLabelValues(authReasonRevoked)))
+	require.Len(t, service.Calls("IsBanned"), 2, "revoked tokens aren't checked further")
+}
+
+func TestTimeout(t *testing.T) {
+	log, hook := logtest.NewNullLogger()
+	h := newHandler(log, nil, nil, tokenRules{})
+	slow := func(respond bool) http.Handler {
+		r := chi.NewRouter()
+		r.Use(h.timeout(20 * time.Millisecond))
+		r.Get("/slow/{id}", func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			if respond {
+				writeResponse(w, "late")
+			}
+		})
+		return r
+	}
+
+	w := httptest.NewRecorder()
+	slow(false).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow/1", nil))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, timeoutRetryAfter, w.Header().Get("Retry-After"))
+	var response JSONResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Equal(t, errCodeTimeout, *response.ErrorCode)
+	require.Equal(t, http.StatusServiceUnavailable, *response.Code)
+	require.Len(t, hook.AllEntries(), 1)
+	require.Contains(t, hook.LastEntry().Message, "GET /slow/{id}")
+
+	// a handler which responded already isn't written to again
+	w = httptest.NewRecorder()
+	slow(true).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow/1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"data":"late"}`, w.Body.String())
+	require.Len(t, hook.AllEntries(), 2)
+
+	// service errors of the deadline are reported as timeouts too
+	w = httptest.NewRecorder()
+	h.writeServiceError(w, fmt.Errorf("err getting regions: %w", context.DeadlineExceeded), "getting regions")
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, timeoutRetryAfter, w.Header().Get("Retry-After"))
+	require.NotContains(t, w.Body.String(), "regions")
+}
@@ -0,0 +1,351 @@
+package rest
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/chat"
+	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	openAPIVersion   = "3.0.3"
+	schemaRefPrefix  = "#/components/schemas/"
+	bearerAuthScheme = "bearerAuth"
+	adminAuthScheme  = "adminToken"
+)
+
+// openAPIBody holds samples of the request body and of the data of a successful response of a route,
+// their schemas are derived from json tags.
+type openAPIBody struct {
+	request  interface{}
+	response interface{}
+}
+
+// openAPIBodies describes bodies of routes by "METHOD /pattern", routes missing here are documented
+// with the bare JSONResponse envelope.
+var openAPIBodies = map[string]openAPIBody{
+	"GET /ping":                                       {response: ""},
+	"GET /version":                                    {response: ""},
+	"GET /openapi.json":                               {response: map[string]interface{}{}},
+	"GET /static/regions":                             {response: []*models.Region{}},
+	"GET /public/v1/bootstrap":                        {response: &bootstrapData{}},
+	"GET /public/v1/config":                           {response: &models.Config{}},
+	"GET /public/v1/config/schema":                    {response: &models.ConfigSchema{}},
+	"PUT /public/v1/config":                           {request: &models.Config{}},
+	"PATCH /public/v1/config":                         {request: &models.Config{}},
+	"GET /public/v1/settings":                         {response: &models.Settings{}},
+	"PUT /public/v1/settings":                         {request: &models.Settings{}},
+	"GET /public/v1/photos":                           {response: []*models.Photo{}},
+	"POST /public/v1/photos":                          {request: &photoRequest{}, response: &models.Photo{}},
+	"POST /public/v1/photos/presign":                  {response: &models.PhotoUpload{}},
+	"POST /public/v1/photos/confirm":                  {request: &photoConfirmRequest{}, response: &models.Photo{}},
+	"PUT /public/v1/photos/order":                     {request: &photoOrderRequest{}},
+	"POST /public/v1/devices":                         {request: &deviceRequest{}, response: &models.Device{}},
+	"GET /public/v1/matches":                          {response: []*models.Profile{}},
+	"GET /public/v1/feed":                             {response: []*models.Profile{}},
+	"GET /public/v1/feed/by-region":                   {response: map[int64]*models.RegionFeed{}},
+	"GET /public/v1/daily-pick":                       {response: &models.Profile{}},
+	"GET /public/v1/match/{uuid}":                     {response: &models.Match{}},
+	"GET /public/v1/match/{uuid}/common":              {response: &models.SharedAttributes{}},
+	"GET /public/v1/match/{uuid}/context":             {response: &models.CommonContext{}},
+	"GET /public/v1/relationship/{uuid}":              {response: &models.Relationship{}},
+	"POST /public/v1/decisions/reset":                 {request: &resetDecisionsRequest{}},
+	"POST /public/v1/relationships":                   {request: &relationshipsRequest{}, response: map[string]*models.Relationship{}}, //nolint:lll
+	"GET /public/v1/liked":                            {response: []*models.Profile{}},
+	"GET /public/v1/disliked":                         {response: []*models.Profile{}},
+	"GET /public/v1/decisions":                        {response: []*models.Decision{}},
+	"GET /public/v1/likes/incoming":                   {response: []*models.IncomingLike{}},
+	"GET /public/v1/viewers":                          {response: []*models.ProfileView{}},
+	"GET /public/v1/boost/status":                     {response: &models.BoostStatus{}},
+	"GET /public/v1/chats":                            {response: []*models.Profile{}},
+	"GET /public/v1/chats/unread-count":               {response: int64(0)},
+	"GET /public/v1/matches/count":                    {response: int64(0)},
+	"POST /public/v1/chat/{uuid}/message/{id}/edit":   {request: &editMessageRequest{}, response: &chat.Message{}},
+	"POST /public/v1/chat/{uuid}/message/{id}/report": {request: &reportMessageRequest{}, response: &models.MessageReport{}},
+	"POST /public/v1/chat/{uuid}/message/{id}/react":  {request: &reactRequest{}, response: &chat.Reaction{}},
+	"POST /public/v1/chat/{uuid}/snooze":              {request: &snoozeRequest{}},
+	"GET /public/v1/stats/timeseries":                 {response: []models.DayBucket{}},
+	"GET /private/chat/stats":                         {response: chat.Stats{}},
+	"GET /private/regions/stats":                      {response: []*models.RegionStats{}},
+	"POST /private/regions":                           {request: &models.Region{}, response: &models.Region{}},
+	"PUT /private/regions/{id}":                       {request: &models.Region{}, response: &models.Region{}},
+	"GET /private/bans":                               {response: []*models.Ban{}},
+	"POST /private/bans":                              {request: &models.Ban{}},
+	"POST /private/revoked-tokens":                    {request: &models.RevokedToken{}},
+	"GET /private/reports":                            {response: []*models.MessageReport{}},
+	"POST /private/maintenance/repair-matches":        {response: &models.MatchRepair{}},
+	"POST /private/impersonate/{uuid}":                {request: &impersonateRequest{}, response: &models.Impersonation{}},
+}
+
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*jsonSchema           `json:"schemas"`
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+type openAPISecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+}
+
+type openAPIOperation struct {
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+	Security    []map[string][]string      `json:"security,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name     string      `json:"name"`
+	In       string      `json:"in"`
+	Required bool        `json:"required"`
+	Schema   *jsonSchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *jsonSchema `json:"schema"`
+}
+
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	AllOf                []*jsonSchema          `json:"allOf,omitempty"`
+}
+
+// openAPIHandler serves the OpenAPI document of the router, built from its routes on the first request
+// so that every route is registered by then.
+func openAPIHandler(routes chi.Routes, version string) func(http.ResponseWriter, *http.Request) {
+	var (
+		once sync.Once
+		doc  *openAPIDocument
+	)
+	return func(w http.ResponseWriter, _ *http.Request) {
+		once.Do(func() {
+			doc = newOpenAPIDocument(routes, version)
+		})
+		writeResponse(w, doc)
+	}
+}
+
+func newOpenAPIDocument(routes chi.Routes, version string) *openAPIDocument {
+	doc := &openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info:    openAPIInfo{Title: "homie-core", Version: version},
+		Paths:   make(map[string]map[string]*openAPIOperation),
+		Components: openAPIComponents{
+			Schemas: make(map[string]*jsonSchema),
+			SecuritySchemes: map[string]openAPISecurityScheme{
+				bearerAuthScheme: {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+				adminAuthScheme:  {Type: "apiKey", In: "header", Name: "X-Admin-Token"},
+			},
+		},
+	}
+	doc.schemaFor(reflect.TypeOf(JSONResponse{}))
+	doc.addRoutes(routes, "")
+	return doc
+}
+
+func (d *openAPIDocument) addRoutes(routes chi.Routes, prefix string) {
+	for _, route := range routes.Routes() {
+		pattern := prefix + strings.TrimSuffix(route.Pattern, "/*")
+		if route.SubRoutes != nil {
+			d.addRoutes(route.SubRoutes, pattern)
+			continue
+		}
+		for method, handler := range route.Handlers {
+			if _, ok := route.Handlers["*"]; ok {
+				// routes handling any method are websocket upgrades, which are done with GET
+				if method != http.MethodGet {
+					continue
+				}
+			}
+			switch method {
+			case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+				d.addOperation(method, pattern, isRaw(handler))
+			}
+		}
+	}
+}
+
+var pathParamRe = regexp.MustCompile(`{([^}:]+)(:[^}]*)?}`)
+
+func (d *openAPIDocument) addOperation(method, pattern string, raw bool) {
+	path := pathParamRe.ReplaceAllString(pattern, "{$1}")
+	op := &openAPIOperation{Responses: make(map[string]openAPIResponse)}
+	for _, match := range pathParamRe.FindAllStringSubmatch(pattern, -1) {
+		op.Parameters = append(op.Parameters, openAPIParameter{
+			Name:     match[1],
+			In:       "path",
+			Required: true,
+			Schema:   &jsonSchema{Type: "string"},
+		})
+	}
+	switch {
+	case strings.HasPrefix(path, "/public/"):
+		op.Security = []map[string][]string{{bearerAuthScheme: {}}}
+	case strings.HasPrefix(path, "/private/"):
+		op.Security = []map[string][]string{{adminAuthScheme: {}}}
+	}
+	body := openAPIBodies[method+" "+path]
+	if body.request != nil {
+		contentType := "application/json"
+		if method == http.MethodPatch {
+			contentType = "application/merge-patch+json"
+		}
+		op.RequestBody = &openAPIRequestBody{
+			Required: true,
+			Content:  map[string]openAPIMediaType{contentType: {Schema: d.schemaFor(reflect.TypeOf(body.request))}},
+		}
+	}
+	envelope := &jsonSchema{Ref: schemaRefPrefix + "JSONResponse"}
+	success := envelope
+	if body.response != nil {
+		data := d.schemaFor(reflect.TypeOf(body.response))
+		success = &jsonSchema{AllOf: []*jsonSchema{envelope, {Type: "object", Properties: map[string]*jsonSchema{"data": data}}}}
+		if raw {
+			success = data
+		}
+	}
+	op.Responses["200"] = openAPIResponse{
+		Description: http.StatusText(http.StatusOK),
+		Content:     map[string]openAPIMediaType{"application/json": {Schema: success}},
+	}
+	op.Responses["default"] = openAPIResponse{
+		Description: "Error",
+		Content:     map[string]openAPIMediaType{"application/json": {Schema: envelope}},
+	}
+	if d.Paths[path] == nil {
+		d.Paths[path] = make(map[string]*openAPIOperation)
+	}
+	d.Paths[path][strings.ToLower(method)] = op
+}
+
+// isRaw tells whether the route responds without the JSONResponse envelope.
+func isRaw(handler http.Handler) bool {
+	chain, ok := handler.(*chi.ChainHandler)
+	if !ok {
+		return false
+	}
+	raw := reflect.ValueOf(rawResponses).Pointer()
+	for _, mw := range chain.Middlewares {
+		if reflect.ValueOf(mw).Pointer() == raw {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	timestampType = reflect.TypeOf(common.Timestamp{})
+	dateType      = reflect.TypeOf(models.Date{})
+)
+
+// schemaFor returns the schema of values of the type as encoded by encoding/json, named structs are
+// added to components and referenced.
+func (d *openAPIDocument) schemaFor(t reflect.Type) *jsonSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType, timestampType:
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	case dateType:
+		return &jsonSchema{Type: "string", Format: "date"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &jsonSchema{Type: "array", Items: d.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: d.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.objectSchema(t)
+		}
+		if _, ok := d.Components.Schemas[t.Name()]; !ok {
+			// registered before filling in so that recursive types refer to it
+			schema := &jsonSchema{}
+			d.Components.Schemas[t.Name()] = schema
+			*schema = *d.objectSchema(t)
+		}
+		return &jsonSchema{Ref: schemaRefPrefix + t.Name()}
+	default:
+		return &jsonSchema{}
+	}
+}
+
+func (d *openAPIDocument) objectSchema(t reflect.Type) *jsonSchema {
+	schema := &jsonSchema{Type: "object", Properties: make(map[string]*jsonSchema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for k, v := range d.objectSchema(embedded).Properties {
+					schema.Properties[k] = v
+				}
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = d.schemaFor(field.Type)
+	}
+	return schema
+}
@@ -0,0 +1,93 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+	router := newTestRouter(t, nil, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Title   string `json:"title"`
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths map[string]map[string]struct {
+			Parameters []struct {
+				Name string `json:"name"`
+				In   string `json:"in"`
+			} `json:"parameters"`
+			Responses map[string]json.RawMessage `json:"responses"`
+			Security  []map[string][]string      `json:"security"`
+		} `json:"paths"`
+		Components struct {
+			Schemas         map[string]json.RawMessage `json:"schemas"`
+			SecuritySchemes map[string]json.RawMessage `json:"securitySchemes"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	require.True(t, strings.HasPrefix(doc.OpenAPI, "3."))
+	require.NotEmpty(t, doc.Info.Title)
+	require.Equal(t, "test", doc.Info.Version)
+
+	for path, methods := range map[string][]string{
+		"/static/regions":                      {"get"},
+		"/public/v1/config":                    {"get", "put", "patch"},
+		"/public/v1/matches":                   {"get"},
+		"/public/v1/match/{uuid}":              {"get"},
+		"/public/v1/chats":                     {"get"},
+		"/public/v1/chat/{uuid}":               {"get"},
+		"/public/v1/chat/{uuid}/messages/{id}": {"delete"},
+		"/private/bans":                        {"get", "post"},
+	} {
+		require.Contains(t, doc.Paths, path)
+		for _, method := range methods {
+			require.Contains(t, doc.Paths[path], method, path)
+		}
+	}
+	require.Len(t, doc.Paths["/public/v1/chat/{uuid}"], 1, "websocket route is documented as GET only")
+	for _, name := range []string{"JSONResponse", "Config", "Profile", "Region"} {
+		require.Contains(t, doc.Components.Schemas, name)
+	}
+
+	paramRe := regexp.MustCompile(`{([^}]+)}`)
+	for path, methods := range doc.Paths {
+		for method, op := range methods {
+			require.NotEmpty(t, op.Responses, "%s %s", method, path)
+			var params []string
+			for _, param := range op.Parameters {
+				require.Equal(t, "path", param.In)
+				params = append(params, param.Name)
+			}
+			var templated []string
+			for _, match := range paramRe.FindAllStringSubmatch(path, -1) {
+				templated = append(templated, match[1])
+			}
+			require.ElementsMatch(t, templated, params, "%s %s", method, path)
+			for _, requirement := range op.Security {
+				for scheme := range requirement {
+					require.Contains(t, doc.Components.SecuritySchemes, scheme)
+				}
+			}
+			if strings.HasPrefix(path, "/public/") {
+				require.NotEmpty(t, op.Security, "%s %s", method, path)
+			}
+		}
+	}
+	// every reference resolves to a component schema
+	refRe := regexp.MustCompile(`"\$ref":"#/components/schemas/([^"]+)"`)
+	for _, match := range refRe.FindAllStringSubmatch(w.Body.String(), -1) {
+		require.Contains(t, doc.Components.Schemas, match[1])
+	}
+}
@@ -0,0 +1,151 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type photoRequest struct {
+	Link string `json:"link"`
+}
+
+type photoConfirmRequest struct {
+	Key string `json:"key"`
+}
+
+type photoOrderRequest struct {
+	IDs []int64 `json:"ids"`
+}
+
+func (h *handler) listPhotos(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	photos, err := h.service.ListPhotos(r.Context(), uuid)
+	if err != nil {
+		h.writeServiceError(w, err, "listing photos")
+		return
+	}
+	writeResponse(w, photos)
+}
+
+// addPhoto uploads a photo, replace_oldest=true replaces the oldest one other than the primary
+// when the user has as many photos as allowed.
+func (h *handler) addPhoto(w http.ResponseWriter, r *http.Request) {
+	replaceOldest, ok := parseReplaceOldest(w, r)
+	if !ok {
+		return
+	}
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	var req photoRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.Link == "" {
+		writeErrResponse(w, http.StatusText(http.StatusBadRequest)+": link is required", http.StatusBadRequest)
+		return
+	}
+	photo, err := h.service.AddPhoto(r.Context(), uuid, req.Link, replaceOldest)
+	if err != nil {
+		h.writeServiceError(w, err, "adding photo")
+		return
+	}
+	writeResponse(w, photo)
+}
+
+// presignPhoto responds with a URL to upload a photo to directly, which is added once confirmed.
+func (h *handler) presignPhoto(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	upload, err := h.service.PresignPhoto(r.Context(), uuid)
+	if err != nil {
+		h.writeServiceError(w, err, "presigning photo")
+		return
+	}
+	writeResponse(w, upload)
+}
+
+// confirmPhoto adds the photo uploaded to a presigned URL by its key, replace_oldest is as of addPhoto.
+func (h *handler) confirmPhoto(w http.ResponseWriter, r *http.Request) {
+	replaceOldest, ok := parseReplaceOldest(w, r)
+	if !ok {
+		return
+	}
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	var req photoConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.Key == "" {
+		writeErrResponse(w, http.StatusText(http.StatusBadRequest)+": key is required", http.StatusBadRequest)
+		return
+	}
+	photo, err := h.service.ConfirmPhoto(r.Context(), uuid, req.Key, replaceOldest)
+	if err != nil {
+		h.writeServiceError(w, err, "confirming photo")
+		return
+	}
+	writeResponse(w, photo)
+}
+
+func parseReplaceOldest(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	val := r.URL.Query().Get("replace_oldest")
+	if val == "" {
+		return false, true
+	}
+	replaceOldest, err := strconv.ParseBool(val)
+	if err != nil {
+		writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return false, false
+	}
+	return replaceOldest, true
+}
+
+func (h *handler) reorderPhotos(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	var req photoOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	err := h.service.ReorderPhotos(r.Context(), uuid, req.IDs)
+	if err != nil {
+		h.writeServiceError(w, err, "reordering photos")
+		return
+	}
+	writeResponse(w, "Ok")
+}
+
+func (h *handler) deletePhoto(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := h.getUUID(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeErrResponse(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if err = h.service.DeletePhoto(r.Context(), uuid, id); err != nil {
+		h.writeServiceError(w, err, "deleting photo")
+		return
+	}
+	writeResponse(w, "Ok")
+}
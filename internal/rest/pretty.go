@@ -0,0 +1,73 @@
+package rest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// prettyResponses indents JSON responses of every request if always, and of requests with ?pretty=true
+// if onQuery. Indented responses are meant for development, they waste bandwidth in production.
+func prettyResponses(always, onQuery bool) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			pretty := always
+			if !pretty && onQuery {
+				pretty, _ = strconv.ParseBool(r.URL.Query().Get("pretty"))
+			}
+			if !pretty {
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(prettyWriter{ResponseWriter: w}, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// prettyWriter marks responses to be indented, writers of inner middlewares wrap it.
+type prettyWriter struct {
+	http.ResponseWriter
+}
+
+func (pw prettyWriter) Flush() {
+	if f, ok := pw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (pw prettyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := pw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("err response writer doesn't support hijacking")
+	}
+	return hj.Hijack()
+}
+
+// isPretty reports whether responses written to w are indented, looking through writers wrapping it.
+func isPretty(w http.ResponseWriter) bool {
+	for {
+		switch ww := w.(type) {
+		case prettyWriter:
+			return true
+		case rawResponseWriter:
+			w = ww.ResponseWriter
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = ww.Unwrap()
+		default:
+			return false
+		}
+	}
+}
+
+// newEncoder returns an encoder of JSON responses to w, indenting them if they are to be pretty.
+func newEncoder(w http.ResponseWriter) *json.Encoder {
+	encoder := json.NewEncoder(w)
+	if isPretty(w) {
+		encoder.SetIndent("", "  ")
+	}
+	return encoder
+}
@@ -0,0 +1,58 @@
+package rest
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/internal/rest/resttest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrettyResponses(t *testing.T) {
+	service := &resttest.Service{
+		GetRegionsFunc: func(context.Context) ([]*models.Region, error) {
+			return []*models.Region{{ID: 1, Name: "Moscow"}}, nil
+		},
+	}
+	get := func(router http.Handler, path string, gzipped bool) string {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if gzipped {
+			r.Header.Set("Accept-Encoding", "gzip")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		var body io.Reader = w.Body
+		if w.Header().Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(w.Body)
+			require.NoError(t, err)
+			body = zr
+		}
+		b, err := io.ReadAll(body)
+		require.NoError(t, err)
+		return string(b)
+	}
+	compact := `{"data":[{"id":1,"name":"Moscow"`
+	pretty := "{\n  \"data\": [\n    {\n      \"id\": 1,\n      \"name\": \"Moscow\""
+
+	byDefault := newTestRouter(t, service, nil)
+	require.True(t, strings.HasPrefix(get(byDefault, "/static/regions", false), compact))
+	require.True(t, strings.HasPrefix(get(byDefault, "/static/regions?pretty=true", false), compact),
+		"the query is ignored unless enabled")
+
+	onQuery := newTestRouter(t, service, nil, WithPrettyResponses(false, true))
+	require.True(t, strings.HasPrefix(get(onQuery, "/static/regions", false), compact))
+	require.True(t, strings.HasPrefix(get(onQuery, "/static/regions?pretty=true", false), pretty))
+	require.True(t, strings.HasPrefix(get(onQuery, "/static/regions?pretty=1", true), pretty),
+		"through the writers of inner middlewares")
+	require.Contains(t, get(onQuery, "/public/v1/config?pretty=true", false), "\n  \"error\": ", "errors too")
+
+	always := newTestRouter(t, service, nil, WithPrettyResponses(true, false))
+	require.True(t, strings.HasPrefix(get(always, "/static/regions", false), pretty))
+	require.Equal(t, "\"pong\"\n", get(always, "/ping", false))
+}
@@ -0,0 +1,280 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+const (
+	// defaultIPRateLimit and defaultIPRateBurst let a client request unauthenticated routes 10 times a second
+	// on average and 20 times at once.
+	defaultIPRateLimit = 10
+	defaultIPRateBurst = 20
+	// defaultMaxInFlightWrites is the count of writes of a user handled at once.
+	defaultMaxInFlightWrites = 10
+)
+
+// ipLimiter is a token bucket of every client IP refilled at rate tokens a second up to burst ones.
+type ipLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mx      sync.Mutex
+	buckets map[string]*ipBucket
+	swept   time.Time
+}
+
+type ipBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+func newIPLimiter(rate float64, burst int) *ipLimiter {
+	return &ipLimiter{rate: rate, burst: float64(burst), now: time.Now, buckets: make(map[string]*ipBucket)}
+}
+
+// allow takes a token of the IP, otherwise it tells how long until the next one.
+func (l *ipLimiter) allow(ip string) (bool, time.Duration) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &ipBucket{tokens: l.burst, at: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.at).Seconds()*l.rate)
+	b.at = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep forgets buckets refilled up to burst, which are no different from new ones, once per refill period
+// so that the map doesn't grow with every IP ever seen.
+func (l *ipLimiter) sweep(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.swept) < full {
+		return
+	}
+	for ip, b := range l.buckets {
+		if now.Sub(b.at) >= full {
+			delete(l.buckets, ip)
+		}
+	}
+	l.swept = now
+}
+
+// ipRateLimit answers 429 to clients requesting faster than the limiter allows. Clients are told apart by
+// the remote address, which is the one of the client behind trusted proxies only, see realIP.
+func (h *handler) ipRateLimit(l *ipLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ok, wait := l.allow(remoteIP(r)); !ok {
+				h.writeServiceError(w, &common.RetryError{After: wait, Err: common.ErrTooManyRequests}, "limiting rate")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// inFlightLimiter counts requests of every user being handled, up to max of them.
+type inFlightLimiter struct {
+	max int
+
+	mx       sync.Mutex
+	inFlight map[string]int
+}
+
+func newInFlightLimiter(max int) *inFlightLimiter {
+	return &inFlightLimiter{max: max, inFlight: make(map[string]int)}
+}
+
+// acquire counts a request of the user unless they have max of them in flight already.
+func (l *inFlightLimiter) acquire(uuid string) bool {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.inFlight[uuid] >= l.max {
+		return false
+	}
+	l.inFlight[uuid]++
+	return true
+}
+
+func (l *inFlightLimiter) release(uuid string) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.inFlight[uuid]--; l.inFlight[uuid] <= 0 {
+		delete(l.inFlight, uuid)
+	}
+}
+
+// limitInFlight answers 429 to users with as many requests in flight as the limiter allows, so that a client
+// can't multiplex hundreds of writes at once however slow their rate is. It follows jwtAuth, nil l disables it.
+func (h *handler) limitInFlight(l *inFlightLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uuid, _ := r.Context().Value(uuidKey).(string)
+			if !l.acquire(uuid) {
+				h.writeServiceError(w, common.ErrTooManyInFlight, "limiting requests in flight")
+				return
+			}
+			defer l.release(uuid)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writesOnly applies the middleware to requests of unsafe methods only, reads pass as they are.
+func writesOnly(mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		limited := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				next.ServeHTTP(w, r)
+			default:
+				limited.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// remoteIP returns the host of the remote address, which realIP sets without a port. It's the full client IP
+// kept by anonymizeIPs if there is one.
+func remoteIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(fullIPKey).(string); ok {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// realIP takes the client IP from the X-Forwarded-For and X-Real-IP headers of requests coming from
+// the trusted proxies only, as anyone else may set the headers to whatever they like. The remote address
+// is set to the client IP without a port.
+func realIP(trusted []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isTrusted(trusted, net.ParseIP(remoteIP(r))) {
+				if ip := forwardedIP(r, trusted); ip != "" {
+					r.RemoteAddr = ip
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedIP returns the rightmost X-Forwarded-For entry which isn't a trusted proxy, as every proxy
+// appends the address it got the request from and entries left of the first untrusted one may be forged
+// by the client. It's the leftmost entry if all of them are trusted, X-Real-IP without X-Forwarded-For.
+func forwardedIP(r *http.Request, trusted []*net.IPNet) string {
+	var entries []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		entries = append(entries, strings.Split(header, ",")...)
+	}
+	var leftmost string
+	for i := len(entries) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(entries[i]))
+		if ip == nil {
+			// a malformed entry isn't appended by a trusted proxy, nothing left of it can be told from forged
+			break
+		}
+		if !isTrusted(trusted, ip) {
+			return ip.String()
+		}
+		leftmost = ip.String()
+	}
+	if leftmost != "" {
+		return leftmost
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+func isTrusted(trusted []*net.IPNet, ip net.IP) bool {
+	for _, n := range trusted {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+const fullIPKey idType = `FullIP`
+
+// anonymizeIPs replaces the remote address with the anonymized client IP, so that the access log, metrics
+// and anything else down the chain never see the full one. keepFull keeps the full IP in the context for
+// limiting rate by, which otherwise tells clients apart by their anonymized IPs.
+func anonymizeIPs(keepFull bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := remoteIP(r)
+			if keepFull {
+				r = r.WithContext(context.WithValue(r.Context(), fullIPKey, ip))
+			}
+			r.RemoteAddr = anonymizeIP(ip)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// anonymizeIP zeroes the last octet of IPv4 addresses and all but the first 48 bits of IPv6 ones. Hosts
+// which aren't IPs are dropped altogether.
+func anonymizeIP(host string) string {
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return ip.Mask(net.CIDRMask(24, 8*net.IPv4len)).String()
+	default:
+		return ip.Mask(net.CIDRMask(48, 8*net.IPv6len)).String()
+	}
+}
+
+// parseProxies parses CIDRs and plain IPs, the latter standing for networks of the single address.
+func parseProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %q is invalid", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is invalid", proxy)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
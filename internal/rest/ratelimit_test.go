@@ -0,0 +1,199 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/rest/resttest"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/golang-jwt/jwt"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPRateLimit(t *testing.T) {
+	router := newTestRouter(t, nil, nil, WithIPRateLimit(1), WithIPRateBurst(3), WithTrustedProxies([]string{"10.0.0.0/8"}))
+	ping := func(remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		r.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusOK, ping("192.0.2.1:1234", "").Code, i)
+	}
+	w := ping("192.0.2.1:4321", "")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "1", w.Header().Get("Retry-After"))
+	var response JSONResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Equal(t, errCodeLimitExceeded, *response.ErrorCode)
+	require.Equal(t, http.StatusOK, ping("192.0.2.2:1234", "").Code, "other IPs have their own budget")
+
+	// an untrusted client can't get a new budget by forging the header
+	require.Equal(t, http.StatusTooManyRequests, ping("192.0.2.1:1234", "198.51.100.1").Code)
+	// while clients behind a trusted proxy are told apart by it
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusOK, ping("10.0.0.1:1234", "198.51.100.1").Code, i)
+	}
+	require.Equal(t, http.StatusTooManyRequests, ping("10.0.0.1:1234", "198.51.100.1").Code)
+	require.Equal(t, http.StatusOK, ping("10.0.0.1:1234", "198.51.100.2").Code)
+	// prepending a forged IP doesn't get them a new budget either
+	for i := 0; i < 2; i++ {
+		require.Equal(t, http.StatusOK, ping("10.0.0.1:1234", "198.51.100.2").Code, i)
+	}
+	require.Equal(t, http.StatusTooManyRequests, ping("10.0.0.1:1234", "203.0.113.9, 198.51.100.2").Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/public/v1/config", nil))
+	require.Equal(t, http.StatusUnauthorized, w.Code, "authenticated routes aren't limited by IP")
+}
+
+func TestForwardedIP(t *testing.T) {
+	trusted, err := parseProxies([]string{"10.0.0.0/8", "192.0.2.7"})
+	require.NoError(t, err)
+	for _, tt := range []struct {
+		name      string
+		forwarded []string
+		realIP    string
+		want      string
+	}{
+		{name: "single", forwarded: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "forged by the client", forwarded: []string{"203.0.113.9, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "chained proxies", forwarded: []string{"198.51.100.1, 192.0.2.7", "10.0.0.2"}, want: "198.51.100.1"},
+		{name: "all trusted", forwarded: []string{"10.0.0.3, 10.0.0.2"}, want: "10.0.0.3"},
+		{name: "malformed", forwarded: []string{"203.0.113.9, bogus, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "real ip", realIP: " 198.51.100.1 ", want: "198.51.100.1"},
+		{name: "none"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ping", nil)
+			for _, header := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", header)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			require.Equal(t, tt.want, forwardedIP(r, trusted))
+		})
+	}
+}
+
+func TestIPLimiterRefill(t *testing.T) {
+	now := time.Now()
+	l := newIPLimiter(2, 2)
+	l.now = func() time.Time { return now }
+	for i := 0; i < 2; i++ {
+		ok, _ := l.allow("192.0.2.1")
+		require.True(t, ok, i)
+	}
+	ok, wait := l.allow("192.0.2.1")
+	require.False(t, ok)
+	require.Equal(t, 500*time.Millisecond, wait)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = l.allow("192.0.2.1")
+	require.True(t, ok)
+
+	// buckets idle long enough to be full again are forgotten
+	now = now.Add(time.Second)
+	_, _ = l.allow("192.0.2.2")
+	require.Len(t, l.buckets, 1)
+}
+
+func TestMaxInFlightWrites(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	sign := func(uuid string) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"uuid": uuid}).SignedString(key)
+		require.NoError(t, err)
+		return token
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	service := &resttest.Service{
+		LikeFunc: func(_ context.Context, uuid, _ string, _ bool) error {
+			if uuid == testUUID {
+				started <- struct{}{}
+				<-release
+			}
+			return nil
+		},
+	}
+	router := newTestRouter(t, service, &key.PublicKey, WithMaxInFlightWrites(2))
+	do := func(method, path, uuid string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Authorization", "Bearer "+sign(uuid))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	done := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- do(http.MethodGet, "/public/v1/like/"+testPeer+"?super=false", testUUID).Code
+		}()
+		<-started
+	}
+	w := do(http.MethodGet, "/public/v1/like/"+testPeer+"?super=false", testUUID)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	var response JSONResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Equal(t, errCodeLimitExceeded, *response.ErrorCode)
+	require.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "/public/v1/hide/"+testPeer, testUUID).Code,
+		"writes share the bound")
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/public/v1/matches/count", testUUID).Code, "reads aren't bound")
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/public/v1/like/"+testUUID+"?super=false", testPeer).Code,
+		"other users have their own bound")
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		require.Equal(t, http.StatusOK, <-done)
+	}
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/public/v1/hide/"+testPeer, testUUID).Code)
+}
+
+func TestAnonymizeIPs(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+	logged := realIP(nil)(anonymizeIPs(false)(middleware.RequestLogger(&middleware.DefaultLogFormatter{Logger: log, NoColor: true})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { writeResponse(w, "Ok") }))))
+	for addr, anonymized := range map[string]string{
+		"192.0.2.123:1234": "192.0.2.0",
+		"[2001:db8:85a3:8d3:1319:8a2e:370:7348]:1234": "2001:db8:85a3::",
+	} {
+		buf.Reset()
+		r := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		r.RemoteAddr = addr
+		logged.ServeHTTP(httptest.NewRecorder(), r)
+		require.Contains(t, buf.String(), "from "+anonymized+" ")
+		require.NotContains(t, buf.String(), addr[:len(addr)-len(":1234")])
+	}
+
+	ping := func(router http.Handler, remoteAddr string) int {
+		r := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+	router := newTestRouter(t, nil, nil, WithIPRateLimit(1), WithIPRateBurst(1), WithIPAnonymization(false))
+	require.Equal(t, http.StatusOK, ping(router, "192.0.2.1:1234"))
+	require.Equal(t, http.StatusTooManyRequests, ping(router, "192.0.2.2:1234"), "anonymized IPs share the budget")
+	router = newTestRouter(t, nil, nil, WithIPRateLimit(1), WithIPRateBurst(1), WithIPAnonymization(true))
+	require.Equal(t, http.StatusOK, ping(router, "192.0.2.1:1234"))
+	require.Equal(t, http.StatusOK, ping(router, "192.0.2.2:1234"), "full IPs are kept for limiting rate")
+}
@@ -0,0 +1,702 @@
+// Package resttest provides a programmable rest.Service for handler tests.
+package resttest
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/chat"
+)
+
+// Call is a recorded call of a Service method, Args don't include the context.
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// Service implements rest.Service by calling the matching Func field. Every call is recorded,
+// methods with nil Func return zero values.
+type Service struct {
+	SaveConfigFunc           func(ctx context.Context, config *models.Config) ([]models.Warning, error)
+	PatchConfigFunc          func(ctx context.Context, uuid string, patch []byte) ([]models.Warning, error)
+	GetConfigFunc            func(ctx context.Context, uuid string) (*models.Config, error)
+	GetSettingsFunc          func(ctx context.Context, uuid string) (*models.Settings, error)
+	GetSharedAttributesFunc  func(ctx context.Context, uuid, targetUUID string) (*models.SharedAttributes, error)
+	GetCommonContextFunc     func(ctx context.Context, uuid, targetUUID string) (*models.CommonContext, error)
+	SaveSettingsFunc         func(ctx context.Context, settings *models.Settings) error
+	GetRegionsFunc           func(ctx context.Context) ([]*models.Region, error)
+	UpsertRegionFunc         func(ctx context.Context, region *models.Region) error
+	AddPhotoFunc             func(ctx context.Context, uuid, link string, replaceOldest bool) (*models.Photo, error)
+	PresignPhotoFunc         func(ctx context.Context, uuid string) (*models.PhotoUpload, error)
+	ConfirmPhotoFunc         func(ctx context.Context, uuid, key string, replaceOldest bool) (*models.Photo, error)
+	DeletePhotoFunc          func(ctx context.Context, uuid string, id int64) error
+	ListPhotosFunc           func(ctx context.Context, uuid string) ([]*models.Photo, error)
+	ReorderPhotosFunc        func(ctx context.Context, uuid string, ids []int64) error
+	LikeFunc                 func(ctx context.Context, uuid, targetUUID string, super bool) error
+	LikeAndOpenChatFunc      func(ctx context.Context, uuid, targetUUID string, super bool) (string, error)
+	DislikeFunc              func(ctx context.Context, uuid, targetUUID string) error
+	GetMatchFunc             func(ctx context.Context, uuid, targetUUID string) (*models.Match, error)
+	GetMatchesWithStateFunc  func(ctx context.Context, uuid string, limit, offset int64) ([]*models.MatchWithState, error)
+	GetRelationshipFunc      func(ctx context.Context, uuid, targetUUID string) (*models.Relationship, error)
+	GetRelationshipsFunc     func(ctx context.Context, uuid string, targets []string) (map[string]*models.Relationship, error)
+	ArchiveMatchFunc         func(ctx context.Context, uuid, targetUUID string) error
+	HideFunc                 func(ctx context.Context, uuid, targetUUID string) error
+	UnhideFunc               func(ctx context.Context, uuid, targetUUID string) error
+	ReconsiderFunc           func(ctx context.Context, uuid, targetUUID string) error
+	ResetDecisionsFunc       func(ctx context.Context, uuid string) (int, error)
+	ListProfileViewersFunc   func(ctx context.Context, uuid string, limit int64) ([]*models.ProfileView, error)
+	GetUserTimeseriesFunc    func(ctx context.Context, uuid string, from, to time.Time) ([]models.DayBucket, error)
+	ListLikedProfilesFunc    func(ctx context.Context, uuid string, period models.TimeRange, limit, offset int64) ([]*models.Profile, error)                                    //nolint:lll
+	ListDislikedProfilesFunc func(ctx context.Context, uuid string, period models.TimeRange, limit, offset int64) ([]*models.Profile, error)                                    //nolint:lll
+	ListIncomingLikesFunc    func(ctx context.Context, uuid, cursor string, limit int64) ([]*models.IncomingLike, string, error)                                                //nolint:lll
+	ListDecisionsFunc        func(ctx context.Context, uuid, action string, period models.TimeRange, limit, offset int64, exact bool) ([]*models.Decision, models.Total, error) //nolint:lll
+	GetMatchesFunc           func(ctx context.Context, uuid string, count int64, sort models.FeedSort, newOnly bool) ([]*models.Profile, error)                                 //nolint:lll
+	CountCandidatesFunc      func(ctx context.Context, uuid string, newOnly bool) (models.Total, error)
+	GetDailyPickFunc         func(ctx context.Context, uuid string) (*models.Profile, error)
+	GetFeedByRegionFunc      func(ctx context.Context, uuid string, sample int64, sort models.FeedSort) (map[int64]*models.RegionFeed, error)                                       //nolint:lll
+	GetFeedPageFunc          func(ctx context.Context, uuid string, count int64, sort models.FeedSort, newOnly bool, token string, offset int64) ([]*models.Profile, string, error) //nolint:lll
+	StreamMatchesFunc        func(ctx context.Context, uuid string, count int64, sort models.FeedSort, fn func(*models.Profile) error) error                                        //nolint:lll
+	GetDialogFunc            func(ctx context.Context, client, target string) (*chat.Hub, error)
+	GetConversationInitFunc  func(ctx context.Context, uuid, peer string) (*models.ConversationInit, error)
+	ServeChatStreamFunc      func(w http.ResponseWriter, r *http.Request, uuid string, hooks chat.StreamHooks) error
+	GetAllChatsFunc          func(ctx context.Context, uuid string, includeArchived bool, limit, offset int64) ([]*models.Profile, error) //nolint:lll
+	ArchiveChatFunc          func(ctx context.Context, uuid, targetUUID string, archived bool) error
+	SnoozeChatFunc           func(ctx context.Context, uuid, targetUUID string, until time.Time) error
+	RetractMessageFunc       func(ctx context.Context, uuid, targetUUID string, id int64) error
+	EditMessageFunc          func(ctx context.Context, uuid, targetUUID string, id int64, body string) (*chat.Message, error)
+	ReactToMessageFunc       func(ctx context.Context, uuid, targetUUID string, id int64, emoji string) (*chat.Reaction, error)
+	RemoveReactionFunc       func(ctx context.Context, uuid, targetUUID string, id int64) error
+	ReportMessageFunc        func(ctx context.Context, uuid, targetUUID string, id int64, reason string) (*models.MessageReport, error) //nolint:lll
+	ExportChatFunc           func(ctx context.Context, uuid, targetUUID string, fn func(*chat.Message) error) error
+	MarkAllChatsReadFunc     func(ctx context.Context, uuid string) (int, error)
+	CountMatchesFunc         func(ctx context.Context, uuid string) (int64, error)
+	GetTotalUnreadFunc       func(ctx context.Context, uuid string) (int64, error)
+	GetLimitsFunc            func() models.Limits
+	GetQuotaFunc             func(ctx context.Context, uuid string) (*models.Quota, error)
+	GetConfigSchemaFunc      func() *models.ConfigSchema
+	ResolveConversationFunc  func(ctx context.Context, uuid, id string) (string, error)
+	ChatStatsFunc            func() chat.Stats
+	GetRegionStatsFunc       func(ctx context.Context, since time.Time) ([]*models.RegionStats, error)
+	IsBannedFunc             func(ctx context.Context, subject, device string) (bool, error)
+	BanIdentityFunc          func(ctx context.Context, ban *models.Ban) error
+	UnbanIdentityFunc        func(ctx context.Context, kind models.BanKind, value string) error
+	ListBansFunc             func(ctx context.Context) ([]*models.Ban, error)
+	IsTokenRevokedFunc       func(ctx context.Context, jti string) (bool, error)
+	RevokeTokenFunc          func(ctx context.Context, token *models.RevokedToken) error
+	RecordAuditFunc          func(ctx context.Context, entry *models.AuditEntry) error
+	ListMessageReportsFunc   func(ctx context.Context) ([]*models.MessageReport, error)
+	RepairMatchesFunc        func(ctx context.Context) (*models.MatchRepair, error)
+	StartBoostFunc           func(ctx context.Context, uuid string, duration time.Duration) error
+	GetBoostStatusFunc       func(ctx context.Context, uuid string) (*models.BoostStatus, error)
+	RegisterDeviceFunc       func(ctx context.Context, uuid string, platform models.DevicePlatform, token string) (*models.Device, error) //nolint:lll
+	UnregisterDeviceFunc     func(ctx context.Context, uuid string, id int64) error
+
+	mx    sync.Mutex
+	calls []Call
+}
+
+// Calls returns recorded calls of the method in order, all calls if the method is empty.
+func (s *Service) Calls(method string) []Call {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	var calls []Call
+	for _, call := range s.calls {
+		if method == "" || call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (s *Service) record(method string, args ...interface{}) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.calls = append(s.calls, Call{Method: method, Args: args})
+}
+
+func (s *Service) SaveConfig(ctx context.Context, config *models.Config) ([]models.Warning, error) {
+	s.record("SaveConfig", config)
+	if s.SaveConfigFunc != nil {
+		return s.SaveConfigFunc(ctx, config)
+	}
+	return nil, nil
+}
+
+func (s *Service) PatchConfig(ctx context.Context, uuid string, patch []byte) ([]models.Warning, error) {
+	s.record("PatchConfig", uuid, patch)
+	if s.PatchConfigFunc != nil {
+		return s.PatchConfigFunc(ctx, uuid, patch)
+	}
+	return nil, nil
+}
+
+func (s *Service) GetConfig(ctx context.Context, uuid string) (*models.Config, error) {
+	s.record("GetConfig", uuid)
+	if s.GetConfigFunc != nil {
+		return s.GetConfigFunc(ctx, uuid)
+	}
+	return nil, nil
+}
+
+func (s *Service) GetCommonContext(ctx context.Context, uuid, targetUUID string) (*models.CommonContext, error) {
+	s.record("GetCommonContext", uuid, targetUUID)
+	if s.GetCommonContextFunc != nil {
+		return s.GetCommonContextFunc(ctx, uuid, targetUUID)
+	}
+	return nil, nil
+}
+
+func (s *Service) GetSharedAttributes(ctx context.Context, uuid, targetUUID string) (*models.SharedAttributes, error) {
+	s.record("GetSharedAttributes", uuid, targetUUID)
+	if s.GetSharedAttributesFunc != nil {
+		return s.GetSharedAttributesFunc(ctx, uuid, targetUUID)
+	}
+	return nil, nil
+}
+
+func (s *Service) GetSettings(ctx context.Context, uuid string) (*models.Settings, error) {
+	s.record("GetSettings", uuid)
+	if s.GetSettingsFunc != nil {
+		return s.GetSettingsFunc(ctx, uuid)
+	}
+	return nil, nil
+}
+
+func (s *Service) SaveSettings(ctx context.Context, settings *models.Settings) error {
+	s.record("SaveSettings", settings)
+	if s.SaveSettingsFunc != nil {
+		return s.SaveSettingsFunc(ctx, settings)
+	}
+	return nil
+}
+
+func (s *Service) GetRegions(ctx context.Context) ([]*models.Region, error) {
+	s.record("GetRegions")
+	if s.GetRegionsFunc != nil {
+		return s.GetRegionsFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (s *Service) UpsertRegion(ctx context.Context, region *models.Region) error {
+	s.record("UpsertRegion", region)
+	if s.UpsertRegionFunc != nil {
+		return s.UpsertRegionFunc(ctx, region)
+	}
+	return nil
+}
+
+func (s *Service) AddPhoto(ctx context.Context, uuid, link string, replaceOldest bool) (*models.Photo, error) {
+	s.record("AddPhoto", uuid, link, replaceOldest)
+	if s.AddPhotoFunc != nil {
+		return s.AddPhotoFunc(ctx, uuid, link, replaceOldest)
+	}
+	return nil, nil
+}
+
+func (s *Service) PresignPhoto(ctx context.Context, uuid string) (*models.PhotoUpload, error) {
+	s.record("PresignPhoto", uuid)
+	if s.PresignPhotoFunc != nil {
+		return s.PresignPhotoFunc(ctx, uuid)
+	}
+	return nil, nil
+}
+
+func (s *Service) ConfirmPhoto(ctx context.Context, uuid, key string, replaceOldest bool) (*models.Photo, error) {
+	s.record("ConfirmPhoto", uuid, key, replaceOldest)
+	if s.ConfirmPhotoFunc != nil {
+		return s.ConfirmPhotoFunc(ctx, uuid, key, replaceOldest)
+	}
+	return nil, nil
+}
+
+func (s *Service) DeletePhoto(ctx context.Context, uuid string, id int64) error {
+	s.record("DeletePhoto", uuid, id)
+	if s.DeletePhotoFunc != nil {
+		return s.DeletePhotoFunc(ctx, uuid, id)
+	}
+	return nil
+}
+
+func (s *Service) ListPhotos(ctx context.Context, uuid string) ([]*models.Photo, error) {
+	s.record("ListPhotos", uuid)
+	if s.ListPhotosFunc != nil {
+		return s.ListPhotosFunc(ctx, uuid)
+	}
+	return nil, nil
+}
+
+func (s *Service) ReorderPhotos(ctx context.Context, uuid string, ids []int64) error {
+	s.record("ReorderPhotos", uuid, ids)
+	if s.ReorderPhotosFunc != nil {
+		return s.ReorderPhotosFunc(ctx, uuid, ids)
+	}
+	return nil
+}
+
+func (s *Service) Like(ctx context.Context, uuid, targetUUID string, super bool) error {
+	s.record("Like", uuid, targetUUID, super)
+	if s.LikeFunc != nil {
+		return s.LikeFunc(ctx, uuid, targetUUID, super)
+	}
+	return nil
+}
+
+func (s *Service) LikeAndOpenChat(ctx context.Context, uuid, targetUUID string, super bool) (string, error) {
+	s.record("LikeAndOpenChat", uuid, targetUUID, super)
+	if s.LikeAndOpenChatFunc != nil {
+		return s.LikeAndOpenChatFunc(ctx, uuid, targetUUID, super)
+	}
+	return "", nil
+}
+
+func (s *Service) Dislike(ctx context.Context, uuid, targetUUID string) error {
+	s.record("Dislike", uuid, targetUUID)
+	if s.DislikeFunc != nil {
+		return s.DislikeFunc(ctx, uuid, targetUUID)
+	}
+	return nil
+}
+
+func (s *Service) GetMatch(ctx context.Context, uuid, targetUUID string) (*models.Match, error) {
+	s.record("GetMatch", uuid, targetUUID)
+	if s.GetMatchFunc != nil {
+		return s.GetMatchFunc(ctx, uuid, targetUUID)
+	}
+	return nil, nil
+}
+
+func (s *Service) GetMatchesWithState(ctx context.Context, uuid string, limit, offset int64) ([]*models.MatchWithState, error) { //nolint:lll
+	s.record("GetMatchesWithState", uuid, limit, offset)
+	if s.GetMatchesWithStateFunc != nil {
+		return s.GetMatchesWithStateFunc(ctx, uuid, limit, offset)
+	}
+	return nil, nil
+}
+
+func (s *Service) GetRelationship(ctx context.Context, uuid, targetUUID string) (*models.Relationship, error) {
+	s.record("GetRelationship", uuid, targetUUID)
+	if s.GetRelationshipFunc != nil {
+		return s.GetRelationshipFunc(ctx, uuid, targetUUID)
+	}
+	return nil, nil
+}
+
+func (s *Service) GetRelationships(ctx context.Context, uuid string, targets []string) (map[string]*models.Relationship, error) { //nolint:lll
+	s.record("GetRelationships", uuid, targets)
+	if s.GetRelationshipsFunc != nil {
+		return s.GetRelationshipsFunc(ctx, uuid, targets)
+	}
+	return nil, nil
+}
+
+func (s *Service) ArchiveMatch(ctx context.Context, uuid, targetUUID string) error {
+	s.record("ArchiveMatch", uuid, targetUUID)
+	if s.ArchiveMatchFunc != nil {
+		return s.ArchiveMatchFunc(ctx, uuid, targetUUID)
+	}
+	return nil
+}
+
+func (s *Service) Hide(ctx context.Context, uuid, targetUUID string) error {
+	s.record("Hide", uuid, targetUUID)
+	if s.HideFunc != nil {
+		return s.HideFunc(ctx, uuid, targetUUID)
+	}
+	return nil
+}
+
+func (s *Service) Unhide(ctx context.Context, uuid, targetUUID string) error {
+	s.record("Unhide", uuid, targetUUID)
+	if s.UnhideFunc != nil {
+		return s.UnhideFunc(ctx, uuid, targetUUID)
+	}
+	return nil
+}
+
+func (s *Service) Reconsider(ctx context.Context, uuid, targetUUID string) error {
+	s.record("Reconsider", uuid, targetUUID)
+	if s.ReconsiderFunc != nil {
+		return s.ReconsiderFunc(ctx, uuid, targetUUID)
+	}
+	return nil
+}
+
+func (s *Service) ResetDecisions(ctx context.Context, uuid string) (int, error) {
+	s.record("ResetDecisions", uuid)
+	if s.ResetDecisionsFunc != nil {
+		return s.ResetDecisionsFunc(ctx, uuid)
+	}
+	return 0, nil
+}
+
+func (s *Service) ListLikedProfiles(ctx context.Context, uuid string, period models.TimeRange, limit, offset int64) ([]*models.Profile, error) { //nolint:lll
+	s.record("ListLikedProfiles", uuid, period, limit, offset)
+	if s.ListLikedProfilesFunc != nil {
+		return s.ListLikedProfilesFunc(ctx, uuid, period, limit, offset)
+	}
+	return nil, nil
+}
+
+func (s *Service) ListDislikedProfiles(ctx context.Context, uuid string, period models.TimeRange, limit, offset int64) ([]*models.Profile, error) { //nolint:lll
+	s.record("ListDislikedProfiles", uuid, period, limit, offset)
+	if s.ListDislikedProfilesFunc != nil {
+		return s.ListDislikedProfilesFunc(ctx, uuid, period, limit, offset)
+	}
+	return nil, nil
+}
+
+func (s *Service) ListIncomingLikes(ctx context.Context, uuid, cursor string, limit int64) ([]*models.IncomingLike, string, error) { //nolint:lll
+	s.record("ListIncomingLikes", uuid, cursor, limit)
+	if s.ListIncomingLikesFunc != nil {
+		return s.ListIncomingLikesFunc(ctx, uuid, cursor, limit)
+	}
+	return nil, "", nil
+}
+
+func (s *Service) ListProfileViewers(ctx context.Context, uuid string, limit int64) ([]*models.ProfileView, error) {
+	s.record("ListProfileViewers", uuid, limit)
+	if s.ListProfileViewersFunc != nil {
+		return s.ListProfileViewersFunc(ctx, uuid, limit)
+	}
+	return nil, nil
+}
+
+func (s *Service) GetUserTimeseries(ctx context.Context, uuid string, from, to time.Time) ([]models.DayBucket, error) {
+	s.record("GetUserTimeseries", uuid, from, to)
+	if s.GetUserTimeseriesFunc != nil {
+		return s.GetUserTimeseriesFunc(ctx, uuid, from, to)
+	}
+	return nil, nil
+}
+
+func (s *Service) ListDecisions(ctx context.Context, uuid, action string, period models.TimeRange, limit, offset int64, exact bool) ([]*models.Decision, models.Total, error) { //nolint:lll
+	s.record("ListDecisions", uuid, action, period, limit, offset, exact)
+	if s.ListDecisionsFunc != nil {
+		return s.ListDecisionsFunc(ctx, uuid, action, period, limit, offset, exact)
+	}
+	return nil, models.Total{}, nil
+}
+
+func (s *Service) GetMatches(ctx context.Context, uuid string, count int64, sort models.FeedSort, newOnly bool) ([]*models.Profile, error) { //nolint:lll
+	s.record("GetMatches", uuid, count, sort, newOnly)
+	if s.GetMatchesFunc != nil {
+		return s.GetMatchesFunc(ctx, uuid, count, sort, newOnly)
+	}
+	return nil, nil
+}
+
+func (s *Service) CountCandidates(ctx context.Context, uuid string, newOnly bool) (models.Total, error) {
+	s.record("CountCandidates", uuid, newOnly)
+	if s.CountCandidatesFunc != nil {
+		return s.CountCandidatesFunc(ctx, uuid, newOnly)
+	}
+	return models.Total{}, nil
+}
+
+func (s *Service) GetDailyPick(ctx context.Context, uuid string) (*models.Profile, error) {
+	s.record("GetDailyPick", uuid)
+	if s.GetDailyPickFunc != nil {
+		return s.GetDailyPickFunc(ctx, uuid)
+	}
+	return nil, nil
+}
+
+func (s *Service) GetFeedByRegion(ctx context.Context, uuid string, sample int64, sort models.FeedSort) (map[int64]*models.RegionFeed, error) { //nolint:lll
+	s.record("GetFeedByRegion", uuid, sample, sort)
+	if s.GetFeedByRegionFunc != nil {
+		return s.GetFeedByRegionFunc(ctx, uuid, sample, sort)
+	}
+	return nil, nil
+}
+
+func (s *Service) GetFeedPage(ctx context.Context, uuid string, count int64, sort models.FeedSort, newOnly bool, token string, offset int64) ([]*models.Profile, string, error) { //nolint:lll
+	s.record("GetFeedPage", uuid, count, sort, newOnly, token, offset)
+	if s.GetFeedPageFunc != nil {
+		return s.GetFeedPageFunc(ctx, uuid, count, sort, newOnly, token, offset)
+	}
+	return nil, "", nil
+}
+
+func (s *Service) StreamMatches(ctx context.Context, uuid string, count int64, sort models.FeedSort, fn func(*models.Profile) error) error { //nolint:lll
+	s.record("StreamMatches", uuid, count, sort)
+	if s.StreamMatchesFunc != nil {
+		return s.StreamMatchesFunc(ctx, uuid, count, sort, fn)
+	}
+	return nil
+}
+
+func (s *Service) GetDialog(ctx context.Context, client, target string) (*chat.Hub, error) {
+	s.record("GetDialog", client, target)
+	if s.GetDialogFunc != nil {
+		return s.GetDialogFunc(ctx, client, target)
+	}
+	return nil, nil
+}
+
+func (s *Service) GetConversationInit(ctx context.Context, uuid, peer string) (*models.ConversationInit, error) {
+	s.record("GetConversationInit", uuid, peer)
+	if s.GetConversationInitFunc != nil {
+		return s.GetConversationInitFunc(ctx, uuid, peer)
+	}
+	return nil, nil
+}
+
+func (s *Service) ServeChatStream(w http.ResponseWriter, r *http.Request, uuid string, hooks chat.StreamHooks) error {
+	s.record("ServeChatStream", uuid)
+	if s.ServeChatStreamFunc != nil {
+		return s.ServeChatStreamFunc(w, r, uuid, hooks)
+	}
+	return nil
+}
+
+func (s *Service) GetAllChats(ctx context.Context, uuid string, includeArchived bool, limit, offset int64) ([]*models.Profile, error) { //nolint:lll
+	s.record("GetAllChats", uuid, includeArchived, limit, offset)
+	if s.GetAllChatsFunc != nil {
+		return s.GetAllChatsFunc(ctx, uuid, includeArchived, limit, offset)
+	}
+	return nil, nil
+}
+
+func (s *Service) ArchiveChat(ctx context.Context, uuid, targetUUID string, archived bool) error {
+	s.record("ArchiveChat", uuid, targetUUID, archived)
+	if s.ArchiveChatFunc != nil {
+		return s.ArchiveChatFunc(ctx, uuid, targetUUID, archived)
+	}
+	return nil
+}
+
+func (s *Service) SnoozeChat(ctx context.Context, uuid, targetUUID string, until time.Time) error {
+	s.record("SnoozeChat", uuid, targetUUID, until)
+	if s.SnoozeChatFunc != nil {
+		return s.SnoozeChatFunc(ctx, uuid, targetUUID, until)
+	}
+	return nil
+}
+
+func (s *Service) RetractMessage(ctx context.Context, uuid, targetUUID string, id int64) error {
+	s.record("RetractMessage", uuid, targetUUID, id)
+	if s.RetractMessageFunc != nil {
+		return s.RetractMessageFunc(ctx, uuid, targetUUID, id)
+	}
+	return nil
+}
+
+func (s *Service) EditMessage(ctx context.Context, uuid, targetUUID string, id int64, body string) (*chat.Message, error) {
+	s.record("EditMessage", uuid, targetUUID, id, body)
+	if s.EditMessageFunc != nil {
+		return s.EditMessageFunc(ctx, uuid, targetUUID, id, body)
+	}
+	return nil, nil
+}
+
+func (s *Service) ReportMessage(ctx context.Context, uuid, targetUUID string, id int64, reason string) (*models.MessageReport, error) { //nolint:lll
+	s.record("ReportMessage", uuid, targetUUID, id, reason)
+	if s.ReportMessageFunc != nil {
+		return s.ReportMessageFunc(ctx, uuid, targetUUID, id, reason)
+	}
+	return nil, nil
+}
+
+func (s *Service) ReactToMessage(ctx context.Context, uuid, targetUUID string, id int64, emoji string) (*chat.Reaction, error) { //nolint:lll
+	s.record("ReactToMessage", uuid, targetUUID, id, emoji)
+	if s.ReactToMessageFunc != nil {
+		return s.ReactToMessageFunc(ctx, uuid, targetUUID, id, emoji)
+	}
+	return nil, nil
+}
+
+func (s *Service) RemoveReaction(ctx context.Context, uuid, targetUUID string, id int64) error {
+	s.record("RemoveReaction", uuid, targetUUID, id)
+	if s.RemoveReactionFunc != nil {
+		return s.RemoveReactionFunc(ctx, uuid, targetUUID, id)
+	}
+	return nil
+}
+
+func (s *Service) ExportChat(ctx context.Context, uuid, targetUUID string, fn func(*chat.Message) error) error {
+	s.record("ExportChat", uuid, targetUUID)
+	if s.ExportChatFunc != nil {
+		return s.ExportChatFunc(ctx, uuid, targetUUID, fn)
+	}
+	return nil
+}
+
+func (s *Service) MarkAllChatsRead(ctx context.Context, uuid string) (int, error) {
+	s.record("MarkAllChatsRead", uuid)
+	if s.MarkAllChatsReadFunc != nil {
+		return s.MarkAllChatsReadFunc(ctx, uuid)
+	}
+	return 0, nil
+}
+
+func (s *Service) CountMatches(ctx context.Context, uuid string) (int64, error) {
+	s.record("CountMatches", uuid)
+	if s.CountMatchesFunc != nil {
+		return s.CountMatchesFunc(ctx, uuid)
+	}
+	return 0, nil
+}
+
+func (s *Service) GetTotalUnread(ctx context.Context, uuid string) (int64, error) {
+	s.record("GetTotalUnread", uuid)
+	if s.GetTotalUnreadFunc != nil {
+		return s.GetTotalUnreadFunc(ctx, uuid)
+	}
+	return 0, nil
+}
+
+func (s *Service) GetLimits() models.Limits {
+	s.record("GetLimits")
+	if s.GetLimitsFunc != nil {
+		return s.GetLimitsFunc()
+	}
+	return models.Limits{}
+}
+
+func (s *Service) GetQuota(ctx context.Context, uuid string) (*models.Quota, error) {
+	s.record("GetQuota", uuid)
+	if s.GetQuotaFunc != nil {
+		return s.GetQuotaFunc(ctx, uuid)
+	}
+	return nil, nil
+}
+
+func (s *Service) GetConfigSchema() *models.ConfigSchema {
+	s.record("GetConfigSchema")
+	if s.GetConfigSchemaFunc != nil {
+		return s.GetConfigSchemaFunc()
+	}
+	return &models.ConfigSchema{}
+}
+
+func (s *Service) ResolveConversation(ctx context.Context, uuid, id string) (string, error) {
+	s.record("ResolveConversation", uuid, id)
+	if s.ResolveConversationFunc != nil {
+		return s.ResolveConversationFunc(ctx, uuid, id)
+	}
+	return "", nil
+}
+
+func (s *Service) ChatStats() chat.Stats {
+	s.record("ChatStats")
+	if s.ChatStatsFunc != nil {
+		return s.ChatStatsFunc()
+	}
+	return chat.Stats{}
+}
+
+func (s *Service) GetRegionStats(ctx context.Context, since time.Time) ([]*models.RegionStats, error) {
+	s.record("GetRegionStats", since)
+	if s.GetRegionStatsFunc != nil {
+		return s.GetRegionStatsFunc(ctx, since)
+	}
+	return nil, nil
+}
+
+func (s *Service) IsBanned(ctx context.Context, subject, device string) (bool, error) {
+	s.record("IsBanned", subject, device)
+	if s.IsBannedFunc != nil {
+		return s.IsBannedFunc(ctx, subject, device)
+	}
+	return false, nil
+}
+
+func (s *Service) BanIdentity(ctx context.Context, ban *models.Ban) error {
+	s.record("BanIdentity", ban)
+	if s.BanIdentityFunc != nil {
+		return s.BanIdentityFunc(ctx, ban)
+	}
+	return nil
+}
+
+func (s *Service) UnbanIdentity(ctx context.Context, kind models.BanKind, value string) error {
+	s.record("UnbanIdentity", kind, value)
+	if s.UnbanIdentityFunc != nil {
+		return s.UnbanIdentityFunc(ctx, kind, value)
+	}
+	return nil
+}
+
+func (s *Service) ListBans(ctx context.Context) ([]*models.Ban, error) {
+	s.record("ListBans")
+	if s.ListBansFunc != nil {
+		return s.ListBansFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (s *Service) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	s.record("IsTokenRevoked", jti)
+	if s.IsTokenRevokedFunc != nil {
+		return s.IsTokenRevokedFunc(ctx, jti)
+	}
+	return false, nil
+}
+
+func (s *Service) RevokeToken(ctx context.Context, token *models.RevokedToken) error {
+	s.record("RevokeToken", token)
+	if s.RevokeTokenFunc != nil {
+		return s.RevokeTokenFunc(ctx, token)
+	}
+	return nil
+}
+
+func (s *Service) RecordAudit(ctx context.Context, entry *models.AuditEntry) error {
+	s.record("RecordAudit", entry)
+	if s.RecordAuditFunc != nil {
+		return s.RecordAuditFunc(ctx, entry)
+	}
+	return nil
+}
+
+func (s *Service) ListMessageReports(ctx context.Context) ([]*models.MessageReport, error) {
+	s.record("ListMessageReports")
+	if s.ListMessageReportsFunc != nil {
+		return s.ListMessageReportsFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (s *Service) RepairMatches(ctx context.Context) (*models.MatchRepair, error) {
+	s.record("RepairMatches")
+	if s.RepairMatchesFunc != nil {
+		return s.RepairMatchesFunc(ctx)
+	}
+	return &models.MatchRepair{}, nil
+}
+
+func (s *Service) StartBoost(ctx context.Context, uuid string, duration time.Duration) error {
+	s.record("StartBoost", uuid, duration)
+	if s.StartBoostFunc != nil {
+		return s.StartBoostFunc(ctx, uuid, duration)
+	}
+	return nil
+}
+
+func (s *Service) GetBoostStatus(ctx context.Context, uuid string) (*models.BoostStatus, error) {
+	s.record("GetBoostStatus", uuid)
+	if s.GetBoostStatusFunc != nil {
+		return s.GetBoostStatusFunc(ctx, uuid)
+	}
+	return nil, nil
+}
+
+func (s *Service) RegisterDevice(ctx context.Context, uuid string, platform models.DevicePlatform, token string) (*models.Device, error) { //nolint:lll
+	s.record("RegisterDevice", uuid, platform, token)
+	if s.RegisterDeviceFunc != nil {
+		return s.RegisterDeviceFunc(ctx, uuid, platform, token)
+	}
+	return nil, nil
+}
+
+func (s *Service) UnregisterDevice(ctx context.Context, uuid string, id int64) error {
+	s.record("UnregisterDevice", uuid, id)
+	if s.UnregisterDeviceFunc != nil {
+		return s.UnregisterDeviceFunc(ctx, uuid, id)
+	}
+	return nil
+}
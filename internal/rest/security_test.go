@@ -0,0 +1,43 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	w := httptest.NewRecorder()
+	newTestRouter(t, nil, nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	require.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+	require.Equal(t, "no-referrer", w.Header().Get("Referrer-Policy"))
+	require.Equal(t, "default-src 'none'; frame-ancestors 'none'", w.Header().Get("Content-Security-Policy"))
+	require.Empty(t, w.Header().Values("Strict-Transport-Security"), "HSTS is off by default")
+
+	headers := DefaultSecurityHeaders()
+	headers.ReferrerPolicy = ""
+	headers.ContentSecurityPolicy = "default-src 'self'"
+	headers.HSTSMaxAge = 365 * 24 * time.Hour
+	headers.HSTSIncludeSubdomains = true
+	router := newTestRouter(t, nil, nil, WithSecurityHeaders(headers))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"), "errors get them too")
+	require.Empty(t, w.Header().Values("Referrer-Policy"))
+	require.Equal(t, "default-src 'self'", w.Header().Get("Content-Security-Policy"))
+	require.Equal(t, "max-age=31536000; includeSubDomains", w.Header().Get("Strict-Transport-Security"))
+
+	// WebSocket handshakes are left alone
+	r := httptest.NewRequest(http.MethodGet, "/public/v1/chat/ws", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	w = httptest.NewRecorder()
+	securityHeaders(headers)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(w, r)
+	require.Empty(t, w.Header())
+}
@@ -0,0 +1,33 @@
+//go:build testseed
+
+package rest
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type seeder interface {
+	Seed(ctx context.Context) error
+}
+
+// registerSeed mounts the test seed endpoint. Only compiled with the testseed build tag.
+func (h *handler) registerSeed(r chi.Router) {
+	r.Post("/test/seed", h.seed)
+}
+
+func (h *handler) seed(w http.ResponseWriter, r *http.Request) {
+	s, ok := h.service.(seeder)
+	if !ok {
+		writeErrResponse(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+	if err := s.Seed(r.Context()); err != nil {
+		h.log.Warnf("err seeding: %v", err)
+		writeErrResponse(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, "Ok")
+}
@@ -0,0 +1,18 @@
+//go:build !testseed
+
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeedDisabledByDefault(t *testing.T) {
+	router := newTestRouter(t, nil, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/private/test/seed", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
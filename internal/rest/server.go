@@ -0,0 +1,92 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Defaults bounding how long clients may take to send requests, net/http waits forever unless they are set,
+// so clients dribbling headers or bodies could hold connections open.
+const (
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultReadTimeout       = 30 * time.Second
+	defaultWriteTimeout      = 30 * time.Second
+	defaultMaxHeaderBytes    = 64 << 10
+)
+
+type serverOptions struct {
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	maxHeaderBytes    int
+}
+
+type ServerOption func(*serverOptions)
+
+// WithReadHeaderTimeout sets how long a client may take to send request headers, 5s by default.
+func WithReadHeaderTimeout(timeout time.Duration) ServerOption {
+	return func(o *serverOptions) {
+		o.readHeaderTimeout = timeout
+	}
+}
+
+// WithReadTimeout sets how long a client may take to send a whole request, 30s by default.
+func WithReadTimeout(timeout time.Duration) ServerOption {
+	return func(o *serverOptions) {
+		o.readTimeout = timeout
+	}
+}
+
+// WithMaxHeaderBytes caps the size of request headers, 64KiB by default. Larger ones are answered with 431.
+func WithMaxHeaderBytes(size int) ServerOption {
+	return func(o *serverOptions) {
+		o.maxHeaderBytes = size
+	}
+}
+
+func (o *serverOptions) validate() error {
+	var problems []string
+	if o.readHeaderTimeout <= 0 {
+		problems = append(problems, fmt.Sprintf("read header timeout %s is not positive", o.readHeaderTimeout))
+	}
+	if o.readTimeout <= 0 {
+		problems = append(problems, fmt.Sprintf("read timeout %s is not positive", o.readTimeout))
+	} else if o.readHeaderTimeout > o.readTimeout {
+		problems = append(problems, fmt.Sprintf("read header timeout %s exceeds read timeout %s",
+			o.readHeaderTimeout, o.readTimeout))
+	}
+	if o.maxHeaderBytes <= 0 {
+		problems = append(problems, fmt.Sprintf("max header bytes %d is not positive", o.maxHeaderBytes))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("err invalid server config: %s", strings.Join(problems, "; "))
+}
+
+// NewServer returns a server of the handler on the address with timeouts and the header size limit set,
+// an error describing every problem of the options if they are invalid.
+func NewServer(addr string, handler http.Handler, opts ...ServerOption) (*http.Server, error) {
+	o := serverOptions{
+		readHeaderTimeout: defaultReadHeaderTimeout,
+		readTimeout:       defaultReadTimeout,
+		writeTimeout:      defaultWriteTimeout,
+		maxHeaderBytes:    defaultMaxHeaderBytes,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: o.readHeaderTimeout,
+		ReadTimeout:       o.readTimeout,
+		WriteTimeout:      o.writeTimeout,
+		MaxHeaderBytes:    o.maxHeaderBytes,
+	}, nil
+}
@@ -0,0 +1,84 @@
+package rest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewServer(t *testing.T) {
+	s, err := NewServer(":3000", http.NotFoundHandler())
+	require.NoError(t, err)
+	require.Equal(t, ":3000", s.Addr)
+	require.Equal(t, 5*time.Second, s.ReadHeaderTimeout)
+	require.Equal(t, 30*time.Second, s.ReadTimeout)
+	require.Equal(t, 30*time.Second, s.WriteTimeout)
+	require.Equal(t, 64<<10, s.MaxHeaderBytes)
+
+	s, err = NewServer(":3000", http.NotFoundHandler(),
+		WithReadHeaderTimeout(time.Second), WithReadTimeout(10*time.Second), WithMaxHeaderBytes(8<<10))
+	require.NoError(t, err)
+	require.Equal(t, time.Second, s.ReadHeaderTimeout)
+	require.Equal(t, 10*time.Second, s.ReadTimeout)
+	require.Equal(t, 8<<10, s.MaxHeaderBytes)
+
+	_, err = NewServer(":3000", http.NotFoundHandler(), WithReadHeaderTimeout(0), WithMaxHeaderBytes(-1))
+	require.EqualError(t, err, "err invalid server config: read header timeout 0s is not positive; "+
+		"max header bytes -1 is not positive")
+	_, err = NewServer(":3000", http.NotFoundHandler(), WithReadHeaderTimeout(time.Minute))
+	require.EqualError(t, err, "err invalid server config: read header timeout 1m0s exceeds read timeout 30s")
+}
+
+func TestServerDropsSlowClients(t *testing.T) {
+	s, err := NewServer("", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeResponse(w, "Ok")
+	}), WithReadHeaderTimeout(50*time.Millisecond), WithReadTimeout(time.Second), WithMaxHeaderBytes(1<<10))
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = s.Serve(l)
+	}()
+	defer s.Close()
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		require.NoError(t, conn.SetDeadline(time.Now().Add(2*time.Second)))
+		return conn
+	}
+
+	// headers dribbled past the timeout get the connection closed
+	conn := dial()
+	defer conn.Close()
+	_, err = fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n")
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+	require.Less(t, time.Since(start), time.Second, "closed by the server rather than the client deadline")
+
+	// net/http allows 4KiB more than the limit
+	conn = dial()
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Padding: %s\r\n\r\n", strings.Repeat("a", 8<<10))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+
+	conn = dial()
+	defer conn.Close()
+	_, err = fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+// RevokeToken rejects access tokens with the jti claim from now on, even if they are valid otherwise, until
+// the expiry of the token if it's set. Revocations of expired tokens are pruned meanwhile.
+func (a *App) RevokeToken(ctx context.Context, token *models.RevokedToken) error {
+	if token.JTI == "" {
+		return fmt.Errorf("%w: empty jti", common.ErrInvalidRevocation)
+	}
+	now := a.now()
+	if count, err := a.store.DeleteExpiredRevokedTokens(ctx, now); err != nil {
+		a.log.Warnf("err pruning revoked tokens: %v", err)
+	} else if count > 0 {
+		a.log.Infof("pruned %d revocations of expired tokens", count)
+	}
+	token.RevokedAt = common.NewTimestamp(now)
+	if err := a.store.SaveRevokedToken(ctx, token); err != nil {
+		return fmt.Errorf("err revoking token: %w", err)
+	}
+	a.revocations.reset()
+	return nil
+}
+
+// IsTokenRevoked reports whether the token of the jti claim is revoked, tokens without one never are.
+// Revocations of unexpired tokens are cached for revocationsTTL, so revocations made by other instances
+// apply within it. Without caching the jti is looked up by itself.
+func (a *App) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	if jti == "" {
+		return false, nil
+	}
+	now := a.now()
+	if a.revocationsTTL <= 0 {
+		revoked, err := a.store.IsTokenRevoked(ctx, jti, now)
+		if err != nil {
+			return false, fmt.Errorf("err checking revoked token: %w", err)
+		}
+		return revoked, nil
+	}
+	revoked, ok := a.revocations.get(now, a.revocationsTTL)
+	if !ok {
+		jtis, err := a.store.ListRevokedTokens(ctx, now)
+		if err != nil {
+			return false, fmt.Errorf("err checking revoked tokens: %w", err)
+		}
+		revoked = makeSet(jtis)
+		a.revocations.set(revoked, now)
+	}
+	return revoked[jti], nil
+}
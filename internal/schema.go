@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"math"
+	"sort"
+
+	"github.com/gerladeno/homie-core/internal/models"
+)
+
+// GetConfigSchema returns the constraints prepareConfig enforces on configs.
+func (a *App) GetConfigSchema() *models.ConfigSchema {
+	languages := make([]string, 0, len(a.bioLanguages))
+	for lang := range a.bioLanguages {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+	var genders []models.Gender
+	for g := models.Any; g.Valid(); g++ {
+		genders = append(genders, g)
+	}
+	return &models.ConfigSchema{
+		Personal: models.PersonalSchema{
+			// a gender has to be specified
+			Genders:           genders[1:],
+			MinAge:            a.minAge,
+			MaxAge:            math.MaxInt8,
+			MaxUsernameLength: a.username.maxLength,
+			ASCIIUsername:     a.username.asciiOnly,
+			MaxBioLength:      a.maxBioLength,
+			BioLanguages:      languages,
+		},
+		Criteria: models.CriteriaSchema{
+			Genders:    genders,
+			MaxRegions: a.maxRegions,
+			MaxBudget:  a.maxBudget,
+		},
+	}
+}
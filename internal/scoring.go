@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/gerladeno/homie-core/internal/models"
+)
+
+// minParallelScoring is the count of candidates below which they are scored serially, since handing them
+// to workers costs more than it saves.
+const minParallelScoring = 256
+
+// WithScoringWorkers sets how many workers score candidates of matches, GOMAXPROCS by default.
+// Non-positive workers mean the default, one scores serially.
+func WithScoringWorkers(workers int) Option {
+	return func(a *App) {
+		if workers <= 0 {
+			workers = runtime.GOMAXPROCS(0)
+		}
+		a.scoringWorkers = workers
+	}
+}
+
+// scoreCandidates fills distances from the user to the candidates and projects them. A failure to get
+// the user's location is returned after projecting the candidates without distances.
+func (a *App) scoreCandidates(ctx context.Context, uuid string, profiles []*models.Profile) error {
+	if len(profiles) == 0 {
+		return nil
+	}
+	self, err := a.location(ctx, uuid)
+	a.forEachCandidate(profiles, func(p *models.Profile) {
+		// distances are computed from locations which aren't public, so before projecting
+		if err == nil {
+			a.setDistance(self, p)
+		}
+		a.projectProfile(p)
+	})
+	return err
+}
+
+// forEachCandidate calls fn for every profile, splitting them into contiguous chunks across the workers
+// when there are enough of them. fn may only change the profile it's called for, so the profiles keep
+// their order and content whatever the count of workers.
+func (a *App) forEachCandidate(profiles []*models.Profile, fn func(p *models.Profile)) {
+	workers := a.scoringWorkers
+	if workers <= 1 || len(profiles) < minParallelScoring {
+		for _, p := range profiles {
+			fn(p)
+		}
+		return
+	}
+	if workers > len(profiles) {
+		workers = len(profiles)
+	}
+	chunk := (len(profiles) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(profiles); start += chunk {
+		end := start + chunk
+		if end > len(profiles) {
+			end = len(profiles)
+		}
+		wg.Add(1)
+		go func(profiles []*models.Profile) {
+			defer wg.Done()
+			for _, p := range profiles {
+				fn(p)
+			}
+		}(profiles[start:end])
+	}
+	wg.Wait()
+}
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/logging"
+)
+
+// secondChance re-surfaces profiles disliked long ago in matches, people change.
+type secondChance struct {
+	// age is how long ago a profile must have been disliked, zero disables second chances.
+	age time.Duration
+	// rate is the probability a user gets one on a day.
+	rate float64
+	// roll returns a number in [0, 1) of the seed compared with rate.
+	roll func(seed string) float64
+}
+
+// DefaultSecondChanceRate is the probability of a second chance per user and day unless configured otherwise.
+const DefaultSecondChanceRate = 0.05
+
+// WithSecondChance lets a profile disliked more than age ago back into matches with the probability
+// per user and UTC day, flagged with SecondChance. Second chances meet the user's search criteria, profiles
+// hidden by either user never come back. Zero age or probability disables second chances, which is the default.
+// It panics unless the probability is within [0, 1].
+func WithSecondChance(age time.Duration, probability float64) Option {
+	if probability < 0 || probability > 1 {
+		panic(fmt.Sprintf("second chance probability %v is out of [0, 1]", probability))
+	}
+	return func(a *App) {
+		a.secondChance.age = age
+		a.secondChance.rate = probability
+	}
+}
+
+func newSecondChance() secondChance {
+	return secondChance{roll: hashRoll}
+}
+
+// hashRoll spreads seeds uniformly over [0, 1).
+func hashRoll(seed string) float64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(seed))
+	return float64(h.Sum64()>>11) / math.Exp2(53)
+}
+
+// addSecondChance appends a second chance to the page of matches, replacing the last match of a full page
+// so that the page keeps its size. Whether there is one and which one it is depends on the user and the day
+// only, so that repeated calls return the same matches. Failing to get one leaves the page as it is.
+func (a *App) addSecondChance(ctx context.Context, uuid string, count int64, matches []*models.Profile) []*models.Profile {
+	if a.secondChance.age <= 0 || a.secondChance.rate <= 0 {
+		return matches
+	}
+	now := a.now()
+	seed := feedSeed(uuid, now)
+	if a.secondChance.roll(seed) >= a.secondChance.rate {
+		return matches
+	}
+	profile, err := a.store.GetSecondChance(ctx, uuid, seed, now.Add(-a.secondChance.age), now,
+		now.Add(-a.rematchCooldown), a.strategy.SoftFilters())
+	if err != nil {
+		logging.FromContext(ctx, a.log).Warnf("err getting second chance for %s: %v", uuid, err)
+		return matches
+	}
+	if profile == nil {
+		return matches
+	}
+	profile.SecondChance = true
+	if int64(len(matches)) >= count && len(matches) > 0 {
+		matches = matches[:len(matches)-1]
+	}
+	return append(matches, profile)
+}
@@ -0,0 +1,58 @@
+//go:build testseed
+
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/internal/storage"
+)
+
+// Deterministic seed profiles for client integration tests. Only compiled with the testseed build tag.
+const (
+	SeedUUID1 = "00000000-0000-4000-8000-000000000001"
+	SeedUUID2 = "00000000-0000-4000-8000-000000000002"
+	SeedUUID3 = "00000000-0000-4000-8000-000000000003"
+)
+
+// Seed stores three profiles sharing a region: the first two like each other (a mutual match)
+// and the third likes the second.
+func (a *App) Seed(ctx context.Context) error {
+	profiles := []struct {
+		uuid     string
+		username string
+		gender   models.Gender
+		age      int8
+	}{
+		{SeedUUID1, "seed-alice", models.Female, 25},
+		{SeedUUID2, "seed-bob", models.Male, 27},
+		{SeedUUID3, "seed-carol", models.Female, 24},
+	}
+	for _, p := range profiles {
+		cfg := models.Config{
+			Personal: &models.Personal{Username: p.username, Gender: p.gender, Age: p.age},
+			Criteria: &models.SearchCriteria{
+				Regions:    []int64{1},
+				PriceRange: models.NewRange(20000, 50000),
+				AgeRange:   models.NewRange(18, 40),
+			},
+		}
+		cfg.SetUUID(p.uuid)
+		if err := a.store.SaveConfig(ctx, &cfg); err != nil {
+			return fmt.Errorf("err seeding config for %s: %w", p.uuid, err)
+		}
+	}
+	relations := []models.Relation{
+		{UUID: SeedUUID1, Target: SeedUUID2, Relation: int8(storage.Liked)},
+		{UUID: SeedUUID2, Target: SeedUUID1, Relation: int8(storage.SuperLiked)},
+		{UUID: SeedUUID3, Target: SeedUUID2, Relation: int8(storage.Liked)},
+	}
+	for i := range relations {
+		if err := a.store.UpsertRelation(ctx, &relations[i]); err != nil {
+			return fmt.Errorf("err seeding relation: %w", err)
+		}
+	}
+	return nil
+}
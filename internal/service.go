@@ -4,70 +4,1136 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gerladeno/homie-core/pkg/chat"
 
 	"github.com/gerladeno/homie-core/internal/models"
 	"github.com/gerladeno/homie-core/internal/storage"
 	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/gerladeno/homie-core/pkg/logging"
+	"github.com/gerladeno/homie-core/pkg/metrics"
+	"github.com/gerladeno/homie-core/pkg/textfilter"
 	"github.com/sirupsen/logrus"
 )
 
 type Storage interface {
 	SaveConfig(ctx context.Context, config *models.Config) error
+	UpdateConfig(ctx context.Context, uuid string, fn func(*models.Config) (*models.Config, error)) error
 	GetConfig(ctx context.Context, uuid string) (*models.Config, error)
 	GetRegions(ctx context.Context) ([]*models.Region, error)
+	UpsertRegion(ctx context.Context, region *models.Region, now time.Time) error
+	DeleteMessagesBefore(ctx context.Context, before time.Time, limit int) (int64, error)
+	StartBoost(ctx context.Context, uuid string, boost *models.Boost, expiredBefore time.Time) (bool, error)
+	GetBoost(ctx context.Context, uuid string) (*models.Boost, error)
+	CountActiveBoosts(ctx context.Context, at time.Time) (int64, error)
+	RepairMatchCounts(ctx context.Context, after string, limit int) (string, int64, error)
+	DeleteOrphanedMatchCounts(ctx context.Context, limit int) (int64, error)
+	DeleteUnmatchedChats(ctx context.Context, limit int) (int64, error)
 	UpsertRelation(ctx context.Context, relation *models.Relation) error
-	ListRelated(ctx context.Context, uuid string, relation storage.Relation, limit, offset int64) ([]*models.Profile, error)
-	ListMatches(ctx context.Context, uuid string, count int64) ([]*models.Profile, error)
+	UpsertLike(ctx context.Context, relation *models.Relation, maxMatches int64) error
+	CountLike(ctx context.Context, uuid, target string, at time.Time) error
+	GetRegionStats(ctx context.Context, since, now time.Time) ([]*models.RegionStats, error)
+	GetUserTimeseries(ctx context.Context, uuid string, from, to time.Time) ([]*models.DayBucket, error)
+	GetRelation(ctx context.Context, uuid, target string) (storage.Relation, error)
+	CountActiveMatches(ctx context.Context, uuid string) (int64, error)
+	IsActiveMatch(ctx context.Context, uuid, target string) (bool, error)
+	GetRelationStates(ctx context.Context, uuid string, targets []string) (map[string]storage.RelationState, error)
+	GetConnectionRegions(ctx context.Context, uuid, target string, minConnections int) ([]int64, error)
+	IsHidden(ctx context.Context, uuid, target string) (bool, error)
+	CountPendingLikes(ctx context.Context, uuid string, since time.Time) (int64, error)
+	ArchiveMatch(ctx context.Context, uuid, target string) error
+	GetMatch(ctx context.Context, uuid, target string) (*models.Match, error)
+	ListActiveMatches(ctx context.Context, uuid string, limit, offset int64) ([]*models.MatchWithState, error)
+	Hide(ctx context.Context, uuid, target string) error
+	Unhide(ctx context.Context, uuid, target string) error
+	DeleteDislike(ctx context.Context, uuid, target string) error
+	ResetDecisions(ctx context.Context, uuid string) (int64, error)
+	GetChat(ctx context.Context, uuid1, uuid2 string) error
+	GetSettings(ctx context.Context, uuid string) (*models.Settings, error)
+	SaveSettings(ctx context.Context, settings *models.Settings) error
+	SaveMessageReport(ctx context.Context, report *models.MessageReport, peer string) error
+	ListMessageReports(ctx context.Context) ([]*models.MessageReport, error)
+	ListRelated(ctx context.Context, uuid string, relation storage.Relation, period models.TimeRange, limit, offset int64) ([]*models.Profile, error)        //nolint:lll
+	GetSecondChance(ctx context.Context, uuid, seed string, dislikedBefore, now, unmatchedSince time.Time, soft models.SoftFilters) (*models.Profile, error) //nolint:lll
+	RecordProfileView(ctx context.Context, viewer, target string, at time.Time, window time.Duration) error
+	ListProfileViewers(ctx context.Context, uuid string, now time.Time, limit int64) ([]*models.ProfileView, error)
+	DeleteProfileViewsBefore(ctx context.Context, before time.Time, limit int) (int64, error)
+	DeferMatchNotifications(ctx context.Context, uuid string, targets []string, deliverAt time.Time) error
+	TakeDeferredNotifications(ctx context.Context, uuid string) ([]string, error)
+	ListDeferredNotifications(ctx context.Context) (map[string]time.Time, error)
+	ListIncomingLikes(ctx context.Context, uuid string, now time.Time, after models.LikesCursor, limit int64) ([]*models.IncomingLike, error)                                       //nolint:lll
+	ListDecisions(ctx context.Context, uuid string, relations []storage.Relation, period models.TimeRange, limit, offset, countCap int64) ([]*models.Decision, models.Total, error) //nolint:lll
+	ListMatches(ctx context.Context, uuid string, q models.MatchQuery) ([]*models.Profile, error)
+	CountCandidates(ctx context.Context, uuid string, now, unmatchedSince, joinedSince time.Time, soft models.SoftFilters, countCap int64) (models.Total, error) //nolint:lll
+	StreamMatches(ctx context.Context, uuid string, q models.MatchQuery, fn func(*models.Profile) error) error
+	SaveUnmatch(ctx context.Context, uuid, target string, at time.Time) error
 	GetProfiles(ctx context.Context, uuids []string) ([]*models.Profile, error)
+	GetPersonal(ctx context.Context, uuid string) (*models.Personal, error)
+	GetTravel(ctx context.Context, uuid string) (*models.Travel, error)
+	AddPhoto(ctx context.Context, uuid, link string, limit int, replaceOldest bool) (*models.Photo, error)
+	DeletePhoto(ctx context.Context, uuid string, id int64) error
+	ListPhotos(ctx context.Context, uuid string) ([]*models.Photo, error)
+	ReorderPhotos(ctx context.Context, uuid string, ids []int64) error
+	SavePhotoUpload(ctx context.Context, uuid, key string, expiresAt time.Time) error
+	ConfirmPhotoUpload(ctx context.Context, uuid, key, link string, expiredBefore time.Time, limit int, replaceOldest bool) (*models.Photo, error) //nolint:lll
+	DeleteOrphanPhotoUploads(ctx context.Context, before time.Time, limit int, fn func(keys []string) error) (int, error)
+	DeletePhotoUploadsBefore(ctx context.Context, before time.Time, limit int) (int64, error)
+	SaveBan(ctx context.Context, ban *models.Ban) error
+	DeleteBan(ctx context.Context, kind models.BanKind, value string) error
+	ListBans(ctx context.Context) ([]*models.Ban, error)
+	SaveRevokedToken(ctx context.Context, token *models.RevokedToken) error
+	ListRevokedTokens(ctx context.Context, now time.Time) ([]string, error)
+	IsTokenRevoked(ctx context.Context, jti string, now time.Time) (bool, error)
+	DeleteExpiredRevokedTokens(ctx context.Context, now time.Time) (int64, error)
+	SaveAuditEntry(ctx context.Context, entry *models.AuditEntry) error
+	CountUnread(ctx context.Context, uuid string) (int64, error)
+	GetUnreadCounts(ctx context.Context, uuid string) (map[string]int64, error)
+	GetReadState(ctx context.Context, uuid1, uuid2 string) (unread, lastRead int64, err error)
+	SaveDevice(ctx context.Context, uuid string, device *models.Device) error
+	DeleteDevice(ctx context.Context, uuid string, id int64) error
 }
 
 type Chat interface {
-	GetDialog(ctx context.Context, client, target string) *chat.Hub
-	GetAllChats(ctx context.Context, uuid string) ([]string, error)
+	GetDialog(ctx context.Context, client, target string) (*chat.Hub, error)
+	GetAllChats(ctx context.Context, uuid string, includeArchived bool, limit, offset int64) ([]string, error)
+	ArchiveChat(ctx context.Context, uuid, target string, archived bool) error
+	SnoozeChat(ctx context.Context, uuid, peer string, until time.Time) error
+	RetractMessage(ctx context.Context, sender, receiver string, id int64) error
+	EditMessage(ctx context.Context, sender, receiver string, id int64, body string) (*chat.Message, error)
+	ReactToMessage(ctx context.Context, uuid, peer string, id int64, emoji string) (*chat.Reaction, error)
+	CheckReaction(emoji string) error
+	RemoveReaction(ctx context.Context, uuid, peer string, id int64) error
+	ExportMessages(ctx context.Context, uuid, target string, fn func(*chat.Message) error) error
+	MarkAllRead(ctx context.Context, uuid string) (int, error)
+	ResolveConversation(ctx context.Context, uuid, id string) (string, error)
+	CloseDialog(uuid1, uuid2 string, code int, reason string)
+	SetBlocked(uuid, target string, blocked bool)
+	ServeStream(uuid string, peers []string, hooks chat.StreamHooks, w http.ResponseWriter, r *http.Request)
+	Stats() chat.Stats
 }
 
+const (
+	defaultMinAge            = 18
+	defaultMaxRegions        = 20
+	defaultMaxBudget         = 1e8
+	defaultMaxPhotos         = 6
+	defaultMaxBioLength      = 1000
+	defaultDistancePrecision = 1.0
+	purgeBatchSize           = 1000
+	// defaultLikesPageSize and maxLikesPageSize keep pages of incoming likes small however many there are.
+	defaultLikesPageSize    = 20
+	maxLikesPageSize        = 100
+	defaultBoostCooldown    = 24 * time.Hour
+	defaultMaxBoostDuration = time.Hour
+	defaultRegionsTTL       = time.Hour
+	// maxSnooze is the longest a chat may be snoozed for.
+	maxSnooze      = 30 * 24 * time.Hour
+	defaultBansTTL = time.Minute
+	// defaultRevocationsTTL is short as revoked tokens are usually stolen ones.
+	defaultRevocationsTTL = 10 * time.Second
+	// defaultNewUsersWindow is how recently users joined to be in the feed of new users only.
+	defaultNewUsersWindow = 7 * 24 * time.Hour
+)
+
+// defaultBioLanguages are the languages bios may be translated to unless overridden with WithBioLanguages.
+var defaultBioLanguages = []string{"en", "ru"}
+
+// Subsystems skipped by reads returning partial results with common.DegradedError.
+const (
+	SkippedProfiles  = "profiles"
+	SkippedDistances = "distances"
+	// SkippedUnreadCounts lists chats without unread_count.
+	SkippedUnreadCounts = "unread_counts"
+)
+
 type App struct {
 	log        *logrus.Entry
 	store      Storage
 	chatServer Chat
+	minAge     int
+	maxMatches int64
+	// maxRegions limits the number of regions of search criteria, zero means unlimited.
+	maxRegions int
+	// maxBudget is the ceiling of budgets of search criteria, zero means unlimited.
+	maxBudget float64
+	// maxPhotos limits the number of photos of a user, zero means unlimited.
+	maxPhotos int
+	// maxBioLength limits the number of characters of bios, zero means unlimited.
+	maxBioLength int
+	username     usernamePolicy
+	// distancePrecision is a step in km distances to other users are rounded to.
+	distancePrecision float64
+	// publicFields is an allowlist of profile fields shown to other users.
+	publicFields map[string]bool
+	// titleCaseFields are free-text fields title-cased on saves.
+	titleCaseFields map[string]bool
+	textFilter      textfilter.Filter
+	now             func() time.Time
+	ids             common.IDGenerator
+	feedSort        models.FeedSort
+	strategy        MatchStrategy
+	// messageRetention is the age chat messages are purged at, non-positive keeps them forever.
+	messageRetention time.Duration
+	// boostCooldown is the time after a boost expires before the next one may be started.
+	boostCooldown    time.Duration
+	maxBoostDuration time.Duration
+	// maxPendingLikes limits likes not reciprocated within pendingLikesWindow.
+	maxPendingLikes    int64
+	pendingLikesWindow time.Duration
+	// regionsTTL is how long the region list is cached for, zero disables caching.
+	regionsTTL time.Duration
+	regions    regionCache
+	bansTTL    time.Duration
+	bans       ttlCache[map[banKey]bool]
+	// revocationsTTL is how long jti claims of revoked tokens are cached for, zero disables caching.
+	revocationsTTL time.Duration
+	revocations    ttlCache[map[string]bool]
+	// matchOnlyChats allows chats between users with an active match only.
+	matchOnlyChats bool
+	// photoRequired keeps users without photos from browsing candidates.
+	photoRequired bool
+	// rematchCooldown keeps a pair out of each other's matches after unmatching or hiding, zero disables it.
+	rematchCooldown time.Duration
+	// newUsersWindow is how recently candidates joined to be in the feed of new users only.
+	newUsersWindow time.Duration
+	// bioLanguages are the language codes bios may be translated to.
+	bioLanguages map[string]bool
+	// superLikesFirst puts users who super liked the user at the front of their feed.
+	superLikesFirst bool
+	// shuffleFeed shuffles best sorted candidates of equal score with a seed changing daily.
+	shuffleFeed bool
+	// countCap makes list totals past it estimated instead of counted exactly, zero always counts exactly.
+	countCap int64
+	events   *metrics.Events
+	// swipes challenges users liking and disliking faster than humans do.
+	swipes swipeTracker
+	// edits rejects config saves coming too soon after the previous one.
+	edits editTracker
+	// blobs takes photos uploaded directly with URLs valid for uploadTTL, photos are links only if it's nil.
+	blobs     BlobStore
+	uploadTTL time.Duration
+	// notifier is sent match notifications coalesced by matchNotifications, none are sent if it's nil.
+	notifier           Notifier
+	matchNotifications matchBatcher
+	// quietMatches times delivery of match notifications stored for users in quiet hours.
+	quietMatches matchBatcher
+	// snapshots pin candidates of feed paging sessions.
+	snapshots feedSnapshots
+	// diversity breaks runs of similar candidates in matches.
+	diversity feedDiversity
+	// fairness down-ranks candidates shown widely in matches, nil keeps the ranked order.
+	fairness *feedFairness
+	// scoringWorkers score candidates of matches in parallel.
+	scoringWorkers int
+	// secondChance re-surfaces profiles disliked long ago in matches.
+	secondChance secondChance
+	// photoURLTTL is how long signed links of photos of the blob store are valid for, zero shows permanent ones.
+	photoURLTTL time.Duration
+	// viewsWindow is how long repeated views of a profile by the same viewer count as one.
+	viewsWindow time.Duration
+	// viewsRetention is the age views of profiles are purged at, non-positive keeps them forever.
+	viewsRetention time.Duration
+}
+
+type Option func(*App)
+
+// WithMinAge sets the minimum age derived from birthdate a user must have to use matching.
+func WithMinAge(age int) Option {
+	return func(a *App) {
+		a.minAge = age
+	}
+}
+
+// WithMaxActiveMatches limits the number of active matches a user can have. Zero means unlimited.
+func WithMaxActiveMatches(count int64) Option {
+	return func(a *App) {
+		a.maxMatches = count
+	}
+}
+
+// WithMaxRegions limits the number of regions a user can search in. Zero means unlimited.
+func WithMaxRegions(count int) Option {
+	return func(a *App) {
+		a.maxRegions = count
+	}
+}
+
+// WithMaxBioLength limits the number of characters of the bio and of each of its translations, 1000 by default.
+// Zero means unlimited. Stored longer bios are kept.
+func WithMaxBioLength(length int) Option {
+	return func(a *App) {
+		a.maxBioLength = length
+	}
+}
+
+// WithMaxBudget sets the ceiling of budgets users search with, 1e8 by default. Zero means unlimited.
+func WithMaxBudget(budget float64) Option {
+	return func(a *App) {
+		a.maxBudget = budget
+	}
+}
+
+// WithMaxPhotos limits the number of photos a user can upload, 6 by default. Zero means unlimited.
+func WithMaxPhotos(count int) Option {
+	return func(a *App) {
+		a.maxPhotos = count
+	}
+}
+
+// WithDistancePrecision sets a step in km distances to other users are rounded to for privacy.
+func WithDistancePrecision(km float64) Option {
+	return func(a *App) {
+		if km > 0 {
+			a.distancePrecision = km
+		}
+	}
+}
+
+// WithPublicFields sets profile fields shown to other users, see models.DefaultPublicFields.
+func WithPublicFields(fields []string) Option {
+	return func(a *App) {
+		a.publicFields = makeSet(fields)
+	}
+}
+
+// WithTitleCaseFields title-cases the username or bio on saves, which are only trimmed by default.
+func WithTitleCaseFields(fields []string) Option {
+	return func(a *App) {
+		a.titleCaseFields = makeSet(fields)
+	}
+}
+
+// WithTextFilter sets a filter applied to free-text config fields, which are not filtered by default.
+func WithTextFilter(filter textfilter.Filter) Option {
+	return func(a *App) {
+		a.textFilter = filter
+	}
+}
+
+// WithDefaultFeedSort sets the order of matches used when a client doesn't ask for one.
+func WithDefaultFeedSort(sort models.FeedSort) Option {
+	return func(a *App) {
+		if sort.Valid() {
+			a.feedSort = sort
+		}
+	}
+}
+
+// WithMatchStrategy sets how search criteria and ranking combine in matches, strict by default.
+func WithMatchStrategy(strategy MatchStrategy) Option {
+	return func(a *App) {
+		a.strategy = strategy
+	}
+}
+
+// WithSuperLikesFirst puts users who super liked someone at the front of their feed whatever its sort.
+func WithSuperLikesFirst(enabled bool) Option {
+	return func(a *App) {
+		a.superLikesFirst = enabled
+	}
+}
+
+// WithFeedShuffle makes the best sorted feed vary per user and day by shuffling candidates
+// within the same boost and score tier.
+func WithFeedShuffle(enabled bool) Option {
+	return func(a *App) {
+		a.shuffleFeed = enabled
+	}
+}
+
+// WithCountCap makes list totals above the cap estimated instead of counting every item.
+func WithCountCap(countCap int64) Option {
+	return func(a *App) {
+		a.countCap = countCap
+	}
+}
+
+// WithEventMetrics sets business event metrics, unregistered ones are used by default.
+func WithEventMetrics(m *metrics.Events) Option {
+	return func(a *App) {
+		a.events = m
+	}
+}
+
+// WithMessageRetention makes RunMessageRetention purge chat messages older than the age. Non-positive age
+// keeps them forever.
+func WithMessageRetention(age time.Duration) Option {
+	return func(a *App) {
+		a.messageRetention = age
+	}
+}
+
+// WithPendingLikesLimit limits the number of likes which aren't reciprocated. Likes older than the window
+// aren't counted, zero count means unlimited and zero window means likes never expire.
+func WithPendingLikesLimit(count int64, window time.Duration) Option {
+	return func(a *App) {
+		a.maxPendingLikes = count
+		a.pendingLikesWindow = window
+	}
+}
+
+// WithMaxBoostDuration sets the longest boost, an hour by default.
+func WithMaxBoostDuration(maxDuration time.Duration) Option {
+	return func(a *App) {
+		a.maxBoostDuration = maxDuration
+	}
+}
+
+// WithBoostCooldown sets the time after a boost expires before the next one may be started, a day by default.
+func WithBoostCooldown(cooldown time.Duration) Option {
+	return func(a *App) {
+		a.boostCooldown = cooldown
+	}
+}
+
+// WithRegionsTTL sets how long the region list is cached for, zero disables caching.
+func WithRegionsTTL(ttl time.Duration) Option {
+	return func(a *App) {
+		a.regionsTTL = ttl
+	}
+}
+
+// WithBansTTL sets how long banned identities are cached for, zero disables caching.
+func WithBansTTL(ttl time.Duration) Option {
+	return func(a *App) {
+		a.bansTTL = ttl
+	}
+}
+
+// WithRevocationsTTL sets how long revoked tokens are cached for, zero disables caching.
+func WithRevocationsTTL(ttl time.Duration) Option {
+	return func(a *App) {
+		a.revocationsTTL = ttl
+	}
+}
+
+// WithMatchOnlyChats allows users to chat only while they have an active match, open messaging otherwise.
+func WithMatchOnlyChats(matchOnly bool) Option {
+	return func(a *App) {
+		a.matchOnlyChats = matchOnly
+	}
+}
+
+// WithPhotoRequired keeps users from browsing candidates until they have uploaded a photo, feeds fail
+// with ErrPhotoRequired before that.
+func WithPhotoRequired(required bool) Option {
+	return func(a *App) {
+		a.photoRequired = required
+	}
+}
+
+// WithRematchCooldown keeps users out of each other's matches for the cooldown after one of them
+// unmatched or hid the other.
+func WithRematchCooldown(cooldown time.Duration) Option {
+	return func(a *App) {
+		a.rematchCooldown = cooldown
+	}
+}
+
+// WithNewUsersWindow sets how recently candidates have to join to be in the feed of new users only.
+func WithNewUsersWindow(window time.Duration) Option {
+	return func(a *App) {
+		a.newUsersWindow = window
+	}
+}
+
+// WithBioLanguages sets the lower-case language codes bios may be translated to.
+func WithBioLanguages(languages []string) Option {
+	return func(a *App) {
+		a.bioLanguages = makeSet(languages)
+	}
+}
+
+// WithClock replaces time.Now as the source of current time.
+func WithClock(now func() time.Time) Option {
+	return func(a *App) {
+		a.now = now
+	}
+}
+
+// WithIDGenerator replaces random ids of photo keys and feed snapshot tokens.
+func WithIDGenerator(ids common.IDGenerator) Option {
+	return func(a *App) {
+		a.ids = ids
+	}
 }
 
-func NewApp(log *logrus.Logger, store Storage, chatServer Chat) *App {
-	return &App{
-		log:        log.WithField("module", "app"),
-		store:      store,
-		chatServer: chatServer,
+func makeSet(elems []string) map[string]bool {
+	set := make(map[string]bool, len(elems))
+	for _, elem := range elems {
+		set[elem] = true
 	}
+	return set
 }
 
-func (a *App) GetDialog(ctx context.Context, client, target string) *chat.Hub {
+func NewApp(log *logrus.Logger, store Storage, chatServer Chat, opts ...Option) *App {
+	a := &App{
+		log:          log.WithField("module", "app"),
+		store:        store,
+		chatServer:   chatServer,
+		minAge:       defaultMinAge,
+		maxRegions:   defaultMaxRegions,
+		maxBudget:    defaultMaxBudget,
+		maxBioLength: defaultMaxBioLength,
+		maxPhotos:    defaultMaxPhotos,
+		username:     usernamePolicy{maxLength: defaultMaxUsernameLength, nfc: true},
+
+		distancePrecision: defaultDistancePrecision,
+		publicFields:      makeSet(models.DefaultPublicFields),
+		textFilter:        textfilter.Nop{},
+		now:               time.Now,
+		ids:               common.RandomIDs{},
+		feedSort:          models.FeedSortBest,
+		strategy:          strictStrategy{},
+		boostCooldown:     defaultBoostCooldown,
+		maxBoostDuration:  defaultMaxBoostDuration,
+		regionsTTL:        defaultRegionsTTL,
+		bansTTL:           defaultBansTTL,
+		revocationsTTL:    defaultRevocationsTTL,
+		newUsersWindow:    defaultNewUsersWindow,
+		viewsWindow:       defaultViewsWindow,
+		viewsRetention:    defaultViewsRetention,
+		bioLanguages:      makeSet(defaultBioLanguages),
+		events:            metrics.NewEvents(),
+		scoringWorkers:    runtime.GOMAXPROCS(0),
+		secondChance:      newSecondChance(),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// GetDialog returns the dialog of the users, ErrNotMatched if chats are match only and they have no active match,
+// ErrChatBlocked if either of them has hidden the other. Users can't chat with themselves.
+func (a *App) GetDialog(ctx context.Context, client, target string) (*chat.Hub, error) {
+	if client == target {
+		return nil, common.ErrSelfChat
+	}
+	for _, pair := range [][2]string{{client, target}, {target, client}} {
+		hidden, err := a.store.IsHidden(ctx, pair[0], pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("err checking blocks: %w", err)
+		}
+		if hidden {
+			return nil, common.ErrChatBlocked
+		}
+	}
+	if a.matchOnlyChats {
+		matched, err := a.store.IsActiveMatch(ctx, client, target)
+		if err != nil {
+			return nil, fmt.Errorf("err checking match: %w", err)
+		}
+		if !matched {
+			return nil, common.ErrNotMatched
+		}
+	}
 	return a.chatServer.GetDialog(ctx, client, target)
 }
 
-func (a *App) GetAllChats(ctx context.Context, uuid string) ([]*models.Profile, error) {
-	uuids, err := a.chatServer.GetAllChats(ctx, uuid)
+// GetAllChats returns profiles the user chatted with, most recently messaged first. Zero limit means no limit.
+func (a *App) GetAllChats(ctx context.Context, uuid string, includeArchived bool, limit, offset int64) ([]*models.Profile, error) { //nolint:lll
+	uuids, err := a.chatServer.GetAllChats(ctx, uuid, includeArchived, limit, offset)
 	if err != nil {
 		return nil, fmt.Errorf("err getting list of uuids client chatted with: %w", err)
 	}
+	var degraded common.DegradedError
 	profiles, err := a.store.GetProfiles(ctx, uuids)
 	if err != nil {
-		return nil, fmt.Errorf("err getting list of profiles client chatted with: %w", err)
+		// chats are still listed without profile details
+		profiles = make([]*models.Profile, 0, len(uuids))
+		for _, peer := range uuids {
+			profiles = append(profiles, &models.Profile{UUID: peer})
+		}
+		degraded.Skipped, degraded.Err = append(degraded.Skipped, SkippedProfiles), err
+	}
+	a.project(profiles)
+	for _, p := range profiles {
+		p.ConversationID = chat.ConversationID(uuid, p.UUID)
+	}
+	// counts of every chat are taken at once rather than per listed chat
+	counts, err := a.GetUnreadCounts(ctx, uuid)
+	if err != nil {
+		degraded.Skipped, degraded.Err = append(degraded.Skipped, SkippedUnreadCounts), err
+	} else {
+		for _, p := range profiles {
+			count := counts[p.UUID]
+			p.UnreadCount = &count
+		}
+	}
+	if degraded.Err != nil {
+		return profiles, &degraded
 	}
 	return profiles, nil
 }
 
-func (a *App) SaveConfig(ctx context.Context, config *models.Config) error {
+// GetUnreadCounts returns counts of unread messages of the user's chats by peer, chats read up are left out.
+// The counts are those MarkAllChatsRead and replies reset.
+func (a *App) GetUnreadCounts(ctx context.Context, uuid string) (map[string]int64, error) {
+	counts, err := a.store.GetUnreadCounts(ctx, uuid)
+	if err != nil {
+		return nil, fmt.Errorf("err getting unread counts: %w", err)
+	}
+	return counts, nil
+}
+
+func (a *App) ChatStats() chat.Stats {
+	return a.chatServer.Stats()
+}
+
+// ResolveConversation returns the peer of the user in the conversation with the id.
+func (a *App) ResolveConversation(ctx context.Context, uuid, id string) (string, error) {
+	peer, err := a.chatServer.ResolveConversation(ctx, uuid, id)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrChatNotFound):
+		return "", common.ErrChatNotFound
+	default:
+		return "", fmt.Errorf("err resolving conversation: %w", err)
+	}
+	return peer, nil
+}
+
+func (a *App) ArchiveChat(ctx context.Context, uuid, targetUUID string, archived bool) error {
+	err := a.chatServer.ArchiveChat(ctx, uuid, targetUUID, archived)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrChatNotFound):
+		return common.ErrChatNotFound
+	default:
+		return fmt.Errorf("err archiving chat: %w", err)
+	}
+	return nil
+}
+
+// SnoozeChat holds messages of the target to the user until the time and hides the chat from their list,
+// a zero time ends the snooze.
+func (a *App) SnoozeChat(ctx context.Context, uuid, targetUUID string, until time.Time) error {
+	if now := a.now(); !until.IsZero() && (!until.After(now) || until.Sub(now) > maxSnooze) {
+		return fmt.Errorf("%w: until must be within %s from now", common.ErrInvalidSnooze, maxSnooze)
+	}
+	err := a.chatServer.SnoozeChat(ctx, uuid, targetUUID, until)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrChatNotFound):
+		return common.ErrChatNotFound
+	default:
+		return fmt.Errorf("err snoozing chat: %w", err)
+	}
+	return nil
+}
+
+// StartBoost raises the user in matches of others for the duration. Only one boost may be active
+// and a new one is available after a cooldown.
+func (a *App) StartBoost(ctx context.Context, uuid string, duration time.Duration) error {
+	if duration <= 0 || duration > a.maxBoostDuration {
+		return common.ErrInvalidBoostDuration
+	}
+	now := a.now()
+	boost := &models.Boost{StartedAt: common.NewTimestamp(now), ExpiresAt: common.NewTimestamp(now.Add(duration))}
+	started, err := a.store.StartBoost(ctx, uuid, boost, now.Add(-a.boostCooldown))
+	if err != nil {
+		return fmt.Errorf("err starting boost: %w", err)
+	}
+	if started {
+		return nil
+	}
+	last, err := a.store.GetBoost(ctx, uuid)
+	if err != nil {
+		return fmt.Errorf("err getting boost: %w", err)
+	}
+	if last != nil && last.ExpiresAt.After(now) {
+		return common.ErrBoostActive
+	}
+	return common.ErrBoostCooldown
+}
+
+// GetBoostStatus returns the last boost of the user. While it's active the status tells the time remaining
+// and the position bucket, which is skipped if active boosts fail to be counted.
+func (a *App) GetBoostStatus(ctx context.Context, uuid string) (*models.BoostStatus, error) {
+	boost, err := a.store.GetBoost(ctx, uuid)
+	if err != nil {
+		return nil, fmt.Errorf("err getting boost: %w", err)
+	}
+	status := models.BoostStatus{Boost: boost}
+	if boost == nil {
+		return &status, nil
+	}
+	now := a.now()
+	status.Active = boost.ExpiresAt.After(now)
+	if status.Active {
+		status.RemainingSeconds = int64(math.Ceil(boost.ExpiresAt.Sub(now).Seconds()))
+		count, err := a.store.CountActiveBoosts(ctx, now)
+		if err != nil {
+			logging.FromContext(ctx, a.log).Warnf("err estimating boost position of %s: %v", uuid, err)
+		} else {
+			status.PositionBucket = positionBucket(count)
+		}
+	}
+	if availableAt := boost.ExpiresAt.Add(a.boostCooldown); availableAt.After(now) {
+		status.AvailableAt = common.NewTimestampPtr(&availableAt)
+	}
+	return &status, nil
+}
+
+// positionBucket rounds the number of boosted candidates up to a power of ten, 10 at least.
+func positionBucket(count int64) int64 {
+	bucket := int64(10)
+	for bucket < count && bucket < math.MaxInt64/10 {
+		bucket *= 10
+	}
+	return bucket
+}
+
+// RunMessageRetention purges expired chat messages every interval until the context is done.
+func (a *App) RunMessageRetention(ctx context.Context, interval time.Duration) {
+	if a.messageRetention <= 0 {
+		return
+	}
+	a.runEvery(ctx, interval, "message retention", a.PurgeExpiredMessages)
+}
+
+// runEvery runs the named background job right away and then every interval until the context is done,
+// logging its errors and the count of items it removed.
+func (a *App) runEvery(ctx context.Context, interval time.Duration, name string, fn func(context.Context) (int64, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		count, err := fn(ctx)
+		switch {
+		case err != nil:
+			a.log.Warnf("err running %s: %v", name, err)
+		case count > 0:
+			a.log.Infof("%s removed %d", name, count)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeExpiredMessages deletes chat messages older than the retention age in batches and returns their count.
+func (a *App) PurgeExpiredMessages(ctx context.Context) (int64, error) {
+	if a.messageRetention <= 0 {
+		return 0, nil
+	}
+	before := a.now().Add(-a.messageRetention)
+	var total int64
+	for {
+		count, err := a.store.DeleteMessagesBefore(ctx, before, purgeBatchSize)
+		if err != nil {
+			return total, fmt.Errorf("err purging messages: %w", err)
+		}
+		total += count
+		if count < purgeBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// RepairMatches fixes match state left inconsistent by bugs or crashes in batches: it recounts active matches
+// of every user, deletes counts of users who no longer exist and, with match only chats, chats of users
+// who don't like each other anymore. It is safe to run repeatedly, changes made meanwhile are repaired
+// by the next run.
+func (a *App) RepairMatches(ctx context.Context) (*models.MatchRepair, error) {
+	var repair models.MatchRepair
+	for after := ""; ; {
+		last, fixed, err := a.store.RepairMatchCounts(ctx, after, purgeBatchSize)
+		if err != nil {
+			return &repair, fmt.Errorf("err repairing matches: %w", err)
+		}
+		repair.FixedCounts += fixed
+		if last == "" {
+			break
+		}
+		after = last
+	}
+	var err error
+	if repair.OrphanedCounts, err = deleteInBatches(ctx, a.store.DeleteOrphanedMatchCounts); err != nil {
+		return &repair, fmt.Errorf("err repairing matches: %w", err)
+	}
+	if a.matchOnlyChats {
+		if repair.UnmatchedChats, err = deleteInBatches(ctx, a.store.DeleteUnmatchedChats); err != nil {
+			return &repair, fmt.Errorf("err repairing matches: %w", err)
+		}
+	}
+	return &repair, nil
+}
+
+// deleteInBatches deletes with del batch after batch until one falls short and returns the total deleted.
+func deleteInBatches(ctx context.Context, del func(ctx context.Context, limit int) (int64, error)) (int64, error) {
+	var total int64
+	for {
+		count, err := del(ctx, purgeBatchSize)
+		if err != nil {
+			return total, err
+		}
+		total += count
+		if count < purgeBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// MarkAllChatsRead marks every chat of the user read and returns the number of chats affected.
+func (a *App) MarkAllChatsRead(ctx context.Context, uuid string) (int, error) {
+	count, err := a.chatServer.MarkAllRead(ctx, uuid)
+	if err != nil {
+		return 0, fmt.Errorf("err marking all chats read: %w", err)
+	}
+	return count, nil
+}
+
+// GetLimits returns limits of the service, counts of matches asked for are capped by the caller.
+func (a *App) GetLimits() models.Limits {
+	return models.Limits{
+		MinAge:           a.minAge,
+		MaxActiveMatches: a.maxMatches,
+		MaxPendingLikes:  a.maxPendingLikes,
+		MaxRegions:       a.maxRegions,
+		MaxBudget:        a.maxBudget,
+		MaxPhotos:        a.maxPhotos,
+	}
+}
+
+// CountMatches returns the number of active matches of the user, the same count the match limit applies to.
+func (a *App) CountMatches(ctx context.Context, uuid string) (int64, error) {
+	count, err := a.store.CountActiveMatches(ctx, uuid)
+	if err != nil {
+		return 0, fmt.Errorf("err counting matches: %w", err)
+	}
+	return count, nil
+}
+
+// GetTotalUnread returns the number of unread messages across all chats of the user.
+func (a *App) GetTotalUnread(ctx context.Context, uuid string) (int64, error) {
+	count, err := a.store.CountUnread(ctx, uuid)
+	if err != nil {
+		return 0, fmt.Errorf("err getting total unread: %w", err)
+	}
+	return count, nil
+}
+
+func (a *App) RetractMessage(ctx context.Context, uuid, targetUUID string, id int64) error {
+	err := a.chatServer.RetractMessage(ctx, uuid, targetUUID, id)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrMessageNotFound):
+		return common.ErrMessageNotFound
+	default:
+		return fmt.Errorf("err retracting message: %w", err)
+	}
+	return nil
+}
+
+// EditMessage replaces the body of a message the user sent to the target and returns the edited message.
+func (a *App) EditMessage(ctx context.Context, uuid, targetUUID string, id int64, body string) (*chat.Message, error) {
+	m, err := a.chatServer.EditMessage(ctx, uuid, targetUUID, id, body)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrMessageNotFound), errors.Is(err, common.ErrNotMessageSender),
+		errors.Is(err, common.ErrEditWindowExpired), errors.Is(err, common.ErrInvalidMessage):
+		return nil, err
+	default:
+		return nil, fmt.Errorf("err editing message: %w", err)
+	}
+	return m, nil
+}
+
+// ExportChat calls fn for every message of the user's dialog with the target, oldest first.
+func (a *App) ExportChat(ctx context.Context, uuid, targetUUID string, fn func(*chat.Message) error) error {
+	err := a.chatServer.ExportMessages(ctx, uuid, targetUUID, fn)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrChatNotFound):
+		return common.ErrNotParticipant
+	default:
+		return fmt.Errorf("err exporting chat: %w", err)
+	}
+	return nil
+}
+
+// SaveConfig saves the config and returns non-blocking warnings about it. Saves and patches sooner than
+// the edit cooldown after the previous one fail with ErrEditingTooFast.
+func (a *App) SaveConfig(ctx context.Context, config *models.Config) ([]models.Warning, error) {
+	undo, err := a.reserveEdit(config.UUID)
+	if err != nil {
+		return nil, err
+	}
+	stored := &storedPersonal{load: func() (*models.Personal, error) { return a.store.GetPersonal(ctx, config.UUID) }}
+	flagged, err := a.prepareConfig(ctx, config, stored)
+	if err != nil {
+		undo()
+		return nil, err
+	}
+	if err = a.store.SaveConfig(ctx, config); err != nil {
+		undo()
+		return nil, fmt.Errorf("err saving config: %w", err)
+	}
+	return configWarnings(config, flagged), nil
+}
+
+// PatchConfig applies a JSON merge patch to the user's config and saves the result atomically.
+// Only the sections present in the patch are validated and saved.
+func (a *App) PatchConfig(ctx context.Context, uuid string, patch []byte) ([]models.Warning, error) {
+	undo, err := a.reserveEdit(uuid)
+	if err != nil {
+		return nil, err
+	}
+	var warnings []models.Warning
+	err = a.store.UpdateConfig(ctx, uuid, func(current *models.Config) (*models.Config, error) {
+		config, err := current.Patch(patch)
+		if err != nil {
+			return nil, err
+		}
+		config.SetUUID(uuid)
+		stored := &storedPersonal{load: func() (*models.Personal, error) { return current.Personal, nil }}
+		flagged, err := a.prepareConfig(ctx, config, stored)
+		if err != nil {
+			return nil, err
+		}
+		warnings = configWarnings(config, flagged)
+		return config, nil
+	})
+	if err != nil {
+		undo()
+		return nil, fmt.Errorf("err patching config: %w", err)
+	}
+	return warnings, nil
+}
+
+// storedPersonal loads the stored personal of the user once it's needed, saves keep stored values breaking
+// limits tightened since they were saved.
+type storedPersonal struct {
+	load     func() (*models.Personal, error)
+	personal *models.Personal
+	loaded   bool
+}
+
+// get returns the stored personal, nil if there is none.
+func (s *storedPersonal) get() (*models.Personal, error) {
+	if s.loaded {
+		return s.personal, nil
+	}
+	personal, err := s.load()
+	if err != nil && !errors.Is(err, common.ErrConfigNotFound) {
+		return nil, fmt.Errorf("err getting stored personal: %w", err)
+	}
+	s.personal, s.loaded = personal, true
+	return personal, nil
+}
+
+// prepareConfig validates the config and fills in derived fields before saving.
+// It returns free-text fields flagged by the text filter.
+func (a *App) prepareConfig(ctx context.Context, config *models.Config, stored *storedPersonal) ([]string, error) {
 	if config.Personal != nil && config.Personal.Gender == models.Any {
-		return common.ErrGenderNotSpecified
+		return nil, common.ErrGenderNotSpecified
+	}
+	if config.Personal != nil && !config.Personal.Gender.Valid() {
+		return nil, fmt.Errorf("%w: %d", common.ErrInvalidGender, config.Personal.Gender)
+	}
+	if config.Criteria != nil && !config.Criteria.Gender.Valid() {
+		return nil, fmt.Errorf("%w: %d looked for", common.ErrInvalidGender, config.Criteria.Gender)
+	}
+	if config.Personal != nil && config.Personal.Birthdate != nil {
+		age := config.Personal.Birthdate.AgeAt(a.now())
+		switch {
+		case age < 0 || age > math.MaxInt8:
+			return nil, common.ErrInvalidBirthdate
+		case age < a.minAge:
+			return nil, common.ErrUnderage
+		}
+		config.Personal.Age = int8(age)
+	}
+	if config.Personal != nil {
+		config.Personal.Normalize(a.titleCaseFields)
+		if err := a.checkUsername(config.Personal, stored); err != nil {
+			return nil, err
+		}
+		if err := a.checkBios(config.Personal, stored); err != nil {
+			return nil, err
+		}
+	}
+	if config.Criteria != nil {
+		if err := a.checkRegions(ctx, config.Criteria); err != nil {
+			return nil, err
+		}
+		if err := config.Criteria.NormalizeRegionWeights(); err != nil {
+			return nil, err
+		}
+		if err := a.checkBudget(config.Criteria.PriceRange); err != nil {
+			return nil, err
+		}
+	}
+	flagged, err := a.filterText(config.Personal)
+	if err != nil {
+		return nil, err
+	}
+	if !config.Paused(a.now()) {
+		config.PauseUntil = nil
+	}
+	if config.Travel != nil {
+		if err = config.Travel.Validate(); err != nil {
+			return nil, err
+		}
+		if !config.Travel.Active(a.now()) {
+			config.Travel = nil
+		}
+	}
+	return flagged, nil
+}
+
+// checkRegions dedupes regions of the criteria and validates their number and that every one of them exists.
+func (a *App) checkRegions(ctx context.Context, criteria *models.SearchCriteria) error {
+	criteria.DedupeRegions()
+	if a.maxRegions > 0 && len(criteria.Regions) > a.maxRegions {
+		return fmt.Errorf("%w: %d, at most %d allowed", common.ErrTooManyRegions, len(criteria.Regions), a.maxRegions)
+	}
+	if len(criteria.Regions) == 0 {
+		return nil
+	}
+	regions, err := a.GetRegions(ctx)
+	if err != nil {
+		return err
+	}
+	known := make(map[int64]bool, len(regions))
+	for _, region := range regions {
+		known[region.ID] = true
+	}
+	for _, region := range criteria.Regions {
+		if !known[region] {
+			return fmt.Errorf("%w: no region %d", common.ErrInvalidRegion, region)
+		}
+	}
+	return nil
+}
+
+// fieldPriceRange names the budget of search criteria in errors.
+const fieldPriceRange = "price_range"
+
+// checkBudget validates that bounds of the budget are non-negative, ordered and within the ceiling,
+// the matcher relies on it to tell whether budgets of users overlap.
+func (a *App) checkBudget(budget models.Range) error {
+	var err error
+	for _, bound := range []*float64{budget.From, budget.To} {
+		switch {
+		case bound == nil:
+		case *bound < 0:
+			err = fmt.Errorf("%w: %v is negative", common.ErrInvalidBudget, *bound)
+		case a.maxBudget > 0 && *bound > a.maxBudget:
+			err = fmt.Errorf("%w: %v, at most %v allowed", common.ErrInvalidBudget, *bound, a.maxBudget)
+		}
+		if err != nil {
+			return &common.FieldError{Field: fieldPriceRange, Err: err}
+		}
+	}
+	if budget.From != nil && budget.To != nil && *budget.From > *budget.To {
+		err = fmt.Errorf("%w: from %v is more than to %v", common.ErrInvalidBudget, *budget.From, *budget.To)
+		return &common.FieldError{Field: fieldPriceRange, Err: err}
+	}
+	return nil
+}
+
+// fieldBios names translated bios in errors and warnings.
+const fieldBios = "bios"
+
+// checkBios validates languages of the translated bios, which are allowed along with a default bio only,
+// and their length. Stored bios are kept whatever their length, so that lowering the limit doesn't break
+// saves of other fields.
+func (a *App) checkBios(personal *models.Personal, stored *storedPersonal) error {
+	tooLong, err := a.bioTooLong(personal.Bio, stored, func(p *models.Personal) string { return p.Bio })
+	if err != nil {
+		return err
 	}
-	if err := a.store.SaveConfig(ctx, config); err != nil {
-		return fmt.Errorf("err saving config: %w", err)
+	if tooLong {
+		return &common.FieldError{Field: models.FieldBio, Err: a.bioLengthError(personal.Bio)}
+	}
+	if len(personal.Bios) == 0 {
+		return nil
+	}
+	if personal.Bio == "" {
+		return &common.FieldError{Field: fieldBios, Err: common.ErrMissingDefaultBio}
+	}
+	for lang := range personal.Bios {
+		if !a.bioLanguages[lang] {
+			return &common.FieldError{Field: fieldBios, Err: fmt.Errorf("%w %q", common.ErrUnsupportedLanguage, lang)}
+		}
+		bio := personal.Bios[lang]
+		tooLong, err = a.bioTooLong(bio, stored, func(p *models.Personal) string { return p.Bios[lang] })
+		if err != nil {
+			return err
+		}
+		if tooLong {
+			return &common.FieldError{Field: fieldBios, Err: fmt.Errorf("%w in %q", a.bioLengthError(bio), lang)}
+		}
 	}
 	return nil
 }
 
+// bioTooLong reports whether the bio is over the limit and isn't the one of the stored personal.
+func (a *App) bioTooLong(bio string, stored *storedPersonal, storedBio func(*models.Personal) string) (bool, error) {
+	if a.maxBioLength <= 0 || utf8.RuneCountInString(bio) <= a.maxBioLength {
+		return false, nil
+	}
+	previous, err := stored.get()
+	if err != nil {
+		return false, err
+	}
+	return previous == nil || storedBio(previous) != bio, nil
+}
+
+func (a *App) bioLengthError(bio string) error {
+	return fmt.Errorf("%w: %d characters, at most %d allowed", common.ErrBioTooLong,
+		utf8.RuneCountInString(bio), a.maxBioLength)
+}
+
+func configWarnings(config *models.Config, flagged []string) []models.Warning {
+	warnings := config.Warnings()
+	for _, field := range flagged {
+		warnings = append(warnings, models.Warning{
+			Code:    models.WarningFlaggedText,
+			Message: field + " looks like spam and may be hidden",
+		})
+	}
+	return warnings
+}
+
+// filterText returns free-text fields flagged by the text filter or an error for the first rejected one.
+func (a *App) filterText(personal *models.Personal) ([]string, error) {
+	if personal == nil {
+		return nil, nil
+	}
+	var flagged []string
+	fields := []struct{ name, text string }{
+		{models.FieldUsername, personal.Username},
+		{models.FieldBio, personal.Bio},
+	}
+	for _, bio := range personal.Bios {
+		fields = append(fields, struct{ name, text string }{fieldBios, bio})
+	}
+	for _, field := range fields {
+		switch a.textFilter.Check(field.text) {
+		case textfilter.Rejected:
+			return nil, &common.FieldError{Field: field.name, Err: common.ErrRejectedContent}
+		case textfilter.Flagged:
+			// translations are flagged once
+			if len(flagged) == 0 || flagged[len(flagged)-1] != field.name {
+				flagged = append(flagged, field.name)
+			}
+		case textfilter.Clean:
+		}
+	}
+	return flagged, nil
+}
+
 func (a *App) GetConfig(ctx context.Context, uuid string) (*models.Config, error) {
 	result, err := a.store.GetConfig(ctx, uuid)
 	switch {
@@ -76,21 +1142,173 @@ func (a *App) GetConfig(ctx context.Context, uuid string) (*models.Config, error
 		return nil, common.ErrConfigNotFound
 	default:
 		err = fmt.Errorf("err getting config: %w", err)
-		a.log.Debug(err)
+		logging.FromContext(ctx, a.log).Debug(err)
 		return nil, err
 	}
+	// an expired pause or travel is left in storage and ignored until the next save
+	if !result.Paused(a.now()) {
+		result.PauseUntil = nil
+	}
+	if !result.Travel.Active(a.now()) {
+		result.Travel = nil
+	}
+	if result.Personal != nil {
+		result.Personal.Photos = a.signPhotos(result.Personal.Photos)
+	}
 	return result, nil
 }
 
+// AddPhoto appends the photo to the user's ones, ErrPhotoLimitReached if they have maxPhotos already.
+// With replaceOldest the oldest uploaded photos other than the primary are replaced instead.
+func (a *App) AddPhoto(ctx context.Context, uuid, link string, replaceOldest bool) (*models.Photo, error) {
+	photo, err := a.store.AddPhoto(ctx, uuid, link, a.maxPhotos, replaceOldest)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrPhotoLimitReached):
+		return nil, err
+	default:
+		return nil, fmt.Errorf("err adding photo: %w", err)
+	}
+	return photo, nil
+}
+
+// DeletePhoto deletes the user's photo, which frees a slot for another one.
+func (a *App) DeletePhoto(ctx context.Context, uuid string, id int64) error {
+	err := a.store.DeletePhoto(ctx, uuid, id)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrPhotoNotFound):
+		return err
+	default:
+		return fmt.Errorf("err deleting photo: %w", err)
+	}
+	return nil
+}
+
+func (a *App) ListPhotos(ctx context.Context, uuid string) ([]*models.Photo, error) {
+	photos, err := a.store.ListPhotos(ctx, uuid)
+	if err != nil {
+		return nil, fmt.Errorf("err listing photos: %w", err)
+	}
+	return a.signPhotos(photos), nil
+}
+
+// checkPhoto returns ErrPhotoRequired if photos are required to browse candidates and the user has none.
+func (a *App) checkPhoto(ctx context.Context, uuid string) error {
+	if !a.photoRequired {
+		return nil
+	}
+	photos, err := a.store.ListPhotos(ctx, uuid)
+	if err != nil {
+		return fmt.Errorf("err listing photos: %w", err)
+	}
+	if len(photos) == 0 {
+		return common.ErrPhotoRequired
+	}
+	return nil
+}
+
+func (a *App) ReorderPhotos(ctx context.Context, uuid string, ids []int64) error {
+	err := a.store.ReorderPhotos(ctx, uuid, ids)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrPhotoNotFound),
+		errors.Is(err, common.ErrForeignPhoto),
+		errors.Is(err, common.ErrInvalidPhotoOrder):
+		return err
+	default:
+		return fmt.Errorf("err reordering photos: %w", err)
+	}
+	return nil
+}
+
+// GetRegions returns the region list, cached for regionsTTL.
 func (a *App) GetRegions(ctx context.Context) ([]*models.Region, error) {
+	now := a.now()
+	if regions, ok := a.regions.get(now, a.regionsTTL); ok {
+		return regions, nil
+	}
 	result, err := a.store.GetRegions(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("err getting regions: %w", err)
 	}
+	a.regions.set(result, now)
 	return result, nil
 }
 
+// Warm loads the regions cache ahead of the first request needing it. Matching strategies keep no lookup
+// tables of their own, so regions are all there is to load.
+func (a *App) Warm(ctx context.Context) error {
+	if _, err := a.GetRegions(ctx); err != nil {
+		return fmt.Errorf("err warming up: %w", err)
+	}
+	return nil
+}
+
+// UpsertRegion adds the region if it has no id or updates the region of the id, the cached region list
+// is dropped either way.
+func (a *App) UpsertRegion(ctx context.Context, region *models.Region) error {
+	if err := region.Validate(); err != nil {
+		return err
+	}
+	err := a.store.UpsertRegion(ctx, region, a.now().UTC())
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrRegionNotFound):
+		return err
+	default:
+		return fmt.Errorf("err saving region: %w", err)
+	}
+	a.InvalidateRegions()
+	return nil
+}
+
+// InvalidateRegions makes the next GetRegions load regions from the store.
+func (a *App) InvalidateRegions() {
+	a.regions.set(nil, time.Time{})
+}
+
+// regionCache keeps the region list loaded at loadedAt, nil regions mean nothing is cached.
+type regionCache struct {
+	mx       sync.RWMutex
+	regions  []*models.Region
+	loadedAt time.Time
+}
+
+func (c *regionCache) get(now time.Time, ttl time.Duration) ([]*models.Region, bool) {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	if c.regions == nil || now.Sub(c.loadedAt) >= ttl {
+		return nil, false
+	}
+	return c.regions, true
+}
+
+func (c *regionCache) set(regions []*models.Region, loadedAt time.Time) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.regions, c.loadedAt = regions, loadedAt
+}
+
+// Like likes or super likes the target. A super like of someone liked upgrades the like, a regular like
+// of someone already liked or super liked changes nothing.
 func (a *App) Like(ctx context.Context, uuid, targetUUID string, super bool) error {
+	_, err := a.like(ctx, uuid, targetUUID, super, false)
+	return err
+}
+
+// LikeAndOpenChat likes the target as Like does and, if the like forms a match, opens their chat and returns
+// its conversation id, so that the user can connect right away. The chat opens before the like is saved,
+// so a failure to open it fails the like and there is no match without a chat. It returns an empty id
+// if no match forms.
+func (a *App) LikeAndOpenChat(ctx context.Context, uuid, targetUUID string, super bool) (string, error) {
+	return a.like(ctx, uuid, targetUUID, super, true)
+}
+
+func (a *App) like(ctx context.Context, uuid, targetUUID string, super, openChat bool) (string, error) {
+	if err := a.checkSwipeCadence(ctx, uuid); err != nil {
+		return "", err
+	}
 	relationType := storage.Liked
 	if super {
 		relationType = storage.SuperLiked
@@ -100,13 +1318,238 @@ func (a *App) Like(ctx context.Context, uuid, targetUUID string, super bool) err
 		Target:   targetUUID,
 		Relation: int8(relationType),
 	}
-	if err := a.store.UpsertRelation(ctx, &relation); err != nil {
-		return fmt.Errorf("err adding relation")
+	own, err := a.store.GetRelation(ctx, uuid, targetUUID)
+	if err != nil {
+		return "", fmt.Errorf("err getting relation: %w", err)
+	}
+	switch {
+	case own == storage.SuperLiked, own == storage.Liked && !super:
+		// liking again is a no-op, so is a regular like of someone super liked
+		return "", nil
+	case own == storage.Liked:
+		// a super like upgrades the like, which is neither a new like nor a match
+		if err = a.store.UpsertRelation(ctx, &relation); err != nil {
+			return "", fmt.Errorf("err upgrading relation: %w", err)
+		}
+		a.events.SuperLikes.Inc()
+		return "", nil
+	}
+	other, err := a.store.GetRelation(ctx, targetUUID, uuid)
+	if err != nil {
+		return "", fmt.Errorf("err getting relation: %w", err)
+	}
+	// liking back forms a match
+	matching := isLike(other)
+	if err = a.checkMatchLimit(ctx, uuid, targetUUID, matching); err != nil {
+		return "", err
+	}
+	if err = a.checkPendingLikesLimit(ctx, uuid, own, other); err != nil {
+		return "", err
+	}
+	var conversationID string
+	if matching && openChat {
+		hub, err := a.chatServer.GetDialog(ctx, uuid, targetUUID)
+		if err != nil {
+			return "", fmt.Errorf("err opening chat: %w", err)
+		}
+		conversationID = hub.ConversationID()
+	}
+	if err = a.store.UpsertLike(ctx, &relation, a.maxMatches); err != nil {
+		return "", fmt.Errorf("err adding relation: %w", err)
+	}
+	if super {
+		a.events.SuperLikes.Inc()
+	} else {
+		a.events.Likes.Inc()
+	}
+	if matching {
+		a.events.Matches.Inc()
+		a.notifyMatch(ctx, uuid, targetUUID)
+		a.notifyMatch(ctx, targetUUID, uuid)
+	}
+	// region stats are for analytics only, so failing to count the like doesn't fail it
+	if err := a.store.CountLike(ctx, uuid, targetUUID, a.now().UTC()); err != nil {
+		logging.FromContext(ctx, a.log).Warnf("err counting like for region stats: %v", err)
+	}
+	return conversationID, nil
+}
+
+// GetRegionStats returns per region the users currently searching there and their likes and matches since the day of since.
+func (a *App) GetRegionStats(ctx context.Context, since time.Time) ([]*models.RegionStats, error) {
+	stats, err := a.store.GetRegionStats(ctx, since.UTC(), a.now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("err getting region stats: %w", err)
+	}
+	return stats, nil
+}
+
+// checkMatchLimit returns ErrMatchLimitReached if the like forms a new match while the user or the target
+// already has the maximum number of active ones. It fails such likes before a chat opens for them,
+// UpsertLike checks the limit again along with saving the like, as matches may form meanwhile.
+func (a *App) checkMatchLimit(ctx context.Context, uuid, targetUUID string, matching bool) error {
+	if a.maxMatches == 0 || !matching {
+		return nil
+	}
+	for _, participant := range []string{uuid, targetUUID} {
+		count, err := a.store.CountActiveMatches(ctx, participant)
+		if err != nil {
+			return fmt.Errorf("err checking match limit: %w", err)
+		}
+		if count >= a.maxMatches {
+			return common.ErrMatchLimitReached
+		}
+	}
+	return nil
+}
+
+// checkPendingLikesLimit returns ErrPendingLikesLimit if liking the target would add one more
+// unreciprocated like while the user already has the maximum number of them, own and other
+// are the relations of the user to the target and back.
+func (a *App) checkPendingLikesLimit(ctx context.Context, uuid string, own, other storage.Relation) error {
+	if a.maxPendingLikes == 0 || isLike(own) || isLike(other) {
+		return nil
+	}
+	var since time.Time
+	if a.pendingLikesWindow != 0 {
+		since = a.now().UTC().Add(-a.pendingLikesWindow)
+	}
+	count, err := a.store.CountPendingLikes(ctx, uuid, since)
+	if err != nil {
+		return fmt.Errorf("err checking pending likes limit: %w", err)
+	}
+	if count >= a.maxPendingLikes {
+		return common.ErrPendingLikesLimit
+	}
+	return nil
+}
+
+// GetQuota returns what the user may still do before hitting the limits.
+func (a *App) GetQuota(ctx context.Context, uuid string) (*models.Quota, error) {
+	now := a.now()
+	var quota models.Quota
+	if a.maxPendingLikes != 0 {
+		var since time.Time
+		if a.pendingLikesWindow != 0 {
+			since = now.UTC().Add(-a.pendingLikesWindow)
+		}
+		count, err := a.store.CountPendingLikes(ctx, uuid, since)
+		if err != nil {
+			return nil, fmt.Errorf("err counting pending likes: %w", err)
+		}
+		remaining := a.maxPendingLikes - count
+		if remaining < 0 {
+			remaining = 0
+		}
+		quota.PendingLikesRemaining = &remaining
+	}
+	if until := a.swipes.coolingUntil(uuid, now); !until.IsZero() {
+		quota.SwipesAvailableAt = common.NewTimestampPtr(&until)
+	}
+	return &quota, nil
+}
+
+func isLike(relation storage.Relation) bool {
+	return relation == storage.Liked || relation == storage.SuperLiked
+}
+
+func (a *App) GetMatch(ctx context.Context, uuid, targetUUID string) (*models.Match, error) {
+	match, err := a.store.GetMatch(ctx, uuid, targetUUID)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrMatchNotFound):
+		return nil, common.ErrMatchNotFound
+	default:
+		return nil, fmt.Errorf("err getting match: %w", err)
 	}
+	if err = a.RecordProfileView(ctx, uuid, targetUUID); err != nil {
+		logging.FromContext(ctx, a.log).Warnf("err recording view of %s: %v", targetUUID, err)
+	}
+	a.projectProfile(match.Profile)
+	return match, nil
+}
+
+// GetRelationship returns the status of the user to the target. Hiding the target blocks them whatever
+// the likes, an active match outweighs the user's own decision.
+func (a *App) GetRelationship(ctx context.Context, uuid, targetUUID string) (*models.Relationship, error) {
+	states, err := a.store.GetRelationStates(ctx, uuid, []string{targetUUID})
+	if err != nil {
+		return nil, fmt.Errorf("err getting relationship: %w", err)
+	}
+	return relationshipOf(states, targetUUID), nil
+}
+
+func relationshipOf(states map[string]storage.RelationState, target string) *models.Relationship {
+	state, ok := states[target]
+	if !ok {
+		state = storage.RelationState{Target: target, Own: storage.Neither, Other: storage.Neither}
+	}
+	relationship := &models.Relationship{Status: models.RelationshipNone, LikedYou: isLike(state.Other)}
+	if state.Hidden {
+		relationship.Status = models.RelationshipBlocked
+		return relationship
+	}
+	if state.Matched {
+		relationship.Status = models.RelationshipMatched
+		return relationship
+	}
+	switch state.Own {
+	case storage.Liked:
+		relationship.Status = models.RelationshipLiked
+	case storage.SuperLiked:
+		relationship.Status = models.RelationshipSuperLiked
+	case storage.Disliked:
+		relationship.Status = models.RelationshipDisliked
+	case storage.Neither:
+	}
+	return relationship
+}
+
+// maxRelationshipTargets caps the targets of a single GetRelationships call.
+const maxRelationshipTargets = 100
+
+// GetRelationships returns the statuses of the user to the targets by their uuids as GetRelationship does.
+func (a *App) GetRelationships(ctx context.Context, uuid string, targets []string) (map[string]*models.Relationship, error) { //nolint:lll
+	unique := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		unique[target] = true
+		if len(unique) > maxRelationshipTargets {
+			return nil, fmt.Errorf("%w: at most %d", common.ErrTooManyTargets, maxRelationshipTargets)
+		}
+	}
+	result := make(map[string]*models.Relationship, len(unique))
+	if len(unique) == 0 {
+		return result, nil
+	}
+	states, err := a.store.GetRelationStates(ctx, uuid, targets)
+	if err != nil {
+		return nil, fmt.Errorf("err getting relationships: %w", err)
+	}
+	for target := range unique {
+		result[target] = relationshipOf(states, target)
+	}
+	return result, nil
+}
+
+func (a *App) ArchiveMatch(ctx context.Context, uuid, targetUUID string) error {
+	err := a.store.ArchiveMatch(ctx, uuid, targetUUID)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrMatchNotFound):
+		return common.ErrMatchNotFound
+	default:
+		return fmt.Errorf("err archiving match: %w", err)
+	}
+	if err = a.store.SaveUnmatch(ctx, uuid, targetUUID, a.now()); err != nil {
+		return fmt.Errorf("err archiving match: %w", err)
+	}
+	a.closeUnmatchedDialog(uuid, targetUUID)
 	return nil
 }
 
 func (a *App) Dislike(ctx context.Context, uuid, targetUUID string) error {
+	if err := a.checkSwipeCadence(ctx, uuid); err != nil {
+		return err
+	}
 	relationType := storage.Disliked
 	relation := models.Relation{
 		UUID:     uuid,
@@ -116,33 +1559,310 @@ func (a *App) Dislike(ctx context.Context, uuid, targetUUID string) error {
 	if err := a.store.UpsertRelation(ctx, &relation); err != nil {
 		return fmt.Errorf("err adding relation: %w", err)
 	}
+	a.events.Dislikes.Inc()
+	return nil
+}
+
+func (a *App) Hide(ctx context.Context, uuid, targetUUID string) error {
+	if err := a.store.Hide(ctx, uuid, targetUUID); err != nil {
+		return fmt.Errorf("err hiding profile: %w", err)
+	}
+	if err := a.store.SaveUnmatch(ctx, uuid, targetUUID, a.now()); err != nil {
+		return fmt.Errorf("err hiding profile: %w", err)
+	}
+	// the target can't go on messaging the user over a connection opened before
+	a.chatServer.SetBlocked(uuid, targetUUID, true)
+	a.closeUnmatchedDialog(uuid, targetUUID)
+	return nil
+}
+
+// closeUnmatchedDialog disconnects an open dialog of the users which may not chat anymore.
+func (a *App) closeUnmatchedDialog(uuid, targetUUID string) {
+	if a.matchOnlyChats {
+		a.chatServer.CloseDialog(uuid, targetUUID, chat.ClosePolicyViolation, chat.ReasonBlocked)
+	}
+}
+
+func (a *App) Unhide(ctx context.Context, uuid, targetUUID string) error {
+	if err := a.store.Unhide(ctx, uuid, targetUUID); err != nil {
+		return fmt.Errorf("err unhiding profile: %w", err)
+	}
+	a.chatServer.SetBlocked(uuid, targetUUID, false)
 	return nil
 }
 
-func (a *App) ListLikedProfiles(ctx context.Context, uuid string, limit, offset int64) ([]*models.Profile, error) {
-	liked, err := a.store.ListRelated(ctx, uuid, storage.Liked, limit, offset)
+// Reconsider removes the dislike of the target so that they are back in the user's feed, unless a rematch
+// cooldown keeps them out of it for a while. Unlike liking, it makes no decision.
+func (a *App) Reconsider(ctx context.Context, uuid, targetUUID string) error {
+	err := a.store.DeleteDislike(ctx, uuid, targetUUID)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrDislikeNotFound):
+		return common.ErrDislikeNotFound
+	default:
+		return fmt.Errorf("err reconsidering profile: %w", err)
+	}
+	return nil
+}
+
+// ResetDecisions removes likes and dislikes of the user so that everyone they swiped is back in their feed,
+// returning the number of decisions removed. Matches and their chats are kept, as are hidden profiles
+// and rematch cooldowns.
+func (a *App) ResetDecisions(ctx context.Context, uuid string) (int, error) {
+	count, err := a.store.ResetDecisions(ctx, uuid)
+	if err != nil {
+		return 0, fmt.Errorf("err resetting decisions: %w", err)
+	}
+	logging.FromContext(ctx, a.log).WithFields(logrus.Fields{"uuid": uuid, "decisions": count}).Info("decisions reset")
+	return int(count), nil
+}
+
+var decisionRelations = map[string][]storage.Relation{
+	"":                     {storage.Liked, storage.SuperLiked, storage.Disliked},
+	models.ActionLike:      {storage.Liked},
+	models.ActionSuperLike: {storage.SuperLiked},
+	models.ActionDislike:   {storage.Disliked},
+}
+
+// ListDecisions returns the user's likes and dislikes made within the period, most recent first, with their
+// total count. Empty action means any. The total is estimated past the count cap unless exact is set.
+func (a *App) ListDecisions(ctx context.Context, uuid, action string, period models.TimeRange, limit, offset int64, exact bool) ([]*models.Decision, models.Total, error) { //nolint:lll
+	relations, ok := decisionRelations[action]
+	if !ok {
+		return nil, models.Total{}, common.ErrInvalidAction
+	}
+	if !period.Valid() {
+		return nil, models.Total{}, common.ErrInvalidTimeRange
+	}
+	countCap := a.countCap
+	if exact {
+		countCap = 0
+	}
+	decisions, total, err := a.store.ListDecisions(ctx, uuid, relations, period, limit, offset, countCap)
+	if err != nil {
+		return nil, models.Total{}, fmt.Errorf("err getting list of decisions: %w", err)
+	}
+	for _, decision := range decisions {
+		if decision.Profile != nil {
+			a.projectProfile(decision.Profile)
+		}
+	}
+	return decisions, total, nil
+}
+
+// ListIncomingLikes returns a page of up to limit likes of the user after the cursor, most recent first,
+// and the cursor of the next page, empty on the last one. Zero limit means the default page size, larger
+// ones than the maximum are capped. Likes the user liked back, of hidden users and of paused ones are left out.
+func (a *App) ListIncomingLikes(ctx context.Context, uuid, cursor string, limit int64) ([]*models.IncomingLike, string, error) { //nolint:lll
+	after, err := models.ParseLikesCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	switch {
+	case limit <= 0:
+		limit = defaultLikesPageSize
+	case limit > maxLikesPageSize:
+		limit = maxLikesPageSize
+	}
+	likes, err := a.store.ListIncomingLikes(ctx, uuid, a.now(), after, limit)
+	if err != nil {
+		return nil, "", fmt.Errorf("err getting list of incoming likes: %w", err)
+	}
+	var next string
+	if int64(len(likes)) == limit {
+		last := likes[len(likes)-1]
+		next = models.LikesCursor{LikedAt: last.LikedAt.Time, UUID: last.UUID}.String()
+	}
+	for _, like := range likes {
+		if like.Profile != nil {
+			a.projectProfile(like.Profile)
+		}
+	}
+	return likes, next, nil
+}
+
+// ListLikedProfiles lists profiles the user liked within the period.
+func (a *App) ListLikedProfiles(ctx context.Context, uuid string, period models.TimeRange, limit, offset int64) ([]*models.Profile, error) { //nolint:lll
+	if !period.Valid() {
+		return nil, common.ErrInvalidTimeRange
+	}
+	liked, err := a.store.ListRelated(ctx, uuid, storage.Liked, period, limit, offset)
 	if err != nil {
 		return nil, fmt.Errorf("err getting list of liked: %w", err)
 	}
+	a.project(liked)
 	return liked, nil
 }
 
-func (a *App) ListDislikedProfiles(ctx context.Context, uuid string, limit, offset int64) ([]*models.Profile, error) {
-	disliked, err := a.store.ListRelated(ctx, uuid, storage.Disliked, limit, offset)
+// ListDislikedProfiles lists profiles the user disliked within the period.
+func (a *App) ListDislikedProfiles(ctx context.Context, uuid string, period models.TimeRange, limit, offset int64) ([]*models.Profile, error) { //nolint:lll
+	if !period.Valid() {
+		return nil, common.ErrInvalidTimeRange
+	}
+	disliked, err := a.store.ListRelated(ctx, uuid, storage.Disliked, period, limit, offset)
 	if err != nil {
 		return nil, fmt.Errorf("err getting list of disliked: %w", err)
 	}
+	a.project(disliked)
 	return disliked, nil
 }
 
-func (a *App) GetMatches(ctx context.Context, uuid string, count int64) ([]*models.Profile, error) {
-	matches, err := a.store.ListMatches(ctx, uuid, count)
+// GetMatches returns candidates for the user, empty sort means the default one. With newOnly there are
+// only candidates who joined within the new users window, otherwise the last one may be a second chance.
+// Calls return the same matches in the same order while the data doesn't change.
+func (a *App) GetMatches(ctx context.Context, uuid string, count int64, sort models.FeedSort, newOnly bool) ([]*models.Profile, error) { //nolint:lll
+	matches, err := a.listCandidates(ctx, uuid, count, sort, newOnly)
+	if err != nil {
+		return nil, err
+	}
+	now := a.now()
+	a.fairness.apply(matches, now)
+	a.diversity.apply(matches)
+	if !newOnly {
+		matches = a.addSecondChance(ctx, uuid, count, matches)
+	}
+	a.fairness.record(matches, now)
+	if err = a.scoreCandidates(ctx, uuid, matches); err != nil {
+		return matches, &common.DegradedError{Skipped: []string{SkippedDistances}, Err: err}
+	}
+	return matches, nil
+}
+
+// listCandidates returns up to count candidates for the user passing the feed filters, not yet scored
+// or projected. Empty sort means the default one, newOnly leaves candidates who joined within the new
+// users window.
+func (a *App) listCandidates(ctx context.Context, uuid string, count int64, sort models.FeedSort, newOnly bool) ([]*models.Profile, error) { //nolint:lll
+	if sort == "" {
+		sort = a.feedSort
+	}
+	if !sort.Valid() {
+		return nil, common.ErrInvalidFeedSort
+	}
+	if err := a.checkPhoto(ctx, uuid); err != nil {
+		return nil, err
+	}
+	now := a.now()
+	var seed string
+	if a.shuffleFeed {
+		seed = feedSeed(uuid, now)
+	}
+	var joinedSince time.Time
+	if newOnly {
+		joinedSince = now.Add(-a.newUsersWindow)
+	}
+	matches, err := a.store.ListMatches(ctx, uuid, a.matchQuery(count, now, joinedSince, sort, seed))
 	if err != nil {
 		return nil, fmt.Errorf("err getting list of matches: %w", err)
 	}
 	return matches, nil
 }
 
+// matchQuery returns the query of count candidates of the feed at the moment now in the sort order.
+func (a *App) matchQuery(count int64, now, joinedSince time.Time, sort models.FeedSort, seed string) models.MatchQuery {
+	return models.MatchQuery{
+		Count:           count,
+		Now:             now,
+		UnmatchedSince:  now.Add(-a.rematchCooldown),
+		JoinedSince:     joinedSince,
+		Sort:            sort,
+		Soft:            a.strategy.SoftFilters(),
+		SuperLikesFirst: a.superLikesFirst,
+		ShuffleSeed:     seed,
+	}
+}
+
+// CountCandidates returns the number of candidates in the user's feed, only those who joined recently if
+// newOnly. Totals past the count cap are estimated.
+func (a *App) CountCandidates(ctx context.Context, uuid string, newOnly bool) (models.Total, error) {
+	now := a.now()
+	var joinedSince time.Time
+	if newOnly {
+		joinedSince = now.Add(-a.newUsersWindow)
+	}
+	total, err := a.store.CountCandidates(ctx, uuid, now, now.Add(-a.rematchCooldown), joinedSince,
+		a.strategy.SoftFilters(), a.countCap)
+	if err != nil {
+		return models.Total{}, fmt.Errorf("err counting candidates: %w", err)
+	}
+	return total, nil
+}
+
+// feedSeed is stable for a user within a UTC day, so paging through the feed keeps its order.
+func feedSeed(uuid string, now time.Time) string {
+	return uuid + ":" + now.UTC().Format("2006-01-02")
+}
+
+// project strips fields not allowed to be shown to other users.
+func (a *App) project(profiles []*models.Profile) {
+	for _, p := range profiles {
+		a.projectProfile(p)
+	}
+}
+
+// StreamMatches calls fn for every candidate for the user as they are loaded, empty sort means the default one.
+// Unlike GetMatches it fails if distances can't be computed, as they can't be skipped after streaming started.
+func (a *App) StreamMatches(ctx context.Context, uuid string, count int64, sort models.FeedSort, fn func(*models.Profile) error) error { //nolint:lll
+	if sort == "" {
+		sort = a.feedSort
+	}
+	if !sort.Valid() {
+		return common.ErrInvalidFeedSort
+	}
+	if err := a.checkPhoto(ctx, uuid); err != nil {
+		return err
+	}
+	self, err := a.location(ctx, uuid)
+	if err != nil {
+		return fmt.Errorf("err streaming matches: %w", err)
+	}
+	now := a.now()
+	var seed string
+	if a.shuffleFeed {
+		seed = feedSeed(uuid, now)
+	}
+	err = a.store.StreamMatches(ctx, uuid, a.matchQuery(count, now, time.Time{}, sort, seed), func(p *models.Profile) error {
+		a.setDistance(self, p)
+		a.projectProfile(p)
+		return fn(p)
+	})
+	if err != nil {
+		return fmt.Errorf("err streaming matches: %w", err)
+	}
+	return nil
+}
+
+// location returns the user's personal with the location distances are computed from,
+// the travel one if it is active. It may have no location.
+func (a *App) location(ctx context.Context, uuid string) (*models.Personal, error) {
+	self, err := a.store.GetPersonal(ctx, uuid)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrConfigNotFound):
+	default:
+		return nil, fmt.Errorf("err getting user location: %w", err)
+	}
+	travel, err := a.store.GetTravel(ctx, uuid)
+	if err != nil {
+		return nil, fmt.Errorf("err getting user location: %w", err)
+	}
+	if travel.Active(a.now()) && travel.Latitude != nil {
+		self = &models.Personal{Latitude: travel.Latitude, Longitude: travel.Longitude}
+	}
+	return self, nil
+}
+
+func (a *App) setDistance(self *models.Personal, p *models.Profile) {
+	if self.HasLocation() && p.Personal.HasLocation() {
+		distance := math.Round(self.DistanceKm(p.Personal)/a.distancePrecision) * a.distancePrecision
+		p.DistanceKm = &distance
+	}
+}
+
 func (a *App) GetProfiles(ctx context.Context, uuids []string) ([]*models.Profile, error) {
-	return a.store.GetProfiles(ctx, uuids)
+	profiles, err := a.store.GetProfiles(ctx, uuids)
+	if err != nil {
+		return nil, fmt.Errorf("err getting profiles: %w", err)
+	}
+	a.project(profiles)
+	return profiles, nil
 }
@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+func (a *App) GetSettings(ctx context.Context, uuid string) (*models.Settings, error) {
+	settings, err := a.store.GetSettings(ctx, uuid)
+	if err != nil {
+		return nil, fmt.Errorf("err getting settings: %w", err)
+	}
+	return settings, nil
+}
+
+// SaveSettings replaces the user's settings, leaving the config untouched. The user needs a config first.
+func (a *App) SaveSettings(ctx context.Context, settings *models.Settings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	err := a.store.SaveSettings(ctx, settings)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrConfigNotFound):
+		return err
+	default:
+		return fmt.Errorf("err saving settings: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+// GetSharedAttributes returns what the user and the target have in common. It requires an active match,
+// or a chat of theirs unless chats are match only, ErrNotMatched otherwise.
+func (a *App) GetSharedAttributes(ctx context.Context, uuid, targetUUID string) (*models.SharedAttributes, error) {
+	if err := a.checkAcquainted(ctx, uuid, targetUUID); err != nil {
+		return nil, err
+	}
+	profiles, err := a.store.GetProfiles(ctx, []string{uuid, targetUUID})
+	if err != nil {
+		return nil, fmt.Errorf("err getting shared attributes: %w", err)
+	}
+	byUUID := make(map[string]*models.Profile, len(profiles))
+	for _, p := range profiles {
+		byUUID[p.UUID] = p
+	}
+	own, target := byUUID[uuid], byUUID[targetUUID]
+	if own == nil || target == nil {
+		return &models.SharedAttributes{Regions: []int64{}}, nil
+	}
+	return models.Shared(own, target), nil
+}
+
+// minConnections is the fewest common connections a region is hinted at for, so that a hint never points
+// at a single user.
+const minConnections = 2
+
+// GetCommonContext returns what the user and the target have in common which doesn't identify anyone else:
+// shared regions, regions of users both of them are matched with and when both are matched in the same
+// regions. It requires an active match or a chat as GetSharedAttributes does.
+func (a *App) GetCommonContext(ctx context.Context, uuid, targetUUID string) (*models.CommonContext, error) {
+	if err := a.checkAcquainted(ctx, uuid, targetUUID); err != nil {
+		return nil, err
+	}
+	configs := make([]*models.Config, 0, 2)
+	for _, u := range []string{uuid, targetUUID} {
+		config, err := a.store.GetConfig(ctx, u)
+		switch {
+		case err == nil:
+		case errors.Is(err, common.ErrConfigNotFound):
+			config = &models.Config{UUID: u}
+		default:
+			return nil, fmt.Errorf("err getting common context: %w", err)
+		}
+		configs = append(configs, config)
+	}
+	result := models.Common(configs[0], configs[1], a.now())
+	regions, err := a.store.GetConnectionRegions(ctx, uuid, targetUUID, minConnections)
+	if err != nil {
+		return nil, fmt.Errorf("err getting common context: %w", err)
+	}
+	result.ConnectionRegions = regions
+	return result, nil
+}
+
+// checkAcquainted returns nil if the users have an active match, or a chat unless chats are match only,
+// ErrNotMatched otherwise.
+func (a *App) checkAcquainted(ctx context.Context, uuid, targetUUID string) error {
+	matched, err := a.store.IsActiveMatch(ctx, uuid, targetUUID)
+	if err != nil {
+		return fmt.Errorf("err checking match: %w", err)
+	}
+	if matched {
+		return nil
+	}
+	if a.matchOnlyChats {
+		return common.ErrNotMatched
+	}
+	err = a.store.GetChat(ctx, uuid, targetUUID)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrChatNotFound):
+		return common.ErrNotMatched
+	default:
+		return fmt.Errorf("err checking chat: %w", err)
+	}
+	return nil
+}
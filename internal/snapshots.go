@@ -0,0 +1,219 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+const (
+	defaultFeedSnapshotSize = 200
+	// maxUserFeedSnapshots is the number of snapshots kept per user, taking another one drops their oldest.
+	maxUserFeedSnapshots = 3
+	// maxFeedSnapshots is the number of snapshots kept in total, past it the one expiring first is dropped.
+	maxFeedSnapshots = 10000
+)
+
+// WithFeedSnapshots lets clients page through up to size candidates pinned on the first page for the ttl,
+// so that swipes and new users don't shift pages. Zero ttl disables snapshots, non-positive size means 200.
+func WithFeedSnapshots(ttl time.Duration, size int64) Option {
+	return func(a *App) {
+		if size <= 0 {
+			size = defaultFeedSnapshotSize
+		}
+		a.snapshots.ttl = ttl
+		a.snapshots.size = size
+	}
+}
+
+// GetFeedPage returns count candidates for the user from the offset of the snapshot with the token.
+// An empty token takes a new snapshot returned along with its first page, empty sort means the default one
+// and newOnly filters candidates as in GetMatches.
+// ErrSnapshotExpired tells the client to restart paging with an empty token.
+func (a *App) GetFeedPage(ctx context.Context, uuid string, count int64, sort models.FeedSort, newOnly bool, token string, offset int64) ([]*models.Profile, string, error) { //nolint:lll
+	if token != "" {
+		entries, ok := a.snapshots.get(uuid, token, a.now())
+		if !ok {
+			return nil, "", common.ErrSnapshotExpired
+		}
+		profiles, err := a.loadSnapshotPage(ctx, uuid, page(entries, offset, count))
+		return profiles, token, err
+	}
+	if a.snapshots.ttl == 0 {
+		profiles, err := a.GetMatches(ctx, uuid, count, sort, newOnly)
+		return profiles, "", err
+	}
+	profiles, err := a.GetMatches(ctx, uuid, a.snapshots.size, sort, newOnly)
+	var degradedErr *common.DegradedError
+	if err != nil && !errors.As(err, &degradedErr) {
+		return nil, "", err
+	}
+	entries := make([]snapshotEntry, 0, len(profiles))
+	for _, p := range profiles {
+		entries = append(entries, snapshotEntry{uuid: p.UUID, superLikedYou: p.SuperLikedYou, secondChance: p.SecondChance})
+	}
+	token, tokenErr := a.snapshots.save(a.ids, uuid, entries, a.now())
+	if tokenErr != nil {
+		return nil, "", fmt.Errorf("err taking feed snapshot: %w", tokenErr)
+	}
+	if int64(len(profiles)) > count {
+		profiles = profiles[:count]
+	}
+	return profiles, token, err
+}
+
+// loadSnapshotPage loads and scores profiles of the page of a snapshot, those deleted since it was taken
+// are left out.
+func (a *App) loadSnapshotPage(ctx context.Context, uuid string, entries []snapshotEntry) ([]*models.Profile, error) {
+	if len(entries) == 0 {
+		return []*models.Profile{}, nil
+	}
+	uuids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		uuids = append(uuids, e.uuid)
+	}
+	profiles, err := a.store.GetProfiles(ctx, uuids)
+	if err != nil {
+		return nil, fmt.Errorf("err loading feed snapshot page: %w", err)
+	}
+	byUUID := make(map[string]snapshotEntry, len(entries))
+	for _, e := range entries {
+		byUUID[e.uuid] = e
+	}
+	for _, p := range profiles {
+		p.SuperLikedYou, p.SecondChance = byUUID[p.UUID].superLikedYou, byUUID[p.UUID].secondChance
+	}
+	if err = a.scoreCandidates(ctx, uuid, profiles); err != nil {
+		return profiles, &common.DegradedError{Skipped: []string{SkippedDistances}, Err: err}
+	}
+	return profiles, nil
+}
+
+// page returns count entries from the offset.
+func page(entries []snapshotEntry, offset, count int64) []snapshotEntry {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= int64(len(entries)) {
+		return nil
+	}
+	end := offset + count
+	if end > int64(len(entries)) {
+		end = int64(len(entries))
+	}
+	return entries[offset:end]
+}
+
+// feedSnapshots keeps candidates of paging sessions by their tokens until they expire,
+// up to maxUserFeedSnapshots per user and maxFeedSnapshots in total.
+type feedSnapshots struct {
+	ttl  time.Duration
+	size int64
+
+	mx        sync.Mutex
+	snapshots map[string]*feedSnapshot
+	// owned are tokens of snapshots of each user, oldest first.
+	owned map[string][]string
+	// sweptAt is when expired snapshots were last dropped.
+	sweptAt time.Time
+}
+
+type feedSnapshot struct {
+	owner     string
+	entries   []snapshotEntry
+	expiresAt time.Time
+}
+
+// snapshotEntry is a candidate of a snapshot, profiles are loaded as pages are fetched.
+type snapshotEntry struct {
+	uuid          string
+	superLikedYou bool
+	secondChance  bool
+}
+
+func (s *feedSnapshots) save(ids common.IDGenerator, uuid string, entries []snapshotEntry, now time.Time) (string, error) {
+	token, err := ids.NewID()
+	if err != nil {
+		return "", err
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.snapshots == nil {
+		s.snapshots = make(map[string]*feedSnapshot)
+		s.owned = make(map[string][]string)
+	}
+	s.forgetExpired(now)
+	for len(s.owned[uuid]) >= maxUserFeedSnapshots {
+		s.drop(s.owned[uuid][0])
+	}
+	if len(s.snapshots) >= maxFeedSnapshots {
+		s.sweptAt = time.Time{}
+		s.forgetExpired(now)
+	}
+	if len(s.snapshots) >= maxFeedSnapshots {
+		s.dropFirstExpiring()
+	}
+	s.snapshots[token] = &feedSnapshot{owner: uuid, entries: entries, expiresAt: now.Add(s.ttl)}
+	s.owned[uuid] = append(s.owned[uuid], token)
+	return token, nil
+}
+
+// get returns candidates of the user's snapshot, false if it has expired or belongs to another user.
+func (s *feedSnapshots) get(uuid, token string, now time.Time) ([]snapshotEntry, bool) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	snapshot, ok := s.snapshots[token]
+	if !ok || snapshot.owner != uuid || !now.Before(snapshot.expiresAt) {
+		return nil, false
+	}
+	return snapshot.entries, true
+}
+
+// forgetExpired drops expired snapshots at most once a ttl.
+func (s *feedSnapshots) forgetExpired(now time.Time) {
+	if now.Sub(s.sweptAt) < s.ttl {
+		return
+	}
+	s.sweptAt = now
+	for token, snapshot := range s.snapshots {
+		if !now.Before(snapshot.expiresAt) {
+			s.drop(token)
+		}
+	}
+}
+
+// dropFirstExpiring drops the snapshot expiring first.
+func (s *feedSnapshots) dropFirstExpiring() {
+	var first string
+	for token, snapshot := range s.snapshots {
+		if first == "" || snapshot.expiresAt.Before(s.snapshots[first].expiresAt) {
+			first = token
+		}
+	}
+	s.drop(first)
+}
+
+func (s *feedSnapshots) drop(token string) {
+	snapshot, ok := s.snapshots[token]
+	if !ok {
+		return
+	}
+	delete(s.snapshots, token)
+	owned := s.owned[snapshot.owner]
+	for i, t := range owned {
+		if t == token {
+			owned = append(owned[:i:i], owned[i+1:]...)
+			break
+		}
+	}
+	if len(owned) == 0 {
+		delete(s.owned, snapshot.owner)
+		return
+	}
+	s.owned[snapshot.owner] = owned
+}
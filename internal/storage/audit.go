@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gerladeno/homie-core/internal/models"
+)
+
+// SaveAuditEntry appends the entry to the audit log and sets its id. The log is append-only, the database
+// rejects updates and deletes of it.
+func (s *Storage) SaveAuditEntry(ctx context.Context, entry *models.AuditEntry) error {
+	query := `
+INSERT INTO audit_log (at, actor, action, subject, token_id, method, path, reason)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id`
+	err := s.db.QueryRow(ctx, query, entry.At, entry.Actor, entry.Action, entry.Subject, entry.TokenID,
+		entry.Method, entry.Path, entry.Reason).Scan(&entry.ID)
+	if err != nil {
+		return fmt.Errorf("err saving audit entry of %s by %s: %w", entry.Subject, entry.Actor, err)
+	}
+	return nil
+}
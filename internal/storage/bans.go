@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+// SaveBan adds the ban or updates the reason of an existing one.
+func (s *Storage) SaveBan(ctx context.Context, ban *models.Ban) error {
+	query := `
+INSERT INTO bans (kind, value, reason, created_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (kind, value) DO UPDATE SET reason = EXCLUDED.reason
+`
+	if _, err := s.db.Exec(ctx, query, ban.Kind, ban.Value, ban.Reason, ban.CreatedAt); err != nil {
+		return fmt.Errorf("err saving ban of %s %s: %w", ban.Kind, ban.Value, err)
+	}
+	return nil
+}
+
+func (s *Storage) DeleteBan(ctx context.Context, kind models.BanKind, value string) error {
+	res, err := s.db.Exec(ctx, `DELETE FROM bans WHERE kind = $1 AND value = $2`, kind, value)
+	if err != nil {
+		return fmt.Errorf("err deleting ban of %s %s: %w", kind, value, err)
+	}
+	if res.RowsAffected() == 0 {
+		return common.ErrBanNotFound
+	}
+	return nil
+}
+
+func (s *Storage) ListBans(ctx context.Context) ([]*models.Ban, error) {
+	var bans []*models.Ban
+	query := `SELECT kind, value, reason, created_at FROM bans ORDER BY created_at DESC, kind, value`
+	if err := pgxscan.Select(ctx, s.db, &bans, query); err != nil {
+		return nil, fmt.Errorf("err listing bans: %w", err)
+	}
+	return bans, nil
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/jackc/pgx/v4"
+)
+
+// StartBoost replaces the last boost of the user unless it expired after expiredBefore, false then.
+// The check is a part of the upsert, so that concurrent starts can't both succeed.
+func (s *Storage) StartBoost(ctx context.Context, uuid string, boost *models.Boost, expiredBefore time.Time) (bool, error) {
+	query := `
+INSERT INTO boosts (uuid, started_at, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (uuid) DO UPDATE SET started_at = EXCLUDED.started_at,
+                                 expires_at = EXCLUDED.expires_at
+WHERE boosts.expires_at <= $4
+`
+	res, err := s.db.Exec(ctx, query, uuid, boost.StartedAt, boost.ExpiresAt, expiredBefore.UTC())
+	if err != nil {
+		return false, fmt.Errorf("err starting boost for %s: %w", uuid, err)
+	}
+	return res.RowsAffected() > 0, nil
+}
+
+// GetBoost returns the last boost of the user or nil if there was none.
+func (s *Storage) GetBoost(ctx context.Context, uuid string) (*models.Boost, error) {
+	var boost models.Boost
+	err := s.db.QueryRow(ctx, `SELECT started_at, expires_at FROM boosts WHERE uuid = $1`, uuid).
+		Scan(&boost.StartedAt, &boost.ExpiresAt)
+	switch {
+	case err == nil:
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("err getting boost for %s: %w", uuid, err)
+	}
+	return &boost, nil
+}
+
+// CountActiveBoosts returns the number of boosts active at the time.
+func (s *Storage) CountActiveBoosts(ctx context.Context, at time.Time) (int64, error) {
+	var count int64
+	err := s.db.QueryRow(ctx, `SELECT count(*) FROM boosts WHERE started_at <= $1 AND expires_at > $1`, at).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("err counting active boosts: %w", err)
+	}
+	return count, nil
+}
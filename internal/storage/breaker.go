@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// querier is the part of the pool queries go through, so that it may be guarded by a breaker.
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+}
+
+// WithBreaker fails queries fast with common.ErrStoreUnavailable for the cooldown after threshold
+// consecutive failures of the datastore, then lets a single query through to probe it. A zero
+// threshold disables the breaker.
+func WithBreaker(threshold int, cooldown time.Duration) Option {
+	return func(s *Storage) {
+		if threshold <= 0 {
+			s.breaker = nil
+			return
+		}
+		s.breaker = &breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+	}
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerClosed:
+	}
+	return "closed"
+}
+
+// probeRetryAfter is the Retry-After of queries rejected while a probe is in flight.
+const probeRetryAfter = time.Second
+
+// breaker counts consecutive failures of the datastore, opens at the threshold and half-opens
+// after the cooldown to let a probe through. A successful probe closes it, a failed one opens it again.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+	// onChange is called with the new state under the lock on every transition.
+	onChange func(breakerState)
+
+	mx       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	// generation changes with every transition, so that results of queries let through
+	// in a previous state don't count.
+	generation int
+}
+
+// allow returns a func to report the result of a query made with ctx let through, an error if it must
+// fail fast.
+func (b *breaker) allow(ctx context.Context) (func(error), error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	switch b.state {
+	case breakerOpen:
+		wait := b.openedAt.Add(b.cooldown).Sub(b.now())
+		if wait > 0 {
+			return nil, &common.RetryError{After: wait, Err: common.ErrStoreUnavailable}
+		}
+		b.transition(breakerHalfOpen)
+	case breakerHalfOpen:
+		// a probe is in flight
+		return nil, &common.RetryError{After: probeRetryAfter, Err: common.ErrStoreUnavailable}
+	case breakerClosed:
+	}
+	generation := b.generation
+	return func(err error) {
+		b.done(generation, isStoreFailure(ctx, err))
+	}, nil
+}
+
+func (b *breaker) done(generation int, failed bool) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if generation != b.generation {
+		return
+	}
+	switch {
+	case !failed && b.state == breakerHalfOpen:
+		b.transition(breakerClosed)
+	case !failed:
+		b.failures = 0
+	case b.state == breakerHalfOpen:
+		b.transition(breakerOpen)
+	default:
+		if b.failures++; b.failures >= b.threshold {
+			b.transition(breakerOpen)
+		}
+	}
+}
+
+func (b *breaker) transition(state breakerState) {
+	b.state = state
+	b.failures = 0
+	b.generation++
+	if state == breakerOpen {
+		b.openedAt = b.now()
+	}
+	if b.onChange != nil {
+		b.onChange(state)
+	}
+}
+
+// isStoreFailure tells whether the error of a query made with ctx means the datastore is struggling rather
+// than that it answered the query with an error. Only connection level errors count: clients giving up or
+// running out of their deadline don't, neither do errors of scanning results, which are bugs of the query.
+func isStoreFailure(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// connection exceptions, insufficient resources and operator intervention
+		class := pgErr.Code
+		if len(class) > 2 {
+			class = class[:2]
+		}
+		return class == "08" || class == "53" || class == "57"
+	}
+	var retryable interface{ SafeToRetry() bool }
+	if errors.As(err, &retryable) && retryable.SafeToRetry() {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || pgconn.Timeout(err) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// breakerDB passes queries to the db unless the breaker is open.
+type breakerDB struct {
+	db      querier
+	breaker *breaker
+}
+
+func (d *breakerDB) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	done, err := d.breaker.allow(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := d.db.Exec(ctx, sql, args...)
+	done(err)
+	return tag, err
+}
+
+func (d *breakerDB) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	done, err := d.breaker.allow(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := d.db.Query(ctx, sql, args...)
+	if err != nil {
+		done(err)
+		return nil, err
+	}
+	return &breakerRows{Rows: rows, done: done}, nil
+}
+
+func (d *breakerDB) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	done, err := d.breaker.allow(ctx)
+	if err != nil {
+		return errRow{err: err}
+	}
+	return &breakerRow{row: d.db.QueryRow(ctx, sql, args...), done: done}
+}
+
+func (d *breakerDB) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	done, err := d.breaker.allow(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := d.db.BeginTx(ctx, txOptions)
+	done(err)
+	return tx, err
+}
+
+// breakerRows reports the result of a query once its rows are read or closed, as errors of reading
+// them only show up then.
+type breakerRows struct {
+	pgx.Rows
+	done     func(error)
+	reported bool
+}
+
+func (r *breakerRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.report()
+	return false
+}
+
+func (r *breakerRows) Close() {
+	r.Rows.Close()
+	r.report()
+}
+
+func (r *breakerRows) report() {
+	if !r.reported {
+		r.reported = true
+		r.done(r.Rows.Err())
+	}
+}
+
+type breakerRow struct {
+	row  pgx.Row
+	done func(error)
+}
+
+func (r *breakerRow) Scan(dest ...interface{}) error {
+	err := r.row.Scan(dest...)
+	r.done(err)
+	return err
+}
+
+// errRow is a row of a query which failed before it was sent.
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(...interface{}) error {
+	return r.err
+}
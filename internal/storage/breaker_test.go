@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyDB fails every Exec with err while it is set.
+type flakyDB struct {
+	querier
+	err   error
+	calls int
+}
+
+func (d *flakyDB) Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error) {
+	d.calls++
+	return nil, d.err
+}
+
+func TestBreaker(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	var states []breakerState
+	b := &breaker{threshold: 3, cooldown: 30 * time.Second, now: func() time.Time { return now },
+		onChange: func(state breakerState) { states = append(states, state) }}
+	db := &flakyDB{}
+	guarded := &breakerDB{db: db, breaker: b}
+	exec := func() error {
+		_, err := guarded.Exec(ctx, "SELECT 1")
+		return err
+	}
+
+	db.err = fmt.Errorf("err dialing: %w", context.DeadlineExceeded)
+	require.Error(t, exec())
+	require.Error(t, exec())
+	db.err = nil
+	require.NoError(t, exec(), "a success resets the count of failures")
+	db.err = fmt.Errorf("err dialing: %w", context.DeadlineExceeded)
+	for i := 0; i < 3; i++ {
+		require.ErrorIs(t, exec(), context.DeadlineExceeded)
+	}
+	require.Equal(t, []breakerState{breakerOpen}, states)
+
+	calls := db.calls
+	now = now.Add(10 * time.Second)
+	err := exec()
+	require.ErrorIs(t, err, common.ErrStoreUnavailable)
+	var retryErr *common.RetryError
+	require.ErrorAs(t, err, &retryErr)
+	require.Equal(t, 20*time.Second, retryErr.After)
+	require.Equal(t, calls, db.calls, "an open breaker fails fast")
+
+	now = now.Add(20 * time.Second)
+	require.ErrorIs(t, exec(), context.DeadlineExceeded, "a probe is let through after the cooldown")
+	require.Equal(t, []breakerState{breakerOpen, breakerHalfOpen, breakerOpen}, states)
+	require.ErrorIs(t, exec(), common.ErrStoreUnavailable, "a failed probe opens the breaker again")
+
+	now = now.Add(30 * time.Second)
+	db.err = nil
+	done, err := b.allow(ctx)
+	require.NoError(t, err)
+	require.ErrorIs(t, exec(), common.ErrStoreUnavailable, "one probe at a time")
+	done(nil)
+	require.Equal(t, []breakerState{breakerOpen, breakerHalfOpen, breakerOpen, breakerHalfOpen, breakerClosed}, states)
+	require.NoError(t, exec())
+}
+
+func TestBreakerIgnoresStaleResults(t *testing.T) {
+	now := time.Now()
+	b := &breaker{threshold: 1, cooldown: time.Second, now: func() time.Time { return now }}
+	ctx := context.Background()
+	stale, err := b.allow(ctx)
+	require.NoError(t, err)
+	failed, err := b.allow(ctx)
+	require.NoError(t, err)
+	failed(context.DeadlineExceeded)
+	require.Equal(t, breakerOpen, b.state)
+	stale(nil)
+	require.Equal(t, breakerOpen, b.state, "a query started before the breaker opened doesn't close it")
+}
+
+func TestIsStoreFailure(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	var count int
+	scanErr := pgtype.NewConnInfo().Scan(pgtype.Int4OID, pgtype.TextFormatCode, []byte("many"), &count)
+	require.Error(t, scanErr)
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{"success", context.Background(), nil, false},
+		{"no rows", context.Background(), fmt.Errorf("err getting config: %w", pgx.ErrNoRows), false},
+		{"client gone", canceled, context.Canceled, false},
+		{"caller deadline", expired, context.DeadlineExceeded, false},
+		{"store timeout", context.Background(), context.DeadlineExceeded, true},
+		{"connection", context.Background(), &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+		{"connection lost", context.Background(), io.ErrUnexpectedEOF, true},
+		{"scan", context.Background(), fmt.Errorf("can't scan into dest[0]: %w", scanErr), false},
+		{"constraint", context.Background(), &pgconn.PgError{Code: "23505"}, false},
+		{"too many connections", context.Background(), &pgconn.PgError{Code: "53300"}, true},
+		{"shutting down", context.Background(), &pgconn.PgError{Code: "57P01"}, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, isStoreFailure(tt.ctx, tt.err))
+		})
+	}
+}
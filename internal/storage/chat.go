@@ -0,0 +1,553 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/gerladeno/homie-core/pkg/chat"
+	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/jackc/pgx/v4"
+)
+
+// Every dialog is stored as two chat rows, one per participant with uuid1 being the owner,
+// so that per-user chat state like archiving doesn't affect the peer.
+
+// SaveChat creates the dialog of uuid1 and uuid2 unless it exists, the chat of uuid1 is marked opened
+// for EvictEmptyChats either way.
+func (s *Storage) SaveChat(ctx context.Context, uuid1, uuid2 string) error {
+	query := `
+INSERT INTO chat (uuid1, uuid2, opened_at, conversation_id)
+VALUES ($1, $2, now(), $3), ($2, $1, NULL, $3)
+ON CONFLICT (uuid1, uuid2) DO UPDATE SET opened_at = now()
+WHERE chat.uuid1 = $1
+`
+	if _, err := s.db.Exec(ctx, query, uuid1, uuid2, chat.ConversationID(uuid1, uuid2)); err != nil {
+		return fmt.Errorf("err inserting chat for %s and %s: %w", uuid1, uuid2, err)
+	}
+	return nil
+}
+
+func (s *Storage) GetChat(ctx context.Context, uuid1, uuid2 string) error {
+	var uuid string
+	err := s.db.QueryRow(ctx, `SELECT uuid2 FROM chat WHERE uuid1 = $1 AND uuid2 = $2`, uuid1, uuid2).Scan(&uuid)
+	switch {
+	case err == nil:
+	case errors.Is(err, pgx.ErrNoRows):
+		return common.ErrChatNotFound
+	default:
+		return fmt.Errorf("err getting chat for %s and %s: %w", uuid1, uuid2, err)
+	}
+	return nil
+}
+
+// GetChatPeer returns the peer of the user in the dialog with the conversation id, ErrChatNotFound if the user
+// has no such dialog.
+func (s *Storage) GetChatPeer(ctx context.Context, uuid, conversationID string) (string, error) {
+	var peer string
+	err := s.db.QueryRow(ctx, `SELECT uuid2 FROM chat WHERE uuid1 = $1 AND conversation_id = $2`, uuid, conversationID).
+		Scan(&peer)
+	switch {
+	case err == nil:
+	case errors.Is(err, pgx.ErrNoRows):
+		return "", common.ErrChatNotFound
+	default:
+		return "", fmt.Errorf("err getting chat of %s by conversation id %s: %w", uuid, conversationID, err)
+	}
+	return peer, nil
+}
+
+// EvictEmptyChats deletes dialogs of the user without messages but the keep most recently opened ones, those
+// with peers in open and those either participant opened at openedBefore or later, for both participants.
+// It returns the number of dialogs deleted.
+func (s *Storage) EvictEmptyChats(ctx context.Context, uuid string, keep int, open []string, openedBefore time.Time) (int64, error) { //nolint:lll
+	query := `
+WITH evicted AS (SELECT uuid2
+                 FROM (SELECT own.uuid2,
+                              greatest(own.opened_at, peer.opened_at)                                      AS opened_at,
+                              row_number() OVER (ORDER BY own.opened_at DESC NULLS LAST, own.created DESC, own.uuid2) AS n
+                       FROM chat AS own
+                                LEFT JOIN chat AS peer ON peer.uuid1 = own.uuid2 AND peer.uuid2 = own.uuid1
+                       WHERE own.uuid1 = $1
+                         AND own.last_message_at IS NULL) AS ranked
+                 WHERE n > $2
+                   AND uuid2 <> ALL (coalesce($3::text[], '{}'))
+                   AND (opened_at IS NULL OR opened_at < $4)),
+     deleted AS (DELETE FROM chat
+                 WHERE (uuid1 = $1 AND uuid2 IN (SELECT uuid2 FROM evicted))
+                    OR (uuid2 = $1 AND uuid1 IN (SELECT uuid2 FROM evicted))
+                 RETURNING uuid1)
+SELECT count(*)
+FROM deleted
+WHERE uuid1 = $1
+`
+	var count int64
+	if err := s.db.QueryRow(ctx, query, uuid, keep, open, openedBefore.UTC()).Scan(&count); err != nil {
+		return 0, fmt.Errorf("err evicting empty chats of %s: %w", uuid, err)
+	}
+	return count, nil
+}
+
+// GetAllChats lists peers of the user, most recently messaged first. Unless archived chats are included,
+// chats snoozed after now are left out too.
+func (s *Storage) GetAllChats(ctx context.Context, uuid string, includeArchived bool, now time.Time, limit, offset int64) ([]string, error) { //nolint:lll
+	var uuids []string
+	query := `SELECT uuid2 FROM chat WHERE uuid1 = $1`
+	args := []interface{}{uuid}
+	if !includeArchived {
+		query += ` AND NOT archived AND (snoozed_until IS NULL OR snoozed_until <= $2)`
+		args = append(args, now)
+	}
+	query += "\nORDER BY last_message_at DESC NULLS LAST, created DESC"
+	if limit != 0 {
+		query += fmt.Sprintf("\nLIMIT %d OFFSET %d", limit, offset)
+	}
+	if err := pgxscan.Select(ctx, s.db, &uuids, query, args...); err != nil {
+		return nil, fmt.Errorf("err selecting chats for %s: %w", uuid, err)
+	}
+	return uuids, nil
+}
+
+func (s *Storage) ArchiveChat(ctx context.Context, uuid1, uuid2 string, archived bool) error {
+	query := `
+UPDATE chat
+SET archived = $3,
+    updated  = now()
+WHERE uuid1 = $1
+  AND uuid2 = $2
+`
+	res, err := s.db.Exec(ctx, query, uuid1, uuid2, archived)
+	if err != nil {
+		return fmt.Errorf("err archiving chat for %s and %s: %w", uuid1, uuid2, err)
+	}
+	if res.RowsAffected() == 0 {
+		return common.ErrChatNotFound
+	}
+	return nil
+}
+
+// SnoozeChat snoozes the chat of uuid1 with uuid2 at the time until another one, nil ends the snooze.
+// A snooze not released yet keeps when it started, so that messages it holds are still sent once it ends.
+func (s *Storage) SnoozeChat(ctx context.Context, uuid1, uuid2 string, at time.Time, until *time.Time) error {
+	query := `
+UPDATE chat
+SET snoozed_at    = CASE
+                        WHEN $4::timestamp IS NULL THEN NULL
+                        WHEN snoozed_until IS NOT NULL THEN coalesce(snoozed_at, $3)
+                        ELSE $3 END,
+    snoozed_until = $4,
+    updated       = now()
+WHERE uuid1 = $1
+  AND uuid2 = $2
+`
+	res, err := s.db.Exec(ctx, query, uuid1, uuid2, at, until)
+	if err != nil {
+		return fmt.Errorf("err snoozing chat for %s and %s: %w", uuid1, uuid2, err)
+	}
+	if res.RowsAffected() == 0 {
+		return common.ErrChatNotFound
+	}
+	return nil
+}
+
+// ReleaseSnooze ends the snooze of uuid1 with uuid2 until the time unless it has been snoozed again.
+func (s *Storage) ReleaseSnooze(ctx context.Context, uuid1, uuid2 string, until time.Time) error {
+	query := `
+UPDATE chat
+SET snoozed_at    = NULL,
+    snoozed_until = NULL
+WHERE uuid1 = $1
+  AND uuid2 = $2
+  AND snoozed_until = $3
+`
+	if _, err := s.db.Exec(ctx, query, uuid1, uuid2, until); err != nil {
+		return fmt.Errorf("err releasing snooze of %s and %s: %w", uuid1, uuid2, err)
+	}
+	return nil
+}
+
+// GetSnoozes returns when participants of the dialog have snoozed it and until when, expired ones included
+// unless released.
+func (s *Storage) GetSnoozes(ctx context.Context, uuid1, uuid2 string) (map[string]chat.Snooze, error) {
+	query := `
+SELECT uuid1, coalesce(snoozed_at, snoozed_until), snoozed_until
+FROM chat
+WHERE ((uuid1 = $1 AND uuid2 = $2) OR (uuid1 = $2 AND uuid2 = $1))
+  AND snoozed_until IS NOT NULL
+`
+	rows, err := s.db.Query(ctx, query, uuid1, uuid2)
+	if err != nil {
+		return nil, fmt.Errorf("err selecting snoozes of %s and %s: %w", uuid1, uuid2, err)
+	}
+	defer rows.Close()
+	snoozes := make(map[string]chat.Snooze)
+	for rows.Next() {
+		var (
+			uuid         string
+			since, until time.Time
+		)
+		if err = rows.Scan(&uuid, &since, &until); err != nil {
+			return nil, fmt.Errorf("err scanning snooze of %s and %s: %w", uuid1, uuid2, err)
+		}
+		snoozes[uuid] = chat.Snooze{Since: since.UTC(), Until: until.UTC()}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("err selecting snoozes of %s and %s: %w", uuid1, uuid2, err)
+	}
+	return snoozes, nil
+}
+
+// MarkAllRead marks every unread chat of the user read up to its latest message and returns their peers.
+func (s *Storage) MarkAllRead(ctx context.Context, uuid string) ([]string, error) {
+	query := `
+UPDATE chat
+SET last_read_at = last_message_at,
+    unread_count = 0
+WHERE uuid1 = $1
+  AND last_message_at IS NOT NULL
+  AND (last_read_at IS NULL OR last_read_at < last_message_at OR unread_count > 0)
+RETURNING uuid2
+`
+	var uuids []string
+	if err := pgxscan.Select(ctx, s.db, &uuids, query, uuid); err != nil {
+		return nil, fmt.Errorf("err marking chats read for %s: %w", uuid, err)
+	}
+	return uuids, nil
+}
+
+// CountUnreadChats counts chats of the user having messages after the last read one.
+func (s *Storage) CountUnreadChats(ctx context.Context, uuid string) (int64, error) {
+	query := `
+SELECT count(1)
+FROM chat
+WHERE uuid1 = $1
+  AND last_message_at IS NOT NULL
+  AND (last_read_at IS NULL OR last_read_at < last_message_at)
+`
+	var count int64
+	if err := s.db.QueryRow(ctx, query, uuid).Scan(&count); err != nil {
+		return 0, fmt.Errorf("err counting unread chats for %s: %w", uuid, err)
+	}
+	return count, nil
+}
+
+// GetReadState returns the unread message counter of the chat of uuid1 with uuid2 and the id of the latest
+// message of the dialog read by uuid1, zeros if there is no such chat.
+func (s *Storage) GetReadState(ctx context.Context, uuid1, uuid2 string) (unread, lastRead int64, err error) {
+	query := `
+SELECT chat.unread_count,
+       coalesce((SELECT max(id)
+                 FROM message
+                 WHERE ((sender = $1 AND receiver = $2) OR (sender = $2 AND receiver = $1))
+                   AND timestamp <= chat.last_read_at), 0)
+FROM chat
+WHERE uuid1 = $1
+  AND uuid2 = $2
+`
+	err = s.db.QueryRow(ctx, query, uuid1, uuid2).Scan(&unread, &lastRead)
+	switch {
+	case err == nil:
+	case errors.Is(err, pgx.ErrNoRows):
+		return 0, 0, nil
+	default:
+		return 0, 0, fmt.Errorf("err getting read state of %s and %s: %w", uuid1, uuid2, err)
+	}
+	return unread, lastRead, nil
+}
+
+// CountUnread sums unread message counters of every chat of the user.
+func (s *Storage) CountUnread(ctx context.Context, uuid string) (int64, error) {
+	var count int64
+	err := s.db.QueryRow(ctx, `SELECT coalesce(sum(unread_count), 0) FROM chat WHERE uuid1 = $1`, uuid).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("err counting unread messages for %s: %w", uuid, err)
+	}
+	return count, nil
+}
+
+// GetUnreadCounts returns unread message counters of the user's chats having unread messages by peer.
+func (s *Storage) GetUnreadCounts(ctx context.Context, uuid string) (map[string]int64, error) {
+	rows, err := s.db.Query(ctx, `SELECT uuid2, unread_count FROM chat WHERE uuid1 = $1 AND unread_count > 0`, uuid)
+	if err != nil {
+		return nil, fmt.Errorf("err selecting unread counts for %s: %w", uuid, err)
+	}
+	defer rows.Close()
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var peer string
+		var count int64
+		if err = rows.Scan(&peer, &count); err != nil {
+			return nil, fmt.Errorf("err scanning unread count for %s: %w", uuid, err)
+		}
+		counts[peer] = count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("err selecting unread counts for %s: %w", uuid, err)
+	}
+	return counts, nil
+}
+
+func (s *Storage) SaveMessage(ctx context.Context, m *chat.Message) error {
+	if m == nil {
+		return nil
+	}
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return fmt.Errorf("err saving message: %w", err)
+	}
+	defer func() {
+		if err = tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.log.Warnf("err rolling back tx during saving message: %v", err)
+		}
+	}()
+	var key *string
+	if m.Key != "" {
+		key = &m.Key
+	}
+	var attachments interface{}
+	if len(m.Attachments) > 0 {
+		attachments = m.Attachments
+	}
+	query := `
+INSERT INTO message (sender, receiver, timestamp, body, idempotency_key, attachments)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (sender, receiver, idempotency_key) DO NOTHING
+RETURNING id
+`
+	err = tx.QueryRow(ctx, query, m.Sender, m.Receiver, m.Timestamp, m.Body, key, attachments).Scan(&m.ID)
+	switch {
+	case err == nil:
+	case errors.Is(err, pgx.ErrNoRows):
+		// a retried send, the previously stored message is returned instead
+		query = `
+SELECT id, timestamp, body, attachments
+FROM message
+WHERE sender = $1
+  AND receiver = $2
+  AND idempotency_key = $3
+`
+		err = tx.QueryRow(ctx, query, m.Sender, m.Receiver, key).Scan(&m.ID, &m.Timestamp, &m.Body, &m.Attachments)
+		if err != nil {
+			return fmt.Errorf("err selecting message from %s to %s by key: %w", m.Sender, m.Receiver, err)
+		}
+		return common.ErrDuplicateMessage
+	default:
+		return fmt.Errorf("err inserting message from %s to %s: %w", m.Sender, m.Receiver, err)
+	}
+	query = `
+UPDATE chat
+SET last_message_at = greatest(last_message_at, $3),
+    last_read_at    = CASE WHEN uuid1 = $1 THEN greatest(last_read_at, $3) ELSE last_read_at END,
+    unread_count    = CASE WHEN uuid1 = $1 THEN 0 ELSE unread_count + 1 END
+WHERE (uuid1 = $1 AND uuid2 = $2)
+   OR (uuid1 = $2 AND uuid2 = $1)
+`
+	if _, err = tx.Exec(ctx, query, m.Sender, m.Receiver, m.Timestamp); err != nil {
+		return fmt.Errorf("err updating last message time of %s and %s: %w", m.Sender, m.Receiver, err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("err committing save message transaction: %w", err)
+	}
+	return nil
+}
+
+// RetractMessage deletes a message sent by the sender to the receiver and recalculates last message time of their chat.
+func (s *Storage) RetractMessage(ctx context.Context, sender, receiver string, id int64) error {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return fmt.Errorf("err retracting message: %w", err)
+	}
+	defer func() {
+		if err = tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.log.Warnf("err rolling back tx during retracting message: %v", err)
+		}
+	}()
+	var timestamp time.Time
+	err = tx.QueryRow(ctx, `DELETE FROM message WHERE id = $1 AND sender = $2 AND receiver = $3 RETURNING timestamp`,
+		id, sender, receiver).Scan(&timestamp)
+	switch {
+	case err == nil:
+	case errors.Is(err, pgx.ErrNoRows):
+		return common.ErrMessageNotFound
+	default:
+		return fmt.Errorf("err deleting message %d: %w", id, err)
+	}
+	// the message stops counting as unread for the receiver unless they have read it
+	query := `
+UPDATE chat
+SET last_message_at = (SELECT max(timestamp)
+                       FROM message
+                       WHERE (sender = $1 AND receiver = $2)
+                          OR (sender = $2 AND receiver = $1)),
+    unread_count    = CASE
+                          WHEN uuid1 = $2 AND (last_read_at IS NULL OR last_read_at < $3)
+                              THEN greatest(unread_count - 1, 0)
+                          ELSE unread_count END
+WHERE (uuid1 = $1 AND uuid2 = $2)
+   OR (uuid1 = $2 AND uuid2 = $1)
+`
+	if _, err = tx.Exec(ctx, query, sender, receiver, timestamp); err != nil {
+		return fmt.Errorf("err updating last message time of %s and %s: %w", sender, receiver, err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("err committing retract message transaction: %w", err)
+	}
+	return nil
+}
+
+// EditMessage replaces the body of a message of the dialog sent after since and keeps the original body
+// of the first edit. Last message times aren't changed as the message keeps its timestamp.
+func (s *Storage) EditMessage(ctx context.Context, m *chat.Message, since time.Time) error {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return fmt.Errorf("err editing message: %w", err)
+	}
+	defer func() {
+		if err = tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.log.Warnf("err rolling back tx during editing message: %v", err)
+		}
+	}()
+	query := `
+SELECT sender, timestamp, attachments
+FROM message
+WHERE id = $1
+  AND ((sender = $2 AND receiver = $3) OR (sender = $3 AND receiver = $2))
+    FOR UPDATE
+`
+	var sender string
+	err = tx.QueryRow(ctx, query, m.ID, m.Sender, m.Receiver).Scan(&sender, &m.Timestamp, &m.Attachments)
+	switch {
+	case err == nil:
+	case errors.Is(err, pgx.ErrNoRows):
+		return common.ErrMessageNotFound
+	default:
+		return fmt.Errorf("err selecting message %d: %w", m.ID, err)
+	}
+	switch {
+	case sender != m.Sender:
+		return common.ErrNotMessageSender
+	case m.Timestamp.Before(since):
+		return common.ErrEditWindowExpired
+	}
+	query = `
+UPDATE message
+SET original_body = coalesce(original_body, body),
+    body          = $2,
+    edited_at     = $3
+WHERE id = $1
+`
+	if _, err = tx.Exec(ctx, query, m.ID, m.Body, m.EditedAt); err != nil {
+		return fmt.Errorf("err updating message %d: %w", m.ID, err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("err committing edit message transaction: %w", err)
+	}
+	return nil
+}
+
+// DeleteMessagesBefore deletes at most limit oldest messages sent before the time and returns their count.
+// Chat last message and last read times are kept so ordering and unread state don't change.
+func (s *Storage) DeleteMessagesBefore(ctx context.Context, before time.Time, limit int) (int64, error) {
+	query := `
+DELETE
+FROM message
+WHERE id IN (SELECT id FROM message WHERE timestamp < $1 ORDER BY id LIMIT $2)
+`
+	res, err := s.db.Exec(ctx, query, before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("err deleting messages before %s: %w", before, err)
+	}
+	return res.RowsAffected(), nil
+}
+
+// reactionsColumn selects reactions to the row of the message relation as a JSON array, null if there are none.
+const reactionsColumn = `(SELECT json_agg(json_build_object('user', r.uuid, 'emoji', r.emoji) ORDER BY r.created_at, r.uuid)
+        FROM message_reactions r
+        WHERE r.message_id = message.id) AS reactions`
+
+func (s *Storage) LoadAllMessages(ctx context.Context, uuid1, uuid2 string) ([]*chat.Message, error) {
+	var messages []*chat.Message
+	query := `
+SELECT id, sender, receiver, timestamp, body, attachments, edited_at, ` + reactionsColumn + `
+FROM message
+WHERE (sender = $1 AND receiver = $2)
+   OR (sender = $2 AND receiver = $1)
+ORDER BY timestamp, id
+`
+	if err := pgxscan.Select(ctx, s.db, &messages, query, uuid1, uuid2); err != nil {
+		return nil, fmt.Errorf("err selecting messages for %s and %s: %w", uuid1, uuid2, err)
+	}
+	return messages, nil
+}
+
+// LoadRecentMessages returns up to limit latest messages of the dialog, oldest first.
+func (s *Storage) LoadRecentMessages(ctx context.Context, uuid1, uuid2 string, limit int) ([]*chat.Message, error) {
+	var messages []*chat.Message
+	query := `
+SELECT id, sender, receiver, timestamp, body, attachments, edited_at, ` + reactionsColumn + `
+FROM (SELECT id, sender, receiver, timestamp, body, attachments, edited_at
+      FROM message
+      WHERE (sender = $1 AND receiver = $2)
+         OR (sender = $2 AND receiver = $1)
+      ORDER BY timestamp DESC, id DESC
+      LIMIT $3) AS message
+ORDER BY timestamp, id
+`
+	if err := pgxscan.Select(ctx, s.db, &messages, query, uuid1, uuid2, limit); err != nil {
+		return nil, fmt.Errorf("err selecting recent messages for %s and %s: %w", uuid1, uuid2, err)
+	}
+	return messages, nil
+}
+
+// LoadMessagesSince returns up to limit latest messages the sender has sent to the receiver since the time,
+// oldest first.
+func (s *Storage) LoadMessagesSince(ctx context.Context, sender, receiver string, since time.Time, limit int) ([]*chat.Message, error) { //nolint:lll
+	var messages []*chat.Message
+	query := `
+SELECT id, sender, receiver, timestamp, body, attachments, edited_at, ` + reactionsColumn + `
+FROM (SELECT id, sender, receiver, timestamp, body, attachments, edited_at
+      FROM message
+      WHERE sender = $1
+        AND receiver = $2
+        AND timestamp >= $3
+      ORDER BY timestamp DESC, id DESC
+      LIMIT $4) AS message
+ORDER BY timestamp, id
+`
+	if err := pgxscan.Select(ctx, s.db, &messages, query, sender, receiver, since, limit); err != nil {
+		return nil, fmt.Errorf("err selecting messages of %s to %s: %w", sender, receiver, err)
+	}
+	return messages, nil
+}
+
+// StreamMessages calls fn for every message of the dialog, oldest first, without loading them all.
+func (s *Storage) StreamMessages(ctx context.Context, uuid1, uuid2 string, fn func(*chat.Message) error) error {
+	query := `
+SELECT id, sender, receiver, timestamp, body, attachments, edited_at, ` + reactionsColumn + `
+FROM message
+WHERE (sender = $1 AND receiver = $2)
+   OR (sender = $2 AND receiver = $1)
+ORDER BY timestamp, id
+`
+	rows, err := s.db.Query(ctx, query, uuid1, uuid2)
+	if err != nil {
+		return fmt.Errorf("err selecting messages for %s and %s: %w", uuid1, uuid2, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m chat.Message
+		err = rows.Scan(&m.ID, &m.Sender, &m.Receiver, &m.Timestamp, &m.Body, &m.Attachments, &m.EditedAt, &m.Reactions)
+		if err != nil {
+			return fmt.Errorf("err scanning message for %s and %s: %w", uuid1, uuid2, err)
+		}
+		if err = fn(&m); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("err selecting messages for %s and %s: %w", uuid1, uuid2, err)
+	}
+	return nil
+}
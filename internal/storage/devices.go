@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+// SaveDevice registers the device to the user. A token registered already keeps its id and moves
+// to the user, as a device signed in to another account no longer belongs to the previous one.
+func (s *Storage) SaveDevice(ctx context.Context, uuid string, device *models.Device) error {
+	query := `
+INSERT INTO devices (uuid, platform, token, created_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (platform, token) DO UPDATE SET uuid       = EXCLUDED.uuid,
+                                            created_at = CASE
+                                                             WHEN devices.uuid = EXCLUDED.uuid THEN devices.created_at
+                                                             ELSE EXCLUDED.created_at END
+RETURNING id, created_at
+`
+	err := s.db.QueryRow(ctx, query, uuid, device.Platform, device.Token, device.CreatedAt).
+		Scan(&device.ID, &device.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("err saving %s device of %s: %w", device.Platform, uuid, err)
+	}
+	return nil
+}
+
+// DeleteDevice unregisters the user's device. ErrDeviceNotFound if the user has no such device.
+func (s *Storage) DeleteDevice(ctx context.Context, uuid string, id int64) error {
+	res, err := s.db.Exec(ctx, `DELETE FROM devices WHERE id = $1 AND uuid = $2`, id, uuid)
+	if err != nil {
+		return fmt.Errorf("err deleting device %d of %s: %w", id, uuid, err)
+	}
+	if res.RowsAffected() == 0 {
+		return common.ErrDeviceNotFound
+	}
+	return nil
+}
+
+// ListDevices returns devices of the user to push notifications to, the latest registered first.
+func (s *Storage) ListDevices(ctx context.Context, uuid string) ([]*models.Device, error) {
+	var devices []*models.Device
+	query := `SELECT id, platform, token, created_at FROM devices WHERE uuid = $1 ORDER BY created_at DESC, id DESC`
+	if err := pgxscan.Select(ctx, s.db, &devices, query, uuid); err != nil {
+		return nil, fmt.Errorf("err listing devices of %s: %w", uuid, err)
+	}
+	return devices, nil
+}
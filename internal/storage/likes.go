@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+// ListIncomingLikes lists up to limit likes of the user after the cursor, most recent first. Likes the user
+// liked back are left out, as are those of users hidden by or hiding the user and of users paused at the moment now.
+// The query walks relations_incoming_likes_idx from the cursor on, so it doesn't slow down with the count of likes.
+func (s *Storage) ListIncomingLikes(ctx context.Context, uuid string, now time.Time, after models.LikesCursor, limit int64) ([]*models.IncomingLike, error) { //nolint:lll
+	args := []interface{}{uuid, now.UTC(), limit}
+	var cursorCondition string
+	if !after.IsZero() {
+		args = append(args, after.LikedAt.UTC(), after.UUID)
+		cursorCondition = "\n  AND (liker.updated, liker.uuid) < ($4, $5)"
+	}
+	var rows []LikeRow
+	query := fmt.Sprintf(`
+SELECT liker.uuid, liker.relation, liker.updated
+FROM relations AS liker
+WHERE liker.target = $1
+  AND liker.relation IN (%[1]d, %[2]d)%[3]s
+  AND NOT EXISTS(SELECT 1
+                 FROM relations AS own
+                 WHERE own.uuid = $1
+                   AND own.target = liker.uuid
+                   AND own.relation IN (%[1]d, %[2]d))
+  AND NOT EXISTS(SELECT 1
+                 FROM hidden
+                 WHERE (hidden.uuid = $1 AND hidden.target = liker.uuid)
+                    OR (hidden.uuid = liker.uuid AND hidden.target = $1))
+  AND NOT EXISTS(SELECT 1 FROM config WHERE config.uuid = liker.uuid AND config.pause_until > $2)
+ORDER BY liker.updated DESC, liker.uuid DESC
+LIMIT $3`, Liked, SuperLiked, cursorCondition)
+	if err := pgxscan.Select(ctx, s.db, &rows, query, args...); err != nil {
+		return nil, fmt.Errorf("err selecting incoming likes of %s: %w", uuid, err)
+	}
+	uuids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		uuids = append(uuids, row.UUID)
+	}
+	var profiles []*models.Profile
+	if err := s.getProfiles(ctx, &profiles, uuids); err != nil {
+		return nil, fmt.Errorf("err selecting incoming likes of %s: %w", uuid, err)
+	}
+	byUUID := make(map[string]*models.Profile, len(profiles))
+	for _, p := range profiles {
+		byUUID[p.UUID] = p
+	}
+	result := make([]*models.IncomingLike, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, &models.IncomingLike{
+			UUID:      row.UUID,
+			Profile:   byUUID[row.UUID],
+			SuperLike: Relation(row.Relation) == SuperLiked,
+			LikedAt:   common.NewTimestamp(row.Updated),
+		})
+	}
+	return result, nil
+}
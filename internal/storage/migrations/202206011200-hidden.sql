@@ -0,0 +1,20 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table hidden
+(
+    uuid   text not null
+        constraint fk_configs_hidden_uuid
+            references config,
+    target text not null
+        constraint fk_configs_hidden_target
+            references config,
+    created timestamp default now(),
+    primary key (uuid, target)
+);
+
+-- +migrate Down
+
+DROP TABLE hidden CASCADE;
@@ -0,0 +1,12 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+alter table personal
+    add column birthdate date;
+
+-- +migrate Down
+
+alter table personal
+    drop column birthdate;
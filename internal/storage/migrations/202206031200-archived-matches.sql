@@ -0,0 +1,12 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+alter table relations
+    add column archived boolean not null default false;
+
+-- +migrate Down
+
+alter table relations
+    drop column archived;
@@ -0,0 +1,12 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+alter table chat
+    add column archived boolean not null default false;
+
+-- +migrate Down
+
+alter table chat
+    drop column archived;
@@ -0,0 +1,14 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+alter table personal
+    add column latitude double precision,
+    add column longitude double precision;
+
+-- +migrate Down
+
+alter table personal
+    drop column latitude,
+    drop column longitude;
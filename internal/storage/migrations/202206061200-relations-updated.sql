@@ -0,0 +1,12 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+alter table relations
+    add column updated timestamp not null default now();
+
+-- +migrate Down
+
+alter table relations
+    drop column updated;
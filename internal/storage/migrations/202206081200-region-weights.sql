@@ -0,0 +1,12 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+alter table uuid_regions
+    add column weight double precision not null default 1;
+
+-- +migrate Down
+
+alter table uuid_regions
+    drop column weight;
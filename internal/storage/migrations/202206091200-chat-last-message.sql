@@ -0,0 +1,28 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+alter table message
+    add column id bigserial primary key;
+
+alter table chat
+    add column last_message_at timestamp;
+
+update chat
+set last_message_at = (select max(timestamp)
+                       from message
+                       where (sender = chat.uuid1 and receiver = chat.uuid2)
+                          or (sender = chat.uuid2 and receiver = chat.uuid1));
+
+create index chat_last_message_idx on chat (uuid1, last_message_at desc nulls last);
+
+-- +migrate Down
+
+drop index chat_last_message_idx;
+
+alter table chat
+    drop column last_message_at;
+
+alter table message
+    drop column id;
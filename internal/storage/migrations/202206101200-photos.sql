@@ -0,0 +1,21 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table photos
+(
+    id       bigserial primary key,
+    uuid     text     not null
+        constraint fk_configs_photos
+            references config,
+    link     text     not null,
+    position smallint not null default 0,
+    created  timestamp default now()
+);
+
+create index photos_uuid_idx on photos (uuid, position);
+
+-- +migrate Down
+
+DROP TABLE photos CASCADE;
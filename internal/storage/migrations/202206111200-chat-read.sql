@@ -0,0 +1,12 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+alter table chat
+    add column last_read_at timestamp;
+
+-- +migrate Down
+
+alter table chat
+    drop column last_read_at;
@@ -0,0 +1,12 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+alter table config
+    add column pause_until timestamp;
+
+-- +migrate Down
+
+alter table config
+    drop column pause_until;
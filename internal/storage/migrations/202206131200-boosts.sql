@@ -0,0 +1,18 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table boosts
+(
+    uuid       text      not null
+        primary key
+        constraint fk_configs_boosts
+            references config,
+    started_at timestamp not null,
+    expires_at timestamp not null
+);
+
+-- +migrate Down
+
+DROP TABLE boosts CASCADE;
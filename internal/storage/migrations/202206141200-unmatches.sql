@@ -0,0 +1,18 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table unmatches
+(
+    uuid         text      not null
+        constraint fk_configs_unmatches
+            references config,
+    target       text      not null,
+    unmatched_at timestamp not null,
+    primary key (uuid, target)
+);
+
+-- +migrate Down
+
+DROP TABLE unmatches CASCADE;
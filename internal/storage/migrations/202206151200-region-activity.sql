@@ -0,0 +1,19 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table region_activity
+(
+    region_id bigint not null
+        constraint fk_region_activity_region
+            references regions,
+    day       date   not null,
+    likes     bigint not null default 0,
+    matches   bigint not null default 0,
+    primary key (region_id, day)
+);
+
+-- +migrate Down
+
+DROP TABLE region_activity CASCADE;
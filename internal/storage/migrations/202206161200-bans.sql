@@ -0,0 +1,17 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table bans
+(
+    kind       text      not null,
+    value      text      not null,
+    reason     text      not null default '',
+    created_at timestamp not null,
+    primary key (kind, value)
+);
+
+-- +migrate Down
+
+DROP TABLE bans CASCADE;
@@ -0,0 +1,19 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+alter table chat
+    add column unread_count bigint not null default 0;
+
+update chat
+set unread_count = (select count(1)
+                    from message
+                    where sender = chat.uuid2
+                      and receiver = chat.uuid1
+                      and (chat.last_read_at is null or timestamp > chat.last_read_at));
+
+-- +migrate Down
+
+alter table chat
+    drop column unread_count;
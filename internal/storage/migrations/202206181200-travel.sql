@@ -0,0 +1,20 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+alter table config
+    add column travel_region_id bigint
+        constraint fk_config_travel_region
+            references regions,
+    add column travel_latitude  double precision,
+    add column travel_longitude double precision,
+    add column travel_until     timestamp;
+
+-- +migrate Down
+
+alter table config
+    drop column travel_region_id,
+    drop column travel_latitude,
+    drop column travel_longitude,
+    drop column travel_until;
@@ -0,0 +1,17 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+alter table message
+    add column idempotency_key text;
+
+create unique index message_idempotency_key_uindex
+    on message (sender, receiver, idempotency_key);
+
+-- +migrate Down
+
+drop index message_idempotency_key_uindex;
+
+alter table message
+    drop column idempotency_key;
@@ -0,0 +1,14 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+alter table message
+    add column edited_at     timestamp,
+    add column original_body text;
+
+-- +migrate Down
+
+alter table message
+    drop column edited_at,
+    drop column original_body;
@@ -0,0 +1,21 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table message_reports
+(
+    id         bigserial primary key,
+    reporter   text      not null,
+    message_id bigint    not null,
+    sender     text      not null,
+    body       text      not null default '',
+    sent_at    timestamp not null,
+    reason     text      not null,
+    created_at timestamp not null,
+    constraint message_reports_reporter_message_uindex unique (reporter, message_id)
+);
+
+-- +migrate Down
+
+DROP TABLE message_reports CASCADE;
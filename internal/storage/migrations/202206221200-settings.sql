@@ -0,0 +1,24 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+alter table settings
+    add column language        text    not null default '',
+    add column incognito       boolean not null default false,
+    add column notify_matches  boolean not null default true,
+    add column notify_messages boolean not null default true,
+    add column notify_likes    boolean not null default true,
+    add column show_age        boolean not null default true,
+    add column show_distance   boolean not null default true;
+
+-- +migrate Down
+
+alter table settings
+    drop column language,
+    drop column incognito,
+    drop column notify_matches,
+    drop column notify_messages,
+    drop column notify_likes,
+    drop column show_age,
+    drop column show_distance;
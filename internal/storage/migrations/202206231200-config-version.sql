@@ -0,0 +1,12 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+alter table config
+    add column version bigint not null default 1;
+
+-- +migrate Down
+
+alter table config
+    drop column version;
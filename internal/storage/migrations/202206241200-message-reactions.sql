@@ -0,0 +1,17 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table message_reactions
+(
+    message_id bigint    not null references message (id) on delete cascade,
+    uuid       text      not null,
+    emoji      text      not null,
+    created_at timestamp not null,
+    primary key (message_id, uuid)
+);
+
+-- +migrate Down
+
+DROP TABLE message_reactions CASCADE;
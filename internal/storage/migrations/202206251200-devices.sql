@@ -0,0 +1,20 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table devices
+(
+    id         bigserial primary key,
+    uuid       text      not null,
+    platform   text      not null,
+    token      text      not null,
+    created_at timestamp not null,
+    unique (platform, token)
+);
+
+create index devices_uuid_idx on devices (uuid);
+
+-- +migrate Down
+
+DROP TABLE devices CASCADE;
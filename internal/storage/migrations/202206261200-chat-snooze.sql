@@ -0,0 +1,12 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+alter table chat
+    add column snoozed_until timestamp;
+
+-- +migrate Down
+
+alter table chat
+    drop column snoozed_until;
@@ -0,0 +1,88 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+-- active matches of a user are counted as in CountActiveMatches: both like each other
+-- and the user hasn't archived the match
+create table match_counts
+(
+    uuid  text   not null
+        primary key,
+    count bigint not null default 0
+);
+
+insert into match_counts (uuid, count)
+select own.uuid, count(1)
+from relations as own
+         join relations as other on other.uuid = own.target and other.target = own.uuid
+where not own.archived
+  and own.relation in (0, 1)
+  and other.relation in (0, 1)
+group by own.uuid;
+
+-- +migrate StatementBegin
+create function count_matches() returns trigger as
+$$
+declare
+    pair_uuid   text;
+    pair_target text;
+    back        relations%rowtype;
+    back_like   boolean;
+    back_active boolean;
+    was_like    boolean := false;
+    was_active  boolean := false;
+    is_like     boolean := false;
+    is_active   boolean := false;
+    own_delta   int;
+    back_delta  int;
+begin
+    if tg_op = 'DELETE' then
+        pair_uuid := old.uuid;
+        pair_target := old.target;
+    else
+        pair_uuid := new.uuid;
+        pair_target := new.target;
+    end if;
+    -- changes of a pair are serialized, so that concurrent likes see each other committed
+    perform pg_advisory_xact_lock(hashtext(least(pair_uuid, pair_target) || ':' || greatest(pair_uuid, pair_target)));
+    select * into back from relations where uuid = pair_target and target = pair_uuid;
+    -- 0 and 1 are likes and super likes
+    back_like := coalesce(back.relation in (0, 1), false);
+    back_active := back_like and not back.archived;
+    if tg_op != 'INSERT' then
+        was_like := old.relation in (0, 1);
+        was_active := was_like and not old.archived;
+    end if;
+    if tg_op != 'DELETE' then
+        is_like := new.relation in (0, 1);
+        is_active := is_like and not new.archived;
+    end if;
+    own_delta := (is_active and back_like)::int - (was_active and back_like)::int;
+    back_delta := (back_active and is_like)::int - (back_active and was_like)::int;
+    if own_delta != 0 then
+        insert into match_counts (uuid, count)
+        values (pair_uuid, own_delta)
+        on conflict (uuid) do update set count = match_counts.count + excluded.count;
+    end if;
+    if back_delta != 0 then
+        insert into match_counts (uuid, count)
+        values (pair_target, back_delta)
+        on conflict (uuid) do update set count = match_counts.count + excluded.count;
+    end if;
+    return null;
+end;
+$$ language plpgsql;
+-- +migrate StatementEnd
+
+create trigger relations_count_matches
+    after insert or update or delete
+    on relations
+    for each row
+execute function count_matches();
+
+-- +migrate Down
+
+drop trigger relations_count_matches on relations;
+drop function count_matches();
+drop table match_counts;
@@ -0,0 +1,12 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+alter table personal
+    add column bios jsonb;
+
+-- +migrate Down
+
+alter table personal
+    drop column bios;
@@ -0,0 +1,21 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+-- a user has a single relation to a target by the primary key, so liking and disliking replace each other
+-- and liked and disliked targets are distinct. Relations of no known type, in neither list, are dropped.
+delete
+from relations
+where relation is null
+   or relation not in (0, 1, 2);
+
+alter table relations
+    alter column relation set not null,
+    add constraint relations_relation_check check (relation in (0, 1, 2));
+
+-- +migrate Down
+
+alter table relations
+    drop constraint relations_relation_check,
+    alter column relation drop not null;
@@ -0,0 +1,15 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table revoked_tokens
+(
+    jti        text      not null
+        primary key,
+    revoked_at timestamp not null
+);
+
+-- +migrate Down
+
+DROP TABLE revoked_tokens;
@@ -0,0 +1,11 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+-- incoming likes are listed by the liked user, most recent first
+create index relations_incoming_likes_idx on relations (target, updated desc, uuid desc) where relation in (0, 1);
+
+-- +migrate Down
+
+drop index relations_incoming_likes_idx;
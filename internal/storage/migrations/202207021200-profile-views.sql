@@ -0,0 +1,21 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+-- the last view of a profile by a viewer, repeated views within the dedup window aren't counted
+create table profile_views
+(
+    viewer text        not null,
+    target text        not null,
+    viewed timestamptz not null,
+    views  int         not null default 1,
+    primary key (viewer, target)
+);
+
+-- viewers are listed by the viewed user, most recent first
+create index profile_views_target_idx on profile_views (target, viewed desc, viewer desc);
+
+-- +migrate Down
+
+drop table profile_views;
@@ -0,0 +1,42 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+-- actions taken on behalf of users by admins, rows are never updated nor deleted
+create table audit_log
+(
+    id       bigserial   not null
+        primary key,
+    at       timestamptz not null,
+    actor    text        not null,
+    action   text        not null,
+    subject  text        not null,
+    token_id text        not null,
+    method   text        not null default '',
+    path     text        not null default '',
+    reason   text        not null default ''
+);
+
+create index audit_log_subject_idx on audit_log (subject, at desc);
+
+-- +migrate StatementBegin
+create function audit_log_immutable() returns trigger as
+$$
+begin
+    raise exception 'audit_log is append-only';
+end;
+$$ language plpgsql;
+-- +migrate StatementEnd
+
+create trigger audit_log_immutable
+    before update or delete
+    on audit_log
+    for each row
+execute procedure audit_log_immutable();
+
+-- +migrate Down
+
+drop trigger audit_log_immutable on audit_log;
+drop function audit_log_immutable();
+drop table audit_log;
@@ -0,0 +1,17 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+-- empty quiet_start means no quiet hours
+alter table settings
+    add column quiet_start    text not null default '',
+    add column quiet_end      text not null default '',
+    add column quiet_timezone text not null default '';
+
+-- +migrate Down
+
+alter table settings
+    drop column quiet_start,
+    drop column quiet_end,
+    drop column quiet_timezone;
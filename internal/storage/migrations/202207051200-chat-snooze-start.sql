@@ -0,0 +1,21 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+alter table chat
+    add column snoozed_at timestamp;
+
+-- snoozes over already had their messages sent from memory, ongoing ones hold messages since they were set
+update chat
+set snoozed_until = null
+where snoozed_until <= now() at time zone 'utc';
+
+update chat
+set snoozed_at = updated
+where snoozed_until is not null;
+
+-- +migrate Down
+
+alter table chat
+    drop column snoozed_at;
@@ -0,0 +1,16 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+ALTER TABLE revoked_tokens
+    ADD COLUMN expires_at timestamp;
+
+create index revoked_tokens_expires_at_idx on revoked_tokens (expires_at);
+
+-- +migrate Down
+
+DROP INDEX revoked_tokens_expires_at_idx;
+
+ALTER TABLE revoked_tokens
+    DROP COLUMN expires_at;
@@ -0,0 +1,16 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+-- creation and update times were written as the local time of the app, taken to be the zone of the database
+-- session, so they are read in it
+ALTER TABLE config
+    ALTER COLUMN created TYPE timestamptz USING created AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN updated TYPE timestamptz USING updated AT TIME ZONE current_setting('TimeZone');
+
+-- +migrate Down
+
+ALTER TABLE config
+    ALTER COLUMN created TYPE timestamp USING created AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN updated TYPE timestamp USING updated AT TIME ZONE current_setting('TimeZone');
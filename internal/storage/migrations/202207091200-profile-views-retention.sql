@@ -0,0 +1,11 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+-- views past the retention age are purged
+create index profile_views_viewed_idx on profile_views (viewed);
+
+-- +migrate Down
+
+drop index profile_views_viewed_idx;
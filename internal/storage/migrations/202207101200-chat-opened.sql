@@ -0,0 +1,17 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+-- when the owner of the chat last opened it, empty chats opened least recently are evicted first
+alter table chat
+    add column opened_at timestamp;
+
+-- updated used to be bumped on opening, along with archiving and snoozing
+update chat
+set opened_at = updated;
+
+-- +migrate Down
+
+alter table chat
+    drop column opened_at;
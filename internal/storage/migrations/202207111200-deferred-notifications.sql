@@ -0,0 +1,18 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+-- match notifications of users in quiet hours, sent once deliver_at comes
+create table deferred_notifications
+(
+    id         bigserial not null,
+    uuid       text      not null,
+    target     text      not null,
+    deliver_at timestamp not null,
+    primary key (uuid, target)
+);
+
+-- +migrate Down
+
+drop table deferred_notifications;
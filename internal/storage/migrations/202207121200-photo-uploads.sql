@@ -0,0 +1,19 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+-- photos presigned for direct uploads, photo_id is set once the upload is confirmed
+create table photo_uploads
+(
+    key        text primary key,
+    uuid       text      not null,
+    expires_at timestamp not null,
+    photo_id   bigint
+);
+
+create index photo_uploads_expires_at_idx on photo_uploads (expires_at);
+
+-- +migrate Down
+
+drop table photo_uploads;
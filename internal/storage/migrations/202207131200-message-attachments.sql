@@ -0,0 +1,12 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+alter table message
+    add column attachments jsonb;
+
+-- +migrate Down
+
+alter table message
+    drop column attachments;
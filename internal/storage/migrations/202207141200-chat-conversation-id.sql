@@ -0,0 +1,21 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+-- conversation ids are opaque, so that clients addressing a dialog by one need it stored to be resolved
+alter table chat
+    add column conversation_id text;
+
+-- as chat.ConversationID forms them, the uuids are ordered bytewise
+update chat
+set conversation_id = substr(encode(sha256(convert_to(least(uuid1 collate "C", uuid2 collate "C") || ':' ||
+                                                      greatest(uuid1 collate "C", uuid2 collate "C"), 'UTF8')),
+                                    'hex'), 1, 32);
+
+create index chat_conversation_id_idx on chat (uuid1, conversation_id);
+
+-- +migrate Down
+
+alter table chat
+    drop column conversation_id;
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+)
+
+// DeferMatchNotifications stores notifications of the user about matches with the targets till deliverAt.
+func (s *Storage) DeferMatchNotifications(ctx context.Context, uuid string, targets []string, deliverAt time.Time) error {
+	query := `
+INSERT INTO deferred_notifications (uuid, target, deliver_at)
+SELECT $1, target, $3
+FROM unnest($2::text[]) WITH ORDINALITY AS targets(target, n)
+ORDER BY n
+ON CONFLICT (uuid, target) DO NOTHING
+`
+	if _, err := s.db.Exec(ctx, query, uuid, targets, deliverAt.UTC()); err != nil {
+		return fmt.Errorf("err deferring notifications of %s: %w", uuid, err)
+	}
+	return nil
+}
+
+// TakeDeferredNotifications deletes the deferred notifications of the user and returns their targets in the order
+// they were deferred, none if another caller has taken them.
+func (s *Storage) TakeDeferredNotifications(ctx context.Context, uuid string) ([]string, error) {
+	query := `
+WITH taken AS (DELETE FROM deferred_notifications WHERE uuid = $1 RETURNING id, target)
+SELECT target
+FROM taken
+ORDER BY id
+`
+	var targets []string
+	if err := pgxscan.Select(ctx, s.db, &targets, query, uuid); err != nil {
+		return nil, fmt.Errorf("err taking deferred notifications of %s: %w", uuid, err)
+	}
+	return targets, nil
+}
+
+// ListDeferredNotifications returns users with deferred notifications and when the earliest of those are due.
+func (s *Storage) ListDeferredNotifications(ctx context.Context) (map[string]time.Time, error) {
+	rows, err := s.db.Query(ctx, `SELECT uuid, min(deliver_at) FROM deferred_notifications GROUP BY uuid`)
+	if err != nil {
+		return nil, fmt.Errorf("err listing deferred notifications: %w", err)
+	}
+	defer rows.Close()
+	deferred := make(map[string]time.Time)
+	for rows.Next() {
+		var (
+			uuid      string
+			deliverAt time.Time
+		)
+		if err = rows.Scan(&uuid, &deliverAt); err != nil {
+			return nil, fmt.Errorf("err scanning deferred notification: %w", err)
+		}
+		deferred[uuid] = deliverAt.UTC()
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("err listing deferred notifications: %w", err)
+	}
+	return deferred, nil
+}
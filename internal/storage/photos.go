@@ -0,0 +1,341 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/jackc/pgx/v4"
+)
+
+// AddPhoto appends the photo to the user's ones. With a positive limit ErrPhotoLimitReached is returned
+// if the user has as many photos already, unless replaceOldest is set. Then the oldest uploaded photos
+// other than the primary are deleted to make room, the primary is never replaced.
+func (s *Storage) AddPhoto(ctx context.Context, uuid, link string, limit int, replaceOldest bool) (*models.Photo, error) { //nolint:lll
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("err adding photo: %w", err)
+	}
+	defer func() {
+		if err = tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.log.Warnf("err rolling back tx during adding photo: %v", err)
+		}
+	}()
+	photo, err := insertPhoto(ctx, tx, uuid, link, limit, replaceOldest)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("err committing add photo transaction: %w", err)
+	}
+	return photo, nil
+}
+
+// ConfirmPhotoUpload adds the photo uploaded by the user with the presigned key as AddPhoto does, once
+// per key: confirming it again returns the same photo. ErrUploadNotFound if the key wasn't presigned
+// for the user, expired before expiredBefore or its photo was deleted since.
+func (s *Storage) ConfirmPhotoUpload(ctx context.Context, uuid, key, link string, expiredBefore time.Time, limit int, replaceOldest bool) (*models.Photo, error) { //nolint:lll
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("err confirming photo upload: %w", err)
+	}
+	defer func() {
+		if err = tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.log.Warnf("err rolling back tx during confirming photo upload: %v", err)
+		}
+	}()
+	// concurrent confirmations of the key wait for the first one
+	var photoID *int64
+	query := `SELECT photo_id FROM photo_uploads WHERE key = $1 AND uuid = $2 AND expires_at >= $3 FOR UPDATE`
+	err = tx.QueryRow(ctx, query, key, uuid, expiredBefore.UTC()).Scan(&photoID)
+	switch {
+	case err == nil:
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, common.ErrUploadNotFound
+	default:
+		return nil, fmt.Errorf("err selecting photo upload %s: %w", key, err)
+	}
+	if photoID != nil {
+		var photo models.Photo
+		query = `SELECT id, link, position FROM photos WHERE id = $1 AND uuid = $2`
+		err = tx.QueryRow(ctx, query, *photoID, uuid).Scan(&photo.ID, &photo.Link, &photo.Position)
+		switch {
+		case err == nil:
+			return &photo, nil
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, common.ErrUploadNotFound
+		default:
+			return nil, fmt.Errorf("err selecting photo %d of %s: %w", *photoID, uuid, err)
+		}
+	}
+	photo, err := insertPhoto(ctx, tx, uuid, link, limit, replaceOldest)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec(ctx, `UPDATE photo_uploads SET photo_id = $2 WHERE key = $1`, key, photo.ID); err != nil {
+		return nil, fmt.Errorf("err confirming photo upload %s: %w", key, err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("err committing confirm photo upload transaction: %w", err)
+	}
+	return photo, nil
+}
+
+// insertPhoto appends the photo to the user's ones within the tx as AddPhoto does.
+func insertPhoto(ctx context.Context, tx pgx.Tx, uuid, link string, limit int, replaceOldest bool) (*models.Photo, error) { //nolint:lll
+	// concurrent uploads of the user are counted one after another
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM config WHERE uuid = $1 FOR UPDATE`, uuid); err != nil {
+		return nil, fmt.Errorf("err locking config for %s: %w", uuid, err)
+	}
+	if limit > 0 {
+		var photos []*models.Photo
+		err := pgxscan.Select(ctx, tx, &photos,
+			`SELECT id, link, position FROM photos WHERE uuid = $1 ORDER BY position, id`, uuid)
+		if err != nil {
+			return nil, fmt.Errorf("err selecting photos for %s: %w", uuid, err)
+		}
+		if excess := len(photos) - limit + 1; excess > 0 {
+			if !replaceOldest || excess >= len(photos) {
+				return nil, common.ErrPhotoLimitReached
+			}
+			replaced := photos[1:]
+			sort.Slice(replaced, func(i, j int) bool { return replaced[i].ID < replaced[j].ID })
+			ids := make([]int64, 0, excess)
+			for _, photo := range replaced[:excess] {
+				ids = append(ids, photo.ID)
+			}
+			if _, err = tx.Exec(ctx, `DELETE FROM photos WHERE id = ANY($1)`, ids); err != nil {
+				return nil, fmt.Errorf("err deleting photos of %s: %w", uuid, err)
+			}
+			if err = compactPositions(ctx, tx, uuid); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// photos are a part of the config, so adding one bumps its version
+	query := `
+WITH bumped AS (UPDATE config SET version = version + 1 WHERE uuid = $1)
+INSERT INTO photos (uuid, link, position)
+VALUES ($1, $2, (SELECT coalesce(max(position) + 1, 0) FROM photos WHERE uuid = $1))
+RETURNING id, link, position
+`
+	var photo models.Photo
+	if err := tx.QueryRow(ctx, query, uuid, link).Scan(&photo.ID, &photo.Link, &photo.Position); err != nil {
+		return nil, fmt.Errorf("err inserting photo for %s: %w", uuid, err)
+	}
+	return &photo, nil
+}
+
+// DeletePhoto deletes the user's photo, the next one becomes the primary if it was. ErrPhotoNotFound
+// if the user has no such photo.
+func (s *Storage) DeletePhoto(ctx context.Context, uuid string, id int64) error {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return fmt.Errorf("err deleting photo: %w", err)
+	}
+	defer func() {
+		if err = tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.log.Warnf("err rolling back tx during deleting photo: %v", err)
+		}
+	}()
+	if _, err = tx.Exec(ctx, `SELECT 1 FROM config WHERE uuid = $1 FOR UPDATE`, uuid); err != nil {
+		return fmt.Errorf("err locking config for %s: %w", uuid, err)
+	}
+	res, err := tx.Exec(ctx, `DELETE FROM photos WHERE id = $1 AND uuid = $2`, id, uuid)
+	if err != nil {
+		return fmt.Errorf("err deleting photo %d of %s: %w", id, uuid, err)
+	}
+	if res.RowsAffected() == 0 {
+		return common.ErrPhotoNotFound
+	}
+	if err = compactPositions(ctx, tx, uuid); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, `UPDATE config SET version = version + 1 WHERE uuid = $1`, uuid); err != nil {
+		return fmt.Errorf("err bumping config version for %s: %w", uuid, err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("err committing delete photo transaction: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) ListPhotos(ctx context.Context, uuid string) ([]*models.Photo, error) {
+	return s.listPhotos(ctx, s.db, uuid)
+}
+
+func (s *Storage) listPhotos(ctx context.Context, db reader, uuid string) ([]*models.Photo, error) {
+	var photos []*models.Photo
+	err := pgxscan.Select(ctx, db, &photos,
+		`SELECT id, link, position FROM photos WHERE uuid = $1 ORDER BY position, id`, uuid)
+	if err != nil {
+		return nil, fmt.Errorf("err selecting photos for %s: %w", uuid, err)
+	}
+	return photos, nil
+}
+
+// ReorderPhotos sets positions of the user's photos according to their order in ids.
+// The ids must list every photo of the user exactly once.
+func (s *Storage) ReorderPhotos(ctx context.Context, uuid string, ids []int64) error {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return fmt.Errorf("err reordering photos: %w", err)
+	}
+	defer func() {
+		if err = tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.log.Warnf("err rolling back tx during reordering photos: %v", err)
+		}
+	}()
+	var owners []PhotoOwner
+	err = pgxscan.Select(ctx, tx, &owners, `SELECT id, uuid FROM photos WHERE id = ANY($1) FOR UPDATE`, ids)
+	if err != nil {
+		return fmt.Errorf("err selecting photos owners: %w", err)
+	}
+	if len(owners) != len(uniqueIDs(ids)) {
+		return common.ErrPhotoNotFound
+	}
+	for _, owner := range owners {
+		if owner.UUID != uuid {
+			return common.ErrForeignPhoto
+		}
+	}
+	var count int
+	if err = tx.QueryRow(ctx, `SELECT count(1) FROM photos WHERE uuid = $1`, uuid).Scan(&count); err != nil {
+		return fmt.Errorf("err counting photos for %s: %w", uuid, err)
+	}
+	if count != len(ids) || len(uniqueIDs(ids)) != len(ids) {
+		return common.ErrInvalidPhotoOrder
+	}
+	query := `
+UPDATE photos
+SET position = data.position - 1
+FROM unnest($1::bigint[]) WITH ORDINALITY AS data(id, position)
+WHERE photos.id = data.id
+`
+	if _, err = tx.Exec(ctx, query, ids); err != nil {
+		return fmt.Errorf("err updating photos positions for %s: %w", uuid, err)
+	}
+	if _, err = tx.Exec(ctx, `UPDATE config SET version = version + 1 WHERE uuid = $1`, uuid); err != nil {
+		return fmt.Errorf("err bumping config version for %s: %w", uuid, err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("err committing reorder photos transaction: %w", err)
+	}
+	return nil
+}
+
+// compactPositions numbers the user's photos from zero keeping their order, so that no gaps are left
+// by deleted ones.
+func compactPositions(ctx context.Context, tx pgx.Tx, uuid string) error {
+	query := `
+UPDATE photos
+SET position = ordered.position - 1
+FROM (SELECT id, row_number() OVER (ORDER BY position, id) AS position FROM photos WHERE uuid = $1) AS ordered
+WHERE photos.id = ordered.id
+  AND photos.position <> ordered.position - 1
+`
+	if _, err := tx.Exec(ctx, query, uuid); err != nil {
+		return fmt.Errorf("err compacting photos positions for %s: %w", uuid, err)
+	}
+	return nil
+}
+
+// loadPhotos attaches ordered photos to the profiles.
+func (s *Storage) loadPhotos(ctx context.Context, profiles []*models.Profile) error {
+	if len(profiles) == 0 {
+		return nil
+	}
+	byUUID := make(map[string]*models.Personal, len(profiles))
+	uuids := make([]string, 0, len(profiles))
+	for _, p := range profiles {
+		if p.Personal != nil {
+			byUUID[p.UUID] = p.Personal
+			uuids = append(uuids, p.UUID)
+		}
+	}
+	var photos []PhotoOwner
+	err := pgxscan.Select(ctx, s.db, &photos,
+		`SELECT id, uuid, link, position FROM photos WHERE uuid = ANY($1) ORDER BY uuid, position, id`, uuids)
+	if err != nil {
+		return fmt.Errorf("err selecting photos: %w", err)
+	}
+	for i := range photos {
+		personal := byUUID[photos[i].UUID]
+		personal.Photos = append(personal.Photos, &models.Photo{
+			ID:       photos[i].ID,
+			Link:     photos[i].Link,
+			Position: photos[i].Position,
+		})
+	}
+	return nil
+}
+
+func uniqueIDs(ids []int64) map[int64]bool {
+	result := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		result[id] = true
+	}
+	return result
+}
+
+// SavePhotoUpload records the key presigned for the user to upload a photo with until expiresAt.
+func (s *Storage) SavePhotoUpload(ctx context.Context, uuid, key string, expiresAt time.Time) error {
+	query := `INSERT INTO photo_uploads (key, uuid, expires_at) VALUES ($1, $2, $3)`
+	if _, err := s.db.Exec(ctx, query, key, uuid, expiresAt.UTC()); err != nil {
+		return fmt.Errorf("err inserting photo upload %s: %w", key, err)
+	}
+	return nil
+}
+
+// DeleteOrphanPhotoUploads deletes up to limit records of uploads never confirmed which expired before the moment,
+// calling fn with their keys first, and returns their count. The records are locked meanwhile: confirmations
+// in flight keep their uploads, later ones find them swept. An error of fn keeps the records.
+func (s *Storage) DeleteOrphanPhotoUploads(ctx context.Context, before time.Time, limit int, fn func(keys []string) error) (int, error) { //nolint:lll
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return 0, fmt.Errorf("err deleting orphan photo uploads: %w", err)
+	}
+	defer func() {
+		if err = tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.log.Warnf("err rolling back tx during deleting orphan photo uploads: %v", err)
+		}
+	}()
+	var keys []string
+	query := `SELECT key
+FROM photo_uploads
+WHERE expires_at < $1 AND photo_id IS NULL
+ORDER BY expires_at
+LIMIT $2 FOR UPDATE SKIP LOCKED`
+	if err = pgxscan.Select(ctx, tx, &keys, query, before.UTC(), limit); err != nil {
+		return 0, fmt.Errorf("err selecting orphan photo uploads: %w", err)
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	if err = fn(keys); err != nil {
+		return 0, err
+	}
+	if _, err = tx.Exec(ctx, `DELETE FROM photo_uploads WHERE key = ANY($1)`, keys); err != nil {
+		return 0, fmt.Errorf("err deleting orphan photo uploads: %w", err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("err committing delete orphan photo uploads transaction: %w", err)
+	}
+	return len(keys), nil
+}
+
+// DeletePhotoUploadsBefore deletes up to limit records of uploads which expired before the moment and returns
+// their count.
+func (s *Storage) DeletePhotoUploadsBefore(ctx context.Context, before time.Time, limit int) (int64, error) {
+	query := `DELETE FROM photo_uploads WHERE key IN (SELECT key FROM photo_uploads WHERE expires_at < $1 LIMIT $2)`
+	res, err := s.db.Exec(ctx, query, before.UTC(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("err deleting photo uploads before %s: %w", before, err)
+	}
+	return res.RowsAffected(), nil
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+// SaveReaction sets the reaction of uuid to the message of their dialog with the peer, replacing a previous one.
+// ErrMessageNotFound if there is no such message in the dialog.
+func (s *Storage) SaveReaction(ctx context.Context, uuid, peer string, id int64, emoji string, at time.Time) error {
+	query := `
+INSERT INTO message_reactions (message_id, uuid, emoji, created_at)
+SELECT id, $2, $4, $5
+FROM message
+WHERE id = $1
+  AND ((sender = $2 AND receiver = $3) OR (sender = $3 AND receiver = $2))
+ON CONFLICT (message_id, uuid) DO UPDATE SET emoji = EXCLUDED.emoji, created_at = EXCLUDED.created_at
+`
+	res, err := s.db.Exec(ctx, query, id, uuid, peer, emoji, at)
+	if err != nil {
+		return fmt.Errorf("err saving reaction of %s to message %d: %w", uuid, id, err)
+	}
+	if res.RowsAffected() == 0 {
+		return common.ErrMessageNotFound
+	}
+	return nil
+}
+
+// DeleteReaction removes the reaction of uuid to the message of their dialog with the peer,
+// ErrReactionNotFound if there is none.
+func (s *Storage) DeleteReaction(ctx context.Context, uuid, peer string, id int64) error {
+	query := `
+DELETE
+FROM message_reactions r
+    USING message m
+WHERE r.message_id = $1
+  AND r.uuid = $2
+  AND m.id = r.message_id
+  AND ((m.sender = $2 AND m.receiver = $3) OR (m.sender = $3 AND m.receiver = $2))
+`
+	res, err := s.db.Exec(ctx, query, id, uuid, peer)
+	if err != nil {
+		return fmt.Errorf("err deleting reaction of %s to message %d: %w", uuid, id, err)
+	}
+	if res.RowsAffected() == 0 {
+		return common.ErrReactionNotFound
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// RepairMatchCounts recounts active matches of up to limit users following after in uuid order, as
+// CountActiveMatches counts them, and fixes counts which drifted. It returns the last uuid of the batch,
+// empty once there are no users left, and the number of counts fixed. Users of the batch are locked
+// like the count_matches trigger locks them first, so that relations changing meanwhile wait
+// for the recount rather than have their deltas overwritten by it.
+func (s *Storage) RepairMatchCounts(ctx context.Context, after string, limit int) (string, int64, error) {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return "", 0, fmt.Errorf("err repairing match counts after %q: %w", after, err)
+	}
+	defer func() {
+		if err = tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.log.Warnf("err rolling back tx during repairing match counts: %v", err)
+		}
+	}()
+	// in uuid order as the trigger takes them, the recount below sees changes committed before
+	lock := `
+SELECT count(pg_advisory_xact_lock(hashtext('match_counts:' || uuid)))
+FROM (SELECT uuid FROM config WHERE uuid > $1 ORDER BY uuid LIMIT $2) AS batch
+`
+	if _, err = tx.Exec(ctx, lock, after, limit); err != nil {
+		return "", 0, fmt.Errorf("err locking match counts after %q: %w", after, err)
+	}
+	query := `
+WITH batch AS (SELECT uuid FROM config WHERE uuid > $1 ORDER BY uuid LIMIT $2),
+     actual AS (SELECT batch.uuid, count(other.uuid) AS count
+                FROM batch
+                         LEFT JOIN relations AS own
+                                   ON own.uuid = batch.uuid AND NOT own.archived AND own.relation IN ($3, $4)
+                         LEFT JOIN relations AS other
+                                   ON other.uuid = own.target AND other.target = own.uuid AND other.relation IN ($3, $4)
+                GROUP BY batch.uuid),
+     fixed AS (
+         INSERT INTO match_counts (uuid, count)
+             SELECT actual.uuid, actual.count
+             FROM actual
+                      LEFT JOIN match_counts ON match_counts.uuid = actual.uuid
+             WHERE actual.count != COALESCE(match_counts.count, 0)
+             ON CONFLICT (uuid) DO UPDATE SET count = EXCLUDED.count
+             RETURNING 1)
+SELECT COALESCE((SELECT max(uuid) FROM batch), ''), (SELECT count(1) FROM fixed)
+`
+	var last string
+	var fixed int64
+	if err = tx.QueryRow(ctx, query, after, limit, Liked, SuperLiked).Scan(&last, &fixed); err != nil {
+		return "", 0, fmt.Errorf("err repairing match counts after %q: %w", after, err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return "", 0, fmt.Errorf("err committing repair match counts transaction: %w", err)
+	}
+	return last, fixed, nil
+}
+
+// DeleteOrphanedMatchCounts deletes up to limit match counts of users who no longer exist and returns their number.
+func (s *Storage) DeleteOrphanedMatchCounts(ctx context.Context, limit int) (int64, error) {
+	query := `
+DELETE
+FROM match_counts
+WHERE uuid IN (SELECT uuid
+               FROM match_counts
+               WHERE NOT EXISTS(SELECT 1 FROM config WHERE config.uuid = match_counts.uuid)
+               LIMIT $1)
+`
+	res, err := s.db.Exec(ctx, query, limit)
+	if err != nil {
+		return 0, fmt.Errorf("err deleting orphaned match counts: %w", err)
+	}
+	return res.RowsAffected(), nil
+}
+
+// DeleteUnmatchedChats deletes up to limit chat rows of users who no longer like each other and returns
+// their number. Messages are kept, a new match opens the chat again.
+func (s *Storage) DeleteUnmatchedChats(ctx context.Context, limit int) (int64, error) {
+	query := `
+DELETE
+FROM chat
+WHERE (uuid1, uuid2) IN (SELECT uuid1, uuid2
+                         FROM chat
+                         WHERE NOT EXISTS(SELECT 1
+                                          FROM relations AS own
+                                                   JOIN relations AS other
+                                                        ON other.uuid = own.target AND other.target = own.uuid
+                                          WHERE own.uuid = chat.uuid1
+                                            AND own.target = chat.uuid2
+                                            AND own.relation IN ($2, $3)
+                                            AND other.relation IN ($2, $3))
+                         LIMIT $1)
+`
+	res, err := s.db.Exec(ctx, query, limit, Liked, SuperLiked)
+	if err != nil {
+		return 0, fmt.Errorf("err deleting unmatched chats: %w", err)
+	}
+	return res.RowsAffected(), nil
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/jackc/pgx/v4"
+)
+
+// SaveMessageReport records the report of a message of the dialog of the reporter and the peer along with
+// a copy of the message, so that it's reviewable after the message is retracted or purged. Reporting
+// the message again updates the reason. ErrMessageNotFound if there is no such message in the dialog.
+func (s *Storage) SaveMessageReport(ctx context.Context, report *models.MessageReport, peer string) error {
+	query := `
+INSERT INTO message_reports (reporter, message_id, sender, body, sent_at, reason, created_at)
+SELECT $1, id, sender, coalesce(body, ''), coalesce(timestamp, $5), $4, $5
+FROM message
+WHERE id = $2
+  AND ((sender = $1 AND receiver = $3) OR (sender = $3 AND receiver = $1))
+ON CONFLICT (reporter, message_id) DO UPDATE SET reason = EXCLUDED.reason
+RETURNING id, sender, body, sent_at, created_at
+`
+	err := s.db.QueryRow(ctx, query, report.Reporter, report.MessageID, peer, report.Reason, report.CreatedAt).
+		Scan(&report.ID, &report.Sender, &report.Body, &report.SentAt, &report.CreatedAt)
+	switch {
+	case err == nil:
+	case errors.Is(err, pgx.ErrNoRows):
+		return common.ErrMessageNotFound
+	default:
+		return fmt.Errorf("err saving report of message %d by %s: %w", report.MessageID, report.Reporter, err)
+	}
+	return nil
+}
+
+func (s *Storage) ListMessageReports(ctx context.Context) ([]*models.MessageReport, error) {
+	var reports []*models.MessageReport
+	query := `
+SELECT id, reporter, message_id, sender, body, sent_at, reason, created_at
+FROM message_reports
+ORDER BY created_at DESC, id DESC
+`
+	if err := pgxscan.Select(ctx, s.db, &reports, query); err != nil {
+		return nil, fmt.Errorf("err listing message reports: %w", err)
+	}
+	return reports, nil
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/gerladeno/homie-core/internal/models"
+)
+
+// SaveRevokedToken revokes the token, revoking it again keeps the first revocation.
+func (s *Storage) SaveRevokedToken(ctx context.Context, token *models.RevokedToken) error {
+	query := `
+INSERT INTO revoked_tokens (jti, revoked_at, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (jti) DO NOTHING
+`
+	var expiresAt *time.Time
+	if token.ExpiresAt != nil {
+		expiresAt = &token.ExpiresAt.Time
+	}
+	if _, err := s.db.Exec(ctx, query, token.JTI, token.RevokedAt, expiresAt); err != nil {
+		return fmt.Errorf("err revoking token %s: %w", token.JTI, err)
+	}
+	return nil
+}
+
+// ListRevokedTokens returns the jti claims of revoked tokens not expired by now.
+func (s *Storage) ListRevokedTokens(ctx context.Context, now time.Time) ([]string, error) {
+	query := `SELECT jti FROM revoked_tokens WHERE expires_at IS NULL OR expires_at > $1`
+	var jtis []string
+	if err := pgxscan.Select(ctx, s.db, &jtis, query, now.UTC()); err != nil {
+		return nil, fmt.Errorf("err listing revoked tokens: %w", err)
+	}
+	return jtis, nil
+}
+
+// IsTokenRevoked reports whether the token of the jti is revoked and not expired by now.
+func (s *Storage) IsTokenRevoked(ctx context.Context, jti string, now time.Time) (bool, error) {
+	query := `
+SELECT exists(SELECT 1 FROM revoked_tokens WHERE jti = $1 AND (expires_at IS NULL OR expires_at > $2))
+`
+	var revoked bool
+	if err := s.db.QueryRow(ctx, query, jti, now.UTC()).Scan(&revoked); err != nil {
+		return false, fmt.Errorf("err checking revoked token %s: %w", jti, err)
+	}
+	return revoked, nil
+}
+
+// DeleteExpiredRevokedTokens deletes revocations of tokens expired by now, which are rejected anyway.
+func (s *Storage) DeleteExpiredRevokedTokens(ctx context.Context, now time.Time) (int64, error) {
+	tag, err := s.db.Exec(ctx, `DELETE FROM revoked_tokens WHERE expires_at <= $1`, now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("err deleting expired revoked tokens: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
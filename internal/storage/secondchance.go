@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/jackc/pgx/v4"
+)
+
+// GetSecondChance returns a profile the user disliked before dislikedBefore picked by the seed, nil if there
+// is none. The same seed picks the same profile while dislikes don't change. Profiles are filtered like
+// candidates of ListMatches, so hidden, paused and recently unmatched ones and those out of the search
+// criteria are left out.
+func (s *Storage) GetSecondChance(ctx context.Context, uuid, seed string, dislikedBefore, now, unmatchedSince time.Time, soft models.SoftFilters) (*models.Profile, error) { //nolint:lll
+	query, _, args := candidatesQuery(uuid, models.MatchQuery{Count: 1, Now: now, UnmatchedSince: unmatchedSince,
+		Sort: models.FeedSortNewest, Soft: soft}, dislikedBefore)
+	args = append(args, seed)
+	query += fmt.Sprintf("ORDER BY md5(search_criteria.uuid || $%d), search_criteria.uuid\nLIMIT $2\n", len(args))
+	var target string
+	err := s.db.QueryRow(ctx, query, args...).Scan(&target)
+	switch {
+	case err == nil:
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("err selecting second chance for %s: %w", uuid, err)
+	}
+	var profiles []*models.Profile
+	if err = s.getProfiles(ctx, &profiles, []string{target}); err != nil {
+		return nil, fmt.Errorf("err selecting second chance for %s: %w", uuid, err)
+	}
+	if len(profiles) == 0 {
+		return nil, nil
+	}
+	return profiles[0], nil
+}
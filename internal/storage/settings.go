@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/jackc/pgx/v4"
+)
+
+// GetSettings returns the user's settings, the default ones if the user hasn't saved any.
+func (s *Storage) GetSettings(ctx context.Context, uuid string) (*models.Settings, error) {
+	settings := models.DefaultSettings(uuid)
+	var quiet models.QuietHours
+	query := `
+SELECT coalesce(theme, 0), language, incognito, notify_matches, notify_messages, notify_likes, show_age, show_distance,
+       quiet_start, quiet_end, quiet_timezone
+FROM settings
+WHERE uuid = $1
+`
+	err := s.db.QueryRow(ctx, query, uuid).Scan(
+		&settings.Theme,
+		&settings.Language,
+		&settings.Incognito,
+		&settings.Notifications.Matches,
+		&settings.Notifications.Messages,
+		&settings.Notifications.Likes,
+		&settings.Privacy.ShowAge,
+		&settings.Privacy.ShowDistance,
+		&quiet.Start,
+		&quiet.End,
+		&quiet.Timezone,
+	)
+	switch {
+	case err == nil, errors.Is(err, pgx.ErrNoRows):
+	default:
+		return nil, fmt.Errorf("err getting settings for %s: %w", uuid, err)
+	}
+	if quiet.Start != "" {
+		settings.Notifications.QuietHours = &quiet
+	}
+	return settings, nil
+}
+
+// SaveSettings replaces the user's settings. ErrConfigNotFound if the user has no config yet.
+func (s *Storage) SaveSettings(ctx context.Context, settings *models.Settings) error {
+	query := `
+INSERT INTO settings (uuid, theme, language, incognito, notify_matches, notify_messages, notify_likes, show_age, show_distance,
+                      quiet_start, quiet_end, quiet_timezone)
+SELECT uuid, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12
+FROM config
+WHERE uuid = $1
+ON CONFLICT (uuid) DO UPDATE SET theme           = EXCLUDED.theme,
+                                 language        = EXCLUDED.language,
+                                 incognito       = EXCLUDED.incognito,
+                                 notify_matches  = EXCLUDED.notify_matches,
+                                 notify_messages = EXCLUDED.notify_messages,
+                                 notify_likes    = EXCLUDED.notify_likes,
+                                 show_age        = EXCLUDED.show_age,
+                                 show_distance   = EXCLUDED.show_distance,
+                                 quiet_start     = EXCLUDED.quiet_start,
+                                 quiet_end       = EXCLUDED.quiet_end,
+                                 quiet_timezone  = EXCLUDED.quiet_timezone
+`
+	var quiet models.QuietHours
+	if settings.Notifications.QuietHours != nil {
+		quiet = *settings.Notifications.QuietHours
+	}
+	res, err := s.db.Exec(ctx, query,
+		settings.UUID,
+		settings.Theme,
+		settings.Language,
+		settings.Incognito,
+		settings.Notifications.Matches,
+		settings.Notifications.Messages,
+		settings.Notifications.Likes,
+		settings.Privacy.ShowAge,
+		settings.Privacy.ShowDistance,
+		quiet.Start,
+		quiet.End,
+		quiet.Timezone,
+	)
+	if err != nil {
+		return fmt.Errorf("err saving settings for %s: %w", settings.UUID, err)
+	}
+	if res.RowsAffected() == 0 {
+		return common.ErrConfigNotFound
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/gerladeno/homie-core/internal/models"
+)
+
+// CountLike adds the like of the target by the user to the daily counters of the user's regions,
+// as a match too if the target likes the user back.
+func (s *Storage) CountLike(ctx context.Context, uuid, target string, at time.Time) error {
+	query := `
+INSERT INTO region_activity (region_id, day, likes, matches)
+SELECT region_id,
+       $3::date,
+       1,
+       CASE
+           WHEN EXISTS(SELECT 1 FROM relations WHERE uuid = $2 AND target = $1 AND relation IN ($4, $5)) THEN 1
+           ELSE 0 END
+FROM uuid_regions
+WHERE uuid = $1
+ON CONFLICT (region_id, day) DO UPDATE SET likes   = region_activity.likes + 1,
+                                           matches = region_activity.matches + excluded.matches
+`
+	if _, err := s.db.Exec(ctx, query, uuid, target, at, Liked, SuperLiked); err != nil {
+		return fmt.Errorf("err counting like of %s by %s: %w", target, uuid, err)
+	}
+	return nil
+}
+
+// GetRegionStats returns per region the number of users searching there whose profiles aren't paused
+// at now and the likes and matches of such users counted since the day of since.
+func (s *Storage) GetRegionStats(ctx context.Context, since, now time.Time) ([]*models.RegionStats, error) {
+	query := `
+SELECT r.id                         AS region_id,
+       r.name,
+       coalesce(u.active_users, 0) AS active_users,
+       coalesce(a.likes, 0)        AS likes,
+       coalesce(a.matches, 0)      AS matches
+FROM regions AS r
+         LEFT JOIN (SELECT ur.region_id, count(1) AS active_users
+                    FROM uuid_regions AS ur
+                             JOIN config AS c ON c.uuid = ur.uuid
+                    WHERE c.pause_until IS NULL
+                       OR c.pause_until <= $2
+                    GROUP BY ur.region_id) AS u ON u.region_id = r.id
+         LEFT JOIN (SELECT region_id, sum(likes) AS likes, sum(matches) AS matches
+                    FROM region_activity
+                    WHERE day >= $1::date
+                    GROUP BY region_id) AS a ON a.region_id = r.id
+ORDER BY r.id
+`
+	var stats []*models.RegionStats
+	if err := pgxscan.Select(ctx, s.db, &stats, query, since, now); err != nil {
+		return nil, fmt.Errorf("err getting region stats: %w", err)
+	}
+	return stats, nil
+}
+
+// GetUserTimeseries counts likes sent and received by the user and the user's matches by day in [from, to),
+// days without any are left out. A match happened when the later of both likes was made. Likes taken back
+// since aren't counted.
+func (s *Storage) GetUserTimeseries(ctx context.Context, uuid string, from, to time.Time) ([]*models.DayBucket, error) { //nolint:lll
+	query := fmt.Sprintf(`
+WITH sent AS (SELECT updated::date AS day, count(1) AS n
+              FROM relations
+              WHERE uuid = $1
+                AND relation IN (%[1]d, %[2]d)
+                AND updated >= $2
+                AND updated < $3
+              GROUP BY 1),
+     received AS (SELECT updated::date AS day, count(1) AS n
+                  FROM relations
+                  WHERE target = $1
+                    AND relation IN (%[1]d, %[2]d)
+                    AND updated >= $2
+                    AND updated < $3
+                  GROUP BY 1),
+     matched AS (SELECT greatest(own.updated, other.updated)::date AS day, count(1) AS n
+                 FROM relations AS own
+                          JOIN relations AS other ON other.uuid = own.target AND other.target = own.uuid
+                 WHERE own.uuid = $1
+                   AND own.relation IN (%[1]d, %[2]d)
+                   AND other.relation IN (%[1]d, %[2]d)
+                   AND greatest(own.updated, other.updated) >= $2
+                   AND greatest(own.updated, other.updated) < $3
+                 GROUP BY 1)
+SELECT days.day,
+       coalesce(sent.n, 0)     AS likes_sent,
+       coalesce(received.n, 0) AS likes_received,
+       coalesce(matched.n, 0)  AS matches
+FROM (SELECT day FROM sent UNION SELECT day FROM received UNION SELECT day FROM matched) AS days
+         LEFT JOIN sent ON sent.day = days.day
+         LEFT JOIN received ON received.day = days.day
+         LEFT JOIN matched ON matched.day = days.day
+ORDER BY days.day`, Liked, SuperLiked)
+	var buckets []*models.DayBucket
+	if err := pgxscan.Select(ctx, s.db, &buckets, query, uuid, from.UTC(), to.UTC()); err != nil {
+		return nil, fmt.Errorf("err getting timeseries of %s: %w", uuid, err)
+	}
+	return buckets, nil
+}
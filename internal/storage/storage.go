@@ -6,6 +6,7 @@ import (
 	"embed"
 	"errors"
 	"fmt"
+	"math"
 	"strings"
 	"time"
 
@@ -32,28 +33,56 @@ const (
 	Neither
 )
 
+// Action returns the name of the relation as a decision action, empty for Neither.
+func (r Relation) Action() string {
+	switch r {
+	case Liked:
+		return models.ActionLike
+	case SuperLiked:
+		return models.ActionSuperLike
+	case Disliked:
+		return models.ActionDislike
+	case Neither:
+	}
+	return ""
+}
+
 type Storage struct {
 	log     *logrus.Entry
-	db      *pgxpool.Pool
+	db      querier
 	dsn     string
 	metrics *metrics.DBClient
+	breaker *breaker
 }
 
-func New(ctx context.Context, log *logrus.Logger, dsn string) (*Storage, error) {
+type Option func(*Storage)
+
+func New(ctx context.Context, log *logrus.Logger, dsn string, opts ...Option) (*Storage, error) {
 	config, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
 		return nil, err
 	}
 	s := Storage{log: log.WithField("module", "storage"), dsn: dsn}
-	s.db, err = pgxpool.ConnectConfig(ctx, config)
+	for _, opt := range opts {
+		opt(&s)
+	}
+	pool, err := pgxpool.ConnectConfig(ctx, config)
 	if err != nil {
 		return nil, fmt.Errorf("err connecting to postgres: %w", err)
 	}
+	s.db = pool
 	fn := func() float64 {
 		return 1.0
 	}
 	s.metrics = metrics.NewDBClient(config.ConnConfig.Database, config.ConnConfig.Host,
 		fmt.Sprintf("%d", config.ConnConfig.Port), fn).AutoRegister()
+	if s.breaker != nil {
+		s.breaker.onChange = func(state breakerState) {
+			s.metrics.BreakerState.Set(float64(state))
+			s.log.Warnf("datastore breaker is %s", state)
+		}
+		s.db = &breakerDB{db: pool, breaker: s.breaker}
+	}
 	return &s, nil
 }
 
@@ -120,61 +149,103 @@ func (s *Storage) SaveConfig(ctx context.Context, config *models.Config) error {
 			s.log.Warnf("err rolling back tx during saving config: %v", err)
 		}
 	}()
-	if err = s.upsertConfig(ctx, tx, config); err != nil {
+	if err = s.saveConfig(ctx, tx, config); err != nil {
 		return fmt.Errorf("err saving config: %w", err)
 	}
-	if err = s.upsertSettings(ctx, tx, config.Settings); err != nil {
-		return fmt.Errorf("err saving config: %w", err)
-	}
-	if err = s.upsertPersonal(ctx, tx, config.Personal); err != nil {
-		return fmt.Errorf("err saving config: %w", err)
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("err committing save config transaction: %w", err)
 	}
-	if err = s.upsertCriteria(ctx, tx, config.Criteria); err != nil {
-		return fmt.Errorf("err saving config: %w", err)
+	return nil
+}
+
+// UpdateConfig saves the config returned by fn for the current one, an empty config if the user has none.
+// Concurrent updates of the user's config are applied one after another.
+func (s *Storage) UpdateConfig(ctx context.Context, uuid string, fn func(*models.Config) (*models.Config, error)) error {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return fmt.Errorf("err updating config: %w", err)
 	}
-	if config.Criteria != nil && config.Criteria.Regions != nil {
-		if err = s.updateCriteriaRegions(ctx, tx, config.Criteria.UUID, config.Criteria.Regions); err != nil {
-			return fmt.Errorf("err saving config: %w", err)
+	defer func() {
+		if err = tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.log.Warnf("err rolling back tx during updating config: %v", err)
 		}
+	}()
+	// the lock is taken before reading so that the current config includes updates committed meanwhile
+	if _, err = tx.Exec(ctx, `SELECT 1 FROM config WHERE uuid = $1 FOR UPDATE`, uuid); err != nil {
+		return fmt.Errorf("err locking config for %s: %w", uuid, err)
+	}
+	current, err := s.readConfig(ctx, tx, uuid)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrConfigNotFound):
+		current = &models.Config{UUID: uuid}
+	default:
+		return fmt.Errorf("err updating config: %w", err)
+	}
+	config, err := fn(current)
+	if err != nil {
+		return err
+	}
+	if err = s.saveConfig(ctx, tx, config); err != nil {
+		return fmt.Errorf("err updating config: %w", err)
 	}
 	if err = tx.Commit(ctx); err != nil {
-		return fmt.Errorf("err committing save config transaction: %w", err)
+		return fmt.Errorf("err committing update config transaction: %w", err)
 	}
 	return nil
 }
 
-func (s *Storage) upsertConfig(ctx context.Context, tx pgx.Tx, config *models.Config) error {
-	query := `
-INSERT INTO config (uuid, created, updated)
-VALUES ($1, $2, $3)
-ON CONFLICT (uuid) DO UPDATE SET updated = EXCLUDED.updated
-`
-	t := time.Now()
-	res, err := tx.Exec(ctx, query, config.UUID, t, t)
-	if err != nil {
-		return fmt.Errorf("err inserting config for %s: %w", config.UUID, err)
+func (s *Storage) saveConfig(ctx context.Context, tx pgx.Tx, config *models.Config) error {
+	if err := s.upsertConfig(ctx, tx, config); err != nil {
+		return err
 	}
-	if res.RowsAffected() == 0 {
-		return errors.New("err no rows affected while upserting config")
+	if err := s.upsertPersonal(ctx, tx, config.Personal); err != nil {
+		return err
+	}
+	if err := s.upsertCriteria(ctx, tx, config.Criteria); err != nil {
+		return err
+	}
+	if config.Criteria != nil && config.Criteria.Regions != nil {
+		return s.updateCriteriaRegions(ctx, tx, config.Criteria.UUID, config.Criteria.Regions, config.Criteria.RegionWeights)
 	}
 	return nil
 }
 
-func (s *Storage) upsertSettings(ctx context.Context, tx pgx.Tx, settings *models.Settings) error {
-	if settings == nil {
-		return nil
-	}
+func (s *Storage) upsertConfig(ctx context.Context, tx pgx.Tx, config *models.Config) error {
 	query := `
-INSERT INTO settings (uuid, theme)
-VALUES ($1, $2)
-ON CONFLICT (uuid) DO UPDATE SET theme = excluded.theme
+INSERT INTO config (uuid, created, updated, pause_until, travel_region_id, travel_latitude, travel_longitude, travel_until)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (uuid) DO UPDATE SET updated          = EXCLUDED.updated,
+                                 version          = config.version + 1,
+                                 pause_until      = EXCLUDED.pause_until,
+                                 travel_region_id = EXCLUDED.travel_region_id,
+                                 travel_latitude  = EXCLUDED.travel_latitude,
+                                 travel_longitude = EXCLUDED.travel_longitude,
+                                 travel_until     = EXCLUDED.travel_until
+RETURNING version
 `
-	res, err := tx.Exec(ctx, query, settings.UUID, settings.Theme)
-	if err != nil {
-		return fmt.Errorf("err inserting settings for %s: %w", settings.UUID, err)
+	// creation and update times are timestamptz, while timestamp columns drop the zone, so pause and travel
+	// times are always kept in UTC
+	t := time.Now()
+	var pauseUntil *time.Time
+	if config.PauseUntil != nil {
+		utc := config.PauseUntil.UTC()
+		pauseUntil = &utc
 	}
-	if res.RowsAffected() == 0 {
-		return errors.New("err no rows affected while upserting settings")
+	var (
+		travelRegionID                  *int64
+		travelLatitude, travelLongitude *float64
+		travelUntil                     *time.Time
+	)
+	if config.Travel != nil {
+		utc := config.Travel.ExpiresAt.UTC()
+		travelRegionID, travelLatitude, travelLongitude, travelUntil =
+			&config.Travel.RegionID, config.Travel.Latitude, config.Travel.Longitude, &utc
+	}
+	err := tx.QueryRow(ctx, query, config.UUID, t, t, pauseUntil, travelRegionID, travelLatitude, travelLongitude, travelUntil).
+		Scan(&config.Version)
+	if err != nil {
+		return fmt.Errorf("err inserting config for %s: %w", config.UUID, err)
 	}
 	return nil
 }
@@ -184,14 +255,30 @@ func (s *Storage) upsertPersonal(ctx context.Context, tx pgx.Tx, personal *model
 		return nil
 	}
 	query := `
-INSERT INTO personal (uuid, username, avatar_link, gender, age)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO personal (uuid, username, avatar_link, gender, age, bio, birthdate, latitude, longitude, bios)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 ON CONFLICT (uuid) DO UPDATE SET username = excluded.username,
 								 avatar_link = excluded.avatar_link,
 								 gender = excluded.gender,
-								 age = excluded.age
+								 age = excluded.age,
+								 bio = excluded.bio,
+								 bios = excluded.bios,
+								 birthdate = excluded.birthdate,
+								 latitude = excluded.latitude,
+								 longitude = excluded.longitude
 `
-	res, err := tx.Exec(ctx, query, personal.UUID, personal.Username, personal.AvatarLink, personal.Gender, personal.Age)
+	res, err := tx.Exec(ctx, query,
+		personal.UUID,
+		personal.Username,
+		personal.AvatarLink,
+		personal.Gender,
+		personal.Age,
+		personal.Bio,
+		personal.Birthdate,
+		personal.Latitude,
+		personal.Longitude,
+		personal.Bios,
+	)
 	if err != nil {
 		return fmt.Errorf("err inserting personal for %s: %w", personal.UUID, err)
 	}
@@ -231,7 +318,7 @@ ON CONFLICT (uuid) DO UPDATE SET price_from = excluded.price_from,
 	return nil
 }
 
-func (s *Storage) updateCriteriaRegions(ctx context.Context, tx pgx.Tx, uuid string, regions []int64) error {
+func (s *Storage) updateCriteriaRegions(ctx context.Context, tx pgx.Tx, uuid string, regions []int64, weights map[int64]float64) error { //nolint:lll
 	query := `
 DELETE FROM uuid_regions
 WHERE uuid = $1
@@ -242,11 +329,15 @@ WHERE uuid = $1
 	}
 	rows := make([][]interface{}, 0, len(regions))
 	for _, region := range regions {
-		rows = append(rows, []interface{}{uuid, region})
+		weight, ok := weights[region]
+		if !ok {
+			weight = 1
+		}
+		rows = append(rows, []interface{}{uuid, region, weight})
 	}
 	_, err = tx.CopyFrom(
 		ctx, pgx.Identifier{"uuid_regions"},
-		[]string{"uuid", "region_id"},
+		[]string{"uuid", "region_id", "weight"},
 		pgx.CopyFromRows(rows),
 	)
 	if err != nil {
@@ -256,31 +347,35 @@ WHERE uuid = $1
 }
 
 func (s *Storage) GetConfig(ctx context.Context, uuid string) (*models.Config, error) {
+	return s.readConfig(ctx, s.db, uuid)
+}
+
+// reader is what reads go through, the pool or a transaction they belong to.
+type reader interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+func (s *Storage) readConfig(ctx context.Context, db reader, uuid string) (*models.Config, error) {
 	var cfg models.Config
 	cfg.UUID = uuid
-	if err := s.getConfig(ctx, uuid); err != nil {
+	if err := s.getConfig(ctx, db, &cfg); err != nil {
 		return nil, err
 	}
-	settings := models.Settings{}
-	err := s.getSettings(ctx, uuid, &settings)
-	switch {
-	case err == nil:
-		cfg.Settings = &settings
-	case errors.Is(err, pgx.ErrNoRows):
-	default:
-		return nil, fmt.Errorf("err getting config for %s: %w", uuid, err)
-	}
 	personal := models.Personal{}
-	err = s.getPersonal(ctx, uuid, &personal)
+	err := s.getPersonal(ctx, db, uuid, &personal)
 	switch {
 	case err == nil:
 		cfg.Personal = &personal
+		if personal.Photos, err = s.listPhotos(ctx, db, uuid); err != nil {
+			return nil, fmt.Errorf("err getting config for %s: %w", uuid, err)
+		}
 	case errors.Is(err, pgx.ErrNoRows):
 	default:
 		return nil, fmt.Errorf("err getting config for %s: %w", uuid, err)
 	}
 	criteria := models.SearchCriteria{}
-	err = s.getSearchCriteria(ctx, uuid, &criteria)
+	err = s.getSearchCriteria(ctx, db, uuid, &criteria)
 	switch {
 	case err == nil:
 		cfg.Criteria = &criteria
@@ -291,32 +386,77 @@ func (s *Storage) GetConfig(ctx context.Context, uuid string) (*models.Config, e
 	return &cfg, nil
 }
 
-func (s *Storage) getConfig(ctx context.Context, uuid string) error {
-	row := s.db.QueryRow(ctx, `SELECT uuid FROM config WHERE uuid = $1`, uuid)
+func (s *Storage) getConfig(ctx context.Context, db reader, cfg *models.Config) error {
+	row := db.QueryRow(ctx, `SELECT uuid, version, pause_until FROM config WHERE uuid = $1`, cfg.UUID)
 	scannedUUID := ""
-	err := row.Scan(&scannedUUID)
+	err := row.Scan(&scannedUUID, &cfg.Version, &cfg.PauseUntil)
 	switch {
 	case err == nil:
 	case errors.Is(err, pgx.ErrNoRows):
 		return common.ErrConfigNotFound
 	default:
-		return fmt.Errorf("err getting config for %s: %w", uuid, err)
+		return fmt.Errorf("err getting config for %s: %w", cfg.UUID, err)
+	}
+	if cfg.Travel, err = s.getTravel(ctx, db, cfg.UUID); err != nil {
+		return fmt.Errorf("err getting config for %s: %w", cfg.UUID, err)
 	}
 	return nil
 }
 
-func (s *Storage) getSettings(ctx context.Context, uuid string, settings *models.Settings) error {
-	return pgxscan.Get(ctx, s.db, settings, `SELECT uuid, theme FROM settings WHERE uuid = $1`, uuid)
+// GetTravel returns the user's travel, nil if there is none. An expired travel is returned as is.
+func (s *Storage) GetTravel(ctx context.Context, uuid string) (*models.Travel, error) {
+	return s.getTravel(ctx, s.db, uuid)
 }
 
-func (s *Storage) getPersonal(ctx context.Context, uuid string, personal *models.Personal) error {
-	return pgxscan.Get(ctx, s.db, personal,
-		`SELECT uuid, username, avatar_link, gender, age FROM personal WHERE uuid = $1`, uuid)
+func (s *Storage) getTravel(ctx context.Context, db reader, uuid string) (*models.Travel, error) {
+	var (
+		regionID            *int64
+		latitude, longitude *float64
+		until               *time.Time
+	)
+	query := `SELECT travel_region_id, travel_latitude, travel_longitude, travel_until FROM config WHERE uuid = $1`
+	err := db.QueryRow(ctx, query, uuid).Scan(&regionID, &latitude, &longitude, &until)
+	switch {
+	case err == nil:
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("err getting travel of %s: %w", uuid, err)
+	}
+	if regionID == nil || until == nil {
+		return nil, nil
+	}
+	return &models.Travel{RegionID: *regionID, Latitude: latitude, Longitude: longitude, ExpiresAt: common.NewTimestamp(*until)}, nil
+}
+
+// ageColumn is the age of a person as of today in UTC, as models.Date.AgeAt counts it from the birthdate,
+// so that it doesn't go stale between saves. The saved age is used for people without a birthdate.
+const ageColumn = `coalesce(extract(YEAR FROM age((now() AT TIME ZONE 'utc')::date, personal.birthdate))::smallint,
+                personal.age)`
+
+func (s *Storage) getPersonal(ctx context.Context, db reader, uuid string, personal *models.Personal) error {
+	return pgxscan.Get(ctx, db, personal, `
+SELECT uuid, username, avatar_link, gender, `+ageColumn+` AS age, coalesce(bio, '') AS bio, bios, birthdate, latitude, longitude
+FROM personal
+WHERE uuid = $1`, uuid)
+}
+
+func (s *Storage) GetPersonal(ctx context.Context, uuid string) (*models.Personal, error) {
+	personal := models.Personal{}
+	err := s.getPersonal(ctx, s.db, uuid, &personal)
+	switch {
+	case err == nil:
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, common.ErrConfigNotFound
+	default:
+		return nil, fmt.Errorf("err getting personal for %s: %w", uuid, err)
+	}
+	return &personal, nil
 }
 
-func (s *Storage) getSearchCriteria(ctx context.Context, uuid string, criteria *models.SearchCriteria) error {
+func (s *Storage) getSearchCriteria(ctx context.Context, db reader, uuid string, criteria *models.SearchCriteria) error {
 	dbCriteria := SearchCriteria{}
-	err := pgxscan.Get(ctx, s.db, &dbCriteria,
+	err := pgxscan.Get(ctx, db, &dbCriteria,
 		`
 SELECT uuid,
        (select array (select distinct region_id from uuid_regions where uuid = $1)) as regions,
@@ -330,6 +470,17 @@ FROM search_criteria WHERE uuid = $2`, uuid, uuid)
 		return err
 	}
 	DBCriteria2Model(&dbCriteria, criteria)
+	var weights []RegionWeight
+	err = pgxscan.Select(ctx, db, &weights, `SELECT region_id, weight FROM uuid_regions WHERE uuid = $1`, uuid)
+	if err != nil {
+		return fmt.Errorf("err getting region weights for %s: %w", uuid, err)
+	}
+	if len(weights) > 0 {
+		criteria.RegionWeights = make(map[int64]float64, len(weights))
+		for _, w := range weights {
+			criteria.RegionWeights[w.RegionID] = w.Weight
+		}
+	}
 	return nil
 }
 
@@ -342,32 +493,444 @@ func (s *Storage) GetRegions(ctx context.Context) ([]*models.Region, error) {
 	return regions, nil
 }
 
+// UpsertRegion adds the region if it has no id yet and sets the id, otherwise updates the region of the id.
+func (s *Storage) UpsertRegion(ctx context.Context, region *models.Region, now time.Time) error {
+	if region.ID == 0 {
+		query := `
+INSERT INTO regions (created_at, updated_at, name, description)
+VALUES ($1, $1, $2, $3)
+RETURNING id
+`
+		if err := s.db.QueryRow(ctx, query, now, region.Name, region.Description).Scan(&region.ID); err != nil {
+			return fmt.Errorf("err adding region %s: %w", region.Name, err)
+		}
+		return nil
+	}
+	query := `UPDATE regions SET updated_at = $2, name = $3, description = $4 WHERE id = $1`
+	tag, err := s.db.Exec(ctx, query, region.ID, now, region.Name, region.Description)
+	if err != nil {
+		return fmt.Errorf("err updating region %d: %w", region.ID, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return common.ErrRegionNotFound
+	}
+	return nil
+}
+
+// UpsertRelation sets the relation of the user to the target, replacing the previous one in a single statement,
+// so that the target is never both liked and disliked.
 func (s *Storage) UpsertRelation(ctx context.Context, relation *models.Relation) error {
 	if relation == nil {
 		return nil
 	}
-	query := `
+	res, err := s.db.Exec(ctx, upsertRelationQuery, relation.UUID, relation.Target, relation.Relation)
+	if err != nil {
+		return fmt.Errorf("err inserting relation for %s and %s: %w", relation.UUID, relation.Target, err)
+	}
+	if res.RowsAffected() == 0 {
+		return errors.New("err no rows affected while upserting relation")
+	}
+	return nil
+}
+
+const upsertRelationQuery = `
 INSERT INTO relations (uuid, target, relation)
 VALUES ($1, $2, $3)
-ON CONFLICT (uuid, target) DO UPDATE SET relation = excluded.relation
+ON CONFLICT (uuid, target) DO UPDATE SET relation = excluded.relation,
+                                          archived = false,
+                                          updated  = now()
+`
+
+// UpsertLike upserts the like or super like as UpsertRelation does, ErrMatchLimitReached if it forms a match while
+// the user or the target has maxMatches active ones already, 0 means no limit. Both users are locked
+// like the count_matches trigger locks them, so that concurrent likes can't take either past the limit.
+func (s *Storage) UpsertLike(ctx context.Context, relation *models.Relation, maxMatches int64) error {
+	if relation == nil {
+		return nil
+	}
+	if maxMatches == 0 {
+		return s.UpsertRelation(ctx, relation)
+	}
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return fmt.Errorf("err upserting like: %w", err)
+	}
+	defer func() {
+		if err = tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.log.Warnf("err rolling back tx during upserting like: %v", err)
+		}
+	}()
+	// the users may have no match_counts rows yet, so their counts are locked instead, in uuid order
+	lock := `
+SELECT count(pg_advisory_xact_lock(hashtext('match_counts:' || uuid)))
+FROM (SELECT unnest($1::text[]) AS uuid ORDER BY uuid) AS pair
 `
-	res, err := s.db.Exec(ctx, query, relation.UUID, relation.Target, relation.Relation)
+	if _, err = tx.Exec(ctx, lock, []string{relation.UUID, relation.Target}); err != nil {
+		return fmt.Errorf("err locking match counts of %s and %s: %w", relation.UUID, relation.Target, err)
+	}
+	// the like counts towards the user's matches if the target likes back, and towards the target's
+	// ones unless the target has archived the match, as count_matches counts them
+	query := `
+SELECT COALESCE((SELECT count FROM match_counts WHERE uuid = $1), 0),
+       COALESCE((SELECT count FROM match_counts WHERE uuid = $2), 0),
+       COALESCE((SELECT relation IN ($3, $4) FROM relations WHERE uuid = $2 AND target = $1), false),
+       COALESCE((SELECT relation IN ($3, $4) AND NOT archived FROM relations WHERE uuid = $2 AND target = $1), false)
+`
+	var own, other int64
+	var ownMatch, otherMatch bool
+	err = tx.QueryRow(ctx, query, relation.UUID, relation.Target, Liked, SuperLiked).
+		Scan(&own, &other, &ownMatch, &otherMatch)
+	if err != nil {
+		return fmt.Errorf("err counting active matches of %s and %s: %w", relation.UUID, relation.Target, err)
+	}
+	if ownMatch && own >= maxMatches || otherMatch && other >= maxMatches {
+		return common.ErrMatchLimitReached
+	}
+	res, err := tx.Exec(ctx, upsertRelationQuery, relation.UUID, relation.Target, relation.Relation)
 	if err != nil {
 		return fmt.Errorf("err inserting relation for %s and %s: %w", relation.UUID, relation.Target, err)
 	}
 	if res.RowsAffected() == 0 {
 		return errors.New("err no rows affected while upserting relation")
 	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("err committing upsert like transaction: %w", err)
+	}
 	return nil
 }
 
-func (s *Storage) ListRelated(ctx context.Context, uuid string, relation Relation, limit, offset int64) ([]*models.Profile, error) { //nolint:lll
+func (s *Storage) GetRelation(ctx context.Context, uuid, target string) (Relation, error) {
+	var relation Relation
+	err := s.db.QueryRow(ctx, `SELECT relation FROM relations WHERE uuid = $1 AND target = $2`, uuid, target).
+		Scan(&relation)
+	switch {
+	case err == nil:
+	case errors.Is(err, pgx.ErrNoRows):
+		return Neither, nil
+	default:
+		return Neither, fmt.Errorf("err getting relation for %s and %s: %w", uuid, target, err)
+	}
+	return relation, nil
+}
+
+// CountActiveMatches returns the number of users liking the user back whose matches the user hasn't archived.
+// It reads the counter kept up to date by a trigger on relations instead of counting them.
+func (s *Storage) CountActiveMatches(ctx context.Context, uuid string) (int64, error) {
+	var count int64
+	err := s.db.QueryRow(ctx, `SELECT count FROM match_counts WHERE uuid = $1`, uuid).Scan(&count)
+	switch {
+	case err == nil:
+	case errors.Is(err, pgx.ErrNoRows):
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("err counting active matches for %s: %w", uuid, err)
+	}
+	return count, nil
+}
+
+// IsActiveMatch reports whether the users like each other, neither of them has archived the match or hidden the other.
+func (s *Storage) IsActiveMatch(ctx context.Context, uuid, target string) (bool, error) {
+	query := `
+SELECT EXISTS(SELECT 1
+              FROM relations AS own
+                       JOIN relations AS other ON other.uuid = own.target AND other.target = own.uuid
+              WHERE own.uuid = $1
+                AND own.target = $2
+                AND own.relation IN ($3, $4)
+                AND other.relation IN ($3, $4)
+                AND NOT own.archived
+                AND NOT other.archived)
+           AND NOT EXISTS(SELECT 1
+                          FROM hidden
+                          WHERE (uuid = $1 AND target = $2)
+                             OR (uuid = $2 AND target = $1))
+`
+	var matched bool
+	if err := s.db.QueryRow(ctx, query, uuid, target, Liked, SuperLiked).Scan(&matched); err != nil {
+		return false, fmt.Errorf("err checking match of %s and %s: %w", uuid, target, err)
+	}
+	return matched, nil
+}
+
+// GetRelationStates returns what relationships of the user to each of the targets are made of, by targets.
+func (s *Storage) GetRelationStates(ctx context.Context, uuid string, targets []string) (map[string]RelationState, error) { //nolint:lll
+	query := `
+SELECT targets.target,
+       coalesce((SELECT relation FROM relations WHERE uuid = $1 AND target = targets.target), $3) AS own,
+       coalesce((SELECT relation FROM relations WHERE uuid = targets.target AND target = $1), $3) AS other,
+       EXISTS(SELECT 1 FROM hidden WHERE uuid = $1 AND target = targets.target) AS hidden,
+       EXISTS(SELECT 1
+              FROM relations AS own
+                       JOIN relations AS other ON other.uuid = own.target AND other.target = own.uuid
+              WHERE own.uuid = $1
+                AND own.target = targets.target
+                AND own.relation IN ($4, $5)
+                AND other.relation IN ($4, $5)
+                AND NOT own.archived
+                AND NOT other.archived)
+           AND NOT EXISTS(SELECT 1
+                          FROM hidden
+                          WHERE (uuid = $1 AND target = targets.target)
+                             OR (uuid = targets.target AND target = $1)) AS matched
+FROM (SELECT DISTINCT target FROM unnest($2::text[]) AS target) AS targets
+`
+	var rows []RelationState
+	if err := pgxscan.Select(ctx, s.db, &rows, query, uuid, targets, Neither, Liked, SuperLiked); err != nil {
+		return nil, fmt.Errorf("err getting relations of %s: %w", uuid, err)
+	}
+	states := make(map[string]RelationState, len(rows))
+	for _, row := range rows {
+		states[row.Target] = row
+	}
+	return states, nil
+}
+
+// GetConnectionRegions returns regions searched by at least minConnections users who have an active match
+// with both the user and the target. The users themselves are never returned.
+func (s *Storage) GetConnectionRegions(ctx context.Context, uuid, target string, minConnections int) ([]int64, error) { //nolint:lll
+	query := `
+WITH matched AS (SELECT own.uuid, own.target
+                 FROM relations AS own
+                          JOIN relations AS other ON other.uuid = own.target AND other.target = own.uuid
+                 WHERE own.uuid = ANY ($1)
+                   AND own.target <> ALL ($1)
+                   AND own.relation IN ($3, $4)
+                   AND other.relation IN ($3, $4)
+                   AND NOT own.archived
+                   AND NOT other.archived
+                   AND NOT EXISTS(SELECT 1
+                                  FROM hidden
+                                  WHERE (hidden.uuid = own.uuid AND hidden.target = own.target)
+                                     OR (hidden.uuid = own.target AND hidden.target = own.uuid))),
+     connections AS (SELECT target FROM matched GROUP BY target HAVING count(DISTINCT uuid) = 2)
+SELECT regions.region_id
+FROM connections
+         JOIN uuid_regions AS regions ON regions.uuid = connections.target
+GROUP BY regions.region_id
+HAVING count(1) >= $2
+ORDER BY regions.region_id
+`
+	regions := []int64{}
+	if err := pgxscan.Select(ctx, s.db, &regions, query, []string{uuid, target}, minConnections, Liked, SuperLiked); err != nil {
+		return nil, fmt.Errorf("err getting connection regions of %s and %s: %w", uuid, target, err)
+	}
+	return regions, nil
+}
+
+// CountPendingLikes counts likes of the user given after since which the targets haven't liked back.
+func (s *Storage) CountPendingLikes(ctx context.Context, uuid string, since time.Time) (int64, error) {
+	query := `
+SELECT count(1)
+FROM relations AS own
+         LEFT JOIN relations AS other ON other.uuid = own.target AND other.target = own.uuid
+WHERE own.uuid = $1
+  AND own.relation IN ($2, $3)
+  AND own.updated > $4
+  AND (other.relation IS NULL OR other.relation NOT IN ($2, $3))
+`
+	var count int64
+	if err := s.db.QueryRow(ctx, query, uuid, Liked, SuperLiked, since).Scan(&count); err != nil {
+		return 0, fmt.Errorf("err counting pending likes for %s: %w", uuid, err)
+	}
+	return count, nil
+}
+
+func (s *Storage) ArchiveMatch(ctx context.Context, uuid, target string) error {
+	query := `
+UPDATE relations
+SET archived = true
+WHERE uuid = $1
+  AND target = $2
+  AND relation IN ($3, $4)
+  AND EXISTS(SELECT 1 FROM relations WHERE uuid = $2 AND target = $1 AND relation IN ($3, $4))
+`
+	res, err := s.db.Exec(ctx, query, uuid, target, Liked, SuperLiked)
+	if err != nil {
+		return fmt.Errorf("err archiving match of %s and %s: %w", uuid, target, err)
+	}
+	if res.RowsAffected() == 0 {
+		return common.ErrMatchNotFound
+	}
+	return nil
+}
+
+func (s *Storage) GetMatch(ctx context.Context, uuid, target string) (*models.Match, error) {
+	query := `
+SELECT greatest(own.updated, other.updated)                            AS matched_at,
+       own.relation = $3 OR other.relation = $3                        AS super_like,
+       EXISTS(SELECT 1
+              FROM message
+              WHERE (sender = $1 AND receiver = $2)
+                 OR (sender = $2 AND receiver = $1))                   AS has_messages
+FROM relations AS own
+         JOIN relations AS other ON other.uuid = own.target AND other.target = own.uuid
+WHERE own.uuid = $1
+  AND own.target = $2
+  AND own.relation IN ($3, $4)
+  AND other.relation IN ($3, $4)
+`
+	var match models.Match
+	err := s.db.QueryRow(ctx, query, uuid, target, SuperLiked, Liked).
+		Scan(&match.MatchedAt, &match.SuperLike, &match.HasMessages)
+	switch {
+	case err == nil:
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, common.ErrMatchNotFound
+	default:
+		return nil, fmt.Errorf("err getting match of %s and %s: %w", uuid, target, err)
+	}
+	var profiles []*models.Profile
+	if err = s.getProfiles(ctx, &profiles, []string{target}); err != nil {
+		return nil, fmt.Errorf("err getting match of %s and %s: %w", uuid, target, err)
+	}
+	if len(profiles) > 0 {
+		match.Profile = profiles[0]
+	}
+	return &match, nil
+}
+
+// ListActiveMatches returns active matches of the user with their latest messages and unread counts, those
+// with the latest activity first. Chat state of every match is selected at once and profiles by a single query.
+func (s *Storage) ListActiveMatches(ctx context.Context, uuid string, limit, offset int64) ([]*models.MatchWithState, error) { //nolint:lll
+	query := `
+SELECT own.target                                AS uuid,
+       greatest(own.updated, other.updated)      AS matched_at,
+       own.relation = $2 OR other.relation = $2  AS super_like,
+       last.sender                               AS last_sender,
+       last.timestamp                            AS last_timestamp,
+       last.body                                 AS last_body,
+       coalesce(chat.unread_count, 0)            AS unread_count
+FROM relations AS own
+         JOIN relations AS other ON other.uuid = own.target AND other.target = own.uuid
+         LEFT JOIN chat ON chat.uuid1 = own.uuid AND chat.uuid2 = own.target
+         LEFT JOIN LATERAL (SELECT sender, timestamp, body
+                            FROM message
+                            WHERE (sender = own.uuid AND receiver = own.target)
+                               OR (sender = own.target AND receiver = own.uuid)
+                            ORDER BY timestamp DESC, id DESC
+                            LIMIT 1) AS last ON true
+WHERE own.uuid = $1
+  AND own.relation IN ($2, $3)
+  AND other.relation IN ($2, $3)
+  AND NOT own.archived
+  AND NOT other.archived
+  AND own.target NOT IN (SELECT target FROM hidden WHERE uuid = $1)
+  AND own.target NOT IN (SELECT uuid FROM hidden WHERE target = $1)
+ORDER BY greatest(last.timestamp, own.updated, other.updated) DESC, own.target
+LIMIT $4 OFFSET $5
+`
+	var rows []struct {
+		UUID          string
+		MatchedAt     time.Time
+		SuperLike     bool
+		LastSender    *string
+		LastTimestamp *time.Time
+		LastBody      *string
+		UnreadCount   int64
+	}
+	if err := pgxscan.Select(ctx, s.db, &rows, query, uuid, SuperLiked, Liked, limit, offset); err != nil {
+		return nil, fmt.Errorf("err selecting active matches of %s: %w", uuid, err)
+	}
+	matches := make([]*models.MatchWithState, 0, len(rows))
+	uuids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		match := &models.MatchWithState{
+			Match:       models.Match{MatchedAt: common.NewTimestamp(row.MatchedAt), SuperLike: row.SuperLike},
+			UnreadCount: row.UnreadCount,
+		}
+		if row.LastSender != nil {
+			match.HasMessages = true
+			match.LastMessage = &models.MessagePreview{
+				Sender:    *row.LastSender,
+				Timestamp: common.NewTimestamp(*row.LastTimestamp),
+				Body:      *row.LastBody,
+			}
+		}
+		matches = append(matches, match)
+		uuids = append(uuids, row.UUID)
+	}
+	var profiles []*models.Profile
+	if err := s.getProfiles(ctx, &profiles, uuids); err != nil {
+		return nil, fmt.Errorf("err selecting active matches of %s: %w", uuid, err)
+	}
+	byUUID := make(map[string]*models.Profile, len(profiles))
+	for _, p := range profiles {
+		byUUID[p.UUID] = p
+	}
+	for i, match := range matches {
+		match.Profile = byUUID[uuids[i]]
+	}
+	return matches, nil
+}
+
+func (s *Storage) Hide(ctx context.Context, uuid, target string) error {
+	query := `
+INSERT INTO hidden (uuid, target)
+VALUES ($1, $2)
+ON CONFLICT (uuid, target) DO NOTHING
+`
+	if _, err := s.db.Exec(ctx, query, uuid, target); err != nil {
+		return fmt.Errorf("err hiding %s for %s: %w", target, uuid, err)
+	}
+	return nil
+}
+
+func (s *Storage) IsHidden(ctx context.Context, uuid, target string) (bool, error) {
+	var hidden bool
+	err := s.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM hidden WHERE uuid = $1 AND target = $2)`, uuid, target).
+		Scan(&hidden)
+	if err != nil {
+		return false, fmt.Errorf("err checking whether %s is hidden for %s: %w", target, uuid, err)
+	}
+	return hidden, nil
+}
+
+func (s *Storage) Unhide(ctx context.Context, uuid, target string) error {
+	if _, err := s.db.Exec(ctx, `DELETE FROM hidden WHERE uuid = $1 AND target = $2`, uuid, target); err != nil {
+		return fmt.Errorf("err unhiding %s for %s: %w", target, uuid, err)
+	}
+	return nil
+}
+
+// DeleteDislike removes the dislike of the target by the user, ErrDislikeNotFound if there is none.
+func (s *Storage) DeleteDislike(ctx context.Context, uuid, target string) error {
+	res, err := s.db.Exec(ctx, `DELETE FROM relations WHERE uuid = $1 AND target = $2 AND relation = $3`,
+		uuid, target, Disliked)
+	if err != nil {
+		return fmt.Errorf("err deleting dislike of %s by %s: %w", target, uuid, err)
+	}
+	if res.RowsAffected() == 0 {
+		return common.ErrDislikeNotFound
+	}
+	return nil
+}
+
+// ResetDecisions removes likes and dislikes of the user but those of matches, returning the number removed.
+// Hidden profiles stay hidden.
+func (s *Storage) ResetDecisions(ctx context.Context, uuid string) (int64, error) {
+	query := `
+DELETE
+FROM relations AS own
+WHERE own.uuid = $1
+  AND NOT (own.relation IN ($2, $3)
+    AND EXISTS(SELECT 1 FROM relations AS other
+               WHERE other.uuid = own.target AND other.target = own.uuid AND other.relation IN ($2, $3)))
+`
+	res, err := s.db.Exec(ctx, query, uuid, Liked, SuperLiked)
+	if err != nil {
+		return 0, fmt.Errorf("err resetting decisions of %s: %w", uuid, err)
+	}
+	return res.RowsAffected(), nil
+}
+
+func (s *Storage) ListRelated(ctx context.Context, uuid string, relation Relation, period models.TimeRange, limit, offset int64) ([]*models.Profile, error) { //nolint:lll
 	var uuids []string
-	query := `SELECT target FROM relations WHERE uuid = $1 AND relation = $2`
+	periodCondition, periodArgs := timeRangeCondition("updated", period, 3)
+	query := `SELECT target FROM relations WHERE uuid = $1 AND relation = $2` + periodCondition
 	if limit != 0 {
 		query += fmt.Sprintf("\nLIMIT %d OFFSET %d", limit, offset)
 	}
-	err := pgxscan.Select(ctx, s.db, &uuids, query, uuid, relation)
+	err := pgxscan.Select(ctx, s.db, &uuids, query, append([]interface{}{uuid, relation}, periodArgs...)...)
 	switch {
 	case err == nil:
 	case errors.Is(err, pgx.ErrNoRows):
@@ -383,8 +946,82 @@ func (s *Storage) ListRelated(ctx context.Context, uuid string, relation Relatio
 	return result, nil
 }
 
+// ListDecisions lists the user's relations of the given types made within the period, most recent first,
+// and their total count. A positive countCap stops counting past it, the total is then an estimate equal to the cap.
+func (s *Storage) ListDecisions(ctx context.Context, uuid string, relations []Relation, period models.TimeRange, limit, offset, countCap int64) ([]*models.Decision, models.Total, error) { //nolint:lll
+	types := make([]int16, 0, len(relations))
+	for _, relation := range relations {
+		types = append(types, int16(relation))
+	}
+	periodCondition, periodArgs := timeRangeCondition("updated", period, 3)
+	args := append([]interface{}{uuid, types}, periodArgs...)
+	var total models.Total
+	query := `SELECT count(1) FROM relations WHERE uuid = $1 AND relation = ANY($2)` + periodCondition
+	if countCap > 0 {
+		query = fmt.Sprintf(`SELECT count(1)
+FROM (SELECT 1 FROM relations WHERE uuid = $1 AND relation = ANY($2)%s LIMIT %d) AS capped`, periodCondition, countCap+1)
+	}
+	err := s.db.QueryRow(ctx, query, args...).Scan(&total.Count)
+	if err != nil {
+		return nil, total, fmt.Errorf("err counting decisions of %s: %w", uuid, err)
+	}
+	if countCap > 0 && total.Count > countCap {
+		total = models.Total{Count: countCap, Estimate: true}
+	}
+	var rows []DecisionRow
+	query = `
+SELECT target, relation, updated
+FROM relations
+WHERE uuid = $1
+  AND relation = ANY($2)` + periodCondition + `
+ORDER BY updated DESC, target`
+	if limit != 0 {
+		query += fmt.Sprintf("\nLIMIT %d OFFSET %d", limit, offset)
+	}
+	if err = pgxscan.Select(ctx, s.db, &rows, query, args...); err != nil {
+		return nil, total, fmt.Errorf("err selecting decisions of %s: %w", uuid, err)
+	}
+	uuids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		uuids = append(uuids, row.Target)
+	}
+	var profiles []*models.Profile
+	if err = s.getProfiles(ctx, &profiles, uuids); err != nil {
+		return nil, total, fmt.Errorf("err selecting decisions of %s: %w", uuid, err)
+	}
+	byUUID := make(map[string]*models.Profile, len(profiles))
+	for _, p := range profiles {
+		byUUID[p.UUID] = p
+	}
+	result := make([]*models.Decision, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, &models.Decision{
+			Profile:   byUUID[row.Target],
+			Action:    Relation(row.Relation).Action(),
+			DecidedAt: common.NewTimestamp(row.Updated),
+		})
+	}
+	return result, total, nil
+}
+
+// timeRangeCondition returns conditions restricting the column to the range and their args, which are
+// numbered from n on. Bounds are passed in UTC as timestamp columns drop the zone.
+func timeRangeCondition(column string, period models.TimeRange, n int) (string, []interface{}) {
+	var condition string
+	var args []interface{}
+	if !period.From.IsZero() {
+		condition += fmt.Sprintf(" AND %s >= $%d", column, n+len(args))
+		args = append(args, period.From.UTC())
+	}
+	if !period.To.IsZero() {
+		condition += fmt.Sprintf(" AND %s <= $%d", column, n+len(args))
+		args = append(args, period.To.UTC())
+	}
+	return condition, args
+}
+
 func (s *Storage) getProfiles(ctx context.Context, profiles *[]*models.Profile, uuids []string) error {
-	if uuids == nil {
+	if len(uuids) == 0 {
 		return nil
 	}
 	var dbProfiles []Profile
@@ -400,9 +1037,18 @@ SELECT criteria.uuid   AS uuid,
        username,
        avatar_link,
        personal.gender AS personal_gender,
-       age
+       age,
+       bio,
+       bios,
+       birthdate,
+       latitude,
+       longitude,
+       config.created  AS joined_at
 FROM (SELECT search_criteria.uuid,
-       (select array (select distinct region_id from uuid_regions where uuid IN (%[1]s))) as regions,
+       (select array (select distinct region_id
+                      from uuid_regions
+                      where uuid = search_criteria.uuid
+                      order by region_id)) as regions,
        price_from,
        price_to,
        gender,
@@ -410,9 +1056,13 @@ FROM (SELECT search_criteria.uuid,
        age_to
 FROM search_criteria
 WHERE search_criteria.uuid IN (%[1]s)) AS criteria
-JOIN (SELECT uuid, username, avatar_link, gender, age FROM personal WHERE uuid IN (%[1]s)
+JOIN (SELECT uuid, username, avatar_link, gender, %[2]s AS age, coalesce(bio, '') AS bio, bios, birthdate, latitude, longitude
+      FROM personal
+      WHERE uuid IN (%[1]s)
 ) AS personal
-ON personal.uuid = criteria.uuid`, quotedUUIDs)
+ON personal.uuid = criteria.uuid
+JOIN config ON config.uuid = criteria.uuid
+ORDER BY array_position(ARRAY [%[1]s], criteria.uuid)`, quotedUUIDs, ageColumn)
 	err := pgxscan.Select(ctx, s.db, &dbProfiles, query)
 	switch {
 	case err == nil:
@@ -423,6 +1073,9 @@ ON personal.uuid = criteria.uuid`, quotedUUIDs)
 	default:
 		return fmt.Errorf("err getting profiles: %w", err)
 	}
+	if err = s.loadPhotos(ctx, *profiles); err != nil {
+		return fmt.Errorf("err getting profiles: %w", err)
+	}
 	return nil
 }
 
@@ -434,42 +1087,78 @@ func (s *Storage) GetProfiles(ctx context.Context, uuids []string) ([]*models.Pr
 	return profiles, nil
 }
 
-func (s *Storage) ListMatches(ctx context.Context, uuid string, count int64) ([]*models.Profile, error) {
+// matchBatchSize is the number of candidate profiles StreamMatches loads at once.
+const matchBatchSize = 100
+
+// ListMatches returns candidates for the user, see StreamMatches.
+func (s *Storage) ListMatches(ctx context.Context, uuid string, q models.MatchQuery) ([]*models.Profile, error) {
+	var result []*models.Profile
+	err := s.StreamMatches(ctx, uuid, q, func(p *models.Profile) error {
+		result = append(result, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// StreamMatches calls fn for up to q.Count candidates for the user's feed, loading their profiles in batches.
+// Candidates are left out as the query tells. They are in the sort order, boosted ones go first in the best
+// order, after those who super liked the user if the query puts them first, then those meeting the soft
+// criteria. Ties are broken by uuid, so the order is stable. An active travel of the user replaces
+// their regions.
+func (s *Storage) StreamMatches(ctx context.Context, uuid string, q models.MatchQuery, fn func(*models.Profile) error) error { //nolint:lll
+	uuids, err := s.matchUUIDs(ctx, uuid, q)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(uuids); start += matchBatchSize {
+		end := start + matchBatchSize
+		if end > len(uuids) {
+			end = len(uuids)
+		}
+		var batch []*models.Profile
+		if err = s.getProfiles(ctx, &batch, uuids[start:end]); err != nil {
+			return fmt.Errorf("err getting matches for %s: %w", uuid, err)
+		}
+		if err = s.markSuperLikes(ctx, uuid, batch); err != nil {
+			return fmt.Errorf("err getting matches for %s: %w", uuid, err)
+		}
+		for _, p := range batch {
+			if err = fn(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CountCandidates counts candidates for the user's feed, left out as by StreamMatches. A positive countCap stops
+// counting past it, the total is then an estimate equal to the cap.
+func (s *Storage) CountCandidates(ctx context.Context, uuid string, now, unmatchedSince, joinedSince time.Time, soft models.SoftFilters, countCap int64) (models.Total, error) { //nolint:lll
+	limit := int64(math.MaxInt64)
+	if countCap > 0 {
+		limit = countCap + 1
+	}
+	query, _, args := candidatesQuery(uuid, models.MatchQuery{Count: limit, Now: now, UnmatchedSince: unmatchedSince,
+		JoinedSince: joinedSince, Sort: models.FeedSortNewest, Soft: soft}, time.Time{})
+	// only counted up to the cap, which spares scanning every candidate past it
+	query = "SELECT count(*) FROM (" + query + "LIMIT $2) AS capped"
+	var total models.Total
+	if err := s.db.QueryRow(ctx, query, args...).Scan(&total.Count); err != nil {
+		return models.Total{}, fmt.Errorf("err counting candidates of %s: %w", uuid, err)
+	}
+	if countCap > 0 && total.Count > countCap {
+		total = models.Total{Count: countCap, Estimate: true}
+	}
+	return total, nil
+}
+
+func (s *Storage) matchUUIDs(ctx context.Context, uuid string, q models.MatchQuery) ([]string, error) {
+	query, orderBy, args := candidatesQuery(uuid, q, time.Time{})
 	var uuids []string
-	err := pgxscan.Select(ctx, s.db, &uuids,
-		`
-WITH uuids AS (SELECT DISTINCT uuid
-               FROM uuid_regions
-               WHERE region_id IN (SELECT region_id FROM uuid_regions WHERE uuid = $1)
-                 AND uuid NOT IN (SELECT DISTINCT target FROM relations WHERE uuid = $1)
-                 AND uuid != $1),
-     criteria AS (SELECT price_from, price_to, gender, age_from, age_to FROM search_criteria WHERE uuid = $1),
-     self AS (SELECT gender, age FROM personal WHERE uuid = $1)
-SELECT uuid
-FROM search_criteria
-WHERE 1 = 1
-  AND uuid IN (SELECT criteria.uuid as uuid
-               FROM (SELECT uuid
-                     FROM personal
-                     WHERE 1 = 1
-                       AND uuid IN (SELECT * FROM uuids)
-                       AND (gender = (SELECT gender FROM criteria) OR
-                            (SELECT gender FROM criteria) = 0) -- if 0 client doesn't care
-                       AND age >= (SELECT COALESCE(age_from, 0) FROM criteria)
-                       AND age <= (SELECT COALESCE(age_to, 999) FROM criteria)) AS personal
-                        JOIN (SELECT uuid
-                              FROM search_criteria
-                              WHERE 1 = 1
-                                AND uuid IN (SELECT * FROM uuids)
-                                AND COALESCE(price_from, 0) <= (SELECT COALESCE(price_to, 999999999999) FROM criteria)
-                                AND COALESCE(price_to, 999999999999) >=
-                                    (SELECT COALESCE(price_from, 0) FROM criteria)) AS criteria
-                             ON personal.uuid = criteria.uuid)
-  AND (gender = 0 OR gender = (SELECT gender FROM self))
-  AND COALESCE(age_from, 0) <= (SELECT age FROM self)
-  AND COALESCE(age_to, 999) >= (SELECT age FROM self)
-LIMIT $2
-`, uuid, count)
+	err := pgxscan.Select(ctx, s.db, &uuids, query+"ORDER BY "+orderBy+"\nLIMIT $2\n", args...)
 	switch {
 	case err == nil:
 	case errors.Is(err, pgx.ErrNoRows):
@@ -477,12 +1166,130 @@ LIMIT $2
 	default:
 		return nil, fmt.Errorf("err selecting matches by region: %w", err)
 	}
-	var result []*models.Profile
-	err = s.getProfiles(ctx, &result, uuids)
-	if err != nil {
-		return nil, fmt.Errorf("err getting matches for %s: %w", uuid, err)
+	return uuids, nil
+}
+
+// candidatesQuery returns the query of candidates for the user without ordering and limiting, the order of them
+// and the args, of which $2 is the count. Profiles the user has decided on are left out, unless dislikedBefore
+// is set: then the candidates are only those the user disliked before it.
+func candidatesQuery(uuid string, q models.MatchQuery, dislikedBefore time.Time) (string, string, []interface{}) {
+	args := []interface{}{uuid, q.Count, q.Now.UTC(), q.UnmatchedSince.UTC()}
+	orderBy := "(boosts.expires_at > $3) IS TRUE DESC, uuids.score DESC, search_criteria.uuid"
+	switch {
+	case q.Sort == models.FeedSortNewest:
+		orderBy = "config.created DESC, search_criteria.uuid"
+	case q.ShuffleSeed != "":
+		// candidates of the same tier are ordered by a hash of the seed instead of uuid
+		args = append(args, q.ShuffleSeed)
+		orderBy = "(boosts.expires_at > $3) IS TRUE DESC, uuids.score DESC, md5(search_criteria.uuid || $5), search_criteria.uuid"
 	}
-	return result, nil
+	// search criteria are mutual, both the candidate has to fit the user's and the other way round
+	ageCriteria := `(search_criteria.uuid IN (SELECT uuid
+                              FROM personal
+                              WHERE ` + ageColumn + ` BETWEEN (SELECT COALESCE(age_from, 0) FROM criteria)
+                                  AND (SELECT COALESCE(age_to, 999) FROM criteria))
+    AND COALESCE(search_criteria.age_from, 0) <= (SELECT age FROM self)
+    AND COALESCE(search_criteria.age_to, 999) >= (SELECT age FROM self))`
+	priceCriteria := `(COALESCE(search_criteria.price_from, 0) <= (SELECT COALESCE(price_to, 999999999999) FROM criteria)
+    AND COALESCE(search_criteria.price_to, 999999999999) >= (SELECT COALESCE(price_from, 0) FROM criteria))`
+	// hard criteria exclude candidates, soft ones only put those meeting them first
+	var where, ranks string
+	for _, criteria := range []struct {
+		condition string
+		soft      bool
+	}{{ageCriteria, q.Soft.Age}, {priceCriteria, q.Soft.Price}} {
+		if criteria.soft {
+			ranks += criteria.condition + " IS TRUE DESC, "
+		} else {
+			where += "\n  AND " + criteria.condition
+		}
+	}
+	orderBy = ranks + orderBy
+	if !q.JoinedSince.IsZero() {
+		args = append(args, q.JoinedSince.UTC())
+		where += fmt.Sprintf("\n  AND config.created >= $%d", len(args))
+	}
+	decided := `candidate.uuid NOT IN (SELECT DISTINCT target FROM relations WHERE uuid = $1)`
+	if !dislikedBefore.IsZero() {
+		args = append(args, dislikedBefore.UTC())
+		decided = fmt.Sprintf(`candidate.uuid IN (SELECT target
+                                        FROM relations
+                                        WHERE uuid = $1 AND relation = %d AND updated < $%d)
+                 AND candidate.uuid NOT IN (SELECT target FROM relations WHERE uuid = $1 AND relation != %[1]d)`,
+			Disliked, len(args))
+	}
+	// incognito candidates are shown only to those they liked
+	incognito := fmt.Sprintf(`candidate.uuid NOT IN (SELECT uuid
+                                     FROM settings
+                                     WHERE incognito
+                                       AND uuid NOT IN (SELECT uuid
+                                                        FROM relations
+                                                        WHERE target = $1 AND relation IN (%d, %d)))`, Liked, SuperLiked)
+	if q.SuperLikesFirst {
+		orderBy = fmt.Sprintf(`EXISTS(SELECT 1
+              FROM relations
+              WHERE uuid = search_criteria.uuid
+                AND target = $1
+                AND relation = %d) DESC, `, SuperLiked) + orderBy
+	}
+	query := `
+WITH travel AS (SELECT travel_region_id AS region_id FROM config WHERE uuid = $1 AND travel_until > $3),
+     -- an active travel replaces the user's own regions
+     own AS (SELECT region_id, weight
+             FROM uuid_regions
+             WHERE uuid = $1
+               AND NOT EXISTS(SELECT 1 FROM travel)
+             UNION ALL
+             SELECT region_id, 1 AS weight
+             FROM travel),
+     uuids AS (SELECT candidate.uuid, sum(own.weight) AS score
+               FROM uuid_regions AS candidate
+                        JOIN own ON own.region_id = candidate.region_id
+               WHERE ` + decided + `
+                 AND ` + incognito + `
+                 AND candidate.uuid NOT IN (SELECT target FROM hidden WHERE uuid = $1)
+                 AND candidate.uuid NOT IN (SELECT uuid FROM config WHERE pause_until > $3)
+                 AND candidate.uuid NOT IN (SELECT target FROM unmatches WHERE uuid = $1 AND unmatched_at > $4)
+                 AND candidate.uuid NOT IN (SELECT uuid FROM unmatches WHERE target = $1 AND unmatched_at > $4)
+                 AND candidate.uuid != $1
+               GROUP BY candidate.uuid),
+     criteria AS (SELECT price_from, price_to, gender, age_from, age_to FROM search_criteria WHERE uuid = $1),
+     self AS (SELECT gender, ` + ageColumn + ` AS age FROM personal WHERE uuid = $1)
+SELECT search_criteria.uuid
+FROM search_criteria
+         JOIN uuids ON uuids.uuid = search_criteria.uuid
+         JOIN config ON config.uuid = search_criteria.uuid
+         LEFT JOIN boosts ON boosts.uuid = search_criteria.uuid
+WHERE search_criteria.uuid IN (SELECT uuid
+                               FROM personal
+                               WHERE (gender = (SELECT gender FROM criteria) OR
+                                      (SELECT gender FROM criteria) = 0)) -- if 0 client doesn't care
+  AND (search_criteria.gender = 0 OR search_criteria.gender = (SELECT gender FROM self))` + where + "\n"
+	return query, orderBy, args
+}
+
+// markSuperLikes sets SuperLikedYou of the profiles which super liked the user.
+func (s *Storage) markSuperLikes(ctx context.Context, uuid string, profiles []*models.Profile) error {
+	if len(profiles) == 0 {
+		return nil
+	}
+	uuids := make([]string, 0, len(profiles))
+	for _, p := range profiles {
+		uuids = append(uuids, p.UUID)
+	}
+	var superLikers []string
+	query := `SELECT uuid FROM relations WHERE target = $1 AND relation = $2 AND uuid = ANY ($3)`
+	if err := pgxscan.Select(ctx, s.db, &superLikers, query, uuid, SuperLiked, uuids); err != nil {
+		return fmt.Errorf("err getting super likes of %s: %w", uuid, err)
+	}
+	superLiked := make(map[string]bool, len(superLikers))
+	for _, superLiker := range superLikers {
+		superLiked[superLiker] = true
+	}
+	for _, p := range profiles {
+		p.SuperLikedYou = superLiked[p.UUID]
+	}
+	return nil
 }
 
 func wrapQuoted(elems []string) []string {
@@ -1,6 +1,11 @@
 package storage
 
-import "github.com/gerladeno/homie-core/internal/models"
+import (
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
 
 type SearchCriteria struct {
 	UUID      string   `db:"uuid"`
@@ -25,18 +30,63 @@ func DBCriteria2Model(dbCriteria *SearchCriteria, criteria *models.SearchCriteri
 	criteria.AgeRange = models.Range{From: dbCriteria.AgeFrom, To: dbCriteria.AgeTo}
 }
 
+// RelationState is what the relationship of a user to the target is made of. Matched is true for an active match.
+type RelationState struct {
+	Target  string   `db:"target"`
+	Own     Relation `db:"own"`
+	Other   Relation `db:"other"`
+	Hidden  bool     `db:"hidden"`
+	Matched bool     `db:"matched"`
+}
+
+type RegionWeight struct {
+	RegionID int64   `db:"region_id"`
+	Weight   float64 `db:"weight"`
+}
+
+type DecisionRow struct {
+	Target   string    `db:"target"`
+	Relation int16     `db:"relation"`
+	Updated  time.Time `db:"updated"`
+}
+
+type LikeRow struct {
+	UUID     string    `db:"uuid"`
+	Relation int16     `db:"relation"`
+	Updated  time.Time `db:"updated"`
+}
+
+type ViewRow struct {
+	Viewer string    `db:"viewer"`
+	Viewed time.Time `db:"viewed"`
+	Views  int64     `db:"views"`
+}
+
+type PhotoOwner struct {
+	ID       int64  `db:"id"`
+	UUID     string `db:"uuid"`
+	Link     string `db:"link"`
+	Position int16  `db:"position"`
+}
+
 type Profile struct {
-	UUID           string   `db:"uuid"`
-	Regions        []int64  `db:"regions"`
-	PriceFrom      *float64 `db:"price_from"`
-	PriceTo        *float64 `db:"price_to"`
-	CriteriaGender int8     `db:"criteria_gender"`
-	AgeFrom        *float64 `db:"age_from"`
-	AgeTo          *float64 `db:"age_to"`
-	Username       string   `db:"username"`
-	AvatarLink     string   `db:"avatar_link"`
-	PersonalGender int8     `db:"personal_gender"`
-	Age            int8     `db:"age"`
+	UUID           string            `db:"uuid"`
+	Regions        []int64           `db:"regions"`
+	PriceFrom      *float64          `db:"price_from"`
+	PriceTo        *float64          `db:"price_to"`
+	CriteriaGender int8              `db:"criteria_gender"`
+	AgeFrom        *float64          `db:"age_from"`
+	AgeTo          *float64          `db:"age_to"`
+	Username       string            `db:"username"`
+	AvatarLink     string            `db:"avatar_link"`
+	PersonalGender int8              `db:"personal_gender"`
+	Age            int8              `db:"age"`
+	Bio            string            `db:"bio"`
+	Bios           map[string]string `db:"bios"`
+	Birthdate      *models.Date      `db:"birthdate"`
+	Latitude       *float64          `db:"latitude"`
+	Longitude      *float64          `db:"longitude"`
+	JoinedAt       *time.Time        `db:"joined_at"`
 }
 
 func DBProfile2Profile(profile *Profile) *models.Profile {
@@ -51,6 +101,11 @@ func DBProfile2Profile(profile *Profile) *models.Profile {
 			AvatarLink: profile.AvatarLink,
 			Gender:     models.Gender(profile.PersonalGender),
 			Age:        profile.Age,
+			Bio:        profile.Bio,
+			Bios:       profile.Bios,
+			Birthdate:  profile.Birthdate,
+			Latitude:   profile.Latitude,
+			Longitude:  profile.Longitude,
 		},
 		Criteria: &models.SearchCriteria{
 			UUID:       profile.UUID,
@@ -59,6 +114,7 @@ func DBProfile2Profile(profile *Profile) *models.Profile {
 			Gender:     models.Gender(profile.CriteriaGender),
 			AgeRange:   models.Range{From: profile.AgeFrom, To: profile.AgeTo},
 		},
+		JoinedAt: common.NewTimestampPtr(profile.JoinedAt),
 	}
 	return &p
 }
@@ -0,0 +1,20 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SaveUnmatch records the last time the user unmatched or hid the target.
+func (s *Storage) SaveUnmatch(ctx context.Context, uuid, target string, at time.Time) error {
+	query := `
+INSERT INTO unmatches (uuid, target, unmatched_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (uuid, target) DO UPDATE SET unmatched_at = EXCLUDED.unmatched_at
+`
+	if _, err := s.db.Exec(ctx, query, uuid, target, at.UTC()); err != nil {
+		return fmt.Errorf("err saving unmatch of %s and %s: %w", uuid, target, err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+// RecordProfileView records a view of the target's profile by the viewer at the moment. A view coming
+// no later than window after the recorded one of the pair is a repeated one, which changes nothing.
+func (s *Storage) RecordProfileView(ctx context.Context, viewer, target string, at time.Time, window time.Duration) error {
+	query := `
+INSERT INTO profile_views (viewer, target, viewed)
+VALUES ($1, $2, $3)
+ON CONFLICT (viewer, target) DO UPDATE SET viewed = EXCLUDED.viewed,
+                                           views  = profile_views.views + 1
+WHERE profile_views.viewed < $4`
+	if _, err := s.db.Exec(ctx, query, viewer, target, at.UTC(), at.Add(-window).UTC()); err != nil {
+		return fmt.Errorf("err recording view of %s by %s: %w", target, viewer, err)
+	}
+	return nil
+}
+
+// ListProfileViewers lists up to limit viewers of the user, most recent view first. Viewers hidden by or hiding
+// the user are left out, as are those paused at the moment now.
+func (s *Storage) ListProfileViewers(ctx context.Context, uuid string, now time.Time, limit int64) ([]*models.ProfileView, error) { //nolint:lll
+	var rows []ViewRow
+	query := `
+SELECT v.viewer, v.viewed, v.views
+FROM profile_views AS v
+WHERE v.target = $1
+  AND NOT EXISTS(SELECT 1
+                 FROM hidden
+                 WHERE (hidden.uuid = $1 AND hidden.target = v.viewer)
+                    OR (hidden.uuid = v.viewer AND hidden.target = $1))
+  AND NOT EXISTS(SELECT 1 FROM config WHERE config.uuid = v.viewer AND config.pause_until > $2)
+ORDER BY v.viewed DESC, v.viewer DESC
+LIMIT $3`
+	if err := pgxscan.Select(ctx, s.db, &rows, query, uuid, now.UTC(), limit); err != nil {
+		return nil, fmt.Errorf("err selecting viewers of %s: %w", uuid, err)
+	}
+	uuids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		uuids = append(uuids, row.Viewer)
+	}
+	var profiles []*models.Profile
+	if err := s.getProfiles(ctx, &profiles, uuids); err != nil {
+		return nil, fmt.Errorf("err selecting viewers of %s: %w", uuid, err)
+	}
+	byUUID := make(map[string]*models.Profile, len(profiles))
+	for _, p := range profiles {
+		byUUID[p.UUID] = p
+	}
+	result := make([]*models.ProfileView, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, &models.ProfileView{
+			UUID:     row.Viewer,
+			Profile:  byUUID[row.Viewer],
+			ViewedAt: common.NewTimestamp(row.Viewed),
+			Views:    row.Views,
+		})
+	}
+	return result, nil
+}
+
+// DeleteProfileViewsBefore deletes up to limit views last made before the moment and returns their count.
+func (s *Storage) DeleteProfileViewsBefore(ctx context.Context, before time.Time, limit int) (int64, error) {
+	query := `
+DELETE
+FROM profile_views
+WHERE (viewer, target) IN (SELECT viewer, target FROM profile_views WHERE viewed < $1 LIMIT $2)
+`
+	res, err := s.db.Exec(ctx, query, before.UTC(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("err deleting profile views before %s: %w", before, err)
+	}
+	return res.RowsAffected(), nil
+}
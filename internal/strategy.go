@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"fmt"
+
+	"github.com/gerladeno/homie-core/internal/models"
+)
+
+// MatchStrategy decides how search criteria and ranking combine when picking candidates for matches
+// and the feed, markets choose one by name.
+type MatchStrategy interface {
+	Name() string
+	// SoftFilters returns the criteria which rank candidates instead of excluding them.
+	SoftFilters() models.SoftFilters
+}
+
+// Match strategies by name, see MatchStrategyByName.
+const (
+	StrategyStrict      = "strict"
+	StrategyBalanced    = "balanced"
+	StrategyExploratory = "exploratory"
+)
+
+// strictStrategy excludes candidates by every criterion, it is the default.
+type strictStrategy struct{}
+
+func (strictStrategy) Name() string { return StrategyStrict }
+
+func (strictStrategy) SoftFilters() models.SoftFilters { return models.SoftFilters{} }
+
+// balancedStrategy lets candidates out of the price range in after those within it.
+type balancedStrategy struct{}
+
+func (balancedStrategy) Name() string { return StrategyBalanced }
+
+func (balancedStrategy) SoftFilters() models.SoftFilters { return models.SoftFilters{Price: true} }
+
+// exploratoryStrategy excludes candidates by gender and regions only, ranking those fitting the age and
+// price ranges first.
+type exploratoryStrategy struct{}
+
+func (exploratoryStrategy) Name() string { return StrategyExploratory }
+
+func (exploratoryStrategy) SoftFilters() models.SoftFilters {
+	return models.SoftFilters{Age: true, Price: true}
+}
+
+var matchStrategies = []MatchStrategy{strictStrategy{}, balancedStrategy{}, exploratoryStrategy{}}
+
+// MatchStrategyByName returns the shipped strategy of the name.
+func MatchStrategyByName(name string) (MatchStrategy, error) {
+	for _, strategy := range matchStrategies {
+		if strategy.Name() == name {
+			return strategy, nil
+		}
+	}
+	return nil, fmt.Errorf("err unknown match strategy %q", name)
+}
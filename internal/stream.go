@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gerladeno/homie-core/pkg/chat"
+)
+
+// maxStreamChats caps the chats a stream joins on connect if they're open, most recently messaged first.
+// Others are joined once messaged or opened by the peer.
+const maxStreamChats = 100
+
+// ServeChatStream upgrades the request to a stream of all chats of the user. Chats joined on connect and
+// ones messaged over the stream are subject to the checks of GetDialog. Errors are returned before the
+// upgrade only, once upgraded it returns when the stream is closed.
+func (a *App) ServeChatStream(w http.ResponseWriter, r *http.Request, uuid string, hooks chat.StreamHooks) error {
+	ctx := r.Context()
+	peers, err := a.chatServer.GetAllChats(ctx, uuid, true, maxStreamChats, 0)
+	if err != nil {
+		return fmt.Errorf("err getting list of uuids client chatted with: %w", err)
+	}
+	if a.matchOnlyChats {
+		matched := peers[:0]
+		for _, peer := range peers {
+			ok, err := a.store.IsActiveMatch(ctx, uuid, peer)
+			if err != nil {
+				return fmt.Errorf("err checking match: %w", err)
+			}
+			if ok {
+				matched = append(matched, peer)
+			}
+		}
+		peers = matched
+	}
+	hooks.Dial = func(ctx context.Context, peer string) (*chat.Hub, error) {
+		return a.GetDialog(ctx, uuid, peer)
+	}
+	a.chatServer.ServeStream(uuid, peers, hooks, w, r)
+	return nil
+}
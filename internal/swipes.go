@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/gerladeno/homie-core/pkg/logging"
+)
+
+// WithSwipeCadence challenges users who like or dislike more than count times within the window, which
+// no human does: their likes and dislikes are rejected for the cooldown. Zero count disables the check.
+func WithSwipeCadence(count int, window, cooldown time.Duration) Option {
+	return func(a *App) {
+		a.swipes.limit = count
+		a.swipes.window = window
+		a.swipes.cooldown = cooldown
+	}
+}
+
+// checkSwipeCadence counts a like or dislike of the user and returns ErrSwipingTooFast if the user swipes
+// faster than allowed or is still cooling down.
+func (a *App) checkSwipeCadence(ctx context.Context, uuid string) error {
+	if a.swipes.limit == 0 {
+		return nil
+	}
+	challenged, err := a.swipes.swipe(uuid, a.now())
+	if challenged {
+		logging.FromContext(ctx, a.log).WithField("uuid", uuid).
+			Warnf("suspicious swiping: more than %d swipes within %s", a.swipes.limit, a.swipes.window)
+	}
+	return err
+}
+
+// swipeTracker keeps recent swipe times of every user within the window and cooldowns of challenged ones.
+type swipeTracker struct {
+	limit    int
+	window   time.Duration
+	cooldown time.Duration
+
+	mx    sync.Mutex
+	users map[string]*userSwipes
+	// sweptAt is when idle users were last dropped.
+	sweptAt time.Time
+}
+
+type userSwipes struct {
+	times []time.Time
+	// coolingUntil is when swipes are allowed again after a challenge.
+	coolingUntil time.Time
+}
+
+// swipe records a swipe at now unless the user is cooling down. It tells whether the swipe triggered
+// a challenge, the error is set for every rejected swipe.
+func (t *swipeTracker) swipe(uuid string, now time.Time) (bool, error) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.users == nil {
+		t.users = make(map[string]*userSwipes)
+	}
+	t.forgetIdle(now)
+	user, ok := t.users[uuid]
+	if !ok {
+		user = &userSwipes{}
+		t.users[uuid] = user
+	}
+	if now.Before(user.coolingUntil) {
+		return false, common.ErrSwipingTooFast
+	}
+	since := now.Add(-t.window)
+	recent := user.times[:0]
+	for _, at := range user.times {
+		if at.After(since) {
+			recent = append(recent, at)
+		}
+	}
+	user.times = append(recent, now)
+	if len(user.times) <= t.limit {
+		return false, nil
+	}
+	user.times = nil
+	user.coolingUntil = now.Add(t.cooldown)
+	return true, common.ErrSwipingTooFast
+}
+
+// coolingUntil returns when the user's cooldown ends, zero if the user isn't cooling down at now.
+func (t *swipeTracker) coolingUntil(uuid string, now time.Time) time.Time {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	user, ok := t.users[uuid]
+	if !ok || !now.Before(user.coolingUntil) {
+		return time.Time{}
+	}
+	return user.coolingUntil
+}
+
+// forgetIdle drops users with neither swipes within the window nor a cooldown once a window, so that
+// the tracker holds active users only.
+func (t *swipeTracker) forgetIdle(now time.Time) {
+	if now.Sub(t.sweptAt) < t.window {
+		return
+	}
+	t.sweptAt = now
+	since := now.Add(-t.window)
+	for uuid, user := range t.users {
+		if now.Before(user.coolingUntil) {
+			continue
+		}
+		if len(user.times) == 0 || !user.times[len(user.times)-1].After(since) {
+			delete(t.users, uuid)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+const (
+	// defaultTimeseriesDays is a week in review, maxTimeseriesDays keeps a series to a season.
+	defaultTimeseriesDays = 7
+	maxTimeseriesDays     = 90
+)
+
+// GetUserTimeseries returns the user's likes sent and received and matches by UTC day from the day of from
+// through the day of to, days without any have zero counts so that the series is continuous. Zero to means now
+// and zero from a week till to. A range of more than maxTimeseriesDays days is rejected.
+func (a *App) GetUserTimeseries(ctx context.Context, uuid string, from, to time.Time) ([]models.DayBucket, error) {
+	if to.IsZero() {
+		to = a.now()
+	}
+	last := utcDay(to)
+	first := last.AddDate(0, 0, 1-defaultTimeseriesDays)
+	if !from.IsZero() {
+		first = utcDay(from)
+	}
+	if first.After(last) {
+		return nil, fmt.Errorf("%w: from is after to", common.ErrInvalidTimeRange)
+	}
+	days := int(last.Sub(first).Hours()/24) + 1
+	if days > maxTimeseriesDays {
+		return nil, fmt.Errorf("%w: %d days is more than %d", common.ErrInvalidTimeRange, days, maxTimeseriesDays)
+	}
+	counts, err := a.store.GetUserTimeseries(ctx, uuid, first, last.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, fmt.Errorf("err getting timeseries: %w", err)
+	}
+	byDay := make(map[time.Time]*models.DayBucket, len(counts))
+	for _, bucket := range counts {
+		byDay[bucket.Day.Time] = bucket
+	}
+	series := make([]models.DayBucket, 0, days)
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		bucket := models.DayBucket{Day: models.NewDate(day.Year(), day.Month(), day.Day())}
+		if counted, ok := byDay[day]; ok {
+			bucket.LikesSent, bucket.LikesReceived, bucket.Matches = counted.LikesSent, counted.LikesReceived, counted.Matches
+		}
+		series = append(series, bucket)
+	}
+	return series, nil
+}
+
+// utcDay returns the midnight in UTC of the day of t in UTC.
+func utcDay(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,184 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+// BlobStore keeps objects clients upload directly with presigned URLs instead of through the service.
+type BlobStore interface {
+	// PresignUpload returns a URL and fields of a form to upload the object with within ttl, which is only let
+	// through with a content type starting with contentTypePrefix and of up to maxSize bytes.
+	PresignUpload(ctx context.Context, key, contentTypePrefix string, maxSize int64, ttl time.Duration) (string, map[string]string, error) //nolint:lll
+	Exists(ctx context.Context, key string) (bool, error)
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+	// SignedURL returns a link of the object valid for ttl.
+	SignedURL(key string, ttl time.Duration) string
+}
+
+const (
+	// defaultUploadTTL is how long a presigned photo upload URL is valid for.
+	defaultUploadTTL = 15 * time.Minute
+	// maxPhotoSize is the size in bytes a directly uploaded photo is limited to.
+	maxPhotoSize = 10 << 20
+	// photoContentType is what the content type of a directly uploaded photo starts with.
+	photoContentType = "image/"
+)
+
+// WithBlobStore lets clients upload photos to the store directly with URLs valid for ttl,
+// zero ttl means 15m. Without it photos are added as links only.
+func WithBlobStore(blobs BlobStore, ttl time.Duration) Option {
+	return func(a *App) {
+		a.blobs = blobs
+		a.uploadTTL = ttl
+		if a.uploadTTL <= 0 {
+			a.uploadTTL = defaultUploadTTL
+		}
+	}
+}
+
+// WithSignedPhotoURLs serves photos uploaded to the blob store with links valid for ttl, signed anew on every
+// request, instead of their permanent ones, which a private bucket doesn't serve. Zero ttl keeps permanent links,
+// which is the default. Photos added as links elsewhere are served as they are.
+func WithSignedPhotoURLs(ttl time.Duration) Option {
+	return func(a *App) {
+		a.photoURLTTL = ttl
+	}
+}
+
+// signPhotos returns copies of the photos with links of the blob store signed, so that photos shared
+// with a feed snapshot stay intact. Already signed links are signed anew.
+func (a *App) signPhotos(photos []*models.Photo) []*models.Photo {
+	if a.blobs == nil || a.photoURLTTL <= 0 || len(photos) == 0 {
+		return photos
+	}
+	base := a.blobs.URL("")
+	result := make([]*models.Photo, 0, len(photos))
+	for _, photo := range photos {
+		signed := *photo
+		if key := strings.TrimPrefix(photo.Link, base); key != photo.Link {
+			key, _, _ = strings.Cut(key, "?")
+			signed.Link = a.blobs.SignedURL(key, a.photoURLTTL)
+		}
+		result = append(result, &signed)
+	}
+	return result
+}
+
+// projectProfile projects the profile shown to another user and signs its photos.
+func (a *App) projectProfile(p *models.Profile) {
+	p.Project(a.publicFields)
+	if p != nil && p.Personal != nil {
+		p.Personal.Photos = a.signPhotos(p.Personal.Photos)
+	}
+}
+
+// photoKeyPrefix returns the prefix of keys of the user's uploaded photos.
+func photoKeyPrefix(uuid string) string {
+	return "photos/" + uuid + "/"
+}
+
+// PresignPhoto returns a URL the user uploads a photo to, which is added once confirmed with its key.
+func (a *App) PresignPhoto(ctx context.Context, uuid string) (*models.PhotoUpload, error) {
+	if a.blobs == nil {
+		return nil, common.ErrUploadsDisabled
+	}
+	id, err := a.ids.NewID()
+	if err != nil {
+		return nil, fmt.Errorf("err generating photo key: %w", err)
+	}
+	key := photoKeyPrefix(uuid) + id
+	expiresAt := a.now().Add(a.uploadTTL)
+	// recorded first, so that the upload is swept if never confirmed
+	if err = a.store.SavePhotoUpload(ctx, uuid, key, expiresAt); err != nil {
+		return nil, fmt.Errorf("err saving photo upload: %w", err)
+	}
+	url, fields, err := a.blobs.PresignUpload(ctx, key, photoContentType, maxPhotoSize, a.uploadTTL)
+	if err != nil {
+		return nil, fmt.Errorf("err presigning photo upload: %w", err)
+	}
+	return &models.PhotoUpload{Key: key, URL: url, Fields: fields, ExpiresAt: common.NewTimestamp(expiresAt)}, nil
+}
+
+// ConfirmPhoto adds the photo uploaded to the presigned URL of the key as AddPhoto does. The key is confirmed
+// once, confirming it again returns the same photo. Uploads are confirmed up to uploadTTL after they expire,
+// later they are swept by SweepPhotoUploads.
+func (a *App) ConfirmPhoto(ctx context.Context, uuid, key string, replaceOldest bool) (*models.Photo, error) {
+	if a.blobs == nil {
+		return nil, common.ErrUploadsDisabled
+	}
+	if !strings.HasPrefix(key, photoKeyPrefix(uuid)) {
+		return nil, common.ErrForeignPhoto
+	}
+	exists, err := a.blobs.Exists(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("err checking uploaded photo: %w", err)
+	}
+	if !exists {
+		return nil, common.ErrUploadNotFound
+	}
+	photo, err := a.store.ConfirmPhotoUpload(ctx, uuid, key, a.blobs.URL(key), a.uploadsSweptBefore(), a.maxPhotos,
+		replaceOldest)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrUploadNotFound), errors.Is(err, common.ErrPhotoLimitReached):
+		return nil, err
+	default:
+		return nil, fmt.Errorf("err confirming photo: %w", err)
+	}
+	return a.signPhotos([]*models.Photo{photo})[0], nil
+}
+
+// uploadsSweptBefore returns the moment uploads which expired before are swept.
+func (a *App) uploadsSweptBefore() time.Time {
+	return a.now().Add(-a.uploadTTL)
+}
+
+// RunUploadsSweep sweeps uploads never confirmed every interval until the context is done.
+func (a *App) RunUploadsSweep(ctx context.Context, interval time.Duration) {
+	if a.blobs == nil {
+		return
+	}
+	a.runEvery(ctx, interval, "uploads sweep", a.SweepPhotoUploads)
+}
+
+// SweepPhotoUploads deletes objects uploaded but never confirmed which expired a while ago from the blob store,
+// forgets the expired uploads and returns the count of objects deleted.
+func (a *App) SweepPhotoUploads(ctx context.Context) (int64, error) {
+	if a.blobs == nil {
+		return 0, nil
+	}
+	before := a.uploadsSweptBefore()
+	var total int64
+	for {
+		// objects are deleted while their uploads are locked, so that they aren't confirmed meanwhile
+		count, err := a.store.DeleteOrphanPhotoUploads(ctx, before, purgeBatchSize, func(keys []string) error {
+			for _, key := range keys {
+				if err := a.blobs.Delete(ctx, key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return total, fmt.Errorf("err sweeping photo uploads: %w", err)
+		}
+		total += int64(count)
+		if count < purgeBatchSize {
+			break
+		}
+	}
+	if _, err := deleteInBatches(ctx, func(ctx context.Context, limit int) (int64, error) {
+		return a.store.DeletePhotoUploadsBefore(ctx, before, limit)
+	}); err != nil {
+		return total, fmt.Errorf("err sweeping photo uploads: %w", err)
+	}
+	return total, nil
+}
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	defaultMaxUsernameLength = 50
+	// maxCombiningMarks is the number of combining marks a character of a username may carry, enough for
+	// diacritics of any script, while stacks of them make zalgo text.
+	maxCombiningMarks = 2
+	// zeroWidthJoiner joins emoji into sequences, other format characters only hide or reorder text.
+	zeroWidthJoiner = '\u200d'
+	// blackFlag starts an emoji tag sequence of tag characters, such as the flags of England or Scotland.
+	blackFlag = '\U0001f3f4'
+)
+
+// isTag reports whether the format character is a tag, tags are only allowed in tag sequences.
+func isTag(r rune) bool {
+	return r >= '\U000e0020' && r <= '\U000e007f'
+}
+
+// WithMaxUsernameLength limits the number of characters of usernames, 50 by default. Zero means unlimited.
+func WithMaxUsernameLength(length int) Option {
+	return func(a *App) {
+		a.username.maxLength = length
+	}
+}
+
+// WithUsernameRules allows printable ASCII usernames only if asciiOnly and normalizes usernames to NFC
+// before checking them if nfc, as by default. Control and format characters, and characters with more
+// than 2 combining marks, are rejected whatever the rules.
+func WithUsernameRules(asciiOnly, nfc bool) Option {
+	return func(a *App) {
+		a.username.asciiOnly = asciiOnly
+		a.username.nfc = nfc
+	}
+}
+
+type usernamePolicy struct {
+	maxLength int
+	asciiOnly bool
+	nfc       bool
+}
+
+// checkUsername normalizes the username by the policy and returns ErrInvalidUsername if it breaks it,
+// unless it's the stored username, so that tightening the policy doesn't break saves of other fields.
+func (a *App) checkUsername(personal *models.Personal, stored *storedPersonal) error {
+	if a.username.nfc {
+		personal.Username = norm.NFC.String(personal.Username)
+	}
+	err := a.username.check(personal.Username)
+	if err == nil {
+		return nil
+	}
+	previous, serr := stored.get()
+	switch {
+	case serr != nil:
+		return serr
+	case previous != nil && previous.Username == personal.Username:
+		return nil
+	}
+	return &common.FieldError{Field: models.FieldUsername, Err: err}
+}
+
+func (p usernamePolicy) check(username string) error {
+	if length := utf8.RuneCountInString(username); p.maxLength > 0 && length > p.maxLength {
+		return fmt.Errorf("%w: %d characters, at most %d allowed", common.ErrInvalidUsername, length, p.maxLength)
+	}
+	marks := 0
+	var prev rune
+	for _, r := range username {
+		tagged := isTag(r) && (prev == blackFlag || isTag(prev))
+		prev = r
+		switch {
+		case tagged:
+		case unicode.IsControl(r) || unicode.Is(unicode.Cf, r) && r != zeroWidthJoiner:
+			return fmt.Errorf("%w: invisible character %U", common.ErrInvalidUsername, r)
+		case p.asciiOnly && (r > unicode.MaxASCII || !unicode.IsPrint(r)):
+			return fmt.Errorf("%w: non-ASCII character %U", common.ErrInvalidUsername, r)
+		case unicode.In(r, unicode.Mn, unicode.Me):
+			if marks++; marks > maxCombiningMarks {
+				return fmt.Errorf("%w: more than %d combining marks in a row", common.ErrInvalidUsername, maxCombiningMarks)
+			}
+		default:
+			marks = 0
+		}
+	}
+	return nil
+}
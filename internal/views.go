@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gerladeno/homie-core/internal/models"
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
+const (
+	// defaultViewsWindow counts a user looking through a profile several times in a row as a single view.
+	defaultViewsWindow = time.Hour
+	// defaultViewsRetention keeps views for a season, lists of viewers are about recent interest.
+	defaultViewsRetention = 90 * 24 * time.Hour
+	// defaultViewersPageSize and maxViewersPageSize keep lists of viewers as short as those of incoming likes.
+	defaultViewersPageSize = 20
+	maxViewersPageSize     = 100
+)
+
+// WithProfileViewsWindow sets how long repeated views of a profile by the same viewer count as one,
+// zero counts every view.
+func WithProfileViewsWindow(window time.Duration) Option {
+	return func(a *App) {
+		a.viewsWindow = window
+	}
+}
+
+// WithProfileViewsRetention makes RunViewsRetention purge views of profiles older than the age, 90 days
+// by default. Zero, or a negative age, keeps them forever.
+func WithProfileViewsRetention(age time.Duration) Option {
+	return func(a *App) {
+		a.viewsRetention = age
+	}
+}
+
+// RecordProfileView records that the viewer has fetched the target's profile. Views of incognito users,
+// of users' own profiles and of admins impersonating the viewer aren't recorded.
+func (a *App) RecordProfileView(ctx context.Context, viewerUUID, targetUUID string) error {
+	if viewerUUID == targetUUID || common.Impersonator(ctx) != "" {
+		return nil
+	}
+	settings, err := a.store.GetSettings(ctx, viewerUUID)
+	if err != nil {
+		return fmt.Errorf("err recording profile view: %w", err)
+	}
+	if settings.Incognito {
+		return nil
+	}
+	if err = a.store.RecordProfileView(ctx, viewerUUID, targetUUID, a.now(), a.viewsWindow); err != nil {
+		return fmt.Errorf("err recording profile view: %w", err)
+	}
+	return nil
+}
+
+// ListProfileViewers returns up to limit recent viewers of the user, most recent first. Zero limit means
+// the default page size, larger ones than the maximum are capped. Viewers hidden by or hiding the user
+// and paused ones are left out.
+func (a *App) ListProfileViewers(ctx context.Context, uuid string, limit int64) ([]*models.ProfileView, error) {
+	switch {
+	case limit <= 0:
+		limit = defaultViewersPageSize
+	case limit > maxViewersPageSize:
+		limit = maxViewersPageSize
+	}
+	views, err := a.store.ListProfileViewers(ctx, uuid, a.now(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("err getting list of viewers: %w", err)
+	}
+	for _, view := range views {
+		if view.Profile != nil {
+			a.projectProfile(view.Profile)
+		}
+	}
+	return views, nil
+}
+
+// RunViewsRetention purges expired views of profiles every interval until the context is done.
+func (a *App) RunViewsRetention(ctx context.Context, interval time.Duration) {
+	if a.viewsRetention <= 0 {
+		return
+	}
+	a.runEvery(ctx, interval, "profile views retention", a.PurgeExpiredViews)
+}
+
+// PurgeExpiredViews deletes views of profiles last made before the retention age in batches and returns
+// their count.
+func (a *App) PurgeExpiredViews(ctx context.Context) (int64, error) {
+	if a.viewsRetention <= 0 {
+		return 0, nil
+	}
+	before := a.now().Add(-a.viewsRetention)
+	var total int64
+	for {
+		count, err := a.store.DeleteProfileViewsBefore(ctx, before, purgeBatchSize)
+		if err != nil {
+			return total, fmt.Errorf("err purging profile views: %w", err)
+		}
+		total += count
+		if count < purgeBatchSize {
+			return total, nil
+		}
+	}
+}
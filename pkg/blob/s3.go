@@ -0,0 +1,216 @@
+package blob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3 presigns requests to objects of a bucket of an S3-compatible storage with signature version 4,
+// so that clients upload objects directly instead of through the service.
+type S3 struct {
+	// endpoint is the URL of the bucket, either https://bucket.host or https://host/bucket.
+	endpoint  *url.URL
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+	now       func() time.Time
+}
+
+// NewS3 returns a presigner of requests to the bucket at the endpoint URL, which includes the bucket
+// either in the host or as the path.
+func NewS3(endpoint, region, accessKey, secretKey string) (*S3, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("err parsing s3 endpoint: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("err s3 endpoint %q is not an absolute url", endpoint)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return &S3{
+		endpoint:  u,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		now:       time.Now,
+	}, nil
+}
+
+// URL returns the link of the object.
+func (s *S3) URL(key string) string {
+	u := *s.endpoint
+	u.Path += "/" + key
+	return u.String()
+}
+
+// SignedURL returns a link of the object valid for ttl, which is what objects of a private bucket are shown by.
+func (s *S3) SignedURL(key string, ttl time.Duration) string {
+	return s.presign(http.MethodGet, key, ttl)
+}
+
+// PresignUpload returns a URL and the fields of a form the object may be uploaded with as a multipart POST
+// within ttl, the file goes last. The policy of the form lets only content of a type starting
+// with contentTypePrefix and of up to maxSize bytes through, the form sets its Content-Type field.
+func (s *S3) PresignUpload(_ context.Context, key, contentTypePrefix string, maxSize int64, ttl time.Duration) (string, map[string]string, error) { //nolint:lll
+	now := s.now().UTC()
+	date := now.Format("20060102")
+	fields := map[string]string{
+		"key":              key,
+		"x-amz-algorithm":  "AWS4-HMAC-SHA256",
+		"x-amz-credential": s.accessKey + "/" + s.scope(date),
+		"x-amz-date":       now.Format("20060102T150405Z"),
+	}
+	conditions := []interface{}{
+		[]interface{}{"starts-with", "$Content-Type", contentTypePrefix},
+		[]interface{}{"content-length-range", 1, maxSize},
+	}
+	for _, name := range []string{"key", "x-amz-algorithm", "x-amz-credential", "x-amz-date"} {
+		conditions = append(conditions, map[string]string{name: fields[name]})
+	}
+	policy, err := json.Marshal(struct {
+		Expiration string        `json:"expiration"`
+		Conditions []interface{} `json:"conditions"`
+	}{
+		Expiration: now.Add(ttl).Format("2006-01-02T15:04:05.000Z"),
+		Conditions: conditions,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("err marshalling upload policy: %w", err)
+	}
+	// the policy is what is signed, as described at
+	// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-authentication-HTTPPOST.html
+	fields["policy"] = base64.StdEncoding.EncodeToString(policy)
+	fields["x-amz-signature"] = hex.EncodeToString(hmacSHA256(s.signingKey(date), fields["policy"]))
+	return s.endpoint.String(), fields, nil
+}
+
+// Delete deletes the object, deleting a missing one is no error.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.presign(http.MethodDelete, key, time.Minute), nil)
+	if err != nil {
+		return fmt.Errorf("err creating request: %w", err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("err deleting object %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+	return fmt.Errorf("err deleting object %s: unexpected status %d", key, resp.StatusCode)
+}
+
+// Exists tells whether the object was uploaded.
+func (s *S3) Exists(ctx context.Context, key string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.presign(http.MethodHead, key, time.Minute), nil)
+	if err != nil {
+		return false, fmt.Errorf("err creating request: %w", err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("err checking object %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("err checking object %s: unexpected status %d", key, resp.StatusCode)
+}
+
+// presign signs the request in the query string as described at
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html
+func (s *S3) presign(method, key string, ttl time.Duration) string {
+	now := s.now().UTC()
+	date := now.Format("20060102")
+	scope := s.scope(date)
+	path := uriEncode(s.endpoint.Path+"/"+key, false)
+	query := map[string]string{
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    s.accessKey + "/" + scope,
+		"X-Amz-Date":          now.Format("20060102T150405Z"),
+		"X-Amz-Expires":       strconv.Itoa(int(ttl.Seconds())),
+		"X-Amz-SignedHeaders": "host",
+	}
+	canonicalQuery := canonicalQueryString(query)
+	canonicalRequest := strings.Join([]string{
+		method,
+		path,
+		canonicalQuery,
+		"host:" + s.endpoint.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		query["X-Amz-Date"],
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+	signature := hex.EncodeToString(hmacSHA256(s.signingKey(date), stringToSign))
+	return s.endpoint.Scheme + "://" + s.endpoint.Host + path + "?" + canonicalQuery + "&X-Amz-Signature=" + signature
+}
+
+func (s *S3) scope(date string) string {
+	return date + "/" + s.region + "/s3/aws4_request"
+}
+
+func (s *S3) signingKey(date string) []byte {
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	for _, part := range []string{s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return key
+}
+
+func canonicalQueryString(query map[string]string) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, uriEncode(key, true)+"="+uriEncode(query[key], true))
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters, slashes are kept unless encodeSlash.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
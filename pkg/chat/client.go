@@ -1,11 +1,12 @@
 package chat
 
 import (
-	"bytes"
+	"errors"
 	"log"
 	"net/http"
 	"time"
 
+	"github.com/gerladeno/homie-core/pkg/common"
 	"github.com/gorilla/websocket"
 )
 
@@ -13,34 +14,77 @@ const (
 	// Time allowed to write a message to the peer.
 	writeWait = 10 * time.Second
 
-	// Time allowed to read the next pong message from the peer.
-	pongWait = 60 * time.Second
+	// Time allowed to read the next pong message from the peer by default.
+	defaultPongWait = 60 * time.Second
 
-	// Send pings to peer with this period. Must be less than pongWait.
-	pingPeriod = (pongWait * 9) / 10
+	// Send pings to peer with this period by default. Must be less than the pong wait.
+	defaultPingPeriod = (defaultPongWait * 9) / 10
 
 	// Maximum message size allowed from peer.
 	maxMessageSize = 512
-)
 
-var (
-	newline = []byte{'\n'}
-	space   = []byte{' '}
+	// Maximum frame size read from peer by default, larger frames close the connection with
+	// CloseMessageTooBig before they are buffered.
+	defaultMaxFrameSize = maxMessageSize
 )
 
+var newline = []byte{'\n'}
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
 }
 
+// Close codes the hub disconnects clients with.
+const (
+	// CloseShutdown means the server is going away, clients should reconnect later.
+	CloseShutdown = websocket.CloseGoingAway
+	// ClosePolicyViolation means the dialog isn't available anymore, clients shouldn't reconnect.
+	ClosePolicyViolation = websocket.ClosePolicyViolation
+	// CloseRateLimited means the client didn't keep up with messages, clients may reconnect.
+	CloseRateLimited = websocket.CloseTryAgainLater
+	// CloseSuperseded means the user has connected elsewhere, clients shouldn't reconnect unless the user asks to.
+	CloseSuperseded = 4000
+	// CloseReconnect means the connection has been open for too long, clients should reconnect with a fresh token.
+	CloseReconnect = 4001
+)
+
+// Close reasons sent along with close codes.
+const (
+	ReasonShutdown     = "server shutdown"
+	ReasonBlocked      = "blocked"
+	ReasonSlowConsumer = "slow consumer"
+	ReasonSuperseded   = "session superseded"
+	ReasonLifetime     = "connection lifetime exceeded"
+)
+
 type Client struct {
+	uuid string
 	hub  *Hub
 	conn *websocket.Conn
 	send chan []byte
+	// closeFrame is set by the hub before closing send.
+	closeFrame []byte
+	// stream is the stream the client belongs to, nil if the client has a connection of its own.
+	stream *Stream
+	// expiresAt is when the hub closes the client with CloseReconnect, zero if never.
+	expiresAt     time.Time
+	lifetimeTimer *time.Timer
+	// history is the recent messages of the dialog loaded before registering, the hub replays them.
+	history []*Message
 }
 
-func NewClient(hub *Hub, conn *websocket.Conn, send chan []byte) *Client {
+// session returns what the connection of the client is, its stream or the client itself if it has none.
+func (c *Client) session() interface{} {
+	if c.stream != nil {
+		return c.stream
+	}
+	return c
+}
+
+func NewClient(uuid string, hub *Hub, conn *websocket.Conn, send chan []byte) *Client {
 	return &Client{
+		uuid: uuid,
 		hub:  hub,
 		conn: conn,
 		send: send,
@@ -52,24 +96,40 @@ func (c *Client) readPump() {
 		c.hub.unregister <- c
 		c.conn.Close()
 	}()
-	c.conn.SetReadLimit(maxMessageSize)
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.conn.SetPongHandler(func(string) error { c.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	c.conn.SetReadLimit(c.hub.maxFrameSize)
+	c.conn.SetReadDeadline(time.Now().Add(c.hub.pongWait))
+	c.conn.SetPongHandler(func(string) error { c.conn.SetReadDeadline(time.Now().Add(c.hub.pongWait)); return nil })
 	for {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			switch {
+			case errors.Is(err, websocket.ErrReadLimit):
+				// the connection has answered with CloseMessageTooBig already
+				log.Printf("err frame of %s is over %d bytes", c.uuid, c.hub.maxFrameSize)
+			case websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure):
 				log.Printf("error: %v", err)
 			}
 			break
 		}
-		message = bytes.TrimSpace(bytes.ReplaceAll(message, newline, space))
-		c.hub.broadcast <- message
+		body, key, attachments := parseIncoming(message)
+		if err = c.hub.attachments.check(attachments); err != nil {
+			c.hub.rejections <- rejection{client: c, event: &Rejection{Type: EventTypeError, Key: key, Error: err.Error()}}
+			continue
+		}
+		c.hub.broadcast <- &Message{
+			ConversationID: c.hub.ConversationID(),
+			Sender:         c.uuid,
+			Receiver:       c.hub.peer(c.uuid),
+			Timestamp:      common.NewTimestamp(time.Now()),
+			Body:           normalizeBody(body),
+			Attachments:    attachments,
+			Key:            key,
+		}
 	}
 }
 
 func (c *Client) writePump() {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(c.hub.pingPeriod)
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
@@ -80,7 +140,7 @@ func (c *Client) writePump() {
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// The hub closed the channel.
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.conn.WriteMessage(websocket.CloseMessage, c.closeFrame)
 				return
 			}
 
@@ -109,14 +169,24 @@ func (c *Client) writePump() {
 	}
 }
 
-func WebsocketChatHandler(hub *Hub, w http.ResponseWriter, r *http.Request) {
+func WebsocketChatHandler(hub *Hub, uuid string, w http.ResponseWriter, r *http.Request) {
+	WebsocketChatHandlerWithInit(hub, uuid, nil, w, r)
+}
+
+// WebsocketChatHandlerWithInit serves the dialog like WebsocketChatHandler, sending the init frame first,
+// before replayed messages and presence, unless it's nil.
+func WebsocketChatHandlerWithInit(hub *Hub, uuid string, init []byte, w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	client := NewClient(hub, conn, make(chan []byte, 256))
-	client.hub.register <- client
+	client := NewClient(uuid, hub, conn, make(chan []byte, 256))
+	if init != nil {
+		// queued before registering, so that nothing of the hub gets ahead of it
+		client.send <- init
+	}
+	client.hub.join(client)
 
 	go client.writePump()
 	go client.readPump()
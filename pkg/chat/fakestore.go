@@ -1,10 +1,15 @@
 package chat
 
-import "context"
+import (
+	"context"
+	"time"
+
+	"github.com/gerladeno/homie-core/pkg/common"
+)
 
 type fakeStore struct{}
 
-func (f fakeStore) GetAllChats(ctx context.Context, uuid string) ([]string, error) {
+func (f fakeStore) GetAllChats(ctx context.Context, uuid string, includeArchived bool, now time.Time, limit, offset int64) ([]string, error) { //nolint:lll
 	return nil, nil
 }
 
@@ -12,14 +17,74 @@ func (f fakeStore) SaveChat(ctx context.Context, uuid1, uuid2 string) error {
 	return nil
 }
 
+func (f fakeStore) EvictEmptyChats(ctx context.Context, uuid string, keep int, open []string, openedBefore time.Time) (int64, error) { //nolint:lll
+	return 0, nil
+}
+
 func (f fakeStore) GetChat(ctx context.Context, uuid1, uuid2 string) error {
 	return nil
 }
 
+func (f fakeStore) GetChatPeer(ctx context.Context, uuid, conversationID string) (string, error) {
+	return "", common.ErrChatNotFound
+}
+
+func (f fakeStore) ArchiveChat(ctx context.Context, uuid1, uuid2 string, archived bool) error {
+	return nil
+}
+
+func (f fakeStore) SnoozeChat(ctx context.Context, uuid1, uuid2 string, at time.Time, until *time.Time) error {
+	return nil
+}
+
+func (f fakeStore) GetSnoozes(ctx context.Context, uuid1, uuid2 string) (map[string]Snooze, error) {
+	return nil, nil
+}
+
+func (f fakeStore) ReleaseSnooze(ctx context.Context, uuid1, uuid2 string, until time.Time) error {
+	return nil
+}
+
+func (f fakeStore) IsHidden(ctx context.Context, uuid, target string) (bool, error) {
+	return false, nil
+}
+
 func (f fakeStore) SaveMessage(ctx context.Context, m *Message) error {
 	return nil
 }
 
+func (f fakeStore) RetractMessage(ctx context.Context, sender, receiver string, id int64) error {
+	return nil
+}
+
+func (f fakeStore) EditMessage(ctx context.Context, m *Message, since time.Time) error {
+	return nil
+}
+
+func (f fakeStore) SaveReaction(ctx context.Context, uuid, peer string, id int64, emoji string, at time.Time) error {
+	return nil
+}
+
+func (f fakeStore) DeleteReaction(ctx context.Context, uuid, peer string, id int64) error {
+	return nil
+}
+
+func (f fakeStore) MarkAllRead(ctx context.Context, uuid string) ([]string, error) {
+	return nil, nil
+}
+
 func (f fakeStore) LoadAllMessages(ctx context.Context, uuid1, uuid2 string) ([]*Message, error) {
 	return nil, nil
 }
+
+func (f fakeStore) LoadRecentMessages(ctx context.Context, uuid1, uuid2 string, limit int) ([]*Message, error) {
+	return nil, nil
+}
+
+func (f fakeStore) LoadMessagesSince(ctx context.Context, sender, receiver string, since time.Time, limit int) ([]*Message, error) { //nolint:lll
+	return nil, nil
+}
+
+func (f fakeStore) StreamMessages(ctx context.Context, uuid1, uuid2 string, fn func(*Message) error) error {
+	return nil
+}
@@ -1,12 +1,216 @@
 package chat
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gerladeno/homie-core/pkg/common"
+)
+
 type Message struct {
-	Sender    string `json:"sender"`
-	Receiver  string `json:"receiver"`
-	Timestamp string `json:"timestamp"`
-	Body      string `json:"body"`
+	ID             int64            `json:"id"`
+	ConversationID string           `json:"conversation_id,omitempty" db:"-"`
+	Sender         string           `json:"sender"`
+	Receiver       string           `json:"receiver"`
+	Timestamp      common.Timestamp `json:"timestamp"`
+	Body           string           `json:"body"`
+	// Attachments are files the message links to.
+	Attachments []*Attachment `json:"attachments,omitempty" db:"attachments"`
+	// Key is a client supplied idempotency key, a retried send with the same key isn't stored twice.
+	Key string `json:"key,omitempty" db:"-"`
+	// EditedAt is when the sender last edited the body, the original body is kept by the store.
+	EditedAt *common.Timestamp `json:"edited_at,omitempty" db:"edited_at"`
+	// Reactions are the participants' reactions to the message, oldest first.
+	Reactions []*Reaction `json:"reactions,omitempty" db:"reactions"`
+	// IsMine tells whether the participant the message is sent to is its sender.
+	IsMine bool `json:"is_mine" db:"-"`
+}
+
+// viewedBy returns a copy of the message for the participant, the message may be sent to their peer as well.
+func (m *Message) viewedBy(uuid string) *Message {
+	viewed := *m
+	viewed.IsMine = m.Sender == uuid
+	return &viewed
+}
+
+// ConversationID returns an opaque id of the dialog, the same for both participants.
+func ConversationID(uuid1, uuid2 string) string {
+	if uuid2 < uuid1 {
+		uuid1, uuid2 = uuid2, uuid1
+	}
+	sum := sha256.Sum256([]byte(uuid1 + ":" + uuid2))
+	return hex.EncodeToString(sum[:16])
+}
+
+// IsConversationID tells whether the id is formed like ones ConversationID returns.
+func IsConversationID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// Attachment is a file a message links to, Type is its MIME type.
+type Attachment struct {
+	URL  string `json:"url"`
+	Type string `json:"type"`
+}
+
+// defaultAttachmentSchemes and defaultAttachmentTypes are what attachments may be linked with and of
+// unless WithAttachmentSchemes and WithAttachmentTypes set others.
+var (
+	defaultAttachmentSchemes = []string{"https"}
+	defaultAttachmentTypes   = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+)
+
+// attachmentRules are schemes of links and types attachments are allowed with, a type/* one allows
+// every subtype.
+type attachmentRules struct {
+	schemes []string
+	types   []string
+}
+
+// check returns ErrInvalidAttachment listing the allowed schemes and types unless every attachment
+// is allowed.
+func (r *attachmentRules) check(attachments []*Attachment) error {
+	for _, a := range attachments {
+		if a == nil {
+			return fmt.Errorf("%w: null", common.ErrInvalidAttachment)
+		}
+		u, err := url.Parse(a.URL)
+		if err != nil || u.Host == "" || !contains(r.schemes, strings.ToLower(u.Scheme)) {
+			return fmt.Errorf("%w: link %q, allowed schemes are %s", common.ErrInvalidAttachment, a.URL,
+				strings.Join(r.schemes, " "))
+		}
+		kind := strings.ToLower(a.Type)
+		family, _, _ := strings.Cut(kind, "/")
+		if !contains(r.types, kind) && !contains(r.types, family+"/*") {
+			return fmt.Errorf("%w: type %q, allowed types are %s", common.ErrInvalidAttachment, a.Type,
+				strings.Join(r.types, " "))
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// incomingMessage is a frame clients send as JSON to attach an idempotency key and files to the body.
+type incomingMessage struct {
+	Body        *string       `json:"body"`
+	Key         string        `json:"key"`
+	Attachments []*Attachment `json:"attachments"`
+}
+
+// parseIncoming returns the body, the idempotency key and the attachments of a frame. Frames other than
+// a JSON object with a body are bodies themselves, keys other than UUIDs are ignored.
+func parseIncoming(frame []byte) (body, key string, attachments []*Attachment) {
+	var in incomingMessage
+	if err := json.Unmarshal(frame, &in); err != nil || in.Body == nil {
+		return string(frame), "", nil
+	}
+	if common.IsValidUUID(in.Key) {
+		key = in.Key
+	}
+	return *in.Body, key, in.Attachments
+}
+
+// normalizeBody replaces newlines of a message body with spaces and trims it, for connections and streams alike.
+func normalizeBody(body string) string {
+	return strings.TrimSpace(strings.ReplaceAll(body, "\n", " "))
 }
 
 func (m *Message) String() string {
-	return m.Sender + " at " + m.Timestamp + " says " + m.Body
+	return m.Sender + " at " + m.Timestamp.Format(time.RFC3339) + " says " + m.Body
+}
+
+const (
+	ReceiptTypeRead   = "read"
+	EventTypeEdit     = "edit"
+	EventTypeReaction = "reaction"
+	EventTypeTyping   = "typing"
+	EventTypePresence = "presence"
+	AckTypeSent       = "sent"
+	AckTypeDelivered  = "delivered"
+	EventTypeError    = "error"
+
+	// EventTypeConversationInit frames are the first ones of a connection, describing the dialog.
+	EventTypeConversationInit = "conversation_init"
+	// EventTypeTypingStopped tells the peer the user has stopped typing, explicitly or by the typing timeout.
+	EventTypeTypingStopped = "typing_stopped"
+)
+
+// Receipt notifies dialog participants about a change of the dialog state.
+type Receipt struct {
+	Type      string           `json:"type"`
+	Reader    string           `json:"reader"`
+	Timestamp common.Timestamp `json:"timestamp"`
+}
+
+// Ack tells the sender that a message is stored (sent) or queued to a connection of the receiver (delivered).
+// MessageID is the sequence number of the message in the store, Key is the idempotency key the client sent it with.
+type Ack struct {
+	Type      string `json:"type"`
+	MessageID int64  `json:"message_id"`
+	Key       string `json:"key,omitempty"`
+}
+
+// Rejection tells the sender why a message it sent to the dialog wasn't taken, Key is the idempotency key
+// it was sent with.
+type Rejection struct {
+	Type  string `json:"type"`
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error"`
+}
+
+// Edit notifies dialog participants that the sender edited a message, Message is the edited one.
+type Edit struct {
+	Type    string   `json:"type"`
+	Message *Message `json:"message"`
+}
+
+// defaultReactions are emoji participants may react to messages with unless WithReactions sets others.
+var defaultReactions = []string{"👍", "👎", "❤️", "😂", "😮", "😢", "😡", "🔥"}
+
+// Reaction is an emoji a participant reacted to a message with, each of them has at most one per message.
+type Reaction struct {
+	User  string `json:"user"`
+	Emoji string `json:"emoji"`
+}
+
+// ReactionEvent notifies dialog participants that one of them reacted to a message, an empty Emoji means
+// the reaction is removed.
+type ReactionEvent struct {
+	Type           string `json:"type"`
+	ConversationID string `json:"conversation_id"`
+	MessageID      int64  `json:"message_id"`
+	User           string `json:"user"`
+	Emoji          string `json:"emoji"`
+}
+
+// Typing notifies the peer that the user is typing in the dialog or has stopped, by its type.
+type Typing struct {
+	Type           string `json:"type"`
+	ConversationID string `json:"conversation_id"`
+	User           string `json:"user"`
+}
+
+// Presence notifies streams of the peer that the user has connected to the dialog or has left it.
+type Presence struct {
+	Type           string `json:"type"`
+	ConversationID string `json:"conversation_id"`
+	User           string `json:"user"`
+	Online         bool   `json:"online"`
 }
@@ -2,32 +2,353 @@ package chat
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/gerladeno/homie-core/pkg/metrics"
+	"github.com/gorilla/websocket"
 )
 
 type Store interface {
+	// SaveChat creates the dialog unless it exists and marks the chat of uuid1 opened.
 	SaveChat(ctx context.Context, uuid1, uuid2 string) error
+	// EvictEmptyChats deletes dialogs of the user without messages but the keep most recently opened ones,
+	// those with peers in open and those either participant opened at openedBefore or later, returning
+	// the number of dialogs deleted.
+	EvictEmptyChats(ctx context.Context, uuid string, keep int, open []string, openedBefore time.Time) (int64, error)
 	GetChat(ctx context.Context, uuid1, uuid2 string) error
-	GetAllChats(ctx context.Context, uuid string) ([]string, error)
+	// GetChatPeer returns the peer of the user in the dialog with the conversation id, ErrChatNotFound if there is none.
+	GetChatPeer(ctx context.Context, uuid, conversationID string) (string, error)
+	// GetAllChats lists peers of the user, chats snoozed after now are listed along with archived ones only.
+	GetAllChats(ctx context.Context, uuid string, includeArchived bool, now time.Time, limit, offset int64) ([]string, error)
+	ArchiveChat(ctx context.Context, uuid1, uuid2 string, archived bool) error
+	// SnoozeChat snoozes the chat of uuid1 with uuid2 at the time until another one, nil ends the snooze.
+	// A snooze still on at the time keeps when it started.
+	SnoozeChat(ctx context.Context, uuid1, uuid2 string, at time.Time, until *time.Time) error
+	// GetSnoozes returns when participants of the dialog have snoozed it and until when, unless released.
+	GetSnoozes(ctx context.Context, uuid1, uuid2 string) (map[string]Snooze, error)
+	// ReleaseSnooze ends the snooze of uuid1 with uuid2 until the time once messages held by it are sent,
+	// unless it has been snoozed again.
+	ReleaseSnooze(ctx context.Context, uuid1, uuid2 string, until time.Time) error
+	// IsHidden reports whether uuid has hidden target.
+	IsHidden(ctx context.Context, uuid, target string) (bool, error)
 	SaveMessage(ctx context.Context, m *Message) error
+	RetractMessage(ctx context.Context, sender, receiver string, id int64) error
+	// EditMessage replaces the body of the message of the dialog of m.Sender and m.Receiver with m.ID
+	// and sets m.EditedAt, keeping the original body. Messages of the receiver fail with ErrNotMessageSender,
+	// ones sent before since with ErrEditWindowExpired.
+	EditMessage(ctx context.Context, m *Message, since time.Time) error
+	// SaveReaction sets the reaction of uuid to the message of their dialog with peer, replacing a previous one.
+	// Messages of other dialogs fail with ErrMessageNotFound.
+	SaveReaction(ctx context.Context, uuid, peer string, id int64, emoji string, at time.Time) error
+	// DeleteReaction removes the reaction of uuid to the message of their dialog with peer,
+	// ErrReactionNotFound if there is none.
+	DeleteReaction(ctx context.Context, uuid, peer string, id int64) error
 	LoadAllMessages(ctx context.Context, uuid1, uuid2 string) ([]*Message, error)
+	LoadRecentMessages(ctx context.Context, uuid1, uuid2 string, limit int) ([]*Message, error)
+	// LoadMessagesSince returns up to limit latest messages the sender has sent to the receiver since the time,
+	// oldest first.
+	LoadMessagesSince(ctx context.Context, sender, receiver string, since time.Time, limit int) ([]*Message, error)
+	StreamMessages(ctx context.Context, uuid1, uuid2 string, fn func(*Message) error) error
+	MarkAllRead(ctx context.Context, uuid string) ([]string, error)
 }
 
 type Server struct {
-	store Store
-	hubs  map[string]map[string]*Hub
-	mx    sync.Mutex
+	store         Store
+	hubs          map[string]map[string]*Hub
+	mx            sync.Mutex
+	autoUnarchive bool
+	metrics       *metrics.Chat
+	events        *metrics.Events
+	// replayLimit is the number of recent messages sent to a new connection, none if zero.
+	replayLimit int
+	// pingPeriod is how often connections are pinged, pongWait is how long a pong may take before they are closed.
+	pingPeriod time.Duration
+	pongWait   time.Duration
+	// maxFrameSize is the size of the largest frame read from connections.
+	maxFrameSize int64
+	// editWindow is how long after sending a message its sender may edit it.
+	editWindow time.Duration
+	// reactions are emoji participants may react to messages with.
+	reactions []string
+	// attachments are what files messages link to are allowed to be.
+	attachments *attachmentRules
+	// typingTimeout is how long after the last typing of a user the peer is told they stopped.
+	typingTimeout time.Duration
+	// maxLifetime is how long connections stay open before they are closed with CloseReconnect, zero is unlimited.
+	maxLifetime time.Duration
+	now         func() time.Time
+	// maxEmptyChats is the number of dialogs without messages a user keeps, zero keeps all of them.
+	maxEmptyChats int
+	sessionMode   SessionMode
+	// sessions track the latest connections of users in the SessionsSingle mode, nil otherwise.
+	sessions *sessions
+	// streams are the open streams of every user, which join dialogs of the user as they open.
+	streams map[string]map[*Stream]bool
+}
+
+type Option func(*Server)
+
+const (
+	defaultReplayLimit   = 50
+	defaultEditWindow    = 15 * time.Minute
+	defaultTypingTimeout = 5 * time.Second
+)
+
+// WithAutoUnarchive makes a new message return an archived chat to the receiver's chat list.
+func WithAutoUnarchive(autoUnarchive bool) Option {
+	return func(s *Server) {
+		s.autoUnarchive = autoUnarchive
+	}
+}
+
+// WithHistoryReplay makes a new connection receive up to limit most recent messages of the dialog,
+// defaultReplayLimit if limit isn't positive.
+func WithHistoryReplay(limit int) Option {
+	return func(s *Server) {
+		if limit <= 0 {
+			limit = defaultReplayLimit
+		}
+		s.replayLimit = limit
+	}
+}
+
+// WithKeepalive makes connections ping clients every pingPeriod and close ones which
+// haven't answered with a pong or sent anything else for pongWait. Pings keep idle chats alive
+// through load balancers dropping idle connections. A ping period not less than the pong wait
+// is replaced with 90% of it, non-positive values keep the defaults of 54s and 60s.
+func WithKeepalive(pingPeriod, pongWait time.Duration) Option {
+	return func(s *Server) {
+		if pongWait > 0 {
+			s.pongWait = pongWait
+		}
+		if pingPeriod > 0 {
+			s.pingPeriod = pingPeriod
+		}
+		if s.pingPeriod >= s.pongWait {
+			s.pingPeriod = s.pongWait * 9 / 10
+		}
+	}
+}
+
+// WithMaxFrameSize sets the size in bytes of the largest frame read from connections, streams read frames
+// of twice the size. Larger frames close the connection with CloseMessageTooBig as soon as their header is
+// read, so they never take more memory than the limit. Non-positive sizes keep the default of 512 bytes.
+func WithMaxFrameSize(size int64) Option {
+	return func(s *Server) {
+		if size > 0 {
+			s.maxFrameSize = size
+		}
+	}
+}
+
+// WithEditWindow sets how long after sending a message its sender may edit it, non-positive values
+// keep the default of 15m.
+func WithEditWindow(window time.Duration) Option {
+	return func(s *Server) {
+		if window > 0 {
+			s.editWindow = window
+		}
+	}
+}
+
+// WithTypingTimeout sets how long after the last typing of a user, unless they send a message or stop typing
+// explicitly, the peer gets a typing_stopped event, so that a client gone mid-typing doesn't leave the peer
+// seeing them typing. Non-positive values keep the default of 5s.
+func WithTypingTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		if timeout > 0 {
+			s.typingTimeout = timeout
+		}
+	}
+}
+
+// WithReactions sets emoji participants may react to messages with, empty ones are skipped and none keep
+// the default 👍 👎 ❤️ 😂 😮 😢 😡 🔥.
+func WithReactions(emoji []string) Option {
+	return func(s *Server) {
+		if reactions := nonEmpty(emoji); len(reactions) > 0 {
+			s.reactions = reactions
+		}
+	}
+}
+
+// WithAttachmentSchemes sets schemes of links messages may attach files with, empty ones are skipped
+// and none keep the default https.
+func WithAttachmentSchemes(schemes []string) Option {
+	return func(s *Server) {
+		if schemes = nonEmpty(schemes); len(schemes) > 0 {
+			for i := range schemes {
+				schemes[i] = strings.ToLower(schemes[i])
+			}
+			s.attachments.schemes = schemes
+		}
+	}
+}
+
+// WithAttachmentTypes sets MIME types of files messages may attach, image/* allows every image type. Empty ones
+// are skipped and none keep the default image/jpeg image/png image/gif image/webp.
+func WithAttachmentTypes(types []string) Option {
+	return func(s *Server) {
+		if types = nonEmpty(types); len(types) > 0 {
+			for i := range types {
+				types[i] = strings.ToLower(types[i])
+			}
+			s.attachments.types = types
+		}
+	}
+}
+
+// nonEmpty returns the values trimmed, without empty ones.
+func nonEmpty(values []string) []string {
+	var result []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// WithMaxLifetime closes connections open for longer than lifetime with CloseReconnect, once the frames queued
+// to them are written, so that clients reconnect with a fresh token and spread over new instances during
+// deploys. A stream is closed once its oldest dialog connection is. Non-positive lifetimes are unlimited, which
+// is the default.
+func WithMaxLifetime(lifetime time.Duration) Option {
+	return func(s *Server) {
+		s.maxLifetime = lifetime
+	}
+}
+
+// WithMaxEmptyChats makes opening a dialog evict the least recently opened dialogs of the user without
+// messages beyond max ones, for the peers too. Dialogs with messages are never evicted, nor ones somebody is
+// connected to. Connections to other instances aren't known, so dialogs opened within twice the max lifetime
+// of connections aren't evicted either, and nothing is without WithMaxLifetime. Non-positive max keeps all
+// of them, which is the default.
+func WithMaxEmptyChats(max int) Option {
+	return func(s *Server) {
+		s.maxEmptyChats = max
+	}
+}
+
+// WithSessionMode sets whether users may have several connections at once, SessionsMultiple by default.
+// In the SessionsSingle mode a new connection of a user closes their older ones of every dialog.
+func WithSessionMode(mode SessionMode) Option {
+	return func(s *Server) {
+		s.sessionMode = mode
+	}
+}
+
+// WithClock sets the source of the current time snoozes and connection lifetimes are checked against,
+// time.Now by default.
+func WithClock(now func() time.Time) Option {
+	return func(s *Server) {
+		s.now = now
+	}
+}
+
+// WithMetrics sets chat metrics, unregistered ones are used by default.
+func WithMetrics(m *metrics.Chat) Option {
+	return func(s *Server) {
+		s.metrics = m
+	}
+}
+
+// WithEventMetrics sets business event metrics counting sent messages, unregistered ones are used by default.
+func WithEventMetrics(m *metrics.Events) Option {
+	return func(s *Server) {
+		s.events = m
+	}
 }
 
-func NewServer() *Server {
+func NewServer(store Store, opts ...Option) *Server {
 	s := Server{
-		hubs:  make(map[string]map[string]*Hub),
-		store: fakeStore{},
+		hubs:          make(map[string]map[string]*Hub),
+		streams:       make(map[string]map[*Stream]bool),
+		store:         store,
+		metrics:       metrics.NewChat(),
+		events:        metrics.NewEvents(),
+		pingPeriod:    defaultPingPeriod,
+		pongWait:      defaultPongWait,
+		maxFrameSize:  defaultMaxFrameSize,
+		editWindow:    defaultEditWindow,
+		reactions:     defaultReactions,
+		attachments:   &attachmentRules{schemes: defaultAttachmentSchemes, types: defaultAttachmentTypes},
+		typingTimeout: defaultTypingTimeout,
+		now:           time.Now,
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	if s.sessionMode == SessionsSingle {
+		s.sessions = newSessions(s.hubsOf)
 	}
 	return &s
 }
 
-func (s *Server) GetDialog(_ context.Context, client, target string) *Hub {
+// hubsOf returns the open dialogs of the user.
+func (s *Server) hubsOf(uuid string) []*Hub {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	hubs := make([]*Hub, 0, len(s.hubs[uuid]))
+	for _, h := range s.hubs[uuid] {
+		hubs = append(hubs, h)
+	}
+	return hubs
+}
+
+// GetDialog returns the hub of the dialog, creating the dialog unless it exists. Further connections of a client
+// connected to the dialog already skip the store, its dialog is neither new nor evicted while they're connected.
+// They still mark the dialog opened once per max lifetime of connections, so that the dialog isn't evicted by
+// other instances while any of them may be open.
+func (s *Server) GetDialog(ctx context.Context, client, target string) (*Hub, error) {
+	now := s.now()
+	s.mx.Lock()
+	h, ok := s.hubs[client][target]
+	s.mx.Unlock()
+	if ok && h.Online(client) && (s.maxLifetime <= 0 || now.Sub(h.openedAt(client)) < s.maxLifetime) {
+		return h, nil
+	}
+	if err := s.store.SaveChat(ctx, client, target); err != nil {
+		return nil, fmt.Errorf("err saving chat: %w", err)
+	}
+	if s.maxEmptyChats > 0 && s.maxLifetime > 0 {
+		s.evictEmptyChats(ctx, client, target, now)
+	}
+	// snoozes are only needed by a new hub, but loading them under the lock would block other dialogs
+	h, err := s.openHub(ctx, client, target)
+	if err != nil {
+		return nil, err
+	}
+	h.markOpened(client, now)
+	return h, nil
+}
+
+// openHub returns the hub of the open dialog, starting it unless it's running. Streams of both participants
+// join a new hub, which starts with the snoozes and hidden participants of the dialog.
+func (s *Server) openHub(ctx context.Context, client, target string) (*Hub, error) {
+	s.mx.Lock()
+	h, ok := s.hubs[client][target]
+	s.mx.Unlock()
+	if ok {
+		return h, nil
+	}
+	// loaded out of the lock, which would block other dialogs, so another call may start the hub meanwhile
+	snoozes, err := s.store.GetSnoozes(ctx, client, target)
+	if err != nil {
+		return nil, fmt.Errorf("err getting snoozes: %w", err)
+	}
+	blocked, err := s.loadBlocked(ctx, client, target)
+	if err != nil {
+		return nil, err
+	}
 	s.mx.Lock()
 	defer s.mx.Unlock()
 	m, ok := s.hubs[client]
@@ -35,11 +356,23 @@ func (s *Server) GetDialog(_ context.Context, client, target string) *Hub {
 		m = make(map[string]*Hub)
 		s.hubs[client] = m
 	}
-	h, ok := m[target]
+	h, ok = m[target]
 	if !ok {
-		h = newHub()
+		h = s.newHub(client, target)
+		for uuid, sn := range snoozes {
+			h.snooze(uuid, sn.Since, sn.Until)
+		}
+		for _, uuid := range blocked {
+			h.blocked[uuid] = true
+		}
 		go h.run()
 		m[target] = h
+		for _, uuid := range []string{client, target} {
+			for st := range s.streams[uuid] {
+				// the stream may be joining another hub
+				go st.join(h)
+			}
+		}
 	}
 	m, ok = s.hubs[target]
 	if !ok {
@@ -47,48 +380,944 @@ func (s *Server) GetDialog(_ context.Context, client, target string) *Hub {
 		s.hubs[target] = m
 	}
 	m[client] = h
-	return h
+	return h, nil
+}
+
+// loadBlocked returns the participants of the dialog hidden by their peer.
+func (s *Server) loadBlocked(ctx context.Context, uuid1, uuid2 string) ([]string, error) {
+	var blocked []string
+	for _, pair := range [][2]string{{uuid1, uuid2}, {uuid2, uuid1}} {
+		hidden, err := s.store.IsHidden(ctx, pair[0], pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("err checking blocks: %w", err)
+		}
+		if hidden {
+			blocked = append(blocked, pair[1])
+		}
+	}
+	return blocked, nil
+}
+
+// evictEmptyChats evicts empty dialogs of the client but the one being opened, those with connections and
+// those opened within twice the max lifetime, which may have connections to other instances as those mark
+// their dialogs opened once per max lifetime. A failure doesn't fail opening the dialog, the next one
+// evicts them.
+func (s *Server) evictEmptyChats(ctx context.Context, client, target string, now time.Time) {
+	open := []string{target}
+	s.mx.Lock()
+	for peer, h := range s.hubs[client] {
+		if h.Online(client) || h.Online(peer) {
+			open = append(open, peer)
+		}
+	}
+	s.mx.Unlock()
+	openedBefore := now.Add(-2 * s.maxLifetime)
+	if _, err := s.store.EvictEmptyChats(ctx, client, s.maxEmptyChats, open, openedBefore); err != nil {
+		log.Printf("err evicting empty chats: %v", err)
+	}
+}
+
+func (s *Server) GetAllChats(ctx context.Context, uuid string, includeArchived bool, limit, offset int64) ([]string, error) { //nolint:lll
+	return s.store.GetAllChats(ctx, uuid, includeArchived, s.now(), limit, offset)
+}
+
+// MarkAllRead marks all chats of the user read, notifies peers connected to the dialogs and returns
+// the number of chats affected.
+func (s *Server) MarkAllRead(ctx context.Context, uuid string) (int, error) {
+	peers, err := s.store.MarkAllRead(ctx, uuid)
+	if err != nil {
+		return 0, fmt.Errorf("err marking chats read: %w", err)
+	}
+	receipt := &Receipt{Type: ReceiptTypeRead, Reader: uuid, Timestamp: common.NewTimestamp(s.now())}
+	s.mx.Lock()
+	hubs := make([]*Hub, 0, len(peers))
+	for _, peer := range peers {
+		if h, ok := s.hubs[uuid][peer]; ok {
+			hubs = append(hubs, h)
+		}
+	}
+	s.mx.Unlock()
+	for _, h := range hubs {
+		h.receipts <- receipt
+	}
+	return len(peers), nil
+}
+
+// ResolveConversation returns the peer of the user in the conversation with the id.
+func (s *Server) ResolveConversation(ctx context.Context, uuid, id string) (string, error) {
+	peer, err := s.store.GetChatPeer(ctx, uuid, id)
+	if err != nil {
+		return "", fmt.Errorf("err resolving conversation: %w", err)
+	}
+	return peer, nil
+}
+
+type Stats struct {
+	Hubs        int        `json:"hubs"`
+	Connections int        `json:"connections"`
+	PerHub      []HubStats `json:"per_hub"`
+}
+
+type HubStats struct {
+	ConversationID string `json:"conversation_id"`
+	Connections    int    `json:"connections"`
+}
+
+// Stats reports open dialogs and connections to them.
+func (s *Server) Stats() Stats {
+	hubs := s.allHubs()
+	stats := Stats{Hubs: len(hubs), PerHub: make([]HubStats, 0, len(hubs))}
+	for h := range hubs {
+		count := h.connections(h.uuid1) + h.connections(h.uuid2)
+		stats.Connections += count
+		stats.PerHub = append(stats.PerHub, HubStats{ConversationID: h.ConversationID(), Connections: count})
+	}
+	sort.Slice(stats.PerHub, func(i, j int) bool {
+		return stats.PerHub[i].Connections > stats.PerHub[j].Connections
+	})
+	return stats
+}
+
+// CloseDialog disconnects all clients of the dialog if it is open.
+func (s *Server) CloseDialog(uuid1, uuid2 string, code int, reason string) {
+	s.mx.Lock()
+	h, ok := s.hubs[uuid1][uuid2]
+	s.mx.Unlock()
+	if ok {
+		h.Disconnect(code, reason)
+	}
+}
+
+// SetBlocked makes the open dialog of the users drop messages target sends to uuid if blocked, closing
+// connections of target to it, so that a block takes effect on live connections too. Unblocking lets
+// messages of target through again. It does nothing to a dialog which isn't open.
+func (s *Server) SetBlocked(uuid, target string, blocked bool) {
+	s.mx.Lock()
+	h, ok := s.hubs[uuid][target]
+	s.mx.Unlock()
+	if ok {
+		h.blocks <- block{uuid: target, blocked: blocked}
+	}
+}
+
+// Shutdown disconnects clients of all dialogs telling them to reconnect later.
+func (s *Server) Shutdown() {
+	for h := range s.allHubs() {
+		h.Disconnect(CloseShutdown, ReasonShutdown)
+	}
+}
+
+// allHubs returns every hub once, as each of them is registered for both participants.
+func (s *Server) allHubs() map[*Hub]bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	hubs := make(map[*Hub]bool)
+	for _, m := range s.hubs {
+		for _, h := range m {
+			hubs[h] = true
+		}
+	}
+	return hubs
+}
+
+func (s *Server) RetractMessage(ctx context.Context, sender, receiver string, id int64) error {
+	return s.store.RetractMessage(ctx, sender, receiver, id)
+}
+
+// EditMessage replaces the body of a message the sender sent to the receiver within the edit window
+// and notifies participants connected to the dialog. The edited message keeps its id and timestamp.
+func (s *Server) EditMessage(ctx context.Context, sender, receiver string, id int64, body string) (*Message, error) {
+	if strings.TrimSpace(body) == "" || len(body) > maxMessageSize {
+		return nil, common.ErrInvalidMessage
+	}
+	now := common.NewTimestamp(time.Now())
+	m := &Message{ID: id, Sender: sender, Receiver: receiver, Body: body, EditedAt: &now}
+	if err := s.store.EditMessage(ctx, m, now.Add(-s.editWindow)); err != nil {
+		return nil, err
+	}
+	m.ConversationID = ConversationID(sender, receiver)
+	m.IsMine = true
+	s.mx.Lock()
+	h, ok := s.hubs[sender][receiver]
+	s.mx.Unlock()
+	if ok {
+		h.edits <- &Edit{Type: EventTypeEdit, Message: m}
+	}
+	return m, nil
+}
+
+// ReactToMessage sets the user's reaction to a message of their dialog with the peer, replacing a previous one,
+// and notifies participants connected to the dialog.
+func (s *Server) ReactToMessage(ctx context.Context, uuid, peer string, id int64, emoji string) (*Reaction, error) {
+	if err := s.CheckReaction(emoji); err != nil {
+		return nil, err
+	}
+	if err := s.store.SaveReaction(ctx, uuid, peer, id, emoji, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	s.notifyReaction(uuid, peer, id, emoji)
+	return &Reaction{User: uuid, Emoji: emoji}, nil
+}
+
+// CheckReaction returns ErrInvalidReaction listing the allowed emoji unless participants may react
+// to messages with the emoji.
+func (s *Server) CheckReaction(emoji string) error {
+	if !contains(s.reactions, emoji) {
+		return fmt.Errorf("%w: %q, allowed are %s", common.ErrInvalidReaction, emoji, strings.Join(s.reactions, " "))
+	}
+	return nil
+}
+
+// RemoveReaction removes the user's reaction to a message of their dialog with the peer
+// and notifies participants connected to the dialog.
+func (s *Server) RemoveReaction(ctx context.Context, uuid, peer string, id int64) error {
+	if err := s.store.DeleteReaction(ctx, uuid, peer, id); err != nil {
+		return err
+	}
+	s.notifyReaction(uuid, peer, id, "")
+	return nil
 }
 
-func (s *Server) GetAllChats(ctx context.Context, uuid string) ([]string, error) {
-	return s.store.GetAllChats(ctx, uuid)
+func (s *Server) notifyReaction(uuid, peer string, id int64, emoji string) {
+	s.mx.Lock()
+	h, ok := s.hubs[uuid][peer]
+	s.mx.Unlock()
+	if ok {
+		h.reactions <- &ReactionEvent{
+			Type:           EventTypeReaction,
+			ConversationID: ConversationID(uuid, peer),
+			MessageID:      id,
+			User:           uuid,
+			Emoji:          emoji,
+		}
+	}
+}
+
+// ExportMessages calls fn for every message of the dialog in order, ErrChatNotFound if there is no such dialog.
+func (s *Server) ExportMessages(ctx context.Context, uuid, target string, fn func(*Message) error) error {
+	if err := s.store.GetChat(ctx, uuid, target); err != nil {
+		return err
+	}
+	id := ConversationID(uuid, target)
+	return s.store.StreamMessages(ctx, uuid, target, func(m *Message) error {
+		m.ConversationID = id
+		m.IsMine = m.Sender == uuid
+		return fn(m)
+	})
 }
 
+func (s *Server) ArchiveChat(ctx context.Context, uuid, target string, archived bool) error {
+	return s.store.ArchiveChat(ctx, uuid, target, archived)
+}
+
+// SnoozeChat holds messages, edits and reactions of the peer to the user until the time instead of sending them
+// in real time and hides the chat from the user's chat list. Messages sent meanwhile are loaded from the store
+// and sent once the snooze ends, with their latest bodies and reactions, so none are lost on restarts.
+// A zero or past time ends it at once.
+func (s *Server) SnoozeChat(ctx context.Context, uuid, peer string, until time.Time) error {
+	// truncated like timestamps of messages, as the snooze holds those sent since now and is released
+	// by the time it's stored with
+	now := s.now().UTC().Truncate(time.Millisecond)
+	var stored *time.Time
+	if until.After(now) {
+		until = until.UTC().Truncate(time.Millisecond)
+		stored = &until
+	}
+	if err := s.store.SnoozeChat(ctx, uuid, peer, now, stored); err != nil {
+		return err
+	}
+	s.mx.Lock()
+	h, ok := s.hubs[uuid][peer]
+	s.mx.Unlock()
+	if ok {
+		h.snoozes <- snooze{uuid: uuid, since: now, until: until}
+	}
+	return nil
+}
+
+// Hub serves a dialog between two participants, each of them may have several connections.
 type Hub struct {
-	clients    map[*Client]bool
-	broadcast  chan []byte
-	register   chan *Client
-	unregister chan *Client
+	uuid1         string
+	uuid2         string
+	store         Store
+	autoUnarchive bool
+	metrics       *metrics.Chat
+	events        *metrics.Events
+	replayLimit   int
+	pingPeriod    time.Duration
+	pongWait      time.Duration
+	maxFrameSize  int64
+	attachments   *attachmentRules
+	clients       map[*Client]bool
+	broadcast     chan *Message
+	// rejections are messages not taken from clients, the reason is sent to the client alone.
+	rejections chan rejection
+	// saves queues messages to persist which stores them off the run loop and sends them back on saved
+	// in order, so that a slow store doesn't hold up other events of the dialog.
+	saves         chan *Message
+	saved         chan savedMessage
+	receipts      chan *Receipt
+	edits         chan *Edit
+	reactions     chan *ReactionEvent
+	typing        chan *Typing
+	typingTimeout time.Duration
+	// typingExpired gets timers of typingTimer once they fire.
+	typingExpired chan *typingTimer
+	snoozes       chan snooze
+	releases      chan string
+	backfills     chan backfill
+	// maxLifetime is how long clients stay registered, expirations gets clients once their timers fire.
+	maxLifetime time.Duration
+	expirations chan *Client
+	disconnect  chan closeRequest
+	blocks      chan block
+	register    chan *Client
+	unregister  chan *Client
+	// supersede closes connections of the user older than their latest one, sessions is nil unless
+	// users have a single session.
+	supersede chan string
+	sessions  *sessions
+
+	now func() time.Time
+	// snoozedSince and snoozedUntil are when snoozes of participants started and end, messages to them sent
+	// meanwhile are loaded from the store once the snooze ends. pending are messages to participants stored
+	// while those are being loaded.
+	snoozedSince map[string]time.Time
+	snoozedUntil map[string]time.Time
+	pending      map[string][]*Message
+	releaseTimer map[string]*time.Timer
+	// typingTimer are timers of participants typing, which tell the peer they stopped when they fire.
+	typingTimer map[string]*typingTimer
+	// blocked are participants blocked by their peer while the dialog is open, their messages are dropped.
+	blocked map[string]bool
+	// recent are the latest messages fanned out, replayed to new clients after the history they loaded.
+	recent []*Message
+
+	// mx guards online which counts open connections per participant, and opened, when participants
+	// last marked the dialog opened in the store.
+	mx     sync.RWMutex
+	online map[string]int
+	opened map[string]time.Time
 }
 
-func newHub() *Hub {
+func (s *Server) newHub(uuid1, uuid2 string) *Hub {
 	return &Hub{
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		clients:    make(map[*Client]bool),
+		uuid1:         uuid1,
+		uuid2:         uuid2,
+		store:         s.store,
+		autoUnarchive: s.autoUnarchive,
+		metrics:       s.metrics,
+		events:        s.events,
+		replayLimit:   s.replayLimit,
+		pingPeriod:    s.pingPeriod,
+		pongWait:      s.pongWait,
+		maxFrameSize:  s.maxFrameSize,
+		attachments:   s.attachments,
+		broadcast:     make(chan *Message),
+		rejections:    make(chan rejection),
+		saves:         make(chan *Message, saveQueueSize),
+		saved:         make(chan savedMessage),
+		receipts:      make(chan *Receipt),
+		edits:         make(chan *Edit),
+		reactions:     make(chan *ReactionEvent),
+		typing:        make(chan *Typing),
+		typingTimeout: s.typingTimeout,
+		typingExpired: make(chan *typingTimer),
+		snoozes:       make(chan snooze),
+		releases:      make(chan string),
+		backfills:     make(chan backfill),
+		maxLifetime:   s.maxLifetime,
+		expirations:   make(chan *Client),
+		disconnect:    make(chan closeRequest),
+		blocks:        make(chan block),
+		register:      make(chan *Client),
+		unregister:    make(chan *Client),
+		supersede:     make(chan string),
+		sessions:      s.sessions,
+		clients:       make(map[*Client]bool),
+		online:        make(map[string]int),
+		opened:        make(map[string]time.Time),
+		now:           s.now,
+		snoozedSince:  make(map[string]time.Time),
+		snoozedUntil:  make(map[string]time.Time),
+		pending:       make(map[string][]*Message),
+		releaseTimer:  make(map[string]*time.Timer),
+		typingTimer:   make(map[string]*typingTimer),
+		blocked:       make(map[string]bool),
+	}
+}
+
+func (h *Hub) ConversationID() string {
+	return ConversationID(h.uuid1, h.uuid2)
+}
+
+// peer returns the other participant of the dialog.
+func (h *Hub) peer(uuid string) string {
+	if uuid == h.uuid1 {
+		return h.uuid2
+	}
+	return h.uuid1
+}
+
+// Online reports whether the participant has at least one open connection to the dialog.
+func (h *Hub) Online(uuid string) bool {
+	return h.connections(uuid) > 0
+}
+
+func (h *Hub) openedAt(uuid string) time.Time {
+	h.mx.RLock()
+	defer h.mx.RUnlock()
+	return h.opened[uuid]
+}
+
+func (h *Hub) markOpened(uuid string, at time.Time) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	h.opened[uuid] = at
+}
+
+func (h *Hub) connections(uuid string) int {
+	h.mx.RLock()
+	defer h.mx.RUnlock()
+	return h.online[uuid]
+}
+
+func (h *Hub) addClient(client *Client) {
+	h.clients[client] = true
+	if h.maxLifetime > 0 {
+		client.expiresAt = h.now().Add(h.maxLifetime)
+		h.armLifetime(client, h.maxLifetime)
+	}
+	h.mx.Lock()
+	h.online[client.uuid]++
+	first := h.online[client.uuid] == 1
+	h.mx.Unlock()
+	if client.stream != nil && h.Online(h.peer(client.uuid)) {
+		h.sendWhere(func(c *Client) bool { return c == client }, h.presence(h.peer(client.uuid), true))
+	}
+	if first {
+		h.sendToStreams(h.peer(client.uuid), h.presence(client.uuid, true))
+	}
+	if h.replayLimit > 0 {
+		h.replay(client)
+	}
+}
+
+// join loads the history the client is replayed and registers it. The history is loaded before,
+// so that a slow store doesn't hold up the run loop.
+func (h *Hub) join(client *Client) {
+	if h.replayLimit > 0 {
+		client.history = h.loadHistory()
+	}
+	h.register <- client
+}
+
+// loadHistory returns the most recent messages of the dialog, oldest first.
+func (h *Hub) loadHistory() []*Message {
+	messages, err := h.store.LoadRecentMessages(context.Background(), h.uuid1, h.uuid2, h.replayLimit)
+	if err != nil {
+		log.Printf("err loading recent messages: %v", err)
+		return nil
+	}
+	return messages
+}
+
+// remember keeps the message among the recent ones, up to replayLimit of them.
+func (h *Hub) remember(m *Message) {
+	if h.replayLimit == 0 {
+		return
+	}
+	h.recent = append(h.recent, m)
+	if len(h.recent) > h.replayLimit {
+		h.recent = h.recent[len(h.recent)-h.replayLimit:]
+	}
+}
+
+func (h *Hub) presence(uuid string, online bool) *Presence {
+	return &Presence{Type: EventTypePresence, ConversationID: h.ConversationID(), User: uuid, Online: online}
+}
+
+// replay queues the history loaded by the new client to it, oldest first, followed by the messages fanned out
+// once it was loaded.
+func (h *Hub) replay(client *Client) {
+	messages := client.history
+	client.history = nil
+	var last int64
+	if len(messages) > 0 {
+		last = messages[len(messages)-1].ID
+	}
+	for _, m := range h.recent {
+		if m.ID > last {
+			messages = append(messages, m)
+		}
+	}
+	id := h.ConversationID()
+	since, snoozed := h.snoozedSince[client.uuid]
+	for _, m := range messages {
+		if snoozed && m.Receiver == client.uuid && !m.Timestamp.Before(since) {
+			// held until the snooze ends
+			continue
+		}
+		m = m.viewedBy(client.uuid)
+		m.ConversationID = id
+		b, err := json.Marshal(m)
+		if err != nil {
+			log.Printf("err marshaling %T: %v", m, err)
+			return
+		}
+		select {
+		case client.send <- b:
+		default:
+			// the client backfills messages which don't fit the buffer
+			return
+		}
+	}
+}
+
+type closeRequest struct {
+	code   int
+	reason string
+}
+
+type block struct {
+	uuid    string
+	blocked bool
+}
+
+// Disconnect closes connections of all clients of the dialog with the code and reason.
+func (h *Hub) Disconnect(code int, reason string) {
+	h.disconnect <- closeRequest{code: code, reason: reason}
+}
+
+func (h *Hub) removeClient(client *Client, code int, reason string) {
+	delete(h.clients, client)
+	if client.lifetimeTimer != nil {
+		client.lifetimeTimer.Stop()
+	}
+	if h.sessions != nil {
+		h.sessions.end(client)
+	}
+	client.closeFrame = websocket.FormatCloseMessage(code, reason)
+	close(client.send)
+	h.mx.Lock()
+	left := false
+	if h.online[client.uuid]--; h.online[client.uuid] <= 0 {
+		delete(h.online, client.uuid)
+		left = true
+	}
+	h.mx.Unlock()
+	if left {
+		h.stopTyping(client.uuid, true)
+		h.sendToStreams(h.peer(client.uuid), h.presence(client.uuid, false))
+	}
+}
+
+func (h *Hub) armLifetime(client *Client, wait time.Duration) {
+	client.lifetimeTimer = time.AfterFunc(wait, func() {
+		h.expirations <- client
+	})
+}
+
+// expire closes the client with CloseReconnect unless it is gone already or, by the clock of the hub,
+// its lifetime isn't over yet, which rearms the timer.
+func (h *Hub) expire(client *Client) {
+	if !h.clients[client] {
+		return
+	}
+	if wait := client.expiresAt.Sub(h.now()); wait > 0 {
+		h.armLifetime(client, wait)
+		return
+	}
+	h.removeClient(client, CloseReconnect, ReasonLifetime)
+}
+
+// closeSuperseded closes connections of the user but their latest one.
+func (h *Hub) closeSuperseded(uuid string) {
+	for client := range h.clients {
+		if client.uuid == uuid && h.sessions.superseded(client) {
+			h.removeClient(client, CloseSuperseded, ReasonSuperseded)
+		}
 	}
 }
 
 func (h *Hub) run() {
+	go h.persist()
 	for {
 		select {
 		case client := <-h.register:
-			h.clients[client] = true
+			if h.blocked[client.uuid] {
+				// connections of a blocked participant are closed as soon as they're open
+				client.closeFrame = websocket.FormatCloseMessage(ClosePolicyViolation, ReasonBlocked)
+				close(client.send)
+				continue
+			}
+			h.addClient(client)
+			if h.sessions != nil {
+				h.sessions.start(client)
+				h.closeSuperseded(client.uuid)
+			}
+		case uuid := <-h.supersede:
+			h.closeSuperseded(uuid)
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
+				h.removeClient(client, websocket.CloseNormalClosure, "")
 			}
 		case message := <-h.broadcast:
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
+			h.deliver(message)
+		case r := <-h.rejections:
+			h.sendWhere(func(c *Client) bool { return c == r.client }, r.event)
+		case saved := <-h.saved:
+			h.fanOut(saved)
+		case receipt := <-h.receipts:
+			h.send(receipt)
+		case edit := <-h.edits:
+			for _, uuid := range []string{h.uuid1, h.uuid2} {
+				if uuid == edit.Message.Sender || !h.holding(uuid) {
+					h.sendTo(uuid, &Edit{Type: edit.Type, Message: edit.Message.viewedBy(uuid)})
 				}
 			}
+		case reaction := <-h.reactions:
+			h.sendTo(reaction.User, reaction)
+			if peer := h.peer(reaction.User); !h.holding(peer) {
+				h.sendTo(peer, reaction)
+			}
+		case typing := <-h.typing:
+			h.handleTyping(typing)
+		case timer := <-h.typingExpired:
+			for uuid, t := range h.typingTimer {
+				// a timer replaced by later typing may fire before it's stopped
+				if t == timer {
+					h.stopTyping(uuid, true)
+				}
+			}
+		case sn := <-h.snoozes:
+			h.snooze(sn.uuid, sn.since, sn.until)
+		case uuid := <-h.releases:
+			if until, ok := h.snoozedUntil[uuid]; ok {
+				// rearms the timer unless the snooze is over by the clock of the hub
+				h.snooze(uuid, h.snoozedSince[uuid], until)
+			}
+		case b := <-h.backfills:
+			h.sendBackfill(b)
+		case client := <-h.expirations:
+			h.expire(client)
+		case req := <-h.disconnect:
+			for client := range h.clients {
+				h.removeClient(client, req.code, req.reason)
+			}
+		case b := <-h.blocks:
+			h.setBlocked(b.uuid, b.blocked)
+		}
+	}
+}
+
+// rejection is a frame of the client the hub didn't take, event tells the client why.
+type rejection struct {
+	client *Client
+	event  *Rejection
+}
+
+// saveQueueSize is the number of messages of a dialog waiting to be stored before deliver waits for the store.
+const saveQueueSize = 64
+
+type savedMessage struct {
+	message       *Message
+	stored, fresh bool
+}
+
+// deliver queues a new message to be stored, fanOut sends it once it is. While the queue is full
+// deliver fans out messages stored meanwhile, as persist waits for the run loop to take them.
+func (h *Hub) deliver(m *Message) {
+	if h.blocked[m.Sender] {
+		// sent before the connection of the sender was closed
+		return
+	}
+	// peers stop showing the sender typing once the message arrives
+	h.stopTyping(m.Sender, false)
+	for {
+		select {
+		case h.saves <- m:
+			return
+		case saved := <-h.saved:
+			h.fanOut(saved)
+		}
+	}
+}
+
+// persist stores messages queued by deliver one by one and hands them back to the run loop in order.
+func (h *Hub) persist() {
+	for m := range h.saves {
+		stored, fresh := h.save(m)
+		h.saved <- savedMessage{message: m, stored: stored, fresh: fresh}
+	}
+}
+
+// fanOut sends a message once it's stored to both participants, acking it to the sender once it's stored
+// and once it's queued to a connection of the receiver.
+func (h *Hub) fanOut(saved savedMessage) {
+	m, stored, fresh := saved.message, saved.stored, saved.fresh
+	if h.blocked[m.Sender] {
+		// the sender was blocked while the message was being stored, their connections are closed already
+		return
+	}
+	// a snooze may end between timer ticks on a clock other than the wall one
+	h.release(m.Receiver)
+	if stored {
+		h.sendTo(m.Sender, &Ack{Type: AckTypeSent, MessageID: m.ID, Key: m.Key})
+	}
+	h.sendTo(m.Sender, m.viewedBy(m.Sender))
+	if !fresh {
+		// only the sender's connections are waiting for the echo of a retried message
+		return
+	}
+	if stored {
+		h.remember(m)
+	}
+	if _, ok := h.snoozedUntil[m.Receiver]; ok {
+		// loaded from the store once the snooze ends
+		return
+	}
+	if pending, ok := h.pending[m.Receiver]; ok {
+		h.pending[m.Receiver] = append(pending, m)
+		return
+	}
+	if h.sendTo(m.Receiver, m.viewedBy(m.Receiver)) > 0 && stored {
+		h.sendTo(m.Sender, &Ack{Type: AckTypeDelivered, MessageID: m.ID, Key: m.Key})
+	}
+}
+
+// setBlocked drops later messages of the participant if blocked and closes their connections.
+func (h *Hub) setBlocked(uuid string, blocked bool) {
+	if !blocked {
+		delete(h.blocked, uuid)
+		return
+	}
+	h.blocked[uuid] = true
+	for client := range h.clients {
+		if client.uuid == uuid {
+			h.removeClient(client, ClosePolicyViolation, ReasonBlocked)
+		}
+	}
+}
+
+// handleTyping tells the peer the user is typing or has stopped. Typing is forwarded as is and rearms
+// the timer telling the peer the user stopped, unless they type again, send a message or stop first.
+func (h *Hub) handleTyping(typing *Typing) {
+	if typing.Type == EventTypeTypingStopped {
+		h.stopTyping(typing.User, true)
+		return
+	}
+	if timer, ok := h.typingTimer[typing.User]; ok {
+		timer.Stop()
+	}
+	// the callback sends the pointer allocated before the timer starts, so it never reads what the hub writes
+	timer := &typingTimer{}
+	timer.Timer = time.AfterFunc(h.typingTimeout, func() {
+		h.typingExpired <- timer
+	})
+	h.typingTimer[typing.User] = timer
+	h.sendTo(h.peer(typing.User), typing)
+}
+
+// typingTimer identifies a typing timeout, the hub tells a timer which has fired from ones replacing it
+// by the pointer.
+type typingTimer struct {
+	*time.Timer
+}
+
+// stopTyping disarms the typing timer of the user and tells the peer they stopped if notify is set.
+// Users who aren't typing are left alone.
+func (h *Hub) stopTyping(uuid string, notify bool) {
+	timer, ok := h.typingTimer[uuid]
+	if !ok {
+		return
+	}
+	timer.Stop()
+	delete(h.typingTimer, uuid)
+	if notify {
+		h.sendTo(h.peer(uuid), &Typing{Type: EventTypeTypingStopped, ConversationID: h.ConversationID(), User: uuid})
+	}
+}
+
+// maxHeldMessages caps messages sent once a snooze ends, older ones are backfilled by clients.
+const maxHeldMessages = 1000
+
+// Snooze is when a participant snoozed the dialog and until when.
+type Snooze struct {
+	Since time.Time
+	Until time.Time
+}
+
+type snooze struct {
+	uuid  string
+	since time.Time
+	until time.Time
+}
+
+// backfill carries messages sent to the participant during their snooze, loaded by loadHeld.
+type backfill struct {
+	uuid     string
+	messages []*Message
+}
+
+// snooze holds messages to the participant from since until the time, a past one releases held messages
+// at once. A snooze still on keeps when it started.
+func (h *Hub) snooze(uuid string, since, until time.Time) {
+	if timer, ok := h.releaseTimer[uuid]; ok {
+		timer.Stop()
+		delete(h.releaseTimer, uuid)
+	}
+	if current, ok := h.snoozedSince[uuid]; !ok || current.After(since) {
+		h.snoozedSince[uuid] = since
+	}
+	h.snoozedUntil[uuid] = until
+	wait := until.Sub(h.now())
+	if wait <= 0 {
+		h.release(uuid)
+		return
+	}
+	h.releaseTimer[uuid] = time.AfterFunc(wait, func() {
+		h.releases <- uuid
+	})
+}
+
+// holding reports whether events of the peer to the participant are held, as they are snoozed or
+// messages held for them are being loaded.
+func (h *Hub) holding(uuid string) bool {
+	if _, ok := h.snoozedUntil[uuid]; ok {
+		return true
+	}
+	_, ok := h.pending[uuid]
+	return ok
+}
+
+// release loads messages sent to the participant during their snooze once it is over, off the run loop.
+// Messages stored meanwhile are pending until those are sent.
+func (h *Hub) release(uuid string) {
+	until, ok := h.snoozedUntil[uuid]
+	if ok && h.now().Before(until) {
+		return
+	}
+	since, snoozed := h.snoozedSince[uuid]
+	delete(h.snoozedSince, uuid)
+	delete(h.snoozedUntil, uuid)
+	delete(h.releaseTimer, uuid)
+	if !snoozed {
+		return
+	}
+	if _, loading := h.pending[uuid]; !loading {
+		h.pending[uuid] = nil
+	}
+	go h.loadHeld(uuid, since, until)
+}
+
+// loadHeld loads messages held for the participant by the snooze and marks it released, so that a dialog
+// opened later doesn't send them again. A snooze ended by the participant is released by ending it.
+func (h *Hub) loadHeld(uuid string, since, until time.Time) {
+	ctx := context.Background()
+	messages, err := h.store.LoadMessagesSince(ctx, h.peer(uuid), uuid, since, maxHeldMessages)
+	if err != nil {
+		log.Printf("err loading held messages: %v", err)
+	}
+	if !until.IsZero() {
+		if err = h.store.ReleaseSnooze(ctx, uuid, h.peer(uuid), until); err != nil {
+			log.Printf("err releasing snooze: %v", err)
+		}
+	}
+	h.backfills <- backfill{uuid: uuid, messages: messages}
+}
+
+// sendBackfill sends messages held for the participant and then those pending meanwhile, acking them delivered
+// to the sender. Pending ones loaded already aren't sent twice.
+func (h *Hub) sendBackfill(b backfill) {
+	pending := h.pending[b.uuid]
+	delete(h.pending, b.uuid)
+	id := h.ConversationID()
+	var last int64
+	for _, m := range b.messages {
+		m.ConversationID = id
+		h.sendHeld(m)
+		last = m.ID
+	}
+	for _, m := range pending {
+		if m.ID > last {
+			h.sendHeld(m)
+		}
+	}
+}
+
+func (h *Hub) sendHeld(m *Message) {
+	if h.sendTo(m.Receiver, m.viewedBy(m.Receiver)) > 0 {
+		h.sendTo(m.Sender, &Ack{Type: AckTypeDelivered, MessageID: m.ID, Key: m.Key})
+	}
+}
+
+func (h *Hub) send(v interface{}) {
+	h.sendTo("", v)
+}
+
+// sendTo sends to connections of the participant, of both if uuid is empty, and returns the number
+// of connections it's queued to.
+func (h *Hub) sendTo(uuid string, v interface{}) int {
+	return h.sendWhere(func(c *Client) bool { return uuid == "" || c.uuid == uuid }, v)
+}
+
+// sendToStreams sends to streams of the participant only, connections to the dialog alone don't get presence.
+func (h *Hub) sendToStreams(uuid string, v interface{}) int {
+	return h.sendWhere(func(c *Client) bool { return c.uuid == uuid && c.stream != nil }, v)
+}
+
+// sendWhere sends to connections matching the filter and returns the number of connections it's queued to.
+// Connections which don't keep up are closed.
+func (h *Hub) sendWhere(match func(*Client) bool, v interface{}) int {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("err marshaling %T: %v", v, err)
+		return 0
+	}
+	var queued int
+	for client := range h.clients {
+		if !match(client) {
+			continue
 		}
+		h.metrics.SendBufferFill.Observe(bufferFill(client.send))
+		select {
+		case client.send <- b:
+			queued++
+		default:
+			h.metrics.DroppedConnections.Inc()
+			h.removeClient(client, CloseRateLimited, ReasonSlowConsumer)
+		}
+	}
+	return queued
+}
+
+func bufferFill(send chan []byte) float64 {
+	if cap(send) == 0 {
+		return 1
+	}
+	return float64(len(send)) / float64(cap(send))
+}
+
+// save stores the message and reports whether it is stored and whether it is a new one. A message with
+// an already sent key is replaced with the stored one.
+func (h *Hub) save(m *Message) (stored, fresh bool) {
+	ctx := context.Background()
+	err := h.store.SaveMessage(ctx, m)
+	switch {
+	case err == nil:
+		h.events.Messages.Inc()
+		stored = true
+	case errors.Is(err, common.ErrDuplicateMessage):
+		return true, false
+	default:
+		log.Printf("err saving message: %v", err)
+	}
+	if !h.autoUnarchive {
+		return stored, true
+	}
+	if err = h.store.ArchiveChat(ctx, m.Receiver, m.Sender, false); err != nil && !errors.Is(err, common.ErrChatNotFound) {
+		log.Printf("err unarchiving chat: %v", err)
 	}
+	return stored, true
 }
@@ -0,0 +1,1420 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/gerladeno/homie-core/pkg/metrics"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHubMultipleConnections(t *testing.T) {
+	server := NewServer(fakeStore{})
+	hub, err := server.GetDialog(context.Background(), "first", "second")
+	require.NoError(t, err)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WebsocketChatHandler(hub, r.URL.Query().Get("uuid"), w, r)
+	}))
+	defer ts.Close()
+	dial := func(uuid string) *websocket.Conn {
+		conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"?uuid="+uuid, nil)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return conn
+	}
+	tab1, tab2, peer := dial("first"), dial("first"), dial("second")
+	defer tab1.Close()
+	defer tab2.Close()
+	defer peer.Close()
+	require.Eventually(t, func() bool {
+		return hub.connections("first") == 2 && hub.Online("second")
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, peer.WriteMessage(websocket.TextMessage, []byte("hello")))
+	// queued frames may be written as one, the sender gets its message between the sent and delivered acks
+	readFrames := func(conn *websocket.Conn, count int) [][]byte {
+		var frames [][]byte
+		for len(frames) < count {
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+			_, b, err := conn.ReadMessage()
+			require.NoError(t, err)
+			frames = append(frames, bytes.Split(b, newline)...)
+		}
+		return frames
+	}
+	frames := map[*websocket.Conn][]byte{tab1: readFrames(tab1, 1)[0], tab2: readFrames(tab2, 1)[0]}
+	sent := readFrames(peer, 3)
+	require.JSONEq(t, `{"type": "sent", "message_id": 0}`, string(sent[0]))
+	require.JSONEq(t, `{"type": "delivered", "message_id": 0}`, string(sent[2]))
+	frames[peer] = sent[1]
+	for _, b := range frames {
+		var m Message
+		require.NoError(t, json.Unmarshal(b, &m))
+		require.Equal(t, "second", m.Sender)
+		require.Equal(t, "first", m.Receiver)
+		require.Equal(t, "hello", m.Body)
+		require.Equal(t, ConversationID("second", "first"), m.ConversationID)
+	}
+
+	stats := server.Stats()
+	require.Equal(t, 1, stats.Hubs)
+	require.Equal(t, 3, stats.Connections)
+	require.Equal(t, []HubStats{{ConversationID: hub.ConversationID(), Connections: 3}}, stats.PerHub)
+
+	require.NoError(t, tab1.Close())
+	require.Eventually(t, func() bool {
+		return hub.connections("first") == 1
+	}, time.Second, 10*time.Millisecond)
+	require.True(t, hub.Online("first"))
+	require.Equal(t, 2, server.Stats().Connections)
+}
+
+func TestConversationID(t *testing.T) {
+	id := ConversationID("first", "second")
+	require.Equal(t, id, ConversationID("second", "first"))
+	require.Len(t, id, 32)
+	require.NotEqual(t, id, ConversationID("first", "third"))
+}
+
+func TestHubDropsSlowClient(t *testing.T) {
+	m := metrics.NewChat()
+	server := NewServer(fakeStore{}, WithMetrics(m))
+	hub, err := server.GetDialog(context.Background(), "first", "second")
+	require.NoError(t, err)
+	slow := NewClient("first", hub, nil, make(chan []byte, 1))
+	hub.register <- slow
+	for i := 0; i < 3; i++ {
+		hub.broadcast <- &Message{Sender: "second", Receiver: "first", Body: "hello"}
+	}
+	require.Eventually(t, func() bool {
+		return !hub.Online("first")
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, float64(1), testutil.ToFloat64(m.DroppedConnections))
+}
+
+func TestCloseDialogBlocked(t *testing.T) {
+	server := NewServer(fakeStore{})
+	hub, err := server.GetDialog(context.Background(), "first", "second")
+	require.NoError(t, err)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WebsocketChatHandler(hub, "first", w, r)
+	}))
+	defer ts.Close()
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	defer conn.Close()
+	require.Eventually(t, func() bool {
+		return hub.Online("first")
+	}, time.Second, 10*time.Millisecond)
+
+	server.CloseDialog("second", "first", ClosePolicyViolation, ReasonBlocked)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	require.ErrorAs(t, err, &closeErr)
+	require.Equal(t, websocket.ClosePolicyViolation, closeErr.Code)
+	require.Equal(t, ReasonBlocked, closeErr.Text)
+	require.False(t, hub.Online("first"))
+}
+
+func TestSetBlocked(t *testing.T) {
+	server := NewServer(fakeStore{}, WithHistoryReplay(0))
+	hub, err := server.GetDialog(context.Background(), "first", "second")
+	require.NoError(t, err)
+	receiver := NewClient("first", hub, nil, make(chan []byte, 256))
+	hub.register <- receiver
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WebsocketChatHandler(hub, "second", w, r)
+	}))
+	defer ts.Close()
+	dial := func() *websocket.Conn {
+		conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		return conn
+	}
+	requireBlocked := func(conn *websocket.Conn) {
+		t.Helper()
+		for {
+			// frames queued before the block may come first
+			if _, _, err := conn.ReadMessage(); err != nil {
+				var closeErr *websocket.CloseError
+				require.ErrorAs(t, err, &closeErr)
+				require.Equal(t, websocket.ClosePolicyViolation, closeErr.Code)
+				require.Equal(t, ReasonBlocked, closeErr.Text)
+				return
+			}
+		}
+	}
+	conn := dial()
+	defer conn.Close()
+	require.Eventually(t, func() bool {
+		return hub.Online("second")
+	}, time.Second, 10*time.Millisecond)
+
+	server.SetBlocked("first", "second", true)
+	// a message already read from the connection of the blocked user is dropped too
+	hub.broadcast <- &Message{Sender: "second", Receiver: "first", Body: "still there?"}
+	_ = conn.WriteMessage(websocket.TextMessage, []byte("hello?"))
+	requireBlocked(conn)
+	require.False(t, hub.Online("second"))
+	select {
+	case b := <-receiver.send:
+		t.Fatalf("message of a blocked user delivered: %s", b)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	reconnected := dial()
+	defer reconnected.Close()
+	requireBlocked(reconnected)
+
+	server.SetBlocked("first", "second", false)
+	hub.broadcast <- &Message{Sender: "second", Receiver: "first", Body: "hi again"}
+	select {
+	case b := <-receiver.send:
+		require.Contains(t, string(b), "hi again")
+	case <-time.After(time.Second):
+		t.Fatal("message of an unblocked user not delivered")
+	}
+}
+
+// hiddenStore tells hidden pairs of users.
+type hiddenStore struct {
+	fakeStore
+	hidden map[[2]string]bool
+}
+
+func (s hiddenStore) IsHidden(_ context.Context, uuid, target string) (bool, error) {
+	return s.hidden[[2]string{uuid, target}], nil
+}
+
+func TestBlockedOnOpen(t *testing.T) {
+	server := NewServer(hiddenStore{hidden: map[[2]string]bool{{"first", "second"}: true}})
+	hub, err := server.GetDialog(context.Background(), "first", "second")
+	require.NoError(t, err)
+	receiver := NewClient("first", hub, nil, make(chan []byte, 16))
+	hub.register <- receiver
+	blocked := NewClient("second", hub, nil, make(chan []byte, 16))
+	hub.register <- blocked
+	_, ok := <-blocked.send
+	require.False(t, ok, "a participant hidden before the dialog opened is closed at once")
+	code, reason := parseCloseFrame(blocked.closeFrame)
+	require.Equal(t, ClosePolicyViolation, code)
+	require.Equal(t, ReasonBlocked, reason)
+
+	hub.broadcast <- &Message{Sender: "second", Receiver: "first", Body: "hello?"}
+	select {
+	case b := <-receiver.send:
+		t.Fatalf("message of a blocked user delivered: %s", b)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// historyStore keeps messages of a single dialog.
+type historyStore struct {
+	fakeStore
+	messages []*Message
+}
+
+func (s historyStore) LoadRecentMessages(_ context.Context, _, _ string, limit int) ([]*Message, error) {
+	if len(s.messages) <= limit {
+		return s.messages, nil
+	}
+	return s.messages[len(s.messages)-limit:], nil
+}
+
+func (s historyStore) StreamMessages(_ context.Context, _, _ string, fn func(*Message) error) error {
+	for _, m := range s.messages {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestHistoryReplayLimit(t *testing.T) {
+	var store historyStore
+	started := time.Now().Add(-time.Hour)
+	for i := 1; i <= 2*defaultReplayLimit; i++ {
+		store.messages = append(store.messages, &Message{
+			ID:        int64(i),
+			Sender:    "second",
+			Receiver:  "first",
+			Timestamp: common.NewTimestamp(started.Add(time.Duration(i) * time.Second)),
+			Body:      "hello",
+		})
+	}
+	server := NewServer(store, WithHistoryReplay(0))
+	hub, err := server.GetDialog(context.Background(), "first", "second")
+	require.NoError(t, err)
+	client := NewClient("first", hub, nil, make(chan []byte, 256))
+	hub.join(client)
+	require.Eventually(t, func() bool {
+		return len(client.send) == defaultReplayLimit
+	}, time.Second, 10*time.Millisecond)
+	for want := int64(defaultReplayLimit + 1); want <= 2*defaultReplayLimit; want++ {
+		var m Message
+		require.NoError(t, json.Unmarshal(<-client.send, &m))
+		require.Equal(t, want, m.ID)
+		require.Equal(t, hub.ConversationID(), m.ConversationID)
+	}
+}
+
+// slowHistoryStore tells when it starts loading recent messages and holds them until released.
+type slowHistoryStore struct {
+	historyStore
+	loading chan struct{}
+	release chan struct{}
+}
+
+func (s slowHistoryStore) LoadRecentMessages(ctx context.Context, uuid1, uuid2 string, limit int) ([]*Message, error) {
+	s.loading <- struct{}{}
+	<-s.release
+	return s.historyStore.LoadRecentMessages(ctx, uuid1, uuid2, limit)
+}
+
+func TestHistoryLoadedOffTheLoop(t *testing.T) {
+	store := slowHistoryStore{
+		historyStore: historyStore{messages: []*Message{{ID: 1, Sender: "second", Receiver: "first", Body: "hi"}}},
+		loading:      make(chan struct{}),
+		release:      make(chan struct{}),
+	}
+	server := NewServer(store, WithHistoryReplay(0))
+	hub, err := server.GetDialog(context.Background(), "first", "second")
+	require.NoError(t, err)
+	sender := NewClient("second", hub, nil, make(chan []byte, 256))
+	hub.register <- sender
+	client := NewClient("first", hub, nil, make(chan []byte, 256))
+	go hub.join(client)
+	<-store.loading
+
+	hub.broadcast <- &Message{ID: 2, Sender: "second", Receiver: "first", Body: "how are you?"}
+	require.Eventually(t, func() bool { return len(sender.send) > 0 }, time.Second, 10*time.Millisecond,
+		"the dialog is served while the history is being loaded")
+	close(store.release)
+	for want := int64(1); want <= 2; want++ {
+		select {
+		case b := <-client.send:
+			var m Message
+			require.NoError(t, json.Unmarshal(b, &m))
+			require.Equal(t, want, m.ID, "messages sent meanwhile follow the history")
+		case <-time.After(time.Second):
+			t.Fatalf("message %d isn't replayed", want)
+		}
+	}
+}
+
+func TestIsMine(t *testing.T) {
+	store := historyStore{messages: []*Message{
+		{ID: 1, Sender: "first", Receiver: "second", Body: "hi"},
+		{ID: 2, Sender: "second", Receiver: "first", Body: "hello"},
+	}}
+	server := NewServer(store, WithHistoryReplay(0))
+	hub, err := server.GetDialog(context.Background(), "first", "second")
+	require.NoError(t, err)
+	clients := map[string]*Client{}
+	for _, uuid := range []string{"first", "second"} {
+		clients[uuid] = NewClient(uuid, hub, nil, make(chan []byte, 256))
+		hub.join(clients[uuid])
+	}
+	// mine returns is_mine of the next messages the participant gets by their ids, skipping other frames
+	mine := func(uuid string, count int) map[int64]bool {
+		t.Helper()
+		result := make(map[int64]bool)
+		for len(result) < count {
+			select {
+			case b := <-clients[uuid].send:
+				var frame map[string]interface{}
+				require.NoError(t, json.Unmarshal(b, &frame))
+				if _, ok := frame["body"]; !ok {
+					continue
+				}
+				var m Message
+				require.NoError(t, json.Unmarshal(b, &m))
+				result[m.ID] = m.IsMine
+			case <-time.After(time.Second):
+				t.Fatalf("%s got %d messages of %d", uuid, len(result), count)
+			}
+		}
+		return result
+	}
+
+	require.Equal(t, map[int64]bool{1: true, 2: false}, mine("first", 2), "replayed history")
+	require.Equal(t, map[int64]bool{1: false, 2: true}, mine("second", 2))
+
+	hub.broadcast <- &Message{ID: 3, Sender: "second", Receiver: "first", Body: "how are you?"}
+	require.Equal(t, map[int64]bool{3: false}, mine("first", 1), "delivered messages")
+	require.Equal(t, map[int64]bool{3: true}, mine("second", 1), "the echo to the sender")
+
+	for uuid, want := range map[string]map[int64]bool{"first": {1: true, 2: false}, "second": {1: false, 2: true}} {
+		exported := make(map[int64]bool)
+		require.NoError(t, server.ExportMessages(context.Background(), uuid, hub.peer(uuid), func(m *Message) error {
+			exported[m.ID] = m.IsMine
+			return nil
+		}))
+		require.Equal(t, want, exported, "exported history of %s", uuid)
+	}
+}
+
+func TestInitFrame(t *testing.T) {
+	store := historyStore{messages: []*Message{{ID: 1, Sender: "second", Receiver: "first", Body: "hello"}}}
+	server := NewServer(store, WithHistoryReplay(0))
+	hub, err := server.GetDialog(context.Background(), "first", "second")
+	require.NoError(t, err)
+	init := []byte(`{"type":"conversation_init"}`)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WebsocketChatHandlerWithInit(hub, "first", init, w, r)
+	}))
+	defer ts.Close()
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	defer conn.Close()
+
+	var frames [][]byte
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(frames) < 2 {
+		_, frame, err := conn.ReadMessage()
+		require.NoError(t, err)
+		frames = append(frames, bytes.Split(frame, newline)...)
+	}
+	require.Equal(t, init, frames[0], "the init frame is ahead of replayed messages")
+	var m Message
+	require.NoError(t, json.Unmarshal(frames[1], &m))
+	require.Equal(t, int64(1), m.ID)
+}
+
+func TestTypingTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	server := NewServer(fakeStore{}, WithTypingTimeout(timeout))
+	hub, err := server.GetDialog(context.Background(), "first", "second")
+	require.NoError(t, err)
+	peer := NewClient("second", hub, nil, make(chan []byte, 256))
+	hub.register <- peer
+	// next returns the type of the next frame the peer gets, "" if there is none for twice the timeout
+	next := func() string {
+		t.Helper()
+		select {
+		case b := <-peer.send:
+			var frame map[string]interface{}
+			require.NoError(t, json.Unmarshal(b, &frame))
+			if typ, ok := frame["type"].(string); ok {
+				return typ
+			}
+			return "message"
+		case <-time.After(2 * timeout):
+			return ""
+		}
+	}
+	typing := func(typ string) {
+		hub.typing <- &Typing{Type: typ, ConversationID: hub.ConversationID(), User: "first"}
+	}
+
+	typing(EventTypeTyping)
+	started := time.Now()
+	require.Equal(t, EventTypeTyping, next())
+	require.Equal(t, EventTypeTypingStopped, next(), "the peer is told the user stopped without further input")
+	require.GreaterOrEqual(t, time.Since(started), timeout)
+	require.Empty(t, next())
+
+	// typing again rearms the timeout
+	typing(EventTypeTyping)
+	require.Equal(t, EventTypeTyping, next())
+	time.Sleep(timeout / 2)
+	typing(EventTypeTyping)
+	require.Equal(t, EventTypeTyping, next())
+	require.Equal(t, EventTypeTypingStopped, next())
+	require.Empty(t, next(), "a replaced timer doesn't stop typing twice")
+
+	typing(EventTypeTyping)
+	typing(EventTypeTypingStopped)
+	require.Equal(t, EventTypeTyping, next())
+	require.Equal(t, EventTypeTypingStopped, next())
+	require.Empty(t, next(), "an explicit stop disarms the timeout")
+
+	typing(EventTypeTyping)
+	hub.broadcast <- &Message{Sender: "first", Receiver: "second", Body: "hi"}
+	require.Equal(t, EventTypeTyping, next())
+	require.Equal(t, "message", next())
+	require.Empty(t, next(), "a message ends typing without a stop")
+}
+
+func TestKeepalive(t *testing.T) {
+	server := NewServer(fakeStore{}, WithKeepalive(50*time.Millisecond, 200*time.Millisecond))
+	hub, err := server.GetDialog(context.Background(), "first", "second")
+	require.NoError(t, err)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WebsocketChatHandler(hub, r.URL.Query().Get("uuid"), w, r)
+	}))
+	defer ts.Close()
+	dial := func(uuid string, pong bool) (*websocket.Conn, *int32) {
+		conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"?uuid="+uuid, nil)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		var pings int32
+		conn.SetPingHandler(func(data string) error {
+			atomic.AddInt32(&pings, 1)
+			if !pong {
+				return nil
+			}
+			return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		})
+		return conn, &pings
+	}
+
+	alive, pings := dial("first", true)
+	defer alive.Close()
+	silent, _ := dial("second", false)
+	defer silent.Close()
+	// control frames are handled while reading
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := silent.ReadMessage(); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			if _, _, err := alive.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not answering pings wasn't closed")
+	}
+	require.Eventually(t, func() bool {
+		return !hub.Online("second")
+	}, time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(pings) >= 6
+	}, time.Second, 10*time.Millisecond)
+	require.True(t, hub.Online("first"))
+}
+
+func TestWithKeepaliveDefaults(t *testing.T) {
+	server := NewServer(fakeStore{}, WithKeepalive(0, 0))
+	require.Equal(t, defaultPingPeriod, server.pingPeriod)
+	require.Equal(t, defaultPongWait, server.pongWait)
+	server = NewServer(fakeStore{}, WithKeepalive(time.Minute, 30*time.Second))
+	require.Equal(t, 27*time.Second, server.pingPeriod)
+}
+
+func TestMaxFrameSize(t *testing.T) {
+	server := NewServer(fakeStore{}, WithMaxFrameSize(64))
+	hub, err := server.GetDialog(context.Background(), "first", "second")
+	require.NoError(t, err)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WebsocketChatHandler(hub, r.URL.Query().Get("uuid"), w, r)
+	}))
+	defer ts.Close()
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"?uuid=first", nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("a", 64))))
+	_, frame, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.Contains(t, string(frame), strings.Repeat("a", 64), "frames within the limit are delivered")
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("a", 1<<20))))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err = conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	require.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig), "got %v", err)
+	require.Eventually(t, func() bool {
+		return !hub.Online("first")
+	}, time.Second, 10*time.Millisecond)
+
+	require.Equal(t, int64(defaultMaxFrameSize), NewServer(fakeStore{}, WithMaxFrameSize(0)).maxFrameSize)
+}
+
+// keyStore stores messages in memory deduplicating them by key like the real store.
+type keyStore struct {
+	fakeStore
+	mx       sync.Mutex
+	messages []*Message
+}
+
+func (s *keyStore) SaveMessage(_ context.Context, m *Message) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	for _, saved := range s.messages {
+		if m.Key != "" && saved.Key == m.Key && saved.Sender == m.Sender && saved.Receiver == m.Receiver {
+			m.ID, m.Timestamp, m.Body = saved.ID, saved.Timestamp, saved.Body
+			return common.ErrDuplicateMessage
+		}
+	}
+	m.ID = int64(len(s.messages) + 1)
+	saved := *m
+	s.messages = append(s.messages, &saved)
+	return nil
+}
+
+func (s *keyStore) LoadMessagesSince(_ context.Context, sender, receiver string, since time.Time, _ int) ([]*Message, error) { //nolint:lll
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	var messages []*Message
+	for _, saved := range s.messages {
+		if saved.Sender == sender && saved.Receiver == receiver && !saved.Timestamp.Before(since) {
+			m := *saved
+			messages = append(messages, &m)
+		}
+	}
+	return messages, nil
+}
+
+func TestHubDeduplicatesRetriedMessages(t *testing.T) {
+	store := &keyStore{}
+	server := NewServer(store)
+	hub, err := server.GetDialog(context.Background(), "first", "second")
+	require.NoError(t, err)
+	sender := NewClient("first", hub, nil, make(chan []byte, 16))
+	peer := NewClient("second", hub, nil, make(chan []byte, 16))
+	hub.register <- sender
+	hub.register <- peer
+
+	body, key, _ := parseIncoming([]byte(`{"body": "hello", "key": "0b8e3f4c-2f4e-4a44-9fd4-6d9a2b1c2e11"}`))
+	require.Equal(t, "hello", body)
+	for i := 0; i < 2; i++ {
+		hub.broadcast <- &Message{Sender: "first", Receiver: "second", Timestamp: common.NewTimestamp(time.Now()), Body: body, Key: key}
+	}
+	hub.broadcast <- &Message{Sender: "first", Receiver: "second", Timestamp: common.NewTimestamp(time.Now()), Body: "plain"}
+	// every message is acked as sent, only new ones as delivered too
+	require.Eventually(t, func() bool {
+		return len(sender.send) == 8 && len(peer.send) == 2
+	}, time.Second, 10*time.Millisecond)
+	var first, retried Message
+	<-sender.send
+	require.NoError(t, json.Unmarshal(<-sender.send, &first))
+	<-sender.send
+	<-sender.send
+	require.NoError(t, json.Unmarshal(<-sender.send, &retried))
+	require.Equal(t, first.ID, retried.ID)
+	require.Equal(t, key, retried.Key)
+	store.mx.Lock()
+	defer store.mx.Unlock()
+	require.Len(t, store.messages, 2)
+}
+
+// slowStore blocks saving messages until unblock is closed and counts dialogs saved.
+type slowStore struct {
+	fakeStore
+	unblock chan struct{}
+	chats   *int32
+}
+
+func (s slowStore) SaveMessage(_ context.Context, _ *Message) error {
+	<-s.unblock
+	return nil
+}
+
+func (s slowStore) SaveChat(_ context.Context, _, _ string) error {
+	atomic.AddInt32(s.chats, 1)
+	return nil
+}
+
+func TestHubStoresOffLoop(t *testing.T) {
+	store := slowStore{unblock: make(chan struct{}), chats: new(int32)}
+	server := NewServer(store)
+	hub, err := server.GetDialog(context.Background(), "first", "second")
+	require.NoError(t, err)
+	sender := NewClient("first", hub, nil, make(chan []byte, 16))
+	peer := NewClient("second", hub, nil, make(chan []byte, 16))
+	hub.register <- sender
+	hub.register <- peer
+
+	hub.broadcast <- &Message{Sender: "first", Receiver: "second", Timestamp: common.NewTimestamp(time.Now()), Body: "hello"}
+	// the dialog keeps serving events while the message is being stored
+	select {
+	case hub.typing <- &Typing{Type: EventTypeTyping, ConversationID: hub.ConversationID(), User: "second"}:
+	case <-time.After(time.Second):
+		t.Fatal("the dialog is held up by the store")
+	}
+	select {
+	case b := <-sender.send:
+		require.Contains(t, string(b), EventTypeTyping)
+	case <-time.After(time.Second):
+		t.Fatal("no typing while the message is being stored")
+	}
+	require.Empty(t, peer.send)
+	close(store.unblock)
+	require.Eventually(t, func() bool {
+		return len(peer.send) == 1
+	}, time.Second, 10*time.Millisecond)
+	var m Message
+	require.NoError(t, json.Unmarshal(<-peer.send, &m))
+	require.Equal(t, "hello", m.Body)
+
+	// connections to an open dialog don't save it again
+	_, err = server.GetDialog(context.Background(), "first", "second")
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(store.chats))
+}
+
+func TestHubAcks(t *testing.T) {
+	server := NewServer(&keyStore{})
+	hub, err := server.GetDialog(context.Background(), "first", "second")
+	require.NoError(t, err)
+	sender := NewClient("first", hub, nil, make(chan []byte, 16))
+	peer := NewClient("second", hub, nil, make(chan []byte, 16))
+	hub.register <- sender
+	hub.register <- peer
+	next := func(client *Client) map[string]interface{} {
+		t.Helper()
+		var frame map[string]interface{}
+		select {
+		case b := <-client.send:
+			require.NoError(t, json.Unmarshal(b, &frame))
+		case <-time.After(time.Second):
+			t.Fatal("no frame")
+		}
+		return frame
+	}
+	key := "0b8e3f4c-2f4e-4a44-9fd4-6d9a2b1c2e11"
+
+	hub.broadcast <- &Message{
+		Sender: "first", Receiver: "second", Timestamp: common.NewTimestamp(time.Now()), Body: "hello", Key: key,
+	}
+	require.Equal(t, map[string]interface{}{"type": AckTypeSent, "message_id": float64(1), "key": key}, next(sender))
+	require.Equal(t, "hello", next(sender)["body"])
+	require.Equal(t, map[string]interface{}{"type": AckTypeDelivered, "message_id": float64(1), "key": key}, next(sender))
+	require.Equal(t, "hello", next(peer)["body"])
+	require.Empty(t, peer.send, "acks are for the sender only")
+
+	// a message the receiver isn't connected for is sent but not delivered
+	hub.unregister <- peer
+	hub.broadcast <- &Message{
+		Sender: "first", Receiver: "second", Timestamp: common.NewTimestamp(time.Now()), Body: "are you there?",
+	}
+	require.Equal(t, map[string]interface{}{"type": AckTypeSent, "message_id": float64(2)}, next(sender))
+	require.Equal(t, "are you there?", next(sender)["body"])
+	require.Never(t, func() bool {
+		return len(sender.send) > 0
+	}, 50*time.Millisecond, 10*time.Millisecond)
+}
+
+// snoozeStore keeps messages like keyStore and starts dialogs with the snoozes, recording released ones.
+type snoozeStore struct {
+	*keyStore
+	snoozes  map[string]Snooze
+	released chan time.Time
+}
+
+func (s snoozeStore) GetSnoozes(context.Context, string, string) (map[string]Snooze, error) {
+	return s.snoozes, nil
+}
+
+func (s snoozeStore) ReleaseSnooze(_ context.Context, _, _ string, until time.Time) error {
+	s.released <- until
+	return nil
+}
+
+func TestSnoozeChat(t *testing.T) {
+	ctx := context.Background()
+	var (
+		mx  sync.Mutex
+		now = time.Now().UTC().Truncate(time.Millisecond)
+	)
+	clock := func() time.Time {
+		mx.Lock()
+		defer mx.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		mx.Lock()
+		defer mx.Unlock()
+		now = now.Add(d)
+	}
+	store := snoozeStore{keyStore: &keyStore{}, released: make(chan time.Time, 1)}
+	server := NewServer(store, WithClock(clock))
+	hub, err := server.GetDialog(ctx, "first", "second")
+	require.NoError(t, err)
+	sender := NewClient("first", hub, nil, make(chan []byte, 16))
+	peer := NewClient("second", hub, nil, make(chan []byte, 16))
+	hub.register <- sender
+	hub.register <- peer
+	next := func(client *Client) map[string]interface{} {
+		t.Helper()
+		var frame map[string]interface{}
+		select {
+		case b := <-client.send:
+			require.NoError(t, json.Unmarshal(b, &frame))
+		case <-time.After(time.Second):
+			t.Fatal("no frame")
+		}
+		return frame
+	}
+	noFrames := func(client *Client) {
+		t.Helper()
+		require.Never(t, func() bool {
+			return len(client.send) > 0
+		}, 50*time.Millisecond, 10*time.Millisecond)
+	}
+
+	// messages, edits and reactions of the peer are held until the snooze ends by the clock of the hub
+	until := now.Add(time.Hour)
+	require.NoError(t, server.SnoozeChat(ctx, "second", "first", until))
+	hub.broadcast <- &Message{Sender: "first", Receiver: "second", Timestamp: common.NewTimestamp(now), Body: "hello"}
+	require.Equal(t, AckTypeSent, next(sender)["type"])
+	require.Equal(t, "hello", next(sender)["body"])
+	_, err = server.EditMessage(ctx, "first", "second", 1, "hello!")
+	require.NoError(t, err)
+	require.Equal(t, EventTypeEdit, next(sender)["type"])
+	_, err = server.ReactToMessage(ctx, "first", "second", 1, "👍")
+	require.NoError(t, err)
+	require.Equal(t, EventTypeReaction, next(sender)["type"])
+	noFrames(peer)
+	advance(time.Hour)
+	// what the release timer sends once it fires
+	hub.releases <- "second"
+	require.Equal(t, "hello", next(peer)["body"], "held messages are loaded once the snooze is over")
+	require.Equal(t, map[string]interface{}{"type": AckTypeDelivered, "message_id": float64(1)}, next(sender))
+	require.Equal(t, until, <-store.released)
+
+	// the snoozer's own messages and those to them once the snooze is ended go as usual
+	require.NoError(t, server.SnoozeChat(ctx, "second", "first", now.Add(time.Hour)))
+	hub.broadcast <- &Message{Sender: "second", Receiver: "first", Timestamp: common.NewTimestamp(now), Body: "brb"}
+	next(peer)
+	require.Equal(t, "brb", next(peer)["body"])
+	require.Equal(t, "brb", next(sender)["body"])
+	next(peer)
+	hub.broadcast <- &Message{Sender: "first", Receiver: "second", Timestamp: common.NewTimestamp(now), Body: "ok"}
+	next(sender)
+	next(sender)
+	noFrames(peer)
+	require.NoError(t, server.SnoozeChat(ctx, "second", "first", time.Time{}))
+	require.Equal(t, "ok", next(peer)["body"])
+	require.Equal(t, map[string]interface{}{"type": AckTypeDelivered, "message_id": float64(3)}, next(sender))
+	noFrames(peer)
+
+	// a dialog opened after a restart holds messages stored before it by the snooze loaded from the store
+	store.snoozes = map[string]Snooze{"second": {Since: now, Until: now.Add(time.Hour)}}
+	restarted := NewServer(store, WithClock(clock))
+	hub, err = restarted.GetDialog(ctx, "first", "second")
+	require.NoError(t, err)
+	peer = NewClient("second", hub, nil, make(chan []byte, 16))
+	hub.register <- peer
+	advance(time.Hour)
+	hub.releases <- "second"
+	require.Equal(t, "ok", next(peer)["body"])
+	noFrames(peer)
+}
+
+func TestMaxLifetime(t *testing.T) {
+	ctx := context.Background()
+	var (
+		mx  sync.Mutex
+		now = time.Now()
+	)
+	clock := func() time.Time {
+		mx.Lock()
+		defer mx.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		mx.Lock()
+		defer mx.Unlock()
+		now = now.Add(d)
+	}
+	server := NewServer(&keyStore{}, WithClock(clock), WithMaxLifetime(100*time.Millisecond))
+	hub, err := server.GetDialog(ctx, "first", "second")
+	require.NoError(t, err)
+	older := NewClient("first", hub, nil, make(chan []byte, 16))
+	hub.register <- older
+	advance(60 * time.Millisecond)
+	newer := NewClient("second", hub, nil, make(chan []byte, 16))
+	hub.register <- newer
+	open := func(client *Client) bool {
+		select {
+		case _, ok := <-client.send:
+			return ok
+		default:
+			return true
+		}
+	}
+
+	require.Never(t, func() bool { return !open(older) }, 150*time.Millisecond, 10*time.Millisecond,
+		"connections are open until their lifetime is over by the clock")
+	advance(40 * time.Millisecond)
+	require.Eventually(t, func() bool { return !open(older) }, time.Second, 10*time.Millisecond)
+	code, reason := parseCloseFrame(older.closeFrame)
+	require.Equal(t, CloseReconnect, code)
+	require.Equal(t, ReasonLifetime, reason)
+	require.True(t, open(newer), "newer connections live on")
+
+	advance(60 * time.Millisecond)
+	require.Eventually(t, func() bool { return !open(newer) }, time.Second, 10*time.Millisecond)
+}
+
+func TestParseIncoming(t *testing.T) {
+	const key = "0b8e3f4c-2f4e-4a44-9fd4-6d9a2b1c2e11"
+	for frame, want := range map[string][2]string{
+		"hello": {"hello", ""},
+		`{"body": "hello", "key": "` + key + `"}`: {"hello", key},
+		`{"body": "hello", "key": "not-a-uuid"}`:  {"hello", ""},
+		`{"text": "hello"}`:                       {`{"text": "hello"}`, ""},
+		`{"body": "hello"`:                        {`{"body": "hello"`, ""},
+	} {
+		body, gotKey, _ := parseIncoming([]byte(frame))
+		require.Equal(t, want, [2]string{body, gotKey}, frame)
+	}
+	_, _, attachments := parseIncoming([]byte(`{"body": "", "attachments": [{"url": "https://a/1.png", "type": "image/png"}]}`))
+	require.Equal(t, []*Attachment{{URL: "https://a/1.png", Type: "image/png"}}, attachments)
+}
+
+func TestAttachments(t *testing.T) {
+	server := NewServer(fakeStore{})
+	hub, err := server.GetDialog(context.Background(), "first", "second")
+	require.NoError(t, err)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WebsocketChatHandler(hub, r.URL.Query().Get("uuid"), w, r)
+	}))
+	defer ts.Close()
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"?uuid=first", nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	defer conn.Close()
+	// queued frames may be written as one
+	var frames [][]byte
+	read := func() string {
+		if len(frames) == 0 {
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+			_, b, err := conn.ReadMessage()
+			require.NoError(t, err)
+			frames = bytes.Split(b, newline)
+		}
+		frame := frames[0]
+		frames = frames[1:]
+		return string(frame)
+	}
+
+	frame := `{"body": "look", "key": "0b8e3f4c-2f4e-4a44-9fd4-6d9a2b1c2e11", "attachments": [{"url": "ftp://a/1.png", "type": "image/png"}]}` //nolint:lll
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(frame)))
+	require.JSONEq(t, `{"type": "error", "key": "0b8e3f4c-2f4e-4a44-9fd4-6d9a2b1c2e11",
+		"error": "err attachment is not allowed: link \"ftp://a/1.png\", allowed schemes are https"}`, read())
+	frame = `{"body": "look", "attachments": [{"url": "https://a/1.pdf", "type": "application/pdf"}]}`
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(frame)))
+	require.Contains(t, read(), "allowed types are image/jpeg image/png image/gif image/webp")
+
+	frame = `{"body": "look", "attachments": [{"url": "https://a/1.png", "type": "image/png"}]}`
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(frame)))
+	require.JSONEq(t, `{"type": "sent", "message_id": 0}`, read())
+	var m Message
+	require.NoError(t, json.Unmarshal([]byte(read()), &m))
+	require.Equal(t, []*Attachment{{URL: "https://a/1.png", Type: "image/png"}}, m.Attachments)
+
+	rules := NewServer(fakeStore{}, WithAttachmentSchemes([]string{" HTTPS ", "s3"}),
+		WithAttachmentTypes([]string{"image/*", ""})).attachments
+	require.NoError(t, rules.check([]*Attachment{{URL: "s3://bucket/1.heic", Type: "image/heic"}}))
+	require.ErrorIs(t, rules.check([]*Attachment{{URL: "https://a/1.pdf", Type: "application/pdf"}}),
+		common.ErrInvalidAttachment)
+	require.ErrorIs(t, rules.check([]*Attachment{nil}), common.ErrInvalidAttachment)
+}
+
+type editStore struct {
+	fakeStore
+	mx       sync.Mutex
+	messages map[int64]*Message
+}
+
+func (s *editStore) EditMessage(_ context.Context, m *Message, since time.Time) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	saved, ok := s.messages[m.ID]
+	switch {
+	case !ok:
+		return common.ErrMessageNotFound
+	case saved.Sender != m.Sender:
+		return common.ErrNotMessageSender
+	case saved.Timestamp.Before(since):
+		return common.ErrEditWindowExpired
+	}
+	m.Timestamp = saved.Timestamp
+	saved.Body, saved.EditedAt = m.Body, m.EditedAt
+	return nil
+}
+
+func TestEditMessage(t *testing.T) {
+	now := time.Now().UTC()
+	store := &editStore{messages: map[int64]*Message{
+		1: {ID: 1, Sender: "first", Receiver: "second", Timestamp: common.NewTimestamp(now.Add(-time.Minute)), Body: "helo"},
+		2: {ID: 2, Sender: "first", Receiver: "second", Timestamp: common.NewTimestamp(now.Add(-time.Hour)), Body: "hi"},
+	}}
+	server := NewServer(store, WithEditWindow(10*time.Minute))
+	hub, err := server.GetDialog(context.Background(), "first", "second")
+	require.NoError(t, err)
+	sender := NewClient("first", hub, nil, make(chan []byte, 16))
+	peer := NewClient("second", hub, nil, make(chan []byte, 16))
+	hub.register <- sender
+	hub.register <- peer
+
+	_, err = server.EditMessage(context.Background(), "second", "first", 1, "hacked")
+	require.ErrorIs(t, err, common.ErrNotMessageSender)
+	_, err = server.EditMessage(context.Background(), "first", "second", 2, "hello")
+	require.ErrorIs(t, err, common.ErrEditWindowExpired)
+	_, err = server.EditMessage(context.Background(), "first", "second", 1, " ")
+	require.ErrorIs(t, err, common.ErrInvalidMessage)
+	require.Empty(t, sender.send)
+	require.Empty(t, peer.send)
+
+	m, err := server.EditMessage(context.Background(), "first", "second", 1, "hello")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), m.ID)
+	require.Equal(t, common.NewTimestamp(now.Add(-time.Minute)), m.Timestamp)
+	require.NotNil(t, m.EditedAt)
+	for _, client := range []*Client{sender, peer} {
+		var edit struct {
+			Type    string  `json:"type"`
+			Message Message `json:"message"`
+		}
+		select {
+		case b := <-client.send:
+			require.NoError(t, json.Unmarshal(b, &edit))
+		case <-time.After(time.Second):
+			t.Fatal("no edit event")
+		}
+		require.Equal(t, EventTypeEdit, edit.Type)
+		require.Equal(t, int64(1), edit.Message.ID)
+		require.Equal(t, "hello", edit.Message.Body)
+		require.Equal(t, hub.ConversationID(), edit.Message.ConversationID)
+		require.NotNil(t, edit.Message.EditedAt)
+		require.True(t, m.EditedAt.Equal(edit.Message.EditedAt.Time))
+	}
+}
+
+type reactStore struct {
+	fakeStore
+	mx        sync.Mutex
+	messages  map[int64]*Message
+	reactions map[int64]map[string]string
+}
+
+func (s *reactStore) SaveReaction(_ context.Context, uuid, peer string, id int64, emoji string, _ time.Time) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	m, ok := s.messages[id]
+	if !ok || !(m.Sender == uuid && m.Receiver == peer || m.Sender == peer && m.Receiver == uuid) {
+		return common.ErrMessageNotFound
+	}
+	if s.reactions[id] == nil {
+		s.reactions[id] = make(map[string]string)
+	}
+	s.reactions[id][uuid] = emoji
+	return nil
+}
+
+func (s *reactStore) DeleteReaction(_ context.Context, uuid, _ string, id int64) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if _, ok := s.reactions[id][uuid]; !ok {
+		return common.ErrReactionNotFound
+	}
+	delete(s.reactions[id], uuid)
+	return nil
+}
+
+func TestReactToMessage(t *testing.T) {
+	store := &reactStore{
+		messages:  map[int64]*Message{1: {ID: 1, Sender: "first", Receiver: "second", Body: "hi"}},
+		reactions: make(map[int64]map[string]string),
+	}
+	server := NewServer(store)
+	hub, err := server.GetDialog(context.Background(), "first", "second")
+	require.NoError(t, err)
+	sender := NewClient("first", hub, nil, make(chan []byte, 16))
+	peer := NewClient("second", hub, nil, make(chan []byte, 16))
+	hub.register <- sender
+	hub.register <- peer
+	expectEvent := func(emoji string) {
+		t.Helper()
+		for _, client := range []*Client{sender, peer} {
+			var event ReactionEvent
+			select {
+			case b := <-client.send:
+				require.NoError(t, json.Unmarshal(b, &event))
+			case <-time.After(time.Second):
+				t.Fatal("no reaction event")
+			}
+			require.Equal(t, ReactionEvent{Type: EventTypeReaction, ConversationID: hub.ConversationID(),
+				MessageID: 1, User: "second", Emoji: emoji}, event)
+		}
+	}
+
+	_, err = server.ReactToMessage(context.Background(), "second", "first", 1, "🦄")
+	require.ErrorIs(t, err, common.ErrInvalidReaction)
+	_, err = server.ReactToMessage(context.Background(), "second", "first", 2, "👍")
+	require.ErrorIs(t, err, common.ErrMessageNotFound)
+	require.ErrorIs(t, server.RemoveReaction(context.Background(), "second", "first", 1), common.ErrReactionNotFound)
+	require.Empty(t, sender.send)
+	require.Empty(t, peer.send)
+
+	reaction, err := server.ReactToMessage(context.Background(), "second", "first", 1, "👍")
+	require.NoError(t, err)
+	require.Equal(t, &Reaction{User: "second", Emoji: "👍"}, reaction)
+	expectEvent("👍")
+
+	// reacting again replaces the reaction
+	_, err = server.ReactToMessage(context.Background(), "second", "first", 1, "❤️")
+	require.NoError(t, err)
+	expectEvent("❤️")
+	require.Equal(t, map[string]string{"second": "❤️"}, store.reactions[1])
+
+	require.NoError(t, server.RemoveReaction(context.Background(), "second", "first", 1))
+	expectEvent("")
+	require.Empty(t, store.reactions[1])
+}
+
+func TestWithReactions(t *testing.T) {
+	store := &reactStore{
+		messages:  map[int64]*Message{1: {ID: 1, Sender: "first", Receiver: "second", Body: "hi"}},
+		reactions: make(map[int64]map[string]string),
+	}
+	server := NewServer(store, WithReactions([]string{"🦄", " 🐈 ", ""}))
+	_, err := server.ReactToMessage(context.Background(), "second", "first", 1, "👍")
+	require.ErrorIs(t, err, common.ErrInvalidReaction)
+	require.Contains(t, err.Error(), "allowed are 🦄 🐈")
+	require.Empty(t, store.reactions[1])
+	_, err = server.ReactToMessage(context.Background(), "second", "first", 1, "🐈")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"second": "🐈"}, store.reactions[1])
+
+	require.NoError(t, NewServer(store, WithReactions(nil)).CheckReaction("👍"), "no emoji keep the default")
+}
+
+// evictStore records evictions of empty chats and counts dialogs marked opened.
+type evictStore struct {
+	fakeStore
+	mx     sync.Mutex
+	opens  [][]string
+	before []time.Time
+	saves  int
+}
+
+func (s *evictStore) SaveChat(context.Context, string, string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.saves++
+	return nil
+}
+
+func (s *evictStore) EvictEmptyChats(_ context.Context, _ string, keep int, open []string, openedBefore time.Time) (int64, error) { //nolint:lll
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.opens = append(s.opens, append([]string{fmt.Sprint(keep)}, open...))
+	s.before = append(s.before, openedBefore)
+	return 0, nil
+}
+
+func (s *evictStore) savesCount() int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.saves
+}
+
+func TestMaxEmptyChats(t *testing.T) {
+	ctx := context.Background()
+	store := &evictStore{}
+	_, err := NewServer(store).GetDialog(ctx, "first", "second")
+	require.NoError(t, err)
+	require.Empty(t, store.opens, "empty chats are kept by default")
+	_, err = NewServer(store, WithMaxEmptyChats(2)).GetDialog(ctx, "first", "second")
+	require.NoError(t, err)
+	require.Empty(t, store.opens, "and without a max lifetime, as connections to other instances may last forever")
+
+	var (
+		mx  sync.Mutex
+		now = time.Date(2022, time.July, 1, 12, 0, 0, 0, time.UTC)
+	)
+	clock := func() time.Time {
+		mx.Lock()
+		defer mx.Unlock()
+		return now
+	}
+	server := NewServer(store, WithMaxEmptyChats(2), WithMaxLifetime(time.Hour), WithClock(clock))
+	hub, err := server.GetDialog(ctx, "first", "second")
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"2", "second"}}, store.opens, "the chat being opened is kept")
+	require.Equal(t, now.Add(-2*time.Hour), store.before[0], "as are chats opened within twice the max lifetime")
+	_, err = server.GetDialog(ctx, "first", "third")
+	require.NoError(t, err)
+	hub.register <- NewClient("second", hub, nil, make(chan []byte, 16))
+	require.Eventually(t, func() bool { return hub.Online("second") }, time.Second, 10*time.Millisecond)
+	_, err = server.GetDialog(ctx, "first", "fourth")
+	require.NoError(t, err)
+	require.Equal(t, []string{"2", "fourth", "second"}, store.opens[2], "and chats somebody is connected to")
+
+	hub.register <- NewClient("first", hub, nil, make(chan []byte, 16))
+	require.Eventually(t, func() bool { return hub.Online("first") }, time.Second, 10*time.Millisecond)
+	saves := store.savesCount()
+	_, err = server.GetDialog(ctx, "first", "second")
+	require.NoError(t, err)
+	require.Equal(t, saves, store.savesCount(), "further connections skip the store")
+	mx.Lock()
+	now = now.Add(time.Hour)
+	mx.Unlock()
+	_, err = server.GetDialog(ctx, "first", "second")
+	require.NoError(t, err)
+	require.Equal(t, saves+1, store.savesCount(), "unless the dialog was marked opened a max lifetime ago")
+}
+
+func TestSingleSession(t *testing.T) {
+	server := NewServer(fakeStore{}, WithSessionMode(SessionsSingle))
+	ctx := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hub, err := server.GetDialog(ctx, "first", r.URL.Query().Get("peer"))
+		require.NoError(t, err)
+		WebsocketChatHandler(hub, "first", w, r)
+	}))
+	defer ts.Close()
+	dial := func(peer string) *websocket.Conn {
+		conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"?peer="+peer, nil)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return conn
+	}
+	requireSuperseded := func(conn *websocket.Conn) {
+		t.Helper()
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		_, _, err := conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		require.ErrorAs(t, err, &closeErr)
+		require.Equal(t, CloseSuperseded, closeErr.Code)
+		require.Equal(t, ReasonSuperseded, closeErr.Text)
+	}
+	hub, err := server.GetDialog(ctx, "first", "second")
+	require.NoError(t, err)
+
+	older := dial("second")
+	defer older.Close()
+	require.Eventually(t, func() bool { return hub.Online("first") }, time.Second, 10*time.Millisecond)
+	newer := dial("second")
+	defer newer.Close()
+	requireSuperseded(older)
+	require.Equal(t, 1, hub.connections("first"), "the second connection closes the first")
+
+	// connections to other dialogs are superseded too
+	other := dial("third")
+	defer other.Close()
+	requireSuperseded(newer)
+	require.Eventually(t, func() bool { return !hub.Online("first") }, time.Second, 10*time.Millisecond)
+
+	_, err = ParseSessionMode("exclusive")
+	require.Error(t, err)
+	mode, err := ParseSessionMode("")
+	require.NoError(t, err)
+	require.Equal(t, SessionsMultiple, mode)
+}
+
+func TestStream(t *testing.T) {
+	server := NewServer(&keyStore{})
+	ctx := context.Background()
+	hub2, err := server.GetDialog(ctx, "second", "first")
+	require.NoError(t, err)
+	hub3, err := server.GetDialog(ctx, "third", "first")
+	require.NoError(t, err)
+	second := NewClient("second", hub2, nil, make(chan []byte, 16))
+	third := NewClient("third", hub3, nil, make(chan []byte, 16))
+	hub2.register <- second
+	hub3.register <- third
+	notifications := make(chan []byte, 1)
+	acked := make(chan int64, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.ServeStream("first", []string{"second", "third", "sixth"}, StreamHooks{
+			Dial:          func(ctx context.Context, peer string) (*Hub, error) { return server.GetDialog(ctx, "first", peer) },
+			Notifications: notifications,
+			Ack:           func(seq int64) { acked <- seq },
+		}, w, r)
+	}))
+	defer ts.Close()
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	defer conn.Close()
+	require.Eventually(t, func() bool {
+		return hub2.Online("first") && hub3.Online("first")
+	}, time.Second, 10*time.Millisecond)
+	require.Len(t, server.hubsOf("first"), 2, "dialogs which aren't open are opened once used")
+
+	var queued []StreamFrame
+	// next returns the next stream frame of the type and the conversation, frames of others are kept for later
+	next := func(typ, id string) StreamFrame {
+		t.Helper()
+		for {
+			for i, frame := range queued {
+				if frame.Type == typ && frame.ConversationID == id {
+					queued = append(queued[:i], queued[i+1:]...)
+					return frame
+				}
+			}
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+			_, b, err := conn.ReadMessage()
+			require.NoError(t, err)
+			for _, line := range bytes.Split(b, newline) {
+				var frame StreamFrame
+				require.NoError(t, json.Unmarshal(line, &frame))
+				queued = append(queued, frame)
+			}
+		}
+	}
+	nextMessage := func(id, sender string) Message {
+		t.Helper()
+		for {
+			var m Message
+			require.NoError(t, json.Unmarshal(next(StreamTypeChat, id).Data, &m))
+			if m.Sender == sender && m.Body != "" {
+				return m
+			}
+		}
+	}
+	// nextOf returns the next frame of a peer of the type, messages have none, skipping others
+	nextOf := func(client *Client, typ interface{}) map[string]interface{} {
+		t.Helper()
+		for {
+			var frame map[string]interface{}
+			select {
+			case b := <-client.send:
+				require.NoError(t, json.Unmarshal(b, &frame))
+			case <-time.After(time.Second):
+				t.Fatal("no frame")
+			}
+			if frame["type"] == typ && frame["sender"] != client.uuid {
+				return frame
+			}
+		}
+	}
+	send := func(frame string) {
+		t.Helper()
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(frame)))
+	}
+
+	// messages of both dialogs are received tagged with their conversations
+	hub2.broadcast <- &Message{Sender: "second", Receiver: "first", Body: "hi from second"}
+	hub3.broadcast <- &Message{Sender: "third", Receiver: "first", Body: "hi from third"}
+	for peer, hub := range map[string]*Hub{"second": hub2, "third": hub3} {
+		// peers connected before the stream are told online first
+		var presence Presence
+		require.NoError(t, json.Unmarshal(next(StreamTypeChat, hub.ConversationID()).Data, &presence))
+		require.Equal(t, Presence{Type: EventTypePresence, ConversationID: hub.ConversationID(), User: peer, Online: true}, presence)
+		require.Equal(t, "hi from "+peer, nextMessage(hub.ConversationID(), peer).Body)
+	}
+
+	// messages are sent to the target by the peer uuid or by the conversation id
+	// bodies are normalized as those of connections to dialogs
+	send(`{"type": "message", "target": "second", "body": " hello\nsecond "}`)
+	send(`{"type": "message", "target": "` + hub3.ConversationID() + `", "body": "hello third"}`)
+	for peer, client := range map[string]*Client{"second": second, "third": third} {
+		frame := nextOf(client, nil)
+		require.Equal(t, "hello "+peer, frame["body"])
+		require.Equal(t, "first", frame["sender"])
+	}
+	var ack Ack
+	require.NoError(t, json.Unmarshal(next(StreamTypeChat, hub2.ConversationID()).Data, &ack))
+	require.Equal(t, AckTypeSent, ack.Type)
+
+	send(`{"type": "typing", "target": "third"}`)
+	require.Equal(t, map[string]interface{}{
+		"type": EventTypeTyping, "conversation_id": hub3.ConversationID(), "user": "first",
+	}, nextOf(third, EventTypeTyping))
+	send(`{"type": "typing_stopped", "target": "third"}`)
+	require.Equal(t, map[string]interface{}{
+		"type": EventTypeTypingStopped, "conversation_id": hub3.ConversationID(), "user": "first",
+	}, nextOf(third, EventTypeTypingStopped))
+
+	notifications <- []byte(`{"seq": 7, "data": {"type": "match"}}`)
+	require.JSONEq(t, `{"seq": 7, "data": {"type": "match"}}`, string(next(StreamTypeNotification, "").Data))
+	send(`{"type": "ack", "seq": 7}`)
+	select {
+	case seq := <-acked:
+		require.Equal(t, int64(7), seq)
+	case <-time.After(time.Second):
+		t.Fatal("no ack")
+	}
+
+	// dialogs opened later are joined, messaging a dialog which isn't open dials it
+	hub4, err := server.GetDialog(ctx, "fourth", "first")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return hub4.Online("first") }, time.Second, 10*time.Millisecond)
+	send(`{"type": "message", "target": "fifth", "body": "hello fifth"}`)
+	require.Equal(t, "hello fifth", nextMessage(ConversationID("first", "fifth"), "first").Body)
+
+	// a blocked dialog leaves the stream, which stays open for others
+	server.CloseDialog("first", "second", ClosePolicyViolation, ReasonBlocked)
+	closed := next(StreamTypeClosed, hub2.ConversationID())
+	require.Equal(t, ClosePolicyViolation, closed.Code)
+	require.Equal(t, ReasonBlocked, closed.Reason)
+	send(`{"type": "typing", "target": "second"}`)
+	require.Equal(t, common.ErrChatNotFound.Error(), next(StreamTypeError, "").Error)
+	hub3.broadcast <- &Message{Sender: "third", Receiver: "first", Body: "still there?"}
+	require.Equal(t, "still there?", nextMessage(hub3.ConversationID(), "third").Body)
+
+	// a shutdown closes the whole stream
+	server.Shutdown()
+	for {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		if _, _, err = conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	var closeErr *websocket.CloseError
+	require.ErrorAs(t, err, &closeErr)
+	require.Equal(t, CloseShutdown, closeErr.Code)
+	require.Eventually(t, func() bool { return !hub3.Online("first") && !hub4.Online("first") }, time.Second, 10*time.Millisecond)
+}
+
+func TestStreamDropsSlowConsumer(t *testing.T) {
+	server := NewServer(fakeStore{})
+	hub, err := server.GetDialog(context.Background(), "second", "first")
+	require.NoError(t, err)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.ServeStream("first", []string{"second"}, StreamHooks{}, w, r)
+	}))
+	defer ts.Close()
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	defer conn.Close()
+	require.Eventually(t, func() bool { return hub.Online("first") }, time.Second, 10*time.Millisecond)
+
+	// the client doesn't read, so the stream falls behind once the socket buffers are full
+	body := strings.Repeat("x", maxMessageSize)
+	require.Eventually(t, func() bool {
+		for i := 0; i < streamBuffer; i++ {
+			hub.broadcast <- &Message{Sender: "second", Receiver: "first", Body: body}
+		}
+		return !hub.Online("first")
+	}, 5*time.Second, 10*time.Millisecond)
+	for {
+		if _, _, err = conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	var closeErr *websocket.CloseError
+	require.ErrorAs(t, err, &closeErr)
+	require.Equal(t, CloseRateLimited, closeErr.Code)
+	require.Equal(t, ReasonSlowConsumer, closeErr.Text)
+}
@@ -0,0 +1,72 @@
+package chat
+
+import (
+	"fmt"
+	"sync"
+)
+
+// SessionMode tells whether a user may have several chat connections at once.
+type SessionMode string
+
+const (
+	// SessionsMultiple lets a user stay connected from several tabs and devices at once, which is the default.
+	SessionsMultiple SessionMode = "multiple"
+	// SessionsSingle keeps the most recent connection of a user only, older ones of every dialog are closed
+	// with CloseSuperseded.
+	SessionsSingle SessionMode = "single"
+)
+
+// ParseSessionMode parses a session mode, empty means SessionsMultiple.
+func ParseSessionMode(mode string) (SessionMode, error) {
+	switch SessionMode(mode) {
+	case "", SessionsMultiple:
+		return SessionsMultiple, nil
+	case SessionsSingle:
+		return SessionsSingle, nil
+	}
+	return "", fmt.Errorf("err session mode %q is unknown, it's either %s or %s", mode, SessionsMultiple, SessionsSingle)
+}
+
+// sessions track the latest connection of every user in the SessionsSingle mode. A stream is a single
+// connection however many dialogs it has joined.
+type sessions struct {
+	mx     sync.Mutex
+	latest map[string]interface{}
+	// hubsOf returns the open dialogs of the user.
+	hubsOf func(uuid string) []*Hub
+}
+
+func newSessions(hubsOf func(uuid string) []*Hub) *sessions {
+	return &sessions{latest: make(map[string]interface{}), hubsOf: hubsOf}
+}
+
+// start makes the client the latest connection of its user and tells other dialogs of the user to close
+// older ones. The hub of the client closes them itself.
+func (s *sessions) start(client *Client) {
+	s.mx.Lock()
+	s.latest[client.uuid] = client.session()
+	s.mx.Unlock()
+	for _, h := range s.hubsOf(client.uuid) {
+		if h != client.hub {
+			// the other hub may be busy sending to this one
+			go func(h *Hub) { h.supersede <- client.uuid }(h)
+		}
+	}
+}
+
+// superseded tells whether a newer connection of the user of the client has started.
+func (s *sessions) superseded(client *Client) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	latest, ok := s.latest[client.uuid]
+	return ok && latest != client.session()
+}
+
+// end forgets the client if it's the latest connection of its user.
+func (s *sessions) end(client *Client) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.latest[client.uuid] == client.session() {
+		delete(s.latest, client.uuid)
+	}
+}
@@ -0,0 +1,389 @@
+package chat
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/homie-core/pkg/common"
+	"github.com/gorilla/websocket"
+)
+
+// streamBuffer is the number of frames queued to a stream and to each of its dialogs.
+const streamBuffer = 256
+
+// Types of stream frames.
+const (
+	// StreamTypeChat frames carry a frame of the dialog with the conversation id, as its connection would get it.
+	StreamTypeChat = "chat"
+	// StreamTypeNotification frames carry a notification event as is.
+	StreamTypeNotification = "notification"
+	// StreamTypeClosed frames tell that the dialog has left the stream with the code and reason its connection
+	// would be closed with. The rest of the stream stays open.
+	StreamTypeClosed = "closed"
+	// StreamTypeError frames tell why a frame of the client to the target failed.
+	StreamTypeError = "error"
+)
+
+// Types of frames clients send to a stream.
+const (
+	StreamSendMessage = "message"
+	StreamSendTyping  = "typing"
+	StreamSendAck     = "ack"
+	// StreamSendTypingStopped tells the peer the user has stopped typing before the typing timeout.
+	StreamSendTypingStopped = "typing_stopped"
+)
+
+// StreamFrame is a frame sent to a stream.
+type StreamFrame struct {
+	Type           string          `json:"type"`
+	ConversationID string          `json:"conversation_id,omitempty"`
+	Data           json.RawMessage `json:"data,omitempty"`
+	Code           int             `json:"code,omitempty"`
+	Reason         string          `json:"reason,omitempty"`
+	Target         string          `json:"target,omitempty"`
+	Error          string          `json:"error,omitempty"`
+}
+
+// streamIncoming is a frame clients send to a stream. Target is the peer uuid or the conversation id
+// of messages and typing, Seq the notification acked.
+type streamIncoming struct {
+	Type        string        `json:"type"`
+	Target      string        `json:"target"`
+	Body        *string       `json:"body"`
+	Key         string        `json:"key"`
+	Attachments []*Attachment `json:"attachments"`
+	Seq         int64         `json:"seq"`
+}
+
+// StreamHooks connect a stream to the rest of the service.
+type StreamHooks struct {
+	// Dial opens the dialog of the user with the peer when the client messages a dialog the stream hasn't joined.
+	Dial func(ctx context.Context, peer string) (*Hub, error)
+	// Notifications are frames sent as StreamTypeNotification ones, nil if there are none.
+	Notifications <-chan []byte
+	// Ack is called with seqs of notifications the client acks.
+	Ack func(seq int64)
+}
+
+// Stream is a single connection of a user to all of their open dialogs. It joins every dialog as
+// a client of its own, frames of dialogs are tagged with conversation ids.
+type Stream struct {
+	uuid   string
+	server *Server
+	conn   *websocket.Conn
+	hooks  StreamHooks
+	out    chan []byte
+
+	// mx guards clients, the clients of the stream per dialog.
+	mx      sync.Mutex
+	clients map[*Hub]*Client
+
+	done      chan struct{}
+	closeOnce sync.Once
+	// closeFrame is set before done is closed.
+	closeFrame []byte
+}
+
+// ServeStream upgrades the request to a stream of the user joined to their open dialogs with the peers and to
+// dialogs of the user opened later. Dialogs with the peers which aren't open are opened once the client sends
+// them a message or once the peer opens them, so that a stream costs nothing for idle ones.
+// It returns once the stream is closed. A stream which doesn't keep up is closed with CloseRateLimited,
+// one whose dialogs shut down or are superseded with the same code.
+func (s *Server) ServeStream(uuid string, peers []string, hooks StreamHooks, w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	st := &Stream{
+		uuid:    uuid,
+		server:  s,
+		conn:    conn,
+		hooks:   hooks,
+		out:     make(chan []byte, streamBuffer),
+		clients: make(map[*Hub]*Client),
+		done:    make(chan struct{}),
+	}
+	s.mx.Lock()
+	m, ok := s.streams[uuid]
+	if !ok {
+		m = make(map[*Stream]bool)
+		s.streams[uuid] = m
+	}
+	m[st] = true
+	// registered first, so that dialogs opened meanwhile are joined by openHub
+	hubs := make([]*Hub, 0, len(peers))
+	for _, peer := range peers {
+		if h, ok := s.hubs[uuid][peer]; ok {
+			hubs = append(hubs, h)
+		}
+	}
+	s.mx.Unlock()
+	for _, h := range hubs {
+		st.join(h)
+	}
+	if hooks.Notifications != nil {
+		go st.forwardNotifications(hooks.Notifications)
+	}
+	go st.writePump()
+	st.readPump()
+}
+
+// join registers a client of the stream to the dialog unless it has joined it already.
+func (st *Stream) join(h *Hub) {
+	client := NewClient(st.uuid, h, nil, make(chan []byte, streamBuffer))
+	client.stream = st
+	if h.replayLimit > 0 {
+		// loaded before taking the lock, so that a slow store doesn't hold up leave
+		client.history = h.loadHistory()
+	}
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	if _, ok := st.clients[h]; ok || st.closed() {
+		return
+	}
+	st.clients[h] = client
+	// registered under the lock, so that leave unregisters every client registered
+	h.register <- client
+	go st.forward(h, client)
+}
+
+// leave unregisters clients of the stream from its dialogs and from the server.
+func (st *Stream) leave() {
+	st.server.mx.Lock()
+	delete(st.server.streams[st.uuid], st)
+	if len(st.server.streams[st.uuid]) == 0 {
+		delete(st.server.streams, st.uuid)
+	}
+	st.server.mx.Unlock()
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	for h, client := range st.clients {
+		h.unregister <- client
+	}
+}
+
+// forward tags frames of the dialog with its conversation id until the hub closes the client.
+func (st *Stream) forward(h *Hub, client *Client) {
+	id := h.ConversationID()
+	for frame := range client.send {
+		st.push(&StreamFrame{Type: StreamTypeChat, ConversationID: id, Data: frame})
+	}
+	code, reason := parseCloseFrame(client.closeFrame)
+	switch {
+	case st.closed() || code == websocket.CloseNormalClosure:
+	case code == ClosePolicyViolation:
+		st.mx.Lock()
+		delete(st.clients, h)
+		st.mx.Unlock()
+		st.push(&StreamFrame{Type: StreamTypeClosed, ConversationID: id, Code: code, Reason: reason})
+	default:
+		// shutdowns, slow dialogs and superseded sessions close the stream as they'd close the connection
+		st.close(code, reason)
+	}
+}
+
+func (st *Stream) forwardNotifications(frames <-chan []byte) {
+	for {
+		select {
+		case frame := <-frames:
+			st.push(&StreamFrame{Type: StreamTypeNotification, Data: frame})
+		case <-st.done:
+			return
+		}
+	}
+}
+
+// push queues the frame to the connection, a stream which doesn't keep up is closed.
+func (st *Stream) push(frame *StreamFrame) {
+	b, err := json.Marshal(frame)
+	if err != nil {
+		log.Printf("err marshaling %T: %v", frame, err)
+		return
+	}
+	select {
+	case st.out <- b:
+	case <-st.done:
+	default:
+		st.server.metrics.DroppedConnections.Inc()
+		st.close(CloseRateLimited, ReasonSlowConsumer)
+	}
+}
+
+// close closes the connection with the code and reason and makes the stream leave its dialogs at once,
+// rather than once the close frame is written.
+func (st *Stream) close(code int, reason string) {
+	st.closeOnce.Do(func() {
+		st.closeFrame = websocket.FormatCloseMessage(code, reason)
+		close(st.done)
+		// forward calls close, and the hub may be busy sending to it
+		go st.leave()
+	})
+}
+
+func (st *Stream) closed() bool {
+	select {
+	case <-st.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func parseCloseFrame(frame []byte) (code int, reason string) {
+	if len(frame) < 2 {
+		return websocket.CloseNoStatusReceived, ""
+	}
+	return int(binary.BigEndian.Uint16(frame)), string(frame[2:])
+}
+
+func (st *Stream) readPump() {
+	defer func() {
+		st.close(websocket.CloseNormalClosure, "")
+		st.conn.Close()
+	}()
+	pongWait := st.server.pongWait
+	// twice the limit of dialogs leaves room for the target and the key around a body
+	st.conn.SetReadLimit(2 * st.server.maxFrameSize)
+	st.conn.SetReadDeadline(time.Now().Add(pongWait))
+	st.conn.SetPongHandler(func(string) error { st.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	for {
+		_, frame, err := st.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("error: %v", err)
+			}
+			return
+		}
+		var in streamIncoming
+		if err = json.Unmarshal(frame, &in); err != nil {
+			st.push(&StreamFrame{Type: StreamTypeError, Error: common.ErrInvalidStreamFrame.Error()})
+			continue
+		}
+		if err = st.handle(&in); err != nil {
+			st.push(&StreamFrame{Type: StreamTypeError, Target: in.Target, Error: err.Error()})
+		}
+	}
+}
+
+func (st *Stream) handle(in *streamIncoming) error {
+	switch in.Type {
+	case StreamSendMessage:
+		if in.Body == nil {
+			return common.ErrInvalidMessage
+		}
+		if err := st.server.attachments.check(in.Attachments); err != nil {
+			return err
+		}
+		h, err := st.target(in.Target, true)
+		if err != nil {
+			return err
+		}
+		var key string
+		if common.IsValidUUID(in.Key) {
+			key = in.Key
+		}
+		h.broadcast <- &Message{
+			ConversationID: h.ConversationID(),
+			Sender:         st.uuid,
+			Receiver:       h.peer(st.uuid),
+			Timestamp:      common.NewTimestamp(time.Now()),
+			Body:           normalizeBody(*in.Body),
+			Attachments:    in.Attachments,
+			Key:            key,
+		}
+	case StreamSendTyping, StreamSendTypingStopped:
+		h, err := st.target(in.Target, false)
+		if err != nil {
+			return err
+		}
+		event := EventTypeTyping
+		if in.Type == StreamSendTypingStopped {
+			event = EventTypeTypingStopped
+		}
+		h.typing <- &Typing{Type: event, ConversationID: h.ConversationID(), User: st.uuid}
+	case StreamSendAck:
+		if st.hooks.Ack != nil && in.Seq > 0 {
+			st.hooks.Ack(in.Seq)
+		}
+	default:
+		return fmt.Errorf("%w: type %q is unknown", common.ErrInvalidStreamFrame, in.Type)
+	}
+	return nil
+}
+
+// target returns the joined dialog with the target, a peer uuid or a conversation id. Dialogs the stream
+// hasn't joined are dialed and joined if dial is set, ErrChatNotFound is returned otherwise.
+func (st *Stream) target(target string, dial bool) (*Hub, error) {
+	st.mx.Lock()
+	for h := range st.clients {
+		if h.ConversationID() == target || h.peer(st.uuid) == target {
+			st.mx.Unlock()
+			return h, nil
+		}
+	}
+	st.mx.Unlock()
+	if !dial || st.hooks.Dial == nil {
+		return nil, common.ErrChatNotFound
+	}
+	// the request context is gone once the connection is upgraded
+	ctx := context.Background()
+	peer := target
+	if IsConversationID(target) {
+		var err error
+		if peer, err = st.server.ResolveConversation(ctx, st.uuid, target); err != nil {
+			return nil, err
+		}
+	}
+	h, err := st.hooks.Dial(ctx, peer)
+	if err != nil {
+		return nil, err
+	}
+	st.join(h)
+	return h, nil
+}
+
+func (st *Stream) writePump() {
+	ticker := time.NewTicker(st.server.pingPeriod)
+	defer func() {
+		ticker.Stop()
+		st.conn.Close()
+	}()
+	for {
+		select {
+		case frame := <-st.out:
+			st.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			w, err := st.conn.NextWriter(websocket.TextMessage)
+			if err != nil {
+				return
+			}
+			w.Write(frame)
+
+			// Add queued frames to the current websocket message.
+			n := len(st.out)
+			for i := 0; i < n; i++ {
+				w.Write(newline)
+				w.Write(<-st.out)
+			}
+
+			if err := w.Close(); err != nil {
+				return
+			}
+		case <-ticker.C:
+			st.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := st.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-st.done:
+			st.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			st.conn.WriteMessage(websocket.CloseMessage, st.closeFrame)
+			return
+		}
+	}
+}
@@ -2,21 +2,165 @@ package common
 
 import (
 	"errors"
+	"fmt"
 	"io"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// Kinds of errors. Errors below match their kind with errors.Is so that callers may handle them by kind.
 var (
-	ErrConfigNotFound       = errors.New("config not found")
+	ErrNotFound      = errors.New("err not found")
+	ErrForbidden     = errors.New("err forbidden")
+	ErrConflict      = errors.New("err conflict")
+	ErrValidation    = errors.New("err validation failed")
+	ErrLimitExceeded = errors.New("err limit exceeded")
+	ErrRejected      = errors.New("err rejected")
+	ErrExpired       = errors.New("err expired")
+	ErrUnavailable   = errors.New("err unavailable")
+)
+
+var (
+	ErrConfigNotFound       = newError(ErrNotFound, "config not found")
 	ErrUnauthenticated      = errors.New("err user failed to authenticate")
-	ErrGenderNotSpecified   = errors.New("err gender not specified")
+	ErrGenderNotSpecified   = newError(ErrValidation, "err gender not specified")
+	ErrInvalidGender        = newError(ErrValidation, "err invalid gender")
+	ErrBioTooLong           = newError(ErrValidation, "err bio is too long")
+	ErrUnderage             = newError(ErrValidation, "err user is under minimum age")
+	ErrInvalidBirthdate     = newError(ErrValidation, "err invalid birthdate")
+	ErrMatchNotFound        = newError(ErrNotFound, "err match not found")
+	ErrMatchLimitReached    = newError(ErrConflict, "err active matches limit reached")
+	ErrPendingLikesLimit    = newError(ErrLimitExceeded, "err unreciprocated likes limit reached")
+	ErrChatNotFound         = newError(ErrNotFound, "err chat not found")
+	ErrMessageNotFound      = newError(ErrNotFound, "err message not found")
+	ErrDuplicateMessage     = newError(ErrConflict, "err message with the key is already sent")
+	ErrNotParticipant       = newError(ErrForbidden, "err not a participant of the chat")
+	ErrNotMatched           = newError(ErrForbidden, "err users have no active match")
+	ErrChatBlocked          = newError(ErrForbidden, "err one of the users has hidden the other")
+	ErrPhotoNotFound        = newError(ErrNotFound, "err photo not found")
+	ErrForeignPhoto         = newError(ErrForbidden, "err photo belongs to another user")
+	ErrUploadsDisabled      = newError(ErrNotFound, "err direct photo uploads are disabled")
+	ErrUploadNotFound       = newError(ErrNotFound, "err uploaded photo not found")
+	ErrInvalidPhotoOrder    = newError(ErrValidation, "err photo order must list every photo once")
+	ErrInvalidRegionWeights = newError(ErrValidation, "err invalid region weights")
+	ErrInvalidPatch         = newError(ErrValidation, "err invalid config patch")
+	ErrRejectedContent      = newError(ErrRejected, "err content rejected")
+	ErrTooManyRegions       = newError(ErrRejected, "err too many regions")
+	ErrInvalidUsername      = newError(ErrRejected, "err invalid username")
+	ErrInvalidBudget        = newError(ErrRejected, "err invalid budget")
+	ErrInvalidFeedSort      = newError(ErrValidation, "err invalid feed sort")
+	ErrInvalidAction        = newError(ErrValidation, "err invalid action")
+	ErrInvalidTimeRange     = newError(ErrValidation, "err invalid time range")
+	ErrBoostActive          = newError(ErrConflict, "err boost is already active")
+	ErrBoostCooldown        = newError(ErrLimitExceeded, "err boost is not available yet")
+	ErrInvalidBoostDuration = newError(ErrValidation, "err invalid boost duration")
 	ErrInvalidSigningMethod = errors.New("err invalid signing method")
 	ErrInvalidAccessToken   = errors.New("err invalid access token")
-	ErrInvalidPhoneNumber   = errors.New("err invalid phone number")
-	ErrPhoneNotFound        = errors.New("err phone not found")
+	ErrInvalidAudience      = errors.New("err token audience mismatch")
+	ErrMissingClaim         = errors.New("err token misses a required claim")
+	ErrInvalidPhoneNumber   = newError(ErrValidation, "err invalid phone number")
+	ErrPhoneNotFound        = newError(ErrNotFound, "err phone not found")
+	ErrInvalidBan           = newError(ErrValidation, "err invalid ban")
+	ErrInvalidRevocation    = newError(ErrValidation, "err invalid token revocation")
+	ErrInvalidAuditEntry    = newError(ErrValidation, "err invalid audit entry")
+	ErrInvalidTravel        = newError(ErrValidation, "err invalid travel")
+	ErrBanNotFound          = newError(ErrNotFound, "err ban not found")
+	ErrInvalidUUID          = newError(ErrValidation, "err invalid uuid")
+	ErrTooManyTargets       = newError(ErrValidation, "err too many targets")
+	ErrInvalidRegion        = newError(ErrValidation, "err invalid region")
+	ErrRegionNotFound       = newError(ErrNotFound, "err region not found")
+	ErrSwipingTooFast       = newError(ErrLimitExceeded, "err swiping too fast, cool down")
+	ErrNotMessageSender     = newError(ErrForbidden, "err message is sent by another user")
+	ErrEditWindowExpired    = newError(ErrConflict, "err message is too old to edit")
+	ErrInvalidMessage       = newError(ErrValidation, "err invalid message")
+	ErrDislikeNotFound      = newError(ErrNotFound, "err dislike not found")
+	ErrSnapshotExpired      = newError(ErrExpired, "err feed snapshot expired, restart paging")
+	ErrInvalidReport        = newError(ErrValidation, "err invalid report")
+	ErrInvalidSettings      = newError(ErrValidation, "err invalid settings")
+	ErrInvalidReaction      = newError(ErrValidation, "err reaction is not allowed")
+	ErrInvalidAttachment    = newError(ErrValidation, "err attachment is not allowed")
+	ErrReactionNotFound     = newError(ErrNotFound, "err reaction not found")
+	ErrInvalidDevice        = newError(ErrValidation, "err invalid device")
+	ErrDeviceNotFound       = newError(ErrNotFound, "err device not found")
+	ErrInvalidSnooze        = newError(ErrValidation, "err invalid snooze")
+	ErrPhotoLimitReached    = newError(ErrConflict, "err photos limit reached")
+	ErrPhotoRequired        = newError(ErrForbidden, "err a photo is required to browse candidates")
+	ErrEditingTooFast       = newError(ErrLimitExceeded, "err config is edited too often")
+	ErrStoreUnavailable     = newError(ErrUnavailable, "err datastore is unavailable")
+	ErrUnsupportedLanguage  = newError(ErrValidation, "err unsupported language")
+	ErrMissingDefaultBio    = newError(ErrValidation, "err translated bios need a default bio")
+	ErrTooManyRequests      = newError(ErrLimitExceeded, "err too many requests, slow down")
+	ErrTooManyInFlight      = newError(ErrLimitExceeded, "err too many writes in flight, wait for them")
+	ErrInvalidCursor        = newError(ErrValidation, "err invalid cursor")
+	ErrInvalidStreamFrame   = newError(ErrValidation, "err invalid stream frame")
+	ErrSelfChat             = newError(ErrValidation, "err users can't chat with themselves")
+	ErrResetNotConfirmed    = newError(ErrValidation, "err resetting decisions needs confirm to be true")
+	ErrNoDailyPick          = newError(ErrNotFound, "err no candidates for a daily pick")
 )
 
+// kindError is a sentinel error of a kind.
+type kindError struct {
+	text string
+	kind error
+}
+
+func newError(kind error, text string) error {
+	return &kindError{text: text, kind: kind}
+}
+
+func (e *kindError) Error() string {
+	return e.text
+}
+
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
+
+// FieldError points at the config field which caused Err.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Field, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// DegradedError is returned along with partial results of reads when optional subsystems failed.
+// Skipped names them, results are still usable without their data.
+type DegradedError struct {
+	Skipped []string
+	Err     error
+}
+
+func (e *DegradedError) Error() string {
+	return fmt.Sprintf("degraded without %s: %v", strings.Join(e.Skipped, ", "), e.Err)
+}
+
+func (e *DegradedError) Unwrap() error {
+	return e.Err
+}
+
+// RetryError tells that Err is temporary and the request may be retried after the duration.
+type RetryError struct {
+	After time.Duration
+	Err   error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%v, retry in %s", e.Err, e.After)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
 func IsValidUUID(u string) bool {
 	_, err := uuid.Parse(u)
 	return err == nil
@@ -0,0 +1,37 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// IDGenerator mints opaque ids of tokens and keys, so that tests may predict them as they do the time.
+type IDGenerator interface {
+	NewID() (string, error)
+}
+
+// RandomIDs mints random 128-bit ids in hex, it is the generator used unless tests replace it.
+type RandomIDs struct{}
+
+func (RandomIDs) NewID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("err generating id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SequentialIDs mints ids 1, 2, 3 and so on, formatted like random ones. The zero value starts at 1.
+type SequentialIDs struct {
+	mx   sync.Mutex
+	last uint64
+}
+
+func (s *SequentialIDs) NewID() (string, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.last++
+	return fmt.Sprintf("%032x", s.last), nil
+}
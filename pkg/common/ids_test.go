@@ -0,0 +1,31 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func ExampleSequentialIDs() {
+	var ids SequentialIDs
+	for i := 0; i < 2; i++ {
+		id, _ := ids.NewID()
+		fmt.Println(id)
+	}
+	// Output:
+	// 00000000000000000000000000000001
+	// 00000000000000000000000000000002
+}
+
+func TestRandomIDs(t *testing.T) {
+	id1, err := RandomIDs{}.NewID()
+	require.NoError(t, err)
+	id2, err := RandomIDs{}.NewID()
+	require.NoError(t, err)
+	require.Len(t, id1, 32)
+	require.NotEqual(t, id1, id2)
+	sequential, err := (&SequentialIDs{}).NewID()
+	require.NoError(t, err)
+	require.Len(t, sequential, len(id1), "sequential ids are formed like random ones")
+}
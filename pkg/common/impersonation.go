@@ -0,0 +1,16 @@
+package common
+
+import "context"
+
+type impersonatorKey struct{}
+
+// WithImpersonator marks the context of a request made by the admin impersonating the user.
+func WithImpersonator(ctx context.Context, admin string) context.Context {
+	return context.WithValue(ctx, impersonatorKey{}, admin)
+}
+
+// Impersonator returns the admin impersonating the user in the request of the context, empty if there is none.
+func Impersonator(ctx context.Context) string {
+	admin, _ := ctx.Value(impersonatorKey{}).(string)
+	return admin
+}
@@ -0,0 +1,65 @@
+package common
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// TimestampLayout is RFC 3339 with milliseconds, every Timestamp is serialized in UTC with it.
+const TimestampLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// Timestamp is a time serialized the same way in every response, so that clients parse a single format.
+// Any RFC 3339 time is accepted on input.
+type Timestamp struct {
+	time.Time
+}
+
+// NewTimestamp returns the time in UTC truncated to milliseconds, so that it is the same after a round trip
+// through JSON.
+func NewTimestamp(t time.Time) Timestamp {
+	return Timestamp{Time: t.UTC().Truncate(time.Millisecond)}
+}
+
+// NewTimestampPtr returns nil for nil, a pointer to the timestamp of the time otherwise.
+func NewTimestampPtr(t *time.Time) *Timestamp {
+	if t == nil {
+		return nil
+	}
+	ts := NewTimestamp(*t)
+	return &ts
+}
+
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.UTC().Format(TimestampLayout))
+}
+
+func (t *Timestamp) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("err parsing timestamp: %w", err)
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return fmt.Errorf("err parsing timestamp: %w", err)
+	}
+	*t = NewTimestamp(parsed)
+	return nil
+}
+
+func (t Timestamp) Value() (driver.Value, error) {
+	return t.Time, nil
+}
+
+func (t *Timestamp) Scan(src interface{}) error {
+	if src == nil {
+		return nil
+	}
+	parsed, ok := src.(time.Time)
+	if !ok {
+		return fmt.Errorf("err scanning timestamp")
+	}
+	*t = NewTimestamp(parsed)
+	return nil
+}
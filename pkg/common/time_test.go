@@ -0,0 +1,26 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimestampJSON(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	ts := NewTimestamp(time.Date(2022, time.June, 27, 15, 4, 5, 123456789, moscow))
+	b, err := json.Marshal(ts)
+	require.NoError(t, err)
+	require.Equal(t, `"2022-06-27T12:04:05.123Z"`, string(b))
+
+	b, err = json.Marshal(Timestamp{Time: time.Date(2022, time.June, 27, 15, 4, 5, 0, moscow)})
+	require.NoError(t, err)
+	require.Equal(t, `"2022-06-27T12:04:05.000Z"`, string(b), "times are in UTC with milliseconds even if built directly")
+
+	var parsed Timestamp
+	require.NoError(t, json.Unmarshal([]byte(`"2022-06-27T15:04:05.123456+03:00"`), &parsed))
+	require.Equal(t, ts, parsed)
+	require.Error(t, json.Unmarshal([]byte(`"27.06.2022"`), &parsed))
+}
@@ -1,6 +1,11 @@
 package logging
 
-import "github.com/sirupsen/logrus"
+import (
+	"context"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sirupsen/logrus"
+)
 
 func GetLogger(verbose bool) *logrus.Logger {
 	log := logrus.StandardLogger()
@@ -11,3 +16,17 @@ func GetLogger(verbose bool) *logrus.Logger {
 	}
 	return log
 }
+
+// Detach returns a background context with the request id of ctx, for work outliving the request
+// which logs on its behalf.
+func Detach(ctx context.Context) context.Context {
+	return context.WithValue(context.Background(), middleware.RequestIDKey, middleware.GetReqID(ctx))
+}
+
+// FromContext returns the logger with request_id set by middleware.RequestID if the context has one.
+func FromContext(ctx context.Context, log *logrus.Entry) *logrus.Entry {
+	if id := middleware.GetReqID(ctx); id != "" {
+		return log.WithField("request_id", id)
+	}
+	return log
+}
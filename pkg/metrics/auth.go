@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Auth struct {
+	FailuresTotal *prometheus.CounterVec
+}
+
+func NewAuth() *Auth {
+	return &Auth{
+		FailuresTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_failures_total",
+			Help: "How many requests failed to authenticate, partitioned by reason",
+		}, []string{"auth_failure_reason"}),
+	}
+}
+
+var authOnce sync.Once
+
+func (a *Auth) AutoRegister() *Auth {
+	authOnce.Do(func() {
+		a.mustRegister(prometheus.DefaultRegisterer)
+	})
+	return a
+}
+
+func (a *Auth) mustRegister(registerer prometheus.Registerer) {
+	registerer.MustRegister(a.FailuresTotal)
+}
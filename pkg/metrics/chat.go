@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Chat struct {
+	SendBufferFill     prometheus.Histogram
+	DroppedConnections prometheus.Counter
+}
+
+func NewChat() *Chat {
+	return &Chat{
+		SendBufferFill: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "chat_send_buffer_fill_ratio",
+			Help:    "Share of a connection send buffer occupied when a message is queued",
+			Buckets: []float64{0, 0.1, 0.25, 0.5, 0.75, 0.9, 1},
+		}),
+		DroppedConnections: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "chat_dropped_connections_total",
+			Help: "How many chat connections were dropped because their send buffer was full",
+		}),
+	}
+}
+
+var chatOnce sync.Once
+
+func (c *Chat) AutoRegister() *Chat {
+	chatOnce.Do(func() {
+		c.mustRegister(prometheus.DefaultRegisterer)
+	})
+	return c
+}
+
+func (c *Chat) mustRegister(registerer prometheus.Registerer) {
+	registerer.MustRegister(c.SendBufferFill, c.DroppedConnections)
+}
@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"encoding/hex"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -11,6 +12,8 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
 )
 
 type ContextKey string
@@ -21,9 +24,17 @@ func (c ContextKey) String() string {
 
 const ErrDescriptionCtxKey = ContextKey("errDescription")
 
-func NewPromMiddleware(host string) func(next http.Handler) http.Handler {
+// NewPromMiddleware collects metrics of incoming requests. When the metrics can't be registered,
+// e.g. another router in the process has registered them already, it serves requests without metrics.
+func NewPromMiddleware(host string, log logrus.FieldLogger) func(next http.Handler) http.Handler {
 	ip, port := getExposedIPPort()
-	c := NewHTTPIn(host, ip, port).AutoRegister()
+	c := NewHTTPIn(host, ip, port)
+	if err := c.register(prometheus.DefaultRegisterer); err != nil {
+		log.Warnf("err registering http metrics, serving without them: %v", err)
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
 			started := time.Now()
@@ -39,7 +50,7 @@ func NewPromMiddleware(host string) func(next http.Handler) http.Handler {
 			labels = append(labels, strconv.Itoa(wrappedWriter.Status()))
 			c.RespTotal.WithLabelValues(labels...).Inc()
 			c.RespBytesTotal.WithLabelValues(labels...).Add(float64(wrappedWriter.BytesWritten()))
-			c.RespTimeHist.WithLabelValues(labels...).Observe(float64(time.Since(started).Milliseconds()))
+			observeWithTrace(c.RespTimeHist.WithLabelValues(labels...), float64(time.Since(started).Milliseconds()), r)
 			c.RespTimeTotal.WithLabelValues(labels...).Set(float64(time.Since(started).Milliseconds()))
 			if wrappedWriter.Status() < 500 {
 				return
@@ -56,6 +67,35 @@ func NewPromMiddleware(host string) func(next http.Handler) http.Handler {
 	}
 }
 
+// observeWithTrace attaches the trace ID of sampled requests as an exemplar, which is exposed in
+// the OpenMetrics format only.
+func observeWithTrace(o prometheus.Observer, value float64, r *http.Request) {
+	if eo, ok := o.(prometheus.ExemplarObserver); ok {
+		if traceID := sampledTraceID(r.Header.Get("traceparent")); traceID != "" {
+			eo.ObserveWithExemplar(value, prometheus.Labels{"trace_id": traceID})
+			return
+		}
+	}
+	o.Observe(value)
+}
+
+// sampledTraceID returns the trace ID of a W3C traceparent header, "00-<trace id>-<parent id>-<flags>",
+// if the caller has sampled the trace, otherwise the exemplar would point to a trace that isn't kept.
+func sampledTraceID(traceparent string) string {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ""
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil || parts[1] == strings.Repeat("0", 32) {
+		return ""
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || flags[0]&1 == 0 {
+		return ""
+	}
+	return parts[1]
+}
+
 func getExposedIPPort() (string, string) {
 	addresses, _ := net.InterfaceAddrs()
 	ip := "UNKNOWN"
@@ -69,15 +109,13 @@ func getExposedIPPort() (string, string) {
 	return ip, port
 }
 
+// splitHostPort splits the remote address, which is a bare IP, IPv6 ones included, once RealIP or
+// anonymization of client IPs have replaced it.
 func splitHostPort(hostPort string) (string, string) {
-	var host, port string
-	x := strings.Split(hostPort, ":")
-	if len(x) > 1 {
-		port = x[len(x)-1]
-		x = x[:len(x)-1]
+	if host, port, err := net.SplitHostPort(hostPort); err == nil {
+		return host, port
 	}
-	host = strings.Join(x, ":")
-	return host, port
+	return hostPort, ""
 }
 
 var reUUID = regexp.MustCompile(`\b[0-9a-f]{8}\b-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-\b[0-9a-f]{12}\b`)
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPromMiddlewareRegistryConflict(t *testing.T) {
+	// a gauge of the same name as the requests counter makes registration of the http metrics fail
+	conflicting := prometheus.NewGauge(prometheus.GaugeOpts{Name: "http_in_requests_total", Help: "conflicting"})
+	prometheus.MustRegister(conflicting)
+	log, hook := logtest.NewNullLogger()
+	var mw func(http.Handler) http.Handler
+	require.NotPanics(t, func() { mw = NewPromMiddleware("conflict", log) })
+	require.Len(t, hook.AllEntries(), 1)
+	require.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+	w := httptest.NewRecorder()
+	mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusNoContent, w.Code, "requests are served without metrics")
+
+	// the uptime registered before the conflict is rolled back
+	ip, port := getExposedIPPort()
+	uptime := NewHTTPIn("conflict", ip, port).Uptime
+	require.NoError(t, prometheus.Register(uptime))
+	prometheus.Unregister(uptime)
+	prometheus.Unregister(conflicting)
+}
+
+func TestSampledTraceID(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		traceparent string
+		want        string
+	}{
+		{"", ""},
+		{"00-" + traceID + "-00f067aa0ba902b7-01", traceID},
+		{"00-" + traceID + "-00f067aa0ba902b7-03", traceID},
+		{"00-" + traceID + "-00f067aa0ba902b7-00", ""},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", ""},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01", ""},
+		{"00-" + traceID + "-01", ""},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, sampledTraceID(tt.traceparent), tt.traceparent)
+	}
+}
+
+func TestObserveWithTrace(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	hist := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_hist", Buckets: []float64{50, 100}})
+	registry.MustRegister(hist)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	observeWithTrace(hist, 70, r)
+	observeWithTrace(hist, 20, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	w := httptest.NewRecorder()
+	scrape := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	scrape.Header.Set("Accept", "application/openmetrics-text; version=0.0.1")
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true}).ServeHTTP(w, scrape)
+	require.Contains(t, w.Body.String(), `test_hist_bucket{le="100.0"} 2 # {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"} 70`)
+	require.Contains(t, w.Body.String(), `test_hist_bucket{le="50.0"} 1`+"\n")
+	require.Contains(t, w.Body.String(), "test_hist_count 2\n")
+}
+
+func TestSplitHostPort(t *testing.T) {
+	tests := []struct {
+		hostPort, host, port string
+	}{
+		{"192.0.2.1:1234", "192.0.2.1", "1234"},
+		{"192.0.2.0", "192.0.2.0", ""},
+		{"[2001:db8::1]:1234", "2001:db8::1", "1234"},
+		{"2001:db8:85a3::", "2001:db8:85a3::", ""},
+	}
+	for _, tt := range tests {
+		host, port := splitHostPort(tt.hostPort)
+		require.Equal(t, tt.host, host, tt.hostPort)
+		require.Equal(t, tt.port, port, tt.hostPort)
+	}
+}
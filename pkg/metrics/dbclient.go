@@ -12,6 +12,8 @@ type DBClient struct {
 	TimeTotal    *prometheus.CounterVec
 	BytesTotal   *prometheus.CounterVec
 	RecordsTotal *prometheus.CounterVec
+	// BreakerState is 0 while queries go through, 1 while they fail fast and 2 while probing recovery.
+	BreakerState prometheus.Gauge
 }
 
 type ConnectionStat interface{}
@@ -44,6 +46,11 @@ func NewDBClient(db, host, port string, fn func() float64) *DBClient {
 			Help:        "How many requests send to remote http service",
 			ConstLabels: constLabels,
 		}, []string{"db_query"}),
+		BreakerState: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "db_client_breaker_state",
+			Help:        "State of the query circuit breaker: 0 closed, 1 open, 2 half-open",
+			ConstLabels: constLabels,
+		}),
 	}
 }
 
@@ -57,6 +64,6 @@ func (c *DBClient) AutoRegister() *DBClient {
 }
 
 func (c *DBClient) mustRegister(registerer prometheus.Registerer) *DBClient {
-	registerer.MustRegister(c.Connections, c.ErrsTotal, c.TimeTotal, c.BytesTotal, c.RecordsTotal)
+	registerer.MustRegister(c.Connections, c.ErrsTotal, c.TimeTotal, c.BytesTotal, c.RecordsTotal, c.BreakerState)
 	return c
 }
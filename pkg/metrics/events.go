@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Events counts business events, they are unlabeled to keep cardinality low.
+type Events struct {
+	Likes      prometheus.Counter
+	SuperLikes prometheus.Counter
+	Dislikes   prometheus.Counter
+	Matches    prometheus.Counter
+	Messages   prometheus.Counter
+}
+
+func NewEvents() *Events {
+	return &Events{
+		Likes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "events_likes_total",
+			Help: "How many likes were given, not counting super likes",
+		}),
+		SuperLikes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "events_super_likes_total",
+			Help: "How many super likes were given",
+		}),
+		Dislikes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "events_dislikes_total",
+			Help: "How many dislikes were given",
+		}),
+		Matches: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "events_matches_total",
+			Help: "How many matches were formed by mutual likes",
+		}),
+		Messages: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "events_messages_total",
+			Help: "How many chat messages were sent and saved",
+		}),
+	}
+}
+
+var eventsOnce sync.Once
+
+func (e *Events) AutoRegister() *Events {
+	eventsOnce.Do(func() {
+		e.mustRegister(prometheus.DefaultRegisterer)
+	})
+	return e
+}
+
+func (e *Events) mustRegister(registerer prometheus.Registerer) {
+	registerer.MustRegister(e.Likes, e.SuperLikes, e.Dislikes, e.Matches, e.Messages)
+}
@@ -132,7 +132,25 @@ func (h *HTTPIn) AutoRegister() *HTTPIn {
 }
 
 func (h *HTTPIn) mustRegister(registerer prometheus.Registerer) {
-	registerer.MustRegister(
+	registerer.MustRegister(h.collectors()...)
+}
+
+// register registers all collectors or none of them.
+func (h *HTTPIn) register(registerer prometheus.Registerer) error {
+	collectors := h.collectors()
+	for i, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
+			for _, registered := range collectors[:i] {
+				registerer.Unregister(registered)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *HTTPIn) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
 		h.Uptime,
 		h.ReqTotal,
 		h.ReqBytesTotal,
@@ -142,5 +160,5 @@ func (h *HTTPIn) mustRegister(registerer prometheus.Registerer) {
 		h.RespErrorsTotal,
 		h.RespTimeHist,
 		h.RespTimeTotal,
-	)
+	}
 }
@@ -0,0 +1,93 @@
+package notify
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = (pongWait * 9) / 10
+	// maxAckSize is the size of the largest frame accepted from clients, which only send acks.
+	maxAckSize = 128
+	sendBuffer = 64
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// ack is a frame clients send when they have handled the event with the seq.
+type ack struct {
+	Ack int64 `json:"ack"`
+}
+
+// ServeWebsocket upgrades the request to a connection of the user receiving their events.
+// Pending events are sent on connect, frames other than acks are ignored.
+func (s *Server) ServeWebsocket(uuid string, w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	c := &client{send: make(chan []byte, sendBuffer)}
+	s.connect(uuid, c)
+	go writePump(conn, c)
+	s.readPump(uuid, conn, c)
+}
+
+func (s *Server) readPump(uuid string, conn *websocket.Conn, c *client) {
+	defer func() {
+		s.disconnect(uuid, c)
+		close(c.send)
+		conn.Close()
+	}()
+	conn.SetReadLimit(maxAckSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error { conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	for {
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("error: %v", err)
+			}
+			return
+		}
+		var a ack
+		if err = json.Unmarshal(frame, &a); err == nil && a.Ack > 0 {
+			s.Ack(uuid, a.Ack)
+		}
+	}
+}
+
+func writePump(conn *websocket.Conn, c *client) {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+	for {
+		select {
+		case frame, ok := <-c.send:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
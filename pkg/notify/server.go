@@ -0,0 +1,233 @@
+package notify
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTTL         = 24 * time.Hour
+	defaultMaxAttempts = 5
+	// maxPending caps unacked events kept per user, the oldest ones are dropped first.
+	maxPending = 100
+)
+
+// Event is a frame sent to connections of the recipient, who acks it with {"ack": <seq>}.
+// Seq is unique and increasing, so that clients ignore events they have already seen.
+type Event struct {
+	Seq  int64           `json:"seq"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Server delivers events to users at least once. An event stays pending until the recipient acks it
+// and is sent again whenever they connect, up to maxAttempts times within the ttl.
+// Pending events are kept in memory of the instance.
+type Server struct {
+	ttl         time.Duration
+	maxAttempts int
+	now         func() time.Time
+
+	mx      sync.Mutex
+	seq     int64
+	inboxes map[string]*inbox
+	// sweptAt is when inboxes of expired events were last dropped.
+	sweptAt time.Time
+}
+
+type Option func(*Server)
+
+// WithTTL sets how long an event is kept for redelivery, 24h by default.
+func WithTTL(ttl time.Duration) Option {
+	return func(s *Server) {
+		if ttl > 0 {
+			s.ttl = ttl
+		}
+	}
+}
+
+// WithMaxAttempts sets how many times an unacked event is sent, 5 by default.
+func WithMaxAttempts(attempts int) Option {
+	return func(s *Server) {
+		if attempts > 0 {
+			s.maxAttempts = attempts
+		}
+	}
+}
+
+// WithClock sets the source of the current time, time.Now by default.
+func WithClock(now func() time.Time) Option {
+	return func(s *Server) {
+		s.now = now
+	}
+}
+
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		ttl:         defaultTTL,
+		maxAttempts: defaultMaxAttempts,
+		now:         time.Now,
+		inboxes:     make(map[string]*inbox),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	// seqs keep increasing across restarts unless the clock goes back
+	s.seq = s.now().UnixMicro()
+	return s
+}
+
+type inbox struct {
+	pending []*pendingEvent
+	clients map[*client]bool
+}
+
+type pendingEvent struct {
+	seq       int64
+	frame     []byte
+	attempts  int
+	expiresAt time.Time
+}
+
+type client struct {
+	send chan []byte
+}
+
+// Send queues the event to the user, sends it to their open connections and returns its seq.
+func (s *Server) Send(uuid string, v interface{}) (int64, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	now := s.now()
+	s.forgetExpired(now)
+	s.seq++
+	e := &pendingEvent{seq: s.seq, expiresAt: now.Add(s.ttl)}
+	if e.frame, err = json.Marshal(Event{Seq: e.seq, Data: data}); err != nil {
+		return 0, err
+	}
+	in := s.inbox(uuid)
+	s.prune(in, now)
+	if len(in.pending) >= maxPending {
+		in.pending = in.pending[1:]
+	}
+	in.pending = append(in.pending, e)
+	if len(in.clients) > 0 {
+		e.attempts++
+		for c := range in.clients {
+			deliver(c, e)
+		}
+	}
+	return e.seq, nil
+}
+
+// Ack drops the user's event with the seq, so that it isn't sent again.
+func (s *Server) Ack(uuid string, seq int64) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	in, ok := s.inboxes[uuid]
+	if !ok {
+		return
+	}
+	for i, e := range in.pending {
+		if e.seq == seq {
+			in.pending = append(in.pending[:i], in.pending[i+1:]...)
+			break
+		}
+	}
+	s.forgetIdle(uuid, in)
+}
+
+// Pending returns the number of the user's unacked events which are still to be sent.
+func (s *Server) Pending(uuid string) int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	in, ok := s.inboxes[uuid]
+	if !ok {
+		return 0
+	}
+	s.prune(in, s.now())
+	return len(in.pending)
+}
+
+// connect registers a connection of the user and sends it their pending events, oldest first.
+func (s *Server) connect(uuid string, c *client) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	in := s.inbox(uuid)
+	in.clients[c] = true
+	s.prune(in, s.now())
+	for _, e := range in.pending {
+		e.attempts++
+		deliver(c, e)
+	}
+}
+
+// Subscribe registers a connection of the user served elsewhere, which gets event frames on the returned
+// channel, pending ones first. Unsubscribe unregisters it, the channel isn't closed.
+func (s *Server) Subscribe(uuid string) (frames <-chan []byte, unsubscribe func()) {
+	c := &client{send: make(chan []byte, sendBuffer)}
+	s.connect(uuid, c)
+	return c.send, func() { s.disconnect(uuid, c) }
+}
+
+// disconnect unregisters the connection, its send channel may be closed after that.
+func (s *Server) disconnect(uuid string, c *client) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	in, ok := s.inboxes[uuid]
+	if !ok {
+		return
+	}
+	delete(in.clients, c)
+	s.forgetIdle(uuid, in)
+}
+
+// deliver queues the event to the connection unless its buffer is full, the event is sent again
+// on the next connect anyway until it's acked.
+func deliver(c *client, e *pendingEvent) {
+	select {
+	case c.send <- e.frame:
+	default:
+	}
+}
+
+func (s *Server) inbox(uuid string) *inbox {
+	in, ok := s.inboxes[uuid]
+	if !ok {
+		in = &inbox{clients: make(map[*client]bool)}
+		s.inboxes[uuid] = in
+	}
+	return in
+}
+
+// prune drops expired events and those sent maxAttempts times.
+func (s *Server) prune(in *inbox, now time.Time) {
+	pending := in.pending[:0]
+	for _, e := range in.pending {
+		if now.Before(e.expiresAt) && e.attempts < s.maxAttempts {
+			pending = append(pending, e)
+		}
+	}
+	in.pending = pending
+}
+
+func (s *Server) forgetIdle(uuid string, in *inbox) {
+	if len(in.pending) == 0 && len(in.clients) == 0 {
+		delete(s.inboxes, uuid)
+	}
+}
+
+// forgetExpired prunes every inbox at most once a ttl, so that inboxes of users who don't come back are dropped.
+func (s *Server) forgetExpired(now time.Time) {
+	if now.Sub(s.sweptAt) < s.ttl {
+		return
+	}
+	s.sweptAt = now
+	for uuid, in := range s.inboxes {
+		s.prune(in, now)
+		s.forgetIdle(uuid, in)
+	}
+}
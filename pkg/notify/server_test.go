@@ -0,0 +1,109 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedeliveryUntilAcked(t *testing.T) {
+	server := NewServer()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.ServeWebsocket(r.URL.Query().Get("uuid"), w, r)
+	}))
+	defer ts.Close()
+	dial := func() *websocket.Conn {
+		conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"?uuid=first", nil)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return conn
+	}
+	read := func(conn *websocket.Conn) Event {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		_, b, err := conn.ReadMessage()
+		require.NoError(t, err)
+		var e Event
+		require.NoError(t, json.Unmarshal(b, &e))
+		return e
+	}
+
+	conn := dial()
+	require.Eventually(t, func() bool {
+		return server.connected("first") == 1
+	}, time.Second, 10*time.Millisecond)
+	seq, err := server.Send("first", map[string]string{"type": "matches"})
+	require.NoError(t, err)
+	e := read(conn)
+	require.Equal(t, seq, e.Seq)
+	require.JSONEq(t, `{"type": "matches"}`, string(e.Data))
+	require.NoError(t, conn.Close())
+
+	// the event wasn't acked, so it's sent again on reconnect
+	conn = dial()
+	e = read(conn)
+	require.Equal(t, seq, e.Seq)
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"ack": %d}`, seq))))
+	require.Eventually(t, func() bool {
+		return server.Pending("first") == 0
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, conn.Close())
+
+	conn = dial()
+	defer conn.Close()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, _, err = conn.ReadMessage()
+	var netErr interface{ Timeout() bool }
+	require.ErrorAs(t, err, &netErr, "acked events aren't sent again")
+	require.True(t, netErr.Timeout())
+}
+
+func TestRedeliveryLimits(t *testing.T) {
+	now := time.Now()
+	server := NewServer(WithMaxAttempts(2), WithTTL(time.Hour), WithClock(func() time.Time { return now }))
+	first, err := server.Send("first", "first")
+	require.NoError(t, err)
+	second, err := server.Send("first", "second")
+	require.NoError(t, err)
+	require.Greater(t, second, first)
+
+	connect := func() []int64 {
+		c := &client{send: make(chan []byte, sendBuffer)}
+		server.connect("first", c)
+		server.disconnect("first", c)
+		close(c.send)
+		var seqs []int64
+		for frame := range c.send {
+			var e Event
+			require.NoError(t, json.Unmarshal(frame, &e))
+			seqs = append(seqs, e.Seq)
+		}
+		return seqs
+	}
+	require.Equal(t, []int64{first, second}, connect())
+	server.Ack("first", second)
+	require.Equal(t, []int64{first}, connect())
+	require.Empty(t, connect(), "events are sent at most max attempts times")
+
+	_, err = server.Send("first", "third")
+	require.NoError(t, err)
+	now = now.Add(time.Hour)
+	require.Empty(t, connect(), "expired events aren't sent")
+	require.Zero(t, server.Pending("first"))
+	require.Empty(t, server.inboxes, "idle inboxes are dropped")
+}
+
+func (s *Server) connected(uuid string) int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if in, ok := s.inboxes[uuid]; ok {
+		return len(in.clients)
+	}
+	return 0
+}
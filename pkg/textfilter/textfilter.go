@@ -0,0 +1,67 @@
+package textfilter
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+type Verdict int
+
+const (
+	Clean Verdict = iota
+	// Flagged content is saved but the user is warned about it.
+	Flagged
+	Rejected
+)
+
+// Filter checks user provided free text.
+type Filter interface {
+	Check(text string) Verdict
+}
+
+// Nop accepts any text.
+type Nop struct{}
+
+func (Nop) Check(string) Verdict {
+	return Clean
+}
+
+// DefaultBannedWords is a minimal list of terms common in spam profiles.
+var DefaultBannedWords = []string{"viagra", "casino", "escort", "onlyfans"}
+
+var urlRe = regexp.MustCompile(`(?i)(https?://|www\.)\S+`)
+
+// Wordlist rejects texts containing banned words or more than one link and flags texts with a single link.
+type Wordlist struct {
+	banned map[string]bool
+}
+
+func NewWordlist(words []string) *Wordlist {
+	banned := make(map[string]bool, len(words))
+	for _, word := range words {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			banned[word] = true
+		}
+	}
+	return &Wordlist{banned: banned}
+}
+
+func (w *Wordlist) Check(text string) Verdict {
+	links := urlRe.FindAllString(text, -1)
+	if len(links) > 1 {
+		return Rejected
+	}
+	words := strings.FieldsFunc(strings.ToLower(urlRe.ReplaceAllString(text, " ")), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if w.banned[word] {
+			return Rejected
+		}
+	}
+	if len(links) == 1 {
+		return Flagged
+	}
+	return Clean
+}
@@ -0,0 +1,28 @@
+package textfilter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWordlist(t *testing.T) {
+	filter := NewWordlist(DefaultBannedWords)
+	tests := []struct {
+		text string
+		want Verdict
+	}{
+		{"", Clean},
+		{"Love hiking and quiet evenings", Clean},
+		{"Visit my page www.example.com", Flagged},
+		{"Best CASINO in town", Rejected},
+		{"casino-night", Rejected},
+		{"https://a.example.com https://a.example.com", Rejected},
+		{"casinos are boring", Clean},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, filter.Check(tt.text), tt.text)
+	}
+	require.Equal(t, Clean, Nop{}.Check("casino"))
+	require.Equal(t, Clean, NewWordlist(nil).Check("casino"))
+}